@@ -0,0 +1,164 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+
+	"raft3d/raft"
+)
+
+// EnableReload exposes POST /api/v1/admin/reload, which calls reload to
+// re-read the node's runtime configuration
+func (s *Server) EnableReload(reload func() (ReloadResult, error)) {
+	s.reload = reload
+}
+
+// handleReload handles POST /api/v1/admin/reload. Settings that only take
+// effect on restart are reported rather than applied.
+func (s *Server) handleReload(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		methodNotAllowed(w, r)
+		return
+	}
+	if !s.requireRole(w, r, RoleAdmin) {
+		return
+	}
+
+	result, err := s.reload()
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, CodeValidationFailed, "Reload failed, nothing was changed: "+err.Error())
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+// handleCompact handles POST /api/v1/admin/compact, which snapshots this
+// node's state and truncates its log. Compaction is local, so it can be run
+// on any node.
+func (s *Server) handleCompact(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		methodNotAllowed(w, r)
+		return
+	}
+
+	result, err := s.store.Compact()
+	if err != nil {
+		s.writeStoreError(w, r, err, "Failed to compact log")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+// handleRaftLog handles GET /api/v1/admin/raft/log?from=&to=, which describes
+// the entries in this node's log: their type, term, size and, for commands,
+// the operation and keys written, but never the values
+func (s *Server) handleRaftLog(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, r)
+		return
+	}
+	if !s.requireRole(w, r, RoleAdmin) {
+		return
+	}
+
+	var bounds [2]uint64
+	for i, name := range []string{"from", "to"} {
+		raw := r.URL.Query().Get(name)
+		if raw == "" {
+			continue
+		}
+		index, err := strconv.ParseUint(raw, 10, 64)
+		if err != nil {
+			writeValidationProblem(w, r, []FieldError{{Name: name, Reason: "must be a non-negative integer"}})
+			return
+		}
+		bounds[i] = index
+	}
+	if bounds[1] != 0 && bounds[0] > bounds[1] {
+		writeValidationProblem(w, r, []FieldError{{Name: "from", Reason: "must not be after to"}})
+		return
+	}
+
+	page, err := s.store.ReadLog(bounds[0], bounds[1])
+	if err != nil {
+		s.writeStoreError(w, r, err, "Failed to read the Raft log")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(page)
+}
+
+// handleRecovery handles GET /api/v1/admin/recovery, which reports how this
+// node rebuilt its state at startup: the snapshot restored, the entries
+// replayed on top of it, commands skipped as corrupt and the entities that
+// resulted. It is node-local; ask each node after a crash.
+func (s *Server) handleRecovery(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, r)
+		return
+	}
+	if !s.requireRole(w, r, RoleAdmin) {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.store.Recovery())
+}
+
+// handleQuarantine handles GET /api/v1/admin/quarantine, which lists the
+// commands that panicked while being applied and were skipped, oldest first.
+// The records are replicated, so every node lists the same ones.
+func (s *Server) handleQuarantine(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, r)
+		return
+	}
+	if !s.requireRole(w, r, RoleAdmin) {
+		return
+	}
+
+	records, err := loadAll[raft.QuarantinedCommand](s, raft.QuarantinePrefix)
+	if err != nil {
+		s.writeStoreError(w, r, err, "Failed to list quarantined commands")
+		return
+	}
+	list := make([]raft.QuarantinedCommand, 0, len(records))
+	for _, record := range records {
+		list = append(list, record)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Index < list[j].Index })
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(list)
+}
+
+// StateHashReport is a node's state hash, for comparing it with the others
+type StateHashReport struct {
+	NodeID   string `json:"node_id,omitempty"`
+	IsLeader bool   `json:"is_leader"`
+	raft.StateHash
+}
+
+// handleStateHash handles GET /api/v1/admin/state-hash, a hash of every key
+// and value this node holds and the applied index it reflects. Nodes at the
+// same index must report the same hash; add ?min_index= to wait for a node
+// to catch up to the others first.
+func (s *Server) handleStateHash(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, r)
+		return
+	}
+	if !s.requireRole(w, r, RoleAdmin) {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(StateHashReport{NodeID: s.NodeID, IsLeader: s.store.IsLeader(), StateHash: s.store.StateHash()})
+}
@@ -0,0 +1,44 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"raft3d/raft"
+	"raft3d/testsupport"
+)
+
+func TestRaftLog(t *testing.T) {
+	c := testsupport.NewCluster(t, 1)
+	leader := c.WaitForLeader(10 * time.Second)
+	s := NewServer("", leader.Store)
+	if err := leader.Store.Set("printer_p1", `{}`); err != nil {
+		t.Fatal(err)
+	}
+	index := leader.Store.AppliedIndex()
+
+	rec := httptest.NewRecorder()
+	s.handleRaftLog(rec, httptest.NewRequest(http.MethodGet, "/api/v1/admin/raft/log?from=1", nil))
+	var page raft.LogPage
+	if rec.Code != http.StatusOK || json.NewDecoder(rec.Body).Decode(&page) != nil || page.LastIndex < index {
+		t.Fatalf("log: %d %+v", rec.Code, page)
+	}
+	found := false
+	for _, entry := range page.Entries {
+		found = found || (entry.Index == index && entry.Op == "set" && entry.Keys[0] == "printer_p1")
+	}
+	if !found {
+		t.Fatalf("set of printer_p1 at %d not in %+v", index, page.Entries)
+	}
+
+	for _, url := range []string{"/api/v1/admin/raft/log?from=x", "/api/v1/admin/raft/log?from=5&to=2"} {
+		rec := httptest.NewRecorder()
+		s.handleRaftLog(rec, httptest.NewRequest(http.MethodGet, url, nil))
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: %d", url, rec.Code)
+		}
+	}
+}
@@ -0,0 +1,355 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"raft3d/raft"
+)
+
+// Key prefixes of alert rules and alerts
+const (
+	alertRuleKeyPrefix = "alertrule_"
+	alertKeyPrefix     = "alert_"
+)
+
+// Events published when alerts open and close
+const (
+	EventAlertRaised   = "alert.raised"
+	EventAlertResolved = "alert.resolved"
+)
+
+// Alert statuses
+const (
+	AlertOpen     = "open"
+	AlertResolved = "resolved"
+)
+
+// handleAlertRules handles GET/POST /alert_rules and GET/DELETE
+// /alert_rules/{id}. Changing rules requires the admin role.
+func (s *Server) handleAlertRules(w http.ResponseWriter, r *http.Request) {
+	id := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/v1/alert_rules"), "/")
+
+	switch {
+	case id == "" && r.Method == http.MethodGet:
+		rules, err := loadAll[AlertRule](s, alertRuleKeyPrefix)
+		if err != nil {
+			s.writeStoreError(w, r, err, "Failed to retrieve alert rules")
+			return
+		}
+		writeMap(w, r, rules)
+	case id == "" && r.Method == http.MethodPost:
+		if s.requireRole(w, r, RoleAdmin) {
+			s.handlePostAlertRule(w, r)
+		}
+	case id != "" && r.Method == http.MethodGet:
+		value, err := s.store.Get(alertRuleKeyPrefix + id)
+		if err != nil {
+			s.writeStoreError(w, r, err, "Alert rule not found")
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(value))
+	case id != "" && r.Method == http.MethodDelete:
+		if !s.requireRole(w, r, RoleAdmin) {
+			return
+		}
+		if _, err := s.store.Get(alertRuleKeyPrefix + id); err != nil {
+			s.writeStoreError(w, r, err, "Alert rule not found")
+			return
+		}
+		if err := s.storeFor(r).Delete(alertRuleKeyPrefix + id); err != nil {
+			s.writeStoreError(w, r, err, "Failed to delete alert rule")
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		methodNotAllowed(w, r)
+	}
+}
+
+// handlePostAlertRule stores an alert rule, replacing one with the same ID
+func (s *Server) handlePostAlertRule(w http.ResponseWriter, r *http.Request) {
+	var rule AlertRule
+	if !decodeJSON(w, r, &rule) {
+		return
+	}
+	if _, err := rule.duration(); err != nil {
+		writeValidationProblem(w, r, []FieldError{{Name: "for", Reason: "must be a non-negative duration such as 30s"}})
+		return
+	}
+	if rule.Condition == "unchanged" && rule.For == "" {
+		writeValidationProblem(w, r, []FieldError{{Name: "for", Reason: "is required for the unchanged condition"}})
+		return
+	}
+
+	body, err := json.Marshal(rule)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, CodeInternal, "Failed to process alert rule data")
+		return
+	}
+	if err := s.storeFor(r).Set(alertRuleKeyPrefix+rule.ID, string(body)); err != nil {
+		s.writeStoreError(w, r, err, "Failed to store alert rule")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	w.Write(body)
+}
+
+// handleAlerts handles GET /alerts, optionally filtered by ?status= and
+// ?printer_id=, and POST /alerts/{id}/resolve
+func (s *Server) handleAlerts(w http.ResponseWriter, r *http.Request) {
+	path := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/v1/alerts"), "/")
+
+	switch {
+	case path == "" && r.Method == http.MethodGet:
+		all, err := loadAll[Alert](s, alertKeyPrefix)
+		if err != nil {
+			s.writeStoreError(w, r, err, "Failed to retrieve alerts")
+			return
+		}
+		alerts := []Alert{}
+		for _, alert := range all {
+			if status := r.URL.Query().Get("status"); status != "" && alert.Status != status {
+				continue
+			}
+			if printer := r.URL.Query().Get("printer_id"); printer != "" && alert.PrinterID != printer {
+				continue
+			}
+			alerts = append(alerts, alert)
+		}
+		sort.Slice(alerts, func(i, j int) bool { return alerts[i].RaisedAt.After(alerts[j].RaisedAt) })
+		writeList(w, r, alerts)
+	case strings.HasSuffix(path, "/resolve") && r.Method == http.MethodPost:
+		alert, err := s.resolveAlert(strings.TrimSuffix(path, "/resolve"))
+		if err != nil {
+			s.writeStoreError(w, r, err, "Alert not found")
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(alert)
+	default:
+		methodNotAllowed(w, r)
+	}
+}
+
+// duration returns how long the rule's condition must hold
+func (rule AlertRule) duration() (time.Duration, error) {
+	if rule.For == "" {
+		return 0, nil
+	}
+	d, err := time.ParseDuration(rule.For)
+	if err == nil && d < 0 {
+		err = errors.New("negative duration")
+	}
+	return d, err
+}
+
+// metric returns a sample's value for the rule's metric, if it has one
+func (rule AlertRule) metric(sample TelemetrySample) (float64, bool) {
+	var value *float64
+	switch rule.Metric {
+	case "nozzle_temp_c":
+		value = sample.NozzleTempC
+	case "bed_temp_c":
+		value = sample.BedTempC
+	case "fan_speed_pct":
+		value = sample.FanSpeedPct
+	case "progress_pct":
+		value = sample.ProgressPct
+	}
+	if value == nil {
+		return 0, false
+	}
+	return *value, true
+}
+
+// firing evaluates a rule against a printer's samples, oldest first. The
+// condition must hold for every sample since it started and have held for
+// at least the rule's duration. It returns the latest value.
+func (rule AlertRule) firing(samples []TelemetrySample, now time.Time, running bool) (bool, float64) {
+	duration, err := rule.duration()
+	if err != nil || (rule.Condition == "unchanged" && !running) {
+		return false, 0
+	}
+
+	var latest float64
+	var since time.Time
+	found := false
+	for i := len(samples) - 1; i >= 0; i-- {
+		value, ok := rule.metric(samples[i])
+		if !ok {
+			continue
+		}
+		if !found {
+			latest, found = value, true
+		}
+		var holds bool
+		switch rule.Condition {
+		case "above":
+			holds = value > rule.Threshold
+		case "below":
+			holds = value < rule.Threshold
+		case "unchanged":
+			holds = value == latest
+		}
+		if !holds {
+			break
+		}
+		since = samples[i].RecordedAt
+	}
+	if since.IsZero() {
+		return false, latest
+	}
+	return now.Sub(since) >= duration, latest
+}
+
+// describe explains why a rule fired
+func (rule AlertRule) describe(printerID string, value float64) string {
+	name := rule.Name
+	if name == "" {
+		name = rule.ID
+	}
+	switch rule.Condition {
+	case "unchanged":
+		return fmt.Sprintf("%s: %s on printer %s has not changed from %g for %s while printing", name, rule.Metric, printerID, value, rule.For)
+	default:
+		msg := fmt.Sprintf("%s: %s on printer %s is %g, %s %g", name, rule.Metric, printerID, value, rule.Condition, rule.Threshold)
+		if rule.For != "" {
+			msg += " for " + rule.For
+		}
+		return msg
+	}
+}
+
+// evaluateAlerts checks every rule for a printer after new telemetry,
+// raising alerts whose rule fires and resolving those whose rule no longer
+// does. Only the leader evaluates, since it holds the telemetry.
+func (s *Server) evaluateAlerts(printerID string, now time.Time) {
+	rules, err := loadAll[AlertRule](s, alertRuleKeyPrefix)
+	if err != nil {
+		log.Printf("Alerts: failed to load rules: %s", err)
+		return
+	}
+
+	samples := s.telemetry.since(printerID, time.Time{})
+	var running *bool
+	for _, rule := range rules {
+		if rule.PrinterID != "" && rule.PrinterID != printerID {
+			continue
+		}
+		if rule.Condition == "unchanged" && running == nil {
+			active, err := s.printerRunning(printerID)
+			if err != nil {
+				log.Printf("Alerts: failed to check jobs of %s: %s", printerID, err)
+				return
+			}
+			running = &active
+		}
+
+		firing, value := rule.firing(samples, now, running != nil && *running)
+		if err := s.updateAlert(rule, printerID, firing, value, now); err != nil {
+			log.Printf("Alerts: failed to update alert %s for %s: %s", rule.ID, printerID, err)
+		}
+	}
+}
+
+// printerRunning reports whether a printer has a Running job
+func (s *Server) printerRunning(printerID string) (bool, error) {
+	jobs, err := s.listPrintJobs()
+	if err != nil {
+		return false, err
+	}
+	for _, job := range jobs {
+		if job.PrinterID == printerID && job.Status == "Running" {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// alertID names the alert of a rule for a printer
+func alertID(ruleID, printerID string) string {
+	return ruleID + ":" + printerID
+}
+
+// updateAlert opens or resolves the alert of a rule for a printer
+func (s *Server) updateAlert(rule AlertRule, printerID string, firing bool, value float64, now time.Time) error {
+	id := alertID(rule.ID, printerID)
+	var alert Alert
+	stored, err := s.store.Get(alertKeyPrefix + id)
+	switch {
+	case errors.Is(err, raft.ErrNotFound):
+		alert = Alert{ID: id, RuleID: rule.ID, PrinterID: printerID, Status: AlertResolved}
+	case err != nil:
+		return err
+	default:
+		if err := json.Unmarshal([]byte(stored), &alert); err != nil {
+			return err
+		}
+	}
+
+	switch {
+	case firing && alert.Status != AlertOpen:
+		alert.Status = AlertOpen
+		alert.Message = rule.describe(printerID, value)
+		alert.Value = value
+		alert.Count++
+		alert.RaisedAt = now
+		alert.ResolvedAt = nil
+		if err := s.storeAlert(alert); err != nil {
+			return err
+		}
+		log.Printf("Alert raised: %s", alert.Message)
+		s.publish(EventAlertRaised, alert)
+	case !firing && alert.Status == AlertOpen:
+		alert.Status = AlertResolved
+		alert.ResolvedAt = &now
+		if err := s.storeAlert(alert); err != nil {
+			return err
+		}
+		s.publish(EventAlertResolved, alert)
+	}
+	return nil
+}
+
+// resolveAlert closes an alert by hand, e.g. once an operator has acted on
+// it. It reopens if its rule fires again.
+func (s *Server) resolveAlert(id string) (Alert, error) {
+	var alert Alert
+	value, err := s.store.Get(alertKeyPrefix + id)
+	if err != nil {
+		return alert, err
+	}
+	if err := json.Unmarshal([]byte(value), &alert); err != nil {
+		return alert, err
+	}
+	if alert.Status == AlertResolved {
+		return alert, nil
+	}
+	now := time.Now().UTC()
+	alert.Status = AlertResolved
+	alert.ResolvedAt = &now
+	if err := s.storeAlert(alert); err != nil {
+		return alert, err
+	}
+	s.publish(EventAlertResolved, alert)
+	return alert, nil
+}
+
+// storeAlert writes an alert
+func (s *Server) storeAlert(alert Alert) error {
+	body, err := json.Marshal(alert)
+	if err != nil {
+		return err
+	}
+	return s.store.Set(alertKeyPrefix+alert.ID, string(body))
+}
@@ -0,0 +1,131 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"raft3d/testsupport"
+)
+
+func TestAlertRuleFiring(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	nozzle := func(values ...float64) []TelemetrySample {
+		var samples []TelemetrySample
+		for i := range values {
+			samples = append(samples, TelemetrySample{NozzleTempC: &values[i], RecordedAt: start.Add(time.Duration(i) * 10 * time.Second)})
+		}
+		return samples
+	}
+	now := start.Add(40 * time.Second)
+
+	tests := []struct {
+		name    string
+		rule    AlertRule
+		samples []TelemetrySample
+		running bool
+		want    bool
+	}{
+		{"above", AlertRule{Metric: "nozzle_temp_c", Condition: "above", Threshold: 250}, nozzle(200, 260), false, true},
+		{"not above", AlertRule{Metric: "nozzle_temp_c", Condition: "above", Threshold: 250}, nozzle(260, 200), false, false},
+		{"above for long enough", AlertRule{Metric: "nozzle_temp_c", Condition: "above", Threshold: 250, For: "20s"}, nozzle(200, 260, 270, 280), false, true},
+		{"above but not for long enough", AlertRule{Metric: "nozzle_temp_c", Condition: "above", Threshold: 250, For: "30s"}, nozzle(200, 200, 270, 280), false, false},
+		{"below", AlertRule{Metric: "nozzle_temp_c", Condition: "below", Threshold: 180}, nozzle(200, 150), false, true},
+		{"unchanged while printing", AlertRule{Metric: "nozzle_temp_c", Condition: "unchanged", For: "20s"}, nozzle(200, 210, 210, 210), true, true},
+		{"unchanged while idle", AlertRule{Metric: "nozzle_temp_c", Condition: "unchanged", For: "20s"}, nozzle(200, 210, 210, 210), false, false},
+		{"metric not reported", AlertRule{Metric: "bed_temp_c", Condition: "below", Threshold: 50}, nozzle(200), false, false},
+		{"no samples", AlertRule{Metric: "nozzle_temp_c", Condition: "below", Threshold: 50}, nil, false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got, _ := tt.rule.firing(tt.samples, now, tt.running); got != tt.want {
+				t.Fatalf("firing = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAlertsOpenAndResolveWithTelemetry(t *testing.T) {
+	c := testsupport.NewCluster(t, 1)
+	leader := c.WaitForLeader(10 * time.Second)
+	s := NewServer("", leader.Store)
+
+	post := func(body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		s.handleAlertRules(w, httptest.NewRequest(http.MethodPost, "/api/v1/alert_rules", strings.NewReader(body)))
+		return w
+	}
+	for _, body := range []string{
+		`{"id":"hot","metric":"nozzle_temp_c","condition":"above","threshold":250,"for":"soon"}`,
+		`{"id":"stuck","metric":"progress_pct","condition":"unchanged"}`,
+		`{"id":"hot","metric":"humidity","condition":"above","threshold":250}`,
+	} {
+		if w := post(body); w.Code != http.StatusBadRequest {
+			t.Fatalf("invalid rule %s: %d %s", body, w.Code, w.Body)
+		}
+	}
+	if w := post(`{"id":"hot","name":"Nozzle too hot","printer_id":"p1","metric":"nozzle_temp_c","condition":"above","threshold":250,"for":"20s"}`); w.Code != http.StatusCreated {
+		t.Fatalf("create rule: %d %s", w.Code, w.Body)
+	}
+
+	start := time.Now().UTC()
+	sample := func(at time.Duration, nozzle float64) {
+		s.telemetry.add("p1", TelemetrySample{NozzleTempC: &nozzle, RecordedAt: start.Add(at)})
+		s.evaluateAlerts("p1", start.Add(at))
+	}
+	alert := func() Alert {
+		var alert Alert
+		value, err := leader.Store.Get(alertKeyPrefix + alertID("hot", "p1"))
+		if err == nil {
+			err = json.Unmarshal([]byte(value), &alert)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		return alert
+	}
+
+	sample(0, 260)
+	if _, err := leader.Store.Get(alertKeyPrefix + alertID("hot", "p1")); err == nil {
+		t.Fatal("alert raised before the rule's duration")
+	}
+	sample(30*time.Second, 270)
+	if got := alert(); got.Status != AlertOpen || got.Value != 270 || got.Count != 1 || !strings.Contains(got.Message, "Nozzle too hot") {
+		t.Fatalf("alert after 30s above: %+v", got)
+	}
+	// Other printers' telemetry doesn't touch the rule's printer
+	s.telemetry.add("p2", TelemetrySample{NozzleTempC: new(float64), RecordedAt: start.Add(40 * time.Second)})
+	s.evaluateAlerts("p2", start.Add(40*time.Second))
+	if got := alert(); got.Status != AlertOpen {
+		t.Fatalf("alert after another printer's telemetry: %+v", got)
+	}
+
+	sample(50*time.Second, 200)
+	if got := alert(); got.Status != AlertResolved || got.ResolvedAt == nil {
+		t.Fatalf("alert after cooling down: %+v", got)
+	}
+	sample(60*time.Second, 280)
+	sample(90*time.Second, 280)
+	if got := alert(); got.Status != AlertOpen || got.Count != 2 {
+		t.Fatalf("alert after heating up again: %+v", got)
+	}
+
+	// Resolving by hand closes it until the rule fires again
+	w := httptest.NewRecorder()
+	s.handleAlerts(w, httptest.NewRequest(http.MethodPost, "/api/v1/alerts/hot:p1/resolve", nil))
+	if w.Code != http.StatusOK || alert().Status != AlertResolved {
+		t.Fatalf("resolve: %d %s", w.Code, w.Body)
+	}
+	w = httptest.NewRecorder()
+	s.handleAlerts(w, httptest.NewRequest(http.MethodGet, "/api/v1/alerts?status=open", nil))
+	if w.Code != http.StatusOK || strings.Contains(w.Body.String(), "hot:p1") {
+		t.Fatalf("open alerts after resolving: %d %s", w.Code, w.Body)
+	}
+	sample(100*time.Second, 290)
+	if got := alert(); got.Status != AlertOpen || got.Count != 3 {
+		t.Fatalf("alert after firing again: %+v", got)
+	}
+}
@@ -0,0 +1,92 @@
+package api
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// ParseAllowlist parses comma-separated CIDRs. Bare addresses allow that
+// single host.
+func ParseAllowlist(list string) ([]netip.Prefix, error) {
+	var prefixes []netip.Prefix
+	for _, entry := range strings.Split(list, ",") {
+		if entry = strings.TrimSpace(entry); entry == "" {
+			continue
+		}
+		if !strings.Contains(entry, "/") {
+			addr, err := netip.ParseAddr(entry)
+			if err != nil {
+				return nil, fmt.Errorf("invalid allowlist entry %q", entry)
+			}
+			prefixes = append(prefixes, netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()))
+			continue
+		}
+		prefix, err := netip.ParsePrefix(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid allowlist entry %q", entry)
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+	return prefixes, nil
+}
+
+// EnableManagementAllowlist restricts the cluster management endpoints to
+// callers from the given networks, whatever API key they hold. An empty list
+// lifts the restriction.
+func (s *Server) EnableManagementAllowlist(prefixes []netip.Prefix) {
+	s.allowMutex.Lock()
+	defer s.allowMutex.Unlock()
+
+	s.managementAllowlist = prefixes
+}
+
+// isManagementPath reports whether a path changes or inspects cluster
+// membership, injects faults or profiles the process. Plain GET /cluster
+// stays open for proxies discovering the leader.
+func isManagementPath(path string) bool {
+	return path == "/join" || path == "/leave" ||
+		strings.HasPrefix(path, "/cluster/") || strings.HasPrefix(path, "/debug/") ||
+		strings.HasPrefix(path, "/api/v1/cluster/") ||
+		path == "/api/v1/chaos" || strings.HasPrefix(path, "/api/v1/chaos/")
+}
+
+// managementAllowed reports whether the request's peer address is on the
+// allowlist. Forwarding headers are ignored since any client can set them.
+func (s *Server) managementAllowed(r *http.Request) bool {
+	s.allowMutex.RLock()
+	defer s.allowMutex.RUnlock()
+
+	if len(s.managementAllowlist) == 0 || viaUnixSocket(r) {
+		return true
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return false
+	}
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+	for _, prefix := range s.managementAllowlist {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// restrictManagement rejects cluster management requests from addresses
+// outside the allowlist before authentication is considered
+func (s *Server) restrictManagement(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if isManagementPath(r.URL.Path) && !s.managementAllowed(r) {
+			writeError(w, r, http.StatusForbidden, CodeForbidden, "Cluster management is not allowed from this address")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
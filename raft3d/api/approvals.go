@@ -0,0 +1,158 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"raft3d/raft"
+)
+
+// approvalPolicyKey holds the replicated approval policy
+const approvalPolicyKey = "approval_policy"
+
+// approvalRoute reports whether path is /api/v1/print_jobs/{id}/approve or
+// /api/v1/print_jobs/{id}/reject
+func approvalRoute(path string) (jobID, action string, ok bool) {
+	rest := strings.TrimPrefix(path, "/api/v1/print_jobs/")
+	jobID, action, found := strings.Cut(strings.TrimSuffix(rest, "/"), "/")
+	ok = found && jobID != "" && (action == "approve" || action == "reject")
+	return jobID, action, ok
+}
+
+// handleApprovalPolicy handles GET and PUT /api/v1/admin/approvals, which
+// sets the weight and cost beyond which jobs need approval
+func (s *Server) handleApprovalPolicy(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		policy, err := s.getApprovalPolicy()
+		if err != nil && !errors.Is(err, raft.ErrNotFound) {
+			s.writeStoreError(w, r, err, "Failed to retrieve approval policy")
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(policy)
+	case http.MethodPut:
+		if !s.requireRole(w, r, RoleAdmin) {
+			return
+		}
+		var policy ApprovalPolicy
+		if !decodeJSON(w, r, &policy) {
+			return
+		}
+		body, err := json.Marshal(policy)
+		if err != nil {
+			writeError(w, r, http.StatusInternalServerError, CodeInternal, "Failed to process approval policy")
+			return
+		}
+		if err := s.storeFor(r).Set(approvalPolicyKey, string(body)); err != nil {
+			s.writeStoreError(w, r, err, "Failed to store approval policy")
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(body)
+	default:
+		methodNotAllowed(w, r)
+	}
+}
+
+// getApprovalPolicy loads the approval policy. A missing policy is returned
+// as one without limits together with ErrNotFound.
+func (s *Server) getApprovalPolicy() (ApprovalPolicy, error) {
+	var policy ApprovalPolicy
+	value, err := s.store.Get(approvalPolicyKey)
+	if err != nil {
+		return policy, err
+	}
+	err = json.Unmarshal([]byte(value), &policy)
+	return policy, err
+}
+
+// approvalReason says why a new job needs approval, or "" if it doesn't.
+// The cost is estimated from the filament's cost_per_kg.
+func (s *Server) approvalReason(job PrintJob, filament Filament) (string, error) {
+	policy, err := s.getApprovalPolicy()
+	if errors.Is(err, raft.ErrNotFound) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	var reasons []string
+	if policy.MaxWeightInGrams > 0 && job.PrintWeightInGrams > policy.MaxWeightInGrams {
+		reasons = append(reasons, fmt.Sprintf("weight %g g exceeds %g g", job.PrintWeightInGrams, policy.MaxWeightInGrams))
+	}
+	cost := filament.MaterialCost(job.PrintWeightInGrams)
+	if policy.MaxCost > 0 && cost > policy.MaxCost {
+		reasons = append(reasons, fmt.Sprintf("estimated cost %g exceeds %g", cost, policy.MaxCost))
+	}
+	return strings.Join(reasons, "; "), nil
+}
+
+// handleJobApproval handles POST /print_jobs/{id}/approve, which queues a job
+// waiting for approval, and POST /print_jobs/{id}/reject, which cancels it.
+// Either needs the approver or admin role.
+func (s *Server) handleJobApproval(w http.ResponseWriter, r *http.Request, jobID, action string) {
+	if r.Method != http.MethodPost {
+		methodNotAllowed(w, r)
+		return
+	}
+	if !s.requireRole(w, r, RoleApprover, RoleAdmin) {
+		return
+	}
+
+	key := "printjob_" + jobID
+	value, err := s.store.Get(key)
+	if err != nil {
+		s.writeStoreError(w, r, err, "Print job not found")
+		return
+	}
+	var job PrintJob
+	if err := json.Unmarshal([]byte(value), &job); err != nil {
+		writeError(w, r, http.StatusInternalServerError, CodeInternal, "Failed to parse print job data")
+		return
+	}
+	if job.Status != "PendingApproval" {
+		writeError(w, r, http.StatusConflict, CodeInvalidTransition,
+			fmt.Sprintf("Only jobs in PendingApproval can be approved or rejected; this one is %s", job.Status))
+		return
+	}
+
+	now := time.Now().UTC()
+	approver := ""
+	if principal, ok := principalFrom(r); ok {
+		approver = principal.Name
+	}
+	if action == "approve" {
+		job.Status, job.ApprovedBy, job.ApprovedAt = "Queued", approver, &now
+	} else {
+		job.Status, job.CompletedAt = "Canceled", &now
+	}
+
+	body, err := json.Marshal(job)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, CodeInternal, "Failed to process print job data")
+		return
+	}
+	values := map[string]string{key: string(body)}
+	if err := s.notifyJobStatus(values, job); err != nil {
+		writeError(w, r, http.StatusInternalServerError, CodeInternal, "Failed to record notification")
+		return
+	}
+	// Two approvers acting at once: only the first decision is applied
+	condition := raft.Condition{Key: key, Field: "status", Equals: "PendingApproval"}
+	if err := s.storeFor(r).SetMany(values, condition); err != nil {
+		if errors.Is(err, raft.ErrConflict) {
+			writeError(w, r, http.StatusConflict, CodeInvalidTransition, "Print job changed state before it could be "+action+"d")
+			return
+		}
+		s.writeStoreError(w, r, err, "Failed to update print job data")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(body)
+}
@@ -0,0 +1,87 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"raft3d/testsupport"
+)
+
+func TestJobApproval(t *testing.T) {
+	c := testsupport.NewCluster(t, 1)
+	leader := c.WaitForLeader(10 * time.Second)
+	s := NewServer("", leader.Store)
+	s.EnableAuth([]APIKey{{Key: "x", Principal: Principal{Name: "root", Role: RoleAdmin}}})
+	for key, v := range map[string]interface{}{
+		"printer_p1":  Printer{ID: "p1", Name: "Prusa", Status: "Idle"},
+		"filament_f1": Filament{ID: "f1", Name: "PLA", Type: "PLA", TotalWeightInGrams: 1000, RemainingWeightInGrams: 1000, CostPerKg: 20},
+	} {
+		body, _ := json.Marshal(v)
+		if err := leader.Store.Set(key, string(body)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	do := func(role, method, url, body string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, url, strings.NewReader(body))
+		r = r.WithContext(context.WithValue(r.Context(), principalKey{}, Principal{Name: role + "-user", Role: role}))
+		rec := httptest.NewRecorder()
+		switch {
+		case strings.HasPrefix(url, "/api/v1/admin/"):
+			s.handleApprovalPolicy(rec, r)
+		case method == http.MethodPost && url == "/api/v1/print_jobs":
+			s.handlePostPrintJob(rec, r)
+		default:
+			s.handlePrintJobs(rec, r)
+		}
+		return rec
+	}
+	if rec := do(RoleAdmin, http.MethodPut, "/api/v1/admin/approvals", `{"max_weight_in_grams":500,"max_cost":5}`); rec.Code != http.StatusOK {
+		t.Fatalf("set policy: %d %s", rec.Code, rec.Body)
+	}
+
+	submit := func(grams string) PrintJob {
+		rec := do(RoleMember, http.MethodPost, "/api/v1/print_jobs", `{"printer_id":"p1","filament_id":"f1","filepath":"part.gcode","print_weight_in_grams":`+grams+`}`)
+		var job PrintJob
+		if rec.Code != http.StatusCreated || json.NewDecoder(rec.Body).Decode(&job) != nil {
+			t.Fatalf("submit %s g: %d %s", grams, rec.Code, rec.Body)
+		}
+		return job
+	}
+	if job := submit("100"); job.Status != "Queued" {
+		t.Fatalf("small job: %+v", job)
+	}
+	big := submit("300")
+	if big.Status != "PendingApproval" || big.ApprovalReason != "estimated cost 6 exceeds 5" {
+		t.Fatalf("expensive job: %+v", big)
+	}
+	// Jobs waiting for approval hold their filament
+	if rec := do(RoleMember, http.MethodPost, "/api/v1/print_jobs", `{"printer_id":"p1","filament_id":"f1","filepath":"x.gcode","print_weight_in_grams":700}`); rec.Code != http.StatusConflict {
+		t.Fatalf("over-allocation: %d %s", rec.Code, rec.Body)
+	}
+
+	if rec := do(RoleOperator, http.MethodPost, "/api/v1/print_jobs/"+big.ID+"/approve", ""); rec.Code != http.StatusForbidden {
+		t.Fatalf("operator approved: %d", rec.Code)
+	}
+	if rec := do(RoleOperator, http.MethodPost, "/api/v1/print_jobs/"+big.ID+"/status?status=Running", ""); rec.Code != http.StatusConflict {
+		t.Fatalf("started without approval: %d %s", rec.Code, rec.Body)
+	}
+	rec := do(RoleApprover, http.MethodPost, "/api/v1/print_jobs/"+big.ID+"/approve", "")
+	var approved PrintJob
+	if rec.Code != http.StatusOK || json.NewDecoder(rec.Body).Decode(&approved) != nil || approved.Status != "Queued" || approved.ApprovedBy != "approver-user" || approved.ApprovedAt == nil {
+		t.Fatalf("approve: %d %+v", rec.Code, approved)
+	}
+	if rec := do(RoleApprover, http.MethodPost, "/api/v1/print_jobs/"+big.ID+"/approve", ""); rec.Code != http.StatusConflict {
+		t.Fatalf("approved twice: %d", rec.Code)
+	}
+
+	rejected := submit("400")
+	if rec := do(RoleAdmin, http.MethodPost, "/api/v1/print_jobs/"+rejected.ID+"/reject", ""); rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"status":"Canceled"`) {
+		t.Fatalf("reject: %d %s", rec.Code, rec.Body)
+	}
+}
@@ -0,0 +1,259 @@
+package api
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"raft3d/raft"
+)
+
+// artifactMaxBytes caps the size of one artifact upload
+const artifactMaxBytes = 64 << 20
+
+// artifactKinds are the kinds of artifact a job can have
+var artifactKinds = map[string]bool{"photo": true, "log": true, "other": true}
+
+// EnableArtifacts sets where the content of job artifacts is stored. Only
+// their metadata is replicated, so every node should share the same store.
+func (s *Server) EnableArtifacts(store raft.BackupTarget) {
+	s.artifacts = store
+}
+
+// artifactRoute splits /api/v1/print_jobs/{id}/artifacts[/{artifact}]
+func artifactRoute(path string) (jobID, artifactID string, ok bool) {
+	rest := strings.TrimPrefix(path, "/api/v1/print_jobs/")
+	jobID, rest, found := strings.Cut(rest, "/")
+	if !found || jobID == "" {
+		return "", "", false
+	}
+	if rest == "artifacts" || rest == "artifacts/" {
+		return jobID, "", true
+	}
+	artifactID, ok = strings.CutPrefix(rest, "artifacts/")
+	return jobID, artifactID, ok && !strings.Contains(artifactID, "/")
+}
+
+// handleJobArtifacts handles GET and POST /print_jobs/{id}/artifacts and
+// GET /print_jobs/{id}/artifacts/{artifact}, which returns the content
+func (s *Server) handleJobArtifacts(w http.ResponseWriter, r *http.Request, jobID, artifactID string) {
+	switch {
+	case artifactID == "" && r.Method == http.MethodGet:
+		artifacts, err := s.listJobArtifacts(jobID)
+		if err != nil {
+			s.writeStoreError(w, r, err, "Failed to retrieve artifacts")
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(artifacts)
+	case artifactID == "" && r.Method == http.MethodPost:
+		s.handleUploadArtifacts(w, r, jobID)
+	case artifactID != "" && r.Method == http.MethodGet:
+		s.handleDownloadArtifact(w, r, jobID, artifactID)
+	default:
+		methodNotAllowed(w, r)
+	}
+}
+
+// handleUploadArtifacts stores every file of a multipart/form-data upload as
+// an artifact of a Done job. The optional "kind" field applies to all of
+// them. Content is written to the artifact store first, so metadata never
+// points at content that doesn't exist.
+func (s *Server) handleUploadArtifacts(w http.ResponseWriter, r *http.Request, jobID string) {
+	if s.artifacts == nil {
+		writeError(w, r, http.StatusConflict, CodeConflict, "No artifact store is configured on this node; start it with -artifact-store")
+		return
+	}
+	job, err := s.getPrintJob(jobID)
+	if err != nil {
+		s.writeStoreError(w, r, err, "Print job not found")
+		return
+	}
+	if job.Status != "Done" {
+		writeError(w, r, http.StatusConflict, CodeConflict,
+			fmt.Sprintf("Artifacts can only be attached to Done jobs; this one is %s", job.Status))
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, artifactMaxBytes)
+	reader, err := r.MultipartReader()
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, CodeMalformedRequest, "Expected a multipart/form-data upload")
+		return
+	}
+	kind := "other"
+	uploadedBy := ""
+	if principal, ok := principalFrom(r); ok {
+		uploadedBy = principal.Name
+	}
+
+	var stored []JobArtifact
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			writeError(w, r, http.StatusBadRequest, CodeMalformedRequest, "Malformed or oversized multipart upload")
+			return
+		}
+		if part.FormName() == "kind" {
+			value, _ := io.ReadAll(io.LimitReader(part, 64))
+			kind = strings.TrimSpace(string(value))
+			if !artifactKinds[kind] {
+				writeValidationProblem(w, r, []FieldError{{Name: "kind", Reason: "must be one of photo, log or other"}})
+				return
+			}
+			continue
+		}
+		if part.FileName() == "" {
+			continue
+		}
+
+		content, err := io.ReadAll(part)
+		if err != nil {
+			writeError(w, r, http.StatusRequestEntityTooLarge, CodeValidationFailed,
+				fmt.Sprintf("Uploads are limited to %d MiB", artifactMaxBytes>>20))
+			return
+		}
+		artifact, err := s.storeArtifact(r, job, JobArtifact{
+			Name:        filepath.Base(part.FileName()),
+			Kind:        kind,
+			ContentType: part.Header.Get("Content-Type"),
+			UploadedBy:  uploadedBy,
+		}, content)
+		if err != nil {
+			s.writeStoreError(w, r, err, "Failed to store artifact")
+			return
+		}
+		stored = append(stored, artifact)
+	}
+	if len(stored) == 0 {
+		writeValidationProblem(w, r, []FieldError{{Name: "file", Reason: "is required"}})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(stored)
+}
+
+// storeArtifact writes an artifact's content, named after its checksum, and
+// then its metadata, on condition the job is still Done
+func (s *Server) storeArtifact(r *http.Request, job PrintJob, artifact JobArtifact, content []byte) (JobArtifact, error) {
+	sum := sha256.Sum256(content)
+	artifact.JobID = job.ID
+	artifact.SHA256 = hex.EncodeToString(sum[:])
+	artifact.Size = int64(len(content))
+	artifact.UploadedAt = time.Now().UTC()
+	if artifact.ContentType == "" || artifact.ContentType == "application/octet-stream" {
+		if byExt := mime.TypeByExtension(filepath.Ext(artifact.Name)); byExt != "" {
+			artifact.ContentType = byExt
+		} else {
+			artifact.ContentType = http.DetectContentType(content)
+		}
+	}
+
+	if err := s.artifacts.Write(artifactObject(artifact), bytes.NewReader(content)); err != nil {
+		return artifact, fmt.Errorf("writing artifact content: %w", err)
+	}
+	body, err := json.Marshal(artifact)
+	if err != nil {
+		return artifact, err
+	}
+	value, err := s.storeFor(r).CreateAndSet(artifactPrefix(job.ID), "", string(body), nil,
+		raft.Condition{Key: "printjob_" + job.ID, Field: "status", Equals: "Done"})
+	if err != nil {
+		return artifact, err
+	}
+	err = json.Unmarshal([]byte(value), &artifact)
+	return artifact, err
+}
+
+// handleDownloadArtifact returns an artifact's content
+func (s *Server) handleDownloadArtifact(w http.ResponseWriter, r *http.Request, jobID, artifactID string) {
+	value, err := s.store.Get(artifactPrefix(jobID) + artifactID)
+	if err != nil {
+		s.writeStoreError(w, r, err, "Artifact not found")
+		return
+	}
+	var artifact JobArtifact
+	if err := json.Unmarshal([]byte(value), &artifact); err != nil {
+		writeError(w, r, http.StatusInternalServerError, CodeInternal, "Failed to parse artifact data")
+		return
+	}
+	if s.artifacts == nil {
+		writeError(w, r, http.StatusConflict, CodeConflict, "No artifact store is configured on this node; start it with -artifact-store")
+		return
+	}
+
+	content, err := s.artifacts.Open(artifactObject(artifact))
+	if errors.Is(err, fs.ErrNotExist) {
+		writeError(w, r, http.StatusNotFound, CodeNotFound, "The artifact's content is not in this node's artifact store")
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to open artifact %s of print job %s: %s", artifactID, jobID, err)
+		writeError(w, r, http.StatusInternalServerError, CodeInternal, "Failed to read artifact")
+		return
+	}
+	defer content.Close()
+
+	w.Header().Set("Content-Type", artifact.ContentType)
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": artifact.Name}))
+	io.Copy(w, content)
+}
+
+// listJobArtifacts returns a job's artifacts, oldest first
+func (s *Server) listJobArtifacts(jobID string) ([]JobArtifact, error) {
+	if _, err := s.getPrintJob(jobID); err != nil {
+		return nil, err
+	}
+	keys, err := s.store.List(artifactPrefix(jobID))
+	if err != nil {
+		return nil, err
+	}
+	artifacts := []JobArtifact{}
+	for _, key := range keys {
+		value, err := s.store.Get(key)
+		if err != nil {
+			continue
+		}
+		var artifact JobArtifact
+		if err := json.Unmarshal([]byte(value), &artifact); err != nil {
+			continue
+		}
+		artifacts = append(artifacts, artifact)
+	}
+	sort.Slice(artifacts, func(i, j int) bool {
+		if !artifacts[i].UploadedAt.Equal(artifacts[j].UploadedAt) {
+			return artifacts[i].UploadedAt.Before(artifacts[j].UploadedAt)
+		}
+		return artifacts[i].ID < artifacts[j].ID
+	})
+	return artifacts, nil
+}
+
+// artifactPrefix is the key prefix of a job's artifact records. The slash
+// keeps one job's artifacts apart from those of a job whose ID it prefixes.
+func artifactPrefix(jobID string) string {
+	return "artifact_" + jobID + "/"
+}
+
+// artifactObject is the name an artifact's content is stored under.
+// Identical uploads to a job share it.
+func artifactObject(artifact JobArtifact) string {
+	return artifact.JobID + "-" + artifact.SHA256
+}
@@ -0,0 +1,89 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"raft3d/raft"
+	"raft3d/testsupport"
+)
+
+// uploadArtifact posts content as a multipart upload of the given kind
+func uploadArtifact(s *Server, jobID, kind, name, content string) *httptest.ResponseRecorder {
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	form.WriteField("kind", kind)
+	file, _ := form.CreateFormFile("file", name)
+	io.WriteString(file, content)
+	form.Close()
+
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/print_jobs/"+jobID+"/artifacts", &body)
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	rec := httptest.NewRecorder()
+	s.handlePrintJobs(rec, req)
+	return rec
+}
+
+func TestJobArtifacts(t *testing.T) {
+	c := testsupport.NewCluster(t, 1)
+	leader := c.WaitForLeader(10 * time.Second)
+	for id, status := range map[string]string{"done": "Done", "queued": "Queued"} {
+		body, _ := json.Marshal(PrintJob{ID: id, PrinterID: "p1", FilamentID: "f1", FilePath: "part.gcode", Status: status})
+		leader.Store.Set("printjob_"+id, string(body))
+	}
+
+	s := NewServer("", leader.Store)
+	if rec := uploadArtifact(s, "done", "log", "print.log", "ok"); rec.Code != http.StatusConflict {
+		t.Fatalf("upload without an artifact store: %d %s", rec.Code, rec.Body)
+	}
+	store, err := raft.NewDirBackupTarget(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.EnableArtifacts(store)
+
+	if rec := uploadArtifact(s, "queued", "log", "print.log", "ok"); rec.Code != http.StatusConflict {
+		t.Fatalf("upload to a queued job: %d %s", rec.Code, rec.Body)
+	}
+	if rec := uploadArtifact(s, "done", "video", "print.log", "ok"); rec.Code != http.StatusBadRequest {
+		t.Fatalf("upload of an unknown kind: %d %s", rec.Code, rec.Body)
+	}
+	rec := uploadArtifact(s, "done", "log", "print.log", "layer 1 done\n")
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("upload: %d %s", rec.Code, rec.Body)
+	}
+	var created []JobArtifact
+	json.NewDecoder(rec.Body).Decode(&created)
+	if len(created) != 1 || created[0].ID == "" || created[0].Kind != "log" || created[0].Size != 13 {
+		t.Fatalf("unexpected artifacts: %+v", created)
+	}
+
+	rec = httptest.NewRecorder()
+	s.handlePrintJobs(rec, httptest.NewRequest(http.MethodGet, "/api/v1/print_jobs/done/artifacts", nil))
+	var listed []JobArtifact
+	json.NewDecoder(rec.Body).Decode(&listed)
+	if rec.Code != http.StatusOK || len(listed) != 1 || listed[0].ID != created[0].ID {
+		t.Fatalf("list: %d %+v", rec.Code, listed)
+	}
+
+	rec = httptest.NewRecorder()
+	s.handlePrintJobs(rec, httptest.NewRequest(http.MethodGet, "/api/v1/print_jobs/done/artifacts/"+created[0].ID, nil))
+	if rec.Code != http.StatusOK || rec.Body.String() != "layer 1 done\n" {
+		t.Fatalf("download: %d %q", rec.Code, rec.Body)
+	}
+	if disposition := rec.Header().Get("Content-Disposition"); disposition != `attachment; filename=print.log` {
+		t.Errorf("Content-Disposition = %q", disposition)
+	}
+
+	rec = httptest.NewRecorder()
+	s.handlePrintJobs(rec, httptest.NewRequest(http.MethodGet, "/api/v1/print_jobs/queued/artifacts", nil))
+	if rec.Code != http.StatusOK || rec.Body.String() != "[]\n" {
+		t.Fatalf("list of a job without artifacts: %d %s", rec.Code, rec.Body)
+	}
+}
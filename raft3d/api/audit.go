@@ -0,0 +1,81 @@
+package api
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"time"
+)
+
+// auditKeyPrefix prefixes audit entries in the store
+const auditKeyPrefix = "audit_"
+
+// Audited actions
+const (
+	AuditDryingOverridden = "filament.drying_overridden"
+)
+
+// AuditEntry records a decision someone made that bypassed a safety check
+type AuditEntry struct {
+	ID      string    `json:"id"`
+	Action  string    `json:"action"`
+	Actor   string    `json:"actor,omitempty"`
+	Subject string    `json:"subject"`
+	Detail  string    `json:"detail,omitempty"`
+	At      time.Time `json:"at"`
+}
+
+// addAuditEntry adds an audit entry to values, so it is written in the same
+// log entry as the change it records and can't be lost if that succeeds
+func addAuditEntry(values map[string]string, action, actor, subject, detail string) error {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return err
+	}
+	entry := AuditEntry{
+		ID:      hex.EncodeToString(b[:]),
+		Action:  action,
+		Actor:   actor,
+		Subject: subject,
+		Detail:  detail,
+		At:      time.Now().UTC(),
+	}
+	body, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	values[auditKeyPrefix+entry.ID] = string(body)
+	return nil
+}
+
+// handleAudit handles GET /audit, newest entries first, optionally filtered
+// by ?action= and ?subject=. Only admins can read the audit log.
+func (s *Server) handleAudit(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, r)
+		return
+	}
+	if !s.requireRole(w, r, RoleAdmin) {
+		return
+	}
+
+	all, err := loadAll[AuditEntry](s, auditKeyPrefix)
+	if err != nil {
+		s.writeStoreError(w, r, err, "Failed to retrieve audit log")
+		return
+	}
+	entries := []AuditEntry{}
+	for _, entry := range all {
+		if action := r.URL.Query().Get("action"); action != "" && entry.Action != action {
+			continue
+		}
+		if subject := r.URL.Query().Get("subject"); subject != "" && entry.Subject != subject {
+			continue
+		}
+		entries = append(entries, entry)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].At.After(entries[j].At) })
+	writeList(w, r, entries)
+}
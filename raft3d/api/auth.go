@@ -0,0 +1,171 @@
+package api
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"raft3d/raft"
+	"raft3d/secrets"
+)
+
+// Roles a principal can hold
+const (
+	RoleAdmin    = "admin"
+	RoleOperator = "operator"
+	RoleApprover = "approver" // an operator who may also approve large jobs
+	RoleMember   = "member"
+)
+
+// Principal is the authenticated caller of a request
+type Principal struct {
+	Name string `json:"name" validate:"required"`
+	Role string `json:"role" validate:"required,oneof=admin operator approver member"`
+}
+
+// APIKey maps a secret key to the principal it authenticates
+type APIKey struct {
+	Key string `json:"key" validate:"required"`
+	Principal
+}
+
+// principalKey is the context key holding the request's principal
+type principalKey struct{}
+
+// LoadAPIKeys reads a JSON array of API keys from a file or Vault secret
+// reference, as understood by secrets.Read
+func LoadAPIKeys(path string) ([]APIKey, error) {
+	data, err := secrets.Read(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var keys []APIKey
+	if err := json.Unmarshal(data, &keys); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("%s: no API keys, refusing to start without authentication", path)
+	}
+	for i, key := range keys {
+		if errs := Validate(key); len(errs) > 0 {
+			return nil, fmt.Errorf("%s: key %d: %s %s", path, i, errs[0].Name, errs[0].Reason)
+		}
+	}
+	return keys, nil
+}
+
+// EnableAuth requires every /api/ request to carry one of the given keys, as
+// "Authorization: Bearer <key>" or "X-API-Key: <key>". An empty key set is
+// refused rather than read as "no authentication".
+func (s *Server) EnableAuth(keys []APIKey) error {
+	if len(keys) == 0 {
+		return errors.New("no API keys given")
+	}
+	s.authMutex.Lock()
+	defer s.authMutex.Unlock()
+
+	s.authRequired = true
+	s.apiKeys = keys
+	return nil
+}
+
+// DisableAuth turns authentication off, leaving every endpoint open
+func (s *Server) DisableAuth() {
+	s.authMutex.Lock()
+	defer s.authMutex.Unlock()
+
+	s.authRequired = false
+	s.apiKeys = nil
+}
+
+// authEnabled reports whether API keys are required
+func (s *Server) authEnabled() bool {
+	s.authMutex.RLock()
+	defer s.authMutex.RUnlock()
+
+	return s.authRequired
+}
+
+// authenticate resolves the caller's principal and rejects API requests
+// without a valid key. Cluster-internal and probe endpoints stay open.
+func (s *Server) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !s.authEnabled() || !strings.HasPrefix(r.URL.Path, "/api/") {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		principal, ok := s.lookupKey(requestKey(r))
+		if !ok {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="raft3d"`)
+			writeError(w, r, http.StatusUnauthorized, CodeUnauthorized, "A valid API key is required")
+			return
+		}
+		// Writes through storeFor are attributed to the principal
+		ctx := raft.WithActor(context.WithValue(r.Context(), principalKey{}, principal), principal.Name)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// requestKey returns the API key a request carries, if any: a bearer token,
+// X-API-Key, or the password of basic auth
+func requestKey(r *http.Request) string {
+	if bearer, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+		return bearer
+	}
+	if key := r.Header.Get("X-API-Key"); key != "" {
+		return key
+	}
+	// Calendar apps subscribing to a feed can usually only send basic auth
+	if _, password, ok := r.BasicAuth(); ok {
+		return password
+	}
+	return ""
+}
+
+// requireClusterMember rejects membership changes from callers that can't
+// prove they belong to the cluster. With authentication or TLS on, the caller
+// needs an admin API key or a client certificate from the cluster's CA; these
+// endpoints sit outside /api/, so authenticate doesn't cover them.
+func (s *Server) requireClusterMember(w http.ResponseWriter, r *http.Request) bool {
+	if !s.authEnabled() && s.tls == nil {
+		return true
+	}
+	if r.TLS != nil && len(r.TLS.VerifiedChains) > 0 {
+		return true
+	}
+	if principal, ok := s.lookupKey(requestKey(r)); ok && principal.Role == RoleAdmin {
+		return true
+	}
+	w.Header().Set("WWW-Authenticate", `Bearer realm="raft3d"`)
+	writeError(w, r, http.StatusUnauthorized, CodeUnauthorized,
+		"Cluster membership changes require an admin API key or a client certificate from the cluster CA")
+	return false
+}
+
+// lookupKey finds the principal for a key in constant time per key
+func (s *Server) lookupKey(key string) (Principal, bool) {
+	if key == "" {
+		return Principal{}, false
+	}
+	s.authMutex.RLock()
+	defer s.authMutex.RUnlock()
+
+	for _, k := range s.apiKeys {
+		if subtle.ConstantTimeCompare([]byte(k.Key), []byte(key)) == 1 {
+			return k.Principal, true
+		}
+	}
+	return Principal{}, false
+}
+
+// principalFrom returns the authenticated principal of a request, if any
+func principalFrom(r *http.Request) (Principal, bool) {
+	principal, ok := r.Context().Value(principalKey{}).(Principal)
+	return principal, ok
+}
@@ -0,0 +1,52 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadAPIKeysRejectsEmptyKeySets(t *testing.T) {
+	for _, content := range []string{"null", "[]"} {
+		path := filepath.Join(t.TempDir(), "keys.json")
+		os.WriteFile(path, []byte(content), 0o600)
+		if keys, err := LoadAPIKeys(path); err == nil {
+			t.Errorf("LoadAPIKeys(%s) = %v, want an error", content, keys)
+		}
+	}
+}
+
+func TestEmptyKeySetDoesNotDisableAuth(t *testing.T) {
+	s := NewServer("", nil)
+	if err := s.EnableAuth([]APIKey{{Key: "k1", Principal: Principal{Name: "ops", Role: RoleAdmin}}}); err != nil {
+		t.Fatal(err)
+	}
+	for _, keys := range [][]APIKey{nil, {}} {
+		if err := s.EnableAuth(keys); err == nil {
+			t.Errorf("EnableAuth(%v) succeeded", keys)
+		}
+	}
+
+	handler := s.authenticate(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/v1/printers", nil))
+	if w.Code != http.StatusUnauthorized {
+		t.Fatalf("request without a key got %d, want 401", w.Code)
+	}
+	r := httptest.NewRequest(http.MethodGet, "/api/v1/printers", nil)
+	r.Header.Set("X-API-Key", "k1")
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("request with the old key got %d, want 200", w.Code)
+	}
+
+	s.DisableAuth()
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/v1/printers", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("request after DisableAuth got %d, want 200", w.Code)
+	}
+}
@@ -0,0 +1,37 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// handleBackups handles GET /api/v1/backups (list) and POST (take a backup now)
+func (s *Server) handleBackups(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		backups, err := s.store.Backups()
+		if err != nil {
+			s.writeStoreError(w, r, err, "Failed to list backups")
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(backups)
+	case http.MethodPost:
+		if !s.store.IsLeader() {
+			s.writeNotLeader(w, r)
+			return
+		}
+		info, err := s.store.WriteBackup()
+		if err != nil {
+			s.writeStoreError(w, r, err, "Failed to write backup")
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(info)
+	default:
+		methodNotAllowed(w, r)
+	}
+}
@@ -0,0 +1,128 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"raft3d/raft"
+)
+
+// maxBulkStatusUpdates bounds the size of one bulk status update, and so of
+// the Raft entry it becomes
+const maxBulkStatusUpdates = 500
+
+// handleBulkStatusUpdate handles POST /print_jobs/status. Every change is
+// checked first and then all are applied as one Raft entry: either every job
+// changes status or none does.
+func (s *Server) handleBulkStatusUpdate(w http.ResponseWriter, r *http.Request) {
+	var bulk BulkStatusUpdate
+	if !decodeJSON(w, r, &bulk) {
+		return
+	}
+	if len(bulk.Updates) == 0 {
+		writeValidationProblem(w, r, []FieldError{{Name: "updates", Reason: "is required"}})
+		return
+	}
+	if len(bulk.Updates) > maxBulkStatusUpdates {
+		writeValidationProblem(w, r, []FieldError{{
+			Name:   "updates",
+			Reason: fmt.Sprintf("must not contain more than %d entries", maxBulkStatusUpdates),
+		}})
+		return
+	}
+
+	var errs []FieldError
+	seen := make(map[string]bool)
+	for i, update := range bulk.Updates {
+		for _, e := range Validate(update) {
+			errs = append(errs, FieldError{Name: fmt.Sprintf("updates[%d].%s", i, e.Name), Reason: e.Reason})
+		}
+		if seen[update.ID] {
+			errs = append(errs, FieldError{Name: fmt.Sprintf("updates[%d].id", i), Reason: "job is listed twice"})
+		}
+		seen[update.ID] = true
+	}
+	if len(errs) > 0 {
+		writeValidationProblem(w, r, errs)
+		return
+	}
+
+	changes := make([]statusChange, 0, len(bulk.Updates))
+	results := make([]BulkStatusResult, 0, len(bulk.Updates))
+	for i, update := range bulk.Updates {
+		printJob, err := s.getPrintJob(update.ID)
+		if err != nil {
+			if errors.Is(err, raft.ErrNotFound) {
+				writeValidationProblem(w, r, []FieldError{{
+					Name:   fmt.Sprintf("updates[%d].id", i),
+					Reason: fmt.Sprintf("print job %s does not exist", update.ID),
+				}})
+				return
+			}
+			s.writeStoreError(w, r, err, "Failed to retrieve print job")
+			return
+		}
+
+		if err := ValidatePrintJobStatusTransition(printJob.Status, update.Status); err != nil {
+			writeError(w, r, http.StatusConflict, CodeInvalidTransition, fmt.Sprintf("Print job %s: %s", update.ID, err))
+			return
+		}
+
+		change := statusChange{from: printJob.Status}
+		if update.Status == "Running" && len(printJob.DependsOn) > 0 {
+			pending, err := s.pendingDependencies(printJob)
+			if err != nil {
+				s.writeStoreError(w, r, err, "Failed to check dependencies")
+				return
+			}
+			if len(pending) > 0 {
+				writeError(w, r, http.StatusConflict, CodeDependenciesPending,
+					fmt.Sprintf("Print job %s depends on jobs that are not Done: %s", update.ID, strings.Join(pending, ", ")))
+				return
+			}
+			change.conditions = dependencyConditions(printJob)
+		}
+
+		if update.Status == "Running" {
+			problem, err := s.printerOfflineProblem(printJob)
+			if err != nil {
+				s.writeStoreError(w, r, err, "Failed to check printer")
+				return
+			}
+			if problem != nil {
+				problem.Detail = fmt.Sprintf("Print job %s: %s", update.ID, problem.Detail)
+				writeProblem(w, r, *problem)
+				return
+			}
+		}
+
+		printJob.Status = update.Status
+		if update.Status == "Running" {
+			startedAt := time.Now().UTC()
+			printJob.StartedAt = &startedAt
+		}
+		printJob.syncPrintStep(time.Now().UTC())
+		change.job = printJob
+		changes = append(changes, change)
+		results = append(results, BulkStatusResult{ID: update.ID, From: change.from, To: update.Status})
+	}
+
+	if err := s.commitStatusChanges(r.Context(), changes); err != nil {
+		switch {
+		case errors.Is(err, errJobChanged):
+			writeError(w, r, http.StatusConflict, CodeInvalidTransition, err.Error())
+		case errors.Is(err, raft.ErrNotFound):
+			writeError(w, r, http.StatusInternalServerError, CodeInternal, "Filament referenced by print job not found")
+		default:
+			s.writeStoreError(w, r, err, "Failed to update print job statuses")
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"updated": results})
+}
@@ -0,0 +1,75 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"raft3d/testsupport"
+)
+
+func TestBulkStatusUpdatesAreAllOrNothing(t *testing.T) {
+	c := testsupport.NewCluster(t, 1)
+	leader := c.WaitForLeader(10 * time.Second)
+	s := NewServer("", leader.Store)
+
+	put := func(key string, v interface{}) {
+		body, _ := json.Marshal(v)
+		if err := leader.Store.Set(key, string(body)); err != nil {
+			t.Fatalf("set %s: %s", key, err)
+		}
+	}
+	put("printer_p1", Printer{ID: "p1", Name: "Prusa", Status: "Idle"})
+	put("filament_f1", Filament{ID: "f1", Name: "PLA", Type: "PLA", TotalWeightInGrams: 1000, RemainingWeightInGrams: 1000})
+	for id, status := range map[string]string{"a": "Queued", "b": "Queued", "done": "Done"} {
+		put("printjob_"+id, PrintJob{ID: id, PrinterID: "p1", FilamentID: "f1", FilePath: id + ".gcode", PrintWeightInGrams: 10, Status: status})
+	}
+
+	bulk := func(body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		s.handleBulkStatusUpdate(w, httptest.NewRequest(http.MethodPost, "/api/v1/print_jobs/status", strings.NewReader(body)))
+		return w
+	}
+	statuses := func() string {
+		var got []string
+		for _, id := range []string{"a", "b", "done"} {
+			job, err := s.getPrintJob(id)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got = append(got, job.Status)
+		}
+		return strings.Join(got, ",")
+	}
+
+	// One invalid transition rejects the whole update
+	if w := bulk(`{"updates":[{"id":"a","status":"Running"},{"id":"done","status":"Running"}]}`); w.Code != http.StatusConflict {
+		t.Fatalf("update with an invalid transition: %d %s", w.Code, w.Body)
+	}
+	if w := bulk(`{"updates":[{"id":"a","status":"Running"},{"id":"a","status":"Canceled"}]}`); w.Code != http.StatusBadRequest {
+		t.Fatalf("update listing a job twice: %d %s", w.Code, w.Body)
+	}
+	if w := bulk(`{"updates":[{"id":"a","status":"Running"},{"id":"missing","status":"Running"}]}`); w.Code != http.StatusBadRequest {
+		t.Fatalf("update of a missing job: %d %s", w.Code, w.Body)
+	}
+	if got := statuses(); got != "Queued,Queued,Done" {
+		t.Fatalf("statuses after rejected updates: %s", got)
+	}
+
+	for _, to := range []string{"Running", "Done"} {
+		w := bulk(`{"updates":[{"id":"a","status":"` + to + `"},{"id":"b","status":"` + to + `"}]}`)
+		if w.Code != http.StatusOK {
+			t.Fatalf("bulk %s: %d %s", to, w.Code, w.Body)
+		}
+	}
+	if got := statuses(); got != "Done,Done,Done" {
+		t.Fatalf("statuses after bulk updates: %s", got)
+	}
+	// Completing both jobs in one entry deducts both from the spool
+	if filament, err := s.getFilament("f1"); err != nil || filament.RemainingWeightInGrams != 980 {
+		t.Fatalf("filament after completing both: %+v %v", filament, err)
+	}
+}
@@ -0,0 +1,259 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/netip"
+	"net/url"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// cameraKeyPrefix prefixes printer camera registrations. They are kept apart
+// from the printer so its camera URLs never show up in printer listings.
+const cameraKeyPrefix = "camera_"
+
+// cameraSnapshotTimeout bounds fetching a single snapshot, and waiting for a
+// stream's response headers
+const cameraSnapshotTimeout = 10 * time.Second
+
+// cameraDialTimeout bounds connecting to a camera and its TLS handshake
+const cameraDialTimeout = 5 * time.Second
+
+// DefaultCameraNetworks are the networks camera URLs may reach unless
+// configured otherwise: the private IPv4 ranges and IPv6 unique local
+// addresses printer LANs use. Loopback, link-local and public addresses,
+// where the node's own services and cloud metadata live, are left out.
+const DefaultCameraNetworks = "10.0.0.0/8,172.16.0.0/12,192.168.0.0/16,fc00::/7"
+
+// cameraProxy is how a node reaches printer cameras
+type cameraProxy struct {
+	networks  []netip.Prefix
+	transport *http.Transport
+}
+
+// EnableCameraNetworks lets printer cameras be registered and proxied, but
+// only at addresses within the given networks. Addresses are checked when a
+// camera is registered and again for every connection the proxy makes, so a
+// host name can't later resolve somewhere else. Without it cameras are off.
+func (s *Server) EnableCameraNetworks(prefixes []netip.Prefix) {
+	proxy := &cameraProxy{networks: prefixes}
+	dialer := &net.Dialer{
+		Timeout: cameraDialTimeout,
+		Control: func(network, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			addr, err := netip.ParseAddr(host)
+			if err != nil || !proxy.allowed(addr) {
+				return fmt.Errorf("camera address %s is outside the allowed camera networks", host)
+			}
+			return nil
+		},
+	}
+	// No Proxy: cameras are reached directly, so the address check holds
+	proxy.transport = &http.Transport{
+		DialContext:           dialer.DialContext,
+		TLSHandshakeTimeout:   cameraDialTimeout,
+		ResponseHeaderTimeout: cameraSnapshotTimeout,
+		IdleConnTimeout:       90 * time.Second,
+		MaxIdleConnsPerHost:   2,
+	}
+	s.cameras = proxy
+}
+
+// allowed reports whether a camera may be reached at addr
+func (p *cameraProxy) allowed(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	for _, prefix := range p.networks {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// PrinterCamera is where a printer's webcam can be reached on its LAN
+type PrinterCamera struct {
+	SnapshotURL string `json:"snapshot_url,omitempty"`
+	StreamURL   string `json:"stream_url,omitempty"`
+}
+
+// validate checks that at least one URL is set and every URL is http(s).
+// URLs with an IP address must point into the allowed camera networks;
+// host names are checked once they resolve, when the camera is reached.
+func (c PrinterCamera) validate(proxy *cameraProxy) []FieldError {
+	if c.SnapshotURL == "" && c.StreamURL == "" {
+		return []FieldError{{Name: "snapshot_url", Reason: "a snapshot_url or stream_url is required"}}
+	}
+	var errs []FieldError
+	for name, raw := range map[string]string{"snapshot_url": c.SnapshotURL, "stream_url": c.StreamURL} {
+		if raw == "" {
+			continue
+		}
+		u, err := url.Parse(raw)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			errs = append(errs, FieldError{Name: name, Reason: "must be an absolute http or https URL"})
+			continue
+		}
+		if addr, err := netip.ParseAddr(u.Hostname()); err == nil && !proxy.allowed(addr) {
+			errs = append(errs, FieldError{Name: name, Reason: "must point into the allowed camera networks"})
+		}
+	}
+	return errs
+}
+
+// handlePrinterCamera handles /printers/{id}/camera: PUT registers the
+// camera, DELETE removes it, and GET proxies a snapshot. GET
+// /printers/{id}/camera/stream proxies the live stream. Clients only ever
+// talk to the cluster, so printer LANs stay private; the registered URLs are
+// only returned to admins, by GET ?config=true.
+func (s *Server) handlePrinterCamera(w http.ResponseWriter, r *http.Request, printerID string, stream bool) {
+	if s.cameras == nil {
+		writeError(w, r, http.StatusConflict, CodeConflict, "Printer cameras are not enabled on this node; start it with -camera-networks")
+		return
+	}
+	if _, err := s.getPrinter(printerID); err != nil {
+		s.writeStoreError(w, r, err, "Printer not found")
+		return
+	}
+
+	switch {
+	case r.Method == http.MethodPut && !stream:
+		if !s.requireRole(w, r, RoleAdmin) {
+			return
+		}
+		var camera PrinterCamera
+		if !decodeJSON(w, r, &camera) {
+			return
+		}
+		if errs := camera.validate(s.cameras); len(errs) > 0 {
+			writeValidationProblem(w, r, errs)
+			return
+		}
+		body, err := json.Marshal(camera)
+		if err != nil {
+			writeError(w, r, http.StatusInternalServerError, CodeInternal, "Failed to process camera data")
+			return
+		}
+		if err := s.storeFor(r).Set(cameraKeyPrefix+printerID, string(body)); err != nil {
+			s.writeStoreError(w, r, err, "Failed to store camera")
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(body)
+
+	case r.Method == http.MethodDelete && !stream:
+		if !s.requireRole(w, r, RoleAdmin) {
+			return
+		}
+		if err := s.storeFor(r).Delete(cameraKeyPrefix + printerID); err != nil {
+			s.writeStoreError(w, r, err, "Failed to remove camera")
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	case r.Method == http.MethodGet:
+		camera, err := s.getCamera(printerID)
+		if err != nil {
+			s.writeStoreError(w, r, err, "Printer has no camera")
+			return
+		}
+		if r.URL.Query().Get("config") == "true" {
+			if !s.requireRole(w, r, RoleAdmin) {
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(camera)
+			return
+		}
+
+		target := camera.SnapshotURL
+		if stream {
+			target = camera.StreamURL
+		}
+		if target == "" {
+			what := "snapshot"
+			if stream {
+				what = "stream"
+			}
+			writeError(w, r, http.StatusNotFound, CodeNotFound, "Printer camera has no "+what+" URL")
+			return
+		}
+		s.cameras.relay(w, r, printerID, target, stream)
+
+	default:
+		methodNotAllowed(w, r)
+	}
+}
+
+// getCamera loads a printer's camera registration
+func (s *Server) getCamera(printerID string) (PrinterCamera, error) {
+	var camera PrinterCamera
+	value, err := s.store.Get(cameraKeyPrefix + printerID)
+	if err != nil {
+		return camera, err
+	}
+	err = json.Unmarshal([]byte(value), &camera)
+	return camera, err
+}
+
+// relay proxies a camera URL to the client. Client credentials are not
+// forwarded to the printer. A snapshot must arrive within
+// cameraSnapshotTimeout; streams are flushed as they arrive and last until
+// either side disconnects.
+func (p *cameraProxy) relay(w http.ResponseWriter, r *http.Request, printerID, target string, stream bool) {
+	u, err := url.Parse(target)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, CodeInternal, "Printer camera URL is invalid")
+		return
+	}
+	if !stream {
+		ctx, cancel := context.WithTimeout(r.Context(), cameraSnapshotTimeout)
+		defer cancel()
+		r = r.WithContext(ctx)
+	}
+
+	proxy := &httputil.ReverseProxy{
+		Director: func(out *http.Request) {
+			out.URL = u
+			out.Host = u.Host
+			out.Header.Del("Authorization")
+			out.Header.Del("Cookie")
+			out.Header.Del("X-API-Key")
+		},
+		Transport:     p.transport,
+		FlushInterval: -1,
+		ModifyResponse: func(resp *http.Response) error {
+			resp.Header.Del("Set-Cookie")
+			if !stream {
+				resp.Header.Set("Cache-Control", "no-store")
+			}
+			return nil
+		},
+		ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+			log.Printf("Camera of printer %s unreachable: %s", printerID, err)
+			writeError(w, r, http.StatusBadGateway, CodeCameraUnavailable, "The printer's camera could not be reached")
+		},
+	}
+	proxy.ServeHTTP(w, r)
+}
+
+// cameraRoute splits a /printers/{id}/camera[/stream] path
+func cameraRoute(path string) (printerID string, stream, ok bool) {
+	rest := strings.TrimPrefix(path, "/api/v1/printers/")
+	if id, found := strings.CutSuffix(rest, "/camera/stream"); found {
+		return id, true, true
+	}
+	if id, found := strings.CutSuffix(rest, "/camera"); found {
+		return id, false, true
+	}
+	return "", false, false
+}
@@ -0,0 +1,75 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"strings"
+	"testing"
+	"time"
+
+	"raft3d/testsupport"
+)
+
+func TestCameraURLsStayWithinCameraNetworks(t *testing.T) {
+	camera := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/jpeg")
+		w.Write([]byte("snapshot"))
+	}))
+	defer camera.Close()
+
+	c := testsupport.NewCluster(t, 1)
+	leader := c.WaitForLeader(10 * time.Second)
+	s := NewServer("", leader.Store)
+	body, _ := json.Marshal(Printer{ID: "p1", Name: "Prusa", Status: "Idle"})
+	if err := leader.Store.Set("printer_p1", string(body)); err != nil {
+		t.Fatal(err)
+	}
+	put := func(snapshotURL string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		body := `{"snapshot_url":"` + snapshotURL + `"}`
+		s.handlePrinterCamera(rec, httptest.NewRequest(http.MethodPut, "/api/v1/printers/p1/camera", strings.NewReader(body)), "p1", false)
+		return rec
+	}
+	get := func() *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		s.handlePrinterCamera(rec, httptest.NewRequest(http.MethodGet, "/api/v1/printers/p1/camera", nil), "p1", false)
+		return rec
+	}
+
+	// Cameras are off until their networks are configured
+	if rec := put(camera.URL); rec.Code != http.StatusConflict {
+		t.Fatalf("camera without networks: %d %s", rec.Code, rec.Body)
+	}
+
+	// The default networks leave out loopback and link-local addresses
+	defaults, err := ParseAllowlist(DefaultCameraNetworks)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.EnableCameraNetworks(defaults)
+	for _, target := range []string{camera.URL, "http://169.254.169.254/latest/meta-data/", "http://[::1]:8001/"} {
+		if rec := put(target); rec.Code != http.StatusBadRequest {
+			t.Fatalf("camera at %s: %d %s", target, rec.Code, rec.Body)
+		}
+	}
+
+	// A host name is checked once it resolves, so it can't reach loopback either
+	name := strings.Replace(camera.URL, "127.0.0.1", "localhost", 1)
+	if rec := put(name); rec.Code != http.StatusOK {
+		t.Fatalf("camera by name: %d %s", rec.Code, rec.Body)
+	}
+	if rec := get(); rec.Code != http.StatusBadGateway {
+		t.Fatalf("snapshot from loopback by name: %d %s", rec.Code, rec.Body)
+	}
+
+	// Once its network is allowed, the camera is proxied
+	s.EnableCameraNetworks([]netip.Prefix{netip.MustParsePrefix("127.0.0.0/8")})
+	if rec := put(camera.URL); rec.Code != http.StatusOK {
+		t.Fatalf("camera in an allowed network: %d %s", rec.Code, rec.Body)
+	}
+	if rec := get(); rec.Code != http.StatusOK || rec.Body.String() != "snapshot" {
+		t.Fatalf("snapshot: %d %s", rec.Code, rec.Body)
+	}
+}
@@ -0,0 +1,75 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// handleChaos handles the fault injection endpoints:
+//
+//	GET  /api/v1/chaos                          current settings
+//	POST /api/v1/chaos/pause-apply              block the FSM apply loop
+//	POST /api/v1/chaos/resume-apply             release the FSM apply loop
+//	POST /api/v1/chaos/apply-latency?ms=N       delay every FSM apply
+//	POST /api/v1/chaos/drop-rpcs?percent=N      drop outgoing Raft RPCs
+//	POST /api/v1/chaos/step-down                force the leader to step down
+//	POST /api/v1/chaos/reset                    disable all fault injection
+func (s *Server) handleChaos(w http.ResponseWriter, r *http.Request) {
+	action := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/v1/chaos"), "/")
+
+	if action == "" {
+		if r.Method != http.MethodGet {
+			methodNotAllowed(w, r)
+			return
+		}
+		s.writeChaosState(w)
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		methodNotAllowed(w, r)
+		return
+	}
+
+	switch action {
+	case "pause-apply":
+		s.chaos.PauseApply()
+	case "resume-apply":
+		s.chaos.ResumeApply()
+	case "apply-latency":
+		ms, err := strconv.Atoi(r.URL.Query().Get("ms"))
+		if err != nil || ms < 0 {
+			writeValidationProblem(w, r, []FieldError{{Name: "ms", Reason: "must be a non-negative integer"}})
+			return
+		}
+		s.chaos.SetApplyLatency(time.Duration(ms) * time.Millisecond)
+	case "drop-rpcs":
+		percent, err := strconv.Atoi(r.URL.Query().Get("percent"))
+		if err != nil || percent < 0 || percent > 100 {
+			writeValidationProblem(w, r, []FieldError{{Name: "percent", Reason: "must be an integer between 0 and 100"}})
+			return
+		}
+		s.chaos.SetDropPercent(percent)
+	case "step-down":
+		if err := s.store.TransferLeadership(); err != nil {
+			s.writeStoreError(w, r, err, "Failed to transfer leadership")
+			return
+		}
+	case "reset":
+		s.chaos.Reset()
+	default:
+		writeError(w, r, http.StatusNotFound, CodeNotFound, "Unknown chaos action")
+		return
+	}
+
+	s.writeChaosState(w)
+}
+
+// writeChaosState writes the current fault injection settings
+func (s *Server) writeChaosState(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.chaos.State())
+}
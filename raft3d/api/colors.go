@@ -0,0 +1,210 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// colorFamilies are the families colors are grouped in for filtering
+var colorFamilies = []string{"black", "white", "gray", "red", "orange", "yellow", "green", "blue", "purple", "pink", "brown", "clear"}
+
+// namedColors maps common filament color names to a hex value and family.
+// Names outside the list are kept as given, lowercased.
+var namedColors = map[string]struct{ hex, family string }{
+	"black":       {"#000000", "black"},
+	"white":       {"#ffffff", "white"},
+	"gray":        {"#808080", "gray"},
+	"silver":      {"#c0c0c0", "gray"},
+	"red":         {"#ff0000", "red"},
+	"orange":      {"#ffa500", "orange"},
+	"yellow":      {"#ffff00", "yellow"},
+	"gold":        {"#ffd700", "yellow"},
+	"green":       {"#008000", "green"},
+	"olive":       {"#808000", "green"},
+	"blue":        {"#0000ff", "blue"},
+	"navy":        {"#000080", "blue"},
+	"cyan":        {"#00ffff", "blue"},
+	"purple":      {"#800080", "purple"},
+	"violet":      {"#ee82ee", "purple"},
+	"pink":        {"#ffc0cb", "pink"},
+	"magenta":     {"#ff00ff", "pink"},
+	"brown":       {"#8b4513", "brown"},
+	"beige":       {"#f5f5dc", "brown"},
+	"clear":       {"", "clear"},
+	"natural":     {"", "clear"},
+	"transparent": {"", "clear"},
+}
+
+// colorSynonyms are spellings normalized to a name above
+var colorSynonyms = map[string]string{"grey": "gray", "translucent": "transparent"}
+
+// hexColor matches #rgb and #rrggbb, with or without the #
+var hexColor = regexp.MustCompile(`^#?([0-9a-fA-F]{3}|[0-9a-fA-F]{6})$`)
+
+// FilamentColor is the color of a filament. Name, hex or both may be given;
+// the server normalizes them and works out the family, so inventory can be
+// searched by ?color_family= however the color was written.
+type FilamentColor struct {
+	Name   string `json:"name,omitempty"`
+	Hex    string `json:"hex,omitempty"`    // #rrggbb
+	Family string `json:"family,omitempty"` // set by the server
+}
+
+// UnmarshalJSON also accepts the plain string colors used to be, such as
+// "Red" or "#ff0000"
+func (c *FilamentColor) UnmarshalJSON(data []byte) error {
+	var text string
+	if err := json.Unmarshal(data, &text); err == nil {
+		*c = FilamentColor{Name: text}
+		if hexColor.MatchString(strings.TrimSpace(text)) {
+			*c = FilamentColor{Hex: text}
+		}
+		return nil
+	}
+	type plain FilamentColor
+	return json.Unmarshal(data, (*plain)(c))
+}
+
+// String returns the color's name, or its hex value without one
+func (c FilamentColor) String() string {
+	if c.Name != "" {
+		return c.Name
+	}
+	return c.Hex
+}
+
+// normalized returns the color with a lowercase name, a #rrggbb hex value
+// and its family. A known name without a hex value gets the name's.
+func (c FilamentColor) normalized() (FilamentColor, []FieldError) {
+	name := strings.Join(strings.Fields(strings.ToLower(c.Name)), " ")
+	if synonym, ok := colorSynonyms[name]; ok {
+		name = synonym
+	}
+	normalized := FilamentColor{Name: name}
+
+	if hex := strings.TrimSpace(c.Hex); hex != "" {
+		match := hexColor.FindStringSubmatch(hex)
+		if match == nil {
+			return c, []FieldError{{Name: "color.hex", Reason: "must be #rgb or #rrggbb"}}
+		}
+		digits := strings.ToLower(match[1])
+		if len(digits) == 3 {
+			digits = string([]byte{digits[0], digits[0], digits[1], digits[1], digits[2], digits[2]})
+		}
+		normalized.Hex = "#" + digits
+	}
+
+	known, isKnown := namedColors[name]
+	if normalized.Hex == "" && isKnown {
+		normalized.Hex = known.hex
+	}
+	switch {
+	case isKnown && (known.family == "clear" || c.Hex == ""):
+		normalized.Family = known.family
+	case normalized.Hex != "":
+		normalized.Family = hexFamily(normalized.Hex)
+	default:
+		// Names such as "galaxy black" or "grey blue" take the family of
+		// their last word that names one
+		words := strings.Fields(name)
+		for i := len(words) - 1; i >= 0; i-- {
+			word := words[i]
+			if synonym, ok := colorSynonyms[word]; ok {
+				word = synonym
+			}
+			if known, ok := namedColors[word]; ok {
+				normalized.Family = known.family
+				break
+			}
+		}
+	}
+	return normalized, nil
+}
+
+// hexFamily returns the family of a #rrggbb color by its hue, saturation
+// and lightness
+func hexFamily(hex string) string {
+	value, _ := strconv.ParseUint(strings.TrimPrefix(hex, "#"), 16, 32)
+	r, g, b := float64(value>>16&0xff)/255, float64(value>>8&0xff)/255, float64(value&0xff)/255
+	max, min := math.Max(r, math.Max(g, b)), math.Min(r, math.Min(g, b))
+	lightness := (max + min) / 2
+	saturation := 0.0
+	if max != min {
+		saturation = (max - min) / (1 - math.Abs(2*lightness-1))
+	}
+	switch {
+	case lightness < 0.12:
+		return "black"
+	case lightness > 0.92:
+		return "white"
+	case saturation < 0.15:
+		return "gray"
+	}
+
+	var hue float64
+	switch max {
+	case r:
+		hue = math.Mod((g-b)/(max-min), 6)
+	case g:
+		hue = (b-r)/(max-min) + 2
+	default:
+		hue = (r-g)/(max-min) + 4
+	}
+	hue *= 60
+	if hue < 0 {
+		hue += 360
+	}
+	switch {
+	case hue < 15 || hue >= 345:
+		if lightness > 0.75 {
+			return "pink"
+		}
+		return "red"
+	case hue < 45:
+		if lightness < 0.35 {
+			return "brown"
+		}
+		return "orange"
+	case hue < 70:
+		return "yellow"
+	case hue < 170:
+		return "green"
+	case hue < 260:
+		return "blue"
+	case hue < 290:
+		return "purple"
+	default:
+		return "pink"
+	}
+}
+
+// parseColorFamily reads ?color_family=
+func parseColorFamily(raw string) (string, []FieldError) {
+	family := strings.ToLower(strings.TrimSpace(raw))
+	if family == "grey" {
+		family = "gray"
+	}
+	for _, known := range colorFamilies {
+		if family == known {
+			return family, nil
+		}
+	}
+	if family == "" {
+		return "", nil
+	}
+	return "", []FieldError{{Name: "color_family", Reason: fmt.Sprintf("must be one of %s", strings.Join(colorFamilies, ", "))}}
+}
+
+// colorFamily returns a filament's color family, working it out for
+// filaments stored before colors were normalized
+func (f Filament) colorFamily() string {
+	if f.Color.Family != "" {
+		return f.Color.Family
+	}
+	normalized, _ := f.Color.normalized()
+	return normalized.Family
+}
@@ -0,0 +1,91 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"raft3d/testsupport"
+)
+
+func TestFilamentColorNormalization(t *testing.T) {
+	for _, tc := range []struct {
+		in   string
+		want FilamentColor
+	}{
+		{`"Red"`, FilamentColor{Name: "red", Hex: "#ff0000", Family: "red"}},
+		{`"  Galaxy   BLACK "`, FilamentColor{Name: "galaxy black", Family: "black"}},
+		{`"#0F0"`, FilamentColor{Hex: "#00ff00", Family: "green"}},
+		{`{"name":"Grey"}`, FilamentColor{Name: "gray", Hex: "#808080", Family: "gray"}},
+		{`{"name":"Sky","hex":"87CEEB"}`, FilamentColor{Name: "sky", Hex: "#87ceeb", Family: "blue"}},
+		{`{"name":"orange","hex":"#5c3a1e"}`, FilamentColor{Name: "orange", Hex: "#5c3a1e", Family: "brown"}},
+		{`{"name":"natural","hex":"#f0f0e0"}`, FilamentColor{Name: "natural", Hex: "#f0f0e0", Family: "clear"}},
+		{`{"hex":"#ffb6c1"}`, FilamentColor{Hex: "#ffb6c1", Family: "pink"}},
+		{`{"hex":"#6a0dad"}`, FilamentColor{Hex: "#6a0dad", Family: "purple"}},
+		{`""`, FilamentColor{}},
+	} {
+		var color FilamentColor
+		if err := json.Unmarshal([]byte(tc.in), &color); err != nil {
+			t.Fatalf("%s: %v", tc.in, err)
+		}
+		got, errs := color.normalized()
+		if len(errs) > 0 || got != tc.want {
+			t.Errorf("%s = %+v, %v; want %+v", tc.in, got, errs, tc.want)
+		}
+	}
+	if _, errs := (FilamentColor{Hex: "#12345"}).normalized(); len(errs) != 1 || errs[0].Name != "color.hex" {
+		t.Errorf("bad hex accepted: %v", errs)
+	}
+}
+
+func TestColorFamilyFilters(t *testing.T) {
+	c := testsupport.NewCluster(t, 1)
+	leader := c.WaitForLeader(10 * time.Second)
+	s := NewServer("", leader.Store)
+
+	post := func(body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		s.handleFilaments(rec, httptest.NewRequest(http.MethodPost, "/api/v1/filaments", strings.NewReader(body)))
+		return rec
+	}
+	if rec := post(`{"id":"f1","name":"Spool","type":"PLA","color":{"name":"Fire Engine","hex":"#CE2029"},"total_weight_in_grams":1000}`); rec.Code != http.StatusCreated || !strings.Contains(rec.Body.String(), `"family":"red"`) {
+		t.Fatalf("post: %d %s", rec.Code, rec.Body)
+	}
+	if rec := post(`{"id":"bad","name":"Spool","type":"PLA","color":{"hex":"red"},"total_weight_in_grams":1000}`); rec.Code != http.StatusBadRequest {
+		t.Fatalf("bad hex: %d %s", rec.Code, rec.Body)
+	}
+	// Filaments stored with the old free-text color still filter
+	for key, value := range map[string]string{
+		"filament_f2": `{"id":"f2","name":"Old","type":"PLA","color":"Navy","total_weight_in_grams":1000}`,
+		"printjob_j1": `{"id":"j1","printer_id":"p1","filament_id":"f1","filepath":"a.gcode","status":"Queued"}`,
+		"printjob_j2": `{"id":"j2","printer_id":"p1","filament_id":"f2","filepath":"b.gcode","status":"Queued"}`,
+	} {
+		if err := leader.Store.Set(key, value); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	get := func(url string) (int, map[string]json.RawMessage) {
+		rec := httptest.NewRecorder()
+		if strings.HasPrefix(url, "/api/v1/filaments") {
+			s.handleFilaments(rec, httptest.NewRequest(http.MethodGet, url, nil))
+		} else {
+			s.handlePrintJobs(rec, httptest.NewRequest(http.MethodGet, url, nil))
+		}
+		var items map[string]json.RawMessage
+		json.NewDecoder(rec.Body).Decode(&items)
+		return rec.Code, items
+	}
+	if _, items := get("/api/v1/filaments?color_family=blue"); len(items) != 1 || items["f2"] == nil {
+		t.Errorf("blue filaments: %v", items)
+	}
+	if _, items := get("/api/v1/print_jobs?color_family=RED"); len(items) != 1 || items["j1"] == nil {
+		t.Errorf("red jobs: %v", items)
+	}
+	if code, _ := get("/api/v1/print_jobs?color_family=plaid"); code != http.StatusBadRequest {
+		t.Errorf("unknown family: %d", code)
+	}
+}
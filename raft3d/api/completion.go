@@ -0,0 +1,155 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"raft3d/raft"
+)
+
+// completionAttempts bounds how often status changes are retried when
+// another write to the same spool gets in first
+const completionAttempts = 10
+
+// errJobChanged reports that a job left the status it was changed from
+// while the change was being applied
+var errJobChanged = errors.New("print job changed state before the update could be applied")
+
+// statusChange moves a job from one status to another
+type statusChange struct {
+	job        PrintJob         // with the new status and its timestamps set
+	from       string           // status the job must still have
+	conditions []raft.Condition // further conditions, e.g. on dependencies
+}
+
+// commitStatusChanges writes status changes in a single log entry. Jobs
+// moving to Done get their filament deducted and their usage recorded in
+// the same entry, so concurrent completions on one spool can't lose a
+// deduction. The write is conditioned on every job's old status and every
+// affected spool's remaining weight; if a spool changed, the entry is
+// rebuilt from fresh state and retried. The wear counters of the printers'
+// components advance in the same entry.
+func (s *Server) commitStatusChanges(ctx context.Context, changes []statusChange) error {
+	for attempt := 1; ; attempt++ {
+		values := make(map[string]string)
+		var conditions []raft.Condition
+
+		filaments := make(map[string]*Filament)
+		remaining := make(map[string]float64)
+		var components map[string][]*Component
+		worn := make(map[string]*Component)
+		var due []Component
+		for _, change := range changes {
+			job := change.job
+			if job.Status == "Done" {
+				filament, ok := filaments[job.FilamentID]
+				if !ok {
+					loaded, err := s.getFilament(job.FilamentID)
+					if err != nil {
+						return fmt.Errorf("filament referenced by print job %s: %w", job.ID, err)
+					}
+					filament = &loaded
+					filaments[job.FilamentID] = filament
+					remaining[job.FilamentID] = filament.RemainingWeightInGrams
+				}
+
+				completedAt := time.Now().UTC()
+				job.CompletedAt = &completedAt
+				job.MaterialCost = filament.MaterialCost(job.PrintWeightInGrams)
+
+				filament.RemainingWeightInGrams = roundGrams(filament.RemainingWeightInGrams - job.PrintWeightInGrams)
+				if filament.RemainingWeightInGrams < 0 {
+					filament.RemainingWeightInGrams = 0
+				}
+
+				usage, err := usageRecord(job, *filament)
+				if err != nil {
+					return err
+				}
+				values[usageKeyPrefix+job.ID] = usage
+
+				if components == nil {
+					if components, err = s.componentsByPrinter(); err != nil {
+						return err
+					}
+				}
+				for _, component := range components[job.PrinterID] {
+					if _, ok := worn[component.ID]; !ok {
+						conditions = append(conditions, componentCondition(*component)...)
+						worn[component.ID] = component
+					}
+					if component.addWear(jobHours(job), job.PrintWeightInGrams) {
+						due = append(due, *component)
+					}
+				}
+			}
+
+			body, err := json.Marshal(job)
+			if err != nil {
+				return err
+			}
+			values["printjob_"+job.ID] = string(body)
+			if err := s.notifyJobStatus(values, job); err != nil {
+				return err
+			}
+			conditions = append(conditions, raft.Condition{Key: "printjob_" + job.ID, Field: "status", Equals: change.from})
+			conditions = append(conditions, change.conditions...)
+		}
+
+		filamentIDs := make([]string, 0, len(filaments))
+		for id := range filaments {
+			filamentIDs = append(filamentIDs, id)
+		}
+		sort.Strings(filamentIDs)
+		for _, id := range filamentIDs {
+			body, err := json.Marshal(filaments[id])
+			if err != nil {
+				return err
+			}
+			values["filament_"+id] = string(body)
+			if err := s.notifyFilamentLow(values, *filaments[id], remaining[id]); err != nil {
+				return err
+			}
+			conditions = append(conditions, raft.Condition{
+				Key: "filament_" + id, Field: "remaining_weight_in_grams", Equals: fmt.Sprint(remaining[id]),
+			})
+		}
+
+		for id, component := range worn {
+			body, err := json.Marshal(component)
+			if err != nil {
+				return err
+			}
+			values[componentKeyPrefix+id] = string(body)
+		}
+
+		err := s.store.WithContext(ctx).SetMany(values, conditions...)
+		if err == nil && raft.IsDryRun(ctx) {
+			return nil
+		}
+		if err == nil {
+			for _, component := range due {
+				s.raiseMaintenanceAlert(component)
+			}
+			return nil
+		}
+		if !errors.Is(err, raft.ErrConflict) || errors.Is(err, raft.ErrInvalidStepTransition) || attempt == completionAttempts {
+			return err
+		}
+
+		// Only retry if every job is still waiting for its change
+		for _, change := range changes {
+			job, err := s.getPrintJob(change.job.ID)
+			if err != nil {
+				return err
+			}
+			if job.Status != change.from {
+				return fmt.Errorf("%w: %s", errJobChanged, change.job.ID)
+			}
+		}
+	}
+}
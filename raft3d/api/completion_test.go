@@ -0,0 +1,180 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"raft3d/raft"
+	"raft3d/testsupport"
+)
+
+func TestConcurrentCompletionsOnOneSpool(t *testing.T) {
+	tests := []struct {
+		name      string
+		remaining float64
+		weight    float64
+		want      float64
+	}{
+		{"both fit", 100, 30, 40},
+		{"second overdraws", 50, 30, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := testsupport.NewCluster(t, 3)
+			leader := c.WaitForLeader(10 * time.Second)
+			s := NewServer("", leader.Store)
+
+			put := func(key string, v interface{}) {
+				body, _ := json.Marshal(v)
+				if err := leader.Store.Set(key, string(body)); err != nil {
+					t.Fatalf("set %s: %s", key, err)
+				}
+			}
+			put("filament_f1", Filament{ID: "f1", Name: "PLA", Type: "PLA", TotalWeightInGrams: 1000, RemainingWeightInGrams: tt.remaining})
+			var jobs []PrintJob
+			for i := 1; i <= 2; i++ {
+				job := PrintJob{ID: fmt.Sprintf("j%d", i), PrinterID: "p1", FilamentID: "f1", FilePath: "part.gcode", PrintWeightInGrams: tt.weight, Status: "Running"}
+				put("printjob_"+job.ID, job)
+				jobs = append(jobs, job)
+			}
+
+			var wg sync.WaitGroup
+			errs := make([]error, len(jobs))
+			for i, job := range jobs {
+				wg.Add(1)
+				go func(i int, job PrintJob) {
+					defer wg.Done()
+					job.Status = "Done"
+					errs[i] = s.commitStatusChanges(context.Background(), []statusChange{{job: job, from: "Running"}})
+				}(i, job)
+			}
+			wg.Wait()
+			for i, err := range errs {
+				if err != nil {
+					t.Fatalf("completing %s: %s", jobs[i].ID, err)
+				}
+			}
+
+			filament, err := s.getFilament("f1")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if filament.RemainingWeightInGrams != tt.want {
+				t.Errorf("remaining_weight_in_grams = %v, want %v", filament.RemainingWeightInGrams, tt.want)
+			}
+			if filament.RemainingWeightInGrams < 0 {
+				t.Errorf("remaining_weight_in_grams went negative: %v", filament.RemainingWeightInGrams)
+			}
+			usage, err := leader.Store.List(usageKeyPrefix)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(usage) != 2 {
+				t.Errorf("got %d usage records, want 2", len(usage))
+			}
+			for _, job := range jobs {
+				stored, err := s.getPrintJob(job.ID)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if stored.Status != "Done" {
+					t.Errorf("%s is %s, want Done", job.ID, stored.Status)
+				}
+			}
+			c.AssertConverged(5 * time.Second)
+		})
+	}
+}
+
+func TestCompletingTwiceDeductsOnce(t *testing.T) {
+	c := testsupport.NewCluster(t, 1)
+	leader := c.WaitForLeader(10 * time.Second)
+	s := NewServer("", leader.Store)
+
+	filament, _ := json.Marshal(Filament{ID: "f1", Name: "PLA", Type: "PLA", TotalWeightInGrams: 1000, RemainingWeightInGrams: 100})
+	leader.Store.Set("filament_f1", string(filament))
+	job := PrintJob{ID: "j1", PrinterID: "p1", FilamentID: "f1", FilePath: "part.gcode", PrintWeightInGrams: 30, Status: "Running"}
+	body, _ := json.Marshal(job)
+	leader.Store.Set("printjob_j1", string(body))
+
+	var wg sync.WaitGroup
+	errs := make([]error, 2)
+	for i := range errs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			done := job
+			done.Status = "Done"
+			errs[i] = s.commitStatusChanges(context.Background(), []statusChange{{job: done, from: "Running"}})
+		}(i)
+	}
+	wg.Wait()
+
+	if (errs[0] == nil) == (errs[1] == nil) {
+		t.Fatalf("want exactly one completion to succeed, got %v and %v", errs[0], errs[1])
+	}
+	stored, _ := s.getFilament("f1")
+	if stored.RemainingWeightInGrams != 70 {
+		t.Errorf("remaining_weight_in_grams = %v, want 70", stored.RemainingWeightInGrams)
+	}
+}
+
+// staleReadStore runs race once, right after the first read of key, so a
+// handler acts on a copy that is already stale
+type staleReadStore struct {
+	raft.Store
+	key  string
+	race func()
+	once sync.Once
+}
+
+func (r *staleReadStore) Get(key string) (string, error) {
+	value, err := r.Store.Get(key)
+	if key == r.key {
+		r.once.Do(r.race)
+	}
+	return value, err
+}
+
+func TestCancelAfterCompletionDoesNotOverwriteIt(t *testing.T) {
+	c := testsupport.NewCluster(t, 1)
+	leader := c.WaitForLeader(10 * time.Second)
+	s := NewServer("", leader.Store)
+
+	filament, _ := json.Marshal(Filament{ID: "f1", Name: "PLA", Type: "PLA", TotalWeightInGrams: 1000, RemainingWeightInGrams: 100})
+	leader.Store.Set("filament_f1", string(filament))
+	body, _ := json.Marshal(PrintJob{ID: "j1", PrinterID: "p1", FilamentID: "f1", FilePath: "part.gcode", PrintWeightInGrams: 30, Status: "Running"})
+	leader.Store.Set("printjob_j1", string(body))
+
+	update := func(s *Server, to string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		s.handleUpdatePrintJobStatus(w, httptest.NewRequest(http.MethodPost, "/api/v1/print_jobs/j1/status?status="+to, nil), "j1")
+		return w
+	}
+
+	// The Cancel reads the job as Running, then the Done commits
+	var completed *httptest.ResponseRecorder
+	canceling := NewServer("", &staleReadStore{Store: leader.Store, key: "printjob_j1", race: func() { completed = update(s, "Done") }})
+	w := update(canceling, "Canceled")
+	if completed.Code != http.StatusOK {
+		t.Fatalf("completion: %d %s", completed.Code, completed.Body)
+	}
+	if w.Code != http.StatusConflict || !strings.Contains(w.Body.String(), CodeInvalidTransition) {
+		t.Fatalf("stale cancel: %d %s", w.Code, w.Body)
+	}
+
+	// The job stays Done with its filament deducted once
+	if job, err := s.getPrintJob("j1"); err != nil || job.Status != "Done" {
+		t.Fatalf("job after the race: %+v %v", job, err)
+	}
+	if stored, _ := s.getFilament("f1"); stored.RemainingWeightInGrams != 70 {
+		t.Errorf("remaining_weight_in_grams = %v, want 70", stored.RemainingWeightInGrams)
+	}
+}
@@ -0,0 +1,223 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"raft3d/raft"
+)
+
+// componentKeyPrefix prefixes printer components in the store
+const componentKeyPrefix = "component_"
+
+// maintenanceRuleID is the rule ID of maintenance alerts, which are raised
+// by component wear rather than an alert rule
+const maintenanceRuleID = "maintenance"
+
+// handleComponents handles GET/POST /components, GET/DELETE
+// /components/{id} and POST /components/{id}/service. The list can be
+// filtered by ?printer_id= and ?due=true.
+func (s *Server) handleComponents(w http.ResponseWriter, r *http.Request) {
+	path := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/v1/components"), "/")
+	id, action, _ := strings.Cut(path, "/")
+
+	switch {
+	case path == "" && r.Method == http.MethodGet:
+		all, err := loadAll[Component](s, componentKeyPrefix)
+		if err != nil {
+			s.writeStoreError(w, r, err, "Failed to retrieve components")
+			return
+		}
+		components := []Component{}
+		for _, component := range all {
+			if printer := r.URL.Query().Get("printer_id"); printer != "" && component.PrinterID != printer {
+				continue
+			}
+			if r.URL.Query().Get("due") == "true" && !component.MaintenanceDue {
+				continue
+			}
+			components = append(components, component)
+		}
+		sort.Slice(components, func(i, j int) bool { return components[i].ID < components[j].ID })
+		writeList(w, r, components)
+	case path == "" && r.Method == http.MethodPost:
+		s.handlePostComponent(w, r)
+	case action == "" && r.Method == http.MethodGet:
+		value, err := s.store.Get(componentKeyPrefix + id)
+		if err != nil {
+			s.writeStoreError(w, r, err, "Component not found")
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(value))
+	case action == "" && r.Method == http.MethodDelete:
+		if _, err := s.store.Get(componentKeyPrefix + id); err != nil {
+			s.writeStoreError(w, r, err, "Component not found")
+			return
+		}
+		if err := s.storeFor(r).Delete(componentKeyPrefix + id); err != nil {
+			s.writeStoreError(w, r, err, "Failed to delete component")
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	case action == "service" && r.Method == http.MethodPost:
+		component, err := s.serviceComponent(r.Context(), id)
+		if err != nil {
+			s.writeStoreError(w, r, err, "Failed to service component")
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(component)
+	default:
+		methodNotAllowed(w, r)
+	}
+}
+
+// handlePostComponent attaches a component to a printer. Counters may be
+// given for parts that were already in use.
+func (s *Server) handlePostComponent(w http.ResponseWriter, r *http.Request) {
+	var component Component
+	if !decodeJSON(w, r, &component) {
+		return
+	}
+	if _, err := s.getPrinter(component.PrinterID); err != nil {
+		writeValidationProblem(w, r, []FieldError{{Name: "printer_id", Reason: "printer does not exist"}})
+		return
+	}
+	component.InstalledAt = time.Now().UTC()
+	component.LastServicedAt = nil
+	component.MaintenanceDue = component.wornOut()
+
+	body, err := json.Marshal(component)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, CodeInternal, "Failed to process component data")
+		return
+	}
+	stored, err := s.storeNew(r, componentKeyPrefix, component.ID, string(body))
+	if err != nil {
+		s.writeStoreError(w, r, err, "Failed to store component")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	w.Write([]byte(stored))
+}
+
+// wornOut reports whether a counter has reached its limit
+func (c Component) wornOut() bool {
+	return (c.MaxPrintHours > 0 && c.PrintHours >= c.MaxPrintHours) ||
+		(c.MaxPrintGrams > 0 && c.PrintGrams >= c.MaxPrintGrams)
+}
+
+// addWear advances the counters by a completed job and reports whether that
+// made maintenance due
+func (c *Component) addWear(hours, grams float64) bool {
+	wasDue := c.MaintenanceDue
+	c.PrintHours = math.Round((c.PrintHours+hours)*1000) / 1000
+	c.PrintGrams = roundGrams(c.PrintGrams + grams)
+	c.MaintenanceDue = c.wornOut()
+	return c.MaintenanceDue && !wasDue
+}
+
+// jobHours returns how long a job printed
+func jobHours(job PrintJob) float64 {
+	if job.StartedAt == nil || job.CompletedAt == nil {
+		return 0
+	}
+	return job.CompletedAt.Sub(*job.StartedAt).Hours()
+}
+
+// componentsByPrinter loads the components of every printer that has some
+func (s *Server) componentsByPrinter() (map[string][]*Component, error) {
+	all, err := loadAll[Component](s, componentKeyPrefix)
+	if err != nil {
+		return nil, err
+	}
+	byPrinter := make(map[string][]*Component)
+	for id := range all {
+		component := all[id]
+		byPrinter[component.PrinterID] = append(byPrinter[component.PrinterID], &component)
+	}
+	return byPrinter, nil
+}
+
+// componentCondition guards a component update against a concurrent one
+func componentCondition(c Component) []raft.Condition {
+	key := componentKeyPrefix + c.ID
+	return []raft.Condition{
+		{Key: key, Field: "print_hours", Equals: fmt.Sprint(c.PrintHours)},
+		{Key: key, Field: "print_grams", Equals: fmt.Sprint(c.PrintGrams)},
+	}
+}
+
+// serviceComponent resets a component's counters after maintenance and
+// resolves its maintenance alert
+func (s *Server) serviceComponent(ctx context.Context, id string) (Component, error) {
+	var component Component
+	value, err := s.store.Get(componentKeyPrefix + id)
+	if err != nil {
+		return component, err
+	}
+	if err := json.Unmarshal([]byte(value), &component); err != nil {
+		return component, err
+	}
+	conditions := componentCondition(component)
+
+	now := time.Now().UTC()
+	component.PrintHours, component.PrintGrams = 0, 0
+	component.MaintenanceDue = false
+	component.LastServicedAt = &now
+	body, err := json.Marshal(component)
+	if err != nil {
+		return component, err
+	}
+	if err := s.store.WithContext(ctx).SetIf(componentKeyPrefix+id, string(body), conditions...); err != nil {
+		return component, err
+	}
+
+	if _, err := s.resolveAlert(alertID(maintenanceRuleID, id)); err != nil && !errors.Is(err, raft.ErrNotFound) {
+		log.Printf("Failed to resolve maintenance alert of component %s: %s", id, err)
+	}
+	return component, nil
+}
+
+// raiseMaintenanceAlert opens the maintenance alert of a worn out component
+func (s *Server) raiseMaintenanceAlert(c Component) {
+	value := c.PrintHours
+	if c.MaxPrintGrams > 0 && c.PrintGrams >= c.MaxPrintGrams {
+		value = c.PrintGrams
+	}
+	alert := Alert{
+		ID:        alertID(maintenanceRuleID, c.ID),
+		RuleID:    maintenanceRuleID,
+		PrinterID: c.PrinterID,
+		Status:    AlertOpen,
+		Message: fmt.Sprintf("maintenance due: %s %s on printer %s has %g print hours and %g grams",
+			c.Kind, c.ID, c.PrinterID, c.PrintHours, c.PrintGrams),
+		Value:    value,
+		RaisedAt: time.Now().UTC(),
+	}
+	if value, err := s.store.Get(alertKeyPrefix + alert.ID); err == nil {
+		var previous Alert
+		if json.Unmarshal([]byte(value), &previous) == nil {
+			alert.Count = previous.Count
+		}
+	}
+	alert.Count++
+
+	if err := s.storeAlert(alert); err != nil {
+		log.Printf("Failed to raise maintenance alert for component %s: %s", c.ID, err)
+		return
+	}
+	log.Printf("Alert raised: %s", alert.Message)
+	s.publish(EventAlertRaised, alert)
+}
@@ -0,0 +1,103 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"raft3d/testsupport"
+)
+
+func TestComponentWearAndService(t *testing.T) {
+	c := testsupport.NewCluster(t, 1)
+	leader := c.WaitForLeader(10 * time.Second)
+	s := NewServer("", leader.Store)
+
+	put := func(key string, v interface{}) {
+		body, _ := json.Marshal(v)
+		if err := leader.Store.Set(key, string(body)); err != nil {
+			t.Fatalf("set %s: %s", key, err)
+		}
+	}
+	component := func(id string) Component {
+		var c Component
+		value, err := leader.Store.Get(componentKeyPrefix + id)
+		if err != nil || json.Unmarshal([]byte(value), &c) != nil {
+			t.Fatalf("component %s: %v", id, err)
+		}
+		return c
+	}
+	maintenanceAlert := func() (Alert, bool) {
+		var alert Alert
+		value, err := leader.Store.Get(alertKeyPrefix + alertID(maintenanceRuleID, "nozzle"))
+		if err != nil {
+			return alert, false
+		}
+		json.Unmarshal([]byte(value), &alert)
+		return alert, true
+	}
+
+	put("printer_p1", Printer{ID: "p1", Name: "Prusa", Status: "Printing"})
+	put("filament_f1", Filament{ID: "f1", Name: "PLA", Type: "PLA", TotalWeightInGrams: 1000, RemainingWeightInGrams: 1000})
+	put(componentKeyPrefix+"nozzle", Component{ID: "nozzle", PrinterID: "p1", Kind: "nozzle", MaxPrintGrams: 100})
+	put(componentKeyPrefix+"belt", Component{ID: "belt", PrinterID: "p1", Kind: "belt", MaxPrintHours: 1000})
+	put(componentKeyPrefix+"other", Component{ID: "other", PrinterID: "p2", Kind: "belt"})
+
+	started := time.Now().UTC().Add(-time.Hour)
+	tests := []struct {
+		job       string
+		wantGrams float64
+		wantDue   bool
+	}{
+		{"j1", 60, false},
+		{"j2", 120, true},
+		{"j3", 180, true},
+	}
+	for i, tt := range tests {
+		put("printjob_"+tt.job, PrintJob{ID: tt.job, PrinterID: "p1", FilamentID: "f1", FilePath: "a.gcode", PrintWeightInGrams: 60, Status: "Running", StartedAt: &started})
+		w := httptest.NewRecorder()
+		s.handleUpdatePrintJobStatus(w, httptest.NewRequest(http.MethodPost, "/api/v1/print_jobs/"+tt.job+"/status?status=Done", nil), tt.job)
+		if w.Code != http.StatusOK {
+			t.Fatalf("complete %s: %d %s", tt.job, w.Code, w.Body)
+		}
+
+		nozzle := component("nozzle")
+		if nozzle.PrintGrams != tt.wantGrams || nozzle.MaintenanceDue != tt.wantDue {
+			t.Fatalf("after %s the nozzle has %gg, due %v", tt.job, nozzle.PrintGrams, nozzle.MaintenanceDue)
+		}
+		if belt := component("belt"); belt.PrintHours < float64(i+1) || belt.PrintHours > float64(i+1)+0.1 || belt.PrintGrams != tt.wantGrams || belt.MaintenanceDue {
+			t.Fatalf("after %s the belt has %gh, %gg, due %v", tt.job, belt.PrintHours, belt.PrintGrams, belt.MaintenanceDue)
+		}
+
+		// One alert is raised as the limit is reached, and not raised
+		// again by later jobs
+		alert, raised := maintenanceAlert()
+		if raised != tt.wantDue {
+			t.Fatalf("after %s the alert was raised: %v", tt.job, raised)
+		}
+		if raised && (alert.Status != AlertOpen || alert.Count != 1 || alert.PrinterID != "p1") {
+			t.Fatalf("after %s: %+v", tt.job, alert)
+		}
+	}
+	if other := component("other"); other.PrintHours != 0 || other.PrintGrams != 0 {
+		t.Fatalf("a component of another printer wore: %+v", other)
+	}
+
+	// Servicing resets the counters and resolves the alert
+	w := httptest.NewRecorder()
+	s.handleComponents(w, httptest.NewRequest(http.MethodPost, "/api/v1/components/nozzle/service", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("service: %d %s", w.Code, w.Body)
+	}
+	if nozzle := component("nozzle"); nozzle.PrintGrams != 0 || nozzle.PrintHours != 0 || nozzle.MaintenanceDue || nozzle.LastServicedAt == nil {
+		t.Fatalf("after servicing: %+v", nozzle)
+	}
+	if alert, _ := maintenanceAlert(); alert.Status != AlertResolved || alert.ResolvedAt == nil {
+		t.Fatalf("alert after servicing: %+v", alert)
+	}
+	if belt := component("belt"); belt.PrintGrams != 180 {
+		t.Fatalf("servicing the nozzle reset the belt: %+v", belt)
+	}
+}
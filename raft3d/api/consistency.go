@@ -0,0 +1,136 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"raft3d/raft"
+)
+
+// Headers describing the state a response was served from
+const (
+	appliedIndexHeader = "X-Raft-Applied-Index"
+	leaderHeader       = "X-Raft-Leader"
+)
+
+// minIndexTimeout is the longest a read waits for ?min_index= to be applied
+const minIndexTimeout = 5 * time.Second
+
+// maxLongPollWait caps ?wait= on list endpoints
+const maxLongPollWait = 60 * time.Second
+
+// longPollPrefixes maps the list endpoints that support long-polling to the
+// key prefix whose changes they wait for
+var longPollPrefixes = map[string]string{
+	"/api/v1/printers":       "printer_",
+	"/api/v1/filaments":      "filament_",
+	"/api/v1/print_jobs":     "printjob_",
+	"/api/v1/printer_groups": groupKeyPrefix,
+	"/api/v1/job_templates":  templateKeyPrefix,
+}
+
+// raftHeaderWriter stamps the applied index and leader on a response when its
+// header is written, so a write's response reports an index that includes it
+type raftHeaderWriter struct {
+	http.ResponseWriter
+	store       raft.Store
+	wroteHeader bool
+}
+
+func (w *raftHeaderWriter) WriteHeader(status int) {
+	if !w.wroteHeader {
+		w.wroteHeader = true
+		w.Header().Set(appliedIndexHeader, strconv.FormatUint(w.store.AppliedIndex(), 10))
+		if leader := w.store.LeaderInfo(); leader.ID != "" {
+			w.Header().Set(leaderHeader, leader.ID)
+		}
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *raftHeaderWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// Flush lets streaming handlers such as the event stream keep working
+func (w *raftHeaderWriter) Flush() {
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// consistency adds the applied index and leader to every response and makes
+// reads with ?min_index= wait until this node has caught up to that index
+func (s *Server) consistency(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hw := &raftHeaderWriter{ResponseWriter: w, store: s.store}
+
+		if raw := r.URL.Query().Get("min_index"); raw != "" && (r.Method == http.MethodGet || r.Method == http.MethodHead) {
+			minIndex, err := strconv.ParseUint(raw, 10, 64)
+			if err != nil {
+				writeValidationProblem(hw, r, []FieldError{{Name: "min_index", Reason: "must be a non-negative integer"}})
+				return
+			}
+			ctx, cancel := context.WithTimeout(r.Context(), minIndexTimeout)
+			err = s.store.WaitForIndex(ctx, minIndex)
+			cancel()
+			if errors.Is(err, raft.ErrTimeout) {
+				hw.Header().Set("Retry-After", strconv.Itoa(int(notLeaderRetryAfter.Seconds())))
+				writeError(hw, r, http.StatusServiceUnavailable, CodeStaleRead,
+					fmt.Sprintf("This node has not caught up to index %d yet; retry or read from the leader", minIndex))
+				return
+			}
+		}
+
+		next.ServeHTTP(hw, r)
+	})
+}
+
+// longPoll makes list requests with ?wait= and ?since_index= block until an
+// entity of that kind is written after since_index, then serves the list.
+// If nothing changes within wait the response is 304 Not Modified; either
+// way X-Raft-Applied-Index is the since_index to poll with next.
+func (s *Server) longPoll(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		prefix, ok := longPollPrefixes[r.URL.Path]
+		rawWait := r.URL.Query().Get("wait")
+		if !ok || rawWait == "" || r.Method != http.MethodGet {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		var errs []FieldError
+		wait, err := time.ParseDuration(rawWait)
+		if err != nil || wait <= 0 || wait > maxLongPollWait {
+			errs = append(errs, FieldError{Name: "wait", Reason: fmt.Sprintf("must be a duration such as 30s, at most %s", maxLongPollWait)})
+		}
+		since, err := strconv.ParseUint(r.URL.Query().Get("since_index"), 10, 64)
+		if err != nil {
+			errs = append(errs, FieldError{Name: "since_index", Reason: "must be a non-negative integer"})
+		}
+		if len(errs) > 0 {
+			writeValidationProblem(w, r, errs)
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), wait)
+		err = s.store.WaitForChange(ctx, prefix, since)
+		cancel()
+		if errors.Is(err, raft.ErrTimeout) {
+			if r.Context().Err() != nil {
+				return // the client went away
+			}
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,101 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"raft3d/testsupport"
+)
+
+func TestResponsesCarryAppliedIndexAndMinIndexReads(t *testing.T) {
+	c := testsupport.NewCluster(t, 1)
+	leader := c.WaitForLeader(10 * time.Second)
+	s := NewServer("", leader.Store)
+	post := s.consistency(http.HandlerFunc(s.handlePostPrinter))
+	list := s.consistency(http.HandlerFunc(s.handleGetPrinters))
+
+	// A write's response reports an index that includes the write
+	w := httptest.NewRecorder()
+	post.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/api/v1/printers", strings.NewReader(`{"id":"p1","name":"Prusa","status":"Idle"}`)))
+	if w.Code != http.StatusCreated {
+		t.Fatalf("create printer: %d %s", w.Code, w.Body)
+	}
+	page, err := leader.Store.ReadLog(0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var written uint64
+	for _, entry := range page.Entries {
+		if len(entry.Keys) > 0 && entry.Keys[0] == "printer_p1" {
+			written = entry.Index
+		}
+	}
+	index, err := strconv.ParseUint(w.Header().Get(appliedIndexHeader), 10, 64)
+	if err != nil || written == 0 || index < written || w.Header().Get(leaderHeader) != leader.ID {
+		t.Fatalf("write headers: %v, write at %d", w.Header(), written)
+	}
+
+	read := func(ctx context.Context, minIndex string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		list.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/v1/printers?min_index="+minIndex, nil).WithContext(ctx))
+		return w
+	}
+	if w := read(context.Background(), strconv.FormatUint(index, 10)); w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"p1"`) {
+		t.Fatalf("read at the write's index: %d %s", w.Code, w.Body)
+	}
+	if w := read(context.Background(), "latest"); w.Code != http.StatusBadRequest {
+		t.Fatalf("malformed min_index: %d %s", w.Code, w.Body)
+	}
+
+	// A node that hasn't reached the index says so rather than serving
+	// stale data
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	w = read(ctx, strconv.FormatUint(index+100, 10))
+	if w.Code != http.StatusServiceUnavailable || w.Header().Get("Retry-After") != "1" || !strings.Contains(w.Body.String(), CodeStaleRead) {
+		t.Fatalf("read ahead of the node: %d %v %s", w.Code, w.Header(), w.Body)
+	}
+}
+
+func TestLongPollWaitsForChangesOfItsKind(t *testing.T) {
+	c := testsupport.NewCluster(t, 1)
+	leader := c.WaitForLeader(10 * time.Second)
+	s := NewServer("", leader.Store)
+	list := s.longPoll(http.HandlerFunc(s.handleGetPrinters))
+	poll := func(query string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		list.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/v1/printers?"+query, nil))
+		return w
+	}
+	since := strconv.FormatUint(leader.Store.AppliedIndex(), 10)
+
+	// Writes of other kinds don't end the wait
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		leader.Store.Set("filament_f1", `{"id":"f1"}`)
+	}()
+	if w := poll("wait=300ms&since_index=" + since); w.Code != http.StatusNotModified {
+		t.Fatalf("poll with only a filament written: %d %s", w.Code, w.Body)
+	}
+
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		leader.Store.Set("printer_p1", `{"id":"p1","name":"Prusa","status":"Idle"}`)
+	}()
+	start := time.Now()
+	w := poll("wait=10s&since_index=" + since)
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"p1"`) || time.Since(start) > 5*time.Second {
+		t.Fatalf("poll with a printer written: %d after %s: %s", w.Code, time.Since(start), w.Body)
+	}
+
+	for _, query := range []string{"wait=2m&since_index=0", "wait=1s", "wait=soon&since_index=0"} {
+		if w := poll(query); w.Code != http.StatusBadRequest {
+			t.Fatalf("poll with %s: %d %s", query, w.Code, w.Body)
+		}
+	}
+}
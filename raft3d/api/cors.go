@@ -0,0 +1,94 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CORSConfig lets browser dashboards hosted elsewhere call the API
+type CORSConfig struct {
+	// AllowedOrigins lists origins such as "https://dash.example.com". "*"
+	// allows any origin and "https://*.example.com" any subdomain.
+	AllowedOrigins []string
+
+	// AllowedMethods and AllowedHeaders are offered to preflight requests
+	AllowedMethods []string
+	AllowedHeaders []string
+
+	// AllowCredentials lets browsers send cookies and Authorization headers
+	AllowCredentials bool
+
+	// MaxAge is how long browsers may cache a preflight response
+	MaxAge time.Duration
+}
+
+// corsExposedHeaders are response headers scripts may read
+var corsExposedHeaders = []string{appliedIndexHeader, leaderHeader, readOnlyHeader, "Retry-After", "Content-Disposition"}
+
+// EnableCORS answers cross-origin requests from the configured origins
+func (s *Server) EnableCORS(config CORSConfig) {
+	s.corsConfig = &config
+}
+
+// originAllowed reports whether an Origin header matches the config
+func (c *CORSConfig) originAllowed(origin string) bool {
+	for _, allowed := range c.AllowedOrigins {
+		if allowed == "*" || strings.EqualFold(allowed, origin) {
+			return true
+		}
+		if scheme, domain, ok := strings.Cut(allowed, "://*."); ok {
+			rest, found := strings.CutPrefix(strings.ToLower(origin), strings.ToLower(scheme)+"://")
+			if found && strings.HasSuffix(rest, "."+strings.ToLower(domain)) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// cors adds CORS headers for allowed origins and answers preflight requests
+// before they reach authentication, since browsers send them without keys
+func (s *Server) cors(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c := s.corsConfig
+		origin := r.Header.Get("Origin")
+		if c == nil || origin == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		w.Header().Add("Vary", "Origin")
+		if !c.originAllowed(origin) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		// A wildcard origin can't be combined with credentials, so the
+		// caller's origin is echoed instead
+		allowOrigin := origin
+		if len(c.AllowedOrigins) == 1 && c.AllowedOrigins[0] == "*" && !c.AllowCredentials {
+			allowOrigin = "*"
+		}
+		w.Header().Set("Access-Control-Allow-Origin", allowOrigin)
+		if c.AllowCredentials {
+			w.Header().Set("Access-Control-Allow-Credentials", "true")
+		}
+
+		if r.Method != http.MethodOptions || r.Header.Get("Access-Control-Request-Method") == "" {
+			w.Header().Set("Access-Control-Expose-Headers", strings.Join(corsExposedHeaders, ", "))
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		// Preflight
+		w.Header().Add("Vary", "Access-Control-Request-Method")
+		w.Header().Add("Vary", "Access-Control-Request-Headers")
+		w.Header().Set("Access-Control-Allow-Methods", strings.Join(c.AllowedMethods, ", "))
+		w.Header().Set("Access-Control-Allow-Headers", strings.Join(c.AllowedHeaders, ", "))
+		if c.MaxAge > 0 {
+			w.Header().Set("Access-Control-Max-Age", strconv.Itoa(int(c.MaxAge.Seconds())))
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
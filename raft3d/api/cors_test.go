@@ -0,0 +1,84 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestCORS(t *testing.T) {
+	tests := []struct {
+		name        string
+		config      CORSConfig
+		origin      string
+		preflight   bool
+		allowOrigin string
+		reached     bool // the request got through to the API
+	}{
+		{"exact origin", CORSConfig{AllowedOrigins: []string{"https://dash.example.com"}}, "https://dash.example.com", false, "https://dash.example.com", true},
+		{"origin case", CORSConfig{AllowedOrigins: []string{"https://dash.example.com"}}, "HTTPS://Dash.Example.com", false, "HTTPS://Dash.Example.com", true},
+		{"other origin", CORSConfig{AllowedOrigins: []string{"https://dash.example.com"}}, "https://evil.example.org", false, "", true},
+		{"subdomain", CORSConfig{AllowedOrigins: []string{"https://*.example.com"}}, "https://a.b.example.com", false, "https://a.b.example.com", true},
+		{"subdomain wildcard excludes the domain", CORSConfig{AllowedOrigins: []string{"https://*.example.com"}}, "https://example.com", false, "", true},
+		{"subdomain over another scheme", CORSConfig{AllowedOrigins: []string{"https://*.example.com"}}, "http://a.example.com", false, "", true},
+		{"any origin", CORSConfig{AllowedOrigins: []string{"*"}}, "https://anywhere.test", false, "*", true},
+		{"any origin with credentials", CORSConfig{AllowedOrigins: []string{"*"}, AllowCredentials: true}, "https://anywhere.test", false, "https://anywhere.test", true},
+		{"preflight", CORSConfig{AllowedOrigins: []string{"*"}}, "https://anywhere.test", true, "*", false},
+		{"preflight from another origin", CORSConfig{AllowedOrigins: []string{"https://dash.example.com"}}, "https://evil.example.org", true, "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewServer("", nil)
+			tt.config.AllowedMethods = []string{"GET", "POST"}
+			tt.config.AllowedHeaders = []string{"Authorization", "Content-Type"}
+			tt.config.MaxAge = 10 * time.Minute
+			s.EnableCORS(tt.config)
+
+			reached := false
+			handler := s.cors(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { reached = true }))
+			r := httptest.NewRequest(http.MethodGet, "/api/v1/printers", nil)
+			if tt.preflight {
+				r.Method = http.MethodOptions
+				r.Header.Set("Access-Control-Request-Method", "POST")
+			}
+			r.Header.Set("Origin", tt.origin)
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+
+			if got := w.Header().Get("Access-Control-Allow-Origin"); got != tt.allowOrigin {
+				t.Fatalf("Access-Control-Allow-Origin = %q, want %q", got, tt.allowOrigin)
+			}
+			if reached != tt.reached {
+				t.Fatalf("reached the API = %v, want %v", reached, tt.reached)
+			}
+			if w.Header().Get("Vary") != "Origin" && !tt.preflight {
+				t.Fatalf("Vary = %v", w.Header().Values("Vary"))
+			}
+			if got := w.Header().Get("Access-Control-Allow-Credentials"); (got == "true") != (tt.config.AllowCredentials && tt.allowOrigin != "") {
+				t.Fatalf("Access-Control-Allow-Credentials = %q", got)
+			}
+			switch {
+			case tt.preflight && !tt.reached:
+				if w.Code != http.StatusNoContent || w.Header().Get("Access-Control-Allow-Methods") != "GET, POST" ||
+					w.Header().Get("Access-Control-Allow-Headers") != "Authorization, Content-Type" || w.Header().Get("Access-Control-Max-Age") != "600" {
+					t.Fatalf("preflight: %d %v", w.Code, w.Header())
+				}
+			case tt.allowOrigin != "":
+				if w.Header().Get("Access-Control-Expose-Headers") == "" {
+					t.Fatalf("no exposed headers: %v", w.Header())
+				}
+			}
+		})
+	}
+
+	// Without a config, cross-origin requests pass through untouched
+	s := NewServer("", nil)
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/api/v1/printers", nil)
+	r.Header.Set("Origin", "https://dash.example.com")
+	s.cors(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})).ServeHTTP(w, r)
+	if len(w.Header()) != 0 {
+		t.Fatalf("headers without CORS enabled: %v", w.Header())
+	}
+}
@@ -0,0 +1,46 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	"raft3d/raft"
+)
+
+// requestTimeoutHeader lets clients bound how long a request may take, as a
+// duration such as "2s" or a number of seconds. Writes that aren't
+// replicated in time fail with 504 replication_timeout.
+const requestTimeoutHeader = "X-Request-Timeout"
+
+// storeFor returns the store bound to the request's context, so writes stop
+// waiting for replication once the client's deadline passes or it goes away
+func (s *Server) storeFor(r *http.Request) raft.Store {
+	return s.store.WithContext(r.Context())
+}
+
+// requestDeadline applies X-Request-Timeout to the request's context
+func (s *Server) requestDeadline(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		value := r.Header.Get(requestTimeoutHeader)
+		if value == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		timeout, err := time.ParseDuration(value)
+		if err != nil {
+			seconds, serr := strconv.ParseFloat(value, 64)
+			timeout, err = time.Duration(seconds*float64(time.Second)), serr
+		}
+		if err != nil || timeout <= 0 {
+			writeValidationProblem(w, r, []FieldError{{Name: requestTimeoutHeader, Reason: "must be a positive duration such as 2s"}})
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), timeout)
+		defer cancel()
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
@@ -0,0 +1,88 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"raft3d/testsupport"
+)
+
+func TestRequestTimeoutHeader(t *testing.T) {
+	tests := []struct {
+		value string
+		want  time.Duration // 0 when the request gets no deadline
+		valid bool
+	}{
+		{"", 0, true},
+		{"2s", 2 * time.Second, true},
+		{"1.5", 1500 * time.Millisecond, true},
+		{"0", 0, false},
+		{"-1s", 0, false},
+		{"soon", 0, false},
+	}
+	s := NewServer("", nil)
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			var got time.Duration
+			handler := s.requestDeadline(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if deadline, ok := r.Context().Deadline(); ok {
+					got = time.Until(deadline)
+				}
+			}))
+			r := httptest.NewRequest(http.MethodGet, "/api/v1/printers", nil)
+			r.Header.Set(requestTimeoutHeader, tt.value)
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+
+			if !tt.valid {
+				if w.Code != http.StatusBadRequest {
+					t.Fatalf("got %d, want 400", w.Code)
+				}
+				return
+			}
+			if got > tt.want || got < tt.want-time.Second {
+				t.Fatalf("deadline in %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWritesStopAtTheRequestDeadline(t *testing.T) {
+	c := testsupport.NewCluster(t, 1)
+	leader := c.WaitForLeader(10 * time.Second)
+	s := NewServer("", leader.Store)
+	post := func(ctx context.Context, id string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodPost, "/api/v1/printers", strings.NewReader(`{"id":"`+id+`","name":"Prusa","status":"Idle"}`))
+		s.handlePostPrinter(w, r.WithContext(ctx))
+		return w
+	}
+
+	// A deadline that has passed is a replication timeout
+	expired, cancel := context.WithDeadline(context.Background(), time.Now().Add(-time.Second))
+	defer cancel()
+	if w := post(expired, "p1"); w.Code != http.StatusGatewayTimeout || !strings.Contains(w.Body.String(), CodeReplicationTimeout) {
+		t.Fatalf("write past its deadline: %d %s", w.Code, w.Body)
+	}
+	// A client that went away gets no response
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+	if w := post(canceled, "p2"); w.Body.Len() != 0 {
+		t.Fatalf("write for a client that went away: %d %s", w.Code, w.Body)
+	}
+	for _, id := range []string{"p1", "p2"} {
+		if _, err := s.getPrinter(id); err == nil {
+			t.Fatalf("abandoned write of %s was applied", id)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if w := post(ctx, "p3"); w.Code != http.StatusCreated {
+		t.Fatalf("write within its deadline: %d %s", w.Code, w.Body)
+	}
+}
@@ -0,0 +1,210 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"expvar"
+	"log"
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"net/netip"
+	"runtime"
+	"sort"
+	"strings"
+)
+
+// goroutineGroupsShown bounds the groups in a goroutine summary
+const goroutineGroupsShown = 50
+
+// GoroutineGroup counts goroutines in the same state whose stacks start in
+// the same function
+type GoroutineGroup struct {
+	Function string `json:"function"`
+	State    string `json:"state"`
+	Count    int    `json:"count"`
+
+	// CreatedBy is where the goroutines were started, which usually tells
+	// leaking ones apart
+	CreatedBy string `json:"created_by,omitempty"`
+}
+
+// GoroutineSummary describes the goroutines and memory of this process
+type GoroutineSummary struct {
+	Goroutines    int              `json:"goroutines"`
+	GOMAXPROCS    int              `json:"gomaxprocs"`
+	HeapAllocMB   float64          `json:"heap_alloc_mb"`
+	HeapObjects   uint64           `json:"heap_objects"`
+	NumGC         uint32           `json:"num_gc"`
+	LastGCPauseMs float64          `json:"last_gc_pause_ms"`
+	Groups        []GoroutineGroup `json:"groups"` // the 50 largest
+}
+
+// EnableDebugListener serves the /debug endpoints, without authentication,
+// on a listener of their own, which must be on a loopback address
+func (s *Server) EnableDebugListener(addr string) {
+	s.debugAddr = addr
+}
+
+// debugMux serves pprof profiles under /debug/pprof/ and expvar variables at
+// /debug/vars
+func debugMux() *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	mux.Handle("/debug/vars", expvar.Handler())
+	return mux
+}
+
+// handleDebug guards the /debug endpoints on the main listener. They reveal
+// the process's memory and command line, so they need an admin API key when
+// authentication is on, and otherwise only answer callers on this host.
+func (s *Server) handleDebug(debug http.Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if s.authEnabled() {
+			if principal, ok := s.lookupKey(requestKey(r)); !ok || principal.Role != RoleAdmin {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="raft3d"`)
+				writeError(w, r, http.StatusUnauthorized, CodeUnauthorized, "The debug endpoints require an admin API key")
+				return
+			}
+		} else if !fromLoopback(r) {
+			writeError(w, r, http.StatusForbidden, CodeForbidden,
+				"Without authentication the debug endpoints only answer requests from this host")
+			return
+		}
+		debug.ServeHTTP(w, r)
+	}
+}
+
+// fromLoopback reports whether a request came from this host
+func fromLoopback(r *http.Request) bool {
+	if viaUnixSocket(r) {
+		return true
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return false
+	}
+	addr, err := netip.ParseAddr(host)
+	return err == nil && addr.Unmap().IsLoopback()
+}
+
+// startDebugListener serves the debug endpoints on their own loopback
+// listener until the server stops
+func (s *Server) startDebugListener() error {
+	host, _, err := net.SplitHostPort(s.debugAddr)
+	if err != nil {
+		return err
+	}
+	if addr, err := netip.ParseAddr(host); (err != nil || !addr.IsLoopback()) && host != "localhost" {
+		return &net.AddrError{Err: "the debug listener must be on a loopback address", Addr: s.debugAddr}
+	}
+	listener, err := net.Listen("tcp", s.debugAddr)
+	if err != nil {
+		return err
+	}
+
+	s.debugSrv = &http.Server{Handler: debugMux()}
+	log.Printf("Serving debug endpoints at %s\n", s.debugAddr)
+	go func() {
+		if err := s.debugSrv.Serve(listener); !isClosed(err) {
+			log.Printf("Debug listener error: %s", err)
+		}
+	}()
+	return nil
+}
+
+// handleGoroutines handles GET /api/v1/admin/goroutines, a summary of the
+// goroutines this node runs, grouped by where their stacks start, and its
+// heap. A group that keeps growing on a long-running node is a leak; a
+// goroutine profile from /debug/pprof/goroutine has the full stacks.
+func (s *Server) handleGoroutines(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, r)
+		return
+	}
+	if !s.requireRole(w, r, RoleAdmin) {
+		return
+	}
+
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+	summary := GoroutineSummary{
+		Goroutines:  runtime.NumGoroutine(),
+		GOMAXPROCS:  runtime.GOMAXPROCS(0),
+		HeapAllocMB: float64(mem.HeapAlloc) / (1 << 20),
+		HeapObjects: mem.HeapObjects,
+		NumGC:       mem.NumGC,
+		Groups:      groupGoroutines(goroutineStacks()),
+	}
+	if mem.NumGC > 0 {
+		summary.LastGCPauseMs = float64(mem.PauseNs[(mem.NumGC+255)%256]) / 1e6
+	}
+	if len(summary.Groups) > goroutineGroupsShown {
+		summary.Groups = summary.Groups[:goroutineGroupsShown]
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(summary)
+}
+
+// goroutineStacks returns the stacks of every goroutine, in the format of an
+// unrecovered panic
+func goroutineStacks() []byte {
+	buf := make([]byte, 1<<16)
+	for {
+		n := runtime.Stack(buf, true)
+		if n < len(buf) {
+			return buf[:n]
+		}
+		buf = make([]byte, 2*len(buf))
+	}
+}
+
+// groupGoroutines counts goroutines by state, top function and creator,
+// largest groups first
+func groupGoroutines(stacks []byte) []GoroutineGroup {
+	counts := make(map[GoroutineGroup]int)
+	for _, stack := range bytes.Split(stacks, []byte("\n\n")) {
+		lines := strings.Split(strings.TrimSpace(string(stack)), "\n")
+		// goroutine 7 [chan receive, 3 minutes]:
+		header := lines[0]
+		open, end := strings.IndexByte(header, '['), strings.LastIndexByte(header, ']')
+		if !strings.HasPrefix(header, "goroutine ") || open < 0 || end < open || len(lines) < 2 {
+			continue
+		}
+		var group GoroutineGroup
+		group.State, _, _ = strings.Cut(header[open+1:end], ",")
+		group.Function = stackFunction(lines[1])
+		for _, line := range lines {
+			if creator, ok := strings.CutPrefix(line, "created by "); ok {
+				group.CreatedBy, _, _ = strings.Cut(creator, " in goroutine")
+			}
+		}
+		counts[group]++
+	}
+
+	groups := make([]GoroutineGroup, 0, len(counts))
+	for group, count := range counts {
+		group.Count = count
+		groups = append(groups, group)
+	}
+	sort.Slice(groups, func(i, j int) bool {
+		if groups[i].Count != groups[j].Count {
+			return groups[i].Count > groups[j].Count
+		}
+		return groups[i].Function < groups[j].Function
+	})
+	return groups
+}
+
+// stackFunction strips the arguments from a stack frame's function line
+func stackFunction(line string) string {
+	if i := strings.LastIndexByte(line, '('); i > 0 {
+		return line[:i]
+	}
+	return line
+}
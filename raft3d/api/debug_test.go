@@ -0,0 +1,66 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestGroupGoroutines(t *testing.T) {
+	stacks := `goroutine 1 [running]:
+main.main()
+	/src/main.go:10 +0x1d
+
+goroutine 7 [chan receive, 3 minutes]:
+raft3d/api.(*Server).runTasks(0xc000120000)
+	/src/api/tasks.go:40 +0x85
+created by raft3d/api.(*Server).Start in goroutine 1
+	/src/api/server.go:200 +0x3f
+
+goroutine 8 [chan receive]:
+raft3d/api.(*Server).runTasks(0xc000120100)
+	/src/api/tasks.go:40 +0x85
+created by raft3d/api.(*Server).Start in goroutine 1
+	/src/api/server.go:200 +0x3f
+`
+	groups := groupGoroutines([]byte(stacks))
+	if len(groups) != 2 {
+		t.Fatalf("groups = %+v", groups)
+	}
+	want := GoroutineGroup{Function: "raft3d/api.(*Server).runTasks", State: "chan receive", Count: 2, CreatedBy: "raft3d/api.(*Server).Start"}
+	if groups[0] != want || groups[1].Function != "main.main" || groups[1].State != "running" {
+		t.Fatalf("groups = %+v", groups)
+	}
+}
+
+func TestDebugEndpointsGuarded(t *testing.T) {
+	s := NewServer("", nil)
+	handler := s.handleDebug(debugMux())
+	get := func(remote, key string) int {
+		rec := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "/debug/vars", nil)
+		r.RemoteAddr = remote
+		if key != "" {
+			r.Header.Set("X-API-Key", key)
+		}
+		handler(rec, r)
+		return rec.Code
+	}
+
+	if code := get("127.0.0.1:5000", ""); code != http.StatusOK {
+		t.Fatalf("local request: %d", code)
+	}
+	if code := get("192.0.2.10:5000", ""); code != http.StatusForbidden {
+		t.Fatalf("remote request without auth: %d", code)
+	}
+
+	if err := s.EnableAuth([]APIKey{{Key: "admin-key", Principal: Principal{Name: "ops", Role: RoleAdmin}}, {Key: "member-key", Principal: Principal{Name: "bob", Role: RoleMember}}}); err != nil {
+		t.Fatal(err)
+	}
+	if code := get("192.0.2.10:5000", "admin-key"); code != http.StatusOK {
+		t.Fatalf("admin: %d", code)
+	}
+	if code := get("127.0.0.1:5000", "member-key"); code != http.StatusUnauthorized {
+		t.Fatalf("member: %d", code)
+	}
+}
@@ -0,0 +1,108 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"raft3d/raft"
+)
+
+// validateDependencies checks that every job a new job depends on exists
+func (s *Server) validateDependencies(job PrintJob) []FieldError {
+	seen := make(map[string]bool)
+	for _, dep := range job.DependsOn {
+		switch {
+		case dep == job.ID:
+			return []FieldError{{Name: "depends_on", Reason: "a job cannot depend on itself"}}
+		case seen[dep]:
+			return []FieldError{{Name: "depends_on", Reason: fmt.Sprintf("job %s is listed twice", dep)}}
+		}
+		seen[dep] = true
+		if _, err := s.store.Get("printjob_" + dep); err != nil {
+			return []FieldError{{Name: "depends_on", Reason: fmt.Sprintf("print job %s does not exist", dep)}}
+		}
+	}
+	return nil
+}
+
+// pendingDependencies returns the dependencies of a job that aren't Done
+func (s *Server) pendingDependencies(job PrintJob) ([]string, error) {
+	var pending []string
+	for _, dep := range job.DependsOn {
+		value, err := s.store.Get("printjob_" + dep)
+		if err != nil {
+			return nil, err
+		}
+		var depJob PrintJob
+		if err := json.Unmarshal([]byte(value), &depJob); err != nil {
+			return nil, err
+		}
+		if depJob.Status != "Done" {
+			pending = append(pending, dep)
+		}
+	}
+	return pending, nil
+}
+
+// dependencyConditions makes the FSM re-check that every dependency is Done
+// when a job is started, so a dependency can't be reverted or canceled
+// between the check and the write
+func dependencyConditions(job PrintJob) []raft.Condition {
+	var conditions []raft.Condition
+	for _, dep := range job.DependsOn {
+		conditions = append(conditions, raft.Condition{Key: "printjob_" + dep, Field: "status", Equals: "Done"})
+	}
+	return conditions
+}
+
+// cascadeCancel cancels every active job that depends, directly or through
+// other jobs, on the given job. It returns the IDs it canceled.
+func (s *Server) cascadeCancel(ctx context.Context, jobID string) ([]string, error) {
+	jobs, err := s.listPrintJobs()
+	if err != nil {
+		return nil, err
+	}
+
+	dependents := make(map[string][]PrintJob)
+	for _, job := range jobs {
+		for _, dep := range job.DependsOn {
+			dependents[dep] = append(dependents[dep], job)
+		}
+	}
+
+	canceled := []string{}
+	visited := map[string]bool{jobID: true}
+	queue := []string{jobID}
+	for len(queue) > 0 {
+		id := queue[0]
+		queue = queue[1:]
+		for _, job := range dependents[id] {
+			if visited[job.ID] {
+				continue
+			}
+			visited[job.ID] = true
+			queue = append(queue, job.ID)
+			if !job.isActive() {
+				continue
+			}
+
+			job.Status = "Canceled"
+			job.syncPrintStep(time.Now().UTC())
+			body, err := json.Marshal(job)
+			if err != nil {
+				return canceled, err
+			}
+			values := map[string]string{"printjob_" + job.ID: string(body)}
+			if err := s.notifyJobStatus(values, job); err != nil {
+				return canceled, err
+			}
+			if err := s.store.WithContext(ctx).SetMany(values); err != nil {
+				return canceled, err
+			}
+			canceled = append(canceled, job.ID)
+		}
+	}
+	return canceled, nil
+}
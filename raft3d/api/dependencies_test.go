@@ -0,0 +1,80 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"raft3d/testsupport"
+)
+
+func TestJobsStartOnlyAfterTheirDependencies(t *testing.T) {
+	c := testsupport.NewCluster(t, 1)
+	leader := c.WaitForLeader(10 * time.Second)
+	s := NewServer("", leader.Store)
+
+	put := func(key string, v interface{}) {
+		body, _ := json.Marshal(v)
+		if err := leader.Store.Set(key, string(body)); err != nil {
+			t.Fatalf("set %s: %s", key, err)
+		}
+	}
+	put("printer_p1", Printer{ID: "p1", Name: "Prusa", Status: "Idle"})
+	put("filament_f1", Filament{ID: "f1", Name: "PLA", Type: "PLA", TotalWeightInGrams: 1000, RemainingWeightInGrams: 1000})
+	job := func(id string, deps ...string) PrintJob {
+		return PrintJob{ID: id, PrinterID: "p1", FilamentID: "f1", FilePath: id + ".gcode", PrintWeightInGrams: 10, Status: "Queued", DependsOn: deps}
+	}
+	put("printjob_base", job("base"))
+	put("printjob_lid", job("lid", "base"))
+	put("printjob_label", job("label", "lid"))
+	put("printjob_stand", job("stand", "base"))
+
+	status := func(id, to string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		s.handleUpdatePrintJobStatus(w, httptest.NewRequest(http.MethodPost, "/api/v1/print_jobs/"+id+"/status?"+to, nil), id)
+		return w
+	}
+
+	// Dependencies must exist and can't repeat or include the job itself
+	for _, deps := range []string{`["missing"]`, `["base","base"]`, `["self"]`} {
+		w := httptest.NewRecorder()
+		body := `{"id":"self","printer_id":"p1","filament_id":"f1","filepath":"x.gcode","print_weight_in_grams":10,"depends_on":` + deps + `}`
+		s.handlePostPrintJob(w, httptest.NewRequest(http.MethodPost, "/api/v1/print_jobs", strings.NewReader(body)))
+		if w.Code != http.StatusBadRequest {
+			t.Fatalf("depends_on %s: %d %s", deps, w.Code, w.Body)
+		}
+	}
+
+	if w := status("lid", "status=Running"); w.Code != http.StatusConflict || !strings.Contains(w.Body.String(), CodeDependenciesPending) {
+		t.Fatalf("lid before base is Done: %d %s", w.Code, w.Body)
+	}
+	for _, to := range []string{"Running", "Done"} {
+		if w := status("base", "status="+to); w.Code != http.StatusOK {
+			t.Fatalf("base to %s: %d %s", to, w.Code, w.Body)
+		}
+	}
+	if w := status("lid", "status=Running"); w.Code != http.StatusOK {
+		t.Fatalf("lid after base is Done: %d %s", w.Code, w.Body)
+	}
+
+	// Canceling with cascade cancels what depends on the job, and only that
+	w := status("lid", "status=Canceled&cascade=true")
+	if w.Code != http.StatusOK {
+		t.Fatalf("cancel lid: %d %s", w.Code, w.Body)
+	}
+	var response struct {
+		Canceled []string `json:"canceled_dependents"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &response)
+	if strings.Join(response.Canceled, ",") != "label" {
+		t.Fatalf("canceled dependents: %v", response.Canceled)
+	}
+	for id, want := range map[string]string{"label": "Canceled", "stand": "Queued", "base": "Done"} {
+		if job, err := s.getPrintJob(id); err != nil || job.Status != want {
+			t.Errorf("%s: %+v %v, want %s", id, job, err, want)
+		}
+	}
+}
@@ -0,0 +1,197 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"raft3d/raft"
+)
+
+// EventStateDivergence is published when a peer's state digest differs from
+// this node's at the same log index
+const EventStateDivergence = "cluster.state_divergence"
+
+// divergencesKept bounds the divergences a node remembers
+const divergencesKept = 20
+
+// Divergence is a log index at which a peer's state digest differed from
+// this node's
+type Divergence struct {
+	PeerID     string    `json:"peer_id"`
+	PeerAddr   string    `json:"peer_addr"`
+	Index      uint64    `json:"index"`
+	Digest     string    `json:"digest"`
+	PeerDigest string    `json:"peer_digest"`
+	DetectedAt time.Time `json:"detected_at"`
+}
+
+// DeterminismReport is a node's latest state digest and what its checks of
+// the other nodes found
+type DeterminismReport struct {
+	NodeID      string       `json:"node_id,omitempty"`
+	Index       uint64       `json:"index"`
+	Digest      string       `json:"digest"`
+	Checks      uint64       `json:"checks"`
+	Divergences []Divergence `json:"divergences"` // the last 20, newest last
+}
+
+// determinismAudit periodically compares this node's state digests with the
+// other nodes'
+type determinismAudit struct {
+	interval time.Duration
+
+	mu          sync.Mutex
+	checks      uint64
+	divergences []Divergence
+	flagged     map[string]uint64 // the index each peer last diverged at
+}
+
+// EnableDeterminismAudit compares state digests with every other node each
+// interval, logging and publishing an event when one differs. The store
+// must have been opened with DeterminismAudit.
+func (s *Server) EnableDeterminismAudit(interval time.Duration) {
+	s.determinism = &determinismAudit{interval: interval, flagged: make(map[string]uint64)}
+}
+
+// handleClusterDigest handles GET /cluster/digest, which other nodes poll
+// to compare states. ?index= asks for the digest after an earlier entry
+// instead of the latest one.
+func (s *Server) handleClusterDigest(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, r)
+		return
+	}
+	if !s.requireClusterMember(w, r) {
+		return
+	}
+	if s.determinism == nil {
+		writeError(w, r, http.StatusNotFound, CodeNotFound, "The determinism audit is not enabled on this node")
+		return
+	}
+
+	var index uint64
+	if value := r.URL.Query().Get("index"); value != "" {
+		var err error
+		if index, err = strconv.ParseUint(value, 10, 64); err != nil {
+			writeError(w, r, http.StatusBadRequest, CodeMalformedRequest, "index must be a log index")
+			return
+		}
+	}
+	digest, ok := s.store.StateDigest(index)
+	if !ok {
+		writeError(w, r, http.StatusNotFound, CodeNotFound, fmt.Sprintf("No state digest is kept for index %d", index))
+		return
+	}
+
+	s.determinism.mu.Lock()
+	report := DeterminismReport{
+		NodeID:      s.NodeID,
+		Index:       digest.Index,
+		Digest:      digest.Digest,
+		Checks:      s.determinism.checks,
+		Divergences: append([]Divergence{}, s.determinism.divergences...),
+	}
+	s.determinism.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
+
+// runDeterminismAudit checks the other nodes each interval until the server
+// stops
+func (s *Server) runDeterminismAudit() {
+	client := &http.Client{Timeout: 5 * time.Second}
+	if s.tls != nil {
+		client.Transport = &http.Transport{TLSClientConfig: s.tls.ClientConfig("")}
+	}
+	ticker := time.NewTicker(s.determinism.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-s.stopCh:
+			return
+		}
+
+		nodes, err := loadAll[raft.NodeInfo](s, "node_")
+		if err != nil {
+			log.Printf("Determinism audit: failed to list nodes: %s", err)
+			continue
+		}
+		for _, node := range nodes {
+			if node.ID == s.NodeID || node.HTTPAddr == "" || node.HTTPAddr == s.advertiseAddr() {
+				continue
+			}
+			if err := s.checkDeterminism(client, node); err != nil {
+				log.Printf("Determinism audit: %s: %s", node.ID, err)
+			}
+		}
+	}
+}
+
+// checkDeterminism compares a peer's latest state digest with this node's
+// at the same index. A peer ahead of the digests this node keeps, or behind
+// them, is checked again next time.
+func (s *Server) checkDeterminism(client *http.Client, peer raft.NodeInfo) error {
+	req, err := http.NewRequest(http.MethodGet, fmt.Sprintf("%s://%s/cluster/digest", s.scheme(), peer.HTTPAddr), nil)
+	if err != nil {
+		return err
+	}
+	if s.joinAPIKey != "" {
+		req.Header.Set("X-API-Key", s.joinAPIKey)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("digest request failed: %s", resp.Status)
+	}
+	var theirs DeterminismReport
+	if err := json.NewDecoder(resp.Body).Decode(&theirs); err != nil {
+		return err
+	}
+
+	ours, ok := s.store.StateDigest(theirs.Index)
+	if !ok {
+		return nil
+	}
+	a := s.determinism
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.checks++
+	if ours.Digest == theirs.Digest || a.flagged[peer.ID] == theirs.Index {
+		return nil
+	}
+
+	a.flagged[peer.ID] = theirs.Index
+	divergence := Divergence{
+		PeerID:     peer.ID,
+		PeerAddr:   peer.HTTPAddr,
+		Index:      theirs.Index,
+		Digest:     ours.Digest,
+		PeerDigest: theirs.Digest,
+		DetectedAt: time.Now().UTC(),
+	}
+	a.divergences = append(a.divergences, divergence)
+	if len(a.divergences) > divergencesKept {
+		a.divergences = a.divergences[len(a.divergences)-divergencesKept:]
+	}
+	log.Printf("Determinism audit: state diverged from %s at index %d (digest %s here, %s there)",
+		peer.ID, theirs.Index, ours.Digest, theirs.Digest)
+	if s.events != nil {
+		s.events.Publish(EventStateDivergence, map[string]string{
+			"peer_id":     peer.ID,
+			"index":       strconv.FormatUint(theirs.Index, 10),
+			"digest":      ours.Digest,
+			"peer_digest": theirs.Digest,
+		})
+	}
+	return nil
+}
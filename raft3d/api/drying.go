@@ -0,0 +1,91 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"raft3d/raft"
+)
+
+// isHygroscopic reports whether the filament absorbs moisture and so needs
+// drying before use, because the spool is flagged or its material is
+func (s *Server) isHygroscopic(filament Filament) bool {
+	if filament.Hygroscopic {
+		return true
+	}
+	material, err := s.getMaterial(filament.Type)
+	return err == nil && material.Hygroscopic
+}
+
+// needsDrying reports whether a hygroscopic spool was last dried longer than
+// the configured maximum age before now, or never
+func (s *Server) needsDrying(filament Filament, now time.Time) bool {
+	if s.dryingMaxAge <= 0 || !s.isHygroscopic(filament) {
+		return false
+	}
+	return filament.LastDriedAt == nil || now.Sub(*filament.LastDriedAt) > s.dryingMaxAge
+}
+
+// EnableDryingCheck rejects jobs on hygroscopic filament last dried more than
+// maxAge ago, unless the job sets ignore_drying
+func (s *Server) EnableDryingCheck(maxAge time.Duration) {
+	s.dryingMaxAge = maxAge
+}
+
+// dryingProblem explains why a job was refused a spool that needs drying
+func dryingProblem(filament Filament) *Problem {
+	detail := fmt.Sprintf("Filament %s (%s) is humidity sensitive and has never been dried", filament.ID, filament.Type)
+	if filament.LastDriedAt != nil {
+		detail = fmt.Sprintf("Filament %s (%s) is humidity sensitive and was last dried at %s",
+			filament.ID, filament.Type, filament.LastDriedAt.Format(time.RFC3339))
+	}
+	return errorProblem(http.StatusConflict, CodeFilamentNeedsDrying, detail+"; dry it or set ignore_drying")
+}
+
+// FilamentDrying is the optional body of POST /filaments/{id}/dried
+type FilamentDrying struct {
+	DriedAt *time.Time `json:"dried_at,omitempty"`
+}
+
+// handleFilamentDried handles POST /filaments/{id}/dried, recording that the
+// spool has been dried, now or at dried_at
+func (s *Server) handleFilamentDried(w http.ResponseWriter, r *http.Request, filamentID string) {
+	var drying FilamentDrying
+	if r.ContentLength != 0 && !decodeJSON(w, r, &drying) {
+		return
+	}
+	now := time.Now().UTC()
+	driedAt := now
+	if drying.DriedAt != nil {
+		if drying.DriedAt.After(now) {
+			writeValidationProblem(w, r, []FieldError{{Name: "dried_at", Reason: "must not be in the future"}})
+			return
+		}
+		driedAt = drying.DriedAt.UTC()
+	}
+
+	filament, err := s.getFilament(filamentID)
+	if err != nil {
+		s.writeStoreError(w, r, err, "Filament not found")
+		return
+	}
+	filament.LastDriedAt = &driedAt
+
+	// Condition on the weight read so a concurrent deduction isn't lost
+	body, err := json.Marshal(filament)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, CodeInternal, "Failed to process filament data")
+		return
+	}
+	err = s.storeFor(r).SetIf("filament_"+filament.ID, string(body), raft.Condition{
+		Key: "filament_" + filament.ID, Field: "remaining_weight_in_grams", Equals: fmt.Sprint(filament.RemainingWeightInGrams),
+	})
+	if err != nil {
+		s.writeStoreError(w, r, err, "Failed to update filament")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(body)
+}
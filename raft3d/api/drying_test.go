@@ -0,0 +1,98 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"raft3d/testsupport"
+)
+
+func TestDryingCheck(t *testing.T) {
+	c := testsupport.NewCluster(t, 1)
+	leader := c.WaitForLeader(10 * time.Second)
+	s := NewServer("", leader.Store)
+	s.EnableDryingCheck(24 * time.Hour)
+
+	put := func(key string, v interface{}) {
+		body, _ := json.Marshal(v)
+		if err := leader.Store.Set(key, string(body)); err != nil {
+			t.Fatalf("set %s: %s", key, err)
+		}
+	}
+	lastWeek := time.Now().UTC().Add(-7 * 24 * time.Hour)
+	put("printer_p1", Printer{ID: "p1", Name: "Prusa", Status: "Idle"})
+	put("filament_f1", Filament{ID: "f1", Name: "Nylon", Type: "PA", TotalWeightInGrams: 1000, RemainingWeightInGrams: 1000,
+		Hygroscopic: true, LastDriedAt: &lastWeek})
+	post := func(id, extra string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		s.handlePostPrintJob(w, httptest.NewRequest(http.MethodPost, "/api/v1/print_jobs",
+			strings.NewReader(`{"id":"`+id+`","printer_id":"p1","filament_id":"f1","filepath":"a.gcode","print_weight_in_grams":10`+extra+`}`)))
+		return w
+	}
+	audits := func() []AuditEntry {
+		all, err := loadAll[AuditEntry](s, auditKeyPrefix)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var entries []AuditEntry
+		for _, entry := range all {
+			entries = append(entries, entry)
+		}
+		return entries
+	}
+
+	// A spool dried a week ago is refused
+	if w := post("j1", ""); w.Code != http.StatusConflict || !strings.Contains(w.Body.String(), CodeFilamentNeedsDrying) {
+		t.Fatalf("job on a damp spool: %d %s", w.Code, w.Body)
+	}
+
+	// unless the job overrides the check, which is audited in the same
+	// log entry as the job
+	w := post("j2", `,"ignore_drying":true`)
+	var job PrintJob
+	if w.Code != http.StatusCreated || json.Unmarshal(w.Body.Bytes(), &job) != nil || !job.DryingOverridden {
+		t.Fatalf("overridden job: %d %s", w.Code, w.Body)
+	}
+	entries := audits()
+	if len(entries) != 1 || entries[0].Action != AuditDryingOverridden || entries[0].Subject != "filament_f1" || !strings.Contains(entries[0].Detail, "j2") {
+		t.Fatalf("audit log: %+v", entries)
+	}
+	page, err := leader.Store.ReadLog(0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	together := false
+	for _, entry := range page.Entries {
+		keys := strings.Join(entry.Keys, ",")
+		together = together || strings.Contains(keys, "printjob_j2") && strings.Contains(keys, auditKeyPrefix+entries[0].ID)
+	}
+	if !together {
+		t.Fatal("the audit entry wasn't written with the job")
+	}
+
+	// Drying the spool clears the check
+	w = httptest.NewRecorder()
+	s.handleFilamentDried(w, httptest.NewRequest(http.MethodPost, "/api/v1/filaments/f1/dried", strings.NewReader(`{"dried_at":"2999-01-01T00:00:00Z"}`)), "f1")
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("dried in the future: %d %s", w.Code, w.Body)
+	}
+	w = httptest.NewRecorder()
+	s.handleFilamentDried(w, httptest.NewRequest(http.MethodPost, "/api/v1/filaments/f1/dried", nil), "f1")
+	var filament Filament
+	if w.Code != http.StatusOK || json.Unmarshal(w.Body.Bytes(), &filament) != nil || filament.LastDriedAt == nil || time.Since(*filament.LastDriedAt) > time.Minute {
+		t.Fatalf("dried: %d %s", w.Code, w.Body)
+	}
+	if filament.RemainingWeightInGrams != 1000 {
+		t.Fatalf("drying changed the weight: %+v", filament)
+	}
+	if w := post("j3", ""); w.Code != http.StatusCreated || strings.Contains(w.Body.String(), "drying_overridden") {
+		t.Fatalf("job on a dried spool: %d %s", w.Code, w.Body)
+	}
+	if entries := audits(); len(entries) != 1 {
+		t.Fatalf("audit log after drying: %+v", entries)
+	}
+}
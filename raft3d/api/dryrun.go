@@ -0,0 +1,26 @@
+package api
+
+import (
+	"net/http"
+
+	"raft3d/raft"
+)
+
+// dryRunHeader marks responses to ?dry_run=true, whose writes were checked
+// on the leader but not committed
+const dryRunHeader = "X-Raft3d-Dry-Run"
+
+// dryRunRequest returns the request with its writes through storeFor turned
+// into dry runs if it asked for ?dry_run=true; raft.IsDryRun tells them
+// apart. Any value but true or false is refused with a validation problem.
+func dryRunRequest(w http.ResponseWriter, r *http.Request) (*http.Request, bool) {
+	switch r.URL.Query().Get("dry_run") {
+	case "", "false":
+		return r, true
+	case "true":
+		w.Header().Set(dryRunHeader, "true")
+		return r.WithContext(raft.WithDryRun(r.Context())), true
+	}
+	writeValidationProblem(w, r, []FieldError{{Name: "dry_run", Reason: "must be true or false"}})
+	return r, false
+}
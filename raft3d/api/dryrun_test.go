@@ -0,0 +1,75 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"raft3d/raft"
+	"raft3d/testsupport"
+)
+
+func TestDryRunWrites(t *testing.T) {
+	c := testsupport.NewCluster(t, 1)
+	leader := c.WaitForLeader(10 * time.Second)
+	s := NewServer("", leader.Store)
+	for key, v := range map[string]interface{}{
+		"printer_p1":  Printer{ID: "p1", Name: "Prusa", Status: "Idle"},
+		"filament_f1": Filament{ID: "f1", Name: "PLA", Type: "PLA", TotalWeightInGrams: 1000, RemainingWeightInGrams: 1000},
+	} {
+		body, _ := json.Marshal(v)
+		if err := leader.Store.Set(key, string(body)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	do := func(method, url, body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		r := httptest.NewRequest(method, url, strings.NewReader(body))
+		if method == http.MethodPost && strings.HasPrefix(url, "/api/v1/print_jobs?") {
+			s.handlePostPrintJob(rec, r)
+		} else {
+			s.handlePrintJobs(rec, r)
+		}
+		return rec
+	}
+	const job = `{"id":"j1","printer_id":"p1","filament_id":"f1","filepath":"clip.gcode","print_weight_in_grams":10}`
+
+	before := leader.Store.AppliedIndex()
+	rec := do(http.MethodPost, "/api/v1/print_jobs?dry_run=true", job)
+	if rec.Code != http.StatusCreated || rec.Header().Get(dryRunHeader) != "true" || !strings.Contains(rec.Body.String(), `"status":"Queued"`) {
+		t.Fatalf("dry run create: %d %s", rec.Code, rec.Body)
+	}
+	if _, err := leader.Store.Get("printjob_j1"); err == nil || leader.Store.AppliedIndex() != before {
+		t.Fatal("dry run committed the job")
+	}
+	if rec := do(http.MethodPost, "/api/v1/print_jobs?dry_run=maybe", job); rec.Code != http.StatusBadRequest {
+		t.Fatalf("bad dry_run accepted: %d", rec.Code)
+	}
+
+	if rec := do(http.MethodPost, "/api/v1/print_jobs?dry_run=false", job); rec.Code != http.StatusCreated {
+		t.Fatalf("create: %d %s", rec.Code, rec.Body)
+	}
+	// The FSM's checks run as they would on commit
+	if err := leader.Store.Set(raft.DuplicateJobPolicyKey, `{"mode":"reject"}`); err != nil {
+		t.Fatal(err)
+	}
+	rec = do(http.MethodPost, "/api/v1/print_jobs?dry_run=true", strings.Replace(job, `"j1"`, `"j2"`, 1))
+	if rec.Code != http.StatusConflict || !strings.Contains(rec.Body.String(), CodeDuplicateJob) {
+		t.Fatalf("dry run of a duplicate: %d %s", rec.Code, rec.Body)
+	}
+
+	if rec := do(http.MethodPost, "/api/v1/print_jobs/j1/status?status=Running&dry_run=true", ""); rec.Code != http.StatusOK {
+		t.Fatalf("dry run status update: %d %s", rec.Code, rec.Body)
+	}
+	if rec := do(http.MethodPost, "/api/v1/print_jobs/j1/status?status=Done&dry_run=true", ""); rec.Code != http.StatusConflict {
+		t.Fatalf("dry run of an invalid transition: %d %s", rec.Code, rec.Body)
+	}
+	value, _ := leader.Store.Get("printjob_j1")
+	if !strings.Contains(value, `"status":"Queued"`) {
+		t.Fatalf("dry run changed the job: %s", value)
+	}
+}
@@ -0,0 +1,62 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"raft3d/raft"
+)
+
+// handleDuplicateJobPolicy handles GET and PUT /api/v1/admin/duplicate_jobs,
+// which sets whether a job repeating a recently queued one is accepted with a
+// warning or rejected. The FSM applies it, so every node decides alike.
+func (s *Server) handleDuplicateJobPolicy(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		var policy raft.DuplicateJobPolicy
+		value, err := s.store.Get(raft.DuplicateJobPolicyKey)
+		if err != nil && !errors.Is(err, raft.ErrNotFound) {
+			s.writeStoreError(w, r, err, "Failed to retrieve duplicate job policy")
+			return
+		}
+		if err == nil {
+			json.Unmarshal([]byte(value), &policy)
+		}
+		if policy.Mode == "" {
+			policy.Mode = raft.DuplicateJobsWarn
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(policy)
+	case http.MethodPut:
+		if !s.requireRole(w, r, RoleAdmin) {
+			return
+		}
+		var policy raft.DuplicateJobPolicy
+		if !decodeJSON(w, r, &policy) {
+			return
+		}
+		body, err := json.Marshal(policy)
+		if err != nil {
+			writeError(w, r, http.StatusInternalServerError, CodeInternal, "Failed to process duplicate job policy")
+			return
+		}
+		if err := s.storeFor(r).Set(raft.DuplicateJobPolicyKey, string(body)); err != nil {
+			s.writeStoreError(w, r, err, "Failed to store duplicate job policy")
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(body)
+	default:
+		methodNotAllowed(w, r)
+	}
+}
+
+// warnDuplicate adds a Warning header to the response for a job the FSM
+// accepted as a repeat of another
+func warnDuplicate(w http.ResponseWriter, job PrintJob) {
+	if job.DuplicateOf != "" {
+		w.Header().Add("Warning", fmt.Sprintf(`199 raft3d "duplicate of queued print job %s"`, job.DuplicateOf))
+	}
+}
@@ -0,0 +1,67 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"raft3d/testsupport"
+)
+
+func TestCreatingAnExistingIDConflicts(t *testing.T) {
+	c := testsupport.NewCluster(t, 3)
+	leader := c.WaitForLeader(10 * time.Second)
+	s := NewServer("", leader.Store)
+
+	post := func(url, name string) *httptest.ResponseRecorder {
+		body := fmt.Sprintf(`{"id":"p1","name":%q,"status":"Idle"}`, name)
+		w := httptest.NewRecorder()
+		s.handlePostPrinter(w, httptest.NewRequest(http.MethodPost, url, strings.NewReader(body)))
+		return w
+	}
+
+	// Racing creates of one ID: the FSM lets exactly one through
+	const creates = 8
+	codes := make([]int, creates)
+	bodies := make([]string, creates)
+	var wg sync.WaitGroup
+	for i := 0; i < creates; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			w := post("/api/v1/printers", fmt.Sprintf("printer %d", i))
+			codes[i], bodies[i] = w.Code, w.Body.String()
+		}(i)
+	}
+	wg.Wait()
+
+	winner := -1
+	for i, code := range codes {
+		switch {
+		case code == http.StatusCreated && winner < 0:
+			winner = i
+		case code == http.StatusConflict && strings.Contains(bodies[i], `"code":"`+CodeAlreadyExists+`"`):
+		default:
+			t.Fatalf("create %d: %d %s", i, code, bodies[i])
+		}
+	}
+	if winner < 0 {
+		t.Fatalf("no create succeeded: %v", codes)
+	}
+	printer, err := s.getPrinter("p1")
+	if err != nil || printer.Name != fmt.Sprintf("printer %d", winner) {
+		t.Fatalf("stored %+v %v, want the winning create %d", printer, err, winner)
+	}
+
+	// ?upsert=true replaces it instead
+	if w := post("/api/v1/printers?upsert=true", "renamed"); w.Code != http.StatusCreated {
+		t.Fatalf("upsert: %d %s", w.Code, w.Body)
+	}
+	if printer, err := s.getPrinter("p1"); err != nil || printer.Name != "renamed" {
+		t.Fatalf("after upsert: %+v %v", printer, err)
+	}
+}
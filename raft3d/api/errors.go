@@ -0,0 +1,135 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"raft3d/raft"
+)
+
+// Machine-readable error codes returned in the "code" field of error responses
+const (
+	CodeMalformedRequest      = "malformed_request"
+	CodeValidationFailed      = "validation_failed"
+	CodeNotFound              = "not_found"
+	CodeConflict              = "conflict"
+	CodeAlreadyExists         = "already_exists"
+	CodeInvalidTransition     = "invalid_status_transition"
+	CodeInsufficientFilament  = "insufficient_filament"
+	CodeNotLeader             = "not_leader"
+	CodeQuorumLost            = "quorum_lost"
+	CodeMethodNotAllowed      = "method_not_allowed"
+	CodeUnauthorized          = "unauthorized"
+	CodeForbidden             = "forbidden"
+	CodeQuotaExceeded         = "quota_exceeded"
+	CodeDependenciesPending   = "dependencies_pending"
+	CodeStaleRead             = "stale_read"
+	CodePrinterOffline        = "printer_offline"
+	CodeCameraUnavailable     = "camera_unavailable"
+	CodeFilamentNeedsDrying   = "filament_needs_drying"
+	CodeNotCaughtUp           = "not_caught_up"
+	CodeReplicationTimeout    = "replication_timeout"
+	CodeOverloaded            = "overloaded"
+	CodeQueryTimeout          = "query_timeout"
+	CodeHistoryUnavailable    = "history_unavailable"
+	CodePolicyViolation       = raft.ApplyCodePolicy
+	CodeReservationConflict   = raft.ApplyCodeReservation
+	CodeDuplicateJob          = raft.ApplyCodeDuplicateJob
+	CodeQuarantined           = raft.ApplyCodeQuarantined
+	CodeIncompatibleVersion   = raft.ApplyCodeVersion
+	CodeInvalidStepTransition = raft.ApplyCodeStep
+	CodePrinterReserved       = "printer_reserved"
+	CodeInternal              = "internal_error"
+)
+
+// notLeaderRetryAfter is how long clients are asked to wait before retrying a
+// write rejected by a follower. It roughly matches an election round.
+const notLeaderRetryAfter = 1 * time.Second
+
+// overloadedRetryAfter is how long clients are asked to back off when a write
+// is shed because the state machine is behind
+const overloadedRetryAfter = 1 * time.Second
+
+// LeaderHint tells clients where writes should be sent instead
+type LeaderHint struct {
+	ID       string `json:"id,omitempty"`
+	RaftAddr string `json:"raft_addr,omitempty"`
+	HTTPAddr string `json:"http_addr,omitempty"`
+}
+
+// writeError writes a problem response with the given status and error code
+func writeError(w http.ResponseWriter, r *http.Request, status int, code, detail string) {
+	writeProblem(w, r, *errorProblem(status, code, detail))
+}
+
+// writeStoreError maps an error from the store layer to an HTTP status and code.
+// The detail is used as the message for errors that aren't client-facing.
+func (s *Server) writeStoreError(w http.ResponseWriter, r *http.Request, err error, detail string) {
+	switch {
+	case errors.Is(err, context.Canceled):
+		// The client went away; nobody reads the response
+		return
+	case errors.Is(err, raft.ErrReplicationTimeout):
+		writeError(w, r, http.StatusGatewayTimeout, CodeReplicationTimeout,
+			"The write was not replicated in time; it may still be applied, so check before retrying")
+	case errors.Is(err, raft.ErrOverloaded):
+		w.Header().Set("Retry-After", strconv.Itoa(int(overloadedRetryAfter.Seconds())))
+		writeError(w, r, http.StatusTooManyRequests, CodeOverloaded,
+			"The cluster is behind on applying writes; retry after the Retry-After delay")
+	case errors.Is(err, raft.ErrQuorumLost):
+		writeQuorumLost(w, r)
+	case errors.Is(err, raft.ErrNotLeader):
+		s.writeNotLeader(w, r)
+	case errors.Is(err, raft.ErrNotFound):
+		writeError(w, r, http.StatusNotFound, CodeNotFound, detail)
+	case errors.Is(err, raft.ErrHistoryUnavailable):
+		writeError(w, r, http.StatusGone, CodeHistoryUnavailable, err.Error())
+	case errors.Is(err, raft.ErrQuarantined):
+		writeError(w, r, http.StatusInternalServerError, CodeQuarantined, err.Error())
+	case errors.Is(err, raft.ErrConflict):
+		writeError(w, r, http.StatusConflict, applyErrorCode(err, CodeConflict), err.Error())
+	case errors.Is(err, raft.ErrValidation):
+		writeError(w, r, http.StatusBadRequest, applyErrorCode(err, CodeValidationFailed), err.Error())
+	default:
+		writeError(w, r, http.StatusInternalServerError, CodeInternal, detail)
+	}
+}
+
+// applyErrorCode returns the code the FSM gave a rejected command, which can
+// be more specific than the generic code for its error class
+func applyErrorCode(err error, fallback string) string {
+	var applyErr *raft.ApplyError
+	if errors.As(err, &applyErr) && applyErr.Code != "" {
+		return applyErr.Code
+	}
+	return fallback
+}
+
+// methodNotAllowed writes a 405 error response
+func methodNotAllowed(w http.ResponseWriter, r *http.Request) {
+	writeError(w, r, http.StatusMethodNotAllowed, CodeMethodNotAllowed, "Method not allowed")
+}
+
+// writeNotLeader writes a 503 telling the client to retry against the leader
+func (s *Server) writeNotLeader(w http.ResponseWriter, r *http.Request) {
+	leader := s.store.LeaderInfo()
+
+	detail := "This node is not the leader and no leader is currently elected"
+	var hint *LeaderHint
+	if leader.ID != "" {
+		detail = "This node is not the leader; retry the request against the leader"
+		hint = &LeaderHint{ID: leader.ID, RaftAddr: leader.RaftAddr, HTTPAddr: leader.HTTPAddr}
+	}
+
+	w.Header().Set("Retry-After", strconv.Itoa(int(notLeaderRetryAfter.Seconds())))
+	writeProblem(w, r, Problem{
+		Title:  http.StatusText(http.StatusServiceUnavailable),
+		Status: http.StatusServiceUnavailable,
+		Code:   CodeNotLeader,
+		Detail: detail,
+		Leader: hint,
+	})
+}
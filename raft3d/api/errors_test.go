@@ -0,0 +1,87 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"raft3d/raft"
+	"raft3d/testsupport"
+)
+
+func TestStoreErrorsUseTheProblemEnvelope(t *testing.T) {
+	c := testsupport.NewCluster(t, 1)
+	leader := c.WaitForLeader(10 * time.Second)
+	s := NewServer("", leader.Store)
+
+	tests := []struct {
+		name   string
+		err    error
+		status int
+		code   string
+		detail string
+	}{
+		{"not found", fmt.Errorf("get printer_p1: %w", raft.ErrNotFound), http.StatusNotFound, CodeNotFound, "Printer not found"},
+		{"conflict", raft.ErrConflict, http.StatusConflict, CodeConflict, "conflict"},
+		{"already exists", raft.ErrAlreadyExists, http.StatusConflict, CodeConflict, "conflict: already exists"},
+		{"validation", raft.ErrValidation, http.StatusBadRequest, CodeValidationFailed, "validation failed"},
+		{"code from the FSM", &raft.ApplyError{Code: raft.ApplyCodeReservation, Err: raft.ErrReservationConflict},
+			http.StatusConflict, CodeReservationConflict, "conflict: reservation overlaps"},
+		{"internal errors stay hidden", errors.New("boltdb: disk I/O error"), http.StatusInternalServerError, CodeInternal, "Printer not found"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			s.writeStoreError(w, httptest.NewRequest(http.MethodGet, "/api/v1/printers/p1", nil), tt.err, "Printer not found")
+			if w.Code != tt.status {
+				t.Fatalf("status = %d, want %d", w.Code, tt.status)
+			}
+			if ct := w.Header().Get("Content-Type"); ct != "application/problem+json" {
+				t.Fatalf("content type %q", ct)
+			}
+			var p Problem
+			if err := json.Unmarshal(w.Body.Bytes(), &p); err != nil {
+				t.Fatal(err)
+			}
+			want := Problem{Type: "about:blank", Title: http.StatusText(tt.status), Status: tt.status, Code: tt.code, Detail: tt.detail, Instance: "/api/v1/printers/p1"}
+			if p.Type != want.Type || p.Title != want.Title || p.Status != want.Status || p.Code != want.Code || p.Detail != want.Detail || p.Instance != want.Instance {
+				t.Fatalf("problem = %+v, want %+v", p, want)
+			}
+		})
+	}
+}
+
+func TestFollowersPointWritesAtTheLeader(t *testing.T) {
+	c := testsupport.NewCluster(t, 3)
+	c.WaitForLeader(10 * time.Second)
+	c.AssertConverged(10 * time.Second)
+
+	// A follower that lost touch with the leader under load has no hint to
+	// give until it hears from it again
+	var p Problem
+	var leader *testsupport.Node
+	for deadline := time.Now().Add(10 * time.Second); ; {
+		leader = c.WaitForLeader(10 * time.Second)
+		s := NewServer("", c.Followers()[0].Store)
+		w := httptest.NewRecorder()
+		s.handlePostPrinter(w, httptest.NewRequest(http.MethodPost, "/api/v1/printers", strings.NewReader(`{"id":"p1","name":"Prusa","status":"Idle"}`)))
+		if w.Code != http.StatusServiceUnavailable || w.Header().Get("Retry-After") != "1" {
+			t.Fatalf("write on a follower: %d Retry-After %q: %s", w.Code, w.Header().Get("Retry-After"), w.Body)
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &p); err != nil {
+			t.Fatal(err)
+		}
+		if p.Leader != nil || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	if p.Code != CodeNotLeader || p.Leader == nil || p.Leader.ID != leader.ID || p.Leader.RaftAddr != string(leader.Addr) {
+		t.Fatalf("problem = %+v, leader = %+v, want %s at %s", p, p.Leader, leader.ID, leader.Addr)
+	}
+}
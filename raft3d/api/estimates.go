@@ -0,0 +1,113 @@
+package api
+
+import "time"
+
+// defaultGramsPerHour is assumed for printers without completed history
+const defaultGramsPerHour = 15.0
+
+// jobEstimate is the projected start and completion of an active job
+type jobEstimate struct {
+	start      time.Time
+	completion time.Time
+}
+
+// printerThroughput learns grams printed per hour for each printer from
+// jobs with a recorded start and completion
+func printerThroughput(jobs []PrintJob) map[string]float64 {
+	grams := make(map[string]float64)
+	hours := make(map[string]float64)
+	for _, job := range jobs {
+		if job.Status != "Done" || job.StartedAt == nil || job.CompletedAt == nil {
+			continue
+		}
+		elapsed := job.CompletedAt.Sub(*job.StartedAt).Hours()
+		if elapsed <= 0 {
+			continue
+		}
+		grams[job.PrinterID] += job.PrintWeightInGrams
+		hours[job.PrinterID] += elapsed
+	}
+
+	throughput := make(map[string]float64)
+	for printerID, h := range hours {
+		throughput[printerID] = grams[printerID] / h
+	}
+	return throughput
+}
+
+// estimateJobs projects when each active job starts and completes. Each
+// printer finishes its running job, then works through its queue in
+// scheduling order at its learned throughput.
+func estimateJobs(jobs []PrintJob, now time.Time) map[string]jobEstimate {
+	return estimateJobsAround(jobs, nil, now)
+}
+
+// estimateJobsAround is estimateJobs, also holding each queued job back
+// until it fits between the reservations of its printer held by someone
+// other than its submitter
+func estimateJobsAround(jobs []PrintJob, reservations []Reservation, now time.Time) map[string]jobEstimate {
+	throughput := printerThroughput(jobs)
+	duration := func(job PrintJob) time.Duration {
+		rate, ok := throughput[job.PrinterID]
+		if !ok {
+			rate = defaultGramsPerHour
+		}
+		return time.Duration(job.PrintWeightInGrams / rate * float64(time.Hour))
+	}
+
+	estimates := make(map[string]jobEstimate)
+	freeAt := make(map[string]time.Time)
+	for _, job := range jobs {
+		if job.Status != "Running" {
+			continue
+		}
+		start := now
+		if job.StartedAt != nil {
+			start = *job.StartedAt
+		}
+		completion := start.Add(duration(job))
+		if completion.Before(now) {
+			completion = now
+		}
+		estimates[job.ID] = jobEstimate{start: start, completion: completion}
+		if completion.After(freeAt[job.PrinterID]) {
+			freeAt[job.PrinterID] = completion
+		}
+	}
+
+	for _, job := range queueOrder(jobs) {
+		start := freeAt[job.PrinterID]
+		if start.Before(now) {
+			start = now
+		}
+		start = pastReservations(job, start, duration(job), reservations)
+		completion := start.Add(duration(job))
+		estimates[job.ID] = jobEstimate{start: start, completion: completion}
+		freeAt[job.PrinterID] = completion
+	}
+	return estimates
+}
+
+// pastReservations returns the earliest time from start that a job taking
+// length doesn't overlap a reservation of its printer by someone else.
+// Reservations must be ordered by start.
+func pastReservations(job PrintJob, start time.Time, length time.Duration, reservations []Reservation) time.Time {
+	for _, reservation := range reservations {
+		if reservation.PrinterID != job.PrinterID || reservation.heldBy(job.SubmittedBy) {
+			continue
+		}
+		if start.Before(reservation.End) && reservation.Start.Before(start.Add(length)) {
+			start = reservation.End
+		}
+	}
+	return start
+}
+
+// withEstimate fills in the estimated start and completion of a job
+func (j PrintJob) withEstimate(estimates map[string]jobEstimate) PrintJob {
+	if estimate, ok := estimates[j.ID]; ok {
+		start, completion := estimate.start, estimate.completion
+		j.EstimatedStart, j.EstimatedCompletion = &start, &completion
+	}
+	return j
+}
@@ -0,0 +1,123 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"raft3d/events"
+)
+
+// handleEvents handles GET /api/v1/events?since=N&type=prefix, returning
+// remembered events newer than since
+func (s *Server) handleEvents(w http.ResponseWriter, r *http.Request) {
+	s.writeRecentEvents(w, r, r.URL.Query().Get("type"))
+}
+
+// handleClusterEvents handles GET /api/v1/cluster/events, returning recent
+// leadership, peer and heartbeat events
+func (s *Server) handleClusterEvents(w http.ResponseWriter, r *http.Request) {
+	s.writeRecentEvents(w, r, "cluster.")
+}
+
+// writeRecentEvents writes events from the ring buffer matching prefix
+func (s *Server) writeRecentEvents(w http.ResponseWriter, r *http.Request, prefix string) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, r)
+		return
+	}
+
+	since, ok := parseSince(w, r, r.URL.Query().Get("since"))
+	if !ok {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.events.Recent(prefix, since))
+}
+
+// handleEventStream handles GET /api/v1/events/stream, pushing events to the
+// client as server-sent events. Clients resuming with Last-Event-ID first
+// receive any remembered events they missed.
+func (s *Server) handleEventStream(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, r)
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, r, http.StatusInternalServerError, CodeInternal, "Streaming is not supported")
+		return
+	}
+
+	lastID := r.Header.Get("Last-Event-ID")
+	if lastID == "" {
+		lastID = r.URL.Query().Get("since")
+	}
+	since, ok := parseSince(w, r, lastID)
+	if !ok {
+		return
+	}
+	prefix := r.URL.Query().Get("type")
+
+	// Subscribe before replaying so nothing published in between is lost
+	ch, unsubscribe := s.events.Subscribe(64)
+	defer unsubscribe()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+
+	if since > 0 {
+		for _, event := range s.events.Recent(prefix, since) {
+			writeSSE(w, event)
+			since = event.Seq
+		}
+	}
+	flusher.Flush()
+
+	for {
+		select {
+		case event, ok := <-ch:
+			if !ok {
+				return
+			}
+			if event.Seq <= since || !strings.HasPrefix(event.Type, prefix) {
+				continue
+			}
+			writeSSE(w, event)
+			flusher.Flush()
+		case <-r.Context().Done():
+			return
+		case <-s.stopCh:
+			// Let a graceful shutdown finish rather than wait for clients
+			return
+		}
+	}
+}
+
+// writeSSE writes a single event in text/event-stream format
+func writeSSE(w http.ResponseWriter, event events.Event) {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return
+	}
+	fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", event.Seq, event.Type, data)
+}
+
+// parseSince parses an event sequence number, writing a 400 if it is invalid
+func parseSince(w http.ResponseWriter, r *http.Request, value string) (uint64, bool) {
+	if value == "" {
+		return 0, true
+	}
+	since, err := strconv.ParseUint(value, 10, 64)
+	if err != nil {
+		writeValidationProblem(w, r, []FieldError{{Name: "since", Reason: "must be a non-negative integer"}})
+		return 0, false
+	}
+	return since, true
+}
@@ -0,0 +1,353 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"raft3d/drivers"
+	"raft3d/raft"
+)
+
+// driverPollInterval is how often a node polls the printers it drives
+const driverPollInterval = 2 * time.Second
+
+// driverSyncInterval is how often a node checks which printers' stored
+// driver configs name it
+const driverSyncInterval = 15 * time.Second
+
+// driverStartTimeout is how long a printer may take to start printing a job
+// before the job is marked Failed
+const driverStartTimeout = time.Minute
+
+// EnableDrivers has this node run the jobs assigned to the printers it is
+// connected to, besides those whose stored driver config names the node.
+// Jobs are started, completed and failed through the leader's API like any
+// other client's, authenticated with apiKey if it is set.
+func (s *Server) EnableDrivers(printers map[string]drivers.PrinterDriver, apiKey string) {
+	s.drivers = printers
+	s.driverAPIKey = apiKey
+}
+
+// executor runs the jobs of one printer through its driver
+type executor struct {
+	s         *Server
+	printerID string
+	driver    drivers.PrinterDriver
+	client    *http.Client
+
+	started     bool      // whether jobs left Running by an earlier run were handled
+	job         string    // job the printer is running, if any
+	since       time.Time // when it was started
+	sawPrinting bool      // whether the printer was seen printing it
+}
+
+// runDriver polls a printer, reports its telemetry and moves its jobs
+// through their statuses until the server stops or stop is closed
+func (s *Server) runDriver(printerID string, driver drivers.PrinterDriver, stop <-chan struct{}) {
+	defer driver.Close()
+
+	// The transport fills in each request's server name for TLS
+	client := &http.Client{Timeout: 10 * time.Second}
+	if s.tls != nil {
+		client.Transport = &http.Transport{TLSClientConfig: s.tls.ClientConfig("")}
+	}
+	e := &executor{s: s, printerID: printerID, driver: driver, client: client}
+
+	ticker := time.NewTicker(driverPollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			ctx, cancel := context.WithTimeout(context.Background(), driverPollInterval*5)
+			e.poll(ctx)
+			cancel()
+		case <-s.stopCh:
+			return
+		case <-stop:
+			return
+		}
+	}
+}
+
+// storedDriver is a printer driven here because its stored config says so
+type storedDriver struct {
+	config drivers.Config
+	stop   chan struct{}
+}
+
+// runStoredDrivers drives the printers whose stored driver config names this
+// node, restarting a driver when its config changes and stopping it when the
+// printer is moved elsewhere. Printers given to EnableDrivers keep the
+// driver they were given.
+func (s *Server) runStoredDrivers() {
+	running := make(map[string]storedDriver)
+	failed := make(map[string]drivers.Config) // not retried until changed
+
+	ticker := time.NewTicker(driverSyncInterval)
+	defer ticker.Stop()
+	for {
+		s.syncStoredDrivers(running, failed)
+		select {
+		case <-ticker.C:
+		case <-s.stopCh:
+			return
+		}
+	}
+}
+
+// syncStoredDrivers starts and stops drivers to match the stored configs
+func (s *Server) syncStoredDrivers(running map[string]storedDriver, failed map[string]drivers.Config) {
+	printers, err := s.listPrinters()
+	if err != nil {
+		log.Printf("Failed to list printers to drive: %s", err)
+		return
+	}
+	wanted := make(map[string]drivers.Config)
+	for _, printer := range printers {
+		if printer.Driver == nil || printer.Driver.Node != s.NodeID {
+			continue
+		}
+		if _, local := s.drivers[printer.ID]; local {
+			continue
+		}
+		wanted[printer.ID] = printer.Driver.Config
+	}
+
+	for printerID, driver := range running {
+		if config, ok := wanted[printerID]; !ok || config != driver.config {
+			close(driver.stop)
+			delete(running, printerID)
+			log.Printf("Printer %s: no longer driven with %s at %s", printerID, driver.config.Driver, driver.config.Address)
+		}
+	}
+	for printerID, config := range wanted {
+		if _, ok := running[printerID]; ok {
+			continue
+		}
+		if last, ok := failed[printerID]; ok && last == config {
+			continue
+		}
+		driver, err := drivers.Open(config)
+		if err != nil {
+			log.Printf("Failed to connect to printer %s: %s; fix its driver config to retry", printerID, err)
+			failed[printerID] = config
+			continue
+		}
+		delete(failed, printerID)
+		stop := make(chan struct{})
+		running[printerID] = storedDriver{config: config, stop: stop}
+		log.Printf("Driving printer %s with %s at %s", printerID, config.Driver, config.Address)
+		printerID, driver := printerID, driver
+		s.spawn(func() { s.runDriver(printerID, driver, stop) })
+	}
+}
+
+// poll checks on the printer once
+func (e *executor) poll(ctx context.Context) {
+	status, err := e.driver.Status(ctx)
+	if err != nil {
+		log.Printf("Printer %s: failed to read status: %s", e.printerID, err)
+		return
+	}
+	if telemetry, err := e.driver.Telemetry(ctx); err != nil {
+		log.Printf("Printer %s: failed to read telemetry: %s", e.printerID, err)
+	} else if telemetry != (drivers.Telemetry{}) {
+		// Telemetry also serves as the printer's heartbeat
+		if err := e.callLeader(ctx, http.MethodPost, "/api/v1/printers/"+e.printerID+"/telemetry", telemetry); err != nil {
+			log.Printf("Printer %s: failed to report telemetry: %s", e.printerID, err)
+		}
+	}
+
+	jobs, err := e.s.listPrintJobs()
+	if err != nil {
+		log.Printf("Printer %s: failed to list print jobs: %s", e.printerID, err)
+		return
+	}
+	var forPrinter []PrintJob
+	for _, job := range jobs {
+		if job.PrinterID == e.printerID {
+			forPrinter = append(forPrinter, job)
+		}
+	}
+
+	if !e.started {
+		e.adopt(ctx, status, forPrinter)
+		return
+	}
+	if e.job != "" {
+		e.track(ctx, status, forPrinter)
+		return
+	}
+	if status.State == drivers.StateIdle || status.State == drivers.StateDone {
+		e.startNext(ctx, forPrinter)
+	}
+}
+
+// adopt handles jobs left Running on the printer by an earlier run of this
+// node. A job is kept if the printer is still printing and failed otherwise,
+// since a print can't be resumed part way through.
+func (e *executor) adopt(ctx context.Context, status drivers.Status, jobs []PrintJob) {
+	for _, job := range jobs {
+		if job.Status != "Running" {
+			continue
+		}
+		if e.job == job.ID {
+			continue
+		}
+		if e.job == "" && (status.State == drivers.StatePrinting || status.State == drivers.StatePaused) {
+			log.Printf("Printer %s: resuming tracking of print job %s", e.printerID, job.ID)
+			e.job, e.since, e.sawPrinting = job.ID, time.Now(), true
+			continue
+		}
+		if !e.finish(ctx, job.ID, "Failed", "printer is not printing it") {
+			return
+		}
+	}
+	e.started = true
+}
+
+// startNext starts the first job in the printer's queue that the leader lets
+// start. Jobs refused because their dependencies aren't Done are skipped.
+func (e *executor) startNext(ctx context.Context, jobs []PrintJob) {
+	for _, job := range queueOrder(jobs) {
+		err := e.setStatus(ctx, job.ID, "Running")
+		var rejected *leaderError
+		if errors.As(err, &rejected) && rejected.status == http.StatusConflict {
+			continue
+		}
+		if err != nil {
+			log.Printf("Printer %s: failed to start print job %s: %s", e.printerID, job.ID, err)
+			return
+		}
+
+		if err := e.driver.StartJob(ctx, drivers.Job{ID: job.ID, FilePath: job.FilePath}); err != nil {
+			e.finish(ctx, job.ID, "Failed", err.Error())
+			return
+		}
+		log.Printf("Printer %s: started print job %s", e.printerID, job.ID)
+		e.job, e.since, e.sawPrinting = job.ID, time.Now(), false
+		return
+	}
+}
+
+// track follows the job the printer is running until it ends, reporting how
+// it ended, and cancels it on the printer if it was canceled in the cluster
+func (e *executor) track(ctx context.Context, status drivers.Status, jobs []PrintJob) {
+	for _, job := range jobs {
+		// A follower may not have applied the job starting yet
+		if job.ID == e.job && !job.isActive() {
+			if status.State == drivers.StatePrinting || status.State == drivers.StatePaused {
+				if err := e.driver.Cancel(ctx); err != nil {
+					log.Printf("Printer %s: failed to cancel print job %s: %s", e.printerID, job.ID, err)
+					return
+				}
+				log.Printf("Printer %s: canceled print job %s, which is now %s", e.printerID, job.ID, job.Status)
+			}
+			e.job = ""
+			return
+		}
+	}
+
+	switch status.State {
+	case drivers.StatePrinting, drivers.StatePaused:
+		e.sawPrinting = true
+	case drivers.StateDone:
+		if e.sawPrinting {
+			e.finish(ctx, e.job, "Done", "")
+		}
+	case drivers.StateError:
+		e.finish(ctx, e.job, "Failed", status.Message)
+	case drivers.StateIdle:
+		if e.sawPrinting {
+			e.finish(ctx, e.job, "Failed", "printer stopped before finishing")
+		}
+	}
+	if e.job != "" && !e.sawPrinting && time.Since(e.since) > driverStartTimeout {
+		e.finish(ctx, e.job, "Failed", fmt.Sprintf("printer did not start within %s", driverStartTimeout))
+	}
+}
+
+// finish moves a job to a final status and stops tracking it. It reports
+// false if the leader couldn't be told, so it is tried again on the next
+// poll. A job the leader refuses to move has already left Running.
+func (e *executor) finish(ctx context.Context, jobID, status, reason string) bool {
+	err := e.setStatus(ctx, jobID, status)
+	var rejected *leaderError
+	if err != nil && !(errors.As(err, &rejected) && rejected.status == http.StatusConflict) {
+		log.Printf("Printer %s: failed to mark print job %s %s: %s", e.printerID, jobID, status, err)
+		return false
+	}
+	if reason != "" {
+		log.Printf("Printer %s: print job %s is %s: %s", e.printerID, jobID, status, reason)
+	} else {
+		log.Printf("Printer %s: print job %s is %s", e.printerID, jobID, status)
+	}
+	if e.job == jobID {
+		e.job = ""
+	}
+	return true
+}
+
+// setStatus asks the leader to move a job to a new status
+func (e *executor) setStatus(ctx context.Context, jobID, status string) error {
+	path := fmt.Sprintf("/api/v1/print_jobs/%s/status?status=%s", jobID, url.QueryEscape(status))
+	return e.callLeader(ctx, http.MethodPost, path, nil)
+}
+
+// leaderError is a request the leader refused
+type leaderError struct {
+	status  int
+	message string
+}
+
+func (e *leaderError) Error() string {
+	return fmt.Sprintf("%d %s: %s", e.status, http.StatusText(e.status), e.message)
+}
+
+// callLeader sends a request to the leader's API
+func (e *executor) callLeader(ctx context.Context, method, path string, body interface{}) error {
+	leader := e.s.store.LeaderInfo()
+	if raft.ValidateAddr(leader.HTTPAddr) != nil {
+		return errors.New("no leader to report to")
+	}
+
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, fmt.Sprintf("%s://%s%s", e.s.scheme(), leader.HTTPAddr, path), reader)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if e.s.driverAPIKey != "" {
+		req.Header.Set("X-API-Key", e.s.driverAPIKey)
+	}
+
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return &leaderError{status: resp.StatusCode, message: strings.TrimSpace(string(msg))}
+	}
+	return nil
+}
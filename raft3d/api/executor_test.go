@@ -0,0 +1,99 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"raft3d/drivers"
+	"raft3d/testsupport"
+)
+
+// idleDriver is a printer that never prints
+type idleDriver struct{}
+
+func (idleDriver) StartJob(context.Context, drivers.Job) error { return nil }
+func (idleDriver) Cancel(context.Context) error                { return nil }
+func (idleDriver) Status(context.Context) (drivers.Status, error) {
+	return drivers.Status{State: drivers.StateIdle}, nil
+}
+func (idleDriver) Telemetry(context.Context) (drivers.Telemetry, error) {
+	return drivers.Telemetry{}, nil
+}
+func (idleDriver) Close() error { return nil }
+
+func init() {
+	drivers.Register("test-idle", func(drivers.Config) (drivers.PrinterDriver, error) { return idleDriver{}, nil })
+}
+
+func TestStoredDriverConfigs(t *testing.T) {
+	c := testsupport.NewCluster(t, 1)
+	leader := c.WaitForLeader(10 * time.Second)
+	s := NewServer("", leader.Store)
+	s.NodeID = "n1"
+	t.Cleanup(func() { close(s.stopCh) })
+
+	post := func(body string) int {
+		w := httptest.NewRecorder()
+		s.handlePrinters(w, httptest.NewRequest(http.MethodPost, "/api/v1/printers?upsert=true", strings.NewReader(body)))
+		return w.Code
+	}
+	if code := post(`{"id":"p1","name":"P1","driver":{"driver":"nope","address":"http://x"}}`); code != http.StatusBadRequest {
+		t.Fatalf("an unknown driver without a node got %d, want 400", code)
+	}
+	if code := post(`{"id":"p1","name":"P1","driver":{"node":"n1","driver":"test-idle","address":"http://a"}}`); code != http.StatusCreated {
+		t.Fatalf("storing a driver config got %d", code)
+	}
+	post(`{"id":"p2","name":"P2","driver":{"node":"n2","driver":"test-idle","address":"http://b"}}`)
+	post(`{"id":"p3","name":"P3"}`)
+
+	running := make(map[string]storedDriver)
+	failed := make(map[string]drivers.Config)
+	s.syncStoredDrivers(running, failed)
+	if len(running) != 1 || running["p1"].config.Address != "http://a" {
+		t.Fatalf("driving %v, want only p1", running)
+	}
+
+	// A changed config restarts the driver; moving the printer stops it
+	first := running["p1"].stop
+	post(`{"id":"p1","name":"P1","driver":{"node":"n1","driver":"test-idle","address":"http://c"}}`)
+	s.syncStoredDrivers(running, failed)
+	if running["p1"].config.Address != "http://c" {
+		t.Fatalf("driving %v after the address changed", running)
+	}
+	select {
+	case <-first:
+	default:
+		t.Fatal("the driver with the old config was not stopped")
+	}
+	post(`{"id":"p1","name":"P1","driver":{"node":"n2","driver":"test-idle","address":"http://c"}}`)
+	s.syncStoredDrivers(running, failed)
+	if len(running) != 0 {
+		t.Fatalf("still driving %v after the printer moved", running)
+	}
+}
+
+func TestDriverConfigNeedsAdmin(t *testing.T) {
+	c := testsupport.NewCluster(t, 1)
+	leader := c.WaitForLeader(10 * time.Second)
+	s := NewServer("", leader.Store)
+	s.EnableAuth([]APIKey{{Key: "member-key", Principal: Principal{Name: "alice", Role: RoleMember}}})
+
+	r := httptest.NewRequest(http.MethodPost, "/api/v1/printers", strings.NewReader(
+		`{"id":"p1","name":"P1","driver":{"node":"n1","driver":"test-idle","address":"http://a","api_key_ref":"/etc/raft3d/admin.key"}}`))
+	r = r.WithContext(context.WithValue(r.Context(), principalKey{}, Principal{Name: "alice", Role: RoleMember}))
+	w := httptest.NewRecorder()
+	s.handlePrinters(w, r)
+	if w.Code != http.StatusForbidden {
+		t.Fatalf("a member storing a driver config got %d, want 403", w.Code)
+	}
+	var printer Printer
+	if value, err := leader.Store.Get("printer_p1"); err == nil {
+		json.Unmarshal([]byte(value), &printer)
+		t.Fatalf("stored %+v", printer)
+	}
+}
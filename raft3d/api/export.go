@@ -0,0 +1,248 @@
+package api
+
+import (
+	"encoding/csv"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// csvFlushEvery is how many rows are buffered before flushing to the client
+const csvFlushEvery = 100
+
+// wantsCSV reports whether the request asked for ?format=csv. Any format
+// other than json or csv is rejected with a validation problem.
+func wantsCSV(w http.ResponseWriter, r *http.Request) (csvOut bool, ok bool) {
+	switch r.URL.Query().Get("format") {
+	case "", "json":
+		return false, true
+	case "csv":
+		return true, true
+	}
+	writeValidationProblem(w, r, []FieldError{{Name: "format", Reason: "must be one of [json csv]"}})
+	return false, false
+}
+
+// writeCSV streams rows as a CSV attachment. The rows function calls emit
+// once per row.
+func writeCSV(w http.ResponseWriter, filename string, header []string, rows func(emit func([]string) error) error) {
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+
+	cw := csv.NewWriter(w)
+	flusher, _ := w.(http.Flusher)
+	cw.Write(header)
+
+	n := 0
+	rows(func(row []string) error {
+		if err := cw.Write(row); err != nil {
+			return err
+		}
+		if n++; n%csvFlushEvery == 0 {
+			cw.Flush()
+			if flusher != nil {
+				flusher.Flush()
+			}
+		}
+		return cw.Error()
+	})
+	cw.Flush()
+}
+
+// sortedKeys returns the keys of a map in order
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// csvTime formats an optional time for CSV output
+func csvTime(t *time.Time) string {
+	if t == nil || t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339)
+}
+
+// csvFloat formats a number without a trailing exponent
+func csvFloat(f float64) string {
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
+
+// writePrintersCSV writes printers as CSV
+func writePrintersCSV(w http.ResponseWriter, printers map[string]Printer) {
+	header := []string{"id", "name", "model", "status", "temperature", "material", "group_id"}
+	writeCSV(w, "printers.csv", header, func(emit func([]string) error) error {
+		for _, id := range sortedKeys(printers) {
+			p := printers[id]
+			if err := emit([]string{p.ID, p.Name, p.Model, p.Status, strconv.Itoa(p.Temperature), p.Material, p.GroupID}); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// writeFilamentsCSV writes filaments as CSV
+func writeFilamentsCSV(w http.ResponseWriter, filaments map[string]Filament) {
+	header := []string{"id", "name", "type", "color", "color_hex", "color_family", "total_weight_in_grams",
+		"remaining_weight_in_grams", "cost_per_kg", "vendor_id", "sku", "lot_number", "purchase_date", "purchase_price"}
+	writeCSV(w, "filaments.csv", header, func(emit func([]string) error) error {
+		for _, id := range sortedKeys(filaments) {
+			f := filaments[id]
+			row := []string{f.ID, f.Name, f.Type, f.Color.String(), f.Color.Hex, f.colorFamily(), csvFloat(f.TotalWeightInGrams),
+				csvFloat(f.RemainingWeightInGrams), csvFloat(f.CostPerKg),
+				f.VendorID, f.SKU, f.LotNumber, csvTime(f.PurchaseDate), csvFloat(f.PurchasePrice)}
+			if err := emit(row); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// writePrintJobsCSV writes print jobs as CSV
+func writePrintJobsCSV(w http.ResponseWriter, jobs map[string]PrintJob) {
+	header := []string{"id", "printer_id", "printer_group_id", "filament_id", "filepath", "print_weight_in_grams",
+		"status", "submitted_by", "depends_on", "due_by", "created_at", "started_at", "completed_at",
+		"material_cost", "estimated_start", "estimated_completion"}
+	writeCSV(w, "print_jobs.csv", header, func(emit func([]string) error) error {
+		for _, id := range sortedKeys(jobs) {
+			j := jobs[id]
+			row := []string{j.ID, j.PrinterID, j.PrinterGroupID, j.FilamentID, j.FilePath,
+				csvFloat(j.PrintWeightInGrams), j.Status, j.SubmittedBy, strings.Join(j.DependsOn, " "),
+				csvTime(j.DueBy), csvTime(&j.CreatedAt), csvTime(j.StartedAt), csvTime(j.CompletedAt),
+				csvFloat(j.MaterialCost), csvTime(j.EstimatedStart), csvTime(j.EstimatedCompletion)}
+			if err := emit(row); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// writeUtilizationReportCSV writes a utilization report as CSV, the total
+// first with an empty printer
+func writeUtilizationReportCSV(w http.ResponseWriter, report UtilizationReport) {
+	header := []string{"printer_id", "jobs", "busy_hours", "maintenance_hours", "available_hours", "utilization_pct"}
+	writeCSV(w, "utilization.csv", header, func(emit func([]string) error) error {
+		row := func(printerID string, u PrinterUtilization) []string {
+			return []string{printerID, strconv.Itoa(u.Jobs), csvFloat(u.BusyHours), csvFloat(u.MaintenanceHours),
+				csvFloat(u.AvailableHours), csvFloat(u.UtilizationPct)}
+		}
+		if err := emit(row("", report.Total)); err != nil {
+			return err
+		}
+		for _, id := range sortedKeys(report.ByPrinter) {
+			if err := emit(row(id, report.ByPrinter[id])); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// writeReorderReportCSV writes a reorder report as CSV, one row per filament
+// with its vendor's details repeated so rows can be sorted and filtered
+func writeReorderReportCSV(w http.ResponseWriter, report ReorderReport) {
+	header := []string{"vendor_id", "vendor_name", "vendor_email", "account_ref", "lead_time_days", "filament_id", "name",
+		"type", "color", "sku", "reason", "remaining_weight_in_grams", "grams_per_day", "depletion_date",
+		"suggested_grams", "suggested_spools", "estimated_cost"}
+	writeCSV(w, "reorder.csv", header, func(emit func([]string) error) error {
+		for _, v := range report.Vendors {
+			for _, item := range v.Items {
+				row := []string{v.VendorID, v.VendorName, v.Email, v.AccountRef, strconv.Itoa(v.LeadTimeDays), item.FilamentID,
+					item.Name, item.Type, item.Color, item.SKU, item.Reason, csvFloat(item.RemainingWeightInGrams),
+					csvFloat(item.GramsPerDay), csvTime(item.DepletionDate), csvFloat(item.SuggestedGrams),
+					strconv.Itoa(item.SuggestedSpools), csvFloat(item.EstimatedCost)}
+				if err := emit(row); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+}
+
+// writeUsageCSV writes filament usage records as CSV
+func writeUsageCSV(w http.ResponseWriter, usages []FilamentUsage) {
+	header := []string{"recorded_at", "print_job_id", "filament_id", "filament_type", "printer_id",
+		"submitted_by", "weight_in_grams", "cost"}
+	writeCSV(w, "filament_usage.csv", header, func(emit func([]string) error) error {
+		for _, u := range usages {
+			row := []string{csvTime(&u.RecordedAt), u.PrintJobID, u.FilamentID, u.FilamentType, u.PrinterID,
+				u.SubmittedBy, csvFloat(u.WeightInGrams), csvFloat(u.Cost)}
+			if err := emit(row); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// writeCostReportCSV flattens a cost report into one row per dimension/key
+func writeCostReportCSV(w http.ResponseWriter, report CostReport) {
+	header := []string{"dimension", "key", "jobs", "weight_in_grams", "cost"}
+	writeCSV(w, "costs.csv", header, func(emit func([]string) error) error {
+		row := func(dimension, key string, c CostSummary) []string {
+			return []string{dimension, key, strconv.Itoa(c.Jobs), csvFloat(c.WeightInGrams), csvFloat(c.Cost)}
+		}
+		if err := emit(row("total", "", report.Total)); err != nil {
+			return err
+		}
+		for _, dim := range []struct {
+			name string
+			data map[string]CostSummary
+		}{
+			{"printer", report.ByPrinter},
+			{"filament_type", report.ByFilamentType},
+			{report.Period, report.ByPeriod},
+		} {
+			for _, key := range sortedKeys(dim.data) {
+				if err := emit(row(dim.name, key, dim.data[key])); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+}
+
+// writeDefectReportCSV writes a defect report as rows of dimension, key and
+// counts; defect codes only have a count of failures
+func writeDefectReportCSV(w http.ResponseWriter, report DefectReport) {
+	header := []string{"dimension", "key", "inspected", "failed", "defect_rate"}
+	writeCSV(w, "defects.csv", header, func(emit func([]string) error) error {
+		row := func(dimension, key string, d DefectSummary) []string {
+			return []string{dimension, key, strconv.Itoa(d.Inspected), strconv.Itoa(d.Failed), csvFloat(d.DefectRate)}
+		}
+		if err := emit(row("total", "", report.Total)); err != nil {
+			return err
+		}
+		for _, dim := range []struct {
+			name string
+			data map[string]DefectSummary
+		}{
+			{"printer", report.ByPrinter},
+			{"filament_lot", report.ByFilamentLot},
+		} {
+			for _, key := range sortedKeys(dim.data) {
+				if err := emit(row(dim.name, key, dim.data[key])); err != nil {
+					return err
+				}
+			}
+		}
+		for _, code := range sortedKeys(report.ByDefectCode) {
+			if err := emit([]string{"defect_code", code, "", strconv.Itoa(report.ByDefectCode[code]), ""}); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
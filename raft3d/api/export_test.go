@@ -0,0 +1,70 @@
+package api
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"raft3d/testsupport"
+)
+
+func TestCSVExport(t *testing.T) {
+	c := testsupport.NewCluster(t, 1)
+	leader := c.WaitForLeader(10 * time.Second)
+	s := NewServer("", leader.Store)
+
+	put := func(key string, v interface{}) {
+		body, _ := json.Marshal(v)
+		if err := leader.Store.Set(key, string(body)); err != nil {
+			t.Fatalf("set %s: %s", key, err)
+		}
+	}
+	put("filament_f2", Filament{ID: "f2", Name: `Silk "Gold", 1.75mm`, Type: "PLA", TotalWeightInGrams: 1000, RemainingWeightInGrams: 412.5})
+	put("filament_f1", Filament{ID: "f1", Name: "Black", Type: "PETG", TotalWeightInGrams: 750, RemainingWeightInGrams: 750, CostPerKg: 24.99})
+
+	get := func(url string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		s.handleGetFilaments(w, httptest.NewRequest(http.MethodGet, url, nil))
+		return w
+	}
+
+	w := get("/api/v1/filaments?format=csv")
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "text/csv; charset=utf-8" ||
+		w.Header().Get("Content-Disposition") != `attachment; filename="filaments.csv"` {
+		t.Fatalf("csv export: %d %v", w.Code, w.Header())
+	}
+	records, err := csv.NewReader(w.Body).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 3 {
+		t.Fatalf("records: %q", records)
+	}
+	column := make(map[string]int)
+	for i, name := range records[0] {
+		column[name] = i
+	}
+	// Rows are in ID order; quoting survives the round trip and weights
+	// keep their fractions
+	for i, want := range []map[string]string{
+		{"id": "f1", "name": "Black", "type": "PETG", "remaining_weight_in_grams": "750", "cost_per_kg": "24.99"},
+		{"id": "f2", "name": `Silk "Gold", 1.75mm`, "type": "PLA", "remaining_weight_in_grams": "412.5", "cost_per_kg": "0"},
+	} {
+		for name, value := range want {
+			if got := records[i+1][column[name]]; got != value {
+				t.Errorf("row %d %s = %q, want %q", i+1, name, got, value)
+			}
+		}
+	}
+
+	if w := get("/api/v1/filaments?format=json"); w.Code != http.StatusOK || !strings.HasPrefix(w.Header().Get("Content-Type"), "application/json") {
+		t.Fatalf("json export: %d %v", w.Code, w.Header())
+	}
+	if w := get("/api/v1/filaments?format=xml"); w.Code != http.StatusBadRequest {
+		t.Fatalf("unknown format: %d %s", w.Code, w.Body)
+	}
+}
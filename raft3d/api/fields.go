@@ -0,0 +1,92 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"strings"
+)
+
+// parseFields reads ?fields=, a comma-separated list of JSON field names of
+// T to keep in each list item. It returns nil when every field is wanted.
+func parseFields[T any](r *http.Request) ([]string, []FieldError) {
+	value := r.URL.Query().Get("fields")
+	if value == "" {
+		return nil, nil
+	}
+
+	known := jsonFieldNames(reflect.TypeOf((*T)(nil)).Elem())
+	var fields []string
+	var unknown []string
+	for _, name := range strings.Split(value, ",") {
+		if name = strings.TrimSpace(name); name == "" {
+			continue
+		}
+		if !known[name] {
+			unknown = append(unknown, name)
+			continue
+		}
+		fields = append(fields, name)
+	}
+	if len(unknown) > 0 {
+		return nil, []FieldError{{Name: "fields", Reason: "unknown fields: " + strings.Join(unknown, ", ")}}
+	}
+	return fields, nil
+}
+
+// jsonFieldNames returns the names a struct type's fields are encoded as,
+// including those of embedded structs
+func jsonFieldNames(t reflect.Type) map[string]bool {
+	names := make(map[string]bool)
+	if t.Kind() != reflect.Struct {
+		return names
+	}
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := field.Tag.Get("json")
+		name, _, _ := strings.Cut(tag, ",")
+		if name == "-" || (!field.IsExported() && !field.Anonymous) {
+			continue
+		}
+		if field.Anonymous && name == "" {
+			embedded := field.Type
+			if embedded.Kind() == reflect.Pointer {
+				embedded = embedded.Elem()
+			}
+			for n := range jsonFieldNames(embedded) {
+				names[n] = true
+			}
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		names[name] = true
+	}
+	return names
+}
+
+// sparse encodes item and keeps only the given fields. Fields the item
+// omits, such as empty omitempty ones, stay absent.
+func sparse[T any](item T, fields []string) map[string]json.RawMessage {
+	data, _ := json.Marshal(item)
+	var all map[string]json.RawMessage
+	json.Unmarshal(data, &all)
+
+	kept := make(map[string]json.RawMessage, len(fields))
+	for _, name := range fields {
+		if value, ok := all[name]; ok {
+			kept[name] = value
+		}
+	}
+	return kept
+}
+
+// sparseList applies sparse to every item
+func sparseList[T any](items []T, fields []string) []map[string]json.RawMessage {
+	kept := make([]map[string]json.RawMessage, len(items))
+	for i, item := range items {
+		kept[i] = sparse(item, fields)
+	}
+	return kept
+}
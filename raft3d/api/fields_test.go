@@ -0,0 +1,76 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestSparseFieldsets(t *testing.T) {
+	type Base struct {
+		ID string `json:"id"`
+	}
+	type item struct {
+		Base
+		Status   string `json:"status"`
+		Notes    string `json:"notes,omitempty"`
+		Secret   string `json:"-"`
+		Untagged string
+	}
+	items := []item{{Base: Base{ID: "a"}, Status: "Queued", Notes: "rush"}, {Base: Base{ID: "b"}, Status: "Done"}}
+
+	tests := []struct {
+		name   string
+		query  string
+		want   string // sorted keys of each item, or the error
+		status int
+	}{
+		{"all fields", "", "Untagged,id,notes,status;Untagged,id,status", http.StatusOK},
+		{"selected fields", "fields=id,status", "id,status;id,status", http.StatusOK},
+		{"spaces and empty names", "fields=id,%20notes,,", "id,notes;id", http.StatusOK},
+		{"untagged field", "fields=Untagged", "Untagged;Untagged", http.StatusOK},
+		{"unknown field", "fields=id,colour", "unknown fields: colour", http.StatusBadRequest},
+		{"ignored field", "fields=Secret", "unknown fields: Secret", http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			writeList(w, httptest.NewRequest(http.MethodGet, "/api/v1/items?"+tt.query, nil), items)
+			if w.Code != tt.status {
+				t.Fatalf("got %d, want %d: %s", w.Code, tt.status, w.Body)
+			}
+			if tt.status != http.StatusOK {
+				if !strings.Contains(w.Body.String(), tt.want) {
+					t.Fatalf("error %s, want %q", w.Body, tt.want)
+				}
+				return
+			}
+			var got []map[string]json.RawMessage
+			if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+				t.Fatal(err)
+			}
+			var shapes []string
+			for _, fields := range got {
+				var keys []string
+				for key := range fields {
+					keys = append(keys, key)
+				}
+				sort.Strings(keys)
+				shapes = append(shapes, strings.Join(keys, ","))
+			}
+			if strings.Join(shapes, ";") != tt.want {
+				t.Fatalf("items held %s, want %s", strings.Join(shapes, ";"), tt.want)
+			}
+		})
+	}
+
+	// Keyed lists trim each value
+	w := httptest.NewRecorder()
+	writeMap(w, httptest.NewRequest(http.MethodGet, "/api/v1/items?fields=status", nil), map[string]item{"a": items[0]})
+	if strings.TrimSpace(w.Body.String()) != `{"a":{"status":"Queued"}}` {
+		t.Fatalf("keyed list: %s", w.Body)
+	}
+}
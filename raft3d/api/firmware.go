@@ -0,0 +1,313 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"raft3d/raft"
+)
+
+// firmwareCampaignKeyPrefix prefixes firmware campaigns in the store
+const firmwareCampaignKeyPrefix = "firmware_campaign_"
+
+// EventFirmwareStatus is published when a printer in a firmware campaign is
+// marked pending, updated or failed
+const EventFirmwareStatus = "firmware.status_changed"
+
+// Statuses of a printer in a firmware campaign
+const (
+	FirmwarePending = "pending"
+	FirmwareUpdated = "updated"
+	FirmwareFailed  = "failed"
+)
+
+// validFirmwareTransitions lists the statuses a printer in a campaign can be
+// marked with next. Updated is final; a failed update can be retried.
+var validFirmwareTransitions = map[string][]string{
+	FirmwarePending: {FirmwareUpdated, FirmwareFailed},
+	FirmwareFailed:  {FirmwarePending, FirmwareUpdated, FirmwareFailed},
+}
+
+// FirmwareCampaign is a rollout of one firmware version to a set of
+// printers, recording how far each got
+type FirmwareCampaign struct {
+	ID            string `json:"id"`
+	Name          string `json:"name" validate:"required"`
+	TargetVersion string `json:"target_version" validate:"required"`
+
+	// Printers are chosen by ID, or else every printer of Model
+	PrinterIDs []string `json:"printer_ids,omitempty"`
+	Model      string   `json:"model,omitempty"`
+
+	Printers map[string]FirmwareTarget `json:"printers"` // by printer ID
+	Summary  FirmwareSummary           `json:"summary"`
+
+	// Revision counts changes, so concurrent ones don't overwrite each other
+	Revision  int        `json:"revision"`
+	CreatedAt *time.Time `json:"created_at,omitempty"`
+	CreatedBy string     `json:"created_by,omitempty"`
+}
+
+// FirmwareTarget is one printer's progress in a campaign
+type FirmwareTarget struct {
+	Status      string     `json:"status"`
+	FromVersion string     `json:"from_version,omitempty"` // what the printer ran when the campaign started
+	Error       string     `json:"error,omitempty"`        // why the last attempt failed
+	UpdatedAt   *time.Time `json:"updated_at,omitempty"`
+	UpdatedBy   string     `json:"updated_by,omitempty"`
+}
+
+// FirmwareSummary counts a campaign's printers by status
+type FirmwareSummary struct {
+	Total    int  `json:"total"`
+	Pending  int  `json:"pending"`
+	Updated  int  `json:"updated"`
+	Failed   int  `json:"failed"`
+	Complete bool `json:"complete"` // every printer is updated
+}
+
+// summarize recounts the campaign's printers
+func (c *FirmwareCampaign) summarize() {
+	c.Summary = FirmwareSummary{Total: len(c.Printers)}
+	for _, target := range c.Printers {
+		switch target.Status {
+		case FirmwarePending:
+			c.Summary.Pending++
+		case FirmwareUpdated:
+			c.Summary.Updated++
+		case FirmwareFailed:
+			c.Summary.Failed++
+		}
+	}
+	c.Summary.Complete = c.Summary.Updated == c.Summary.Total
+}
+
+// FirmwareMark is the body of PUT /firmware_campaigns/{id}/printers/{printer}
+type FirmwareMark struct {
+	Status string `json:"status" validate:"required,oneof=pending updated failed"`
+	Error  string `json:"error,omitempty"`
+}
+
+// handleFirmwareCampaigns handles GET/POST /firmware_campaigns, GET/DELETE
+// /firmware_campaigns/{id} and PUT /firmware_campaigns/{id}/printers/{printer}.
+// Changing campaigns requires the admin or operator role.
+func (s *Server) handleFirmwareCampaigns(w http.ResponseWriter, r *http.Request) {
+	path := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/v1/firmware_campaigns"), "/")
+	id, printerID, _ := strings.Cut(path, "/printers/")
+
+	switch {
+	case path == "" && r.Method == http.MethodGet:
+		all, err := loadAll[FirmwareCampaign](s, firmwareCampaignKeyPrefix)
+		if err != nil {
+			s.writeStoreError(w, r, err, "Failed to retrieve firmware campaigns")
+			return
+		}
+		campaigns := []FirmwareCampaign{}
+		for _, campaign := range all {
+			campaigns = append(campaigns, campaign)
+		}
+		sort.Slice(campaigns, func(i, j int) bool { return campaigns[i].ID < campaigns[j].ID })
+		writeList(w, r, campaigns)
+	case path == "" && r.Method == http.MethodPost:
+		if s.requireRole(w, r, RoleAdmin, RoleOperator) {
+			s.handlePostFirmwareCampaign(w, r)
+		}
+	case strings.Contains(id, "/"):
+		writeError(w, r, http.StatusNotFound, CodeNotFound, "Not found")
+	case printerID != "" && r.Method == http.MethodPut:
+		if s.requireRole(w, r, RoleAdmin, RoleOperator) {
+			s.handleMarkFirmware(w, r, id, printerID)
+		}
+	case printerID == "" && r.Method == http.MethodGet:
+		value, err := s.store.Get(firmwareCampaignKeyPrefix + id)
+		if err != nil {
+			s.writeStoreError(w, r, err, "Firmware campaign not found")
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(value))
+	case printerID == "" && r.Method == http.MethodDelete:
+		if !s.requireRole(w, r, RoleAdmin, RoleOperator) {
+			return
+		}
+		if _, err := s.store.Get(firmwareCampaignKeyPrefix + id); err != nil {
+			s.writeStoreError(w, r, err, "Firmware campaign not found")
+			return
+		}
+		if err := s.storeFor(r).Delete(firmwareCampaignKeyPrefix + id); err != nil {
+			s.writeStoreError(w, r, err, "Failed to delete firmware campaign")
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		methodNotAllowed(w, r)
+	}
+}
+
+// handlePostFirmwareCampaign starts a campaign. Its printers start pending,
+// except those already running the target version, which count as updated.
+func (s *Server) handlePostFirmwareCampaign(w http.ResponseWriter, r *http.Request) {
+	var campaign FirmwareCampaign
+	if !decodeJSON(w, r, &campaign) {
+		return
+	}
+	if len(campaign.PrinterIDs) == 0 && campaign.Model == "" {
+		writeValidationProblem(w, r, []FieldError{{Name: "printer_ids", Reason: "name printers or a model"}})
+		return
+	}
+
+	printers, err := s.listPrinters()
+	if err != nil {
+		s.writeStoreError(w, r, err, "Failed to retrieve printers")
+		return
+	}
+	byID := make(map[string]Printer, len(printers))
+	for _, printer := range printers {
+		byID[printer.ID] = printer
+	}
+	var chosen []Printer
+	if len(campaign.PrinterIDs) > 0 {
+		var errs []FieldError
+		for i, id := range campaign.PrinterIDs {
+			printer, ok := byID[id]
+			if !ok {
+				errs = append(errs, FieldError{Name: fmt.Sprintf("printer_ids[%d]", i), Reason: "printer does not exist"})
+				continue
+			}
+			chosen = append(chosen, printer)
+		}
+		if len(errs) > 0 {
+			writeValidationProblem(w, r, errs)
+			return
+		}
+	} else {
+		for _, printer := range printers {
+			if printer.Model == campaign.Model {
+				chosen = append(chosen, printer)
+			}
+		}
+		if len(chosen) == 0 {
+			writeValidationProblem(w, r, []FieldError{{Name: "model", Reason: "no printer is of this model"}})
+			return
+		}
+	}
+
+	now := time.Now().UTC()
+	campaign.PrinterIDs = nil
+	campaign.Printers = make(map[string]FirmwareTarget, len(chosen))
+	for _, printer := range chosen {
+		target := FirmwareTarget{Status: FirmwarePending, FromVersion: printer.FirmwareVersion}
+		if printer.FirmwareVersion == campaign.TargetVersion {
+			target.Status, target.UpdatedAt = FirmwareUpdated, &now
+		}
+		campaign.Printers[printer.ID] = target
+	}
+	campaign.summarize()
+	campaign.Revision, campaign.CreatedAt = 1, &now
+	if principal, ok := principalFrom(r); ok {
+		campaign.CreatedBy = principal.Name
+	}
+
+	body, err := json.Marshal(campaign)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, CodeInternal, "Failed to process firmware campaign")
+		return
+	}
+	stored, err := s.storeNew(r, firmwareCampaignKeyPrefix, campaign.ID, string(body))
+	if err != nil {
+		s.writeStoreError(w, r, err, "Failed to store firmware campaign")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	w.Write([]byte(stored))
+}
+
+// handleMarkFirmware records how a printer's update went. Marking it updated
+// also sets the printer's firmware version, in the same log entry.
+func (s *Server) handleMarkFirmware(w http.ResponseWriter, r *http.Request, id, printerID string) {
+	var mark FirmwareMark
+	if !decodeJSON(w, r, &mark) {
+		return
+	}
+
+	key := firmwareCampaignKeyPrefix + id
+	value, err := s.store.Get(key)
+	if err != nil {
+		s.writeStoreError(w, r, err, "Firmware campaign not found")
+		return
+	}
+	var campaign FirmwareCampaign
+	if err := json.Unmarshal([]byte(value), &campaign); err != nil {
+		writeError(w, r, http.StatusInternalServerError, CodeInternal, "Failed to parse firmware campaign")
+		return
+	}
+	target, ok := campaign.Printers[printerID]
+	if !ok {
+		writeError(w, r, http.StatusNotFound, CodeNotFound, "Printer is not part of this firmware campaign")
+		return
+	}
+	allowed := false
+	for _, next := range validFirmwareTransitions[target.Status] {
+		allowed = allowed || next == mark.Status
+	}
+	if !allowed {
+		writeError(w, r, http.StatusConflict, CodeInvalidTransition,
+			fmt.Sprintf("Printer %s is %s in this campaign and can't be marked %s", printerID, target.Status, mark.Status))
+		return
+	}
+
+	now := time.Now().UTC()
+	target.Status, target.Error, target.UpdatedAt, target.UpdatedBy = mark.Status, "", &now, ""
+	if mark.Status == FirmwareFailed {
+		target.Error = mark.Error
+	}
+	if principal, ok := principalFrom(r); ok {
+		target.UpdatedBy = principal.Name
+	}
+	campaign.Printers[printerID] = target
+	campaign.summarize()
+	conditions := []raft.Condition{{Key: key, Field: "revision", Equals: strconv.Itoa(campaign.Revision)}}
+	campaign.Revision++
+
+	body, err := json.Marshal(campaign)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, CodeInternal, "Failed to process firmware campaign")
+		return
+	}
+	values := map[string]string{key: string(body)}
+	if mark.Status == FirmwareUpdated {
+		printer, err := s.getPrinter(printerID)
+		if err != nil {
+			s.writeStoreError(w, r, err, "Printer not found")
+			return
+		}
+		conditions = append(conditions, raft.Condition{Key: "printer_" + printerID, Field: "status", Equals: printer.Status})
+		printer.FirmwareVersion = campaign.TargetVersion
+		printerBody, err := json.Marshal(printer)
+		if err != nil {
+			writeError(w, r, http.StatusInternalServerError, CodeInternal, "Failed to process printer data")
+			return
+		}
+		values["printer_"+printerID] = string(printerBody)
+	}
+	if err := s.storeFor(r).SetMany(values, conditions...); err != nil {
+		s.writeStoreError(w, r, err, "Failed to store firmware campaign")
+		return
+	}
+	s.publish(EventFirmwareStatus, map[string]interface{}{
+		"campaign_id":    campaign.ID,
+		"printer_id":     printerID,
+		"status":         mark.Status,
+		"target_version": campaign.TargetVersion,
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(body)
+}
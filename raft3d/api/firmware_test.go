@@ -0,0 +1,81 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"raft3d/testsupport"
+)
+
+func TestFirmwareCampaign(t *testing.T) {
+	c := testsupport.NewCluster(t, 1)
+	leader := c.WaitForLeader(10 * time.Second)
+	s := NewServer("", leader.Store)
+	s.EnableAuth([]APIKey{{Key: "x", Principal: Principal{Name: "root", Role: RoleAdmin}}})
+
+	for id, firmware := range map[string]string{"p1": "2.0.9", "p2": "2.1.0", "p3": "2.0.9"} {
+		model := "MK4"
+		if id == "p3" {
+			model = "Mini"
+		}
+		printer, _ := json.Marshal(Printer{ID: id, Name: id, Model: model, Status: "Idle", FirmwareVersion: firmware})
+		if err := leader.Store.Set("printer_"+id, string(printer)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	do := func(role, method, url, body string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, url, strings.NewReader(body))
+		r = r.WithContext(context.WithValue(r.Context(), principalKey{}, Principal{Name: role + "-user", Role: role}))
+		rec := httptest.NewRecorder()
+		s.handleFirmwareCampaigns(rec, r)
+		return rec
+	}
+	decode := func(rec *httptest.ResponseRecorder) FirmwareCampaign {
+		var campaign FirmwareCampaign
+		if err := json.NewDecoder(rec.Body).Decode(&campaign); err != nil {
+			t.Fatal(err)
+		}
+		return campaign
+	}
+
+	if rec := do(RoleMember, http.MethodPost, "/api/v1/firmware_campaigns", `{"id":"fw","name":"MK4 2.1.0","target_version":"2.1.0","model":"MK4"}`); rec.Code != http.StatusForbidden {
+		t.Fatalf("member started a campaign: %d", rec.Code)
+	}
+	rec := do(RoleOperator, http.MethodPost, "/api/v1/firmware_campaigns", `{"id":"fw","name":"MK4 2.1.0","target_version":"2.1.0","model":"MK4"}`)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("start campaign: %d %s", rec.Code, rec.Body)
+	}
+	// p2 already runs the target version
+	campaign := decode(rec)
+	if campaign.Summary != (FirmwareSummary{Total: 2, Pending: 1, Updated: 1}) || campaign.Printers["p1"].FromVersion != "2.0.9" {
+		t.Fatalf("campaign = %+v", campaign)
+	}
+
+	rec = do(RoleOperator, http.MethodPut, "/api/v1/firmware_campaigns/fw/printers/p1", `{"status":"failed","error":"checksum mismatch"}`)
+	if rec.Code != http.StatusOK || decode(rec).Printers["p1"].Error != "checksum mismatch" {
+		t.Fatalf("mark failed: %d %s", rec.Code, rec.Body)
+	}
+	rec = do(RoleOperator, http.MethodPut, "/api/v1/firmware_campaigns/fw/printers/p1", `{"status":"updated"}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("mark updated: %d %s", rec.Code, rec.Body)
+	}
+	if campaign := decode(rec); !campaign.Summary.Complete || campaign.Revision != 3 {
+		t.Fatalf("campaign = %+v", campaign)
+	}
+	if printer, err := s.getPrinter("p1"); err != nil || printer.FirmwareVersion != "2.1.0" {
+		t.Fatalf("printer = %+v, %v", printer, err)
+	}
+
+	if rec := do(RoleOperator, http.MethodPut, "/api/v1/firmware_campaigns/fw/printers/p1", `{"status":"failed"}`); rec.Code != http.StatusConflict {
+		t.Fatalf("updated printer marked failed: %d", rec.Code)
+	}
+	if rec := do(RoleOperator, http.MethodPut, "/api/v1/firmware_campaigns/fw/printers/p3", `{"status":"updated"}`); rec.Code != http.StatusNotFound {
+		t.Fatalf("printer outside the campaign: %d", rec.Code)
+	}
+}
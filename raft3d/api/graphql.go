@@ -0,0 +1,530 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+)
+
+// GraphQLRequest is the body of POST /graphql
+type GraphQLRequest struct {
+	Query         string                 `json:"query"`
+	OperationName string                 `json:"operationName,omitempty"`
+	Variables     map[string]interface{} `json:"variables,omitempty"`
+}
+
+// GraphQLError is an entry of a GraphQL response's errors list
+type GraphQLError struct {
+	Message string   `json:"message"`
+	Path    []string `json:"path,omitempty"`
+}
+
+// QueueEntry is a job's place in a printer's queue
+type QueueEntry struct {
+	Position int    `json:"position"`
+	JobID    string `json:"job_id"`
+}
+
+// graphQLMaxQueryBytes limits the size of a query, and of the request body
+// carrying it
+const graphQLMaxQueryBytes = 64 << 10
+
+// handleGraphQL handles GET and POST /graphql. Queries are read-only and
+// served from this node's copy of the state, like the REST list endpoints.
+func (s *Server) handleGraphQL(w http.ResponseWriter, r *http.Request) {
+	var req GraphQLRequest
+	switch r.Method {
+	case http.MethodGet:
+		req.Query = r.URL.Query().Get("query")
+	case http.MethodPost:
+		if r.ContentLength > graphQLMaxQueryBytes {
+			writeGraphQLErrors(w, http.StatusRequestEntityTooLarge, GraphQLError{Message: fmt.Sprintf("request body is larger than %d bytes", graphQLMaxQueryBytes)})
+			return
+		}
+		r.Body = http.MaxBytesReader(w, r.Body, graphQLMaxQueryBytes)
+		if !decodeJSON(w, r, &req) {
+			return
+		}
+	default:
+		methodNotAllowed(w, r)
+		return
+	}
+
+	if len(req.Query) > graphQLMaxQueryBytes {
+		writeGraphQLErrors(w, http.StatusRequestEntityTooLarge, GraphQLError{Message: fmt.Sprintf("query is larger than %d bytes", graphQLMaxQueryBytes)})
+		return
+	}
+	if strings.TrimSpace(req.Query) == "" {
+		writeGraphQLErrors(w, http.StatusBadRequest, GraphQLError{Message: "query is required"})
+		return
+	}
+	if len(req.Variables) > 0 {
+		writeGraphQLErrors(w, http.StatusBadRequest, GraphQLError{Message: "variables are not supported"})
+		return
+	}
+	selections, err := parseGraphQL(req.Query)
+	if err != nil {
+		writeGraphQLErrors(w, http.StatusBadRequest, GraphQLError{Message: err.Error()})
+		return
+	}
+
+	exec := &gqlExecutor{loader: &gqlLoader{s: s}}
+	data := exec.resolveObject("Query", nil, selections, nil)
+
+	response := map[string]interface{}{"data": data}
+	if len(exec.errors) > 0 {
+		response["errors"] = exec.errors
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// writeGraphQLErrors writes a response for a query that couldn't be executed
+func writeGraphQLErrors(w http.ResponseWriter, status int, errs ...GraphQLError) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]interface{}{"errors": errs})
+}
+
+// gqlLoader loads each kind of entity at most once per query, however many
+// times and at whatever depth it is referenced, so nested queries cost one
+// scan of the store per kind rather than one read per parent
+type gqlLoader struct {
+	s *Server
+
+	printers  map[string]Printer
+	filaments map[string]Filament
+	jobs      map[string]PrintJob
+	groups    map[string]PrinterGroup
+}
+
+// loadAll reads every value under a key prefix into a map of decoded values
+func loadAll[T any](s *Server, prefix string) (map[string]T, error) {
+	keys, err := s.store.List(prefix)
+	if err != nil {
+		return nil, err
+	}
+	values := make(map[string]T, len(keys))
+	for _, key := range keys {
+		value, err := s.store.Get(key)
+		if err != nil {
+			continue
+		}
+		var v T
+		if err := json.Unmarshal([]byte(value), &v); err != nil {
+			continue
+		}
+		values[strings.TrimPrefix(key, prefix)] = v
+	}
+	return values, nil
+}
+
+// loadPrinters returns every printer with the telemetry this node holds
+func (l *gqlLoader) loadPrinters() (map[string]Printer, error) {
+	if l.printers == nil {
+		printers, err := loadAll[Printer](l.s, "printer_")
+		if err != nil {
+			return nil, err
+		}
+		for id, printer := range printers {
+			printers[id] = printer.withTelemetry(l.s.telemetry)
+		}
+		l.printers = printers
+	}
+	return l.printers, nil
+}
+
+// loadFilaments returns every filament
+func (l *gqlLoader) loadFilaments() (map[string]Filament, error) {
+	if l.filaments == nil {
+		filaments, err := loadAll[Filament](l.s, "filament_")
+		if err != nil {
+			return nil, err
+		}
+		l.filaments = filaments
+	}
+	return l.filaments, nil
+}
+
+// loadJobs returns every print job, with estimates filled in for active ones
+func (l *gqlLoader) loadJobs() (map[string]PrintJob, error) {
+	if l.jobs == nil {
+		jobs, err := loadAll[PrintJob](l.s, "printjob_")
+		if err != nil {
+			return nil, err
+		}
+		all := make([]PrintJob, 0, len(jobs))
+		for _, job := range jobs {
+			all = append(all, job)
+		}
+		estimates := estimateJobs(all, time.Now().UTC())
+		for id, job := range jobs {
+			if job.isActive() {
+				jobs[id] = job.withEstimate(estimates)
+			}
+		}
+		l.jobs = jobs
+	}
+	return l.jobs, nil
+}
+
+// loadGroups returns every printer group with its members filled in
+func (l *gqlLoader) loadGroups() (map[string]PrinterGroup, error) {
+	if l.groups == nil {
+		groups, err := loadAll[PrinterGroup](l.s, groupKeyPrefix)
+		if err != nil {
+			return nil, err
+		}
+		printers, err := l.loadPrinters()
+		if err != nil {
+			return nil, err
+		}
+		list := make([]Printer, 0, len(printers))
+		for _, printer := range printers {
+			list = append(list, printer)
+		}
+		for id, group := range groups {
+			groups[id] = group.withMembers(list)
+		}
+		l.groups = groups
+	}
+	return l.groups, nil
+}
+
+// sortedValues returns a map's values ordered by key, filtered by keep
+func sortedValues[T any](m map[string]T, keep func(T) bool) []interface{} {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	values := []interface{}{}
+	for _, key := range keys {
+		if keep == nil || keep(m[key]) {
+			values = append(values, m[key])
+		}
+	}
+	return values
+}
+
+// lookup returns a map entry as an interface, or nil if it is missing
+func lookup[T any](m map[string]T, err error, key string) (interface{}, error) {
+	if err != nil {
+		return nil, err
+	}
+	if v, ok := m[key]; ok {
+		return v, nil
+	}
+	return nil, nil
+}
+
+// gqlRelation is a field whose value is another object or list of objects
+type gqlRelation struct {
+	typ     string   // type of the related objects
+	list    bool     // whether the field is a list
+	args    []string // accepted arguments, all strings
+	resolve func(l *gqlLoader, parent interface{}, args map[string]string) (interface{}, error)
+}
+
+// jobFilter selects jobs by the print_jobs arguments
+func jobFilter(args map[string]string, keep func(PrintJob) bool) func(PrintJob) bool {
+	return func(job PrintJob) bool {
+		if keep != nil && !keep(job) {
+			return false
+		}
+		for name, field := range map[string]string{
+			"status": job.Status, "printer_id": job.PrinterID,
+			"filament_id": job.FilamentID, "submitted_by": job.SubmittedBy,
+		} {
+			if want, ok := args[name]; ok && want != field {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+// jobArgs are the arguments accepted by print job list fields
+var jobArgs = []string{"status", "printer_id", "filament_id", "submitted_by"}
+
+// gqlSchema describes every object type: the Go type its scalar fields come
+// from, and its relations
+var gqlSchema = map[string]struct {
+	scalars   reflect.Type
+	relations map[string]gqlRelation
+}{
+	"Query": {nil, map[string]gqlRelation{
+		"printers": {typ: "Printer", list: true, args: []string{"group_id"},
+			resolve: func(l *gqlLoader, _ interface{}, args map[string]string) (interface{}, error) {
+				printers, err := l.loadPrinters()
+				group, filter := args["group_id"]
+				return sortedValues(printers, func(p Printer) bool { return !filter || p.GroupID == group }), err
+			}},
+		"printer": {typ: "Printer", args: []string{"id"},
+			resolve: func(l *gqlLoader, _ interface{}, args map[string]string) (interface{}, error) {
+				printers, err := l.loadPrinters()
+				return lookup(printers, err, args["id"])
+			}},
+		"filaments": {typ: "Filament", list: true, args: []string{"type"},
+			resolve: func(l *gqlLoader, _ interface{}, args map[string]string) (interface{}, error) {
+				filaments, err := l.loadFilaments()
+				typ, filter := args["type"]
+				return sortedValues(filaments, func(f Filament) bool { return !filter || f.Type == typ }), err
+			}},
+		"filament": {typ: "Filament", args: []string{"id"},
+			resolve: func(l *gqlLoader, _ interface{}, args map[string]string) (interface{}, error) {
+				filaments, err := l.loadFilaments()
+				return lookup(filaments, err, args["id"])
+			}},
+		"print_jobs": {typ: "PrintJob", list: true, args: jobArgs,
+			resolve: func(l *gqlLoader, _ interface{}, args map[string]string) (interface{}, error) {
+				jobs, err := l.loadJobs()
+				return sortedValues(jobs, jobFilter(args, nil)), err
+			}},
+		"print_job": {typ: "PrintJob", args: []string{"id"},
+			resolve: func(l *gqlLoader, _ interface{}, args map[string]string) (interface{}, error) {
+				jobs, err := l.loadJobs()
+				return lookup(jobs, err, args["id"])
+			}},
+		"printer_groups": {typ: "PrinterGroup", list: true,
+			resolve: func(l *gqlLoader, _ interface{}, _ map[string]string) (interface{}, error) {
+				groups, err := l.loadGroups()
+				return sortedValues(groups, nil), err
+			}},
+		"printer_group": {typ: "PrinterGroup", args: []string{"id"},
+			resolve: func(l *gqlLoader, _ interface{}, args map[string]string) (interface{}, error) {
+				groups, err := l.loadGroups()
+				return lookup(groups, err, args["id"])
+			}},
+	}},
+	"Printer": {reflect.TypeOf(Printer{}), map[string]gqlRelation{
+		"queue": {typ: "QueueEntry", list: true,
+			resolve: func(l *gqlLoader, parent interface{}, _ map[string]string) (interface{}, error) {
+				jobs, err := l.loadJobs()
+				if err != nil {
+					return nil, err
+				}
+				var forPrinter []PrintJob
+				for _, job := range jobs {
+					if job.PrinterID == parent.(Printer).ID {
+						forPrinter = append(forPrinter, job)
+					}
+				}
+				queue := []interface{}{}
+				for i, job := range queueOrder(forPrinter) {
+					queue = append(queue, QueueEntry{Position: i + 1, JobID: job.ID})
+				}
+				return queue, nil
+			}},
+		"print_jobs": {typ: "PrintJob", list: true, args: jobArgs,
+			resolve: func(l *gqlLoader, parent interface{}, args map[string]string) (interface{}, error) {
+				jobs, err := l.loadJobs()
+				id := parent.(Printer).ID
+				return sortedValues(jobs, jobFilter(args, func(j PrintJob) bool { return j.PrinterID == id })), err
+			}},
+		"group": {typ: "PrinterGroup",
+			resolve: func(l *gqlLoader, parent interface{}, _ map[string]string) (interface{}, error) {
+				groups, err := l.loadGroups()
+				return lookup(groups, err, parent.(Printer).GroupID)
+			}},
+	}},
+	"QueueEntry": {reflect.TypeOf(QueueEntry{}), map[string]gqlRelation{
+		"job": {typ: "PrintJob",
+			resolve: func(l *gqlLoader, parent interface{}, _ map[string]string) (interface{}, error) {
+				jobs, err := l.loadJobs()
+				return lookup(jobs, err, parent.(QueueEntry).JobID)
+			}},
+	}},
+	"PrintJob": {reflect.TypeOf(PrintJob{}), map[string]gqlRelation{
+		"printer": {typ: "Printer",
+			resolve: func(l *gqlLoader, parent interface{}, _ map[string]string) (interface{}, error) {
+				printers, err := l.loadPrinters()
+				return lookup(printers, err, parent.(PrintJob).PrinterID)
+			}},
+		"filament": {typ: "Filament",
+			resolve: func(l *gqlLoader, parent interface{}, _ map[string]string) (interface{}, error) {
+				filaments, err := l.loadFilaments()
+				return lookup(filaments, err, parent.(PrintJob).FilamentID)
+			}},
+		"printer_group": {typ: "PrinterGroup",
+			resolve: func(l *gqlLoader, parent interface{}, _ map[string]string) (interface{}, error) {
+				groups, err := l.loadGroups()
+				return lookup(groups, err, parent.(PrintJob).PrinterGroupID)
+			}},
+		"dependencies": {typ: "PrintJob", list: true,
+			resolve: func(l *gqlLoader, parent interface{}, _ map[string]string) (interface{}, error) {
+				jobs, err := l.loadJobs()
+				deps := []interface{}{}
+				for _, id := range parent.(PrintJob).DependsOn {
+					if job, ok := jobs[id]; ok {
+						deps = append(deps, job)
+					}
+				}
+				return deps, err
+			}},
+	}},
+	"Filament": {reflect.TypeOf(Filament{}), map[string]gqlRelation{
+		"print_jobs": {typ: "PrintJob", list: true, args: jobArgs,
+			resolve: func(l *gqlLoader, parent interface{}, args map[string]string) (interface{}, error) {
+				jobs, err := l.loadJobs()
+				id := parent.(Filament).ID
+				return sortedValues(jobs, jobFilter(args, func(j PrintJob) bool { return j.FilamentID == id })), err
+			}},
+	}},
+	"PrinterGroup": {reflect.TypeOf(PrinterGroup{}), map[string]gqlRelation{
+		"printers": {typ: "Printer", list: true,
+			resolve: func(l *gqlLoader, parent interface{}, _ map[string]string) (interface{}, error) {
+				printers, err := l.loadPrinters()
+				id := parent.(PrinterGroup).ID
+				return sortedValues(printers, func(p Printer) bool { return p.GroupID == id }), err
+			}},
+	}},
+}
+
+// gqlExecutor resolves a parsed query, collecting field errors as it goes
+type gqlExecutor struct {
+	loader *gqlLoader
+	errors []GraphQLError
+}
+
+// fail records a field error; the field resolves to null
+func (e *gqlExecutor) fail(path []string, format string, args ...interface{}) {
+	e.errors = append(e.errors, GraphQLError{Message: fmt.Sprintf(format, args...), Path: path})
+}
+
+// gqlObject is a resolved object whose keys keep the order of the selection
+type gqlObject struct {
+	keys   []string
+	values map[string]interface{}
+}
+
+func (o *gqlObject) set(key string, value interface{}) {
+	if _, exists := o.values[key]; !exists {
+		o.keys = append(o.keys, key)
+	}
+	o.values[key] = value
+}
+
+func (o *gqlObject) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, key := range o.keys {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		k, _ := json.Marshal(key)
+		v, err := json.Marshal(o.values[key])
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(k)
+		buf.WriteByte(':')
+		buf.Write(v)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+// scalarFields returns the JSON field names of a type
+func scalarFields(t reflect.Type) map[string]bool {
+	fields := make(map[string]bool)
+	for i := 0; i < t.NumField(); i++ {
+		fields[jsonFieldName(t.Field(i))] = true
+	}
+	return fields
+}
+
+// resolveObject resolves a selection set against one object of a type
+func (e *gqlExecutor) resolveObject(typ string, obj interface{}, selections []*gqlField, path []string) *gqlObject {
+	schema := gqlSchema[typ]
+	result := &gqlObject{values: make(map[string]interface{})}
+
+	var scalars map[string]interface{}
+	var known map[string]bool
+	if schema.scalars != nil {
+		known = scalarFields(schema.scalars)
+		body, _ := json.Marshal(obj)
+		json.Unmarshal(body, &scalars)
+	}
+
+	for _, field := range selections {
+		fieldPath := append(append([]string{}, path...), field.responseKey())
+
+		if field.Name == "__typename" {
+			result.set(field.responseKey(), typ)
+			continue
+		}
+
+		if relation, ok := schema.relations[field.Name]; ok {
+			result.set(field.responseKey(), e.resolveRelation(relation, obj, field, fieldPath))
+			continue
+		}
+
+		if known[field.Name] {
+			if len(field.Selections) > 0 {
+				e.fail(fieldPath, "Field %q of type %q must not have a selection", field.Name, typ)
+				result.set(field.responseKey(), nil)
+				continue
+			}
+			if len(field.Args) > 0 {
+				e.fail(fieldPath, "Field %q of type %q takes no arguments", field.Name, typ)
+			}
+			result.set(field.responseKey(), scalars[field.Name])
+			continue
+		}
+
+		e.fail(fieldPath, "Cannot query field %q on type %q", field.Name, typ)
+		result.set(field.responseKey(), nil)
+	}
+	return result
+}
+
+// resolveRelation resolves a field that refers to other objects
+func (e *gqlExecutor) resolveRelation(relation gqlRelation, parent interface{}, field *gqlField, path []string) interface{} {
+	if len(field.Selections) == 0 {
+		e.fail(path, "Field %q of type %q must have a selection of subfields", field.Name, relation.typ)
+		return nil
+	}
+
+	args := make(map[string]string, len(field.Args))
+	for name, value := range field.Args {
+		accepted := false
+		for _, a := range relation.args {
+			accepted = accepted || a == name
+		}
+		if !accepted {
+			e.fail(path, "Unknown argument %q on field %q", name, field.Name)
+			return nil
+		}
+		str, ok := value.(string)
+		if !ok {
+			e.fail(path, "Argument %q on field %q must be a string", name, field.Name)
+			return nil
+		}
+		args[name] = str
+	}
+
+	value, err := relation.resolve(e.loader, parent, args)
+	if err != nil {
+		e.fail(path, "Failed to resolve %q: %s", field.Name, err)
+		return nil
+	}
+	if value == nil {
+		return nil
+	}
+	if !relation.list {
+		return e.resolveObject(relation.typ, value, field.Selections, path)
+	}
+
+	items := value.([]interface{})
+	results := make([]interface{}, 0, len(items))
+	for i, item := range items {
+		results = append(results, e.resolveObject(relation.typ, item, field.Selections, append(path, fmt.Sprint(i))))
+	}
+	return results
+}
@@ -0,0 +1,298 @@
+package api
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// gqlField is one field of a parsed GraphQL selection set
+type gqlField struct {
+	Alias      string
+	Name       string
+	Args       map[string]interface{}
+	Selections []*gqlField
+}
+
+// responseKey is the key the field's value is returned under
+func (f *gqlField) responseKey() string {
+	if f.Alias != "" {
+		return f.Alias
+	}
+	return f.Name
+}
+
+// gqlMaxDepth is how deeply selection sets may be nested. The parser
+// recurses per level, so this also bounds its stack.
+const gqlMaxDepth = 32
+
+// gqlParser parses the subset of GraphQL the endpoint supports: a single
+// query operation with nested selection sets, aliases and literal arguments.
+// Fragments, directives, variables and mutations are rejected.
+type gqlParser struct {
+	src   string
+	pos   int
+	depth int // selection sets currently open
+}
+
+// parseGraphQL parses a query document into its top-level selections
+func parseGraphQL(src string) ([]*gqlField, error) {
+	p := &gqlParser{src: src}
+	p.skipIgnored()
+
+	if name := p.peekName(); name != "" {
+		if name != "query" {
+			return nil, fmt.Errorf("only query operations are supported, got %q", name)
+		}
+		p.readName()
+		p.skipIgnored()
+		if p.peekName() != "" {
+			p.readName() // operation name
+			p.skipIgnored()
+		}
+		if p.peek() == '(' {
+			return nil, p.errorf("variables are not supported")
+		}
+	}
+
+	selections, err := p.parseSelectionSet()
+	if err != nil {
+		return nil, err
+	}
+	p.skipIgnored()
+	if p.pos < len(p.src) {
+		return nil, p.errorf("unexpected %q after the query; only one operation is supported", p.src[p.pos])
+	}
+	return selections, nil
+}
+
+// errorf reports a syntax error at the current position
+func (p *gqlParser) errorf(format string, args ...interface{}) error {
+	pos := min(p.pos, len(p.src))
+	line := 1 + strings.Count(p.src[:pos], "\n")
+	column := pos - strings.LastIndex(p.src[:pos], "\n")
+	return fmt.Errorf("syntax error at line %d, column %d: %s", line, column, fmt.Sprintf(format, args...))
+}
+
+// skipIgnored skips whitespace, commas and comments
+func (p *gqlParser) skipIgnored() {
+	for p.pos < len(p.src) {
+		switch c := p.src[p.pos]; {
+		case c == '#':
+			for p.pos < len(p.src) && p.src[p.pos] != '\n' {
+				p.pos++
+			}
+		case c == ',' || c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			p.pos++
+		default:
+			return
+		}
+	}
+}
+
+// peek returns the next byte without consuming it, or 0 at the end
+func (p *gqlParser) peek() byte {
+	if p.pos < len(p.src) {
+		return p.src[p.pos]
+	}
+	return 0
+}
+
+// expect consumes the given punctuator
+func (p *gqlParser) expect(c byte) error {
+	p.skipIgnored()
+	if p.peek() != c {
+		if p.pos >= len(p.src) {
+			return p.errorf("expected %q, got end of query", c)
+		}
+		return p.errorf("expected %q, got %q", c, p.src[p.pos])
+	}
+	p.pos++
+	return nil
+}
+
+// isNameStart reports whether c can start a GraphQL name
+func isNameStart(c byte) bool {
+	return c == '_' || unicode.IsLetter(rune(c))
+}
+
+// peekName returns the name at the current position without consuming it
+func (p *gqlParser) peekName() string {
+	start := p.pos
+	name := p.readName()
+	p.pos = start
+	return name
+}
+
+// readName consumes a name, returning "" if there is none
+func (p *gqlParser) readName() string {
+	if p.pos >= len(p.src) || !isNameStart(p.src[p.pos]) {
+		return ""
+	}
+	start := p.pos
+	for p.pos < len(p.src) && (isNameStart(p.src[p.pos]) || unicode.IsDigit(rune(p.src[p.pos]))) {
+		p.pos++
+	}
+	return p.src[start:p.pos]
+}
+
+// parseSelectionSet parses { field ... }
+func (p *gqlParser) parseSelectionSet() ([]*gqlField, error) {
+	if err := p.expect('{'); err != nil {
+		return nil, err
+	}
+	if p.depth++; p.depth > gqlMaxDepth {
+		return nil, p.errorf("selection sets are nested more than %d deep", gqlMaxDepth)
+	}
+	defer func() { p.depth-- }()
+
+	var fields []*gqlField
+	for {
+		p.skipIgnored()
+		switch p.peek() {
+		case '}':
+			p.pos++
+			if len(fields) == 0 {
+				return nil, p.errorf("empty selection set")
+			}
+			return fields, nil
+		case '.':
+			return nil, p.errorf("fragments are not supported")
+		case '@':
+			return nil, p.errorf("directives are not supported")
+		case 0:
+			return nil, p.errorf("unterminated selection set")
+		}
+		field, err := p.parseField()
+		if err != nil {
+			return nil, err
+		}
+		fields = append(fields, field)
+	}
+}
+
+// parseField parses [alias:] name [(args)] [{ selections }]
+func (p *gqlParser) parseField() (*gqlField, error) {
+	name := p.readName()
+	if name == "" {
+		return nil, p.errorf("expected a field name, got %q", p.peek())
+	}
+	field := &gqlField{Name: name}
+
+	p.skipIgnored()
+	if p.peek() == ':' {
+		p.pos++
+		p.skipIgnored()
+		if field.Name = p.readName(); field.Name == "" {
+			return nil, p.errorf("expected a field name after alias %q", name)
+		}
+		field.Alias = name
+		p.skipIgnored()
+	}
+
+	if p.peek() == '(' {
+		args, err := p.parseArguments()
+		if err != nil {
+			return nil, err
+		}
+		field.Args = args
+		p.skipIgnored()
+	}
+
+	if p.peek() == '{' {
+		selections, err := p.parseSelectionSet()
+		if err != nil {
+			return nil, err
+		}
+		field.Selections = selections
+	}
+	return field, nil
+}
+
+// parseArguments parses (name: value ...)
+func (p *gqlParser) parseArguments() (map[string]interface{}, error) {
+	if err := p.expect('('); err != nil {
+		return nil, err
+	}
+	args := make(map[string]interface{})
+	for {
+		p.skipIgnored()
+		if p.peek() == ')' {
+			p.pos++
+			return args, nil
+		}
+		name := p.readName()
+		if name == "" {
+			return nil, p.errorf("expected an argument name")
+		}
+		if err := p.expect(':'); err != nil {
+			return nil, err
+		}
+		p.skipIgnored()
+		value, err := p.parseValue()
+		if err != nil {
+			return nil, err
+		}
+		args[name] = value
+	}
+}
+
+// parseValue parses a literal string, number, boolean, null or enum value
+func (p *gqlParser) parseValue() (interface{}, error) {
+	c := p.peek()
+	switch {
+	case c == '$':
+		return nil, p.errorf("variables are not supported")
+	case c == '"':
+		return p.parseString()
+	case c == '-' || (c >= '0' && c <= '9'):
+		start := p.pos
+		p.pos++
+		for p.pos < len(p.src) && strings.IndexByte("0123456789.eE+-", p.src[p.pos]) >= 0 {
+			p.pos++
+		}
+		n, err := strconv.ParseFloat(p.src[start:p.pos], 64)
+		if err != nil {
+			return nil, p.errorf("invalid number %q", p.src[start:p.pos])
+		}
+		return n, nil
+	case isNameStart(c):
+		switch name := p.readName(); name {
+		case "true":
+			return true, nil
+		case "false":
+			return false, nil
+		case "null":
+			return nil, nil
+		default:
+			return name, nil // enum values are treated as strings
+		}
+	}
+	return nil, p.errorf("expected a value, got %q", c)
+}
+
+// parseString parses a double-quoted string with JSON-style escapes
+func (p *gqlParser) parseString() (string, error) {
+	start := p.pos
+	p.pos++
+	for p.pos < len(p.src) {
+		switch p.src[p.pos] {
+		case '\\':
+			// A trailing backslash leaves the string unterminated
+			p.pos = min(p.pos+2, len(p.src))
+			continue
+		case '\n':
+			return "", p.errorf("unterminated string")
+		case '"':
+			p.pos++
+			s, err := strconv.Unquote(p.src[start:p.pos])
+			if err != nil {
+				return "", p.errorf("invalid string %s", p.src[start:p.pos])
+			}
+			return s, nil
+		}
+		p.pos++
+	}
+	return "", p.errorf("unterminated string")
+}
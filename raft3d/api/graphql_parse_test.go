@@ -0,0 +1,33 @@
+package api
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseGraphQLRejectsMalformedQueries(t *testing.T) {
+	tests := []struct {
+		name  string
+		query string
+		want  string
+	}{
+		{"trailing backslash", `{ printer(id: "a\`, "unterminated string"},
+		{"deep nesting", strings.Repeat("{a", gqlMaxDepth+1), "nested more than"},
+		{"huge nesting", strings.Repeat("{a", 3000000), "nested more than"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := parseGraphQL(tt.query)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Fatalf("parseGraphQL() error = %v, want one containing %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseGraphQLAllowsNestingUpToLimit(t *testing.T) {
+	query := strings.Repeat("{a", gqlMaxDepth) + strings.Repeat("}", gqlMaxDepth)
+	if _, err := parseGraphQL(query); err != nil {
+		t.Fatalf("parseGraphQL() error = %v", err)
+	}
+}
@@ -0,0 +1,248 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"raft3d/raft"
+)
+
+// groupKeyPrefix prefixes printer group records
+const groupKeyPrefix = "printergroup_"
+
+// handlePrinterGroups handles GET/POST /printer_groups and
+// GET/DELETE /printer_groups/{id}
+func (s *Server) handlePrinterGroups(w http.ResponseWriter, r *http.Request) {
+	id := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/v1/printer_groups"), "/")
+
+	switch {
+	case id == "" && r.Method == http.MethodGet:
+		s.handleListPrinterGroups(w, r)
+	case id == "" && r.Method == http.MethodPost:
+		s.handlePostPrinterGroup(w, r)
+	case id != "" && r.Method == http.MethodGet:
+		s.handleGetPrinterGroup(w, r, id)
+	case id != "" && r.Method == http.MethodDelete:
+		s.handleDeletePrinterGroup(w, r, id)
+	default:
+		methodNotAllowed(w, r)
+	}
+}
+
+// handleListPrinterGroups returns every printer group with its members
+func (s *Server) handleListPrinterGroups(w http.ResponseWriter, r *http.Request) {
+	keys, err := s.store.List(groupKeyPrefix)
+	if err != nil {
+		s.writeStoreError(w, r, err, "Failed to retrieve printer groups")
+		return
+	}
+	printers, err := s.listPrinters()
+	if err != nil {
+		s.writeStoreError(w, r, err, "Failed to retrieve printers")
+		return
+	}
+
+	groups := make(map[string]PrinterGroup)
+	for _, key := range keys {
+		group, err := s.getPrinterGroup(strings.TrimPrefix(key, groupKeyPrefix))
+		if err != nil {
+			continue
+		}
+		groups[group.ID] = group.withMembers(printers)
+	}
+
+	writeMap(w, r, groups)
+}
+
+// handleGetPrinterGroup returns one printer group with its members
+func (s *Server) handleGetPrinterGroup(w http.ResponseWriter, r *http.Request, id string) {
+	group, err := s.getPrinterGroup(id)
+	if err != nil {
+		s.writeStoreError(w, r, err, "Printer group not found")
+		return
+	}
+	printers, err := s.listPrinters()
+	if err != nil {
+		s.writeStoreError(w, r, err, "Failed to retrieve printers")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(group.withMembers(printers))
+}
+
+// handlePostPrinterGroup stores a printer group
+func (s *Server) handlePostPrinterGroup(w http.ResponseWriter, r *http.Request) {
+	var group PrinterGroup
+	if !decodeJSON(w, r, &group) {
+		return
+	}
+	group.PrinterIDs, group.Revision = nil, 0
+
+	body, err := json.Marshal(group)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, CodeInternal, "Failed to process printer group data")
+		return
+	}
+	if err := s.storeFor(r).Create(groupKeyPrefix+group.ID, string(body)); err != nil {
+		s.writeStoreError(w, r, err, "Failed to store printer group")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	w.Write(body)
+}
+
+// handleDeletePrinterGroup removes an empty printer group. The deletion is
+// conditioned on the group's revision, so it fails if a printer joined after
+// the members were checked.
+func (s *Server) handleDeletePrinterGroup(w http.ResponseWriter, r *http.Request, id string) {
+	group, condition, err := s.getPrinterGroupRevision(id)
+	if err != nil {
+		s.writeStoreError(w, r, err, "Printer group not found")
+		return
+	}
+	printers, err := s.listPrinters()
+	if err != nil {
+		s.writeStoreError(w, r, err, "Failed to retrieve printers")
+		return
+	}
+	if members := group.withMembers(printers).PrinterIDs; len(members) > 0 {
+		writeError(w, r, http.StatusConflict, CodeConflict, "Printer group still has printers: "+strings.Join(members, ", "))
+		return
+	}
+
+	if err := s.storeFor(r).DeleteMany([]string{groupKeyPrefix + id}, condition); err != nil {
+		s.writeStoreError(w, r, err, "Failed to delete printer group")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// groupFilterOf returns the printer group a listing is filtered to, given as
+// ?group_id= or, as before, ?group=
+func groupFilterOf(r *http.Request) string {
+	if id := r.URL.Query().Get("group_id"); id != "" {
+		return id
+	}
+	return r.URL.Query().Get("group")
+}
+
+// getPrinterGroup loads a printer group
+func (s *Server) getPrinterGroup(id string) (PrinterGroup, error) {
+	var group PrinterGroup
+	value, err := s.store.Get(groupKeyPrefix + id)
+	if err != nil {
+		return group, err
+	}
+	err = json.Unmarshal([]byte(value), &group)
+	return group, err
+}
+
+// getPrinterGroupRevision loads a printer group together with the condition
+// that it is still at the revision read. Groups stored before revisions were
+// kept have none, and are matched as such.
+func (s *Server) getPrinterGroupRevision(id string) (PrinterGroup, raft.Condition, error) {
+	key := groupKeyPrefix + id
+	var group PrinterGroup
+	value, err := s.store.Get(key)
+	if err != nil {
+		return group, raft.Condition{}, err
+	}
+	var fields map[string]interface{}
+	if err := json.Unmarshal([]byte(value), &group); err != nil {
+		return group, raft.Condition{}, err
+	}
+	json.Unmarshal([]byte(value), &fields)
+	return group, raft.Condition{Key: key, Field: "revision", Equals: fmt.Sprint(fields["revision"])}, nil
+}
+
+// listPrinters returns every stored printer
+func (s *Server) listPrinters() ([]Printer, error) {
+	return listPrintersIn(s.store)
+}
+
+// listPrintersIn reads every printer from store
+func listPrintersIn(store raft.Store) ([]Printer, error) {
+	keys, err := store.List("printer_")
+	if err != nil {
+		return nil, err
+	}
+
+	var printers []Printer
+	for _, key := range keys {
+		value, err := store.Get(key)
+		if err != nil {
+			continue
+		}
+		var printer Printer
+		if err := json.Unmarshal([]byte(value), &printer); err != nil {
+			continue
+		}
+		printers = append(printers, printer)
+	}
+	return printers, nil
+}
+
+// withMembers fills in the IDs of the printers in the group
+func (g PrinterGroup) withMembers(printers []Printer) PrinterGroup {
+	g.PrinterIDs = []string{}
+	for _, printer := range printers {
+		if printer.GroupID == g.ID {
+			g.PrinterIDs = append(g.PrinterIDs, printer.ID)
+		}
+	}
+	sort.Strings(g.PrinterIDs)
+	return g
+}
+
+// assignPrinter picks the printer in a group that will be free the soonest,
+// using the same queue estimates shown on jobs. Online printers are preferred
+// over Offline ones. It returns "" if the group has no printers.
+func (s *Server) assignPrinter(groupID string) (string, error) {
+	printers, err := s.listPrinters()
+	if err != nil {
+		return "", err
+	}
+	jobs, err := s.listPrintJobs()
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now().UTC()
+	estimates := estimateJobs(jobs, now)
+	freeAt := make(map[string]time.Time)
+	for _, job := range jobs {
+		if estimate, ok := estimates[job.ID]; ok && estimate.completion.After(freeAt[job.PrinterID]) {
+			freeAt[job.PrinterID] = estimate.completion
+		}
+	}
+
+	best := ""
+	bestOffline := false
+	var bestFree time.Time
+	for _, printer := range printers {
+		if printer.GroupID != groupID {
+			continue
+		}
+		free := freeAt[printer.ID]
+		if free.Before(now) {
+			free = now
+		}
+		offline := printer.Status == PrinterStatusOffline
+		switch {
+		case best == "", bestOffline && !offline:
+		case offline && !bestOffline:
+			continue
+		case free.After(bestFree), free.Equal(bestFree) && printer.ID > best:
+			continue
+		}
+		best, bestFree, bestOffline = printer.ID, free, offline
+	}
+	return best, nil
+}
@@ -0,0 +1,180 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"raft3d/raft"
+	"raft3d/testsupport"
+)
+
+func TestAssignPrinter(t *testing.T) {
+	c := testsupport.NewCluster(t, 1)
+	leader := c.WaitForLeader(10 * time.Second)
+	s := NewServer("", leader.Store)
+
+	put := func(key string, v interface{}) {
+		body, _ := json.Marshal(v)
+		if err := leader.Store.Set(key, string(body)); err != nil {
+			t.Fatalf("set %s: %s", key, err)
+		}
+	}
+	put("filament_f1", Filament{ID: "f1", Name: "PLA", Type: "PLA", TotalWeightInGrams: 1000, RemainingWeightInGrams: 1000})
+
+	tests := []struct {
+		name     string
+		printers []Printer
+		queued   map[string]float64 // grams queued per printer
+		want     string
+	}{
+		{"online over offline", []Printer{{ID: "a", Status: PrinterStatusOffline}, {ID: "b", Status: "Idle"}}, map[string]float64{"b": 100}, "b"},
+		{"soonest free", []Printer{{ID: "a", Status: "Idle"}, {ID: "b", Status: "Idle"}}, map[string]float64{"a": 50, "b": 20}, "b"},
+		{"lowest ID on a tie", []Printer{{ID: "b", Status: "Idle"}, {ID: "a", Status: "Idle"}}, nil, "a"},
+		{"offline when nothing else", []Printer{{ID: "a", Status: PrinterStatusOffline}}, nil, "a"},
+		{"empty group", nil, nil, ""},
+	}
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			group := "g" + string(rune('0'+i))
+			put(groupKeyPrefix+group, PrinterGroup{ID: group, Name: tt.name})
+			for _, printer := range tt.printers {
+				printer.ID, printer.Name, printer.GroupID = group+"-"+printer.ID, printer.ID, group
+				put("printer_"+printer.ID, printer)
+			}
+			for printer, grams := range tt.queued {
+				id := group + "-" + printer
+				put("printjob_"+id, PrintJob{ID: id, PrinterID: id, FilamentID: "f1", FilePath: "a.gcode", PrintWeightInGrams: grams, Status: "Queued"})
+			}
+
+			want := ""
+			if tt.want != "" {
+				want = group + "-" + tt.want
+			}
+			if got, err := s.assignPrinter(group); err != nil || got != want {
+				t.Fatalf("assigned %q %v, want %q", got, err, want)
+			}
+
+			// A job for the group is given that printer, or refused
+			w := httptest.NewRecorder()
+			s.handlePostPrintJob(w, httptest.NewRequest(http.MethodPost, "/api/v1/print_jobs",
+				strings.NewReader(`{"printer_group_id":"`+group+`","filament_id":"f1","filepath":"b.gcode","print_weight_in_grams":1}`)))
+			if want == "" {
+				if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "printer group has no printers") {
+					t.Fatalf("job for an empty group: %d %s", w.Code, w.Body)
+				}
+				return
+			}
+			var job PrintJob
+			if w.Code != http.StatusCreated || json.Unmarshal(w.Body.Bytes(), &job) != nil || job.PrinterID != want {
+				t.Fatalf("job for the group: %d %s", w.Code, w.Body)
+			}
+		})
+	}
+}
+
+func TestPrinterGroupLifecycle(t *testing.T) {
+	c := testsupport.NewCluster(t, 1)
+	leader := c.WaitForLeader(10 * time.Second)
+	s := NewServer("", leader.Store)
+
+	do := func(method, url, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(method, url, strings.NewReader(body))
+		if strings.HasPrefix(url, "/api/v1/printers") {
+			s.handlePrinters(w, r)
+		} else {
+			s.handlePrinterGroups(w, r)
+		}
+		return w
+	}
+	for _, group := range []string{"farm", "lab"} {
+		if w := do(http.MethodPost, "/api/v1/printer_groups", `{"id":"`+group+`","name":"`+group+`"}`); w.Code != http.StatusCreated {
+			t.Fatalf("create %s: %d %s", group, w.Code, w.Body)
+		}
+	}
+	if w := do(http.MethodPost, "/api/v1/printer_groups", `{"id":"farm","name":"Other farm"}`); w.Code != http.StatusConflict {
+		t.Fatalf("second create: %d %s", w.Code, w.Body)
+	}
+	for _, printer := range []string{`{"id":"p1","name":"P1","group_id":"farm"}`, `{"id":"p2","name":"P2","group_id":"lab"}`, `{"id":"p3","name":"P3"}`} {
+		if w := do(http.MethodPost, "/api/v1/printers", printer); w.Code != http.StatusCreated {
+			t.Fatalf("create printer %s: %d %s", printer, w.Code, w.Body)
+		}
+	}
+	if w := do(http.MethodPost, "/api/v1/printers", `{"id":"p4","name":"P4","group_id":"attic"}`); w.Code != http.StatusBadRequest {
+		t.Fatalf("printer in a missing group: %d %s", w.Code, w.Body)
+	}
+
+	// Listings filter to one group
+	for _, param := range []string{"group_id", "group"} {
+		w := do(http.MethodGet, "/api/v1/printers?"+param+"=farm", "")
+		var printers map[string]Printer
+		if err := json.Unmarshal(w.Body.Bytes(), &printers); err != nil || len(printers) != 1 || printers["p1"].GroupID != "farm" {
+			t.Fatalf("?%s=farm: %d %s", param, w.Code, w.Body)
+		}
+	}
+
+	// A group with printers can't be deleted
+	if w := do(http.MethodDelete, "/api/v1/printer_groups/farm", ""); w.Code != http.StatusConflict || !strings.Contains(w.Body.String(), "p1") {
+		t.Fatalf("delete a group with printers: %d %s", w.Code, w.Body)
+	}
+	if _, err := leader.Store.Get(groupKeyPrefix + "farm"); err != nil {
+		t.Fatal(err)
+	}
+	if w := do(http.MethodPost, "/api/v1/printer_groups", `{"id":"empty","name":"Empty"}`); w.Code != http.StatusCreated {
+		t.Fatalf("create empty: %d %s", w.Code, w.Body)
+	}
+	if w := do(http.MethodDelete, "/api/v1/printer_groups/empty", ""); w.Code != http.StatusNoContent {
+		t.Fatalf("delete an empty group: %d %s", w.Code, w.Body)
+	}
+}
+
+// listRaceStore runs race once, the first time printers are listed
+type listRaceStore struct {
+	raft.Store
+	race func()
+	once sync.Once
+}
+
+func (r *listRaceStore) List(prefix string) ([]string, error) {
+	keys, err := r.Store.List(prefix)
+	if prefix == "printer_" {
+		r.once.Do(r.race)
+	}
+	return keys, err
+}
+
+func TestPrinterJoiningDuringGroupDeletion(t *testing.T) {
+	c := testsupport.NewCluster(t, 1)
+	leader := c.WaitForLeader(10 * time.Second)
+	joiner := NewServer("", leader.Store)
+
+	w := httptest.NewRecorder()
+	joiner.handlePostPrinterGroup(w, httptest.NewRequest(http.MethodPost, "/api/v1/printer_groups", strings.NewReader(`{"id":"farm","name":"Farm"}`)))
+	if w.Code != http.StatusCreated {
+		t.Fatalf("create group: %d %s", w.Code, w.Body)
+	}
+
+	// The deletion finds the group empty, then a printer joins it
+	store := &listRaceStore{Store: leader.Store, race: func() {
+		w := httptest.NewRecorder()
+		joiner.handlePostPrinter(w, httptest.NewRequest(http.MethodPost, "/api/v1/printers", strings.NewReader(`{"id":"p1","name":"P1","group_id":"farm"}`)))
+		if w.Code != http.StatusCreated {
+			t.Errorf("join: %d %s", w.Code, w.Body)
+		}
+	}}
+	s := NewServer("", store)
+	w = httptest.NewRecorder()
+	s.handleDeletePrinterGroup(w, httptest.NewRequest(http.MethodDelete, "/api/v1/printer_groups/farm", nil), "farm")
+	if w.Code != http.StatusConflict {
+		t.Fatalf("delete while a printer joined: %d %s", w.Code, w.Body)
+	}
+	group, err := joiner.getPrinterGroup("farm")
+	if err != nil || group.Revision != 1 {
+		t.Fatalf("group after the race: %+v %v", group, err)
+	}
+}
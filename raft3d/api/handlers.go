@@ -2,22 +2,38 @@ package api
 
 import (
 	"encoding/json"
-	"io"
+	"errors"
+	"fmt"
+	"math"
 	"net/http"
 	"strings"
-	"fmt"
-	
+	"time"
+
+	"raft3d/raft"
 )
 
 // handlePrinters handles GET and POST requests for printers
 func (s *Server) handlePrinters(w http.ResponseWriter, r *http.Request) {
+	if id, ok := strings.CutSuffix(strings.TrimPrefix(r.URL.Path, "/api/v1/printers/"), "/telemetry"); ok {
+		s.handlePrinterTelemetry(w, r, id)
+		return
+	}
+	if id, stream, ok := cameraRoute(r.URL.Path); ok {
+		s.handlePrinterCamera(w, r, id, stream)
+		return
+	}
+
 	switch r.Method {
 	case http.MethodGet:
 		s.handleGetPrinters(w, r)
 	case http.MethodPost:
+		if id, ok := strings.CutSuffix(strings.TrimPrefix(r.URL.Path, "/api/v1/printers/"), "/heartbeat"); ok {
+			s.handlePrinterHeartbeat(w, r, id)
+			return
+		}
 		s.handlePostPrinter(w, r)
 	default:
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		methodNotAllowed(w, r)
 	}
 }
 
@@ -27,87 +43,217 @@ func (s *Server) handleGetPrinters(w http.ResponseWriter, r *http.Request) {
 	path := strings.TrimPrefix(r.URL.Path, "/api/v1/printers")
 	if path != "" && path != "/" {
 		printerID := strings.TrimPrefix(path, "/")
-		s.handleGetPrinter(w, printerID)
+		if id, ok := strings.CutSuffix(printerID, "/queue"); ok {
+			s.handlePrinterQueue(w, r, id)
+			return
+		}
+		if id, ok := strings.CutSuffix(printerID, "/calendar.ics"); ok {
+			s.handlePrinterICalendar(w, r, id)
+			return
+		}
+		if id, ok := strings.CutSuffix(printerID, "/calendar"); ok {
+			s.handlePrinterCalendar(w, r, id)
+			return
+		}
+		s.handleGetPrinter(w, r, printerID)
 		return
 	}
 
-	// Get all printers
-	printers := make(map[string]Printer)
-	
-	// List all keys with prefix "printer_"
-	keys, err := s.store.List("printer_")
+	csvOut, ok := wantsCSV(w, r)
+	if !ok {
+		return
+	}
+
+	selector, errs := parseLabelSelector(r.URL.Query())
+	if len(errs) > 0 {
+		writeValidationProblem(w, r, errs)
+		return
+	}
+
+	// Get all printers, optionally only those in a group
+	groupFilter := groupFilterOf(r)
+	store, historical := s.readStore(r)
+	all, err := listPrintersIn(store)
 	if err != nil {
-		http.Error(w, "Failed to retrieve printers", http.StatusInternalServerError)
+		s.writeStoreError(w, r, err, "Failed to retrieve printers")
 		return
 	}
 
-	// Get each printer by key
-	for _, key := range keys {
-		value, err := s.store.Get(key)
-		if err != nil {
+	printers := make(map[string]Printer)
+	for _, printer := range all {
+		if groupFilter != "" && printer.GroupID != groupFilter {
 			continue
 		}
-
-		var printer Printer
-		if err := json.Unmarshal([]byte(value), &printer); err != nil {
+		if !selector.matches(printer.Labels) {
 			continue
 		}
-
+		// Telemetry isn't kept in history
+		if !historical {
+			printer = printer.withTelemetry(s.telemetry)
+		}
 		printers[printer.ID] = printer
 	}
 
+	if csvOut {
+		writePrintersCSV(w, printers)
+		return
+	}
+
+	// ?group_by=group nests printers under their group ID ("" for none)
+	if r.URL.Query().Get("group_by") == "group" {
+		grouped := make(map[string]map[string]Printer)
+		for id, printer := range printers {
+			if grouped[printer.GroupID] == nil {
+				grouped[printer.GroupID] = make(map[string]Printer)
+			}
+			grouped[printer.GroupID][id] = printer
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(grouped)
+		return
+	}
+
 	// Return the list of printers
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(printers)
+	writeMap(w, r, printers)
 }
 
 // handleGetPrinter handles GET /printers/{id} request
-func (s *Server) handleGetPrinter(w http.ResponseWriter, id string) {
-	key := "printer_" + id
-	value, err := s.store.Get(key)
+func (s *Server) handleGetPrinter(w http.ResponseWriter, r *http.Request, id string) {
+	store, historical := s.readStore(r)
+	value, err := store.Get("printer_" + id)
 	if err != nil {
-		http.Error(w, "Printer not found", http.StatusNotFound)
+		s.writeStoreError(w, r, err, "Printer not found")
 		return
 	}
+	var printer Printer
+	if err := json.Unmarshal([]byte(value), &printer); err != nil {
+		writeError(w, r, http.StatusInternalServerError, CodeInternal, "Failed to parse printer data")
+		return
+	}
+	if !historical {
+		printer = printer.withTelemetry(s.telemetry)
+	}
 
 	w.Header().Set("Content-Type", "application/json")
-	w.Write([]byte(value))
+	json.NewEncoder(w).Encode(printer)
+}
+
+// storeNew stores a newly posted entity under prefix plus its ID and returns
+// the stored value. Without an ID the FSM generates one. An existing entity
+// with the same ID is only replaced with ?upsert=true; otherwise the FSM
+// rejects the write with ErrAlreadyExists, so every replica agrees on which
+// create won.
+func (s *Server) storeNew(r *http.Request, prefix, id, value string) (string, error) {
+	return s.storeNewWith(r, prefix, id, value, nil)
+}
+
+// storeNewWith is storeNew, also setting values in the same log entry. Nothing
+// is written unless every condition holds.
+func (s *Server) storeNewWith(r *http.Request, prefix, id, value string, values map[string]string, conditions ...raft.Condition) (string, error) {
+	switch {
+	case id == "" || r.URL.Query().Get("upsert") != "true":
+		return s.storeFor(r).CreateAndSet(prefix, id, value, values, conditions...)
+	case len(values) == 0 && len(conditions) == 0:
+		return value, s.storeFor(r).Set(prefix+id, value)
+	default:
+		all := map[string]string{prefix + id: value}
+		for key, v := range values {
+			all[key] = v
+		}
+		return value, s.storeFor(r).SetMany(all, conditions...)
+	}
+}
+
+// getFilament loads a filament
+func (s *Server) getFilament(id string) (Filament, error) {
+	var filament Filament
+	value, err := s.store.Get("filament_" + id)
+	if err != nil {
+		return filament, err
+	}
+	err = json.Unmarshal([]byte(value), &filament)
+	return filament, err
+}
+
+// getPrintJob loads a print job
+func (s *Server) getPrintJob(id string) (PrintJob, error) {
+	var printJob PrintJob
+	value, err := s.store.Get("printjob_" + id)
+	if err != nil {
+		return printJob, err
+	}
+	err = json.Unmarshal([]byte(value), &printJob)
+	return printJob, err
 }
 
 // handlePostPrinter handles POST /printers request
 func (s *Server) handlePostPrinter(w http.ResponseWriter, r *http.Request) {
-	// Read request body
-	body, err := io.ReadAll(r.Body)
-	if err != nil {
-		http.Error(w, "Failed to read request body", http.StatusBadRequest)
+	r, ok := dryRunRequest(w, r)
+	if !ok {
 		return
 	}
-	defer r.Body.Close()
 
-	// Parse printer data
+	// Parse and validate printer data
 	var printer Printer
-	if err := json.Unmarshal(body, &printer); err != nil {
-		http.Error(w, "Invalid printer data format", http.StatusBadRequest)
+	if !decodeJSON(w, r, &printer) {
 		return
 	}
+	printer.Telemetry = nil
+	if printer.Driver != nil {
+		// The driving node reads the secrets the config refers to and
+		// sends them to the printer's address
+		if !s.requireRole(w, r, RoleAdmin) {
+			return
+		}
+		if errs := printer.Driver.validate(); len(errs) > 0 {
+			writeValidationProblem(w, r, errs)
+			return
+		}
+	}
 
-	// Validate required fields
-	if printer.ID == "" || printer.Name == "" {
-		http.Error(w, "Printer ID and Name are required", http.StatusBadRequest)
+	body, err := json.Marshal(printer)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, CodeInternal, "Failed to process printer data")
 		return
 	}
 
-	// Store printer in the Raft store
-	key := "printer_" + printer.ID
-	if err := s.store.Set(key, string(body)); err != nil {
-		http.Error(w, "Failed to store printer data", http.StatusInternalServerError)
+	// Store printer in the Raft store. A printer joining a group bumps the
+	// group's revision in the same entry, so the group can't be deleted
+	// from under it; if the group changed meanwhile, it is checked again.
+	var stored string
+	for attempt := 1; ; attempt++ {
+		var values map[string]string
+		var conditions []raft.Condition
+		if printer.GroupID != "" {
+			group, condition, err := s.getPrinterGroupRevision(printer.GroupID)
+			if err != nil {
+				writeValidationProblem(w, r, []FieldError{{Name: "group_id", Reason: "printer group does not exist"}})
+				return
+			}
+			group.Revision++
+			groupBody, err := json.Marshal(group)
+			if err != nil {
+				writeError(w, r, http.StatusInternalServerError, CodeInternal, "Failed to process printer group data")
+				return
+			}
+			values = map[string]string{condition.Key: string(groupBody)}
+			conditions = append(conditions, condition)
+		}
+		stored, err = s.storeNewWith(r, "printer_", printer.ID, string(body), values, conditions...)
+		raced := len(conditions) > 0 && errors.Is(err, raft.ErrConflict) && !errors.Is(err, raft.ErrAlreadyExists)
+		if !raced || attempt == admissionAttempts {
+			break
+		}
+	}
+	if err != nil {
+		s.writeStoreError(w, r, err, "Failed to store printer data")
 		return
 	}
 
 	// Return success
-	w.WriteHeader(http.StatusCreated)
 	w.Header().Set("Content-Type", "application/json")
-	w.Write(body)
+	w.WriteHeader(http.StatusCreated)
+	w.Write([]byte(stored))
 }
 
 // handleFilaments handles GET and POST requests for filaments
@@ -116,9 +262,13 @@ func (s *Server) handleFilaments(w http.ResponseWriter, r *http.Request) {
 	case http.MethodGet:
 		s.handleGetFilaments(w, r)
 	case http.MethodPost:
+		if id, ok := strings.CutSuffix(strings.TrimPrefix(r.URL.Path, "/api/v1/filaments/"), "/dried"); ok {
+			s.handleFilamentDried(w, r, id)
+			return
+		}
 		s.handlePostFilament(w, r)
 	default:
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		methodNotAllowed(w, r)
 	}
 }
 
@@ -128,23 +278,40 @@ func (s *Server) handleGetFilaments(w http.ResponseWriter, r *http.Request) {
 	path := strings.TrimPrefix(r.URL.Path, "/api/v1/filaments")
 	if path != "" && path != "/" {
 		filamentID := strings.TrimPrefix(path, "/")
-		s.handleGetFilament(w, filamentID)
+		if id, ok := strings.CutSuffix(filamentID, "/forecast"); ok {
+			s.handleFilamentForecast(w, r, id)
+			return
+		}
+		s.handleGetFilament(w, r, filamentID)
+		return
+	}
+
+	csvOut, ok := wantsCSV(w, r)
+	if !ok {
+		return
+	}
+
+	selector, errs := parseLabelSelector(r.URL.Query())
+	family, familyErrs := parseColorFamily(r.URL.Query().Get("color_family"))
+	if errs = append(errs, familyErrs...); len(errs) > 0 {
+		writeValidationProblem(w, r, errs)
 		return
 	}
 
 	// Get all filaments
 	filaments := make(map[string]Filament)
-	
+
 	// List all keys with prefix "filament_"
-	keys, err := s.store.List("filament_")
+	store, _ := s.readStore(r)
+	keys, err := store.List("filament_")
 	if err != nil {
-		http.Error(w, "Failed to retrieve filaments", http.StatusInternalServerError)
+		s.writeStoreError(w, r, err, "Failed to retrieve filaments")
 		return
 	}
 
 	// Get each filament by key
 	for _, key := range keys {
-		value, err := s.store.Get(key)
+		value, err := store.Get(key)
 		if err != nil {
 			continue
 		}
@@ -153,21 +320,31 @@ func (s *Server) handleGetFilaments(w http.ResponseWriter, r *http.Request) {
 		if err := json.Unmarshal([]byte(value), &filament); err != nil {
 			continue
 		}
+		if !selector.matches(filament.Labels) {
+			continue
+		}
+		if family != "" && filament.colorFamily() != family {
+			continue
+		}
 
 		filaments[filament.ID] = filament
 	}
 
 	// Return the list of filaments
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(filaments)
+	if csvOut {
+		writeFilamentsCSV(w, filaments)
+		return
+	}
+	writeMap(w, r, filaments)
 }
 
 // handleGetFilament handles GET /filaments/{id} request
-func (s *Server) handleGetFilament(w http.ResponseWriter, id string) {
+func (s *Server) handleGetFilament(w http.ResponseWriter, r *http.Request, id string) {
 	key := "filament_" + id
-	value, err := s.store.Get(key)
+	store, _ := s.readStore(r)
+	value, err := store.Get(key)
 	if err != nil {
-		http.Error(w, "Filament not found", http.StatusNotFound)
+		s.writeStoreError(w, r, err, "Filament not found")
 		return
 	}
 
@@ -177,60 +354,109 @@ func (s *Server) handleGetFilament(w http.ResponseWriter, id string) {
 
 // handlePostFilament handles POST /filaments request
 func (s *Server) handlePostFilament(w http.ResponseWriter, r *http.Request) {
-	// Read request body
-	body, err := io.ReadAll(r.Body)
-	if err != nil {
-		http.Error(w, "Failed to read request body", http.StatusBadRequest)
+	r, ok := dryRunRequest(w, r)
+	if !ok {
 		return
 	}
-	defer r.Body.Close()
 
-	// Parse filament data
+	// Parse and validate filament data
 	var filament Filament
-	if err := json.Unmarshal(body, &filament); err != nil {
-		http.Error(w, "Invalid filament data format", http.StatusBadRequest)
+	if !decodeJSON(w, r, &filament) {
 		return
 	}
 
-	// Validate required fields
-	if filament.ID == "" || filament.Name == "" {
-		http.Error(w, "Filament ID and Name are required", http.StatusBadRequest)
+	errs, err := s.prepareFilament(&filament)
+	if err != nil {
+		s.writeStoreError(w, r, err, "Failed to check filament type")
 		return
 	}
-
-	// Validate filament type
-	if !ValidateFilamentType(filament.Type) {
-		http.Error(w, "Invalid filament type. Must be one of: PLA, PETG, ABS, TPU", http.StatusBadRequest)
+	if len(errs) > 0 {
+		writeValidationProblem(w, r, errs)
 		return
 	}
 
-	// If RemainingWeightInGrams is not set, initialize it to TotalWeightInGrams
-	if filament.RemainingWeightInGrams == 0 {
-		filament.RemainingWeightInGrams = filament.TotalWeightInGrams
-		// Re-serialize to include updated field
-		updatedBody, err := json.Marshal(filament)
-		if err != nil {
-			http.Error(w, "Failed to process filament data", http.StatusInternalServerError)
-			return
-		}
-		body = updatedBody
+	body, err := json.Marshal(filament)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, CodeInternal, "Failed to process filament data")
+		return
 	}
 
 	// Store filament in the Raft store
-	key := "filament_" + filament.ID
-	if err := s.store.Set(key, string(body)); err != nil {
-		http.Error(w, "Failed to store filament data", http.StatusInternalServerError)
+	stored, err := s.storeNew(r, "filament_", filament.ID, string(body))
+	if err != nil {
+		s.writeStoreError(w, r, err, "Failed to store filament data")
 		return
 	}
 
 	// Return success
-	w.WriteHeader(http.StatusCreated)
 	w.Header().Set("Content-Type", "application/json")
-	w.Write(body)
+	w.WriteHeader(http.StatusCreated)
+	w.Write([]byte(stored))
+}
+
+// prepareFilament checks a new filament and fills in what it was posted
+// without. Weights are kept to the milligram, the remaining weight defaults
+// to the total, the color is normalized and any vendor must exist.
+func (s *Server) prepareFilament(filament *Filament) ([]FieldError, error) {
+	filament.TotalWeightInGrams = roundGrams(filament.TotalWeightInGrams)
+	filament.RemainingWeightInGrams = roundGrams(filament.RemainingWeightInGrams)
+	if filament.RemainingWeightInGrams == 0 {
+		filament.RemainingWeightInGrams = filament.TotalWeightInGrams
+	}
+	if filament.RemainingWeightInGrams > filament.TotalWeightInGrams {
+		return []FieldError{{Name: "remaining_weight_in_grams", Reason: "must not exceed total_weight_in_grams"}}, nil
+	}
+	color, errs := filament.Color.normalized()
+	if len(errs) > 0 {
+		return errs, nil
+	}
+	filament.Color = color
+	if filament.CostPerKg == 0 && filament.PurchasePrice > 0 && filament.TotalWeightInGrams > 0 {
+		filament.CostPerKg = math.Round(filament.PurchasePrice/filament.TotalWeightInGrams*1000*100) / 100
+	}
+	errs, err := s.checkVendor(filament.VendorID)
+	if err != nil || len(errs) > 0 {
+		return errs, err
+	}
+	errs, err = s.validateFilamentType(filament.Type)
+	if err != nil || len(errs) > 0 {
+		return errs, err
+	}
+	*filament = s.withFilamentDefaults(*filament)
+	return nil, nil
 }
 
 // handlePrintJobs handles GET and POST requests for print jobs
 func (s *Server) handlePrintJobs(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == "/api/v1/print_jobs/status" {
+		if r.Method != http.MethodPost {
+			methodNotAllowed(w, r)
+			return
+		}
+		s.handleBulkStatusUpdate(w, r)
+		return
+	}
+	if jobID, artifactID, ok := artifactRoute(r.URL.Path); ok {
+		s.handleJobArtifacts(w, r, jobID, artifactID)
+		return
+	}
+	if jobID, ok := historyRoute(r.URL.Path); ok {
+		s.handleJobHistory(w, r, jobID)
+		return
+	}
+	if jobID, action, ok := approvalRoute(r.URL.Path); ok {
+		s.handleJobApproval(w, r, jobID, action)
+		return
+	}
+	if jobID, step, action, ok := stepRoute(r.URL.Path); ok {
+		s.handleJobStep(w, r, jobID, step, action)
+		return
+	}
+	if jobID, ok := inspectionRoute(r.URL.Path); ok {
+		s.handleJobInspection(w, r, jobID)
+		return
+	}
+
 	// Check if this is a status update request
 	if strings.Contains(r.URL.Path, "/status") && r.Method == http.MethodPost {
 		parts := strings.Split(r.URL.Path, "/")
@@ -239,7 +465,7 @@ func (s *Server) handlePrintJobs(w http.ResponseWriter, r *http.Request) {
 			s.handleUpdatePrintJobStatus(w, r, jobID)
 			return
 		}
-		http.Error(w, "Invalid URL format for status update", http.StatusBadRequest)
+		writeError(w, r, http.StatusBadRequest, CodeMalformedRequest, "Invalid URL format for status update")
 		return
 	}
 
@@ -249,7 +475,7 @@ func (s *Server) handlePrintJobs(w http.ResponseWriter, r *http.Request) {
 	case http.MethodPost:
 		s.handlePostPrintJob(w, r)
 	default:
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		methodNotAllowed(w, r)
 	}
 }
 
@@ -259,242 +485,474 @@ func (s *Server) handleGetPrintJobs(w http.ResponseWriter, r *http.Request) {
 	path := strings.TrimPrefix(r.URL.Path, "/api/v1/print_jobs")
 	if path != "" && path != "/" {
 		jobID := strings.TrimPrefix(path, "/")
-		s.handleGetPrintJob(w, jobID)
+		s.handleGetPrintJob(w, r, jobID)
 		return
 	}
 
-	// Check for status filter query parameter
+	// Check for status, submitter and overdue filter query parameters
 	statusFilter := r.URL.Query().Get("status")
+	submitterFilter := r.URL.Query().Get("submitted_by")
+	overdueOnly := r.URL.Query().Get("overdue") == "true"
+	groupFilter := groupFilterOf(r)
+	now := time.Now().UTC()
+	selector, errs := parseLabelSelector(r.URL.Query())
+	family, familyErrs := parseColorFamily(r.URL.Query().Get("color_family"))
+	if errs = append(errs, familyErrs...); len(errs) > 0 {
+		writeValidationProblem(w, r, errs)
+		return
+	}
+
+	csvOut, ok := wantsCSV(w, r)
+	if !ok {
+		return
+	}
 
 	// Get all print jobs
 	printJobs := make(map[string]PrintJob)
-	
-	// List all keys with prefix "printjob_"
-	keys, err := s.store.List("printjob_")
+
+	store, _ := s.readStore(r)
+	allJobs, err := listPrintJobsIn(store)
 	if err != nil {
-		http.Error(w, "Failed to retrieve print jobs", http.StatusInternalServerError)
+		s.writeStoreError(w, r, err, "Failed to retrieve print jobs")
 		return
 	}
+	estimates := estimateJobs(allJobs, now)
 
-	// Get each print job by key
-	for _, key := range keys {
-		value, err := s.store.Get(key)
-		if err != nil {
-			continue
+	// Jobs are filtered by color through their filament
+	families := make(map[string]string)
+	filamentFamily := func(id string) string {
+		if family, ok := families[id]; ok {
+			return family
 		}
-
-		var printJob PrintJob
-		if err := json.Unmarshal([]byte(value), &printJob); err != nil {
-			continue
+		var filament Filament
+		if value, err := store.Get("filament_" + id); err == nil {
+			json.Unmarshal([]byte(value), &filament)
 		}
+		families[id] = filament.colorFamily()
+		return families[id]
+	}
 
+	for _, printJob := range allJobs {
 		// Apply status filter if specified
 		if statusFilter != "" && printJob.Status != statusFilter {
 			continue
 		}
+		if submitterFilter != "" && printJob.SubmittedBy != submitterFilter {
+			continue
+		}
+		if overdueOnly && !printJob.isOverdue(now) {
+			continue
+		}
+		if groupFilter != "" && printJob.PrinterGroupID != groupFilter {
+			continue
+		}
+		if !selector.matches(printJob.Labels) {
+			continue
+		}
+		if family != "" && filamentFamily(printJob.FilamentID) != family {
+			continue
+		}
 
-		printJobs[printJob.ID] = printJob
+		printJobs[printJob.ID] = printJob.withEstimate(estimates)
 	}
 
 	// Return the list of print jobs
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(printJobs)
+	if csvOut {
+		writePrintJobsCSV(w, printJobs)
+		return
+	}
+	writeMap(w, r, printJobs)
 }
 
 // handleGetPrintJob handles GET /print_jobs/{id} request
-func (s *Server) handleGetPrintJob(w http.ResponseWriter, id string) {
+func (s *Server) handleGetPrintJob(w http.ResponseWriter, r *http.Request, id string) {
 	key := "printjob_" + id
-	value, err := s.store.Get(key)
+	store, _ := s.readStore(r)
+	value, err := store.Get(key)
 	if err != nil {
-		http.Error(w, "Print job not found", http.StatusNotFound)
+		s.writeStoreError(w, r, err, "Print job not found")
 		return
 	}
 
+	var printJob PrintJob
+	if err := json.Unmarshal([]byte(value), &printJob); err != nil {
+		writeError(w, r, http.StatusInternalServerError, CodeInternal, "Failed to parse print job data")
+		return
+	}
+
+	// Estimates depend on the whole queue, so they are computed on read
+	if printJob.isActive() {
+		allJobs, err := listPrintJobsIn(store)
+		if err != nil {
+			s.writeStoreError(w, r, err, "Failed to retrieve print jobs")
+			return
+		}
+		printJob = printJob.withEstimate(estimateJobs(allJobs, time.Now().UTC()))
+	}
+
 	w.Header().Set("Content-Type", "application/json")
-	w.Write([]byte(value))
+	json.NewEncoder(w).Encode(printJob)
 }
 
 // handlePostPrintJob handles POST /print_jobs request
 func (s *Server) handlePostPrintJob(w http.ResponseWriter, r *http.Request) {
-	// Read request body
-	body, err := io.ReadAll(r.Body)
-	if err != nil {
-		http.Error(w, "Failed to read request body", http.StatusBadRequest)
+	r, ok := dryRunRequest(w, r)
+	if !ok {
 		return
 	}
-	defer r.Body.Close()
 
-	// Parse print job data
+	// Parse and validate print job data
 	var printJob PrintJob
-	if err := json.Unmarshal(body, &printJob); err != nil {
-		http.Error(w, "Invalid print job data format", http.StatusBadRequest)
+	if !decodeJSON(w, r, &printJob) {
 		return
 	}
 
-	// Validate required fields
-	if printJob.ID == "" || printJob.PrinterID == "" || printJob.FilamentID == "" || printJob.FilePath == "" || printJob.PrintWeightInGrams <= 0 {
-		http.Error(w, "All fields are required: ID, PrinterID, FilamentID, FilePath, and PrintWeightInGrams (> 0)", http.StatusBadRequest)
+	submittedBy := ""
+	if principal, ok := principalFrom(r); ok {
+		submittedBy = principal.Name
+	}
+	printJob.TemplateID, printJob.ReprintOf = "", ""
+	printJob, stored, problem, err := s.createPrintJob(printJob, submittedBy, func(job PrintJob, body string, reservation quotaReservation) (string, error) {
+		return s.storeNewWith(r, "printjob_", job.ID, body, reservation.values, reservation.conditions...)
+	})
+	if problem != nil {
+		writeProblem(w, r, *problem)
 		return
 	}
-
-	// Validate printer exists
-	printerKey := "printer_" + printJob.PrinterID
-	_, err = s.store.Get(printerKey)
 	if err != nil {
-		http.Error(w, "Printer not found", http.StatusBadRequest)
+		s.writeStoreError(w, r, err, "Failed to store print job data")
 		return
 	}
+	json.Unmarshal([]byte(stored), &printJob)
+
+	// Return success
+	warnDuplicate(w, printJob)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	w.Write([]byte(stored))
+}
+
+// createPrintJob admits a new job and stores it with store, checking it again
+// if another job of the same submitter was admitted meanwhile. It returns the
+// job as admitted and the stored value, or the problem it was refused with.
+func (s *Server) createPrintJob(submitted PrintJob, submittedBy string, store func(job PrintJob, body string, reservation quotaReservation) (string, error)) (PrintJob, string, *Problem, error) {
+	for attempt := 1; ; attempt++ {
+		job := submitted
+		reservation, problem, err := s.admitPrintJob(&job, submittedBy)
+		if err != nil || problem != nil {
+			return job, "", problem, err
+		}
+
+		body, err := json.Marshal(job)
+		if err != nil {
+			return job, "", nil, err
+		}
+		stored, err := store(job, string(body), reservation)
+		if !reservation.raced(err) || attempt == admissionAttempts {
+			return job, stored, nil, err
+		}
+	}
+}
+
+// admitPrintJob checks a new job against its printer, filament stock and the
+// submitter's quota, and fills in the fields set by the server. It returns a
+// problem if the job can't be accepted, and otherwise the quota reservation
+// the job must be written with.
+func (s *Server) admitPrintJob(printJob *PrintJob, submittedBy string) (quotaReservation, *Problem, error) {
+	templateID := printJob.TemplateID
+
+	// Validate dependencies exist
+	if errs := s.validateDependencies(*printJob); len(errs) > 0 {
+		return quotaReservation{}, validationProblem(errs), nil
+	}
+	if errs := validateSteps(printJob.Steps); len(errs) > 0 {
+		return quotaReservation{}, validationProblem(errs), nil
+	}
+
+	// Resolve the target printer, assigning one from the group if needed
+	if problem, err := s.resolvePrinter(printJob); problem != nil || err != nil {
+		return quotaReservation{}, problem, err
+	}
 
 	// Validate filament exists
 	filamentKey := "filament_" + printJob.FilamentID
 	filamentValue, err := s.store.Get(filamentKey)
 	if err != nil {
-		http.Error(w, "Filament not found", http.StatusBadRequest)
-		return
+		return quotaReservation{}, validationProblem([]FieldError{{Name: "filament_id", Reason: "filament does not exist"}}), nil
 	}
 
 	var filament Filament
 	if err := json.Unmarshal([]byte(filamentValue), &filament); err != nil {
-		http.Error(w, "Failed to parse filament data", http.StatusInternalServerError)
-		return
+		return quotaReservation{}, errorProblem(http.StatusInternalServerError, CodeInternal, "Failed to parse filament data"), nil
+	}
+
+	// Convert what the job asks for to grams
+	filament = s.withFilamentDefaults(filament)
+	if errs := resolvePrintQuantity(printJob, filament); len(errs) > 0 {
+		return quotaReservation{}, validationProblem(errs), nil
 	}
 
 	// Calculate weight already allocated to active print jobs using this filament
 	allocatedWeight, err := s.calculateAllocatedFilamentWeight(printJob.FilamentID)
 	if err != nil {
-		http.Error(w, "Failed to calculate allocated filament weight", http.StatusInternalServerError)
-		return
+		return quotaReservation{}, nil, err
 	}
 
 	// Check if there's enough filament remaining
-	if filament.RemainingWeightInGrams - allocatedWeight < printJob.PrintWeightInGrams {
-		errMsg := fmt.Sprintf("Not enough filament remaining. Available: %d grams, Requested: %d grams",
-			filament.RemainingWeightInGrams - allocatedWeight, printJob.PrintWeightInGrams)
-		http.Error(w, errMsg, http.StatusBadRequest)
-		return
+	available := roundGrams(filament.RemainingWeightInGrams - allocatedWeight)
+	if available < printJob.PrintWeightInGrams {
+		errMsg := fmt.Sprintf("Not enough filament remaining. Available: %g grams, Requested: %g grams",
+			available, printJob.PrintWeightInGrams)
+		return quotaReservation{}, errorProblem(http.StatusConflict, CodeInsufficientFilament, errMsg), nil
 	}
 
-	// Set initial status to Queued
-	printJob.Status = "Queued"
+	// Humidity sensitive spools must have been dried recently
+	printJob.DryingOverridden = false
+	if s.needsDrying(filament, time.Now()) {
+		if !printJob.IgnoreDrying {
+			return quotaReservation{}, dryingProblem(filament), nil
+		}
+		printJob.DryingOverridden = true
+	}
 
-	// Re-serialize to include the status field
-	updatedBody, err := json.Marshal(printJob)
+	// Set initial status to Queued; the remaining fields are set by the server
+	printJob.Status = "Queued"
+	printJob.MaterialCost = 0
+	printJob.CompletedAt = nil
+	printJob.SubmittedBy = submittedBy
+	printJob.DeadlineMissed = false
+	printJob.DuplicateOf = ""
+	printJob.StartedAt = nil
+	printJob.EstimatedStart, printJob.EstimatedCompletion = nil, nil
+	printJob.TemplateID = templateID
+	printJob.resetSteps()
+	printJob.CreatedAt = time.Now().UTC()
+	printJob.ApprovalReason, printJob.ApprovedBy, printJob.ApprovedAt = "", "", nil
+
+	// Large jobs wait for an approver
+	approval, err := s.approvalReason(*printJob, filament)
 	if err != nil {
-		http.Error(w, "Failed to process print job data", http.StatusInternalServerError)
-		return
+		return quotaReservation{}, nil, err
+	}
+	if approval != "" {
+		printJob.Status, printJob.ApprovalReason = "PendingApproval", approval
 	}
 
-	// Store print job in the Raft store
-	key := "printjob_" + printJob.ID
-	if err := s.store.Set(key, string(updatedBody)); err != nil {
-		http.Error(w, "Failed to store print job data", http.StatusInternalServerError)
-		return
+	// Enforce the submitter's quota
+	reason, reservation, err := s.checkQuota(*printJob)
+	if err != nil {
+		return quotaReservation{}, nil, err
+	}
+	if reason != "" {
+		return quotaReservation{}, errorProblem(http.StatusConflict, CodeQuotaExceeded, reason), nil
 	}
 
-	// Return success
-	w.WriteHeader(http.StatusCreated)
-	w.Header().Set("Content-Type", "application/json")
-	w.Write(updatedBody)
+	// Overriding the drying check is audited along with the job
+	if printJob.DryingOverridden {
+		if reservation.values == nil {
+			reservation.values = make(map[string]string)
+		}
+		detail := "Print job accepted on filament due for drying"
+		if printJob.ID != "" {
+			detail = fmt.Sprintf("Print job %s accepted on filament due for drying", printJob.ID)
+		}
+		if err := addAuditEntry(reservation.values, AuditDryingOverridden, submittedBy, "filament_"+printJob.FilamentID, detail); err != nil {
+			return quotaReservation{}, nil, err
+		}
+	}
+	return reservation, nil, nil
 }
 
 // handleUpdatePrintJobStatus handles POST /print_jobs/{id}/status request
 func (s *Server) handleUpdatePrintJobStatus(w http.ResponseWriter, r *http.Request, jobID string) {
-	// Get new status from query parameters
-	newStatus := r.URL.Query().Get("status")
-	if newStatus == "" {
-		http.Error(w, "Status parameter is required", http.StatusBadRequest)
+	r, ok := dryRunRequest(w, r)
+	if !ok {
 		return
 	}
 
-	// Validate status value
-	if newStatus != "Running" && newStatus != "Done" && newStatus != "Canceled" {
-		http.Error(w, "Status must be one of: Running, Done, Canceled", http.StatusBadRequest)
+	// Get new status from query parameters and validate it
+	update := PrintJobStatusUpdate{Status: r.URL.Query().Get("status")}
+	if errs := Validate(update); len(errs) > 0 {
+		writeValidationProblem(w, r, errs)
 		return
 	}
+	newStatus := update.Status
 
 	// Get existing print job
 	jobKey := "printjob_" + jobID
 	jobValue, err := s.store.Get(jobKey)
 	if err != nil {
-		http.Error(w, "Print job not found", http.StatusNotFound)
+		s.writeStoreError(w, r, err, "Print job not found")
 		return
 	}
 
 	var printJob PrintJob
 	if err := json.Unmarshal([]byte(jobValue), &printJob); err != nil {
-		http.Error(w, "Failed to parse print job data", http.StatusInternalServerError)
+		writeError(w, r, http.StatusInternalServerError, CodeInternal, "Failed to parse print job data")
 		return
 	}
 
 	// Validate status transition
 	if err := ValidatePrintJobStatusTransition(printJob.Status, newStatus); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		writeError(w, r, http.StatusConflict, CodeInvalidTransition, err.Error())
 		return
 	}
 
-	// Update print job status
-	oldStatus := printJob.Status
-	printJob.Status = newStatus
-
-	// If status changed to "Done", update filament remaining weight
-	if newStatus == "Done" {
-		// Get filament
-		filamentKey := "filament_" + printJob.FilamentID
-		filamentValue, err := s.store.Get(filamentKey)
+	// A job can only start once everything it depends on is Done
+	var conditions []raft.Condition
+	if newStatus == "Running" && len(printJob.DependsOn) > 0 {
+		pending, err := s.pendingDependencies(printJob)
 		if err != nil {
-			http.Error(w, "Filament not found", http.StatusInternalServerError)
+			s.writeStoreError(w, r, err, "Failed to check dependencies")
 			return
 		}
-
-		var filament Filament
-		if err := json.Unmarshal([]byte(filamentValue), &filament); err != nil {
-			http.Error(w, "Failed to parse filament data", http.StatusInternalServerError)
+		if len(pending) > 0 {
+			writeError(w, r, http.StatusConflict, CodeDependenciesPending,
+				fmt.Sprintf("Print job depends on jobs that are not Done: %s", strings.Join(pending, ", ")))
 			return
 		}
+		conditions = dependencyConditions(printJob)
+	}
 
-		// Reduce filament weight
-		filament.RemainingWeightInGrams -= printJob.PrintWeightInGrams
-		if filament.RemainingWeightInGrams < 0 {
-			filament.RemainingWeightInGrams = 0
+	// Jobs on an offline printer wait until it is back
+	if newStatus == "Running" {
+		problem, err := s.printerOfflineProblem(printJob)
+		if err != nil {
+			s.writeStoreError(w, r, err, "Failed to check printer")
+			return
+		}
+		if problem != nil {
+			writeProblem(w, r, *problem)
+			return
 		}
 
-		// Update filament in store
-		updatedFilamentData, err := json.Marshal(filament)
+		// Someone else's reservation holds the printer
+		problem, err = s.printerReservedProblem(printJob, time.Now().UTC())
 		if err != nil {
-			http.Error(w, "Failed to process filament data", http.StatusInternalServerError)
+			s.writeStoreError(w, r, err, "Failed to check reservations")
 			return
 		}
-
-		if err := s.store.Set(filamentKey, string(updatedFilamentData)); err != nil {
-			http.Error(w, "Failed to update filament data", http.StatusInternalServerError)
+		if problem != nil {
+			writeProblem(w, r, *problem)
 			return
 		}
 	}
 
-	// Save updated print job
-	updatedJobData, err := json.Marshal(printJob)
-	if err != nil {
-		http.Error(w, "Failed to process print job data", http.StatusInternalServerError)
-		return
+	// Update print job status
+	oldStatus := printJob.Status
+	printJob.Status = newStatus
+	if newStatus == "Running" {
+		startedAt := time.Now().UTC()
+		printJob.StartedAt = &startedAt
 	}
+	printJob.syncPrintStep(time.Now().UTC())
 
-	if err := s.store.Set(jobKey, string(updatedJobData)); err != nil {
-		http.Error(w, "Failed to update print job data", http.StatusInternalServerError)
-		return
+	if newStatus == "Done" {
+		// Completing deducts the filament and records usage atomically
+		if err := s.commitStatusChanges(r.Context(), []statusChange{{job: printJob, from: oldStatus}}); err != nil {
+			switch {
+			case errors.Is(err, errJobChanged):
+				writeError(w, r, http.StatusConflict, CodeInvalidTransition, "Print job changed state before it could complete")
+			case errors.Is(err, raft.ErrNotFound):
+				writeError(w, r, http.StatusInternalServerError, CodeInternal, "Filament referenced by print job not found")
+			default:
+				s.writeStoreError(w, r, err, "Failed to complete print job")
+			}
+			return
+		}
+	} else {
+		// Save updated print job
+		updatedJobData, err := json.Marshal(printJob)
+		if err != nil {
+			writeError(w, r, http.StatusInternalServerError, CodeInternal, "Failed to process print job data")
+			return
+		}
+
+		values := map[string]string{jobKey: string(updatedJobData)}
+		if err := s.notifyJobStatus(values, printJob); err != nil {
+			writeError(w, r, http.StatusInternalServerError, CodeInternal, "Failed to record notification")
+			return
+		}
+		// The job is written whole, so it must still be in the status it
+		// was read in, or a stale copy would undo a concurrent change
+		conditions = append(conditions, raft.Condition{Key: jobKey, Field: "status", Equals: oldStatus})
+		if err := s.storeFor(r).SetMany(values, conditions...); err != nil {
+			if errors.Is(err, raft.ErrConflict) && !errors.Is(err, raft.ErrInvalidStepTransition) {
+				if current, getErr := s.getPrintJob(jobID); getErr == nil && current.Status != oldStatus {
+					writeError(w, r, http.StatusConflict, CodeInvalidTransition, "Print job changed state before it could be updated")
+					return
+				}
+				if len(conditions) > 1 {
+					writeError(w, r, http.StatusConflict, CodeDependenciesPending, "A dependency changed state before the job could start")
+					return
+				}
+			}
+			s.writeStoreError(w, r, err, "Failed to update print job data")
+			return
+		}
 	}
 
 	// Return success message
-	response := map[string]string{
+	response := map[string]interface{}{
 		"message": fmt.Sprintf("Print job status updated from %s to %s", oldStatus, newStatus),
 	}
-	
+
+	// Optionally cancel everything that depends on a canceled job
+	if newStatus == "Canceled" && r.URL.Query().Get("cascade") == "true" {
+		canceled, err := s.cascadeCancel(r.Context(), jobID)
+		if err != nil {
+			s.writeStoreError(w, r, err, "Failed to cancel dependent print jobs")
+			return
+		}
+		response["canceled_dependents"] = canceled
+	}
+
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(response)
 }
 
+// resolvePrinter checks the job's printer exists and belongs to its group.
+// Jobs that only name a group are assigned the group's least busy printer.
+func (s *Server) resolvePrinter(printJob *PrintJob) (*Problem, error) {
+	if printJob.PrinterID == "" && printJob.PrinterGroupID == "" {
+		return validationProblem([]FieldError{
+			{Name: "printer_id", Reason: "is required unless printer_group_id is set"},
+		}), nil
+	}
+
+	if printJob.PrinterGroupID != "" {
+		if _, err := s.getPrinterGroup(printJob.PrinterGroupID); err != nil {
+			return validationProblem([]FieldError{{Name: "printer_group_id", Reason: "printer group does not exist"}}), nil
+		}
+		if printJob.PrinterID == "" {
+			printerID, err := s.assignPrinter(printJob.PrinterGroupID)
+			if err != nil {
+				return nil, err
+			}
+			if printerID == "" {
+				return validationProblem([]FieldError{{Name: "printer_group_id", Reason: "printer group has no printers"}}), nil
+			}
+			printJob.PrinterID = printerID
+		}
+	}
+
+	printerValue, err := s.store.Get("printer_" + printJob.PrinterID)
+	if err != nil {
+		return validationProblem([]FieldError{{Name: "printer_id", Reason: "printer does not exist"}}), nil
+	}
+	var printer Printer
+	if err := json.Unmarshal([]byte(printerValue), &printer); err != nil {
+		return errorProblem(http.StatusInternalServerError, CodeInternal, "Failed to parse printer data"), nil
+	}
+	if printJob.PrinterGroupID != "" && printer.GroupID != printJob.PrinterGroupID {
+		return validationProblem([]FieldError{{Name: "printer_id", Reason: "printer is not in printer_group_id"}}), nil
+	}
+	return nil, nil
+}
+
 // calculateAllocatedFilamentWeight calculates the total weight allocated to active print jobs for a filament
-func (s *Server) calculateAllocatedFilamentWeight(filamentID string) (int, error) {
-	allocatedWeight := 0
+func (s *Server) calculateAllocatedFilamentWeight(filamentID string) (float64, error) {
+	allocatedWeight := 0.0
 
 	// List all print jobs
 	keys, err := s.store.List("printjob_")
@@ -515,38 +973,50 @@ func (s *Server) calculateAllocatedFilamentWeight(filamentID string) (int, error
 		}
 
 		// Only count jobs using this filament and in active states
-		if printJob.FilamentID == filamentID && (printJob.Status == "Queued" || printJob.Status == "Running") {
+		if printJob.FilamentID == filamentID && (printJob.isActive() || printJob.Status == "PendingApproval") {
 			allocatedWeight += printJob.PrintWeightInGrams
 		}
 	}
 
-	return allocatedWeight, nil
+	return roundGrams(allocatedWeight), nil
 }
 
 // handleJoin handles requests to join the cluster
 func (s *Server) handleJoin(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		methodNotAllowed(w, r)
 		return
 	}
-
-	var req struct {
-		NodeID   string `json:"node_id"`
-		RaftAddr string `json:"raft_addr"`
+	if !s.requireClusterMember(w, r) {
+		return
 	}
 
+	var req JoinRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Failed to parse request", http.StatusBadRequest)
+		writeError(w, r, http.StatusBadRequest, CodeMalformedRequest, "Failed to parse request")
 		return
 	}
 
 	if req.NodeID == "" || req.RaftAddr == "" {
-		http.Error(w, "Node ID and Raft address are required", http.StatusBadRequest)
+		writeError(w, r, http.StatusBadRequest, CodeValidationFailed, "Node ID and Raft address are required")
+		return
+	}
+	if err := raft.ValidateAddr(req.RaftAddr); err != nil {
+		writeError(w, r, http.StatusBadRequest, CodeValidationFailed, "raft_addr: "+err.Error())
+		return
+	}
+	if _, unix := SocketPath(req.HTTPAddr); req.HTTPAddr != "" && !unix {
+		if err := raft.ValidateAddr(req.HTTPAddr); err != nil {
+			writeError(w, r, http.StatusBadRequest, CodeValidationFailed, "http_addr: "+err.Error())
+			return
+		}
+	}
+	if !s.checkJoinVersion(w, r, req) {
 		return
 	}
 
-	if err := s.store.Join(req.NodeID, req.RaftAddr); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	if err := s.store.Join(req.NodeID, req.RaftAddr, req.HTTPAddr, !req.NonVoter); err != nil {
+		s.writeStoreError(w, r, err, "Failed to add node to the cluster")
 		return
 	}
 
@@ -556,12 +1026,17 @@ func (s *Server) handleJoin(w http.ResponseWriter, r *http.Request) {
 // handleMetrics returns metrics about the cluster
 func (s *Server) handleMetrics(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		methodNotAllowed(w, r)
 		return
 	}
 
 	metrics := s.store.Metrics()
-	
+	if wantsPrometheus(r) {
+		writePrometheus(w, metrics, s.store.ApplyLatency())
+		return
+	}
+	metrics["apply_latency"] = s.store.ApplyLatency()
+
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(metrics)
-}
\ No newline at end of file
+}
@@ -0,0 +1,119 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"raft3d/raft"
+)
+
+// readOnlyHeader is set on every response while the node has lost quorum
+const readOnlyHeader = "X-Raft3d-Read-Only"
+
+// Readiness is the body returned by /readyz
+type Readiness struct {
+	Ready  bool              `json:"ready"`
+	Reason string            `json:"reason,omitempty"`
+	Leader string            `json:"leader,omitempty"`
+	Quorum raft.QuorumStatus `json:"quorum"`
+}
+
+// ClusterStatus is the body returned by /cluster, describing this node's view
+// of the cluster for proxies and tooling
+type ClusterStatus struct {
+	IsLeader bool              `json:"is_leader"`
+	Leader   raft.NodeInfo     `json:"leader"`
+	Nodes    []raft.NodeInfo   `json:"nodes"`
+	Quorum   raft.QuorumStatus `json:"quorum"`
+}
+
+// handleCluster reports the leader and the registered nodes as seen by this
+// node
+func (s *Server) handleCluster(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, r)
+		return
+	}
+
+	status := ClusterStatus{
+		IsLeader: s.store.IsLeader(),
+		Leader:   s.store.LeaderInfo(),
+		Nodes:    []raft.NodeInfo{},
+		Quorum:   s.store.QuorumStatus(),
+	}
+	keys, err := s.store.List("node_")
+	if err != nil {
+		s.writeStoreError(w, r, err, "Failed to list nodes")
+		return
+	}
+	for _, key := range keys {
+		value, err := s.store.Get(key)
+		if err != nil {
+			continue
+		}
+		var node raft.NodeInfo
+		if err := json.Unmarshal([]byte(value), &node); err != nil {
+			continue
+		}
+		status.Nodes = append(status.Nodes, node)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(status)
+}
+
+// handleHealthz reports that the process is up and serving HTTP
+func (s *Server) handleHealthz(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
+}
+
+// handleReadyz reports whether the node can serve writes. It fails while no
+// leader is known or while the node has lost contact with a quorum.
+func (s *Server) handleReadyz(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, r)
+		return
+	}
+
+	readiness := Readiness{Ready: true, Leader: s.store.Leader(), Quorum: s.store.QuorumStatus()}
+	switch {
+	case readiness.Quorum.Lost:
+		readiness.Ready = false
+		readiness.Reason = "quorum lost; node is read-only"
+	case readiness.Leader == "":
+		readiness.Ready = false
+		readiness.Reason = "no leader elected"
+	}
+
+	status := http.StatusOK
+	if !readiness.Ready {
+		status = http.StatusServiceUnavailable
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(readiness)
+}
+
+// readOnlyGuard marks responses from a node that has lost quorum so clients
+// can tell reads may be stale
+func (s *Server) readOnlyGuard(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.store.QuorumStatus().Lost {
+			w.Header().Set(readOnlyHeader, "quorum-lost")
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// writeQuorumLost writes a 503 for a write refused while quorum is lost
+func writeQuorumLost(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(notLeaderRetryAfter.Seconds())))
+	writeError(w, r, http.StatusServiceUnavailable, CodeQuorumLost,
+		"Quorum lost: this node has no contact with a leader and is read-only until the cluster recovers")
+}
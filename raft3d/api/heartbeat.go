@@ -0,0 +1,239 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"raft3d/raft"
+)
+
+// Events published when a printer stops or resumes sending heartbeats
+const (
+	EventPrinterOffline = "printer.offline"
+	EventPrinterOnline  = "printer.online"
+)
+
+// PrinterStatusOffline is the status the heartbeat monitor gives a printer
+// that stopped sending heartbeats. Its queue is paused until it is back.
+const PrinterStatusOffline = "Offline"
+
+// heartbeatMonitor tracks printer heartbeats on the leader. Heartbeats are
+// kept in memory; only the transitions to and from Offline go through Raft.
+type heartbeatMonitor struct {
+	timeout time.Duration
+
+	mutex       sync.Mutex
+	seen        map[string]time.Time // last heartbeat per printer
+	leaderSince time.Time            // zero while not leader
+}
+
+// EnableHeartbeatMonitor makes the leader mark printers Offline when no
+// heartbeat arrives within timeout
+func (s *Server) EnableHeartbeatMonitor(timeout time.Duration) {
+	s.heartbeats = &heartbeatMonitor{timeout: timeout, seen: make(map[string]time.Time)}
+}
+
+// Heartbeat is the optional body of POST /printers/{id}/heartbeat
+type Heartbeat struct {
+	// FirmwareVersion, when set, is recorded on the printer if it changed
+	FirmwareVersion string `json:"firmware_version,omitempty"`
+}
+
+// handlePrinterHeartbeat handles POST /printers/{id}/heartbeat. Heartbeats
+// must reach the leader, which is the node that watches for missing ones.
+func (s *Server) handlePrinterHeartbeat(w http.ResponseWriter, r *http.Request, printerID string) {
+	if !s.store.IsLeader() {
+		s.writeNotLeader(w, r)
+		return
+	}
+	var heartbeat Heartbeat
+	if r.ContentLength != 0 && !decodeJSON(w, r, &heartbeat) {
+		return
+	}
+
+	printer, err := s.getPrinter(printerID)
+	if err != nil {
+		s.writeStoreError(w, r, err, "Printer not found")
+		return
+	}
+
+	now := time.Now().UTC()
+	printer, err = s.printerSeen(printer, now, func(printer *Printer) bool {
+		if heartbeat.FirmwareVersion == "" || heartbeat.FirmwareVersion == printer.FirmwareVersion {
+			return false
+		}
+		printer.FirmwareVersion = heartbeat.FirmwareVersion
+		return true
+	})
+	if err != nil {
+		s.writeStoreError(w, r, err, "Failed to update printer")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"printer_id":       printerID,
+		"status":           printer.Status,
+		"firmware_version": printer.FirmwareVersion,
+		"received_at":      now,
+	})
+}
+
+// printerSeen records that a printer was heard from, by a heartbeat or
+// telemetry, and brings it back online if it was Offline. update, when set,
+// changes the printer in the same write and reports whether it did, so the
+// printer is written at most once, conditioned on the status it was read with.
+func (s *Server) printerSeen(printer Printer, now time.Time, update func(printer *Printer) bool) (Printer, error) {
+	if s.heartbeats != nil {
+		s.heartbeats.mutex.Lock()
+		s.heartbeats.seen[printer.ID] = now
+		s.heartbeats.mutex.Unlock()
+	}
+	from, offlineSince := printer.Status, printer.OfflineSince
+	changed := update != nil && update(&printer)
+	online := from == PrinterStatusOffline
+	if online {
+		printer.Status = printer.StatusBeforeOffline
+		printer.StatusBeforeOffline = ""
+		printer.OfflineSince = nil
+	}
+	if !changed && !online {
+		return printer, nil
+	}
+	if err := s.setPrinterStatus(printer, from); err != nil {
+		return printer, err
+	}
+	if online {
+		log.Printf("Printer %s is back online", printer.ID)
+		s.publish(EventPrinterOnline, map[string]interface{}{
+			"printer_id":    printer.ID,
+			"offline_since": offlineSince,
+		})
+	}
+	return printer, nil
+}
+
+// getPrinter loads a printer
+func (s *Server) getPrinter(id string) (Printer, error) {
+	var printer Printer
+	value, err := s.store.Get("printer_" + id)
+	if err != nil {
+		return printer, err
+	}
+	err = json.Unmarshal([]byte(value), &printer)
+	return printer, err
+}
+
+// setPrinterStatus writes a printer's new status, conditioned on the status
+// it was read with so a concurrent change isn't overwritten
+func (s *Server) setPrinterStatus(printer Printer, from string) error {
+	body, err := json.Marshal(printer)
+	if err != nil {
+		return err
+	}
+	return s.store.SetIf("printer_"+printer.ID, string(body),
+		raft.Condition{Key: "printer_" + printer.ID, Field: "status", Equals: from})
+}
+
+// runHeartbeatMonitor checks for missing heartbeats until the server stops
+func (s *Server) runHeartbeatMonitor() {
+	interval := s.heartbeats.timeout / 4
+	if interval < time.Second {
+		interval = time.Second
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			s.checkHeartbeats(time.Now().UTC())
+		case <-s.stopCh:
+			return
+		}
+	}
+}
+
+// checkHeartbeats marks printers Offline that haven't sent a heartbeat within
+// the timeout. A new leader has no heartbeat history, so it gives every
+// printer a full timeout from when it took over.
+func (s *Server) checkHeartbeats(now time.Time) {
+	m := s.heartbeats
+	m.mutex.Lock()
+	if !s.store.IsLeader() {
+		m.leaderSince = time.Time{}
+		m.seen = make(map[string]time.Time)
+		m.mutex.Unlock()
+		return
+	}
+	if m.leaderSince.IsZero() {
+		m.leaderSince = now
+	}
+	lastSeen := make(map[string]time.Time, len(m.seen))
+	for id, at := range m.seen {
+		lastSeen[id] = at
+	}
+	leaderSince := m.leaderSince
+	m.mutex.Unlock()
+
+	printers, err := s.listPrinters()
+	if err != nil {
+		log.Printf("Heartbeat monitor: failed to list printers: %s", err)
+		return
+	}
+	for _, printer := range printers {
+		if printer.Status == PrinterStatusOffline {
+			continue
+		}
+		last, ok := lastSeen[printer.ID]
+		if !ok || last.Before(leaderSince) {
+			last = leaderSince
+		}
+		if now.Sub(last) <= m.timeout {
+			continue
+		}
+
+		from := printer.Status
+		printer.StatusBeforeOffline = from
+		printer.Status = PrinterStatusOffline
+		printer.OfflineSince = &now
+		if err := s.setPrinterStatus(printer, from); err != nil {
+			if !errors.Is(err, raft.ErrConflict) {
+				log.Printf("Heartbeat monitor: failed to mark %s offline: %s", printer.ID, err)
+			}
+			continue
+		}
+
+		log.Printf("Printer %s missed its heartbeat and is offline", printer.ID)
+		s.publish(EventPrinterOffline, map[string]interface{}{
+			"printer_id":     printer.ID,
+			"last_heartbeat": lastSeen[printer.ID],
+			"timeout":        m.timeout.String(),
+		})
+	}
+}
+
+// printerOfflineProblem returns a problem if a job's printer is Offline, so
+// its queue stays paused until the printer sends a heartbeat again
+func (s *Server) printerOfflineProblem(job PrintJob) (*Problem, error) {
+	if job.PrinterID == "" {
+		return nil, nil
+	}
+	printer, err := s.getPrinter(job.PrinterID)
+	if err != nil {
+		if errors.Is(err, raft.ErrNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	if printer.Status != PrinterStatusOffline {
+		return nil, nil
+	}
+	return errorProblem(http.StatusConflict, CodePrinterOffline,
+		fmt.Sprintf("Printer %s is offline; its queue is paused until it sends a heartbeat", printer.ID)), nil
+}
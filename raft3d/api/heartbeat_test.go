@@ -0,0 +1,70 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"raft3d/testsupport"
+)
+
+func TestHeartbeatsTakePrintersOfflineAndBack(t *testing.T) {
+	c := testsupport.NewCluster(t, 1)
+	leader := c.WaitForLeader(10 * time.Second)
+	s := NewServer("", leader.Store)
+	s.EnableHeartbeatMonitor(time.Minute)
+
+	body, _ := json.Marshal(Printer{ID: "p1", Name: "Prusa", Status: "Idle", FirmwareVersion: "2.0.9"})
+	if err := leader.Store.Set("printer_p1", string(body)); err != nil {
+		t.Fatal(err)
+	}
+	printer := func() Printer {
+		printer, err := s.getPrinter("p1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return printer
+	}
+
+	// A new leader has heard from nobody, so it allows every printer a full
+	// timeout from when it took over
+	takeover := time.Now().UTC()
+	s.checkHeartbeats(takeover)
+	s.checkHeartbeats(takeover.Add(50 * time.Second))
+	if p := printer(); p.Status != "Idle" {
+		t.Fatalf("offline within the grace period: %+v", p)
+	}
+	s.checkHeartbeats(takeover.Add(2 * time.Minute))
+	if p := printer(); p.Status != PrinterStatusOffline || p.StatusBeforeOffline != "Idle" || p.OfflineSince == nil {
+		t.Fatalf("missed heartbeat: %+v", p)
+	}
+
+	// Coming back online and a new firmware version are one conditioned write
+	index := leader.Store.AppliedIndex()
+	rec := httptest.NewRecorder()
+	s.handlePrinterHeartbeat(rec, httptest.NewRequest(http.MethodPost, "/api/v1/printers/p1/heartbeat", strings.NewReader(`{"firmware_version":"2.1.0"}`)), "p1")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("heartbeat: %d %s", rec.Code, rec.Body)
+	}
+	if p := printer(); p.Status != "Idle" || p.StatusBeforeOffline != "" || p.OfflineSince != nil || p.FirmwareVersion != "2.1.0" {
+		t.Fatalf("after heartbeat: %+v", p)
+	}
+	if writes := leader.Store.AppliedIndex() - index; writes != 1 {
+		t.Fatalf("heartbeat took %d writes", writes)
+	}
+
+	// A heartbeat with nothing new writes nothing, and keeps the printer online
+	index = leader.Store.AppliedIndex()
+	rec = httptest.NewRecorder()
+	s.handlePrinterHeartbeat(rec, httptest.NewRequest(http.MethodPost, "/api/v1/printers/p1/heartbeat", nil), "p1")
+	if rec.Code != http.StatusOK || leader.Store.AppliedIndex() != index {
+		t.Fatalf("plain heartbeat: %d, %d writes", rec.Code, leader.Store.AppliedIndex()-index)
+	}
+	s.checkHeartbeats(time.Now().UTC().Add(50 * time.Second))
+	if p := printer(); p.Status != "Idle" {
+		t.Fatalf("offline despite a heartbeat: %+v", p)
+	}
+}
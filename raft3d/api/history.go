@@ -0,0 +1,79 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+
+	"raft3d/raft"
+)
+
+// historyRoute reports whether path is /api/v1/print_jobs/{id}/history
+func historyRoute(path string) (jobID string, ok bool) {
+	rest := strings.TrimPrefix(path, "/api/v1/print_jobs/")
+	jobID, ok = strings.CutSuffix(strings.TrimSuffix(rest, "/"), "/history")
+	return jobID, ok && jobID != "" && !strings.Contains(jobID, "/")
+}
+
+// handleJobHistory handles GET /print_jobs/{id}/history, listing the job's
+// status transitions as recorded by the FSM
+func (s *Server) handleJobHistory(w http.ResponseWriter, r *http.Request, jobID string) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, r)
+		return
+	}
+	key := "printjob_" + jobID
+	if _, err := s.store.Get(key); err != nil {
+		s.writeStoreError(w, r, err, "Print job not found")
+		return
+	}
+	entries, err := s.store.Changelog(key)
+	if err != nil {
+		s.writeStoreError(w, r, err, "Failed to retrieve print job history")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(entries)
+}
+
+// handleChangelogRetention handles GET and PUT
+// /api/v1/admin/retention/changelog, which bounds the history kept per entity
+func (s *Server) handleChangelogRetention(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		var retention raft.ChangelogRetention
+		value, err := s.store.Get(raft.ChangelogRetentionKey)
+		if err != nil && !errors.Is(err, raft.ErrNotFound) {
+			s.writeStoreError(w, r, err, "Failed to retrieve changelog retention")
+			return
+		}
+		if err == nil {
+			json.Unmarshal([]byte(value), &retention)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(retention)
+	case http.MethodPut:
+		if !s.requireRole(w, r, RoleAdmin) {
+			return
+		}
+		var retention raft.ChangelogRetention
+		if !decodeJSON(w, r, &retention) {
+			return
+		}
+		body, err := json.Marshal(retention)
+		if err != nil {
+			writeError(w, r, http.StatusInternalServerError, CodeInternal, "Failed to process changelog retention")
+			return
+		}
+		if err := s.storeFor(r).Set(raft.ChangelogRetentionKey, string(body)); err != nil {
+			s.writeStoreError(w, r, err, "Failed to store changelog retention")
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(body)
+	default:
+		methodNotAllowed(w, r)
+	}
+}
@@ -0,0 +1,53 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"raft3d/raft"
+	"raft3d/testsupport"
+)
+
+func TestJobHistory(t *testing.T) {
+	c := testsupport.NewCluster(t, 1)
+	leader := c.WaitForLeader(10 * time.Second)
+	s := NewServer("", leader.Store)
+
+	for _, status := range []string{"Queued", "Running", "Done"} {
+		body, _ := json.Marshal(PrintJob{ID: "j1", PrinterID: "p1", FilamentID: "f1", FilePath: "part.gcode", Status: status})
+		if err := leader.Store.Set("printjob_j1", string(body)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	s.handlePrintJobs(rec, httptest.NewRequest(http.MethodGet, "/api/v1/print_jobs/j1/history", nil))
+	var entries []raft.ChangelogEntry
+	if rec.Code != http.StatusOK || json.NewDecoder(rec.Body).Decode(&entries) != nil || len(entries) != 3 {
+		t.Fatalf("history: %d %v", rec.Code, entries)
+	}
+	if last := entries[2]; last.From != "Running" || last.To != "Done" || last.Index == 0 || last.At == nil {
+		t.Fatalf("last transition: %+v", last)
+	}
+
+	rec = httptest.NewRecorder()
+	s.handlePrintJobs(rec, httptest.NewRequest(http.MethodGet, "/api/v1/print_jobs/missing/history", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("missing job: %d", rec.Code)
+	}
+
+	rec = httptest.NewRecorder()
+	s.handleRetention(rec, httptest.NewRequest(http.MethodPut, "/api/v1/admin/retention/changelog", strings.NewReader(`{"max_entries":-1}`)))
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("negative retention: %d", rec.Code)
+	}
+	rec = httptest.NewRecorder()
+	s.handleRetention(rec, httptest.NewRequest(http.MethodPut, "/api/v1/admin/retention/changelog", strings.NewReader(`{"max_entries":10}`)))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("set retention: %d %s", rec.Code, rec.Body)
+	}
+}
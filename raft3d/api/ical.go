@@ -0,0 +1,88 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// icalTime is the UTC date-time format of iCalendar
+const icalTime = "20060102T150405Z"
+
+// icalEscaper escapes text property values as RFC 5545 requires
+var icalEscaper = strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\r\n", `\n`, "\n", `\n`)
+
+// handlePrinterICalendar handles GET /printers/{id}/calendar.ics, the
+// printer's calendar as an iCalendar feed for calendar apps to subscribe
+// to. It takes the same ?from= and ?to= as the JSON calendar. Estimated
+// job slots are marked tentative.
+func (s *Server) handlePrinterICalendar(w http.ResponseWriter, r *http.Request, printerID string) {
+	calendar, ok := s.printerCalendar(w, r, printerID)
+	if !ok {
+		return
+	}
+
+	var b strings.Builder
+	line := func(name, value string) {
+		writeICalLine(&b, name+":"+value)
+	}
+	stamp := time.Now().UTC().Format(icalTime)
+	line("BEGIN", "VCALENDAR")
+	line("VERSION", "2.0")
+	line("PRODID", "-//raft3d//printer calendar//EN")
+	line("CALSCALE", "GREGORIAN")
+	line("X-WR-CALNAME", icalEscaper.Replace("Printer "+printerID))
+	for _, entry := range calendar.Entries {
+		line("BEGIN", "VEVENT")
+		line("UID", icalEscaper.Replace(entry.Type+"-"+entry.ID+"@raft3d"))
+		line("DTSTAMP", stamp)
+		line("DTSTART", entry.Start.UTC().Format(icalTime))
+		line("DTEND", entry.End.UTC().Format(icalTime))
+		line("SUMMARY", icalEscaper.Replace(entry.Title))
+		line("CATEGORIES", strings.ToUpper(entry.Type))
+		var description []string
+		if entry.Status != "" {
+			description = append(description, "Status: "+entry.Status)
+		}
+		if entry.Owner != "" {
+			description = append(description, "By: "+entry.Owner)
+		}
+		if entry.Estimated {
+			description = append(description, "Times are estimated")
+		}
+		if len(description) > 0 {
+			line("DESCRIPTION", icalEscaper.Replace(strings.Join(description, "\n")))
+		}
+		if entry.Estimated {
+			line("STATUS", "TENTATIVE")
+		} else {
+			line("STATUS", "CONFIRMED")
+		}
+		line("END", "VEVENT")
+	}
+	line("END", "VCALENDAR")
+
+	w.Header().Set("Content-Type", "text/calendar; charset=utf-8")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("inline; filename=%q", "printer-"+printerID+".ics"))
+	w.Write([]byte(b.String()))
+}
+
+// writeICalLine writes a content line, folding it into lines of at most 75
+// octets without splitting a UTF-8 character
+func writeICalLine(b *strings.Builder, content string) {
+	limit := 75
+	for len(content) > limit {
+		cut := limit
+		for cut > 0 && content[cut]&0xC0 == 0x80 {
+			cut--
+		}
+		b.WriteString(content[:cut])
+		b.WriteString("\r\n ")
+		content = content[cut:]
+		// Continuation lines start with the space
+		limit = 74
+	}
+	b.WriteString(content)
+	b.WriteString("\r\n")
+}
@@ -0,0 +1,74 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"raft3d/testsupport"
+)
+
+func TestPrinterICalendarFeed(t *testing.T) {
+	c := testsupport.NewCluster(t, 1)
+	leader := c.WaitForLeader(10 * time.Second)
+	s := NewServer("", leader.Store)
+	s.EnableAuth([]APIKey{{Key: "lab-key", Principal: Principal{Name: "lab", Role: RoleMember}}})
+
+	now := time.Now().UTC().Truncate(time.Second)
+	start := now.Add(time.Hour)
+	for key, v := range map[string]interface{}{
+		"printer_p1":     Printer{ID: "p1", Name: "Prusa", Status: "Idle"},
+		"reservation_r1": Reservation{ID: "r1", PrinterID: "p1", Start: start, End: start.Add(2 * time.Hour), Purpose: "thesis; part 2, " + strings.Repeat("long ", 20), ReservedBy: "alice"},
+		"maintwindow_m1": MaintenanceWindow{ID: "m1", PrinterID: "p1", Start: start.Add(24 * time.Hour), End: start.Add(26 * time.Hour)},
+		"printjob_j1":    PrintJob{ID: "j1", PrinterID: "p1", FilamentID: "f1", FilePath: "a.gcode", PrintWeightInGrams: 15, Status: "Queued", SubmittedBy: "bob", CreatedAt: now},
+	} {
+		body, _ := json.Marshal(v)
+		if err := leader.Store.Set(key, string(body)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	handler := s.authenticate(http.HandlerFunc(s.handlePrinters))
+	r := httptest.NewRequest(http.MethodGet, "/api/v1/printers/p1/calendar.ics", nil)
+	r.SetBasicAuth("", "lab-key")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, r)
+	if rec.Code != http.StatusOK || !strings.HasPrefix(rec.Header().Get("Content-Type"), "text/calendar") {
+		t.Fatalf("feed: %d %s", rec.Code, rec.Body)
+	}
+
+	feed := rec.Body.String()
+	for _, line := range strings.Split(strings.TrimSuffix(feed, "\r\n"), "\r\n") {
+		if len(line) > 75 {
+			t.Errorf("line not folded: %q", line)
+		}
+	}
+	unfolded := strings.ReplaceAll(feed, "\r\n ", "")
+	for _, want := range []string{
+		"BEGIN:VCALENDAR\r\n",
+		"UID:reservation-r1@raft3d\r\nDTSTAMP:",
+		"DTSTART:" + start.Format(icalTime) + "\r\n",
+		`SUMMARY:Reserved: thesis\; part 2\, long`,
+		"UID:maintenance-m1@raft3d",
+		"UID:print_job-j1@raft3d",
+		`DESCRIPTION:Status: Queued\nBy: bob\nTimes are estimated` + "\r\nSTATUS:TENTATIVE\r\n",
+		"END:VCALENDAR\r\n",
+	} {
+		if !strings.Contains(unfolded, want) {
+			t.Errorf("feed lacks %q:\n%s", want, feed)
+		}
+	}
+	// The reservation pushes bob's job past it
+	if strings.Index(unfolded, "UID:print_job-j1") < strings.Index(unfolded, "UID:reservation-r1") {
+		t.Errorf("job estimated before the reservation:\n%s", feed)
+	}
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1/printers/p1/calendar.ics", nil))
+	if rec.Code != http.StatusUnauthorized {
+		t.Fatalf("feed without a key: %d", rec.Code)
+	}
+}
@@ -0,0 +1,240 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"raft3d/raft"
+)
+
+// inspectionKeyPrefix prefixes inspection results, one per print job
+const inspectionKeyPrefix = "inspection_"
+
+// EventJobInspected is published when an inspection result is recorded
+const EventJobInspected = "print_job.inspected"
+
+// Inspection results
+const (
+	InspectionPass = "pass"
+	InspectionFail = "fail"
+)
+
+// Inspection is the quality check of a Done job's part. Inspecting a job
+// again replaces its result.
+type Inspection struct {
+	Result      string   `json:"result" validate:"required,oneof=pass fail"`
+	DefectCodes []string `json:"defect_codes,omitempty"`
+	Notes       string   `json:"notes,omitempty"`
+
+	// Reprint queues a copy of a failed job in the same write
+	Reprint bool `json:"reprint,omitempty"`
+
+	// Set by the server, the printer and spool as they were when inspected
+	JobID        string    `json:"job_id"`
+	PrinterID    string    `json:"printer_id"`
+	FilamentID   string    `json:"filament_id"`
+	LotNumber    string    `json:"lot_number,omitempty"`
+	ReprintJobID string    `json:"reprint_job_id,omitempty"`
+	InspectedBy  string    `json:"inspected_by,omitempty"`
+	InspectedAt  time.Time `json:"inspected_at"`
+}
+
+// inspectionRoute reports whether path is /api/v1/print_jobs/{id}/inspection
+func inspectionRoute(path string) (jobID string, ok bool) {
+	rest := strings.TrimPrefix(path, "/api/v1/print_jobs/")
+	jobID, action, found := strings.Cut(strings.TrimSuffix(rest, "/"), "/")
+	return jobID, found && jobID != "" && action == "inspection"
+}
+
+// reprintID is the ID of the job queued to reprint a failed one
+func reprintID(jobID string) string {
+	return jobID + "-reprint"
+}
+
+// getInspection loads a job's inspection
+func (s *Server) getInspection(jobID string) (Inspection, error) {
+	var inspection Inspection
+	value, err := s.store.Get(inspectionKeyPrefix + jobID)
+	if err != nil {
+		return inspection, err
+	}
+	err = json.Unmarshal([]byte(value), &inspection)
+	return inspection, err
+}
+
+// handleJobInspection handles GET and POST /print_jobs/{id}/inspection
+func (s *Server) handleJobInspection(w http.ResponseWriter, r *http.Request, jobID string) {
+	switch r.Method {
+	case http.MethodGet:
+		value, err := s.store.Get(inspectionKeyPrefix + jobID)
+		if err != nil {
+			s.writeStoreError(w, r, err, "Print job has not been inspected")
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(value))
+	case http.MethodPost:
+		s.handlePostInspection(w, r, jobID)
+	default:
+		methodNotAllowed(w, r)
+	}
+}
+
+// handlePostInspection records the inspection of a Done job. A Running
+// inspection step of the job finishes with it, passing or failing, and a
+// failed job can be reprinted: the copy is admitted like a new job from the
+// same submitter and written in the same entry as the result.
+func (s *Server) handlePostInspection(w http.ResponseWriter, r *http.Request, jobID string) {
+	var inspection Inspection
+	if !decodeJSON(w, r, &inspection) {
+		return
+	}
+	var errs []FieldError
+	for i, code := range inspection.DefectCodes {
+		if strings.TrimSpace(code) == "" {
+			errs = append(errs, FieldError{Name: fmt.Sprintf("defect_codes[%d]", i), Reason: "is required"})
+		}
+	}
+	if inspection.Reprint && inspection.Result != InspectionFail {
+		errs = append(errs, FieldError{Name: "reprint", Reason: "only failed inspections can be reprinted"})
+	}
+	if len(errs) > 0 {
+		writeValidationProblem(w, r, errs)
+		return
+	}
+
+	job, err := s.getPrintJob(jobID)
+	if err != nil {
+		s.writeStoreError(w, r, err, "Print job not found")
+		return
+	}
+	if job.Status != "Done" {
+		writeError(w, r, http.StatusConflict, CodeConflict,
+			fmt.Sprintf("Only Done jobs can be inspected; this one is %s", job.Status))
+		return
+	}
+	filament, err := s.getFilament(job.FilamentID)
+	if err != nil && !errors.Is(err, raft.ErrNotFound) {
+		s.writeStoreError(w, r, err, "Failed to retrieve filament")
+		return
+	}
+
+	now := time.Now().UTC()
+	inspection.JobID, inspection.PrinterID, inspection.FilamentID = job.ID, job.PrinterID, job.FilamentID
+	inspection.LotNumber, inspection.InspectedAt, inspection.InspectedBy = filament.LotNumber, now, ""
+	inspection.ReprintJobID = ""
+	if principal, ok := principalFrom(r); ok {
+		inspection.InspectedBy = principal.Name
+	}
+	// A job reprinted after an earlier inspection stays linked to the reprint
+	if previous, err := s.getInspection(job.ID); err == nil {
+		inspection.ReprintJobID = previous.ReprintJobID
+	}
+	if inspection.Reprint {
+		inspection.ReprintJobID = reprintID(job.ID)
+	}
+	body, err := json.Marshal(inspection)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, CodeInternal, "Failed to process inspection")
+		return
+	}
+
+	values := map[string]string{inspectionKeyPrefix + job.ID: string(body)}
+	if job.finishInspectionStep(inspection.Result == InspectionPass, now) {
+		jobBody, err := json.Marshal(job)
+		if err != nil {
+			writeError(w, r, http.StatusInternalServerError, CodeInternal, "Failed to process print job data")
+			return
+		}
+		values["printjob_"+job.ID] = string(jobBody)
+	}
+	condition := raft.Condition{Key: "printjob_" + job.ID, Field: "status", Equals: "Done"}
+
+	response := map[string]interface{}{"inspection": inspection}
+	if inspection.Reprint {
+		reprint, problem, err := s.reprintJob(r, job, values, condition)
+		if problem != nil {
+			writeProblem(w, r, *problem)
+			return
+		}
+		if err != nil {
+			s.writeStoreError(w, r, err, "Failed to store inspection")
+			return
+		}
+		response["reprint_job"] = reprint
+	} else if err := s.storeFor(r).SetMany(values, condition); err != nil {
+		s.writeStoreError(w, r, err, "Failed to store inspection")
+		return
+	}
+	s.publish(EventJobInspected, map[string]interface{}{
+		"job_id":         job.ID,
+		"result":         inspection.Result,
+		"defect_codes":   inspection.DefectCodes,
+		"reprint_job_id": inspection.ReprintJobID,
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(response)
+}
+
+// reprintJob admits a copy of a failed job, with its steps back to Pending,
+// and creates it along with values. A job is reprinted at most once.
+func (s *Server) reprintJob(r *http.Request, job PrintJob, values map[string]string, condition raft.Condition) (PrintJob, *Problem, error) {
+	reprint := PrintJob{
+		ID:                 reprintID(job.ID),
+		PrinterID:          job.PrinterID,
+		PrinterGroupID:     job.PrinterGroupID,
+		FilamentID:         job.FilamentID,
+		FilePath:           job.FilePath,
+		PrintWeightInGrams: job.PrintWeightInGrams,
+		DueBy:              job.DueBy,
+		Labels:             job.Labels,
+		Annotations:        job.Annotations,
+		Steps:              append([]JobStep(nil), job.Steps...),
+		ReprintOf:          job.ID,
+	}
+	reprint, _, problem, err := s.createPrintJob(reprint, job.SubmittedBy, func(admitted PrintJob, body string, reservation quotaReservation) (string, error) {
+		all := map[string]string{}
+		for key, value := range values {
+			all[key] = value
+		}
+		for key, value := range reservation.values {
+			all[key] = value
+		}
+		conditions := append([]raft.Condition{condition}, reservation.conditions...)
+		return s.storeFor(r).CreateAndSet("printjob_", admitted.ID, body, all, conditions...)
+	})
+	if err != nil && errors.Is(err, raft.ErrAlreadyExists) {
+		return reprint, errorProblem(http.StatusConflict, CodeAlreadyExists,
+			fmt.Sprintf("Print job %s has already been reprinted as %s", job.ID, reprint.ID)), nil
+	}
+	return reprint, problem, err
+}
+
+// finishInspectionStep ends the job's Running inspection step, if it has
+// one, with the inspection's result. A passed step needing sign-off awaits
+// it. It reports whether a step changed.
+func (j *PrintJob) finishInspectionStep(passed bool, now time.Time) bool {
+	for i := range j.Steps {
+		step := &j.Steps[i]
+		if step.Kind != "inspection" || step.Status != raft.StepRunning {
+			continue
+		}
+		step.CompletedAt = &now
+		switch {
+		case !passed:
+			step.Status = raft.StepFailed
+		case step.RequiresSignOff:
+			step.Status = raft.StepAwaitingSignOff
+		default:
+			step.Status = raft.StepDone
+		}
+		return true
+	}
+	return false
+}
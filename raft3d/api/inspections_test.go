@@ -0,0 +1,103 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"raft3d/raft"
+	"raft3d/testsupport"
+)
+
+func TestInspectionReprintsAndReportsDefects(t *testing.T) {
+	c := testsupport.NewCluster(t, 1)
+	leader := c.WaitForLeader(10 * time.Second)
+	s := NewServer("", leader.Store)
+	for key, v := range map[string]interface{}{
+		"printer_p1":  Printer{ID: "p1", Name: "Prusa", Status: "Idle"},
+		"filament_f1": Filament{ID: "f1", Name: "PLA", Type: "PLA", TotalWeightInGrams: 1000, RemainingWeightInGrams: 1000, LotNumber: "L42"},
+	} {
+		body, _ := json.Marshal(v)
+		if err := leader.Store.Set(key, string(body)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	do := func(method, url, body string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, url, strings.NewReader(body))
+		r = r.WithContext(context.WithValue(r.Context(), principalKey{}, Principal{Name: "qa-lead", Role: RoleOperator}))
+		rec := httptest.NewRecorder()
+		switch {
+		case strings.HasPrefix(url, "/api/v1/reports/"):
+			s.handleDefectReport(rec, r)
+		case method == http.MethodPost && url == "/api/v1/print_jobs":
+			s.handlePostPrintJob(rec, r)
+		default:
+			s.handlePrintJobs(rec, r)
+		}
+		return rec
+	}
+	printed := func(id string) {
+		rec := do(http.MethodPost, "/api/v1/print_jobs", `{"id":"`+id+`","printer_id":"p1","filament_id":"f1","filepath":"`+id+`.gcode","print_weight_in_grams":10,
+			"steps":[{"name":"print","kind":"print"},{"name":"qa","kind":"inspection"}]}`)
+		if rec.Code != http.StatusCreated {
+			t.Fatalf("submit %s: %d %s", id, rec.Code, rec.Body)
+		}
+		for _, url := range []string{"/status?status=Running", "/status?status=Done", "/steps/qa/status?status=Running"} {
+			if rec := do(http.MethodPost, "/api/v1/print_jobs/"+id+url, ""); rec.Code != http.StatusOK {
+				t.Fatalf("%s%s: %d %s", id, url, rec.Code, rec.Body)
+			}
+		}
+	}
+
+	printed("good")
+	if rec := do(http.MethodPost, "/api/v1/print_jobs/good/inspection", `{"result":"pass","reprint":true}`); rec.Code != http.StatusBadRequest {
+		t.Fatalf("reprint of a passed part: %d", rec.Code)
+	}
+	if rec := do(http.MethodPost, "/api/v1/print_jobs/good/inspection", `{"result":"pass"}`); rec.Code != http.StatusCreated {
+		t.Fatalf("pass: %d %s", rec.Code, rec.Body)
+	}
+
+	printed("bad")
+	rec := do(http.MethodPost, "/api/v1/print_jobs/bad/inspection", `{"result":"fail","defect_codes":["warping","stringing"],"notes":"corner lifted","reprint":true}`)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("fail: %d %s", rec.Code, rec.Body)
+	}
+	var created struct {
+		Inspection Inspection `json:"inspection"`
+		ReprintJob PrintJob   `json:"reprint_job"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&created); err != nil {
+		t.Fatal(err)
+	}
+	if created.Inspection.LotNumber != "L42" || created.Inspection.InspectedBy != "qa-lead" || created.Inspection.ReprintJobID != "bad-reprint" {
+		t.Fatalf("inspection = %+v", created.Inspection)
+	}
+	reprint := created.ReprintJob
+	if reprint.ID != "bad-reprint" || reprint.ReprintOf != "bad" || reprint.Status != "Queued" || reprint.Steps[1].Status != raft.StepPending {
+		t.Fatalf("reprint = %+v", reprint)
+	}
+	if job, err := s.getPrintJob("bad"); err != nil || job.Steps[1].Status != raft.StepFailed {
+		t.Fatalf("inspection step of the failed job: %+v %v", job.Steps, err)
+	}
+	if rec := do(http.MethodPost, "/api/v1/print_jobs/bad/inspection", `{"result":"fail","reprint":true}`); rec.Code != http.StatusConflict {
+		t.Fatalf("reprinted twice: %d %s", rec.Code, rec.Body)
+	}
+	if rec := do(http.MethodPost, "/api/v1/print_jobs/bad-reprint/inspection", `{"result":"pass"}`); rec.Code != http.StatusConflict {
+		t.Fatalf("queued job inspected: %d", rec.Code)
+	}
+
+	rec = do(http.MethodGet, "/api/v1/reports/defects", "")
+	var report DefectReport
+	if rec.Code != http.StatusOK || json.NewDecoder(rec.Body).Decode(&report) != nil {
+		t.Fatalf("report: %d %s", rec.Code, rec.Body)
+	}
+	want := DefectSummary{Inspected: 2, Failed: 1, DefectRate: 0.5}
+	if report.Total != want || report.ByPrinter["p1"] != want || report.ByFilamentLot["L42"] != want || report.ByDefectCode["warping"] != 1 {
+		t.Fatalf("report = %+v", report)
+	}
+}
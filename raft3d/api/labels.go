@@ -0,0 +1,138 @@
+package api
+
+import (
+	"fmt"
+	"net/url"
+	"reflect"
+	"strings"
+)
+
+// Limits on the labels of a single entity
+const (
+	maxLabels        = 64
+	maxLabelKeyLen   = 63
+	maxLabelValueLen = 63
+)
+
+// validLabelText reports whether s only uses the characters allowed in label
+// keys and values: letters, digits, '-', '_', '.' and '/'
+func validLabelText(s string) bool {
+	for _, c := range s {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || strings.ContainsRune("-_./", c)) {
+			return false
+		}
+	}
+	return true
+}
+
+// checkLabels validates a labels map for the "labels" rule
+func checkLabels(fv reflect.Value) string {
+	labels, ok := fv.Interface().(map[string]string)
+	if !ok {
+		return "must be a map of strings"
+	}
+	if len(labels) > maxLabels {
+		return fmt.Sprintf("must have at most %d labels", maxLabels)
+	}
+	for key, value := range labels {
+		if key == "" || len(key) > maxLabelKeyLen || !validLabelText(key) {
+			return fmt.Sprintf("key %q must be 1 to %d letters, digits, '-', '_', '.' or '/'", key, maxLabelKeyLen)
+		}
+		if len(value) > maxLabelValueLen || !validLabelText(value) {
+			return fmt.Sprintf("value of %q must be at most %d letters, digits, '-', '_', '.' or '/'", key, maxLabelValueLen)
+		}
+	}
+	return ""
+}
+
+// Limits on the annotations of a single entity. Annotations hold opaque
+// values for integrations, so only their size is limited.
+const (
+	maxAnnotationKeyLen = 128
+	maxAnnotationsBytes = 8192
+)
+
+// checkAnnotations validates an annotations map for the "annotations" rule
+func checkAnnotations(fv reflect.Value) string {
+	annotations, ok := fv.Interface().(map[string]string)
+	if !ok {
+		return "must be a map of strings"
+	}
+	size := 0
+	for key, value := range annotations {
+		if key == "" || len(key) > maxAnnotationKeyLen {
+			return fmt.Sprintf("keys must be 1 to %d bytes long", maxAnnotationKeyLen)
+		}
+		size += len(key) + len(value)
+	}
+	if size > maxAnnotationsBytes {
+		return fmt.Sprintf("must total at most %d bytes of keys and values", maxAnnotationsBytes)
+	}
+	return ""
+}
+
+// labelRequirement is one term of a label selector
+type labelRequirement struct {
+	key   string
+	value string
+	op    string // "=", "!=", "exists" or "!exists"
+}
+
+// labelSelector matches entities whose labels meet every requirement
+type labelSelector []labelRequirement
+
+// parseLabelSelector reads the ?label= parameters of a list request. Each
+// may hold comma separated terms: key=value, key!=value, key (the label is
+// set) or !key (it isn't). All terms must match.
+func parseLabelSelector(query url.Values) (labelSelector, []FieldError) {
+	var selector labelSelector
+	for _, param := range query["label"] {
+		for _, term := range strings.Split(param, ",") {
+			term = strings.TrimSpace(term)
+			var req labelRequirement
+			switch {
+			case strings.Contains(term, "!="):
+				req.key, req.value, _ = strings.Cut(term, "!=")
+				req.op = "!="
+			case strings.Contains(term, "="):
+				req.key, req.value, _ = strings.Cut(term, "=")
+				req.op = "="
+			case strings.HasPrefix(term, "!"):
+				req.key, req.op = term[1:], "!exists"
+			default:
+				req.key, req.op = term, "exists"
+			}
+			if req.key == "" || !validLabelText(req.key) || !validLabelText(req.value) {
+				return nil, []FieldError{{Name: "label", Reason: fmt.Sprintf("invalid selector %q; use key=value, key!=value, key or !key", term)}}
+			}
+			selector = append(selector, req)
+		}
+	}
+	return selector, nil
+}
+
+// matches reports whether labels satisfy the selector
+func (sel labelSelector) matches(labels map[string]string) bool {
+	for _, req := range sel {
+		value, ok := labels[req.key]
+		switch req.op {
+		case "=":
+			if !ok || value != req.value {
+				return false
+			}
+		case "!=":
+			if ok && value == req.value {
+				return false
+			}
+		case "exists":
+			if !ok {
+				return false
+			}
+		case "!exists":
+			if ok {
+				return false
+			}
+		}
+	}
+	return true
+}
@@ -0,0 +1,190 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	hraft "github.com/hashicorp/raft"
+
+	"raft3d/raft"
+	"raft3d/testsupport"
+)
+
+func TestLabelSelector(t *testing.T) {
+	labels := map[string]string{"site": "lab-2", "material": "pla"}
+
+	tests := []struct {
+		name    string
+		query   string
+		want    bool
+		invalid bool
+	}{
+		{"no selector", "", true, false},
+		{"equals", "label=site=lab-2", true, false},
+		{"equals another value", "label=site=lab-1", false, false},
+		{"not equals", "label=site!=lab-1", true, false},
+		{"not equals unset", "label=owner!=bob", true, false},
+		{"exists", "label=material", true, false},
+		{"not exists", "label=!owner", true, false},
+		{"not exists but set", "label=!material", false, false},
+		{"terms in one parameter", "label=site=lab-2,material=pla", true, false},
+		{"terms across parameters", "label=site=lab-2&label=material=abs", false, false},
+		{"empty key", "label==pla", false, true},
+		{"invalid character", "label=site=lab 2", false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			query, err := url.ParseQuery(tt.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			selector, errs := parseLabelSelector(query)
+			if tt.invalid {
+				if len(errs) == 0 {
+					t.Fatalf("parsed invalid selector %q", tt.query)
+				}
+				return
+			}
+			if len(errs) > 0 {
+				t.Fatalf("errors: %v", errs)
+			}
+			if got := selector.matches(labels); got != tt.want {
+				t.Fatalf("matches = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestListPrintersByLabel(t *testing.T) {
+	c := testsupport.NewCluster(t, 1)
+	leader := c.WaitForLeader(10 * time.Second)
+	s := NewServer("", leader.Store)
+
+	post := func(body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		s.handlePostPrinter(w, httptest.NewRequest(http.MethodPost, "/api/v1/printers", strings.NewReader(body)))
+		return w
+	}
+	for _, body := range []string{
+		`{"id":"p1","name":"Prusa","status":"Idle","labels":{"site":"lab-1","material":"pla"}}`,
+		`{"id":"p2","name":"Ender","status":"Idle","labels":{"site":"lab-2","material":"pla"}}`,
+		`{"id":"p3","name":"Voron","status":"Idle"}`,
+	} {
+		if w := post(body); w.Code != http.StatusCreated {
+			t.Fatalf("create printer: %d %s", w.Code, w.Body)
+		}
+	}
+	if w := post(`{"id":"p4","name":"Bambu","status":"Idle","labels":{"site":"lab 1"}}`); w.Code != http.StatusBadRequest {
+		t.Fatalf("create with an invalid label: %d %s", w.Code, w.Body)
+	}
+
+	list := func(query string) (int, []string) {
+		w := httptest.NewRecorder()
+		s.handleGetPrinters(w, httptest.NewRequest(http.MethodGet, "/api/v1/printers?"+query, nil))
+		var printers map[string]Printer
+		json.Unmarshal(w.Body.Bytes(), &printers)
+		var ids []string
+		for id := range printers {
+			ids = append(ids, id)
+		}
+		sort.Strings(ids)
+		return w.Code, ids
+	}
+	tests := []struct {
+		query string
+		want  string
+	}{
+		{"label=material=pla", "p1,p2"},
+		{"label=site!=lab-1", "p2,p3"},
+		{"label=!site", "p3"},
+		{"label=site=lab-2,material=pla", "p2"},
+	}
+	for _, tt := range tests {
+		if code, ids := list(tt.query); code != http.StatusOK || strings.Join(ids, ",") != tt.want {
+			t.Fatalf("%s: %d %v, want %s", tt.query, code, ids, tt.want)
+		}
+	}
+	if code, _ := list("label=site=lab%201"); code != http.StatusBadRequest {
+		t.Fatalf("invalid selector: %d", code)
+	}
+}
+
+func TestAnnotationLimits(t *testing.T) {
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		valid       bool
+	}{
+		{"none", nil, true},
+		{"longest key", map[string]string{strings.Repeat("k", maxAnnotationKeyLen): "v"}, true},
+		{"key too long", map[string]string{strings.Repeat("k", maxAnnotationKeyLen+1): "v"}, false},
+		{"empty key", map[string]string{"": "v"}, false},
+		{"at the size limit", map[string]string{"notes": strings.Repeat("x", maxAnnotationsBytes-len("notes"))}, true},
+		{"over the size limit", map[string]string{"notes": strings.Repeat("x", maxAnnotationsBytes-len("notes")+1)}, false},
+		{"over the limit across keys", map[string]string{"a": strings.Repeat("x", maxAnnotationsBytes/2), "b": strings.Repeat("x", maxAnnotationsBytes/2)}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			errs := Validate(PrinterGroup{ID: "g1", Name: "Farm", Annotations: tt.annotations})
+			if tt.valid && len(errs) > 0 {
+				t.Fatalf("errors: %v", errs)
+			}
+			if !tt.valid && (len(errs) != 1 || errs[0].Name != "annotations") {
+				t.Fatalf("errors %v, want one for annotations", errs)
+			}
+		})
+	}
+}
+
+func TestAnnotationsSurviveSnapshots(t *testing.T) {
+	annotations := map[string]string{"ops.example.com/owner": "lab-2", "notes": "re-leveled\nafter the move"}
+	body, _ := json.Marshal(Printer{ID: "p1", Name: "Prusa", Annotations: annotations})
+	cmd, _ := json.Marshal(raft.Command{Op: "set", Key: "printer_p1", Value: string(body)})
+	fsm := raft.NewFSM()
+	fsm.Apply(&hraft.Log{Index: 1, Term: 1, Type: hraft.LogCommand, Data: cmd})
+
+	snapshot, err := fsm.Snapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := hraft.NewInmemSnapshotStore()
+	_, transport := hraft.NewInmemTransport("")
+	sink, err := store.Create(hraft.SnapshotVersionMax, 1, 1, hraft.Configuration{}, 1, transport)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := snapshot.Persist(sink); err != nil {
+		t.Fatal(err)
+	}
+	_, rc, err := store.Open(sink.ID())
+	if err != nil {
+		t.Fatal(err)
+	}
+	restored := raft.NewFSM()
+	if err := restored.Restore(rc); err != nil {
+		t.Fatal(err)
+	}
+
+	value, err := restored.Get("printer_p1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var printer Printer
+	if err := json.Unmarshal([]byte(value), &printer); err != nil {
+		t.Fatal(err)
+	}
+	if len(printer.Annotations) != len(annotations) {
+		t.Fatalf("restored annotations %v", printer.Annotations)
+	}
+	for key, value := range annotations {
+		if printer.Annotations[key] != value {
+			t.Fatalf("restored annotations %v", printer.Annotations)
+		}
+	}
+}
@@ -0,0 +1,95 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"net/http"
+	"os"
+	"os/user"
+	"strconv"
+	"strings"
+)
+
+// unixScheme prefixes an HTTP address that is a Unix domain socket, as in
+// unix:///var/run/raft3d.sock
+const unixScheme = "unix://"
+
+// SocketPath returns the socket path of a unix:// address
+func SocketPath(addr string) (string, bool) {
+	path, ok := strings.CutPrefix(addr, unixScheme)
+	return path, ok && path != ""
+}
+
+// SetSocketPermissions sets the mode and, when group is a group name or ID,
+// the group of the socket file the server listens on for unix:// addresses
+func (s *Server) SetSocketPermissions(mode fs.FileMode, group string) {
+	s.socketMode, s.socketGroup = mode, group
+}
+
+// listen opens the server's listener: a Unix domain socket for unix://
+// addresses and TCP otherwise
+func (s *Server) listen() (net.Listener, error) {
+	path, ok := SocketPath(s.Addr)
+	if !ok {
+		return net.Listen("tcp", s.Addr)
+	}
+
+	// A socket left behind by an unclean shutdown would make Listen fail
+	if info, err := os.Lstat(path); err == nil && info.Mode()&fs.ModeSocket != 0 {
+		if conn, err := net.Dial("unix", path); err == nil {
+			conn.Close()
+			return nil, fmt.Errorf("%s is in use by another process", path)
+		}
+		os.Remove(path)
+	}
+
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.applySocketPermissions(path); err != nil {
+		listener.Close()
+		return nil, err
+	}
+	return listener, nil
+}
+
+// applySocketPermissions sets the configured mode and group on the socket
+func (s *Server) applySocketPermissions(path string) error {
+	mode := s.socketMode
+	if mode == 0 {
+		mode = 0660
+	}
+	if err := os.Chmod(path, mode); err != nil {
+		return err
+	}
+	if s.socketGroup == "" {
+		return nil
+	}
+
+	gid, err := strconv.Atoi(s.socketGroup)
+	if err != nil {
+		group, err := user.LookupGroup(s.socketGroup)
+		if err != nil {
+			return err
+		}
+		if gid, err = strconv.Atoi(group.Gid); err != nil {
+			return err
+		}
+	}
+	return os.Chown(path, -1, gid)
+}
+
+// viaUnixSocket reports whether a request arrived on a Unix domain socket,
+// whose callers are local processes allowed by the socket's permissions
+func viaUnixSocket(r *http.Request) bool {
+	addr, ok := r.Context().Value(http.LocalAddrContextKey).(net.Addr)
+	return ok && addr.Network() == "unix"
+}
+
+// isClosed reports whether err is the result of the listener shutting down
+func isClosed(err error) bool {
+	return errors.Is(err, http.ErrServerClosed) || errors.Is(err, net.ErrClosed)
+}
@@ -0,0 +1,77 @@
+package api
+
+import (
+	"context"
+	"io"
+	"io/fs"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestUnixSocketListener(t *testing.T) {
+	// t.TempDir paths can exceed the socket path limit
+	dir, err := os.MkdirTemp("", "raft3d")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	path := filepath.Join(dir, "api.sock")
+
+	if got, ok := SocketPath("unix://" + path); !ok || got != path {
+		t.Fatalf("SocketPath = %q %v", got, ok)
+	}
+	for _, addr := range []string{"unix://", ":8080"} {
+		if _, ok := SocketPath(addr); ok {
+			t.Fatalf("%q is a socket path", addr)
+		}
+	}
+
+	// A socket left behind by a crashed server is replaced
+	stale, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	stale.Close()
+
+	s := NewServer("unix://"+path, nil)
+	s.SetSocketPermissions(0600, "")
+	listener, err := s.listen()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	if info, err := os.Stat(path); err != nil || info.Mode().Perm() != 0600 || info.Mode()&fs.ModeSocket == 0 {
+		t.Fatalf("socket file: %v %v", info.Mode(), err)
+	}
+
+	// One still being served isn't
+	if _, err := NewServer("unix://"+path, nil).listen(); err == nil {
+		t.Fatal("listened on a socket in use")
+	}
+
+	server := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if viaUnixSocket(r) {
+			w.Write([]byte("unix"))
+		}
+	})}
+	go server.Serve(listener)
+	defer server.Close()
+
+	client := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, "unix", path)
+		},
+	}}
+	resp, err := client.Get("http://raft3d/api/v1/printers")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if body, _ := io.ReadAll(resp.Body); string(body) != "unix" {
+		t.Fatalf("request over the socket was not seen as local: %q", body)
+	}
+}
@@ -0,0 +1,99 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// maintenanceKeyPrefix prefixes maintenance windows in the store
+const maintenanceKeyPrefix = "maintwindow_"
+
+// handleMaintenanceWindows handles GET/POST /maintenance_windows and
+// GET/DELETE /maintenance_windows/{id}. The list can be filtered by
+// ?printer_id=.
+func (s *Server) handleMaintenanceWindows(w http.ResponseWriter, r *http.Request) {
+	id := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/v1/maintenance_windows"), "/")
+
+	switch {
+	case id == "" && r.Method == http.MethodGet:
+		windows, err := s.listMaintenanceWindows(r.URL.Query().Get("printer_id"))
+		if err != nil {
+			s.writeStoreError(w, r, err, "Failed to retrieve maintenance windows")
+			return
+		}
+		writeList(w, r, windows)
+	case id == "" && r.Method == http.MethodPost:
+		s.handlePostMaintenanceWindow(w, r)
+	case id != "" && r.Method == http.MethodGet:
+		value, err := s.store.Get(maintenanceKeyPrefix + id)
+		if err != nil {
+			s.writeStoreError(w, r, err, "Maintenance window not found")
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(value))
+	case id != "" && r.Method == http.MethodDelete:
+		if _, err := s.store.Get(maintenanceKeyPrefix + id); err != nil {
+			s.writeStoreError(w, r, err, "Maintenance window not found")
+			return
+		}
+		if err := s.storeFor(r).Delete(maintenanceKeyPrefix + id); err != nil {
+			s.writeStoreError(w, r, err, "Failed to delete maintenance window")
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		methodNotAllowed(w, r)
+	}
+}
+
+// handlePostMaintenanceWindow schedules a maintenance window
+func (s *Server) handlePostMaintenanceWindow(w http.ResponseWriter, r *http.Request) {
+	var window MaintenanceWindow
+	if !decodeJSON(w, r, &window) {
+		return
+	}
+	if !window.End.After(window.Start) {
+		writeValidationProblem(w, r, []FieldError{{Name: "end", Reason: "must be after start"}})
+		return
+	}
+	if _, err := s.getPrinter(window.PrinterID); err != nil {
+		writeValidationProblem(w, r, []FieldError{{Name: "printer_id", Reason: "printer does not exist"}})
+		return
+	}
+	window.Start, window.End = window.Start.UTC(), window.End.UTC()
+
+	body, err := json.Marshal(window)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, CodeInternal, "Failed to process maintenance window data")
+		return
+	}
+	stored, err := s.storeNew(r, maintenanceKeyPrefix, window.ID, string(body))
+	if err != nil {
+		s.writeStoreError(w, r, err, "Failed to store maintenance window")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	w.Write([]byte(stored))
+}
+
+// listMaintenanceWindows returns the maintenance windows, of one printer if
+// printerID is set, ordered by start
+func (s *Server) listMaintenanceWindows(printerID string) ([]MaintenanceWindow, error) {
+	all, err := loadAll[MaintenanceWindow](s, maintenanceKeyPrefix)
+	if err != nil {
+		return nil, err
+	}
+	windows := []MaintenanceWindow{}
+	for _, window := range all {
+		if printerID == "" || window.PrinterID == printerID {
+			windows = append(windows, window)
+		}
+	}
+	sort.Slice(windows, func(i, j int) bool { return windows[i].Start.Before(windows[j].Start) })
+	return windows, nil
+}
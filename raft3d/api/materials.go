@@ -0,0 +1,175 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sort"
+	"strings"
+
+	"raft3d/raft"
+)
+
+// materialKeyPrefix prefixes material catalog entries in the store
+const materialKeyPrefix = "material_"
+
+// builtinMaterials are in every catalog. They can be disabled, which stores
+// a copy of the entry, but not removed.
+var builtinMaterials = map[string]MaterialType{
+	"PLA":  {Name: "PLA", NozzleTempC: 210, BedTempC: 60, DensityGPerCm3: 1.24},
+	"PETG": {Name: "PETG", NozzleTempC: 240, BedTempC: 80, DensityGPerCm3: 1.27},
+	"ABS":  {Name: "ABS", NozzleTempC: 250, BedTempC: 100, DensityGPerCm3: 1.04},
+	"TPU":  {Name: "TPU", NozzleTempC: 225, BedTempC: 50, DensityGPerCm3: 1.21, Hygroscopic: true},
+}
+
+// handleMaterials handles GET/POST /materials, GET /materials/{name} and
+// POST /materials/{name}/disable and /enable. GET /materials leaves out
+// disabled materials unless ?include_disabled=true. Changing the catalog
+// requires the admin role.
+func (s *Server) handleMaterials(w http.ResponseWriter, r *http.Request) {
+	path := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/v1/materials"), "/")
+	name, action, _ := strings.Cut(path, "/")
+
+	switch {
+	case path == "" && r.Method == http.MethodGet:
+		catalog, err := s.materialCatalog()
+		if err != nil {
+			s.writeStoreError(w, r, err, "Failed to retrieve materials")
+			return
+		}
+		materials := []MaterialType{}
+		for _, material := range catalog {
+			if material.Disabled && r.URL.Query().Get("include_disabled") != "true" {
+				continue
+			}
+			materials = append(materials, material)
+		}
+		sort.Slice(materials, func(i, j int) bool { return materials[i].Name < materials[j].Name })
+		writeList(w, r, materials)
+	case path == "" && r.Method == http.MethodPost:
+		if s.requireRole(w, r, RoleAdmin) {
+			s.handlePostMaterial(w, r)
+		}
+	case action == "" && r.Method == http.MethodGet:
+		material, err := s.getMaterial(name)
+		if err != nil {
+			s.writeStoreError(w, r, err, "Material not found")
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(material)
+	case (action == "disable" || action == "enable") && r.Method == http.MethodPost:
+		if !s.requireRole(w, r, RoleAdmin) {
+			return
+		}
+		material, err := s.getMaterial(name)
+		if err != nil {
+			s.writeStoreError(w, r, err, "Material not found")
+			return
+		}
+		material.Disabled = action == "disable"
+		material.Builtin = false
+		body, err := json.Marshal(material)
+		if err != nil {
+			writeError(w, r, http.StatusInternalServerError, CodeInternal, "Failed to process material data")
+			return
+		}
+		if err := s.storeFor(r).Set(materialKeyPrefix+material.Name, string(body)); err != nil {
+			s.writeStoreError(w, r, err, "Failed to update material")
+			return
+		}
+		material.Builtin = isBuiltinMaterial(material.Name)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(material)
+	default:
+		methodNotAllowed(w, r)
+	}
+}
+
+// handlePostMaterial adds a custom material to the catalog
+func (s *Server) handlePostMaterial(w http.ResponseWriter, r *http.Request) {
+	var material MaterialType
+	if !decodeJSON(w, r, &material) {
+		return
+	}
+	if strings.ContainsAny(material.Name, "/?# ") {
+		writeValidationProblem(w, r, []FieldError{{Name: "name", Reason: "must not contain spaces, /, ? or #"}})
+		return
+	}
+	if isBuiltinMaterial(material.Name) {
+		writeError(w, r, http.StatusConflict, CodeAlreadyExists, "Material "+material.Name+" is built in")
+		return
+	}
+	material.Builtin = false
+
+	body, err := json.Marshal(material)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, CodeInternal, "Failed to process material data")
+		return
+	}
+	if err := s.storeFor(r).Create(materialKeyPrefix+material.Name, string(body)); err != nil {
+		s.writeStoreError(w, r, err, "Failed to store material")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	w.Write(body)
+}
+
+// isBuiltinMaterial reports whether name is one of the built in materials
+func isBuiltinMaterial(name string) bool {
+	_, ok := builtinMaterials[name]
+	return ok
+}
+
+// materialCatalog returns the built in materials overlaid with the stored
+// ones, keyed by name
+func (s *Server) materialCatalog() (map[string]MaterialType, error) {
+	stored, err := loadAll[MaterialType](s, materialKeyPrefix)
+	if err != nil {
+		return nil, err
+	}
+	catalog := make(map[string]MaterialType, len(builtinMaterials)+len(stored))
+	for name, material := range builtinMaterials {
+		material.Builtin = true
+		catalog[name] = material
+	}
+	for name, material := range stored {
+		material.Builtin = isBuiltinMaterial(name)
+		catalog[name] = material
+	}
+	return catalog, nil
+}
+
+// getMaterial looks a material up in the catalog
+func (s *Server) getMaterial(name string) (MaterialType, error) {
+	value, err := s.store.Get(materialKeyPrefix + name)
+	if errors.Is(err, raft.ErrNotFound) {
+		if material, ok := builtinMaterials[name]; ok {
+			material.Builtin = true
+			return material, nil
+		}
+	}
+	if err != nil {
+		return MaterialType{}, err
+	}
+	var material MaterialType
+	err = json.Unmarshal([]byte(value), &material)
+	material.Builtin = isBuiltinMaterial(name)
+	return material, err
+}
+
+// validateFilamentType checks that a new spool's type is an enabled material
+func (s *Server) validateFilamentType(filamentType string) ([]FieldError, error) {
+	material, err := s.getMaterial(filamentType)
+	switch {
+	case errors.Is(err, raft.ErrNotFound):
+		return []FieldError{{Name: "type", Reason: "must be a material from the catalog"}}, nil
+	case err != nil:
+		return nil, err
+	case material.Disabled:
+		return []FieldError{{Name: "type", Reason: "material " + filamentType + " is disabled"}}, nil
+	}
+	return nil, nil
+}
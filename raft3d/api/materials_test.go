@@ -0,0 +1,101 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"raft3d/testsupport"
+)
+
+func TestMaterialCatalog(t *testing.T) {
+	c := testsupport.NewCluster(t, 1)
+	leader := c.WaitForLeader(10 * time.Second)
+	s := NewServer("", leader.Store)
+
+	materials := func(method, path, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		s.handleMaterials(w, httptest.NewRequest(method, "/api/v1/materials"+path, strings.NewReader(body)))
+		return w
+	}
+	filament := func(id, typ string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		s.handlePostFilament(w, httptest.NewRequest(http.MethodPost, "/api/v1/filaments",
+			strings.NewReader(`{"id":"`+id+`","name":"Spool","type":"`+typ+`","color":"black","total_weight_in_grams":1000,"remaining_weight_in_grams":1000}`)))
+		return w
+	}
+	list := func(query string) map[string]MaterialType {
+		w := materials(http.MethodGet, query, "")
+		var list []MaterialType
+		if err := json.Unmarshal(w.Body.Bytes(), &list); err != nil {
+			t.Fatalf("list: %d %s", w.Code, w.Body)
+		}
+		byName := make(map[string]MaterialType)
+		for _, material := range list {
+			byName[material.Name] = material
+		}
+		return byName
+	}
+
+	// Custom materials are added once, and can't shadow a built in one
+	if w := materials(http.MethodPost, "", `{"name":"Nylon","nozzle_temp_c":260,"bed_temp_c":70,"density_g_per_cm3":1.14}`); w.Code != http.StatusCreated {
+		t.Fatalf("add Nylon: %d %s", w.Code, w.Body)
+	}
+	if w := materials(http.MethodPost, "", `{"name":"Nylon","nozzle_temp_c":250,"bed_temp_c":70,"density_g_per_cm3":1.14}`); w.Code != http.StatusConflict {
+		t.Fatalf("add Nylon again: %d %s", w.Code, w.Body)
+	}
+	if w := materials(http.MethodPost, "", `{"name":"PLA","nozzle_temp_c":200,"bed_temp_c":60,"density_g_per_cm3":1.2}`); w.Code != http.StatusConflict || !strings.Contains(w.Body.String(), CodeAlreadyExists) {
+		t.Fatalf("add PLA: %d %s", w.Code, w.Body)
+	}
+	if w := materials(http.MethodPost, "", `{"name":"PLA Plus","nozzle_temp_c":200,"bed_temp_c":60}`); w.Code != http.StatusBadRequest {
+		t.Fatalf("name with a space: %d %s", w.Code, w.Body)
+	}
+
+	// Spools must be of a catalog material
+	for _, typ := range []string{"PLA", "Nylon"} {
+		if w := filament("f-"+typ, typ); w.Code != http.StatusCreated {
+			t.Fatalf("%s spool: %d %s", typ, w.Code, w.Body)
+		}
+	}
+	if w := filament("f-x", "Unobtainium"); w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "must be a material from the catalog") {
+		t.Fatalf("spool of an unknown material: %d %s", w.Code, w.Body)
+	}
+
+	// Disabling a built in material stores a copy, which hides it from the
+	// list and new spools
+	if w := materials(http.MethodPost, "/ABS/disable", ""); w.Code != http.StatusOK {
+		t.Fatalf("disable ABS: %d %s", w.Code, w.Body)
+	}
+	var stored MaterialType
+	if value, err := leader.Store.Get(materialKeyPrefix + "ABS"); err != nil || json.Unmarshal([]byte(value), &stored) != nil || !stored.Disabled || stored.DensityGPerCm3 != 1.04 {
+		t.Fatalf("stored ABS %s: %v", value, err)
+	}
+	if w := filament("f-abs", "ABS"); w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "disabled") {
+		t.Fatalf("spool of a disabled material: %d %s", w.Code, w.Body)
+	}
+	enabled := list("")
+	if _, ok := enabled["ABS"]; ok || !enabled["PLA"].Builtin || enabled["Nylon"].Builtin || len(enabled) != len(builtinMaterials) {
+		t.Fatalf("enabled materials: %+v", enabled)
+	}
+	all := list("?include_disabled=true")
+	if abs := all["ABS"]; !abs.Disabled || !abs.Builtin || len(all) != len(builtinMaterials)+1 {
+		t.Fatalf("all materials: %+v", all)
+	}
+
+	// and enabling it again restores it
+	if w := materials(http.MethodPost, "/ABS/enable", ""); w.Code != http.StatusOK {
+		t.Fatalf("enable ABS: %d %s", w.Code, w.Body)
+	}
+	if abs := list("")["ABS"]; abs.Disabled || !abs.Builtin {
+		t.Fatalf("ABS after enabling: %+v", abs)
+	}
+	if w := filament("f-abs", "ABS"); w.Code != http.StatusCreated {
+		t.Fatalf("spool of a re-enabled material: %d %s", w.Code, w.Body)
+	}
+	if w := materials(http.MethodPost, "/Unobtainium/disable", ""); w.Code != http.StatusNotFound {
+		t.Fatalf("disable an unknown material: %d %s", w.Code, w.Body)
+	}
+}
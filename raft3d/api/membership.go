@@ -0,0 +1,167 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"raft3d/raft"
+)
+
+// promotionRetryInterval is how often a joining non-voter asks to be promoted
+const promotionRetryInterval = time.Second
+
+// Bounds of the backoff between attempts to join a cluster
+const (
+	joinRetryMin = 250 * time.Millisecond
+	joinRetryMax = 5 * time.Second
+)
+
+// JoinRequest is the body of POST /join
+type JoinRequest struct {
+	NodeID   string `json:"node_id"`
+	RaftAddr string `json:"raft_addr"`
+	HTTPAddr string `json:"http_addr"`
+	NonVoter bool   `json:"non_voter"`
+
+	// ProtocolVersion is the joiner's raft.ProtocolVersion; nodes too old
+	// to send it speak version 1
+	ProtocolVersion int `json:"protocol_version,omitempty"`
+}
+
+// AllowVersionSkew admits joining nodes whose protocol version is
+// incompatible with this node's, logging a warning instead of refusing them
+func (s *Server) AllowVersionSkew(allow bool) {
+	s.allowVersionSkew = allow
+}
+
+// checkJoinVersion refuses a joiner more than one protocol version away from
+// this node, unless version skew is allowed. It reports whether the join
+// may go ahead.
+func (s *Server) checkJoinVersion(w http.ResponseWriter, r *http.Request, req JoinRequest) bool {
+	if raft.CompatibleVersion(req.ProtocolVersion) {
+		return true
+	}
+	version := req.ProtocolVersion
+	if version == 0 {
+		version = 1
+	}
+	message := fmt.Sprintf("Node %s speaks protocol version %d, but this cluster's nodes work with versions %d to %d; upgrade one version at a time",
+		req.NodeID, version, raft.ProtocolVersion-1, raft.ProtocolVersion+1)
+	if s.allowVersionSkew {
+		log.Printf("Admitting incompatible node anyway: %s", message)
+		return true
+	}
+	writeError(w, r, http.StatusConflict, CodeIncompatibleVersion, message)
+	return false
+}
+
+// handleClusterNodes handles POST /cluster/nodes/{id}/promote, which makes a
+// caught-up non-voter a voter. The leader judges how far the node got from
+// its own replication state.
+func (s *Server) handleClusterNodes(w http.ResponseWriter, r *http.Request) {
+	id, ok := strings.CutSuffix(strings.TrimPrefix(r.URL.Path, "/cluster/nodes/"), "/promote")
+	if !ok || id == "" || strings.Contains(id, "/") {
+		writeError(w, r, http.StatusNotFound, CodeNotFound, "Not found")
+		return
+	}
+	if r.Method != http.MethodPost {
+		methodNotAllowed(w, r)
+		return
+	}
+	if !s.requireClusterMember(w, r) {
+		return
+	}
+
+	if err := s.store.Promote(id); err != nil {
+		if errors.Is(err, raft.ErrNotCaughtUp) {
+			writeError(w, r, http.StatusConflict, CodeNotCaughtUp, err.Error())
+			return
+		}
+		s.writeStoreError(w, r, err, "Node not found")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"id": id, "suffrage": raft.SuffrageVoter})
+}
+
+// EnableJoinKey has this node present an admin API key when it joins a
+// cluster that requires authentication and asks to be promoted
+func (s *Server) EnableJoinKey(key string) {
+	s.joinAPIKey = key
+}
+
+// postMembership posts a membership request to another node with this node's
+// join key, if it has one. Over TLS the client also offers our certificate.
+func (s *Server) postMembership(client *http.Client, url string, body []byte) (*http.Response, error) {
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if s.joinAPIKey != "" {
+		req.Header.Set("X-API-Key", s.joinAPIKey)
+	}
+	return client.Do(req)
+}
+
+// requestJoin sends one join request. It reports whether a failure may pass:
+// the target couldn't be reached, has no leader yet or is overloaded, rather
+// than refusing the request.
+func (s *Server) requestJoin(client *http.Client, url string, body []byte) (bool, error) {
+	resp, err := s.postMembership(client, url, body)
+	if err != nil {
+		return true, fmt.Errorf("failed to send join request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusOK:
+		return false, nil
+	case resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests:
+		return true, fmt.Errorf("join request failed: %s", resp.Status)
+	}
+	return false, fmt.Errorf("join request failed: %s", resp.Status)
+}
+
+// promoteWhenCaughtUp asks the leader to promote this node, which joined as
+// a non-voter, until it has applied enough of the log to be accepted
+func (s *Server) promoteWhenCaughtUp(joinAddr, nodeID string, client *http.Client) {
+	ticker := time.NewTicker(promotionRetryInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.stopCh:
+			return
+		case <-ticker.C:
+		}
+
+		target := joinAddr
+		if leader := s.store.LeaderInfo(); raft.ValidateAddr(leader.HTTPAddr) == nil {
+			target = leader.HTTPAddr
+		}
+		url := fmt.Sprintf("%s://%s/cluster/nodes/%s/promote", s.scheme(), target, nodeID)
+		resp, err := s.postMembership(client, url, nil)
+		if err != nil {
+			log.Printf("Failed to request promotion: %s", err)
+			continue
+		}
+		resp.Body.Close()
+
+		switch resp.StatusCode {
+		case http.StatusOK:
+			log.Printf("Promoted to voter by %s", target)
+			return
+		case http.StatusConflict, http.StatusServiceUnavailable:
+			// Still catching up, or no leader to ask right now
+		default:
+			log.Printf("Promotion request failed: %s", resp.Status)
+		}
+	}
+}
@@ -0,0 +1,278 @@
+package api
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	hraft "github.com/hashicorp/raft"
+
+	"raft3d/raft"
+	"raft3d/testsupport"
+)
+
+func TestJoinAcceptsIPv6Addresses(t *testing.T) {
+	c := testsupport.NewCluster(t, 1)
+	leader := c.WaitForLeader(10 * time.Second)
+	s := NewServer("127.0.0.1:8001", leader.Store)
+
+	tests := []struct {
+		name   string
+		req    JoinRequest
+		status int
+	}{
+		{"bracketed IPv6", JoinRequest{NodeID: "v6", RaftAddr: "[::1]:9002", HTTPAddr: "[::1]:8002", NonVoter: true}, http.StatusOK},
+		{"IPv4 beside IPv6", JoinRequest{NodeID: "v4", RaftAddr: "127.0.0.1:9003", HTTPAddr: "127.0.0.1:8003", NonVoter: true}, http.StatusOK},
+		{"global IPv6", JoinRequest{NodeID: "g6", RaftAddr: "[2001:db8::3]:9004", HTTPAddr: "[2001:db8::3]:8004", NonVoter: true}, http.StatusOK},
+		{"unbracketed raft address", JoinRequest{NodeID: "bad1", RaftAddr: "::1:9005", NonVoter: true}, http.StatusBadRequest},
+		{"unbracketed HTTP address", JoinRequest{NodeID: "bad2", RaftAddr: "[::1]:9006", HTTPAddr: "::1:8006", NonVoter: true}, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body, _ := json.Marshal(tt.req)
+			w := httptest.NewRecorder()
+			s.handleJoin(w, httptest.NewRequest(http.MethodPost, "/join", strings.NewReader(string(body))))
+			if w.Code != tt.status {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.status, w.Body)
+			}
+		})
+	}
+
+	// Every accepted member is recorded with the addresses it joined with
+	keys, err := leader.Store.List("node_")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{"v6": "[::1]:9002", "v4": "127.0.0.1:9003", "g6": "[2001:db8::3]:9004"}
+	for _, key := range keys {
+		var node raft.NodeInfo
+		value, _ := leader.Store.Get(key)
+		json.Unmarshal([]byte(value), &node)
+		if addr, ok := want[node.ID]; ok {
+			if node.RaftAddr != addr || raft.ValidateAddr(node.HTTPAddr) != nil {
+				t.Errorf("%s recorded as %+v", node.ID, node)
+			}
+			delete(want, node.ID)
+		}
+	}
+	if len(want) > 0 {
+		t.Errorf("members missing from the node records: %v", want)
+	}
+}
+
+// failingJoinStore fails membership changes with an internal error
+type failingJoinStore struct {
+	raft.Store
+}
+
+func (failingJoinStore) Join(nodeID, raftAddr, httpAddr string, voter bool) error {
+	return errors.New("raft: add voter via 10.0.0.7:9001: connection refused")
+}
+
+func TestJoinHidesInternalErrors(t *testing.T) {
+	c := testsupport.NewCluster(t, 1)
+	leader := c.WaitForLeader(10 * time.Second)
+	s := NewServer("", failingJoinStore{leader.Store})
+
+	body, _ := json.Marshal(JoinRequest{NodeID: "n1", RaftAddr: "127.0.0.1:9102", NonVoter: true})
+	w := httptest.NewRecorder()
+	s.handleJoin(w, httptest.NewRequest(http.MethodPost, "/join", strings.NewReader(string(body))))
+	if w.Code != http.StatusInternalServerError {
+		t.Fatalf("status = %d, want 500", w.Code)
+	}
+	if strings.Contains(w.Body.String(), "10.0.0.7") {
+		t.Fatalf("response leaks the internal error: %s", w.Body)
+	}
+}
+
+// fakeTLS stands in for the node's certificates; requests are built with
+// their TLS state already set
+type fakeTLS struct{}
+
+func (fakeTLS) ServerConfig(tls.ClientAuthType) *tls.Config { return &tls.Config{} }
+func (fakeTLS) ClientConfig(string) *tls.Config             { return &tls.Config{} }
+
+func TestMembershipRequiresCredentials(t *testing.T) {
+	c := testsupport.NewCluster(t, 1)
+	leader := c.WaitForLeader(10 * time.Second)
+
+	join := func(s *Server, nodeID string, prepare func(r *http.Request)) int {
+		body, _ := json.Marshal(JoinRequest{NodeID: nodeID, RaftAddr: "127.0.0.1:9102", NonVoter: true})
+		r := httptest.NewRequest(http.MethodPost, "/join", strings.NewReader(string(body)))
+		prepare(r)
+		w := httptest.NewRecorder()
+		s.handleJoin(w, r)
+		return w.Code
+	}
+	promote := func(s *Server, prepare func(r *http.Request)) int {
+		r := httptest.NewRequest(http.MethodPost, "/cluster/nodes/n9/promote", strings.NewReader(`{"applied_index":1}`))
+		prepare(r)
+		w := httptest.NewRecorder()
+		s.handleClusterNodes(w, r)
+		return w.Code
+	}
+	withKey := func(key string) func(r *http.Request) {
+		return func(r *http.Request) { r.Header.Set("X-API-Key", key) }
+	}
+	withCert := func(r *http.Request) {
+		r.TLS = &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{{}}}}
+	}
+
+	t.Run("api keys", func(t *testing.T) {
+		s := NewServer("", leader.Store)
+		s.EnableAuth([]APIKey{
+			{Key: "admin-key", Principal: Principal{Name: "ops", Role: RoleAdmin}},
+			{Key: "member-key", Principal: Principal{Name: "alice", Role: RoleMember}},
+		})
+		for name, prepare := range map[string]func(r *http.Request){
+			"no key":     func(*http.Request) {},
+			"member key": withKey("member-key"),
+			"wrong key":  withKey("nope"),
+		} {
+			if code := join(s, "n1", prepare); code != http.StatusUnauthorized {
+				t.Errorf("join with %s got %d, want 401", name, code)
+			}
+			if code := promote(s, prepare); code != http.StatusUnauthorized {
+				t.Errorf("promote with %s got %d, want 401", name, code)
+			}
+		}
+		if code := join(s, "n1", withKey("admin-key")); code != http.StatusOK {
+			t.Errorf("join with the admin key got %d, want 200", code)
+		}
+	})
+
+	t.Run("tls", func(t *testing.T) {
+		s := NewServer("", leader.Store)
+		s.EnableTLS(fakeTLS{})
+		if code := join(s, "n2", func(*http.Request) {}); code != http.StatusUnauthorized {
+			t.Errorf("join without a client certificate got %d, want 401", code)
+		}
+		if code := promote(s, func(*http.Request) {}); code != http.StatusUnauthorized {
+			t.Errorf("promote without a client certificate got %d, want 401", code)
+		}
+		if code := join(s, "n2", withCert); code != http.StatusOK {
+			t.Errorf("join with a client certificate got %d, want 200", code)
+		}
+	})
+}
+
+func TestPromotionUsesLeaderReplicationState(t *testing.T) {
+	c := testsupport.NewCluster(t, 1)
+	leader := c.WaitForLeader(10 * time.Second)
+	s := NewServer("", leader.Store)
+	for i := 0; i < 10; i++ {
+		leader.Store.Set(fmt.Sprintf("printer_p%d", i), `{}`)
+	}
+
+	promote := func(id string) *httptest.ResponseRecorder {
+		// Whatever the node claims about itself is ignored
+		r := httptest.NewRequest(http.MethodPost, "/cluster/nodes/"+id+"/promote", strings.NewReader(`{"applied_index":1000000}`))
+		w := httptest.NewRecorder()
+		s.handleClusterNodes(w, r)
+		return w
+	}
+
+	// A non-voter the leader never reached is not promoted
+	if err := leader.Store.Join("ghost", "ghost-addr", "", false); err != nil {
+		t.Fatal(err)
+	}
+	if w := promote("ghost"); w.Code != http.StatusConflict || !strings.Contains(w.Body.String(), CodeNotCaughtUp) {
+		t.Fatalf("promoting an unreached node got %d: %s", w.Code, w.Body)
+	}
+
+	// One that replicated the log is
+	addr, transport := hraft.NewInmemTransport("")
+	leaderNode := c.Nodes[0]
+	leaderNode.Transport.Connect(addr, transport)
+	transport.Connect(leaderNode.Addr, leaderNode.Transport)
+	store, err := raft.NewRaftStore(raft.StoreConfig{NodeID: "n2", RaftAddr: string(addr), Transport: transport, InMemory: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { store.Close() })
+	if err := leader.Store.Join("n2", string(addr), "", false); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(10 * time.Second)
+	for w := promote("n2"); w.Code != http.StatusOK; w = promote("n2") {
+		if w.Code != http.StatusConflict || time.Now().After(deadline) {
+			t.Fatalf("promoting a replicated node got %d: %s", w.Code, w.Body)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+func TestJoinClusterRetriesUntilAcknowledged(t *testing.T) {
+	c := testsupport.NewCluster(t, 1)
+	s := NewServer("127.0.0.1:8001", c.WaitForLeader(10*time.Second).Store)
+	defer s.Stop()
+
+	// The target has no leader for its first two answers
+	var attempts atomic.Int32
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if attempts.Add(1) < 3 {
+			writeError(w, r, http.StatusServiceUnavailable, CodeNotLeader, "No leader elected")
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer target.Close()
+	addr := strings.TrimPrefix(target.URL, "http://")
+	if err := s.JoinCluster(context.Background(), addr, "node2", "127.0.0.1:9002"); err != nil {
+		t.Fatal(err)
+	}
+	if n := attempts.Load(); n != 3 {
+		t.Fatalf("attempts = %d, want 3", n)
+	}
+
+	// A refusal isn't retried, and unreachable targets are given up on
+	refused := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeError(w, r, http.StatusForbidden, CodeForbidden, "Not allowed")
+	}))
+	defer refused.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := s.JoinCluster(ctx, strings.TrimPrefix(refused.URL, "http://"), "node2", "127.0.0.1:9002"); err == nil || !strings.Contains(err.Error(), "403") {
+		t.Fatalf("err = %v", err)
+	}
+	refused.Close()
+	ctx, cancel = context.WithTimeout(context.Background(), 600*time.Millisecond)
+	defer cancel()
+	if err := s.JoinCluster(ctx, strings.TrimPrefix(refused.URL, "http://"), "node2", "127.0.0.1:9002"); err == nil || !strings.Contains(err.Error(), "gave up") {
+		t.Fatalf("err = %v", err)
+	}
+}
+
+func TestJoinRefusesIncompatibleVersions(t *testing.T) {
+	c := testsupport.NewCluster(t, 1)
+	s := NewServer("127.0.0.1:8001", c.WaitForLeader(10*time.Second).Store)
+	port := 9002
+	join := func(id string, version int) *httptest.ResponseRecorder {
+		port++
+		body, _ := json.Marshal(JoinRequest{NodeID: id, RaftAddr: fmt.Sprintf("127.0.0.1:%d", port), NonVoter: true, ProtocolVersion: version})
+		w := httptest.NewRecorder()
+		s.handleJoin(w, httptest.NewRequest(http.MethodPost, "/join", strings.NewReader(string(body))))
+		return w
+	}
+
+	if w := join("future", raft.ProtocolVersion+2); w.Code != http.StatusConflict || !strings.Contains(w.Body.String(), CodeIncompatibleVersion) {
+		t.Fatalf("status = %d: %s", w.Code, w.Body)
+	}
+	if w := join("next", raft.ProtocolVersion+1); w.Code != http.StatusOK {
+		t.Fatalf("one version newer: status = %d: %s", w.Code, w.Body)
+	}
+
+	s.AllowVersionSkew(true)
+	if w := join("future", raft.ProtocolVersion+2); w.Code != http.StatusOK {
+		t.Fatalf("with skew allowed: status = %d: %s", w.Code, w.Body)
+	}
+}
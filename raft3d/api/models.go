@@ -1,65 +1,640 @@
 package api
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
+	"math"
+	"time"
+
+	"raft3d/drivers"
 )
 
 // Printer represents a 3D printer in the system
 type Printer struct {
 	ID          string `json:"id"`
-	Name        string `json:"name"`
+	Name        string `json:"name" validate:"required"`
 	Model       string `json:"model"`
 	Status      string `json:"status"`
 	Temperature int    `json:"temperature"`
 	Material    string `json:"material"`
+	GroupID     string `json:"group_id,omitempty"`
+
+	// FirmwareVersion is what the printer last reported running, or what a
+	// firmware campaign marked it updated to
+	FirmwareVersion string `json:"firmware_version,omitempty"`
+
+	Labels      map[string]string `json:"labels,omitempty" validate:"omitempty,labels"`
+	Annotations map[string]string `json:"annotations,omitempty" validate:"omitempty,annotations"`
+
+	// Set by the heartbeat monitor while the printer is Offline
+	OfflineSince        *time.Time `json:"offline_since,omitempty"`
+	StatusBeforeOffline string     `json:"status_before_offline,omitempty"`
+
+	// Driver, when set, has the named node run the printer's jobs
+	Driver *PrinterDriverConfig `json:"driver,omitempty"`
+
+	// Latest telemetry held by this node, filled in when read, never stored
+	Telemetry *TelemetrySample `json:"telemetry,omitempty"`
+}
+
+// PrinterDriverConfig says which node drives a printer and how it reaches
+// it. Its api_key_ref and ca_file are read on that node.
+type PrinterDriverConfig struct {
+	Node string `json:"node"`
+	drivers.Config
+}
+
+// validate checks the config names a node and a usable driver
+func (c *PrinterDriverConfig) validate() []FieldError {
+	var errs []FieldError
+	if c.Node == "" {
+		errs = append(errs, FieldError{Name: "driver.node", Reason: "is required"})
+	}
+	if err := c.Config.Validate(); err != nil {
+		errs = append(errs, FieldError{Name: "driver", Reason: err.Error()})
+	}
+	return errs
+}
+
+// PrinterGroup is a set of printers, such as a farm or a lab, that jobs can
+// target instead of a specific printer
+type PrinterGroup struct {
+	ID          string            `json:"id" validate:"required"`
+	Name        string            `json:"name" validate:"required"`
+	Description string            `json:"description,omitempty"`
+	Annotations map[string]string `json:"annotations,omitempty" validate:"omitempty,annotations"`
+
+	// PrinterIDs lists the members when read; membership is set on printers
+	PrinterIDs []string `json:"printer_ids,omitempty"`
+
+	// Revision is bumped by the server whenever a printer joins the group,
+	// so a deletion checked against the old membership conflicts
+	Revision int `json:"revision"`
 }
 
 // Filament represents a filament roll used for 3D printing
 type Filament struct {
-	ID                      string `json:"id"`
-	Name                    string `json:"name"`
-	Type                    string `json:"type"` // PLA, PETG, ABS or TPU
-	Color                   string `json:"color"`
-	TotalWeightInGrams      int    `json:"total_weight_in_grams"`
-	RemainingWeightInGrams  int    `json:"remaining_weight_in_grams"`
+	ID                     string        `json:"id"`
+	Name                   string        `json:"name" validate:"required"`
+	Type                   string        `json:"type" validate:"required"` // a name from the material catalog
+	Color                  FilamentColor `json:"color"`
+	TotalWeightInGrams     float64       `json:"total_weight_in_grams" validate:"gte=0"`
+	RemainingWeightInGrams float64       `json:"remaining_weight_in_grams" validate:"gte=0"`
+	CostPerKg              float64       `json:"cost_per_kg,omitempty" validate:"gte=0"`
+
+	// Used to convert lengths and volumes to grams; density defaults to the
+	// material's and diameter to 1.75mm
+	DensityGPerCm3 float64 `json:"density_g_per_cm3,omitempty" validate:"gte=0"`
+	DiameterMM     float64 `json:"diameter_mm,omitempty" validate:"gte=0"`
+
+	Labels      map[string]string `json:"labels,omitempty" validate:"omitempty,labels"`
+	Annotations map[string]string `json:"annotations,omitempty" validate:"omitempty,annotations"`
+
+	// Hygroscopic marks humidity sensitive spools; some types always are
+	Hygroscopic bool       `json:"hygroscopic,omitempty"`
+	LastDriedAt *time.Time `json:"last_dried_at,omitempty"`
+
+	// Purchasing details. Without a cost_per_kg, one is worked out from
+	// the purchase price and total weight.
+	VendorID      string     `json:"vendor_id,omitempty"`
+	SKU           string     `json:"sku,omitempty"`
+	LotNumber     string     `json:"lot_number,omitempty"`
+	PurchaseDate  *time.Time `json:"purchase_date,omitempty"`
+	PurchasePrice float64    `json:"purchase_price,omitempty" validate:"gte=0"`
+}
+
+// Vendor is a supplier filament is bought from
+type Vendor struct {
+	ID           string `json:"id"`
+	Name         string `json:"name" validate:"required"`
+	Website      string `json:"website,omitempty"`
+	Email        string `json:"email,omitempty"`
+	Phone        string `json:"phone,omitempty"`
+	AccountRef   string `json:"account_ref,omitempty"`                     // the lab's customer number with the vendor
+	LeadTimeDays int    `json:"lead_time_days,omitempty" validate:"gte=0"` // typical days from order to delivery
+
+	Annotations map[string]string `json:"annotations,omitempty" validate:"omitempty,annotations"`
 }
 
 // PrintJob represents a job to print an item
 type PrintJob struct {
-	ID                string `json:"id"`
-	PrinterID         string `json:"printer_id"`
-	FilamentID        string `json:"filament_id"`
-	FilePath          string `json:"filepath"`
-	PrintWeightInGrams int   `json:"print_weight_in_grams"`
-	Status            string `json:"status"` // Queued, Running, Done, Canceled
-}
-
-// ValidateFilamentType checks if the provided filament type is valid
-func ValidateFilamentType(filamentType string) bool {
-	validTypes := []string{"PLA", "PETG", "ABS", "TPU"}
-	for _, t := range validTypes {
-		if t == filamentType {
-			return true
-		}
-	}
-	return false
+	ID                 string  `json:"id"`
+	PrinterID          string  `json:"printer_id"`
+	PrinterGroupID     string  `json:"printer_group_id,omitempty"`
+	FilamentID         string  `json:"filament_id" validate:"required"`
+	FilePath           string  `json:"filepath" validate:"required"`
+	PrintWeightInGrams float64 `json:"print_weight_in_grams" validate:"gte=0"`
+	Status             string  `json:"status" validate:"omitempty,oneof=PendingApproval Queued Running Done Failed Canceled"`
+
+	// Alternatives to the weight, converted with the filament's density and
+	// diameter. All three are filled in when the job is accepted.
+	PrintLengthInMeters float64 `json:"print_length_in_meters,omitempty" validate:"gte=0"`
+	PrintVolumeInCm3    float64 `json:"print_volume_in_cm3,omitempty" validate:"gte=0"`
+
+	// DependsOn lists jobs that must be Done before this one can start
+	DependsOn []string `json:"depends_on,omitempty"`
+
+	// DueBy is an optional deadline the scheduler takes into account
+	DueBy *time.Time `json:"due_by,omitempty"`
+
+	// TemplateID is set on jobs created from a job template
+	TemplateID string `json:"template_id,omitempty"`
+
+	// ReprintOf is set on jobs queued by a failed inspection of another
+	ReprintOf string `json:"reprint_of,omitempty"`
+
+	// Steps are the stages the job goes through in order, one of them the
+	// print itself. Jobs without steps are just printed.
+	Steps []JobStep `json:"steps,omitempty"`
+
+	Labels      map[string]string `json:"labels,omitempty" validate:"omitempty,labels"`
+	Annotations map[string]string `json:"annotations,omitempty" validate:"omitempty,annotations"`
+
+	// IgnoreDrying accepts the job even if its filament is due for drying
+	IgnoreDrying bool `json:"ignore_drying,omitempty"`
+
+	// Set by the server
+	SubmittedBy      string     `json:"submitted_by,omitempty"`
+	DryingOverridden bool       `json:"drying_overridden,omitempty"`
+	DuplicateOf      string     `json:"duplicate_of,omitempty"` // a queued job this one repeats
+	CreatedAt        time.Time  `json:"created_at"`
+	DeadlineMissed   bool       `json:"deadline_missed,omitempty"`
+	StartedAt        *time.Time `json:"started_at,omitempty"`
+
+	// Set on jobs over the approval policy's limits
+	ApprovalReason string     `json:"approval_reason,omitempty"`
+	ApprovedBy     string     `json:"approved_by,omitempty"`
+	ApprovedAt     *time.Time `json:"approved_at,omitempty"`
+
+	// Computed for active jobs when read, never stored
+	EstimatedStart      *time.Time `json:"estimated_start,omitempty"`
+	EstimatedCompletion *time.Time `json:"estimated_completion,omitempty"`
+
+	// Set when the job completes
+	MaterialCost float64    `json:"material_cost,omitempty"`
+	CompletedAt  *time.Time `json:"completed_at,omitempty"`
+}
+
+// JobArtifact describes a file attached to a finished print job, such as a
+// photo of the part or the printer's log. Its content is kept in the
+// artifact store; only this metadata is replicated.
+type JobArtifact struct {
+	ID          string    `json:"id"`
+	JobID       string    `json:"job_id"`
+	Name        string    `json:"name"`
+	Kind        string    `json:"kind"`
+	ContentType string    `json:"content_type"`
+	Size        int64     `json:"size"`
+	SHA256      string    `json:"sha256"`
+	UploadedBy  string    `json:"uploaded_by,omitempty"`
+	UploadedAt  time.Time `json:"uploaded_at"`
+}
+
+// Task is a long-running operation, such as a slice, a backup, an archive run
+// or an import. It is replicated so any node can report on it, and the
+// leader runs Pending tasks and records the outcome as Succeeded or Failed.
+type Task struct {
+	ID     string          `json:"id"`
+	Kind   string          `json:"kind"`
+	Input  json.RawMessage `json:"input,omitempty"`
+	Status string          `json:"status"`
+
+	// Set by the server
+	Attempt     int             `json:"attempt"`
+	Error       string          `json:"error,omitempty"`
+	Result      json.RawMessage `json:"result,omitempty"`
+	SubmittedBy string          `json:"submitted_by,omitempty"`
+	CreatedAt   time.Time       `json:"created_at"`
+	StartedAt   *time.Time      `json:"started_at,omitempty"`
+	CompletedAt *time.Time      `json:"completed_at,omitempty"`
+}
+
+// SliceInput is the input of a slice task: an uploaded model and how to
+// slice it
+type SliceInput struct {
+	ModelName   string `json:"model_name"`
+	ModelSHA256 string `json:"model_sha256"`
+	Profile     string `json:"profile,omitempty"`
+	FilamentID  string `json:"filament_id,omitempty"`
+}
+
+// SliceResult is the G-code a slice produced and the slicer's estimates,
+// which can be used as is for a print job
+type SliceResult struct {
+	GCodeSHA256         string  `json:"gcode_sha256"`
+	GCodeSize           int64   `json:"gcode_size"`
+	PrintWeightInGrams  float64 `json:"print_weight_in_grams,omitempty"`
+	PrintLengthInMeters float64 `json:"print_length_in_meters,omitempty"`
+	PrintSeconds        int64   `json:"print_seconds,omitempty"`
+}
+
+// ImportInput is the input of an import task. Filaments need IDs, so an
+// interrupted import can be run again without creating any twice.
+type ImportInput struct {
+	Filaments []Filament `json:"filaments"`
+}
+
+// SQLQuery is a read-only statement run against a node's SQL projection
+type SQLQuery struct {
+	SQL   string        `json:"sql" validate:"required"`
+	Args  []interface{} `json:"args,omitempty"`                   // bound to ? placeholders
+	Limit int           `json:"limit,omitempty" validate:"gte=0"` // rows returned, 1000 by default and at most 10000
+}
+
+// ImportResult is the result of an import task
+type ImportResult struct {
+	Created int             `json:"created"`
+	Skipped int             `json:"skipped"` // already existed
+	Failed  []ImportFailure `json:"failed"`
+}
+
+// ImportFailure is an entity an import could not create
+type ImportFailure struct {
+	Index int    `json:"index"`
+	ID    string `json:"id,omitempty"`
+	Error string `json:"error"`
+}
+
+// JobTemplate describes a standard part that can be enqueued on demand or
+// automatically on a cron schedule
+type JobTemplate struct {
+	ID                 string  `json:"id" validate:"required"`
+	Name               string  `json:"name"`
+	PrinterID          string  `json:"printer_id"`
+	PrinterGroupID     string  `json:"printer_group_id,omitempty"`
+	FilamentID         string  `json:"filament_id" validate:"required"`
+	FilePath           string  `json:"filepath" validate:"required"`
+	PrintWeightInGrams float64 `json:"print_weight_in_grams" validate:"gt=0"`
+
+	// Schedule is an optional five-field cron expression evaluated in
+	// Timezone (UTC by default)
+	Schedule string `json:"schedule,omitempty"`
+	Timezone string `json:"timezone,omitempty"`
+
+	Annotations map[string]string `json:"annotations,omitempty" validate:"omitempty,annotations"`
+
+	// Set by the server
+	SubmittedBy     string     `json:"submitted_by,omitempty"`
+	CreatedAt       time.Time  `json:"created_at"`
+	LastScheduledAt *time.Time `json:"last_scheduled_at,omitempty"`
+}
+
+// FilamentUsage records the filament consumed by a completed print job
+type FilamentUsage struct {
+	PrintJobID    string    `json:"print_job_id"`
+	FilamentID    string    `json:"filament_id"`
+	PrinterID     string    `json:"printer_id"`
+	SubmittedBy   string    `json:"submitted_by,omitempty"`
+	FilamentType  string    `json:"filament_type"`
+	WeightInGrams float64   `json:"weight_in_grams"`
+	Cost          float64   `json:"cost"`
+	RecordedAt    time.Time `json:"recorded_at"`
+}
+
+// ApprovalPolicy holds jobs that would use more filament, or cost more, than
+// its limits in PendingApproval until an approver approves them. Zero
+// limits are off.
+type ApprovalPolicy struct {
+	MaxWeightInGrams float64 `json:"max_weight_in_grams" validate:"gte=0"`
+	MaxCost          float64 `json:"max_cost" validate:"gte=0"`
+}
+
+// RetentionPolicy controls when finished print jobs are archived and removed.
+// ArchiveAfterDays of zero disables it.
+type RetentionPolicy struct {
+	ArchiveAfterDays int      `json:"archive_after_days" validate:"gte=0"`
+	Statuses         []string `json:"statuses,omitempty"`
+
+	// Set by the server
+	LastRunAt *time.Time `json:"last_run_at,omitempty"`
+}
+
+// RetentionResult reports what a retention run archived
+type RetentionResult struct {
+	Archived int       `json:"archived"`
+	Archive  string    `json:"archive,omitempty"`
+	RanAt    time.Time `json:"ran_at"`
+}
+
+// ReloadResult reports what a configuration reload changed
+type ReloadResult struct {
+	Applied         map[string]string `json:"applied"`
+	RestartRequired []string          `json:"restart_required"`
+	ReloadedAt      time.Time         `json:"reloaded_at"`
+}
+
+// CostSummary totals the material spend of a set of completed jobs
+type CostSummary struct {
+	Jobs          int     `json:"jobs"`
+	WeightInGrams float64 `json:"weight_in_grams"`
+	Cost          float64 `json:"cost"`
+}
+
+// CostReport aggregates material spend over a time range
+type CostReport struct {
+	From           *time.Time             `json:"from,omitempty"`
+	To             *time.Time             `json:"to,omitempty"`
+	Period         string                 `json:"period"`
+	Total          CostSummary            `json:"total"`
+	ByPrinter      map[string]CostSummary `json:"by_printer"`
+	ByFilamentType map[string]CostSummary `json:"by_filament_type"`
+	ByPeriod       map[string]CostSummary `json:"by_period"`
+}
+
+// DefectSummary counts inspections and how many failed
+type DefectSummary struct {
+	Inspected  int     `json:"inspected"`
+	Failed     int     `json:"failed"`
+	DefectRate float64 `json:"defect_rate"` // failed over inspected
+}
+
+// DefectReport aggregates inspection results over a time range
+type DefectReport struct {
+	From          *time.Time               `json:"from,omitempty"`
+	To            *time.Time               `json:"to,omitempty"`
+	Total         DefectSummary            `json:"total"`
+	ByPrinter     map[string]DefectSummary `json:"by_printer"`
+	ByFilamentLot map[string]DefectSummary `json:"by_filament_lot"`
+	ByDefectCode  map[string]int           `json:"by_defect_code"`
+}
+
+// MaintenanceWindow is a period a printer is unavailable for printing
+type MaintenanceWindow struct {
+	ID        string    `json:"id"`
+	PrinterID string    `json:"printer_id" validate:"required"`
+	Start     time.Time `json:"start" validate:"required"`
+	End       time.Time `json:"end" validate:"required"`
+	Reason    string    `json:"reason,omitempty"`
+
+	Annotations map[string]string `json:"annotations,omitempty" validate:"omitempty,annotations"`
+}
+
+// Reservation books a printer for a time slot. Only jobs submitted by the
+// holder start on the printer during the slot.
+type Reservation struct {
+	ID        string    `json:"id"`
+	PrinterID string    `json:"printer_id" validate:"required"`
+	Start     time.Time `json:"start" validate:"required"`
+	End       time.Time `json:"end" validate:"required"`
+	Purpose   string    `json:"purpose,omitempty"`
+
+	Annotations map[string]string `json:"annotations,omitempty" validate:"omitempty,annotations"`
+
+	// Set by the server; the caller's name when auth is enabled
+	ReservedBy string    `json:"reserved_by,omitempty"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// CalendarEntry is one item in a printer's calendar
+type CalendarEntry struct {
+	Type      string    `json:"type"` // reservation, maintenance or print_job
+	ID        string    `json:"id"`
+	Start     time.Time `json:"start"`
+	End       time.Time `json:"end"`
+	Title     string    `json:"title"`
+	Status    string    `json:"status,omitempty"`    // print jobs only
+	Estimated bool      `json:"estimated,omitempty"` // whether the times are a projection
+	Owner     string    `json:"owner,omitempty"`
+}
+
+// PrinterCalendar is a printer's reservations, maintenance windows and
+// print jobs over a time range, ordered by start
+type PrinterCalendar struct {
+	PrinterID string          `json:"printer_id"`
+	From      time.Time       `json:"from"`
+	To        time.Time       `json:"to"`
+	Entries   []CalendarEntry `json:"entries"`
+}
+
+// UtilizationReport compares how long printers printed with how long they
+// were available over a time range
+type UtilizationReport struct {
+	From      time.Time                     `json:"from"`
+	To        time.Time                     `json:"to"`
+	Total     PrinterUtilization            `json:"total"`
+	ByPrinter map[string]PrinterUtilization `json:"by_printer"`
+}
+
+// PrinterUtilization is the utilization of one printer, or of all of them
+type PrinterUtilization struct {
+	Jobs             int     `json:"jobs"`
+	BusyHours        float64 `json:"busy_hours"`
+	MaintenanceHours float64 `json:"maintenance_hours"`
+	AvailableHours   float64 `json:"available_hours"`
+	UtilizationPct   float64 `json:"utilization_pct"`
+}
+
+// FilamentForecast estimates when a filament roll will run out
+type FilamentForecast struct {
+	FilamentID             string     `json:"filament_id"`
+	RemainingWeightInGrams float64    `json:"remaining_weight_in_grams"`
+	WindowDays             int        `json:"window_days"`
+	UsedInWindowGrams      float64    `json:"used_in_window_grams"`
+	GramsPerDay            float64    `json:"grams_per_day"`
+	DaysUntilDepletion     *float64   `json:"days_until_depletion"`
+	DepletionDate          *time.Time `json:"depletion_date"`
+	ReorderDate            *time.Time `json:"reorder_date"`
+}
+
+// ReorderReport lists the filaments to buy, grouped by the vendor they come
+// from. Filaments without a vendor are grouped under an empty vendor ID.
+type ReorderReport struct {
+	GeneratedAt    time.Time       `json:"generated_at"`
+	HorizonDays    int             `json:"horizon_days"`
+	WindowDays     int             `json:"window_days"`
+	ThresholdGrams float64         `json:"threshold_grams"`
+	EstimatedCost  float64         `json:"estimated_cost"`
+	Vendors        []VendorReorder `json:"vendors"`
+}
+
+// VendorReorder is what to order from one vendor
+type VendorReorder struct {
+	VendorID      string        `json:"vendor_id"`
+	VendorName    string        `json:"vendor_name,omitempty"`
+	Email         string        `json:"email,omitempty"`
+	AccountRef    string        `json:"account_ref,omitempty"`
+	LeadTimeDays  int           `json:"lead_time_days"`
+	EstimatedCost float64       `json:"estimated_cost"`
+	Items         []ReorderItem `json:"items"`
+}
+
+// ReorderItem is a filament that is low or forecast to run out, with how
+// many spools like it to buy
+type ReorderItem struct {
+	FilamentID             string     `json:"filament_id"`
+	Name                   string     `json:"name"`
+	Type                   string     `json:"type"`
+	Color                  string     `json:"color,omitempty"`
+	SKU                    string     `json:"sku,omitempty"`
+	Reason                 string     `json:"reason"` // below_threshold or forecast_depletion
+	RemainingWeightInGrams float64    `json:"remaining_weight_in_grams"`
+	GramsPerDay            float64    `json:"grams_per_day"`
+	DepletionDate          *time.Time `json:"depletion_date,omitempty"`
+	SuggestedGrams         float64    `json:"suggested_grams"`
+	SuggestedSpools        int        `json:"suggested_spools"`
+	EstimatedCost          float64    `json:"estimated_cost"`
+}
+
+// MaterialCost returns the cost of printing the given weight from this roll,
+// rounded to cents
+func (f Filament) MaterialCost(grams float64) float64 {
+	return math.Round(grams/1000*f.CostPerKg*100) / 100
+}
+
+// defaultFilamentDiameterMM is assumed for spools posted without a diameter
+const defaultFilamentDiameterMM = 1.75
+
+// cm3PerMeter returns the volume of one meter of this filament
+func (f Filament) cm3PerMeter() float64 {
+	radiusCm := f.DiameterMM / 20
+	return math.Pi * radiusCm * radiusCm * 100
+}
+
+// roundGrams rounds a weight to the milligram, so sums and differences of
+// fractional weights don't accumulate floating point noise
+func roundGrams(grams float64) float64 {
+	return math.Round(grams*1000) / 1000
+}
+
+// Quota limits what a user may print. Zero means unlimited.
+type Quota struct {
+	User              string  `json:"user"`
+	GramsPerMonth     float64 `json:"grams_per_month" validate:"gte=0"`
+	MaxConcurrentJobs int     `json:"max_concurrent_jobs" validate:"gte=0"`
+
+	// Revision is bumped by the server with every admitted job and every
+	// change to the quota, so admissions checked against stale usage conflict
+	Revision int `json:"revision"`
+}
+
+// QuotaStatus is a quota together with the user's current usage
+type QuotaStatus struct {
+	Quota
+	UsedGramsThisMonth float64 `json:"used_grams_this_month"`
+	ReservedGrams      float64 `json:"reserved_grams"`
+	ActiveJobs         int     `json:"active_jobs"`
+}
+
+// Stats summarizes the fleet and per-user consumption
+type Stats struct {
+	Printers    int                    `json:"printers"`
+	Filaments   int                    `json:"filaments"`
+	PrintJobs   map[string]int         `json:"print_jobs"`
+	Consumption CostSummary            `json:"consumption"`
+	ByUser      map[string]CostSummary `json:"by_user"`
+}
+
+// PrintJobStatusUpdate is the payload of a print job status change
+type PrintJobStatusUpdate struct {
+	Status string `json:"status" validate:"required,oneof=Running Done Failed Canceled"`
+}
+
+// Component is a wearing part of a printer, such as a nozzle or belt, with
+// the wear it has accumulated since it was installed or last serviced
+type Component struct {
+	ID        string `json:"id"`
+	PrinterID string `json:"printer_id" validate:"required"`
+	Kind      string `json:"kind" validate:"required,oneof=nozzle belt ptfe_tube hotend other"`
+	Name      string `json:"name,omitempty"`
+
+	// Wear counters, advanced as jobs on the printer complete
+	PrintHours float64 `json:"print_hours" validate:"gte=0"`
+	PrintGrams float64 `json:"print_grams" validate:"gte=0"`
+
+	// Maintenance is due once a counter reaches its limit. Zero means no limit.
+	MaxPrintHours float64 `json:"max_print_hours,omitempty" validate:"gte=0"`
+	MaxPrintGrams float64 `json:"max_print_grams,omitempty" validate:"gte=0"`
+
+	Annotations map[string]string `json:"annotations,omitempty" validate:"omitempty,annotations"`
+
+	// Set by the server
+	MaintenanceDue bool       `json:"maintenance_due"`
+	InstalledAt    time.Time  `json:"installed_at"`
+	LastServicedAt *time.Time `json:"last_serviced_at,omitempty"`
+}
+
+// MaterialType is an entry of the filament material catalog, with the
+// defaults used for spools of that material
+type MaterialType struct {
+	Name           string  `json:"name" validate:"required"`
+	NozzleTempC    float64 `json:"nozzle_temp_c,omitempty" validate:"gte=0"`
+	BedTempC       float64 `json:"bed_temp_c,omitempty" validate:"gte=0"`
+	DensityGPerCm3 float64 `json:"density_g_per_cm3,omitempty" validate:"gte=0"`
+	Hygroscopic    bool    `json:"hygroscopic,omitempty"`
+	Disabled       bool    `json:"disabled,omitempty"`
+
+	Annotations map[string]string `json:"annotations,omitempty" validate:"omitempty,annotations"`
+
+	// Set by the server for the materials every cluster starts with
+	Builtin bool `json:"builtin,omitempty"`
+}
+
+// AlertRule raises an alert when a printer's telemetry crosses a threshold,
+// or stops changing while a job is Running, for at least For
+type AlertRule struct {
+	ID        string  `json:"id" validate:"required"`
+	Name      string  `json:"name"`
+	PrinterID string  `json:"printer_id,omitempty"` // all printers when empty
+	Metric    string  `json:"metric" validate:"required,oneof=nozzle_temp_c bed_temp_c fan_speed_pct progress_pct"`
+	Condition string  `json:"condition" validate:"required,oneof=above below unchanged"`
+	Threshold float64 `json:"threshold"`
+	For       string  `json:"for,omitempty"` // duration such as 30s
+
+	Annotations map[string]string `json:"annotations,omitempty" validate:"omitempty,annotations"`
+}
+
+// Alert is raised by a rule for a printer. There is one per rule and
+// printer; it is reopened if the rule fires again after being resolved.
+type Alert struct {
+	ID         string     `json:"id"`
+	RuleID     string     `json:"rule_id"`
+	PrinterID  string     `json:"printer_id"`
+	Status     string     `json:"status"` // open or resolved
+	Message    string     `json:"message"`
+	Value      float64    `json:"value"`
+	Count      int        `json:"count"` // times the alert was raised
+	RaisedAt   time.Time  `json:"raised_at"`
+	ResolvedAt *time.Time `json:"resolved_at,omitempty"`
+}
+
+// BulkStatusUpdate is the payload of POST /print_jobs/status
+type BulkStatusUpdate struct {
+	Updates []PrintJobStatusChange `json:"updates"`
+}
+
+// PrintJobStatusChange is one entry of a bulk status update
+type PrintJobStatusChange struct {
+	ID     string `json:"id" validate:"required"`
+	Status string `json:"status" validate:"required,oneof=Running Done Failed Canceled"`
+}
+
+// BulkStatusResult reports a status change made by a bulk update
+type BulkStatusResult struct {
+	ID   string `json:"id"`
+	From string `json:"from"`
+	To   string `json:"to"`
 }
 
 // ValidatePrintJobStatus checks if the status transition is valid
 func ValidatePrintJobStatusTransition(currentStatus, newStatus string) error {
 	switch currentStatus {
+	case "PendingApproval":
+		// Approving is done with POST /print_jobs/{id}/approve
+		if newStatus == "Canceled" {
+			return nil
+		}
 	case "Queued":
 		if newStatus == "Running" || newStatus == "Canceled" {
 			return nil
 		}
 	case "Running":
-		if newStatus == "Done" || newStatus == "Canceled" {
+		if newStatus == "Done" || newStatus == "Failed" || newStatus == "Canceled" {
 			return nil
 		}
 	default:
 		return errors.New("invalid status transition: job is already in a terminal state")
 	}
-	
+
 	return fmt.Errorf("invalid status transition: cannot change from %s to %s", currentStatus, newStatus)
-}
\ No newline at end of file
+}
@@ -0,0 +1,79 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"raft3d/raft"
+	"raft3d/version"
+)
+
+// NodeIdentity describes what runs on a node, so fleet tooling can take
+// inventory
+type NodeIdentity struct {
+	NodeID string       `json:"node_id"`
+	Build  version.Info `json:"build"`
+
+	// ProtocolVersion is the raft.ProtocolVersion the node logs commands
+	// and joins with
+	ProtocolVersion int `json:"protocol_version"`
+
+	RaftAddr string `json:"raft_addr"` // as advertised to peers
+	HTTPAddr string `json:"http_addr"` // as advertised to peers and clients
+	Profile  string `json:"profile,omitempty"`
+
+	StartedAt     time.Time `json:"started_at"`
+	UptimeSeconds float64   `json:"uptime_seconds"`
+
+	Storage  StoragePaths `json:"storage"`
+	Features []string     `json:"features"` // sorted
+}
+
+// StoragePaths are where a node keeps its data
+type StoragePaths struct {
+	DataDir       string `json:"data_dir"`
+	RaftLog       string `json:"raft_log,omitempty"`
+	Snapshots     string `json:"snapshots,omitempty"`
+	LogArchive    string `json:"log_archive,omitempty"`
+	SQLProjection string `json:"sql_projection,omitempty"`
+}
+
+// SetIdentity records what GET /api/v1/node reports. The uptime is counted
+// from identity.StartedAt.
+func (s *Server) SetIdentity(identity NodeIdentity) {
+	s.identity = identity
+}
+
+// handleNode handles GET /api/v1/node, which describes this node: its ID,
+// build, addresses, uptime, storage paths and enabled features
+func (s *Server) handleNode(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, r)
+		return
+	}
+	if !s.requireRole(w, r, RoleAdmin, RoleOperator) {
+		return
+	}
+
+	identity := s.identity
+	if identity.NodeID == "" {
+		identity.NodeID = s.NodeID
+	}
+	if identity.HTTPAddr == "" {
+		identity.HTTPAddr = s.advertiseAddr()
+	}
+	if identity.Build.Version == "" {
+		identity.Build = version.Get()
+	}
+	if !identity.StartedAt.IsZero() {
+		identity.UptimeSeconds = time.Since(identity.StartedAt).Seconds()
+	}
+	identity.ProtocolVersion = raft.ProtocolVersion
+	if identity.Features == nil {
+		identity.Features = []string{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(identity)
+}
@@ -0,0 +1,39 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestNodeIdentity(t *testing.T) {
+	s := NewServer("127.0.0.1:8001", nil)
+	s.NodeID = "node1"
+	s.SetIdentity(NodeIdentity{
+		RaftAddr:  "127.0.0.1:9001",
+		StartedAt: time.Now().Add(-time.Minute),
+		Storage:   StoragePaths{DataDir: "data/node1"},
+		Features:  []string{"backups", "tls"},
+	})
+
+	w := httptest.NewRecorder()
+	s.handleNode(w, httptest.NewRequest(http.MethodGet, "/api/v1/node", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", w.Code, w.Body)
+	}
+	var identity NodeIdentity
+	if err := json.NewDecoder(w.Body).Decode(&identity); err != nil {
+		t.Fatal(err)
+	}
+	if identity.NodeID != "node1" || identity.HTTPAddr != "127.0.0.1:8001" || identity.RaftAddr != "127.0.0.1:9001" {
+		t.Errorf("identity = %+v", identity)
+	}
+	if identity.Build.Version == "" || identity.Build.GoVersion == "" {
+		t.Errorf("build = %+v", identity.Build)
+	}
+	if identity.UptimeSeconds < 60 || len(identity.Features) != 2 {
+		t.Errorf("uptime %v, features %v", identity.UptimeSeconds, identity.Features)
+	}
+}
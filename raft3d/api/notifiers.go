@@ -0,0 +1,240 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/smtp"
+	"strings"
+	"time"
+
+	"raft3d/secrets"
+)
+
+// defaultFilamentLowGrams is the remaining weight below which a spool is
+// reported low when the notification config doesn't say
+const defaultFilamentLowGrams = 100
+
+// NotificationConfig configures the outbox's delivery channels
+type NotificationConfig struct {
+	// FilamentLowGrams is the remaining weight below which a spool is
+	// reported low (default 100)
+	FilamentLowGrams float64 `json:"filament_low_grams"`
+
+	Channels []NotificationChannel `json:"channels"`
+}
+
+// NotificationChannel is one place notifications are sent to. Types limits
+// it to some notification types; empty means all of them.
+type NotificationChannel struct {
+	// Name identifies the channel in the delivery records, which are
+	// replicated, so it must be unique and should stay the same
+	Name  string   `json:"name" validate:"required"`
+	Kind  string   `json:"kind" validate:"required,oneof=webhook slack smtp"`
+	Types []string `json:"types,omitempty"`
+
+	// URL is the endpoint of webhook and Slack channels
+	URL string `json:"url,omitempty"`
+
+	// SMTP settings. PasswordRef is read with secrets.Read.
+	Addr        string   `json:"addr,omitempty"`
+	Username    string   `json:"username,omitempty"`
+	PasswordRef string   `json:"password_ref,omitempty"`
+	From        string   `json:"from,omitempty"`
+	To          []string `json:"to,omitempty"`
+}
+
+// LoadNotificationConfig reads a notification config from a file or Vault
+// secret reference, as understood by secrets.Read
+func LoadNotificationConfig(path string) (NotificationConfig, error) {
+	var config NotificationConfig
+	data, err := secrets.Read(path)
+	if err != nil {
+		return config, err
+	}
+	if err := json.Unmarshal(data, &config); err != nil {
+		return config, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	names := make(map[string]bool)
+	for i, channel := range config.Channels {
+		if errs := Validate(channel); len(errs) > 0 {
+			return config, fmt.Errorf("%s: channel %d: %s %s", path, i, errs[0].Name, errs[0].Reason)
+		}
+		if names[channel.Name] {
+			return config, fmt.Errorf("%s: channel %d: name %q is used twice", path, i, channel.Name)
+		}
+		names[channel.Name] = true
+
+		var missing string
+		switch {
+		case channel.Kind != "smtp" && channel.URL == "":
+			missing = "url"
+		case channel.Kind == "smtp" && channel.Addr == "":
+			missing = "addr"
+		case channel.Kind == "smtp" && channel.From == "":
+			missing = "from"
+		case channel.Kind == "smtp" && len(channel.To) == 0:
+			missing = "to"
+		}
+		if missing != "" {
+			return config, fmt.Errorf("%s: channel %d: %s is required for %s channels", path, i, missing, channel.Kind)
+		}
+	}
+	return config, nil
+}
+
+// Notifiers builds a notifier for every channel
+func (c NotificationConfig) Notifiers() ([]Notifier, error) {
+	notifiers := make([]Notifier, 0, len(c.Channels))
+	for _, channel := range c.Channels {
+		var notifier Notifier
+		switch channel.Kind {
+		case "webhook":
+			webhook := NewWebhookNotifier(channel.URL)
+			webhook.name = channel.Name
+			notifier = webhook
+		case "slack":
+			notifier = &SlackNotifier{name: channel.Name, URL: channel.URL, client: &http.Client{Timeout: 5 * time.Second}}
+		case "smtp":
+			mail := &SMTPNotifier{name: channel.Name, Addr: channel.Addr, From: channel.From, To: channel.To}
+			if channel.Username != "" {
+				password, err := secrets.Read(channel.PasswordRef)
+				if err != nil {
+					return nil, fmt.Errorf("channel %s: password: %w", channel.Name, err)
+				}
+				host, _, _ := net.SplitHostPort(channel.Addr)
+				mail.auth = smtp.PlainAuth("", channel.Username, strings.TrimSpace(string(password)), host)
+			}
+			notifier = mail
+		}
+		if len(channel.Types) > 0 {
+			notifier = Filtered(notifier, channel.Types)
+		}
+		notifiers = append(notifiers, notifier)
+	}
+	return notifiers, nil
+}
+
+// typeFilter is implemented by notifiers that only take some notification
+// types
+type typeFilter interface {
+	Accepts(typ string) bool
+}
+
+// accepts reports whether notifier takes notifications of typ
+func accepts(notifier Notifier, typ string) bool {
+	if f, ok := notifier.(typeFilter); ok {
+		return f.Accepts(typ)
+	}
+	return true
+}
+
+// filteredNotifier passes only some notification types to a notifier
+type filteredNotifier struct {
+	Notifier
+	types map[string]bool
+}
+
+// Filtered limits notifier to the given notification types
+func Filtered(notifier Notifier, types []string) Notifier {
+	f := filteredNotifier{Notifier: notifier, types: make(map[string]bool)}
+	for _, typ := range types {
+		f.types[typ] = true
+	}
+	return f
+}
+
+func (f filteredNotifier) Accepts(typ string) bool {
+	return f.types[typ]
+}
+
+// notificationSummary describes a notification in one line for people
+func notificationSummary(n Notification) string {
+	var fields map[string]interface{}
+	json.Unmarshal(n.Data, &fields)
+	_, id, _ := strings.Cut(n.Subject, "_")
+
+	switch n.Type {
+	case NotifyJobDone:
+		return fmt.Sprintf("Print job %s is done", id)
+	case NotifyJobFailed:
+		return fmt.Sprintf("Print job %s failed", id)
+	case NotifyJobCanceled:
+		return fmt.Sprintf("Print job %s was canceled", id)
+	case NotifyFilamentLow:
+		return fmt.Sprintf("Filament %s is low: %vg left", id, fields["remaining_weight_in_grams"])
+	case NotifyNodeDown:
+		return fmt.Sprintf("Node %s is down: no heartbeat since %v", id, fields["last_contact"])
+	}
+	return fmt.Sprintf("%s: %s", n.Type, n.Subject)
+}
+
+// SlackNotifier posts notifications to a Slack incoming webhook
+type SlackNotifier struct {
+	URL    string
+	name   string
+	client *http.Client
+}
+
+func (s *SlackNotifier) Name() string {
+	return s.name
+}
+
+func (s *SlackNotifier) Notify(ctx context.Context, n Notification) error {
+	body, err := json.Marshal(map[string]string{"text": notificationSummary(n)})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return nil
+}
+
+// SMTPNotifier emails notifications
+type SMTPNotifier struct {
+	Addr string
+	From string
+	To   []string
+	name string
+	auth smtp.Auth // nil sends without authenticating
+}
+
+func (m *SMTPNotifier) Name() string {
+	return m.name
+}
+
+// Notify sends the notification as a plain text email. net/smtp can't be
+// canceled, so ctx is only checked before connecting.
+func (m *SMTPNotifier) Notify(ctx context.Context, n Notification) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	details, _ := json.MarshalIndent(json.RawMessage(n.Data), "", "  ")
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\n", m.From)
+	fmt.Fprintf(&msg, "To: %s\r\n", strings.Join(m.To, ", "))
+	fmt.Fprintf(&msg, "Subject: [raft3d] %s\r\n", notificationSummary(n))
+	fmt.Fprintf(&msg, "Date: %s\r\n", n.CreatedAt.Format(time.RFC1123Z))
+	fmt.Fprintf(&msg, "Message-ID: <%s@raft3d>\r\n", n.ID)
+	fmt.Fprintf(&msg, "Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	fmt.Fprintf(&msg, "%s\r\n\r\n%s\r\n", notificationSummary(n), details)
+
+	return smtp.SendMail(m.Addr, m.auth, m.From, m.To, msg.Bytes())
+}
@@ -0,0 +1,82 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoadNotificationConfig(t *testing.T) {
+	tests := []struct {
+		name   string
+		config string
+		err    string
+	}{
+		{"valid", `{"filament_low_grams":50,"channels":[{"name":"ops","kind":"slack","url":"https://hooks.slack.test/x","types":["node.down"]},{"name":"mail","kind":"smtp","addr":"mail:25","from":"raft3d@lab","to":["ops@lab"]}]}`, ""},
+		{"unknown kind", `{"channels":[{"name":"ops","kind":"pager","url":"https://pager.test"}]}`, "kind"},
+		{"missing name", `{"channels":[{"kind":"webhook","url":"https://hooks.test"}]}`, "name"},
+		{"duplicate names", `{"channels":[{"name":"ops","kind":"webhook","url":"https://a.test"},{"name":"ops","kind":"slack","url":"https://b.test"}]}`, `name "ops" is used twice`},
+		{"webhook without a url", `{"channels":[{"name":"ops","kind":"webhook"}]}`, "url is required for webhook channels"},
+		{"smtp without recipients", `{"channels":[{"name":"mail","kind":"smtp","addr":"mail:25","from":"raft3d@lab"}]}`, "to is required for smtp channels"},
+		{"malformed", `{"channels":`, "failed to parse"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "notifications.json")
+			if err := os.WriteFile(path, []byte(tt.config), 0600); err != nil {
+				t.Fatal(err)
+			}
+			config, err := LoadNotificationConfig(path)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("got error %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			notifiers, err := config.Notifiers()
+			if err != nil || len(notifiers) != 2 || config.FilamentLowGrams != 50 {
+				t.Fatalf("notifiers %v %v from %+v", notifiers, err, config)
+			}
+			// Channel types limit what the channel takes
+			if notifiers[0].Name() != "ops" || accepts(notifiers[0], NotifyJobDone) || !accepts(notifiers[0], NotifyNodeDown) {
+				t.Fatalf("slack channel: %s", notifiers[0].Name())
+			}
+			if notifiers[1].Name() != "mail" || !accepts(notifiers[1], NotifyJobDone) {
+				t.Fatalf("smtp channel: %s", notifiers[1].Name())
+			}
+		})
+	}
+}
+
+func TestSlackNotifierPostsASummary(t *testing.T) {
+	var text string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]string
+		json.NewDecoder(r.Body).Decode(&body)
+		text = body["text"]
+	}))
+	defer server.Close()
+
+	notifier := &SlackNotifier{name: "ops", URL: server.URL, client: server.Client()}
+	tests := []struct {
+		notification Notification
+		want         string
+	}{
+		{Notification{Type: NotifyJobFailed, Subject: "printjob_j1"}, "Print job j1 failed"},
+		{Notification{Type: NotifyFilamentLow, Subject: "filament_f1", Data: json.RawMessage(`{"remaining_weight_in_grams":42}`)}, "Filament f1 is low: 42g left"},
+		{Notification{Type: "custom", Subject: "printer_p1"}, "custom: printer_p1"},
+	}
+	for _, tt := range tests {
+		if err := notifier.Notify(context.Background(), tt.notification); err != nil || text != tt.want {
+			t.Fatalf("posted %q %v, want %q", text, err, tt.want)
+		}
+	}
+}
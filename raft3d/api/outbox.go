@@ -0,0 +1,349 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"time"
+
+	"raft3d/raft"
+)
+
+// outboxKeyPrefix prefixes notifications waiting in the outbox
+const outboxKeyPrefix = "outbox_"
+
+// outboxRetryInterval is how long the dispatcher waits before retrying
+// notifications that couldn't be delivered
+const outboxRetryInterval = 10 * time.Second
+
+// outboxRetention is how long delivered notifications stay in the outbox
+const outboxRetention = 24 * time.Hour
+
+// Notification types recorded in the outbox
+const (
+	NotifyJobDone     = "print_job.done"
+	NotifyJobFailed   = "print_job.failed"
+	NotifyJobCanceled = "print_job.canceled"
+	NotifyFilamentLow = "filament.low"
+	NotifyNodeDown    = "node.down"
+)
+
+// jobNotifications maps the print job statuses that are notified about to
+// their notification type
+var jobNotifications = map[string]string{
+	"Done":     NotifyJobDone,
+	"Failed":   NotifyJobFailed,
+	"Canceled": NotifyJobCanceled,
+}
+
+// Notification is a message for external systems, recorded in the same log
+// entry as the change it reports, so it can't be lost even if the leader
+// fails before sending it
+type Notification struct {
+	ID          string               `json:"id"`
+	Type        string               `json:"type"`
+	Subject     string               `json:"subject"`
+	Data        json.RawMessage      `json:"data,omitempty"`
+	CreatedAt   time.Time            `json:"created_at"`
+	Delivered   map[string]time.Time `json:"delivered,omitempty"` // per notifier
+	DeliveredAt *time.Time           `json:"delivered_at,omitempty"`
+	Attempts    int                  `json:"attempts"`
+	LastError   string               `json:"last_error,omitempty"`
+}
+
+// Notifier delivers outbox notifications to one external system
+type Notifier interface {
+	// Name identifies the notifier in a notification's delivery record
+	Name() string
+
+	// Notify sends a notification. It may be called again for a
+	// notification it already sent if the leader changes in between.
+	Notify(ctx context.Context, n Notification) error
+}
+
+// WebhookNotifier posts notifications to a URL as JSON, with the
+// notification's ID as Idempotency-Key
+type WebhookNotifier struct {
+	URL    string
+	name   string // defaults to the URL
+	client *http.Client
+}
+
+// NewWebhookNotifier creates a notifier posting to url
+func NewWebhookNotifier(url string) *WebhookNotifier {
+	return &WebhookNotifier{URL: url, client: &http.Client{Timeout: 5 * time.Second}}
+}
+
+func (w *WebhookNotifier) Name() string {
+	if w.name != "" {
+		return w.name
+	}
+	return "webhook:" + w.URL
+}
+
+func (w *WebhookNotifier) Notify(ctx context.Context, n Notification) error {
+	body, err := json.Marshal(n)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Idempotency-Key", n.ID)
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return nil
+}
+
+// EnableOutbox records notifications of print jobs finishing, spools
+// running low and nodes going down, and has the leader deliver them to the
+// notifiers that take them. Spools are low below filamentLowGrams, or 100
+// if it is zero.
+func (s *Server) EnableOutbox(filamentLowGrams float64, notifiers ...Notifier) {
+	if filamentLowGrams <= 0 {
+		filamentLowGrams = defaultFilamentLowGrams
+	}
+	s.notifiers = notifiers
+	s.filamentLowGrams = filamentLowGrams
+}
+
+// notified reports whether any notifier takes notifications of typ
+func (s *Server) notified(typ string) bool {
+	for _, notifier := range s.notifiers {
+		if accepts(notifier, typ) {
+			return true
+		}
+	}
+	return false
+}
+
+// recordNotification adds a notification to values, a write about to be
+// committed, so it is recorded in the same log entry as the change it
+// reports. It does nothing unless a notifier takes typ.
+func (s *Server) recordNotification(values map[string]string, typ, subject string, data interface{}) error {
+	if !s.notified(typ) {
+		return nil
+	}
+
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return err
+	}
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	n := Notification{
+		ID:        hex.EncodeToString(b[:]),
+		Type:      typ,
+		Subject:   subject,
+		Data:      payload,
+		CreatedAt: time.Now().UTC(),
+	}
+	body, err := json.Marshal(n)
+	if err != nil {
+		return err
+	}
+	values[outboxKeyPrefix+n.ID] = string(body)
+	return nil
+}
+
+// notifyJobStatus records a notification in values if job reached a status
+// that is notified about
+func (s *Server) notifyJobStatus(values map[string]string, job PrintJob) error {
+	typ, ok := jobNotifications[job.Status]
+	if !ok {
+		return nil
+	}
+	return s.recordNotification(values, typ, "printjob_"+job.ID, job)
+}
+
+// notifyFilamentLow records a notification in values if a spool that had
+// before grams left dropped below the low threshold
+func (s *Server) notifyFilamentLow(values map[string]string, filament Filament, before float64) error {
+	if before < s.filamentLowGrams || filament.RemainingWeightInGrams >= s.filamentLowGrams {
+		return nil
+	}
+	return s.recordNotification(values, NotifyFilamentLow, "filament_"+filament.ID, filament)
+}
+
+// watchNodes records a notification when the leader loses its heartbeat to
+// a peer, once until the peer is heard from again
+func (s *Server) watchNodes() {
+	if s.events == nil || !s.notified(NotifyNodeDown) {
+		return
+	}
+	ch, unsubscribe := s.events.Subscribe(64)
+	defer unsubscribe()
+
+	down := make(map[string]bool)
+	for {
+		select {
+		case event := <-ch:
+			data, _ := event.Data.(map[string]string)
+			switch event.Type {
+			case raft.EventHeartbeatFailed:
+				peer := data["peer_id"]
+				if down[peer] || !s.store.IsLeader() {
+					continue
+				}
+				values := make(map[string]string)
+				err := s.recordNotification(values, NotifyNodeDown, "node_"+peer, data)
+				if err == nil {
+					err = s.store.SetMany(values)
+				}
+				if err != nil {
+					log.Printf("Outbox: failed to record that node %s is down: %s", peer, err)
+					continue
+				}
+				down[peer] = true
+			case raft.EventHeartbeatResumed:
+				delete(down, data["peer_id"])
+			}
+		case <-s.stopCh:
+			return
+		}
+	}
+}
+
+// runOutbox delivers outbox notifications while this node is leader, until
+// the server stops
+func (s *Server) runOutbox() {
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-s.stopCh
+		cancel()
+	}()
+
+	for ctx.Err() == nil {
+		since := s.store.AppliedIndex()
+		if s.store.IsLeader() && !s.dispatchOutbox(ctx) {
+			// Don't let recording the failed attempts wake us straight away
+			select {
+			case <-time.After(outboxRetryInterval):
+			case <-ctx.Done():
+			}
+			continue
+		}
+		wait, done := context.WithTimeout(ctx, outboxRetryInterval)
+		s.store.WaitForChange(wait, outboxKeyPrefix, since)
+		done()
+	}
+}
+
+// dispatchOutbox sends every undelivered notification, oldest first, to the
+// notifiers that haven't received it and records the outcome through Raft.
+// Notifications delivered longer ago than the retention are removed. It
+// reports whether everything pending was delivered.
+func (s *Server) dispatchOutbox(ctx context.Context) bool {
+	all, err := loadAll[Notification](s, outboxKeyPrefix)
+	if err != nil {
+		log.Printf("Outbox: failed to list notifications: %s", err)
+		return false
+	}
+	pending := make([]Notification, 0, len(all))
+	var expired []string
+	for id, n := range all {
+		if n.DeliveredAt == nil {
+			pending = append(pending, n)
+		} else if time.Since(*n.DeliveredAt) > outboxRetention {
+			expired = append(expired, outboxKeyPrefix+id)
+		}
+	}
+	sort.Slice(pending, func(i, j int) bool { return pending[i].CreatedAt.Before(pending[j].CreatedAt) })
+
+	delivered := true
+	for _, n := range pending {
+		if ctx.Err() != nil || !s.store.IsLeader() {
+			return true
+		}
+		if n.Delivered == nil {
+			n.Delivered = make(map[string]time.Time)
+		}
+		n.Attempts++
+		n.LastError = ""
+		for _, notifier := range s.notifiers {
+			if _, ok := n.Delivered[notifier.Name()]; ok || !accepts(notifier, n.Type) {
+				continue
+			}
+			if err := notifier.Notify(ctx, n); err != nil {
+				n.LastError = fmt.Sprintf("%s: %s", notifier.Name(), err)
+				continue
+			}
+			n.Delivered[notifier.Name()] = time.Now().UTC()
+		}
+		if n.LastError == "" {
+			now := time.Now().UTC()
+			n.DeliveredAt = &now
+		} else {
+			log.Printf("Outbox: notification %s (%s) not delivered: %s", n.ID, n.Type, n.LastError)
+			delivered = false
+		}
+
+		body, err := json.Marshal(n)
+		if err != nil {
+			continue
+		}
+		key := outboxKeyPrefix + n.ID
+		if err := s.store.SetIf(key, string(body), raft.Condition{Key: key}); err != nil {
+			log.Printf("Outbox: failed to record delivery of %s: %s", n.ID, err)
+			return false
+		}
+	}
+
+	if len(expired) > 0 {
+		if err := s.store.DeleteMany(expired); err != nil {
+			log.Printf("Outbox: failed to remove delivered notifications: %s", err)
+		}
+	}
+	return delivered
+}
+
+// handleOutbox handles GET /outbox, oldest notifications first, optionally
+// filtered by ?status=pending or ?status=delivered. Only admins can read it.
+func (s *Server) handleOutbox(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, r)
+		return
+	}
+	if !s.requireRole(w, r, RoleAdmin) {
+		return
+	}
+
+	status := r.URL.Query().Get("status")
+	if status != "" && status != "pending" && status != "delivered" {
+		writeValidationProblem(w, r, []FieldError{{Name: "status", Reason: "must be pending or delivered"}})
+		return
+	}
+
+	all, err := loadAll[Notification](s, outboxKeyPrefix)
+	if err != nil {
+		s.writeStoreError(w, r, err, "Failed to retrieve outbox")
+		return
+	}
+	notifications := []Notification{}
+	for _, n := range all {
+		if (status == "pending" && n.DeliveredAt != nil) || (status == "delivered" && n.DeliveredAt == nil) {
+			continue
+		}
+		notifications = append(notifications, n)
+	}
+	sort.Slice(notifications, func(i, j int) bool { return notifications[i].CreatedAt.Before(notifications[j].CreatedAt) })
+	writeList(w, r, notifications)
+}
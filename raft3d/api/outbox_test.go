@@ -0,0 +1,161 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"raft3d/testsupport"
+)
+
+// recordingNotifier records the notifications it is sent, failing the
+// first fail of them
+type recordingNotifier struct {
+	name string
+	fail int
+
+	mutex sync.Mutex
+	sent  []Notification
+}
+
+func (n *recordingNotifier) Name() string {
+	return n.name
+}
+
+func (n *recordingNotifier) Notify(ctx context.Context, notification Notification) error {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	if n.fail > 0 {
+		n.fail--
+		return errors.New("unreachable")
+	}
+	n.sent = append(n.sent, notification)
+	return nil
+}
+
+func (n *recordingNotifier) types() []string {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	var types []string
+	for _, notification := range n.sent {
+		types = append(types, notification.Type)
+	}
+	return types
+}
+
+func TestOutboxRecordsNotificationsWithTheirChange(t *testing.T) {
+	c := testsupport.NewCluster(t, 1)
+	leader := c.WaitForLeader(10 * time.Second)
+	s := NewServer("", leader.Store)
+	flaky := &recordingNotifier{name: "flaky", fail: 1}
+	jobsOnly := &recordingNotifier{name: "jobs"}
+	s.EnableOutbox(150, flaky, Filtered(jobsOnly, []string{NotifyJobDone}))
+
+	put := func(key string, v interface{}) {
+		body, _ := json.Marshal(v)
+		if err := leader.Store.Set(key, string(body)); err != nil {
+			t.Fatalf("set %s: %s", key, err)
+		}
+	}
+	put("printer_p1", Printer{ID: "p1", Name: "Prusa", Status: "Idle"})
+	put("filament_f1", Filament{ID: "f1", Name: "PLA", Type: "PLA", TotalWeightInGrams: 1000, RemainingWeightInGrams: 200})
+	put("printjob_j1", PrintJob{ID: "j1", PrinterID: "p1", FilamentID: "f1", FilePath: "a.gcode", PrintWeightInGrams: 100, Status: "Queued"})
+
+	for _, to := range []string{"Running", "Done"} {
+		w := httptest.NewRecorder()
+		s.handleUpdatePrintJobStatus(w, httptest.NewRequest(http.MethodPost, "/api/v1/print_jobs/j1/status?status="+to, nil), "j1")
+		if w.Code != http.StatusOK {
+			t.Fatalf("status %s: %d %s", to, w.Code, w.Body)
+		}
+	}
+
+	// The job's completion and the spool running low are in the same log
+	// entry as the change
+	page, err := leader.Store.ReadLog(0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var keys []string
+	for _, entry := range page.Entries {
+		for _, key := range entry.Keys {
+			if key == "printjob_j1" {
+				keys = entry.Keys
+			}
+		}
+	}
+	outboxed := 0
+	for _, key := range keys {
+		if strings.HasPrefix(key, outboxKeyPrefix) {
+			outboxed++
+		}
+	}
+	if outboxed != 2 {
+		t.Fatalf("completion entry wrote %v, want two notifications", keys)
+	}
+
+	outbox := func(status string) []Notification {
+		w := httptest.NewRecorder()
+		s.handleOutbox(w, httptest.NewRequest(http.MethodGet, "/api/v1/outbox?status="+status, nil))
+		var notifications []Notification
+		if err := json.Unmarshal(w.Body.Bytes(), &notifications); err != nil {
+			t.Fatalf("outbox: %d %s", w.Code, w.Body)
+		}
+		return notifications
+	}
+
+	// A failed delivery is retried only to the notifier that missed it
+	if s.dispatchOutbox(context.Background()) {
+		t.Fatal("dispatch reported a failed delivery as delivered")
+	}
+	pending := outbox("pending")
+	if len(pending) != 1 || pending[0].Attempts != 1 || !strings.Contains(pending[0].LastError, "flaky: unreachable") {
+		t.Fatalf("pending after a failed delivery: %+v", pending)
+	}
+	if !s.dispatchOutbox(context.Background()) {
+		t.Fatal("retry was not delivered")
+	}
+	if pending := outbox("pending"); len(pending) != 0 {
+		t.Fatalf("pending after retrying: %+v", pending)
+	}
+	delivered := outbox("delivered")
+	if len(delivered) != 2 {
+		t.Fatalf("delivered: %+v", delivered)
+	}
+	for _, n := range delivered {
+		if n.ID == pending[0].ID && (n.Attempts != 2 || n.LastError != "" || n.DeliveredAt == nil) {
+			t.Fatalf("retried notification: %+v", n)
+		}
+	}
+
+	if got := strings.Join(flaky.types(), ","); got != NotifyJobDone+","+NotifyFilamentLow && got != NotifyFilamentLow+","+NotifyJobDone {
+		t.Fatalf("flaky notifier got %s", got)
+	}
+	if got := strings.Join(jobsOnly.types(), ","); got != NotifyJobDone {
+		t.Fatalf("filtered notifier got %s", got)
+	}
+}
+
+func TestWebhookNotifierSendsIdempotencyKey(t *testing.T) {
+	var key string
+	status := http.StatusOK
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key = r.Header.Get("Idempotency-Key")
+		w.WriteHeader(status)
+	}))
+	defer server.Close()
+
+	notifier := NewWebhookNotifier(server.URL)
+	if err := notifier.Notify(context.Background(), Notification{ID: "n1", Type: NotifyJobDone}); err != nil || key != "n1" {
+		t.Fatalf("notify: %v, Idempotency-Key %q", err, key)
+	}
+	status = http.StatusInternalServerError
+	if err := notifier.Notify(context.Background(), Notification{ID: "n2", Type: NotifyJobDone}); err == nil {
+		t.Fatal("a 500 counted as delivered")
+	}
+}
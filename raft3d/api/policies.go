@@ -0,0 +1,135 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"raft3d/raft"
+)
+
+// handlePolicies handles GET /policies (list), GET /policies/{name},
+// PUT /policies/{name} and DELETE /policies/{name}. Anyone can read the
+// policies their writes are checked against; changing them needs an admin.
+func (s *Server) handlePolicies(w http.ResponseWriter, r *http.Request) {
+	name := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/v1/policies"), "/")
+
+	switch {
+	case r.Method == http.MethodGet && name == "":
+		s.handleListPolicies(w, r)
+	case r.Method == http.MethodGet:
+		policy, err := s.getPolicy(name)
+		if err != nil {
+			s.writeStoreError(w, r, err, "Policy not found")
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(policy)
+	case r.Method == http.MethodPut && name != "":
+		if !s.requireRole(w, r, RoleAdmin) {
+			return
+		}
+		s.handlePutPolicy(w, r, name)
+	case r.Method == http.MethodDelete && name != "":
+		if !s.requireRole(w, r, RoleAdmin) {
+			return
+		}
+		if _, err := s.getPolicy(name); err != nil {
+			s.writeStoreError(w, r, err, "Policy not found")
+			return
+		}
+		if err := s.storeFor(r).Delete(raft.PolicyPrefix + name); err != nil {
+			s.writeStoreError(w, r, err, "Failed to delete policy")
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		methodNotAllowed(w, r)
+	}
+}
+
+// handleListPolicies returns every policy, ordered by name
+func (s *Server) handleListPolicies(w http.ResponseWriter, r *http.Request) {
+	stored, err := loadAll[raft.Policy](s, raft.PolicyPrefix)
+	if err != nil {
+		s.writeStoreError(w, r, err, "Failed to retrieve policies")
+		return
+	}
+	policies := make([]raft.Policy, 0, len(stored))
+	for _, policy := range stored {
+		policies = append(policies, policy)
+	}
+	sort.Slice(policies, func(i, j int) bool { return policies[i].Name < policies[j].Name })
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(policies)
+}
+
+// handlePutPolicy creates a policy or replaces its rule with a new version.
+// The write is conditional on the version it revises, so two admins editing
+// at once can't silently overwrite each other.
+func (s *Server) handlePutPolicy(w http.ResponseWriter, r *http.Request, name string) {
+	var next raft.Policy
+	if !decodeJSON(w, r, &next) {
+		return
+	}
+	next.Name = name
+	if err := next.Check(); err != nil {
+		writeError(w, r, http.StatusBadRequest, CodeValidationFailed, err.Error())
+		return
+	}
+
+	current, err := s.getPolicy(name)
+	exists := err == nil
+	if err != nil && !errors.Is(err, raft.ErrNotFound) {
+		s.writeStoreError(w, r, err, "Failed to retrieve policy")
+		return
+	}
+	actor := ""
+	if principal, ok := principalFrom(r); ok {
+		actor = principal.Name
+	}
+	now := time.Now().UTC()
+	policy := next
+	policy.Version, policy.UpdatedAt, policy.UpdatedBy, policy.Previous = 1, &now, actor, nil
+	if exists {
+		policy = current.Revise(next, now, actor)
+	}
+
+	body, err := json.Marshal(policy)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, CodeInternal, "Failed to process policy")
+		return
+	}
+	key := raft.PolicyPrefix + name
+	if exists {
+		err = s.storeFor(r).SetIf(key, string(body), raft.Condition{Key: key, Field: "version", Equals: strconv.Itoa(current.Version)})
+	} else {
+		err = s.storeFor(r).Create(key, string(body))
+	}
+	if err != nil {
+		s.writeStoreError(w, r, err, "Failed to store policy")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if !exists {
+		w.WriteHeader(http.StatusCreated)
+	}
+	w.Write(body)
+}
+
+// getPolicy loads a stored policy
+func (s *Server) getPolicy(name string) (raft.Policy, error) {
+	var policy raft.Policy
+	value, err := s.store.Get(raft.PolicyPrefix + name)
+	if err != nil {
+		return policy, err
+	}
+	err = json.Unmarshal([]byte(value), &policy)
+	return policy, err
+}
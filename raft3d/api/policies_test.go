@@ -0,0 +1,70 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"raft3d/raft"
+	"raft3d/testsupport"
+)
+
+func TestPolicies(t *testing.T) {
+	c := testsupport.NewCluster(t, 1)
+	leader := c.WaitForLeader(10 * time.Second)
+	s := NewServer("", leader.Store)
+	for key, v := range map[string]interface{}{
+		"printer_p1":  Printer{ID: "p1", Name: "Prusa", Status: "Idle"},
+		"filament_f1": Filament{ID: "f1", Name: "PLA", Type: "PLA", TotalWeightInGrams: 2000, RemainingWeightInGrams: 2000},
+	} {
+		body, _ := json.Marshal(v)
+		if err := leader.Store.Set(key, string(body)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	do := func(method, url, body string, handler http.HandlerFunc) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		handler(rec, httptest.NewRequest(method, url, strings.NewReader(body)))
+		return rec
+	}
+	rule := `{"kinds":["printjob"],"expression":"entity.print_weight_in_grams <= 500 || entity.labels.approved == 'true'","message":"jobs over 500g need label approved=true"}`
+	if rec := do(http.MethodPut, "/api/v1/policies/big-jobs", rule, s.handlePolicies); rec.Code != http.StatusCreated {
+		t.Fatalf("create policy: %d %s", rec.Code, rec.Body)
+	}
+	if rec := do(http.MethodPut, "/api/v1/policies/broken", `{"kinds":["printjob"],"expression":"entity.x >"}`, s.handlePolicies); rec.Code != http.StatusBadRequest {
+		t.Fatalf("broken expression: %d", rec.Code)
+	}
+	if rec := do(http.MethodPut, "/api/v1/policies/no-kinds", `{"kinds":[],"expression":"true"}`, s.handlePolicies); rec.Code != http.StatusBadRequest {
+		t.Fatalf("policy without kinds: %d", rec.Code)
+	}
+
+	job := `{"printer_id":"p1","filament_id":"f1","filepath":"big.gcode","print_weight_in_grams":600}`
+	rec := do(http.MethodPost, "/api/v1/print_jobs", job, s.handlePostPrintJob)
+	if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), CodePolicyViolation) || !strings.Contains(rec.Body.String(), "need label approved=true") {
+		t.Fatalf("job over the limit: %d %s", rec.Code, rec.Body)
+	}
+	approved := `{"printer_id":"p1","filament_id":"f1","filepath":"big.gcode","print_weight_in_grams":600,"labels":{"approved":"true"}}`
+	if rec := do(http.MethodPost, "/api/v1/print_jobs", approved, s.handlePostPrintJob); rec.Code != http.StatusCreated {
+		t.Fatalf("approved job: %d %s", rec.Code, rec.Body)
+	}
+
+	// A new version keeps the old one
+	if rec := do(http.MethodPut, "/api/v1/policies/big-jobs", `{"kinds":["printjob"],"expression":"true"}`, s.handlePolicies); rec.Code != http.StatusOK {
+		t.Fatalf("update policy: %d %s", rec.Code, rec.Body)
+	}
+	var policy raft.Policy
+	rec = do(http.MethodGet, "/api/v1/policies/big-jobs", "", s.handlePolicies)
+	if json.NewDecoder(rec.Body).Decode(&policy) != nil || policy.Version != 2 || len(policy.Previous) != 1 || policy.Previous[0].Version != 1 || policy.UpdatedAt == nil {
+		t.Fatalf("policy: %+v", policy)
+	}
+	if rec := do(http.MethodPost, "/api/v1/print_jobs", job, s.handlePostPrintJob); rec.Code != http.StatusCreated {
+		t.Fatalf("job under the new version: %d %s", rec.Code, rec.Body)
+	}
+	if rec := do(http.MethodDelete, "/api/v1/policies/big-jobs", "", s.handlePolicies); rec.Code != http.StatusNoContent {
+		t.Fatalf("delete: %d", rec.Code)
+	}
+}
@@ -0,0 +1,59 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// Problem is an RFC 7807 problem details response
+type Problem struct {
+	Type          string       `json:"type"`
+	Title         string       `json:"title"`
+	Status        int          `json:"status"`
+	Code          string       `json:"code,omitempty"`
+	Detail        string       `json:"detail,omitempty"`
+	Instance      string       `json:"instance,omitempty"`
+	InvalidParams []FieldError `json:"invalid_params,omitempty"`
+	Leader        *LeaderHint  `json:"leader,omitempty"`
+}
+
+// writeProblem writes p as an application/problem+json response
+func writeProblem(w http.ResponseWriter, r *http.Request, p Problem) {
+	if p.Type == "" {
+		p.Type = "about:blank"
+	}
+	if p.Instance == "" {
+		p.Instance = requestPath(r)
+	}
+
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.WriteHeader(p.Status)
+	json.NewEncoder(w).Encode(p)
+}
+
+// writeValidationProblem writes a 400 problem listing every invalid field
+func writeValidationProblem(w http.ResponseWriter, r *http.Request, errs []FieldError) {
+	writeProblem(w, r, *validationProblem(errs))
+}
+
+// validationProblem builds a 400 problem listing every invalid field
+func validationProblem(errs []FieldError) *Problem {
+	return &Problem{
+		Type:          "/problems/validation",
+		Title:         "Request validation failed",
+		Status:        http.StatusBadRequest,
+		Code:          CodeValidationFailed,
+		Detail:        "One or more fields are invalid",
+		InvalidParams: errs,
+	}
+}
+
+// errorProblem builds a problem with the given status and error code
+func errorProblem(status int, code, detail string) *Problem {
+	return &Problem{
+		Title:  http.StatusText(status),
+		Status: status,
+		Code:   code,
+		Detail: detail,
+	}
+}
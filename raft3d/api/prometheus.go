@@ -0,0 +1,142 @@
+package api
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"raft3d/raft"
+)
+
+// prometheusContentType is the Prometheus text exposition format
+const prometheusContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// wantsPrometheus reports whether /metrics should answer in the Prometheus
+// text format, requested with ?format=prometheus or by a scraper's Accept
+// header
+func wantsPrometheus(r *http.Request) bool {
+	if r.URL.Query().Get("format") == "prometheus" {
+		return true
+	}
+	accept := r.Header.Get("Accept")
+	return strings.Contains(accept, "text/plain") || strings.Contains(accept, "openmetrics")
+}
+
+// infoLabels are the string metrics reported as labels of raft3d_info. They
+// change rarely, so the info series stays stable; other strings would start a
+// new series every time they changed.
+var infoLabels = []string{"node_id", "state", "leader_addr"}
+
+// writePrometheus writes the node's metrics and apply latency histograms.
+// Numeric and boolean metrics become gauges, last_contact becomes
+// raft3d_last_contact_seconds, and infoLabels are labels of raft3d_info.
+// Other string metrics, such as error messages, are left out.
+func writePrometheus(w http.ResponseWriter, metrics map[string]interface{}, latency raft.ApplyLatency) {
+	w.Header().Set("Content-Type", prometheusContentType)
+
+	labels := make(map[string]string)
+	for _, name := range infoLabels {
+		labels[name], _ = metrics[name].(string)
+	}
+	gauges := make(map[string]float64)
+	for name, value := range metrics {
+		if _, ok := labels[name]; ok {
+			continue
+		}
+		switch v := value.(type) {
+		case bool:
+			gauges[name] = 0
+			if v {
+				gauges[name] = 1
+			}
+		case int:
+			gauges[name] = float64(v)
+		case int64:
+			gauges[name] = float64(v)
+		case uint64:
+			gauges[name] = float64(v)
+		case float64:
+			gauges[name] = v
+		case time.Time:
+			gauges[name] = float64(v.Unix())
+		case string:
+			// Raft stats are reported as strings, most of them numeric.
+			// last_contact is a duration, or "never" before the first
+			// contact.
+			if name == "last_contact" {
+				if d, err := time.ParseDuration(v); err == nil {
+					gauges["last_contact_seconds"] = d.Seconds()
+				}
+			} else if f, err := strconv.ParseFloat(v, 64); err == nil {
+				gauges[name] = f
+			}
+		}
+	}
+
+	fmt.Fprintln(w, "# HELP raft3d_info Node identity and state.")
+	fmt.Fprintln(w, "# TYPE raft3d_info gauge")
+	fmt.Fprintf(w, "raft3d_info%s 1\n", formatLabels(labels))
+
+	names := make([]string, 0, len(gauges))
+	for name := range gauges {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(w, "# TYPE raft3d_%s gauge\n", name)
+		fmt.Fprintf(w, "raft3d_%s %s\n", name, formatFloat(gauges[name]))
+	}
+
+	writeHistograms(w, "raft3d_apply_commit_seconds",
+		"Time from the leader appending a command to this node applying it.", latency.Commit)
+	writeHistograms(w, "raft3d_fsm_apply_seconds",
+		"Time the state machine spends applying a command.", latency.FSM)
+	writeHistograms(w, "raft3d_apply_seconds",
+		"Time from submitting a write on this node to its result.", latency.Total)
+}
+
+// writeHistograms writes one histogram metric with a series per command type
+func writeHistograms(w io.Writer, name, help string, byOp map[string]raft.Histogram) {
+	fmt.Fprintf(w, "# HELP %s %s\n", name, help)
+	fmt.Fprintf(w, "# TYPE %s histogram\n", name)
+
+	ops := make([]string, 0, len(byOp))
+	for op := range byOp {
+		ops = append(ops, op)
+	}
+	sort.Strings(ops)
+	for _, op := range ops {
+		h := byOp[op]
+		for i, bound := range h.Buckets {
+			fmt.Fprintf(w, "%s_bucket{op=%q,le=%q} %d\n", name, op, formatFloat(bound), h.Counts[i])
+		}
+		fmt.Fprintf(w, "%s_bucket{op=%q,le=\"+Inf\"} %d\n", name, op, h.Count)
+		fmt.Fprintf(w, "%s_sum{op=%q} %s\n", name, op, formatFloat(h.Sum))
+		fmt.Fprintf(w, "%s_count{op=%q} %d\n", name, op, h.Count)
+	}
+}
+
+// formatLabels renders a sorted Prometheus label set
+func formatLabels(labels map[string]string) string {
+	if len(labels) == 0 {
+		return ""
+	}
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	pairs := make([]string, len(names))
+	for i, name := range names {
+		pairs[i] = fmt.Sprintf("%s=%q", name, labels[name])
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
@@ -0,0 +1,200 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"raft3d/raft"
+	"raft3d/testsupport"
+)
+
+// promSample is one sample line of the text exposition format
+type promSample struct {
+	name   string
+	labels map[string]string
+	value  float64
+}
+
+var (
+	promSampleLine = regexp.MustCompile(`^([a-zA-Z_:][a-zA-Z0-9_:]*)(\{(.*)\})? (\S+)$`)
+	promLabel      = regexp.MustCompile(`([a-zA-Z_][a-zA-Z0-9_]*)="((?:[^"\\]|\\.)*)"`)
+)
+
+// parseExposition parses Prometheus text output, failing on a malformed line
+// or a sample whose metric wasn't given a TYPE first. It returns the samples
+// and the declared type of each metric.
+func parseExposition(t *testing.T, body string) ([]promSample, map[string]string) {
+	t.Helper()
+	types := map[string]string{}
+	var samples []promSample
+	for _, line := range strings.Split(strings.TrimSpace(body), "\n") {
+		if strings.HasPrefix(line, "# HELP ") {
+			continue
+		}
+		if strings.HasPrefix(line, "# TYPE ") {
+			fields := strings.Fields(line)
+			if len(fields) != 4 {
+				t.Fatalf("malformed TYPE line %q", line)
+			}
+			if _, ok := types[fields[2]]; ok {
+				t.Fatalf("%s has two TYPE lines", fields[2])
+			}
+			types[fields[2]] = fields[3]
+			continue
+		}
+		match := promSampleLine.FindStringSubmatch(line)
+		if match == nil {
+			t.Fatalf("malformed sample %q", line)
+		}
+		value, err := strconv.ParseFloat(match[4], 64)
+		if err != nil {
+			t.Fatalf("sample %q: %v", line, err)
+		}
+		sample := promSample{name: match[1], labels: map[string]string{}, value: value}
+		for _, label := range promLabel.FindAllStringSubmatch(match[3], -1) {
+			sample.labels[label[1]] = label[2]
+		}
+		family := sample.name
+		for _, suffix := range []string{"_bucket", "_sum", "_count"} {
+			if base := strings.TrimSuffix(family, suffix); types[base] == "histogram" {
+				family = base
+			}
+		}
+		if types[family] == "" {
+			t.Fatalf("sample %q has no TYPE", line)
+		}
+		samples = append(samples, sample)
+	}
+	return samples, types
+}
+
+func TestWritePrometheus(t *testing.T) {
+	metrics := map[string]interface{}{
+		"node_id":              "n1",
+		"state":                "Follower",
+		"leader_addr":          "127.0.0.1:9001",
+		"is_leader":            false,
+		"last_contact":         "12.5ms",
+		"term":                 "3",
+		"apply_pending":        int64(2),
+		"sql_projection_error": "table jobs: disk full",
+	}
+	latency := raft.ApplyLatency{
+		Commit: map[string]raft.Histogram{
+			"set": {Buckets: []float64{0.001, 0.01, 0.1}, Counts: []uint64{1, 3, 3}, Count: 4, Sum: 0.5},
+		},
+	}
+	w := httptest.NewRecorder()
+	writePrometheus(w, metrics, latency)
+	if got := w.Header().Get("Content-Type"); got != prometheusContentType {
+		t.Fatalf("Content-Type %q", got)
+	}
+	samples, types := parseExposition(t, w.Body.String())
+
+	values := map[string]float64{}
+	for _, sample := range samples {
+		if sample.name == "raft3d_info" {
+			// Only the fixed identity labels, so the series doesn't churn
+			if len(sample.labels) != 3 || sample.labels["node_id"] != "n1" || sample.labels["state"] != "Follower" || sample.labels["leader_addr"] != "127.0.0.1:9001" {
+				t.Fatalf("raft3d_info labels %v", sample.labels)
+			}
+		}
+		if len(sample.labels) == 0 {
+			values[sample.name] = sample.value
+		}
+	}
+	want := map[string]float64{"raft3d_last_contact_seconds": 0.0125, "raft3d_term": 3, "raft3d_is_leader": 0, "raft3d_apply_pending": 2}
+	for name, value := range want {
+		if got, ok := values[name]; !ok || got != value || types[name] != "gauge" {
+			t.Errorf("%s = %v (%s), want gauge %v", name, got, types[name], value)
+		}
+	}
+	for _, name := range []string{"raft3d_last_contact", "raft3d_sql_projection_error"} {
+		if _, ok := types[name]; ok {
+			t.Errorf("%s was exported", name)
+		}
+	}
+
+	// Buckets are cumulative, in order of their bounds, and end with +Inf
+	// equal to the count
+	if types["raft3d_apply_commit_seconds"] != "histogram" {
+		t.Fatalf("raft3d_apply_commit_seconds is a %q", types["raft3d_apply_commit_seconds"])
+	}
+	var bounds []string
+	var counts []float64
+	var count, sum float64
+	for _, sample := range samples {
+		if sample.labels["op"] != "set" {
+			continue
+		}
+		switch sample.name {
+		case "raft3d_apply_commit_seconds_bucket":
+			bounds = append(bounds, sample.labels["le"])
+			counts = append(counts, sample.value)
+		case "raft3d_apply_commit_seconds_count":
+			count = sample.value
+		case "raft3d_apply_commit_seconds_sum":
+			sum = sample.value
+		}
+	}
+	if strings.Join(bounds, ",") != "0.001,0.01,0.1,+Inf" {
+		t.Fatalf("bucket bounds %v", bounds)
+	}
+	for i := 1; i < len(counts); i++ {
+		if counts[i] < counts[i-1] {
+			t.Fatalf("bucket counts %v are not cumulative", counts)
+		}
+	}
+	if counts[len(counts)-1] != count || count != 4 || sum != 0.5 {
+		t.Fatalf("buckets %v, count %v, sum %v", counts, count, sum)
+	}
+
+	// Before any contact there is no last contact to report
+	metrics["last_contact"] = "never"
+	w = httptest.NewRecorder()
+	writePrometheus(w, metrics, raft.ApplyLatency{})
+	if _, types := parseExposition(t, w.Body.String()); types["raft3d_last_contact_seconds"] != "" {
+		t.Fatal("exported a last contact that never happened")
+	}
+}
+
+func TestMetricsFormatNegotiation(t *testing.T) {
+	c := testsupport.NewCluster(t, 1)
+	leader := c.WaitForLeader(10 * time.Second)
+	s := NewServer("", leader.Store)
+
+	tests := []struct {
+		name   string
+		url    string
+		accept string
+		want   string
+	}{
+		{"default", "/metrics", "", "application/json"},
+		{"format parameter", "/metrics?format=prometheus", "", prometheusContentType},
+		{"scraper", "/metrics", "text/plain;version=0.0.4", prometheusContentType},
+		{"openmetrics", "/metrics", "application/openmetrics-text", prometheusContentType},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, tt.url, nil)
+			if tt.accept != "" {
+				r.Header.Set("Accept", tt.accept)
+			}
+			w := httptest.NewRecorder()
+			s.handleMetrics(w, r)
+			if got := w.Header().Get("Content-Type"); got != tt.want {
+				t.Fatalf("Content-Type %q, want %q", got, tt.want)
+			}
+			if tt.want == prometheusContentType {
+				if _, types := parseExposition(t, w.Body.String()); types["raft3d_info"] != "gauge" || types["raft3d_fsm_apply_seconds"] != "histogram" {
+					t.Fatalf("types %v", types)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,47 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"raft3d/raft"
+)
+
+// queryTimeout bounds how long an ad-hoc query may run
+const queryTimeout = 30 * time.Second
+
+// handleQuery handles POST /api/v1/query, which runs a read-only SQL
+// statement against this node's SQL projection. The projection is local and
+// may trail the node's state by a moment; the response says which log index
+// it reflects.
+func (s *Server) handleQuery(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		methodNotAllowed(w, r)
+		return
+	}
+	if !s.requireRole(w, r, RoleAdmin) {
+		return
+	}
+	var query SQLQuery
+	if !decodeJSON(w, r, &query) {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), queryTimeout)
+	defer cancel()
+	result, err := s.store.Query(ctx, query.SQL, query.Args, query.Limit)
+	switch {
+	case errors.Is(err, raft.ErrTimeout):
+		writeError(w, r, http.StatusGatewayTimeout, CodeQueryTimeout, "The query did not finish within "+queryTimeout.String())
+		return
+	case err != nil:
+		s.writeStoreError(w, r, err, "Query failed")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
@@ -0,0 +1,28 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"raft3d/testsupport"
+)
+
+func TestQueryWithoutProjection(t *testing.T) {
+	c := testsupport.NewCluster(t, 1)
+	s := NewServer("", c.WaitForLeader(10*time.Second).Store)
+
+	for body, want := range map[string]int{
+		`{"sql":"SELECT * FROM printer"}`: http.StatusConflict,
+		`{"limit":5}`:                     http.StatusBadRequest,
+		`{"sql":"SELECT 1","limit":-1}`:   http.StatusBadRequest,
+	} {
+		rec := httptest.NewRecorder()
+		s.handleQuery(rec, httptest.NewRequest(http.MethodPost, "/api/v1/query", strings.NewReader(body)))
+		if rec.Code != want {
+			t.Errorf("%s: got %d %s, want %d", body, rec.Code, rec.Body, want)
+		}
+	}
+}
@@ -0,0 +1,244 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"raft3d/raft"
+)
+
+// quotaKeyPrefix prefixes per-user quota records
+const quotaKeyPrefix = "quota_"
+
+// admissionAttempts bounds how often a job is checked against its quota again
+// after another admission for the same submitter was applied first
+const admissionAttempts = 5
+
+// quotaReservation is written in the same log entry as an admitted job: the
+// submitter's quota with its revision bumped, on the condition that the
+// revision is still the one the usage was computed under. Two admissions
+// checked against the same usage can't both be applied.
+type quotaReservation struct {
+	values     map[string]string
+	conditions []raft.Condition
+}
+
+// raced reports whether writing the job failed because another admission for
+// the submitter got in first, so the job must be checked again
+func (q quotaReservation) raced(err error) bool {
+	return len(q.conditions) > 0 && errors.Is(err, raft.ErrConflict) && !errors.Is(err, raft.ErrAlreadyExists)
+}
+
+// requireRole rejects requests from principals without one of the roles.
+// Without authentication every caller is trusted.
+func (s *Server) requireRole(w http.ResponseWriter, r *http.Request, roles ...string) bool {
+	if !s.authEnabled() {
+		return true
+	}
+	principal, _ := principalFrom(r)
+	for _, role := range roles {
+		if principal.Role == role {
+			return true
+		}
+	}
+	writeError(w, r, http.StatusForbidden, CodeForbidden,
+		fmt.Sprintf("This operation requires one of the roles: %s", strings.Join(roles, ", ")))
+	return false
+}
+
+// handleQuotas handles GET /quotas (list), GET /quotas/{user} and
+// PUT /quotas/{user}. Admins can see and set any quota; other users can only
+// see their own.
+func (s *Server) handleQuotas(w http.ResponseWriter, r *http.Request) {
+	user := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/v1/quotas"), "/")
+
+	switch {
+	case r.Method == http.MethodGet && user == "":
+		if !s.requireRole(w, r, RoleAdmin) {
+			return
+		}
+		s.handleListQuotas(w, r)
+	case r.Method == http.MethodGet:
+		if principal, ok := principalFrom(r); ok && principal.Name != user && !s.requireRole(w, r, RoleAdmin) {
+			return
+		}
+		s.handleGetQuota(w, r, user)
+	case r.Method == http.MethodPut && user != "":
+		if !s.requireRole(w, r, RoleAdmin) {
+			return
+		}
+		s.handlePutQuota(w, r, user)
+	default:
+		methodNotAllowed(w, r)
+	}
+}
+
+// handleListQuotas returns every quota with its current usage
+func (s *Server) handleListQuotas(w http.ResponseWriter, r *http.Request) {
+	keys, err := s.store.List(quotaKeyPrefix)
+	if err != nil {
+		s.writeStoreError(w, r, err, "Failed to retrieve quotas")
+		return
+	}
+
+	statuses := make(map[string]QuotaStatus)
+	for _, key := range keys {
+		status, err := s.quotaStatus(strings.TrimPrefix(key, quotaKeyPrefix))
+		if err != nil {
+			continue
+		}
+		statuses[status.User] = status
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(statuses)
+}
+
+// handleGetQuota returns one user's quota and usage
+func (s *Server) handleGetQuota(w http.ResponseWriter, r *http.Request, user string) {
+	status, err := s.quotaStatus(user)
+	if err != nil {
+		s.writeStoreError(w, r, err, "Quota not found")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(status)
+}
+
+// handlePutQuota sets a user's quota
+func (s *Server) handlePutQuota(w http.ResponseWriter, r *http.Request, user string) {
+	var quota Quota
+	if !decodeJSON(w, r, &quota) {
+		return
+	}
+	quota.User = user
+	quota.Revision = 0
+	if current, err := s.getQuota(user); err == nil {
+		quota.Revision = current.Revision + 1
+	}
+
+	body, err := json.Marshal(quota)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, CodeInternal, "Failed to process quota data")
+		return
+	}
+	if err := s.storeFor(r).Set(quotaKeyPrefix+user, string(body)); err != nil {
+		s.writeStoreError(w, r, err, "Failed to store quota")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(body)
+}
+
+// getQuota loads a user's quota
+func (s *Server) getQuota(user string) (Quota, error) {
+	var quota Quota
+	value, err := s.store.Get(quotaKeyPrefix + user)
+	if err != nil {
+		return quota, err
+	}
+	err = json.Unmarshal([]byte(value), &quota)
+	return quota, err
+}
+
+// quotaStatus loads a user's quota and computes their current usage: grams
+// consumed this month plus grams reserved by active jobs, and the number of
+// active jobs
+func (s *Server) quotaStatus(user string) (QuotaStatus, error) {
+	quota, err := s.getQuota(user)
+	if err != nil {
+		return QuotaStatus{}, err
+	}
+	status := QuotaStatus{Quota: quota}
+
+	monthStart := time.Now().UTC()
+	monthStart = time.Date(monthStart.Year(), monthStart.Month(), 1, 0, 0, 0, 0, time.UTC)
+	usages, err := s.listUsage("")
+	if err != nil {
+		return status, err
+	}
+	for _, usage := range usages {
+		if usage.SubmittedBy == user && !usage.RecordedAt.Before(monthStart) {
+			status.UsedGramsThisMonth += usage.WeightInGrams
+		}
+	}
+
+	keys, err := s.store.List("printjob_")
+	if err != nil {
+		return status, err
+	}
+	for _, key := range keys {
+		value, err := s.store.Get(key)
+		if err != nil {
+			continue
+		}
+		var printJob PrintJob
+		if err := json.Unmarshal([]byte(value), &printJob); err != nil {
+			continue
+		}
+		if printJob.SubmittedBy == user && (printJob.isActive() || printJob.Status == "PendingApproval") {
+			status.ActiveJobs++
+			status.ReservedGrams += printJob.PrintWeightInGrams
+		}
+	}
+	status.UsedGramsThisMonth = roundGrams(status.UsedGramsThisMonth)
+	status.ReservedGrams = roundGrams(status.ReservedGrams)
+	return status, nil
+}
+
+// checkQuota returns a reason the job would exceed its submitter's quota, or
+// "" if it fits or the submitter has no quota. A job that fits must be
+// written together with the returned reservation.
+func (s *Server) checkQuota(job PrintJob) (string, quotaReservation, error) {
+	if job.SubmittedBy == "" {
+		return "", quotaReservation{}, nil
+	}
+
+	// The revision is read before the usage, so any job admitted after the
+	// usage was computed has also changed the revision
+	key := quotaKeyPrefix + job.SubmittedBy
+	value, err := s.store.Get(key)
+	if errors.Is(err, raft.ErrNotFound) {
+		return "", quotaReservation{}, nil
+	}
+	if err != nil {
+		return "", quotaReservation{}, err
+	}
+	var quota Quota
+	var fields map[string]interface{}
+	if err := json.Unmarshal([]byte(value), &quota); err != nil {
+		return "", quotaReservation{}, err
+	}
+	json.Unmarshal([]byte(value), &fields)
+	quota.Revision++
+	body, err := json.Marshal(quota)
+	if err != nil {
+		return "", quotaReservation{}, err
+	}
+	reservation := quotaReservation{
+		values:     map[string]string{key: string(body)},
+		conditions: []raft.Condition{{Key: key, Field: "revision", Equals: fmt.Sprint(fields["revision"])}},
+	}
+
+	status, err := s.quotaStatus(job.SubmittedBy)
+	if err != nil {
+		return "", quotaReservation{}, err
+	}
+	status.Quota = quota
+	if status.MaxConcurrentJobs > 0 && status.ActiveJobs >= status.MaxConcurrentJobs {
+		return fmt.Sprintf("Quota exceeded: %s already has %d active jobs (limit %d)",
+			job.SubmittedBy, status.ActiveJobs, status.MaxConcurrentJobs), reservation, nil
+	}
+	used := status.UsedGramsThisMonth + status.ReservedGrams
+	if status.GramsPerMonth > 0 && roundGrams(used+job.PrintWeightInGrams) > status.GramsPerMonth {
+		return fmt.Sprintf("Quota exceeded: %s has used or reserved %g of %g grams this month, job needs %g",
+			job.SubmittedBy, used, status.GramsPerMonth, job.PrintWeightInGrams), reservation, nil
+	}
+	return "", reservation, nil
+}
@@ -0,0 +1,83 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"raft3d/testsupport"
+)
+
+func TestConcurrentSubmissionsRespectQuota(t *testing.T) {
+	tests := []struct {
+		name     string
+		quota    Quota
+		accepted int
+	}{
+		{"concurrent jobs", Quota{MaxConcurrentJobs: 2}, 2},
+		{"grams per month", Quota{GramsPerMonth: 70}, 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := testsupport.NewCluster(t, 3)
+			leader := c.WaitForLeader(10 * time.Second)
+			s := NewServer("", leader.Store)
+
+			put := func(key string, v interface{}) {
+				body, _ := json.Marshal(v)
+				if err := leader.Store.Set(key, string(body)); err != nil {
+					t.Fatalf("set %s: %s", key, err)
+				}
+			}
+			put("printer_p1", Printer{ID: "p1", Name: "Prusa", Status: "Idle"})
+			put("filament_f1", Filament{ID: "f1", Name: "PLA", Type: "PLA", TotalWeightInGrams: 1000, RemainingWeightInGrams: 1000})
+			tt.quota.User = "alice"
+			put(quotaKeyPrefix+"alice", tt.quota)
+
+			const submissions = 8
+			codes := make([]int, submissions)
+			bodies := make([]string, submissions)
+			var wg sync.WaitGroup
+			for i := 0; i < submissions; i++ {
+				wg.Add(1)
+				go func(i int) {
+					defer wg.Done()
+					body := fmt.Sprintf(`{"printer_id":"p1","filament_id":"f1","filepath":"part%d.gcode","print_weight_in_grams":20}`, i)
+					r := httptest.NewRequest(http.MethodPost, "/api/v1/print_jobs", strings.NewReader(body))
+					r = r.WithContext(context.WithValue(r.Context(), principalKey{}, Principal{Name: "alice", Role: RoleMember}))
+					w := httptest.NewRecorder()
+					s.handlePostPrintJob(w, r)
+					codes[i], bodies[i] = w.Code, w.Body.String()
+				}(i)
+			}
+			wg.Wait()
+
+			accepted := 0
+			for i, code := range codes {
+				switch {
+				case code == http.StatusCreated:
+					accepted++
+				case code != http.StatusConflict || !strings.Contains(bodies[i], CodeQuotaExceeded):
+					t.Errorf("submission %d got %d: %s", i, code, bodies[i])
+				}
+			}
+			if accepted != tt.accepted {
+				t.Errorf("%d jobs accepted, want %d", accepted, tt.accepted)
+			}
+			status, err := s.quotaStatus("alice")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if status.ActiveJobs != tt.accepted {
+				t.Errorf("alice has %d active jobs, want %d", status.ActiveJobs, tt.accepted)
+			}
+			c.AssertConverged(5 * time.Second)
+		})
+	}
+}
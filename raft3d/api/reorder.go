@@ -0,0 +1,166 @@
+package api
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// defaultReorderHorizonDays is how far ahead the reorder report looks when
+// ?days= isn't given, a week between purchasing runs plus slack
+const defaultReorderHorizonDays = 14
+
+// Reasons a filament is on the reorder report
+const (
+	reorderBelowThreshold    = "below_threshold"
+	reorderForecastDepletion = "forecast_depletion"
+)
+
+// handleReorderReport handles GET /api/v1/reports/reorder, listing the
+// filaments below ?below_grams= (default the low spool threshold) or forecast
+// to run out within ?days= plus their vendor's lead time, grouped by vendor.
+// Consumption is averaged over ?window_days= as for a filament's forecast.
+func (s *Server) handleReorderReport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, r)
+		return
+	}
+	csvOut, ok := wantsCSV(w, r)
+	if !ok {
+		return
+	}
+	horizonDays, ok := positiveIntParam(w, r, "days", defaultReorderHorizonDays)
+	if !ok {
+		return
+	}
+	windowDays, ok := positiveIntParam(w, r, "window_days", defaultForecastWindowDays)
+	if !ok {
+		return
+	}
+	threshold := s.filamentLowGrams
+	if threshold <= 0 {
+		threshold = defaultFilamentLowGrams
+	}
+	if raw := r.URL.Query().Get("below_grams"); raw != "" {
+		grams, err := strconv.ParseFloat(raw, 64)
+		if err != nil || grams < 0 {
+			writeValidationProblem(w, r, []FieldError{{Name: "below_grams", Reason: "must be a non-negative number"}})
+			return
+		}
+		threshold = grams
+	}
+
+	filaments, err := loadAll[Filament](s, "filament_")
+	if err != nil {
+		s.writeStoreError(w, r, err, "Failed to retrieve filaments")
+		return
+	}
+	vendors, err := loadAll[Vendor](s, vendorKeyPrefix)
+	if err != nil {
+		s.writeStoreError(w, r, err, "Failed to retrieve vendors")
+		return
+	}
+	usages, err := s.listUsage("")
+	if err != nil {
+		s.writeStoreError(w, r, err, "Failed to retrieve usage history")
+		return
+	}
+
+	report := reorderReport(filaments, vendors, usages, horizonDays, windowDays, threshold, time.Now().UTC())
+
+	if csvOut {
+		writeReorderReportCSV(w, report)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
+
+// reorderReport works out what to buy. A filament is reordered when it is
+// below threshold or forecast to run out before an order placed now, plus
+// horizonDays until the next purchasing run, would arrive. The suggestion
+// covers that period's forecast use and tops the stock back up to the
+// threshold, rounded up to whole spools of the filament's size.
+func reorderReport(filaments map[string]Filament, vendors map[string]Vendor, usages []FilamentUsage,
+	horizonDays, windowDays int, threshold float64, now time.Time) ReorderReport {
+	report := ReorderReport{
+		GeneratedAt:    now,
+		HorizonDays:    horizonDays,
+		WindowDays:     windowDays,
+		ThresholdGrams: threshold,
+		Vendors:        []VendorReorder{},
+	}
+
+	byFilament := make(map[string][]FilamentUsage)
+	for _, usage := range usages {
+		byFilament[usage.FilamentID] = append(byFilament[usage.FilamentID], usage)
+	}
+
+	groups := make(map[string]*VendorReorder)
+	for _, id := range sortedKeys(filaments) {
+		filament := filaments[id]
+		vendor := vendors[filament.VendorID]
+		leadDays := vendor.LeadTimeDays
+		if leadDays == 0 {
+			leadDays = defaultReorderLeadDays
+		}
+		coverDays := horizonDays + leadDays
+
+		forecast := forecastDepletion(filament, byFilament[id], windowDays, leadDays, now)
+		item := ReorderItem{
+			FilamentID:             filament.ID,
+			Name:                   filament.Name,
+			Type:                   filament.Type,
+			Color:                  filament.Color.String(),
+			SKU:                    filament.SKU,
+			RemainingWeightInGrams: filament.RemainingWeightInGrams,
+			GramsPerDay:            roundGrams(forecast.GramsPerDay),
+			DepletionDate:          forecast.DepletionDate,
+		}
+		switch {
+		case filament.RemainingWeightInGrams < threshold:
+			item.Reason = reorderBelowThreshold
+		case forecast.DaysUntilDepletion != nil && *forecast.DaysUntilDepletion <= float64(coverDays):
+			item.Reason = reorderForecastDepletion
+		default:
+			continue
+		}
+
+		needed := forecast.GramsPerDay*float64(coverDays) + threshold - filament.RemainingWeightInGrams
+		item.SuggestedGrams = roundGrams(math.Max(needed, 0))
+		item.SuggestedSpools = 1
+		if filament.TotalWeightInGrams > 0 {
+			item.SuggestedSpools = int(math.Max(math.Ceil(needed/filament.TotalWeightInGrams), 1))
+		}
+		spoolCost := filament.PurchasePrice
+		if spoolCost == 0 {
+			spoolCost = filament.MaterialCost(filament.TotalWeightInGrams)
+		}
+		item.EstimatedCost = math.Round(spoolCost*float64(item.SuggestedSpools)*100) / 100
+
+		group, ok := groups[filament.VendorID]
+		if !ok {
+			group = &VendorReorder{
+				VendorID:     filament.VendorID,
+				VendorName:   vendor.Name,
+				Email:        vendor.Email,
+				AccountRef:   vendor.AccountRef,
+				LeadTimeDays: leadDays,
+				Items:        []ReorderItem{},
+			}
+			groups[filament.VendorID] = group
+		}
+		group.Items = append(group.Items, item)
+		group.EstimatedCost = math.Round((group.EstimatedCost+item.EstimatedCost)*100) / 100
+		report.EstimatedCost = math.Round((report.EstimatedCost+item.EstimatedCost)*100) / 100
+	}
+
+	for _, group := range groups {
+		report.Vendors = append(report.Vendors, *group)
+	}
+	sort.Slice(report.Vendors, func(i, j int) bool { return report.Vendors[i].VendorID < report.Vendors[j].VendorID })
+	return report
+}
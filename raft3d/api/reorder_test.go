@@ -0,0 +1,39 @@
+package api
+
+import (
+	"testing"
+	"time"
+)
+
+func TestReorderReport(t *testing.T) {
+	now := time.Date(2026, 10, 1, 12, 0, 0, 0, time.UTC)
+	filaments := map[string]Filament{
+		"a": {ID: "a", VendorID: "acme", TotalWeightInGrams: 1000, RemainingWeightInGrams: 50, PurchasePrice: 20},
+		"b": {ID: "b", VendorID: "acme", TotalWeightInGrams: 1000, RemainingWeightInGrams: 900, CostPerKg: 25},
+		"c": {ID: "c", TotalWeightInGrams: 1000, RemainingWeightInGrams: 900},
+		"d": {ID: "d", TotalWeightInGrams: 750, RemainingWeightInGrams: 10, CostPerKg: 20},
+	}
+	vendors := map[string]Vendor{"acme": {ID: "acme", Name: "Acme", LeadTimeDays: 5}}
+	usages := []FilamentUsage{{FilamentID: "b", WeightInGrams: 1000, RecordedAt: now.AddDate(0, 0, -10)}}
+
+	report := reorderReport(filaments, vendors, usages, 14, 30, 100, now)
+	if len(report.Vendors) != 2 || report.EstimatedCost != 85 {
+		t.Fatalf("report = %+v", report)
+	}
+
+	unassigned, acme := report.Vendors[0], report.Vendors[1]
+	if unassigned.VendorID != "" || len(unassigned.Items) != 1 || unassigned.Items[0].FilamentID != "d" ||
+		unassigned.LeadTimeDays != defaultReorderLeadDays || unassigned.Items[0].EstimatedCost != 15 {
+		t.Errorf("no vendor = %+v", unassigned)
+	}
+	if acme.VendorName != "Acme" || len(acme.Items) != 2 || acme.EstimatedCost != 70 {
+		t.Fatalf("acme = %+v", acme)
+	}
+	if a := acme.Items[0]; a.Reason != reorderBelowThreshold || a.SuggestedSpools != 1 || a.EstimatedCost != 20 {
+		t.Errorf("a = %+v", a)
+	}
+	// 100g a day for 14+5 days, topped up to the threshold: 1100g
+	if b := acme.Items[1]; b.Reason != reorderForecastDepletion || b.SuggestedGrams != 1100 || b.SuggestedSpools != 2 || b.EstimatedCost != 50 {
+		t.Errorf("b = %+v", b)
+	}
+}
@@ -0,0 +1,236 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"time"
+)
+
+// handleCostReport handles GET /api/v1/reports/costs, aggregating material
+// spend of completed jobs by printer, filament type and period. The range is
+// given with ?from= and ?to= (RFC 3339 or YYYY-MM-DD) and the period with
+// ?period=day|week|month (default month).
+func (s *Server) handleCostReport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, r)
+		return
+	}
+
+	csvOut, ok := wantsCSV(w, r)
+	if !ok {
+		return
+	}
+
+	query := r.URL.Query()
+	report := CostReport{
+		Period:         query.Get("period"),
+		ByPrinter:      make(map[string]CostSummary),
+		ByFilamentType: make(map[string]CostSummary),
+		ByPeriod:       make(map[string]CostSummary),
+	}
+	if report.Period == "" {
+		report.Period = "month"
+	}
+
+	var errs []FieldError
+	if report.Period != "day" && report.Period != "week" && report.Period != "month" {
+		errs = append(errs, FieldError{Name: "period", Reason: "must be one of [day week month]"})
+	}
+	var err error
+	if report.From, err = parseReportTime(query.Get("from")); err != nil {
+		errs = append(errs, FieldError{Name: "from", Reason: err.Error()})
+	}
+	if report.To, err = parseReportTime(query.Get("to")); err != nil {
+		errs = append(errs, FieldError{Name: "to", Reason: err.Error()})
+	}
+	if len(errs) > 0 {
+		writeValidationProblem(w, r, errs)
+		return
+	}
+
+	usages, err := s.listUsage("")
+	if err != nil {
+		s.writeStoreError(w, r, err, "Failed to retrieve usage history")
+		return
+	}
+
+	for _, usage := range usages {
+		if report.From != nil && usage.RecordedAt.Before(*report.From) {
+			continue
+		}
+		if report.To != nil && !usage.RecordedAt.Before(*report.To) {
+			continue
+		}
+		report.Total = report.Total.add(usage)
+		report.ByPrinter[usage.PrinterID] = report.ByPrinter[usage.PrinterID].add(usage)
+		report.ByFilamentType[usage.FilamentType] = report.ByFilamentType[usage.FilamentType].add(usage)
+		bucket := periodBucket(usage.RecordedAt, report.Period)
+		report.ByPeriod[bucket] = report.ByPeriod[bucket].add(usage)
+	}
+
+	if csvOut {
+		writeCostReportCSV(w, report)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
+
+// handleUsageReport handles GET /api/v1/reports/usage, listing the filament
+// consumed by completed jobs, oldest first, within ?from= and ?to=
+func (s *Server) handleUsageReport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, r)
+		return
+	}
+	csvOut, ok := wantsCSV(w, r)
+	if !ok {
+		return
+	}
+
+	var errs []FieldError
+	from, err := parseReportTime(r.URL.Query().Get("from"))
+	if err != nil {
+		errs = append(errs, FieldError{Name: "from", Reason: err.Error()})
+	}
+	to, err := parseReportTime(r.URL.Query().Get("to"))
+	if err != nil {
+		errs = append(errs, FieldError{Name: "to", Reason: err.Error()})
+	}
+	if len(errs) > 0 {
+		writeValidationProblem(w, r, errs)
+		return
+	}
+
+	all, err := s.listUsage(r.URL.Query().Get("filament_id"))
+	if err != nil {
+		s.writeStoreError(w, r, err, "Failed to retrieve usage history")
+		return
+	}
+	usages := []FilamentUsage{}
+	for _, usage := range all {
+		if (from != nil && usage.RecordedAt.Before(*from)) || (to != nil && !usage.RecordedAt.Before(*to)) {
+			continue
+		}
+		usages = append(usages, usage)
+	}
+	sort.Slice(usages, func(i, j int) bool { return usages[i].RecordedAt.Before(usages[j].RecordedAt) })
+
+	if csvOut {
+		writeUsageCSV(w, usages)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(usages)
+}
+
+// handleDefectReport handles GET /api/v1/reports/defects, aggregating the
+// results of inspections made within ?from= and ?to= by printer, filament lot
+// and defect code. Spools without a lot number are reported under their
+// filament ID.
+func (s *Server) handleDefectReport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, r)
+		return
+	}
+	csvOut, ok := wantsCSV(w, r)
+	if !ok {
+		return
+	}
+
+	report := DefectReport{
+		ByPrinter:     make(map[string]DefectSummary),
+		ByFilamentLot: make(map[string]DefectSummary),
+		ByDefectCode:  make(map[string]int),
+	}
+	var errs []FieldError
+	var err error
+	if report.From, err = parseReportTime(r.URL.Query().Get("from")); err != nil {
+		errs = append(errs, FieldError{Name: "from", Reason: err.Error()})
+	}
+	if report.To, err = parseReportTime(r.URL.Query().Get("to")); err != nil {
+		errs = append(errs, FieldError{Name: "to", Reason: err.Error()})
+	}
+	if len(errs) > 0 {
+		writeValidationProblem(w, r, errs)
+		return
+	}
+
+	inspections, err := loadAll[Inspection](s, inspectionKeyPrefix)
+	if err != nil {
+		s.writeStoreError(w, r, err, "Failed to retrieve inspections")
+		return
+	}
+	for _, inspection := range inspections {
+		if report.From != nil && inspection.InspectedAt.Before(*report.From) {
+			continue
+		}
+		if report.To != nil && !inspection.InspectedAt.Before(*report.To) {
+			continue
+		}
+		lot := inspection.LotNumber
+		if lot == "" {
+			lot = "filament:" + inspection.FilamentID
+		}
+		report.Total = report.Total.add(inspection)
+		report.ByPrinter[inspection.PrinterID] = report.ByPrinter[inspection.PrinterID].add(inspection)
+		report.ByFilamentLot[lot] = report.ByFilamentLot[lot].add(inspection)
+		for _, code := range inspection.DefectCodes {
+			report.ByDefectCode[code]++
+		}
+	}
+
+	if csvOut {
+		writeDefectReportCSV(w, report)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
+
+// add returns the summary with an inspection counted in
+func (d DefectSummary) add(inspection Inspection) DefectSummary {
+	d.Inspected++
+	if inspection.Result == InspectionFail {
+		d.Failed++
+	}
+	d.DefectRate = math.Round(float64(d.Failed)/float64(d.Inspected)*10000) / 10000
+	return d
+}
+
+// add returns the summary with a usage record counted in
+func (c CostSummary) add(usage FilamentUsage) CostSummary {
+	c.Jobs++
+	c.WeightInGrams = roundGrams(c.WeightInGrams + usage.WeightInGrams)
+	c.Cost = math.Round((c.Cost+usage.Cost)*100) / 100
+	return c
+}
+
+// parseReportTime parses an optional RFC 3339 timestamp or YYYY-MM-DD date
+func parseReportTime(raw string) (*time.Time, error) {
+	if raw == "" {
+		return nil, nil
+	}
+	for _, layout := range []string{time.RFC3339, "2006-01-02"} {
+		if t, err := time.Parse(layout, raw); err == nil {
+			t = t.UTC()
+			return &t, nil
+		}
+	}
+	return nil, fmt.Errorf("must be an RFC 3339 timestamp or YYYY-MM-DD date")
+}
+
+// periodBucket names the day, ISO week or month a time falls in
+func periodBucket(t time.Time, period string) string {
+	switch period {
+	case "day":
+		return t.Format("2006-01-02")
+	case "week":
+		year, week := t.ISOWeek()
+		return fmt.Sprintf("%d-W%02d", year, week)
+	}
+	return t.Format("2006-01")
+}
@@ -0,0 +1,103 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"raft3d/testsupport"
+)
+
+func TestCompletionRecordsCost(t *testing.T) {
+	c := testsupport.NewCluster(t, 1)
+	leader := c.WaitForLeader(10 * time.Second)
+	s := NewServer("", leader.Store)
+
+	put := func(key string, v interface{}) {
+		body, _ := json.Marshal(v)
+		if err := leader.Store.Set(key, string(body)); err != nil {
+			t.Fatalf("set %s: %s", key, err)
+		}
+	}
+	put("printer_p1", Printer{ID: "p1", Name: "Prusa", Status: "Printing"})
+	put("filament_f1", Filament{ID: "f1", Name: "PLA", Type: "PLA", TotalWeightInGrams: 1000, RemainingWeightInGrams: 1000, CostPerKg: 25})
+	put("printjob_j1", PrintJob{ID: "j1", PrinterID: "p1", FilamentID: "f1", FilePath: "a.gcode", PrintWeightInGrams: 82.5, Status: "Running"})
+
+	w := httptest.NewRecorder()
+	s.handleUpdatePrintJobStatus(w, httptest.NewRequest(http.MethodPost, "/api/v1/print_jobs/j1/status?status=Done", nil), "j1")
+	if w.Code != http.StatusOK {
+		t.Fatalf("complete: %d %s", w.Code, w.Body)
+	}
+
+	// 82.5g at 25 per kg, rounded to cents
+	var job PrintJob
+	if value, err := leader.Store.Get("printjob_j1"); err != nil || json.Unmarshal([]byte(value), &job) != nil || job.MaterialCost != 2.06 {
+		t.Fatalf("job after completing: %+v %v", job, err)
+	}
+	var usage FilamentUsage
+	if value, err := leader.Store.Get(usageKeyPrefix + "j1"); err != nil || json.Unmarshal([]byte(value), &usage) != nil ||
+		usage.Cost != 2.06 || usage.WeightInGrams != 82.5 || usage.FilamentType != "PLA" || usage.PrinterID != "p1" {
+		t.Fatalf("usage after completing: %+v %v", usage, err)
+	}
+}
+
+func TestCostReport(t *testing.T) {
+	c := testsupport.NewCluster(t, 1)
+	leader := c.WaitForLeader(10 * time.Second)
+	s := NewServer("", leader.Store)
+
+	put := func(key string, v interface{}) {
+		body, _ := json.Marshal(v)
+		if err := leader.Store.Set(key, string(body)); err != nil {
+			t.Fatalf("set %s: %s", key, err)
+		}
+	}
+	usage := func(job, printer, typ string, grams, cost float64, at string) {
+		recordedAt, _ := time.Parse(time.RFC3339, at)
+		put(usageKeyPrefix+job, FilamentUsage{PrintJobID: job, FilamentID: "f-" + typ, PrinterID: printer, FilamentType: typ,
+			WeightInGrams: grams, Cost: cost, RecordedAt: recordedAt})
+	}
+	usage("j1", "p1", "PLA", 100, 2.5, "2024-05-03T10:00:00Z")
+	usage("j2", "p2", "PETG", 50, 1.75, "2024-05-20T10:00:00Z")
+	usage("j3", "p1", "PLA", 200, 5, "2024-06-01T00:00:00Z")
+	usage("j4", "p1", "PETG", 20, 0.7, "2024-06-30T23:59:59Z")
+
+	report := func(query string) CostReport {
+		t.Helper()
+		w := httptest.NewRecorder()
+		s.handleCostReport(w, httptest.NewRequest(http.MethodGet, "/api/v1/reports/costs"+query, nil))
+		var report CostReport
+		if w.Code != http.StatusOK || json.Unmarshal(w.Body.Bytes(), &report) != nil {
+			t.Fatalf("report%s: %d %s", query, w.Code, w.Body)
+		}
+		return report
+	}
+
+	got := report("")
+	if got.Period != "month" || got.Total != (CostSummary{Jobs: 4, WeightInGrams: 370, Cost: 9.95}) {
+		t.Fatalf("total: %s %+v", got.Period, got.Total)
+	}
+	if got.ByPeriod["2024-05"] != (CostSummary{Jobs: 2, WeightInGrams: 150, Cost: 4.25}) ||
+		got.ByPeriod["2024-06"] != (CostSummary{Jobs: 2, WeightInGrams: 220, Cost: 5.7}) || len(got.ByPeriod) != 2 {
+		t.Fatalf("by month: %+v", got.ByPeriod)
+	}
+	if got.ByPrinter["p1"] != (CostSummary{Jobs: 3, WeightInGrams: 320, Cost: 8.2}) || got.ByFilamentType["PETG"] != (CostSummary{Jobs: 2, WeightInGrams: 70, Cost: 2.45}) {
+		t.Fatalf("by printer %+v, by type %+v", got.ByPrinter, got.ByFilamentType)
+	}
+
+	// The range includes from and excludes to
+	got = report("?from=2024-06-01&to=2024-06-30T23:59:59Z&period=week")
+	if got.Total != (CostSummary{Jobs: 1, WeightInGrams: 200, Cost: 5}) || got.ByPeriod["2024-W22"].Jobs != 1 || len(got.ByPeriod) != 1 {
+		t.Fatalf("June without its last second: %+v", got)
+	}
+
+	for _, query := range []string{"?period=year", "?from=June"} {
+		w := httptest.NewRecorder()
+		s.handleCostReport(w, httptest.NewRequest(http.MethodGet, "/api/v1/reports/costs"+query, nil))
+		if w.Code != http.StatusBadRequest {
+			t.Fatalf("%s: %d %s", query, w.Code, w.Body)
+		}
+	}
+}
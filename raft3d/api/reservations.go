@@ -0,0 +1,258 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"raft3d/raft"
+)
+
+// defaultCalendarRange is shown when ?to= isn't given
+const defaultCalendarRange = 7 * 24 * time.Hour
+
+// handleReservations handles GET/POST /reservations and
+// GET/DELETE /reservations/{id}. The list can be filtered by ?printer_id=.
+// The FSM rejects a reservation overlapping another of the same printer.
+func (s *Server) handleReservations(w http.ResponseWriter, r *http.Request) {
+	id := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/v1/reservations"), "/")
+
+	switch {
+	case id == "" && r.Method == http.MethodGet:
+		reservations, err := s.listReservations(r.URL.Query().Get("printer_id"))
+		if err != nil {
+			s.writeStoreError(w, r, err, "Failed to retrieve reservations")
+			return
+		}
+		writeList(w, r, reservations)
+	case id == "" && r.Method == http.MethodPost:
+		s.handlePostReservation(w, r)
+	case id != "" && r.Method == http.MethodGet:
+		value, err := s.store.Get(raft.ReservationPrefix + id)
+		if err != nil {
+			s.writeStoreError(w, r, err, "Reservation not found")
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(value))
+	case id != "" && r.Method == http.MethodDelete:
+		s.handleDeleteReservation(w, r, id)
+	default:
+		methodNotAllowed(w, r)
+	}
+}
+
+// handlePostReservation books a printer for a time slot
+func (s *Server) handlePostReservation(w http.ResponseWriter, r *http.Request) {
+	var reservation Reservation
+	if !decodeJSON(w, r, &reservation) {
+		return
+	}
+	if !reservation.End.After(reservation.Start) {
+		writeValidationProblem(w, r, []FieldError{{Name: "end", Reason: "must be after start"}})
+		return
+	}
+	if _, err := s.getPrinter(reservation.PrinterID); err != nil {
+		writeValidationProblem(w, r, []FieldError{{Name: "printer_id", Reason: "printer does not exist"}})
+		return
+	}
+	if principal, ok := principalFrom(r); ok {
+		reservation.ReservedBy = principal.Name
+	}
+	reservation.Start, reservation.End = reservation.Start.UTC(), reservation.End.UTC()
+	reservation.CreatedAt = time.Now().UTC()
+
+	body, err := json.Marshal(reservation)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, CodeInternal, "Failed to process reservation data")
+		return
+	}
+	stored, err := s.storeNew(r, raft.ReservationPrefix, reservation.ID, string(body))
+	if err != nil {
+		s.writeStoreError(w, r, err, "Failed to store reservation")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	w.Write([]byte(stored))
+}
+
+// handleDeleteReservation cancels a reservation. With auth enabled only its
+// holder or an admin may.
+func (s *Server) handleDeleteReservation(w http.ResponseWriter, r *http.Request, id string) {
+	value, err := s.store.Get(raft.ReservationPrefix + id)
+	if err != nil {
+		s.writeStoreError(w, r, err, "Reservation not found")
+		return
+	}
+	var reservation Reservation
+	if err := json.Unmarshal([]byte(value), &reservation); err != nil {
+		writeError(w, r, http.StatusInternalServerError, CodeInternal, "Failed to parse reservation data")
+		return
+	}
+	if principal, _ := principalFrom(r); s.authEnabled() && principal.Role != RoleAdmin && !reservation.heldBy(principal.Name) {
+		writeError(w, r, http.StatusForbidden, CodeForbidden, "Only the holder or an admin can cancel a reservation")
+		return
+	}
+	if err := s.storeFor(r).Delete(raft.ReservationPrefix + id); err != nil {
+		s.writeStoreError(w, r, err, "Failed to delete reservation")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// heldBy reports whether user holds the reservation
+func (r Reservation) heldBy(user string) bool {
+	return r.ReservedBy != "" && r.ReservedBy == user
+}
+
+// listReservations returns the reservations, of one printer if printerID is
+// set, ordered by start
+func (s *Server) listReservations(printerID string) ([]Reservation, error) {
+	all, err := loadAll[Reservation](s, raft.ReservationPrefix)
+	if err != nil {
+		return nil, err
+	}
+	reservations := []Reservation{}
+	for _, reservation := range all {
+		if printerID == "" || reservation.PrinterID == printerID {
+			reservations = append(reservations, reservation)
+		}
+	}
+	sort.Slice(reservations, func(i, j int) bool { return reservations[i].Start.Before(reservations[j].Start) })
+	return reservations, nil
+}
+
+// printerReservedProblem returns a conflict when the job's printer is
+// reserved at now by someone other than the job's submitter
+func (s *Server) printerReservedProblem(job PrintJob, now time.Time) (*Problem, error) {
+	if job.PrinterID == "" {
+		return nil, nil
+	}
+	reservations, err := s.listReservations(job.PrinterID)
+	if err != nil {
+		return nil, err
+	}
+	for _, reservation := range reservations {
+		if reservation.heldBy(job.SubmittedBy) || now.Before(reservation.Start) || !now.Before(reservation.End) {
+			continue
+		}
+		return errorProblem(http.StatusConflict, CodePrinterReserved,
+			fmt.Sprintf("Printer %s is reserved until %s by reservation %s", job.PrinterID, reservation.End.Format(time.RFC3339), reservation.ID)), nil
+	}
+	return nil, nil
+}
+
+// handlePrinterCalendar handles GET /printers/{id}/calendar, merging the
+// printer's reservations, maintenance windows and print jobs that overlap
+// ?from= and ?to= (default now to a week from now). Queued and Running jobs
+// are shown at their estimated times.
+func (s *Server) handlePrinterCalendar(w http.ResponseWriter, r *http.Request, printerID string) {
+	calendar, ok := s.printerCalendar(w, r, printerID)
+	if !ok {
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(calendar)
+}
+
+// printerCalendar builds the calendar of a printer for a request, writing
+// an error response and returning false if it can't
+func (s *Server) printerCalendar(w http.ResponseWriter, r *http.Request, printerID string) (PrinterCalendar, bool) {
+	now := time.Now().UTC()
+	var errs []FieldError
+	from, err := parseReportTime(r.URL.Query().Get("from"))
+	if err != nil {
+		errs = append(errs, FieldError{Name: "from", Reason: err.Error()})
+	}
+	to, err := parseReportTime(r.URL.Query().Get("to"))
+	if err != nil {
+		errs = append(errs, FieldError{Name: "to", Reason: err.Error()})
+	}
+	if len(errs) > 0 {
+		writeValidationProblem(w, r, errs)
+		return PrinterCalendar{}, false
+	}
+	calendar := PrinterCalendar{PrinterID: printerID, From: now, Entries: []CalendarEntry{}}
+	if from != nil {
+		calendar.From = *from
+	}
+	calendar.To = calendar.From.Add(defaultCalendarRange)
+	if to != nil {
+		calendar.To = *to
+	}
+	if !calendar.To.After(calendar.From) {
+		writeValidationProblem(w, r, []FieldError{{Name: "to", Reason: "must be after from"}})
+		return PrinterCalendar{}, false
+	}
+
+	if _, err := s.getPrinter(printerID); err != nil {
+		s.writeStoreError(w, r, err, "Printer not found")
+		return PrinterCalendar{}, false
+	}
+	reservations, err := s.listReservations(printerID)
+	if err != nil {
+		s.writeStoreError(w, r, err, "Failed to retrieve reservations")
+		return PrinterCalendar{}, false
+	}
+	windows, err := s.listMaintenanceWindows(printerID)
+	if err != nil {
+		s.writeStoreError(w, r, err, "Failed to retrieve maintenance windows")
+		return PrinterCalendar{}, false
+	}
+	jobs, err := s.listPrintJobs()
+	if err != nil {
+		s.writeStoreError(w, r, err, "Failed to retrieve print jobs")
+		return PrinterCalendar{}, false
+	}
+
+	add := func(entry CalendarEntry) {
+		if entry.Start.Before(calendar.To) && calendar.From.Before(entry.End) {
+			calendar.Entries = append(calendar.Entries, entry)
+		}
+	}
+	for _, reservation := range reservations {
+		title := "Reserved"
+		if reservation.Purpose != "" {
+			title += ": " + reservation.Purpose
+		}
+		add(CalendarEntry{Type: "reservation", ID: reservation.ID, Start: reservation.Start, End: reservation.End,
+			Title: title, Owner: reservation.ReservedBy})
+	}
+	for _, window := range windows {
+		title := "Maintenance"
+		if window.Reason != "" {
+			title += ": " + window.Reason
+		}
+		add(CalendarEntry{Type: "maintenance", ID: window.ID, Start: window.Start, End: window.End, Title: title})
+	}
+
+	var forPrinter []PrintJob
+	for _, job := range jobs {
+		if job.PrinterID == printerID {
+			forPrinter = append(forPrinter, job)
+		}
+	}
+	estimates := estimateJobsAround(forPrinter, reservations, now)
+	for _, job := range forPrinter {
+		entry := CalendarEntry{Type: "print_job", ID: job.ID, Title: "Print job " + job.ID, Status: job.Status, Owner: job.SubmittedBy}
+		if estimate, ok := estimates[job.ID]; ok {
+			entry.Start, entry.End, entry.Estimated = estimate.start, estimate.completion, true
+		} else if job.StartedAt != nil && job.CompletedAt != nil {
+			entry.Start, entry.End = *job.StartedAt, *job.CompletedAt
+		} else {
+			continue
+		}
+		add(entry)
+	}
+
+	sort.SliceStable(calendar.Entries, func(i, j int) bool {
+		a, b := calendar.Entries[i], calendar.Entries[j]
+		return a.Start.Before(b.Start) || (a.Start.Equal(b.Start) && a.ID < b.ID)
+	})
+	return calendar, true
+}
@@ -0,0 +1,105 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"raft3d/testsupport"
+)
+
+func TestPrinterReservations(t *testing.T) {
+	c := testsupport.NewCluster(t, 1)
+	leader := c.WaitForLeader(10 * time.Second)
+	s := NewServer("", leader.Store)
+	s.EnableAuth([]APIKey{{Key: "x", Principal: Principal{Name: "root", Role: RoleAdmin}}})
+
+	now := time.Now().UTC().Truncate(time.Second)
+	for key, v := range map[string]interface{}{
+		"printer_p1":       Printer{ID: "p1", Name: "Prusa", Status: "Idle"},
+		"printjob_alice1":  PrintJob{ID: "alice1", PrinterID: "p1", FilamentID: "f1", FilePath: "a.gcode", PrintWeightInGrams: 15, Status: "Queued", SubmittedBy: "alice", CreatedAt: now},
+		"printjob_bob1":    PrintJob{ID: "bob1", PrinterID: "p1", FilamentID: "f1", FilePath: "b.gcode", PrintWeightInGrams: 15, Status: "Queued", SubmittedBy: "bob", CreatedAt: now},
+		"maintwindow_m1":   MaintenanceWindow{ID: "m1", PrinterID: "p1", Start: now.Add(48 * time.Hour), End: now.Add(50 * time.Hour), Reason: "nozzle"},
+		"maintwindow_late": MaintenanceWindow{ID: "late", PrinterID: "p1", Start: now.Add(30 * 24 * time.Hour), End: now.Add(31 * 24 * time.Hour)},
+	} {
+		body, _ := json.Marshal(v)
+		if err := leader.Store.Set(key, string(body)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	do := func(user, role, method, url, body string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, url, strings.NewReader(body))
+		r = r.WithContext(context.WithValue(r.Context(), principalKey{}, Principal{Name: user, Role: role}))
+		rec := httptest.NewRecorder()
+		switch {
+		case strings.HasPrefix(url, "/api/v1/reservations"):
+			s.handleReservations(rec, r)
+		case strings.HasPrefix(url, "/api/v1/printers"):
+			s.handlePrinters(rec, r)
+		default:
+			s.handlePrintJobs(rec, r)
+		}
+		return rec
+	}
+	reserve := func(user string, start, end time.Duration) *httptest.ResponseRecorder {
+		body, _ := json.Marshal(Reservation{PrinterID: "p1", Start: now.Add(start), End: now.Add(end), Purpose: "thesis"})
+		return do(user, RoleMember, http.MethodPost, "/api/v1/reservations", string(body))
+	}
+
+	rec := reserve("alice", -time.Hour, 2*time.Hour)
+	var held Reservation
+	if rec.Code != http.StatusCreated || json.NewDecoder(rec.Body).Decode(&held) != nil || held.ID == "" || held.ReservedBy != "alice" {
+		t.Fatalf("reserve: %d %s", rec.Code, rec.Body)
+	}
+	rec = reserve("bob", time.Hour, 3*time.Hour)
+	if rec.Code != http.StatusConflict || !strings.Contains(rec.Body.String(), CodeReservationConflict) {
+		t.Fatalf("overlapping reservation: %d %s", rec.Code, rec.Body)
+	}
+	if rec := reserve("bob", 2*time.Hour, 3*time.Hour); rec.Code != http.StatusCreated {
+		t.Fatalf("following reservation: %d %s", rec.Code, rec.Body)
+	}
+
+	// Only the holder's jobs start during the reservation
+	rec = do("bob", RoleOperator, http.MethodPost, "/api/v1/print_jobs/bob1/status?status=Running", "")
+	if rec.Code != http.StatusConflict || !strings.Contains(rec.Body.String(), CodePrinterReserved) {
+		t.Fatalf("bob started: %d %s", rec.Code, rec.Body)
+	}
+
+	rec = do("alice", RoleMember, http.MethodGet, "/api/v1/printers/p1/calendar", "")
+	var calendar PrinterCalendar
+	if rec.Code != http.StatusOK || json.NewDecoder(rec.Body).Decode(&calendar) != nil {
+		t.Fatalf("calendar: %d %s", rec.Code, rec.Body)
+	}
+	var order []string
+	for _, entry := range calendar.Entries {
+		order = append(order, entry.Type+":"+entry.ID)
+		// bob's job is estimated after both reservations, bob's own included
+		if entry.ID == "bob1" && (!entry.Estimated || entry.Start.Before(now.Add(2*time.Hour))) {
+			t.Errorf("bob's job estimated during alice's reservation: %+v", entry)
+		}
+	}
+	if len(order) != 5 || order[0] != "reservation:"+held.ID || order[1] != "print_job:alice1" || order[4] != "maintenance:m1" {
+		t.Fatalf("calendar entries: %v", order)
+	}
+
+	if rec := do("alice", RoleOperator, http.MethodPost, "/api/v1/print_jobs/alice1/status?status=Running", ""); rec.Code != http.StatusOK {
+		t.Fatalf("alice start: %d %s", rec.Code, rec.Body)
+	}
+	if rec := do("bob", RoleMember, http.MethodDelete, "/api/v1/reservations/"+held.ID, ""); rec.Code != http.StatusForbidden {
+		t.Fatalf("bob canceled alice's reservation: %d", rec.Code)
+	}
+	if rec := do("alice", RoleMember, http.MethodDelete, "/api/v1/reservations/"+held.ID, ""); rec.Code != http.StatusNoContent {
+		t.Fatalf("cancel: %d %s", rec.Code, rec.Body)
+	}
+	if rec := do("alice", RoleMember, http.MethodGet, "/api/v1/printers/p1/calendar?from=x", ""); rec.Code != http.StatusBadRequest {
+		t.Fatalf("bad from: %d", rec.Code)
+	}
+	if rec := do("alice", RoleMember, http.MethodGet, "/api/v1/printers/nope/calendar", ""); rec.Code != http.StatusNotFound {
+		t.Fatalf("unknown printer: %d", rec.Code)
+	}
+}
@@ -0,0 +1,323 @@
+package api
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"raft3d/raft"
+)
+
+// EventJobsArchived is published after the leader archives old print jobs
+const EventJobsArchived = "print_job.archived"
+
+// retentionPolicyKey holds the replicated retention policy
+const retentionPolicyKey = "retention_policy"
+
+// retentionInterval is how often the leader applies the retention policy
+const retentionInterval = time.Hour
+
+// retentionBatchSize caps the number of jobs removed by one log entry
+const retentionBatchSize = 500
+
+// retentionAttempts is how many times a batch is removed before the jobs in
+// it that keep changing are left for the next run
+const retentionAttempts = 3
+
+// EnableJobArchive sets where archived print jobs are written before they
+// are removed. Without it the retention policy is not applied on this node.
+func (s *Server) EnableJobArchive(target raft.BackupTarget) {
+	s.jobArchive = target
+}
+
+// handleRetention handles GET and PUT /api/v1/admin/retention, and
+// POST /api/v1/admin/retention/run to apply the policy immediately.
+// /api/v1/admin/retention/changelog sets how much job history is kept.
+func (s *Server) handleRetention(w http.ResponseWriter, r *http.Request) {
+	action := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/v1/admin/retention"), "/")
+
+	switch {
+	case action == "" && r.Method == http.MethodGet:
+		policy, err := s.getRetentionPolicy()
+		if err != nil && !errors.Is(err, raft.ErrNotFound) {
+			s.writeStoreError(w, r, err, "Failed to retrieve retention policy")
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(policy)
+	case action == "" && r.Method == http.MethodPut:
+		if !s.requireRole(w, r, RoleAdmin) {
+			return
+		}
+		s.handlePutRetention(w, r)
+	case action == "run" && r.Method == http.MethodPost:
+		if !s.requireRole(w, r, RoleAdmin) {
+			return
+		}
+		s.handleRunRetention(w, r)
+	case action == "changelog":
+		s.handleChangelogRetention(w, r)
+	case action == "" || action == "run":
+		methodNotAllowed(w, r)
+	default:
+		writeError(w, r, http.StatusNotFound, CodeNotFound, "Unknown retention action")
+	}
+}
+
+// handlePutRetention replaces the retention policy, keeping its last run time
+func (s *Server) handlePutRetention(w http.ResponseWriter, r *http.Request) {
+	var policy RetentionPolicy
+	if !decodeJSON(w, r, &policy) {
+		return
+	}
+	for _, status := range policy.Statuses {
+		if status != "Done" && status != "Failed" && status != "Canceled" {
+			writeValidationProblem(w, r, []FieldError{{Name: "statuses", Reason: "must only contain Done, Failed or Canceled"}})
+			return
+		}
+	}
+	if len(policy.Statuses) == 0 {
+		policy.Statuses = []string{"Done", "Failed", "Canceled"}
+	}
+
+	current, err := s.getRetentionPolicy()
+	if err != nil && !errors.Is(err, raft.ErrNotFound) {
+		s.writeStoreError(w, r, err, "Failed to retrieve retention policy")
+		return
+	}
+	policy.LastRunAt = current.LastRunAt
+
+	body, err := json.Marshal(policy)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, CodeInternal, "Failed to process retention policy")
+		return
+	}
+	if err := s.storeFor(r).Set(retentionPolicyKey, string(body)); err != nil {
+		s.writeStoreError(w, r, err, "Failed to store retention policy")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(body)
+}
+
+// handleRunRetention applies the retention policy now. It must run on the
+// leader, which is the node that owns the archive writes.
+func (s *Server) handleRunRetention(w http.ResponseWriter, r *http.Request) {
+	if !s.store.IsLeader() {
+		s.writeNotLeader(w, r)
+		return
+	}
+	if s.jobArchive == nil {
+		writeError(w, r, http.StatusConflict, CodeConflict, "No job archive is configured on this node; start it with -job-archive")
+		return
+	}
+	policy, err := s.getRetentionPolicy()
+	if err != nil {
+		s.writeStoreError(w, r, err, "No retention policy is set")
+		return
+	}
+	if policy.ArchiveAfterDays == 0 {
+		writeError(w, r, http.StatusConflict, CodeConflict, "The retention policy is disabled")
+		return
+	}
+
+	result, err := s.archiveJobs(policy, time.Now().UTC())
+	if err != nil {
+		s.writeStoreError(w, r, err, "Failed to archive print jobs")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+// getRetentionPolicy loads the retention policy. A missing policy is
+// returned as a disabled one together with ErrNotFound.
+func (s *Server) getRetentionPolicy() (RetentionPolicy, error) {
+	policy := RetentionPolicy{Statuses: []string{"Done", "Failed", "Canceled"}}
+	value, err := s.store.Get(retentionPolicyKey)
+	if err != nil {
+		return policy, err
+	}
+	err = json.Unmarshal([]byte(value), &policy)
+	return policy, err
+}
+
+// applyRetention runs the retention policy if it is enabled and hasn't run
+// within retentionInterval. The last run time is replicated, so a new leader
+// picks up the schedule where the old one left off.
+func (s *Server) applyRetention(now time.Time) {
+	if s.jobArchive == nil {
+		return
+	}
+	policy, err := s.getRetentionPolicy()
+	if err != nil || policy.ArchiveAfterDays == 0 {
+		return
+	}
+	if policy.LastRunAt != nil && now.Sub(*policy.LastRunAt) < retentionInterval {
+		return
+	}
+
+	result, err := s.archiveJobs(policy, now)
+	if err != nil {
+		log.Printf("Retention: failed to archive print jobs: %s", err)
+		return
+	}
+	if result.Archived > 0 {
+		log.Printf("Retention: archived %d print jobs to %s", result.Archived, result.Archive)
+	}
+}
+
+// retentionCandidates returns the finished jobs older than the policy allows,
+// skipping jobs that active jobs still depend on
+func retentionCandidates(jobs []PrintJob, policy RetentionPolicy, now time.Time) []PrintJob {
+	cutoff := now.AddDate(0, 0, -policy.ArchiveAfterDays)
+	statuses := make(map[string]bool)
+	for _, status := range policy.Statuses {
+		statuses[status] = true
+	}
+	needed := make(map[string]bool)
+	for _, job := range jobs {
+		if job.isActive() {
+			for _, dep := range job.DependsOn {
+				needed[dep] = true
+			}
+		}
+	}
+
+	var expired []PrintJob
+	for _, job := range jobs {
+		if statuses[job.Status] && !needed[job.ID] && job.finishedAt().Before(cutoff) {
+			expired = append(expired, job)
+		}
+	}
+	sort.Slice(expired, func(i, j int) bool { return expired[i].ID < expired[j].ID })
+	return expired
+}
+
+// finishedAt returns the best known time a job reached its final status
+func (j PrintJob) finishedAt() time.Time {
+	switch {
+	case j.CompletedAt != nil:
+		return *j.CompletedAt
+	case j.StartedAt != nil:
+		return *j.StartedAt
+	}
+	return j.CreatedAt
+}
+
+// archiveJobs writes expired jobs to the archive as gzipped JSON lines, which
+// the archive target encrypts when at-rest encryption is on, and then
+// removes them in batches. The archive is written first, so a failed removal
+// leaves jobs that are archived again on the next run rather than jobs that
+// are lost. Jobs that change while the run is in progress are kept; a batch
+// that keeps conflicting doesn't stop the run or its LastRunAt from being
+// recorded.
+func (s *Server) archiveJobs(policy RetentionPolicy, now time.Time) (RetentionResult, error) {
+	result := RetentionResult{RanAt: now}
+
+	jobs, err := s.listPrintJobs()
+	if err != nil {
+		return result, err
+	}
+	expired := retentionCandidates(jobs, policy, now)
+
+	if len(expired) > 0 {
+		var buf bytes.Buffer
+		gz := gzip.NewWriter(&buf)
+		enc := json.NewEncoder(gz)
+		for _, job := range expired {
+			if err := enc.Encode(job); err != nil {
+				return result, err
+			}
+		}
+		if err := gz.Close(); err != nil {
+			return result, err
+		}
+
+		name := fmt.Sprintf("printjobs-%s.jsonl.gz", now.Format("20060102T150405Z"))
+		if err := s.jobArchive.Write(name, &buf); err != nil {
+			return result, fmt.Errorf("failed to write archive %s: %w", name, err)
+		}
+		result.Archive = name
+
+		for start := 0; start < len(expired); start += retentionBatchSize {
+			end := start + retentionBatchSize
+			if end > len(expired) {
+				end = len(expired)
+			}
+			removed, err := s.removeArchivedJobs(expired[start:end])
+			if errors.Is(err, raft.ErrConflict) {
+				log.Printf("Retention: print jobs kept changing, leaving them for the next run: %s", err)
+			} else if err != nil {
+				return result, err
+			}
+			result.Archived += removed
+		}
+
+		s.publish(EventJobsArchived, map[string]interface{}{
+			"archive": name,
+			"count":   result.Archived,
+		})
+	}
+
+	// Reload the policy so a change made during the run isn't overwritten
+	if current, err := s.getRetentionPolicy(); err == nil {
+		policy = current
+	}
+	policy.LastRunAt = &now
+	body, err := json.Marshal(policy)
+	if err != nil {
+		return result, err
+	}
+	return result, s.store.Set(retentionPolicyKey, string(body))
+}
+
+// removeArchivedJobs deletes a batch of archived jobs in one log entry,
+// provided each still has the status it was archived with. Jobs that changed
+// or were deleted since are dropped from the batch and the rest are tried
+// again. It returns how many jobs were removed.
+func (s *Server) removeArchivedJobs(batch []PrintJob) (int, error) {
+	for attempt := 1; len(batch) > 0; attempt++ {
+		var keys []string
+		var conditions []raft.Condition
+		for _, job := range batch {
+			key := "printjob_" + job.ID
+			keys = append(keys, key)
+			conditions = append(conditions, raft.Condition{Key: key, Field: "status", Equals: job.Status})
+		}
+		err := s.store.DeleteMany(keys, conditions...)
+		if err == nil {
+			return len(batch), nil
+		}
+		if !errors.Is(err, raft.ErrConflict) || attempt == retentionAttempts {
+			return 0, err
+		}
+		batch = s.unchangedJobs(batch)
+	}
+	return 0, nil
+}
+
+// unchangedJobs returns the jobs that are still stored with the same status
+func (s *Server) unchangedJobs(jobs []PrintJob) []PrintJob {
+	var unchanged []PrintJob
+	for _, job := range jobs {
+		value, err := s.store.Get("printjob_" + job.ID)
+		if err != nil {
+			continue
+		}
+		var current PrintJob
+		if json.Unmarshal([]byte(value), &current) == nil && current.Status == job.Status {
+			unchanged = append(unchanged, job)
+		}
+	}
+	return unchanged
+}
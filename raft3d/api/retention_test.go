@@ -0,0 +1,65 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"raft3d/raft"
+	"raft3d/testsupport"
+)
+
+// reopeningStore requeues a job just before the first batch removal lands
+type reopeningStore struct {
+	raft.Store
+	reopen func()
+}
+
+func (s *reopeningStore) DeleteMany(keys []string, conditions ...raft.Condition) error {
+	if s.reopen != nil {
+		s.reopen()
+		s.reopen = nil
+	}
+	return s.Store.DeleteMany(keys, conditions...)
+}
+
+func TestArchiveJobsKeepsJobsThatChanged(t *testing.T) {
+	c := testsupport.NewCluster(t, 1)
+	leader := c.WaitForLeader(10 * time.Second)
+
+	finished := time.Now().AddDate(0, 0, -30)
+	for _, id := range []string{"j1", "j2", "j3"} {
+		body, _ := json.Marshal(PrintJob{ID: id, PrinterID: "p1", FilamentID: "f1", FilePath: "part.gcode", Status: "Done", CompletedAt: &finished})
+		leader.Store.Set("printjob_"+id, string(body))
+	}
+	policy, _ := json.Marshal(RetentionPolicy{ArchiveAfterDays: 7, Statuses: []string{"Done"}})
+	leader.Store.Set(retentionPolicyKey, string(policy))
+
+	store := &reopeningStore{Store: leader.Store, reopen: func() {
+		body, _ := json.Marshal(PrintJob{ID: "j2", PrinterID: "p1", FilamentID: "f1", FilePath: "part.gcode", Status: "Queued"})
+		leader.Store.Set("printjob_j2", string(body))
+	}}
+	s := NewServer("", store)
+	archive, err := raft.NewDirBackupTarget(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.EnableJobArchive(archive)
+
+	now := time.Now().UTC()
+	s.applyRetention(now)
+
+	for id, kept := range map[string]bool{"j1": false, "j2": true, "j3": false} {
+		_, err := leader.Store.Get("printjob_" + id)
+		if kept && err != nil {
+			t.Errorf("%s was requeued but removed", id)
+		}
+		if !kept && !errors.Is(err, raft.ErrNotFound) {
+			t.Errorf("%s is still stored: %v", id, err)
+		}
+	}
+	if current, err := s.getRetentionPolicy(); err != nil || current.LastRunAt == nil || !current.LastRunAt.Equal(now) {
+		t.Fatalf("last run not recorded: %+v, %v", current, err)
+	}
+}
@@ -0,0 +1,185 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"sort"
+	"time"
+
+	"raft3d/raft"
+)
+
+// Event types published by the scheduler
+const EventDeadlineMissed = "print_job.deadline_missed"
+
+// schedulerInterval is how often the leader checks job deadlines, templates
+// and the retention policy
+const schedulerInterval = 15 * time.Second
+
+// listPrintJobs returns every stored print job
+func (s *Server) listPrintJobs() ([]PrintJob, error) {
+	return listPrintJobsIn(s.store)
+}
+
+// listPrintJobsIn reads every print job from store
+func listPrintJobsIn(store raft.Store) ([]PrintJob, error) {
+	keys, err := store.List("printjob_")
+	if err != nil {
+		return nil, err
+	}
+
+	var jobs []PrintJob
+	for _, key := range keys {
+		value, err := store.Get(key)
+		if err != nil {
+			continue
+		}
+		var printJob PrintJob
+		if err := json.Unmarshal([]byte(value), &printJob); err != nil {
+			continue
+		}
+		jobs = append(jobs, printJob)
+	}
+	return jobs, nil
+}
+
+// isActive reports whether a job is still waiting or printing
+func (j PrintJob) isActive() bool {
+	return j.Status == "Queued" || j.Status == "Running"
+}
+
+// isOverdue reports whether an active job is past its deadline
+func (j PrintJob) isOverdue(now time.Time) bool {
+	return j.isActive() && j.DueBy != nil && j.DueBy.Before(now)
+}
+
+// queueOrder sorts queued jobs in the order the scheduler would start them:
+// earliest deadline first, then jobs without a deadline in submission order
+func queueOrder(jobs []PrintJob) []PrintJob {
+	var queued []PrintJob
+	for _, job := range jobs {
+		if job.Status == "Queued" {
+			queued = append(queued, job)
+		}
+	}
+
+	sort.SliceStable(queued, func(i, j int) bool {
+		a, b := queued[i], queued[j]
+		switch {
+		case a.DueBy != nil && b.DueBy != nil && !a.DueBy.Equal(*b.DueBy):
+			return a.DueBy.Before(*b.DueBy)
+		case a.DueBy != nil && b.DueBy == nil:
+			return true
+		case a.DueBy == nil && b.DueBy != nil:
+			return false
+		}
+		return a.CreatedAt.Before(b.CreatedAt) || (a.CreatedAt.Equal(b.CreatedAt) && a.ID < b.ID)
+	})
+	return queued
+}
+
+// handlePrinterQueue handles GET /printers/{id}/queue, listing the printer's
+// queued jobs in scheduling order
+func (s *Server) handlePrinterQueue(w http.ResponseWriter, r *http.Request, printerID string) {
+	store, _ := s.readStore(r)
+	if _, err := store.Get("printer_" + printerID); err != nil {
+		s.writeStoreError(w, r, err, "Printer not found")
+		return
+	}
+
+	jobs, err := listPrintJobsIn(store)
+	if err != nil {
+		s.writeStoreError(w, r, err, "Failed to retrieve print jobs")
+		return
+	}
+
+	reservations, err := s.listReservations(printerID)
+	if err != nil {
+		s.writeStoreError(w, r, err, "Failed to retrieve reservations")
+		return
+	}
+
+	var forPrinter []PrintJob
+	for _, job := range jobs {
+		if job.PrinterID == printerID {
+			forPrinter = append(forPrinter, job)
+		}
+	}
+	estimates := estimateJobsAround(forPrinter, reservations, time.Now().UTC())
+	queue := []PrintJob{}
+	for _, job := range queueOrder(forPrinter) {
+		queue = append(queue, job.withEstimate(estimates))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(queue)
+}
+
+// runScheduler periodically performs leader-only scheduling duties until the
+// server stops
+func (s *Server) runScheduler() {
+	ticker := time.NewTicker(schedulerInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if s.store.IsLeader() {
+				now := time.Now().UTC()
+				s.flagMissedDeadlines(now)
+				s.instantiateDueTemplates(now)
+				s.applyRetention(now)
+			}
+		case <-s.stopCh:
+			return
+		}
+	}
+}
+
+// flagMissedDeadlines marks active jobs that passed their deadline. The flag
+// is written through Raft, so each miss is reported once by whichever node
+// is leader at the time.
+func (s *Server) flagMissedDeadlines(now time.Time) {
+	jobs, err := s.listPrintJobs()
+	if err != nil {
+		log.Printf("Scheduler: failed to list print jobs: %s", err)
+		return
+	}
+
+	for _, job := range jobs {
+		if job.DeadlineMissed || !job.isOverdue(now) {
+			continue
+		}
+		job.DeadlineMissed = true
+		body, err := json.Marshal(job)
+		if err != nil {
+			continue
+		}
+		// Only flag the job as it was listed; one that finished or was
+		// cancelled meanwhile is left alone and looked at again next tick
+		key := "printjob_" + job.ID
+		err = s.store.SetIf(key, string(body), raft.Condition{Key: key, Field: "status", Equals: job.Status})
+		if errors.Is(err, raft.ErrConflict) {
+			continue
+		}
+		if err != nil {
+			log.Printf("Scheduler: failed to flag missed deadline of %s: %s", job.ID, err)
+			return
+		}
+		s.publish(EventDeadlineMissed, map[string]interface{}{
+			"print_job_id": job.ID,
+			"printer_id":   job.PrinterID,
+			"due_by":       job.DueBy,
+			"status":       job.Status,
+		})
+	}
+}
+
+// publish sends an event to the event bus if one is configured
+func (s *Server) publish(typ string, data interface{}) {
+	if s.events != nil {
+		s.events.Publish(typ, data)
+	}
+}
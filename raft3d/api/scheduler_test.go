@@ -0,0 +1,56 @@
+package api
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"raft3d/raft"
+	"raft3d/testsupport"
+)
+
+// racingStore completes a job just before the scheduler's write lands
+type racingStore struct {
+	raft.Store
+	before func(key string)
+}
+
+func (s racingStore) SetIf(key string, value string, conditions ...raft.Condition) error {
+	s.before(key)
+	return s.Store.SetIf(key, value, conditions...)
+}
+
+func TestFlagMissedDeadlinesSkipsJobsThatChanged(t *testing.T) {
+	c := testsupport.NewCluster(t, 1)
+	leader := c.WaitForLeader(10 * time.Second)
+
+	due := time.Now().Add(-time.Hour)
+	for _, id := range []string{"j1", "j2"} {
+		body, _ := json.Marshal(PrintJob{ID: id, PrinterID: "p1", FilamentID: "f1", FilePath: "part.gcode", Status: "Running", DueBy: &due})
+		leader.Store.Set("printjob_"+id, string(body))
+	}
+	store := racingStore{Store: leader.Store, before: func(key string) {
+		if key != "printjob_j1" {
+			return
+		}
+		body, _ := json.Marshal(PrintJob{ID: "j1", PrinterID: "p1", FilamentID: "f1", FilePath: "part.gcode", Status: "Done", DueBy: &due})
+		leader.Store.Set(key, string(body))
+	}}
+	s := NewServer("", store)
+	s.flagMissedDeadlines(time.Now())
+
+	j1, err := s.getPrintJob("j1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if j1.Status != "Done" || j1.DeadlineMissed {
+		t.Errorf("completed job was overwritten: %+v", j1)
+	}
+	j2, err := s.getPrintJob("j2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !j2.DeadlineMissed {
+		t.Errorf("j2 after a conflict on j1 was not flagged")
+	}
+}
@@ -1,28 +1,116 @@
 package api
 
 import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io/fs"
 	"log"
+	"net"
 	"net/http"
-	"strings"
+	"net/netip"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"raft3d/drivers"
+	"raft3d/events"
 	"raft3d/raft"
 )
 
 // Server represents the API server and its dependencies
 type Server struct {
-	Addr     string
-	store    raft.Store
-	httpSrv  *http.Server
+	Addr          string
+	AdvertiseAddr string // address other nodes reach this server at, if not Addr
+	NodeID        string // this node's ID, which printers name to be driven here
+	store         raft.Store
+	httpSrv       *http.Server
+	chaos         *raft.Chaos
+	events        *events.Bus
+	stopCh        chan struct{}
+	stopOnce      sync.Once
+	workers       sync.WaitGroup // background goroutines Shutdown waits for
+	serving       atomic.Bool    // set once the listener is being served
+
+	authMutex    sync.RWMutex
+	authRequired bool // set by EnableAuth, cleared only by DisableAuth
+	apiKeys      []APIKey
+
+	allowMutex          sync.RWMutex
+	managementAllowlist []netip.Prefix // empty allows every address
+
+	corsConfig *CORSConfig // optional cross-origin access for browsers
+
+	reload func() (ReloadResult, error) // optional runtime config reload
+
+	jobArchive raft.BackupTarget // optional destination for archived jobs
+	artifacts  raft.BackupTarget // optional store for the content of job artifacts
+	slicer     Slicer            // optional service that slices uploaded models
+	heartbeats *heartbeatMonitor // optional printer heartbeat tracking
+	cameras    *cameraProxy      // optional access to printer cameras
+	telemetry  *telemetryStore   // recent printer telemetry, never replicated
+
+	determinism *determinismAudit // optional comparison of state digests with other nodes
+
+	dryingMaxAge time.Duration // optional limit on time since a hygroscopic spool was dried
+	v1Sunset     time.Time     // announced removal of /api/v1/; zero means defaultV1Sunset
+
+	tls raft.TLSProvider // optional certificates to serve HTTPS with
+
+	notifiers        []Notifier // optional outbox delivery channels
+	filamentLowGrams float64    // remaining weight notified as low
+
+	drivers      map[string]drivers.PrinterDriver // optional printers this node runs jobs on
+	driverAPIKey string                           // authenticates the drivers' calls to the leader
+	joinAPIKey   string                           // authenticates this node's join and promotion requests
+
+	allowVersionSkew bool // admit joiners of incompatible protocol versions
+
+	identity NodeIdentity // what GET /api/v1/node reports
+
+	debugAddr string       // optional loopback address serving /debug without authentication
+	debugSrv  *http.Server // serves debugAddr
+
+	socketMode  fs.FileMode // permissions of a unix:// socket, 0660 by default
+	socketGroup string      // optional group owning a unix:// socket
 }
 
 // NewServer constructs a new API server instance
 func NewServer(addr string, store raft.Store) *Server {
 	return &Server{
-		Addr:  addr,
-		store: store,
+		Addr:      addr,
+		store:     store,
+		stopCh:    make(chan struct{}),
+		telemetry: newTelemetryStore(),
 	}
 }
 
+// EnableChaos registers the fault injection endpoints when the server starts
+func (s *Server) EnableChaos(chaos *raft.Chaos) {
+	s.chaos = chaos
+}
+
+// EnableEvents exposes the event bus through the events endpoints
+func (s *Server) EnableEvents(bus *events.Bus) {
+	s.events = bus
+}
+
+// EnableTLS serves HTTPS with the provider's certificates and joins other
+// nodes over HTTPS. Client certificates are verified when offered.
+func (s *Server) EnableTLS(provider raft.TLSProvider) {
+	s.tls = provider
+}
+
+// scheme returns the URL scheme nodes of this cluster are reached with
+func (s *Server) scheme() string {
+	if s.tls != nil {
+		return "https"
+	}
+	return "http"
+}
+
 // Start starts the HTTP server
 func (s *Server) Start() error {
 	mux := http.NewServeMux()
@@ -30,24 +118,145 @@ func (s *Server) Start() error {
 	// Register all route handlers
 	mux.HandleFunc("/api/v1/printers", s.handlePrinters)
 	mux.HandleFunc("/api/v1/printers/", s.handlePrinters)
-	
+
 	mux.HandleFunc("/api/v1/filaments", s.handleFilaments)
 	mux.HandleFunc("/api/v1/filaments/", s.handleFilaments)
-	
+
 	mux.HandleFunc("/api/v1/print_jobs", s.handlePrintJobs)
 	mux.HandleFunc("/api/v1/print_jobs/", s.handlePrintJobs)
-	
+
+	mux.HandleFunc("/api/v1/printer_groups", s.handlePrinterGroups)
+	mux.HandleFunc("/api/v1/printer_groups/", s.handlePrinterGroups)
+
+	mux.HandleFunc("/api/v1/job_templates", s.handleJobTemplates)
+	mux.HandleFunc("/api/v1/job_templates/", s.handleJobTemplates)
+
+	mux.HandleFunc("/api/v1/maintenance_windows", s.handleMaintenanceWindows)
+	mux.HandleFunc("/api/v1/maintenance_windows/", s.handleMaintenanceWindows)
+
+	mux.HandleFunc("/api/v1/reservations", s.handleReservations)
+	mux.HandleFunc("/api/v1/reservations/", s.handleReservations)
+
+	mux.HandleFunc("/api/v1/components", s.handleComponents)
+	mux.HandleFunc("/api/v1/components/", s.handleComponents)
+
+	mux.HandleFunc("/api/v1/materials", s.handleMaterials)
+	mux.HandleFunc("/api/v1/materials/", s.handleMaterials)
+
+	mux.HandleFunc("/api/v1/vendors", s.handleVendors)
+	mux.HandleFunc("/api/v1/vendors/", s.handleVendors)
+
+	mux.HandleFunc("/api/v1/firmware_campaigns", s.handleFirmwareCampaigns)
+	mux.HandleFunc("/api/v1/firmware_campaigns/", s.handleFirmwareCampaigns)
+
+	mux.HandleFunc("/api/v1/audit", s.handleAudit)
+	mux.HandleFunc("/api/v1/outbox", s.handleOutbox)
+
+	mux.HandleFunc("/api/v1/reports/costs", s.handleCostReport)
+	mux.HandleFunc("/api/v1/reports/usage", s.handleUsageReport)
+	mux.HandleFunc("/api/v1/reports/utilization", s.handleUtilizationReport)
+	mux.HandleFunc("/api/v1/reports/reorder", s.handleReorderReport)
+	mux.HandleFunc("/api/v1/reports/defects", s.handleDefectReport)
+	mux.HandleFunc("/api/v1/stats", s.handleStats)
+	mux.HandleFunc("/api/v1/graphql", s.handleGraphQL)
+	mux.HandleFunc("/api/v1/alert_rules", s.handleAlertRules)
+	mux.HandleFunc("/api/v1/alert_rules/", s.handleAlertRules)
+	mux.HandleFunc("/api/v1/alerts", s.handleAlerts)
+	mux.HandleFunc("/api/v1/alerts/", s.handleAlerts)
+	mux.HandleFunc("/api/v1/quotas", s.handleQuotas)
+	mux.HandleFunc("/api/v1/quotas/", s.handleQuotas)
+	mux.HandleFunc("/api/v1/policies", s.handlePolicies)
+	mux.HandleFunc("/api/v1/policies/", s.handlePolicies)
+
+	mux.HandleFunc("/api/v1/tasks", s.handleTasks)
+	mux.HandleFunc("/api/v1/tasks/", s.handleTasks)
+	mux.HandleFunc("/api/v1/query", s.handleQuery)
+	mux.HandleFunc("/api/v1/slices", s.handleSlices)
+	mux.HandleFunc("/api/v1/slices/", s.handleSlices)
+
+	mux.HandleFunc("/api/v1/backups", s.handleBackups)
+	mux.HandleFunc("/api/v1/node", s.handleNode)
+	mux.HandleFunc("/api/v1/admin/compact", s.handleCompact)
+	mux.HandleFunc("/api/v1/admin/raft/log", s.handleRaftLog)
+	mux.HandleFunc("/api/v1/admin/recovery", s.handleRecovery)
+	mux.HandleFunc("/api/v1/admin/quarantine", s.handleQuarantine)
+	mux.HandleFunc("/api/v1/admin/state-hash", s.handleStateHash)
+	mux.HandleFunc("/api/v1/admin/goroutines", s.handleGoroutines)
+	mux.HandleFunc("/api/v1/admin/retention", s.handleRetention)
+	mux.HandleFunc("/api/v1/admin/approvals", s.handleApprovalPolicy)
+	mux.HandleFunc("/api/v1/admin/duplicate_jobs", s.handleDuplicateJobPolicy)
+	mux.HandleFunc("/api/v1/admin/retention/", s.handleRetention)
+	if s.reload != nil {
+		mux.HandleFunc("/api/v1/admin/reload", s.handleReload)
+	}
+
+	if s.events != nil {
+		mux.HandleFunc("/api/v1/events", s.handleEvents)
+		mux.HandleFunc("/api/v1/events/stream", s.handleEventStream)
+		mux.HandleFunc("/api/v1/cluster/events", s.handleClusterEvents)
+	}
+
+	if s.chaos != nil {
+		mux.HandleFunc("/api/v1/chaos", s.handleChaos)
+		mux.HandleFunc("/api/v1/chaos/", s.handleChaos)
+	}
+
 	mux.HandleFunc("/join", s.handleJoin)
+	mux.HandleFunc("/cluster/nodes/", s.handleClusterNodes)
+	mux.HandleFunc("/cluster/digest", s.handleClusterDigest)
 	mux.HandleFunc("/metrics", s.handleMetrics)
+	mux.Handle("/debug/", s.handleDebug(debugMux()))
+	mux.HandleFunc("/healthz", s.handleHealthz)
+	mux.HandleFunc("/readyz", s.handleReadyz)
+	mux.HandleFunc("/cluster", s.handleCluster)
 
 	s.httpSrv = &http.Server{
 		Addr:    s.Addr,
-		Handler: mux,
+		Handler: s.cors(s.versioned(s.restrictManagement(s.requestDeadline(s.consistency(s.readOnlyGuard(s.authenticate(s.longPoll(s.atIndex(mux))))))))),
+	}
+	if s.tls != nil {
+		s.httpSrv.TLSConfig = s.tls.ServerConfig(tls.VerifyClientCertIfGiven)
+	}
+
+	listener, err := s.listen()
+	if err != nil {
+		return err
+	}
+	if s.debugAddr != "" {
+		if err := s.startDebugListener(); err != nil {
+			listener.Close()
+			return err
+		}
+	}
+
+	s.spawn(s.runScheduler)
+	s.spawn(s.runTasks)
+	if s.heartbeats != nil {
+		s.spawn(s.runHeartbeatMonitor)
+	}
+	if len(s.notifiers) > 0 {
+		s.spawn(s.runOutbox)
+		s.spawn(s.watchNodes)
+	}
+	for printerID, driver := range s.drivers {
+		printerID, driver := printerID, driver
+		s.spawn(func() { s.runDriver(printerID, driver, nil) })
+	}
+	if s.NodeID != "" {
+		s.spawn(s.runStoredDrivers)
+	}
+	if s.determinism != nil {
+		s.spawn(s.runDeterminismAudit)
 	}
 
 	log.Printf("Starting HTTP server at %s\n", s.Addr)
 	go func() {
-		if err := s.httpSrv.ListenAndServe(); err != http.ErrServerClosed {
+		serve := s.httpSrv.Serve
+		if s.tls != nil {
+			serve = func(l net.Listener) error { return s.httpSrv.ServeTLS(l, "", "") }
+		}
+		s.serving.Store(true)
+		if err := serve(listener); !isClosed(err) {
 			log.Fatalf("HTTP server error: %s", err)
 		}
 	}()
@@ -55,8 +264,37 @@ func (s *Server) Start() error {
 	return nil
 }
 
-// Stop gracefully shuts down the HTTP server
+// Ready reports whether the server is accepting requests, saying why not
+// otherwise
+func (s *Server) Ready() error {
+	select {
+	case <-s.stopCh:
+		return errors.New("the HTTP server is stopped")
+	default:
+	}
+	if !s.serving.Load() {
+		return errors.New("the HTTP server is not serving yet")
+	}
+	return nil
+}
+
+// spawn runs fn on a background goroutine that Shutdown waits for. fn must
+// return once stopCh is closed.
+func (s *Server) spawn(fn func()) {
+	s.workers.Add(1)
+	go func() {
+		defer s.workers.Done()
+		fn()
+	}()
+}
+
+// Stop closes the HTTP server at once, cutting off requests in flight, and
+// signals the background workers to stop without waiting for them
 func (s *Server) Stop() error {
+	s.stopOnce.Do(func() { close(s.stopCh) })
+	if s.debugSrv != nil {
+		s.debugSrv.Close()
+	}
 	if s.httpSrv != nil {
 		log.Println("Shutting down HTTP server")
 		return s.httpSrv.Close()
@@ -64,21 +302,85 @@ func (s *Server) Stop() error {
 	return nil
 }
 
-// JoinCluster joins the current node to an existing cluster
-func (s *Server) JoinCluster(joinAddr, nodeID, raftAddr string) error {
-	url := fmt.Sprintf("http://%s/join", joinAddr)
+// Shutdown stops accepting requests and waits for those in flight and the
+// background workers, such as the scheduler, outbox and printer drivers, to
+// finish. Requests still running when ctx ends, like long polls, are cut
+// off.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.stopOnce.Do(func() { close(s.stopCh) })
+	if s.debugSrv != nil {
+		s.debugSrv.Shutdown(ctx)
+	}
+	var err error
+	if s.httpSrv != nil {
+		log.Println("Shutting down HTTP server")
+		if err = s.httpSrv.Shutdown(ctx); err != nil {
+			s.httpSrv.Close()
+		}
+	}
 
-	reqBody := fmt.Sprintf(`{"node_id":"%s", "raft_addr":"%s"}`, nodeID, raftAddr)
-	resp, err := http.Post(url, "application/json", 
-	                      strings.NewReader(reqBody))
-	if err != nil {
-		return fmt.Errorf("failed to send join request: %w", err)
+	done := make(chan struct{})
+	go func() {
+		s.workers.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return err
+	case <-ctx.Done():
+		return fmt.Errorf("background workers still running: %w", ctx.Err())
+	}
+}
+
+// advertiseAddr returns the address other nodes reach this server at
+func (s *Server) advertiseAddr() string {
+	if s.AdvertiseAddr != "" {
+		return s.AdvertiseAddr
 	}
-	defer resp.Body.Close()
+	return s.Addr
+}
+
+// JoinCluster joins the current node to an existing cluster as a non-voter,
+// then has it promoted to voter once it has caught up with the log. It
+// retries while the node at joinAddr can't be reached or has no leader to
+// add this one, until the node acknowledges the join or ctx ends.
+func (s *Server) JoinCluster(ctx context.Context, joinAddr, nodeID, raftAddr string) error {
+	url := fmt.Sprintf("%s://%s/join", s.scheme(), joinAddr)
 
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("join request failed: %s", resp.Status)
+	// The transport fills in each request's server name for TLS
+	client := &http.Client{Timeout: 10 * time.Second}
+	if s.tls != nil {
+		client.Transport = &http.Transport{TLSClientConfig: s.tls.ClientConfig("")}
 	}
 
+	reqBody, err := json.Marshal(JoinRequest{
+		NodeID: nodeID, RaftAddr: raftAddr, HTTPAddr: s.advertiseAddr(), NonVoter: true,
+		ProtocolVersion: raft.ProtocolVersion,
+	})
+	if err != nil {
+		return err
+	}
+	wait := joinRetryMin
+	for attempt := 1; ; attempt++ {
+		retry, err := s.requestJoin(client, url, reqBody)
+		if err == nil {
+			break
+		}
+		if !retry {
+			return err
+		}
+		log.Printf("Join attempt %d via %s failed, retrying in %s: %s", attempt, joinAddr, wait, err)
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return fmt.Errorf("gave up joining via %s after %d attempts: %w", joinAddr, attempt, err)
+		}
+		if wait *= 2; wait > joinRetryMax {
+			wait = joinRetryMax
+		}
+	}
+	log.Printf("Joined the cluster via %s", joinAddr)
+
+	s.spawn(func() { s.promoteWhenCaughtUp(joinAddr, nodeID, client) })
 	return nil
-}
\ No newline at end of file
+}
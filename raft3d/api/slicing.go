@@ -0,0 +1,362 @@
+package api
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"raft3d/raft"
+)
+
+const (
+	// slicingTimeout bounds one call to the slicer
+	slicingTimeout = 10 * time.Minute
+
+	// modelMaxBytes caps the size of an uploaded model
+	modelMaxBytes = 256 << 20
+)
+
+// Slicer turns a 3D model into G-code
+type Slicer interface {
+	// Slice slices the named model with a slicer profile, which may be empty
+	// for the slicer's default, and returns the G-code
+	Slice(ctx context.Context, name string, model io.Reader, profile string) (io.ReadCloser, error)
+}
+
+// httpSlicer calls an external slicing service, such as a CuraEngine or
+// PrusaSlicer wrapper. The model is POSTed as the request body, with its name
+// in the "filename" query parameter and the profile in "profile"; the
+// response body is the G-code.
+type httpSlicer struct {
+	endpoint string
+	client   *http.Client
+}
+
+// NewHTTPSlicer returns a Slicer that calls the service at endpoint
+func NewHTTPSlicer(endpoint string) (Slicer, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("slicer URL %q must be an http or https URL", endpoint)
+	}
+	return &httpSlicer{endpoint: endpoint, client: &http.Client{}}, nil
+}
+
+func (h *httpSlicer) Slice(ctx context.Context, name string, model io.Reader, profile string) (io.ReadCloser, error) {
+	u, _ := url.Parse(h.endpoint)
+	query := u.Query()
+	query.Set("filename", name)
+	if profile != "" {
+		query.Set("profile", profile)
+	}
+	u.RawQuery = query.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u.String(), model)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 300 {
+		defer resp.Body.Close()
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return nil, fmt.Errorf("slicer returned %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return resp.Body, nil
+}
+
+// EnableSlicing slices uploaded models with slicer. Models and G-code are
+// kept in the artifact store, which EnableArtifacts must also set.
+func (s *Server) EnableSlicing(slicer Slicer) {
+	s.slicer = slicer
+}
+
+// handleSlices handles POST /api/v1/slices, which queues a slice task, and
+// GET /api/v1/slices/{task}/gcode
+func (s *Server) handleSlices(w http.ResponseWriter, r *http.Request) {
+	rest := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/v1/slices"), "/")
+	id, sub, _ := strings.Cut(rest, "/")
+
+	switch {
+	case id == "" && r.Method == http.MethodPost:
+		s.handlePostSlice(w, r)
+	case id != "" && sub == "gcode" && r.Method == http.MethodGet:
+		s.handleSliceGCode(w, r, id)
+	case id == "" || sub == "gcode":
+		methodNotAllowed(w, r)
+	default:
+		writeError(w, r, http.StatusNotFound, CodeNotFound, "Not found")
+	}
+}
+
+// handlePostSlice stores an uploaded model and queues it for slicing. The
+// multipart form carries the model as "file" and optionally a slicer
+// "profile" and the "filament_id" to estimate the weight with.
+func (s *Server) handlePostSlice(w http.ResponseWriter, r *http.Request) {
+	if s.slicer == nil || s.artifacts == nil {
+		writeError(w, r, http.StatusConflict, CodeConflict, "Slicing is not configured on this node; start it with -slicer-url and -artifact-store")
+		return
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, modelMaxBytes)
+	if err := r.ParseMultipartForm(32 << 20); err != nil {
+		writeError(w, r, http.StatusBadRequest, CodeMalformedRequest, "Expected a multipart/form-data upload of at most 256 MiB")
+		return
+	}
+	defer r.MultipartForm.RemoveAll()
+
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		writeValidationProblem(w, r, []FieldError{{Name: "file", Reason: "is required"}})
+		return
+	}
+	defer file.Close()
+
+	slice := SliceInput{
+		ModelName:  filepath.Base(header.Filename),
+		Profile:    r.FormValue("profile"),
+		FilamentID: r.FormValue("filament_id"),
+	}
+	var conditions []raft.Condition
+	if slice.FilamentID != "" {
+		if _, err := s.getFilament(slice.FilamentID); err != nil {
+			if errors.Is(err, raft.ErrNotFound) {
+				writeValidationProblem(w, r, []FieldError{{Name: "filament_id", Reason: "filament does not exist"}})
+				return
+			}
+			s.writeStoreError(w, r, err, "Failed to retrieve filament")
+			return
+		}
+		conditions = append(conditions, raft.Condition{Key: "filament_" + slice.FilamentID})
+	}
+
+	sum, err := s.storeObject("model-", file)
+	if err != nil {
+		log.Printf("Failed to store model %s: %s", slice.ModelName, err)
+		writeError(w, r, http.StatusInternalServerError, CodeInternal, "Failed to store model")
+		return
+	}
+	slice.ModelSHA256 = sum
+
+	task, err := s.queueTask(r, "slice", slice, conditions...)
+	if err != nil {
+		s.writeStoreError(w, r, err, "Failed to queue slice")
+		return
+	}
+	writeQueuedTask(w, task)
+}
+
+// handleSliceGCode returns the G-code of a slice that succeeded
+func (s *Server) handleSliceGCode(w http.ResponseWriter, r *http.Request, id string) {
+	task, err := s.getTask(id)
+	if err == nil && task.Kind != "slice" {
+		err = raft.ErrNotFound
+	}
+	if err != nil {
+		s.writeStoreError(w, r, err, "Slice not found")
+		return
+	}
+	var slice SliceInput
+	var result SliceResult
+	json.Unmarshal(task.Input, &slice)
+	if task.Status != "Succeeded" || json.Unmarshal(task.Result, &result) != nil {
+		writeError(w, r, http.StatusConflict, CodeConflict, fmt.Sprintf("The slice is %s and has no G-code", task.Status))
+		return
+	}
+	if s.artifacts == nil {
+		writeError(w, r, http.StatusConflict, CodeConflict, "No artifact store is configured on this node; start it with -artifact-store")
+		return
+	}
+	content, err := s.artifacts.Open("gcode-" + result.GCodeSHA256)
+	if errors.Is(err, fs.ErrNotExist) {
+		writeError(w, r, http.StatusNotFound, CodeNotFound, "The G-code is not in this node's artifact store")
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to open G-code of slice %s: %s", id, err)
+		writeError(w, r, http.StatusInternalServerError, CodeInternal, "Failed to read G-code")
+		return
+	}
+	defer content.Close()
+
+	name := strings.TrimSuffix(slice.ModelName, filepath.Ext(slice.ModelName)) + ".gcode"
+	w.Header().Set("Content-Type", "text/x-gcode")
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": name}))
+	io.Copy(w, content)
+}
+
+// runSliceTask runs a slice: it sends the model to the slicer, stores the
+// G-code and reads the slicer's estimates from it
+func (s *Server) runSliceTask(ctx context.Context, task Task) (interface{}, error) {
+	var slice SliceInput
+	if err := json.Unmarshal(task.Input, &slice); err != nil {
+		return nil, fmt.Errorf("invalid slice: %w", err)
+	}
+	if s.slicer == nil || s.artifacts == nil {
+		return nil, errors.New("slicing is not configured on the leader")
+	}
+	model, err := s.artifacts.Open("model-" + slice.ModelSHA256)
+	if err != nil {
+		return nil, fmt.Errorf("reading model: %w", err)
+	}
+	defer model.Close()
+
+	ctx, cancel := context.WithTimeout(ctx, slicingTimeout)
+	defer cancel()
+	gcode, err := s.slicer.Slice(ctx, slice.ModelName, model, slice.Profile)
+	if err != nil {
+		return nil, err
+	}
+	defer gcode.Close()
+
+	// The G-code is spooled to disk to be scanned for estimates before it
+	// is stored under its checksum
+	spool, err := os.CreateTemp("", "raft3d-gcode-*")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(spool.Name())
+	defer spool.Close()
+	digest := sha256.New()
+	size, err := io.Copy(io.MultiWriter(spool, digest), gcode)
+	if err != nil {
+		return nil, fmt.Errorf("reading G-code: %w", err)
+	}
+	if size == 0 {
+		return nil, errors.New("slicer returned no G-code")
+	}
+
+	spool.Seek(0, io.SeekStart)
+	result := gcodeEstimates(spool)
+	result.GCodeSHA256 = hex.EncodeToString(digest.Sum(nil))
+	result.GCodeSize = size
+	if result.PrintWeightInGrams == 0 && result.PrintLengthInMeters > 0 && slice.FilamentID != "" {
+		if filament, err := s.getFilament(slice.FilamentID); err == nil {
+			job := PrintJob{PrintLengthInMeters: result.PrintLengthInMeters}
+			if resolvePrintQuantity(&job, s.withFilamentDefaults(filament)) == nil {
+				result.PrintWeightInGrams = job.PrintWeightInGrams
+			}
+		}
+	}
+
+	spool.Seek(0, io.SeekStart)
+	if err := s.artifacts.Write("gcode-"+result.GCodeSHA256, spool); err != nil {
+		return nil, fmt.Errorf("storing G-code: %w", err)
+	}
+	return result, nil
+}
+
+// storeObject writes content to the artifact store under prefix followed by
+// its checksum, which it returns
+func (s *Server) storeObject(prefix string, content io.Reader) (string, error) {
+	spool, err := os.CreateTemp("", "raft3d-object-*")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(spool.Name())
+	defer spool.Close()
+
+	digest := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(spool, digest), content); err != nil {
+		return "", err
+	}
+	sum := hex.EncodeToString(digest.Sum(nil))
+	spool.Seek(0, io.SeekStart)
+	return sum, s.artifacts.Write(prefix+sum, spool)
+}
+
+// gcodeEstimates reads the filament use and print time slicers write in
+// G-code comments. PrusaSlicer, OrcaSlicer and Bambu Studio give grams and
+// millimeters; Cura gives meters and seconds. Multi-extruder lists are
+// summed.
+func gcodeEstimates(r io.Reader) SliceResult {
+	var result SliceResult
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64<<10), 1<<20)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if !strings.HasPrefix(line, ";") {
+			continue
+		}
+		comment := strings.TrimSpace(strings.TrimPrefix(line, ";"))
+		lower := strings.ToLower(comment)
+		key, value, ok := strings.Cut(lower, "=")
+		if !ok {
+			key, value, ok = strings.Cut(lower, ":")
+		}
+		if !ok {
+			continue
+		}
+		key, value = strings.TrimSpace(key), strings.TrimSpace(value)
+
+		switch {
+		case key == "filament used [g]" || key == "total filament weight [g]":
+			if grams := sumList(value, ""); grams > 0 {
+				result.PrintWeightInGrams = roundGrams(grams)
+			}
+		case key == "filament used [mm]":
+			if mm := sumList(value, ""); mm > 0 && result.PrintLengthInMeters == 0 {
+				result.PrintLengthInMeters = roundThousandths(mm / 1000)
+			}
+		case key == "filament used":
+			// Cura writes meters, such as "1.2m, 0m"
+			if m := sumList(value, "m"); m > 0 {
+				result.PrintLengthInMeters = roundThousandths(m)
+			}
+		case key == "time":
+			if seconds, err := strconv.ParseInt(value, 10, 64); err == nil {
+				result.PrintSeconds = seconds
+			}
+		case strings.HasPrefix(key, "estimated printing time") && result.PrintSeconds == 0:
+			result.PrintSeconds = parseSlicerDuration(value)
+		}
+	}
+	return result
+}
+
+// sumList adds up a comma-separated list of numbers, each with an optional
+// unit suffix
+func sumList(value, unit string) float64 {
+	var total float64
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSuffix(strings.TrimSpace(item), unit)
+		if n, err := strconv.ParseFloat(item, 64); err == nil && n > 0 {
+			total += n
+		}
+	}
+	return total
+}
+
+// parseSlicerDuration parses a PrusaSlicer duration such as "1d 2h 3m 4s"
+func parseSlicerDuration(value string) int64 {
+	units := map[byte]int64{'d': 86400, 'h': 3600, 'm': 60, 's': 1}
+	var total int64
+	for _, field := range strings.Fields(value) {
+		if len(field) < 2 {
+			return 0
+		}
+		n, err := strconv.ParseInt(field[:len(field)-1], 10, 64)
+		unit, ok := units[field[len(field)-1]]
+		if err != nil || !ok {
+			return 0
+		}
+		total += n * unit
+	}
+	return total
+}
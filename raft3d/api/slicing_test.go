@@ -0,0 +1,138 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"raft3d/raft"
+	"raft3d/testsupport"
+)
+
+// fakeSlicer returns fixed G-code, or fails
+type fakeSlicer struct {
+	gcode  string
+	err    error
+	models []string
+}
+
+func (f *fakeSlicer) Slice(ctx context.Context, name string, model io.Reader, profile string) (io.ReadCloser, error) {
+	content, _ := io.ReadAll(model)
+	f.models = append(f.models, name+":"+profile+":"+string(content))
+	if f.err != nil {
+		return nil, f.err
+	}
+	return io.NopCloser(strings.NewReader(f.gcode)), nil
+}
+
+func TestGCodeEstimates(t *testing.T) {
+	for name, test := range map[string]struct {
+		gcode string
+		want  SliceResult
+	}{
+		"prusaslicer": {
+			gcode: "G1 X1\n; filament used [mm] = 4123.5\n; filament used [g] = 12.34\n; estimated printing time (normal mode) = 1h 2m 3s\n",
+			want:  SliceResult{PrintWeightInGrams: 12.34, PrintLengthInMeters: 4.124, PrintSeconds: 3723},
+		},
+		"cura": {
+			gcode: ";FLAVOR:Marlin\n;TIME:3600\n;Filament used: 1.5m, 0.25m\nG28\n",
+			want:  SliceResult{PrintLengthInMeters: 1.75, PrintSeconds: 3600},
+		},
+		"orca": {
+			gcode: "; total filament weight [g] : 20.5\n",
+			want:  SliceResult{PrintWeightInGrams: 20.5},
+		},
+	} {
+		if got := gcodeEstimates(strings.NewReader(test.gcode)); got != test.want {
+			t.Errorf("%s: got %+v, want %+v", name, got, test.want)
+		}
+	}
+}
+
+// postSlice uploads a model for slicing
+func postSlice(s *Server, fields map[string]string, name, model string) *httptest.ResponseRecorder {
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	for key, value := range fields {
+		form.WriteField(key, value)
+	}
+	file, _ := form.CreateFormFile("file", name)
+	io.WriteString(file, model)
+	form.Close()
+
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/slices", &body)
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	rec := httptest.NewRecorder()
+	s.handleSlices(rec, req)
+	return rec
+}
+
+func TestSlicing(t *testing.T) {
+	c := testsupport.NewCluster(t, 1)
+	leader := c.WaitForLeader(10 * time.Second)
+	filament, _ := json.Marshal(Filament{ID: "f1", Name: "PLA", Type: "PLA", DensityGPerCm3: 1.24, DiameterMM: 1.75})
+	leader.Store.Set("filament_f1", string(filament))
+
+	s := NewServer("", leader.Store)
+	if rec := postSlice(s, nil, "part.stl", "solid"); rec.Code != http.StatusConflict {
+		t.Fatalf("slice without a slicer: %d %s", rec.Code, rec.Body)
+	}
+	store, err := raft.NewDirBackupTarget(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.EnableArtifacts(store)
+	slicer := &fakeSlicer{gcode: ";TIME:60\n;Filament used: 2m\nG28\n"}
+	s.EnableSlicing(slicer)
+
+	if rec := postSlice(s, map[string]string{"filament_id": "missing"}, "part.stl", "solid"); rec.Code != http.StatusBadRequest {
+		t.Fatalf("slice for a missing filament: %d %s", rec.Code, rec.Body)
+	}
+	rec := postSlice(s, map[string]string{"filament_id": "f1", "profile": "0.2mm"}, "part.stl", "solid")
+	if rec.Code != http.StatusAccepted {
+		t.Fatalf("post slice: %d %s", rec.Code, rec.Body)
+	}
+	var queued Task
+	json.NewDecoder(rec.Body).Decode(&queued)
+	if queued.ID == "" || queued.Kind != "slice" || queued.Status != "Pending" || rec.Header().Get("Location") != "/api/v1/tasks/"+queued.ID {
+		t.Fatalf("unexpected task: %+v", queued)
+	}
+
+	rec = httptest.NewRecorder()
+	s.handleSlices(rec, httptest.NewRequest(http.MethodGet, "/api/v1/slices/"+queued.ID+"/gcode", nil))
+	if rec.Code != http.StatusConflict {
+		t.Fatalf("gcode of a pending slice: %d %s", rec.Code, rec.Body)
+	}
+
+	if !s.runNextTask(context.Background()) {
+		t.Fatal("no task was run")
+	}
+	if len(slicer.models) != 1 || slicer.models[0] != "part.stl:0.2mm:solid" {
+		t.Fatalf("slicer got %q", slicer.models)
+	}
+	done, err := s.getTask(queued.ID)
+	var result SliceResult
+	if err != nil || done.Status != "Succeeded" || done.Attempt != 1 || json.Unmarshal(done.Result, &result) != nil {
+		t.Fatalf("slice not recorded: %+v, %v", done, err)
+	}
+	// 2m of 1.75mm filament at 1.24g/cm3
+	if result.PrintLengthInMeters != 2 || result.PrintWeightInGrams != 5.965 || result.PrintSeconds != 60 {
+		t.Fatalf("unexpected estimates: %+v", result)
+	}
+
+	rec = httptest.NewRecorder()
+	s.handleSlices(rec, httptest.NewRequest(http.MethodGet, "/api/v1/slices/"+queued.ID+"/gcode", nil))
+	if rec.Code != http.StatusOK || rec.Body.String() != slicer.gcode {
+		t.Fatalf("gcode: %d %q", rec.Code, rec.Body)
+	}
+	if s.runNextTask(context.Background()) {
+		t.Fatal("a finished slice was run again")
+	}
+}
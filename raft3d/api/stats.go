@@ -0,0 +1,71 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// anonymousUser groups consumption of jobs submitted without authentication
+const anonymousUser = "anonymous"
+
+// handleStats handles GET /api/v1/stats, counting entities and totalling the
+// filament consumed by completed jobs per submitting user
+func (s *Server) handleStats(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, r)
+		return
+	}
+
+	stats := Stats{
+		PrintJobs: make(map[string]int),
+		ByUser:    make(map[string]CostSummary),
+	}
+
+	printers, err := s.store.List("printer_")
+	if err != nil {
+		s.writeStoreError(w, r, err, "Failed to retrieve printers")
+		return
+	}
+	stats.Printers = len(printers)
+
+	filaments, err := s.store.List("filament_")
+	if err != nil {
+		s.writeStoreError(w, r, err, "Failed to retrieve filaments")
+		return
+	}
+	stats.Filaments = len(filaments)
+
+	jobs, err := s.store.List("printjob_")
+	if err != nil {
+		s.writeStoreError(w, r, err, "Failed to retrieve print jobs")
+		return
+	}
+	for _, key := range jobs {
+		value, err := s.store.Get(key)
+		if err != nil {
+			continue
+		}
+		var printJob PrintJob
+		if err := json.Unmarshal([]byte(value), &printJob); err != nil {
+			continue
+		}
+		stats.PrintJobs[printJob.Status]++
+	}
+
+	usages, err := s.listUsage("")
+	if err != nil {
+		s.writeStoreError(w, r, err, "Failed to retrieve usage history")
+		return
+	}
+	for _, usage := range usages {
+		user := usage.SubmittedBy
+		if user == "" {
+			user = anonymousUser
+		}
+		stats.Consumption = stats.Consumption.add(usage)
+		stats.ByUser[user] = stats.ByUser[user].add(usage)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(stats)
+}
@@ -0,0 +1,236 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"raft3d/raft"
+)
+
+// EventJobStepStatus is published when a step of a print job changes status
+// through the steps API
+const EventJobStepStatus = "print_job.step_changed"
+
+// JobStep is one stage of a multi-stage print job, such as the print itself,
+// cooling down or inspecting the part. Steps run in order and can require an
+// operator's sign-off before the next one starts.
+type JobStep struct {
+	Name            string `json:"name" validate:"required"`
+	Kind            string `json:"kind" validate:"required,oneof=print cool_down inspection"`
+	RequiresSignOff bool   `json:"requires_sign_off,omitempty"`
+
+	// Set by the server
+	Status      string     `json:"status"`
+	StartedAt   *time.Time `json:"started_at,omitempty"`
+	CompletedAt *time.Time `json:"completed_at,omitempty"`
+	SignedOffBy string     `json:"signed_off_by,omitempty"`
+	SignedOffAt *time.Time `json:"signed_off_at,omitempty"`
+	Notes       string     `json:"notes,omitempty"`
+}
+
+// JobStepStatusUpdate is the payload of a step status change
+type JobStepStatusUpdate struct {
+	Status string `json:"status" validate:"required,oneof=Running Done Failed"`
+}
+
+// JobStepSignOff is the optional body of a step approval or rejection
+type JobStepSignOff struct {
+	Notes string `json:"notes,omitempty"`
+}
+
+// validateSteps checks the steps of a new job: each is valid, names are
+// unique and usable in a URL, and a job with steps has exactly one print step
+func validateSteps(steps []JobStep) []FieldError {
+	if len(steps) == 0 {
+		return nil
+	}
+	var errs []FieldError
+	names := make(map[string]bool, len(steps))
+	prints := 0
+	for i, step := range steps {
+		field := fmt.Sprintf("steps[%d]", i)
+		for _, e := range Validate(step) {
+			errs = append(errs, FieldError{Name: field + "." + e.Name, Reason: e.Reason})
+		}
+		if strings.Contains(step.Name, "/") {
+			errs = append(errs, FieldError{Name: field + ".name", Reason: "must not contain /"})
+		}
+		if names[step.Name] {
+			errs = append(errs, FieldError{Name: field + ".name", Reason: "is used by another step"})
+		}
+		names[step.Name] = true
+		if step.Kind == raft.StepKindPrint {
+			prints++
+		}
+	}
+	if prints != 1 {
+		errs = append(errs, FieldError{Name: "steps", Reason: "must contain exactly one print step"})
+	}
+	return errs
+}
+
+// resetSteps clears the fields of a new job's steps that are set by the
+// server, leaving every step Pending
+func (j *PrintJob) resetSteps() {
+	for i, step := range j.Steps {
+		j.Steps[i] = JobStep{Name: step.Name, Kind: step.Kind, RequiresSignOff: step.RequiresSignOff, Status: raft.StepPending}
+	}
+}
+
+// syncPrintStep moves the job's print step along with the job's status: it
+// starts when the job starts, and ends when the job does, awaiting sign-off
+// if it needs one
+func (j *PrintJob) syncPrintStep(now time.Time) {
+	for i := range j.Steps {
+		step := &j.Steps[i]
+		if step.Kind != raft.StepKindPrint {
+			continue
+		}
+		switch {
+		case j.Status == "Running" && step.Status == raft.StepPending:
+			step.Status, step.StartedAt = raft.StepRunning, &now
+		case j.Status == "Done" && step.Status == raft.StepRunning:
+			step.Status, step.CompletedAt = raft.StepDone, &now
+			if step.RequiresSignOff {
+				step.Status = raft.StepAwaitingSignOff
+			}
+		case (j.Status == "Failed" || j.Status == "Canceled") && step.Status == raft.StepRunning:
+			step.Status, step.CompletedAt = raft.StepFailed, &now
+		}
+	}
+}
+
+// stepRoute splits /api/v1/print_jobs/{id}/steps/{step}/{action}, where the
+// action is status, approve or reject
+func stepRoute(path string) (jobID, stepName, action string, ok bool) {
+	rest := strings.TrimPrefix(path, "/api/v1/print_jobs/")
+	parts := strings.Split(strings.TrimSuffix(rest, "/"), "/")
+	if len(parts) != 4 || parts[0] == "" || parts[1] != "steps" || parts[2] == "" {
+		return "", "", "", false
+	}
+	switch parts[3] {
+	case "status", "approve", "reject":
+		return parts[0], parts[2], parts[3], true
+	}
+	return "", "", "", false
+}
+
+// handleJobStep handles POST /print_jobs/{id}/steps/{step}/status, which
+// starts, finishes or fails a step, and POST /print_jobs/{id}/steps/{step}/approve
+// or /reject, which signs off a step awaiting it or fails it. Signing off
+// needs the operator or admin role. The print step starts and ends with its
+// job; only its sign-off is given here.
+func (s *Server) handleJobStep(w http.ResponseWriter, r *http.Request, jobID, stepName, action string) {
+	if r.Method != http.MethodPost {
+		methodNotAllowed(w, r)
+		return
+	}
+	var update JobStepStatusUpdate
+	var signOff JobStepSignOff
+	if action == "status" {
+		update.Status = r.URL.Query().Get("status")
+		if errs := Validate(update); len(errs) > 0 {
+			writeValidationProblem(w, r, errs)
+			return
+		}
+	} else {
+		if !s.requireRole(w, r, RoleOperator, RoleAdmin) {
+			return
+		}
+		if r.ContentLength != 0 && !decodeJSON(w, r, &signOff) {
+			return
+		}
+	}
+
+	key := "printjob_" + jobID
+	job, err := s.getPrintJob(jobID)
+	if err != nil {
+		s.writeStoreError(w, r, err, "Print job not found")
+		return
+	}
+	index := -1
+	for i, step := range job.Steps {
+		if step.Name == stepName {
+			index = i
+		}
+	}
+	if index < 0 {
+		writeError(w, r, http.StatusNotFound, CodeNotFound, fmt.Sprintf("Print job %s has no step %s", jobID, stepName))
+		return
+	}
+	step := &job.Steps[index]
+	if job.Status == "PendingApproval" || job.Status == "Failed" || job.Status == "Canceled" {
+		writeError(w, r, http.StatusConflict, CodeInvalidStepTransition,
+			fmt.Sprintf("Steps of a %s print job can't change", job.Status))
+		return
+	}
+
+	now := time.Now().UTC()
+	from := step.Status
+	switch action {
+	case "status":
+		if step.Kind == raft.StepKindPrint {
+			writeError(w, r, http.StatusConflict, CodeInvalidStepTransition,
+				"The print step starts and ends with its job; change the job's status instead")
+			return
+		}
+		to := update.Status
+		if to == raft.StepDone && step.RequiresSignOff {
+			to = raft.StepAwaitingSignOff
+		}
+		if !raft.ValidStepTransition(from, to) {
+			writeError(w, r, http.StatusConflict, CodeInvalidStepTransition,
+				fmt.Sprintf("Step %s can't go from %s to %s", stepName, from, to))
+			return
+		}
+		step.Status = to
+		if to == raft.StepRunning {
+			step.StartedAt, step.CompletedAt = &now, nil
+			step.SignedOffBy, step.SignedOffAt = "", nil
+		} else {
+			step.CompletedAt = &now
+		}
+	default:
+		if from != raft.StepAwaitingSignOff {
+			writeError(w, r, http.StatusConflict, CodeInvalidStepTransition,
+				fmt.Sprintf("Only steps in AwaitingSignOff can be approved or rejected; this one is %s", from))
+			return
+		}
+		step.Status = raft.StepFailed
+		if action == "approve" {
+			step.Status = raft.StepDone
+		}
+		step.SignedOffBy, step.SignedOffAt, step.Notes = "", &now, signOff.Notes
+		if principal, ok := principalFrom(r); ok {
+			step.SignedOffBy = principal.Name
+		}
+		if step.SignedOffBy == "" {
+			step.SignedOffBy = anonymousUser
+		}
+	}
+
+	body, err := json.Marshal(job)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, CodeInternal, "Failed to process print job data")
+		return
+	}
+	// The FSM checks the step's order and transition again against the job
+	// as it is when the write applies
+	condition := raft.Condition{Key: key, Field: "status", Equals: job.Status}
+	if err := s.storeFor(r).SetMany(map[string]string{key: string(body)}, condition); err != nil {
+		s.writeStoreError(w, r, err, "Failed to update print job data")
+		return
+	}
+	s.publish(EventJobStepStatus, map[string]interface{}{
+		"job_id": jobID,
+		"step":   stepName,
+		"from":   from,
+		"to":     step.Status,
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(body)
+}
@@ -0,0 +1,108 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"raft3d/raft"
+	"raft3d/testsupport"
+)
+
+func TestJobSteps(t *testing.T) {
+	c := testsupport.NewCluster(t, 1)
+	leader := c.WaitForLeader(10 * time.Second)
+	s := NewServer("", leader.Store)
+	s.EnableAuth([]APIKey{{Key: "x", Principal: Principal{Name: "root", Role: RoleAdmin}}})
+	for key, v := range map[string]interface{}{
+		"printer_p1":  Printer{ID: "p1", Name: "Prusa", Status: "Idle"},
+		"filament_f1": Filament{ID: "f1", Name: "PLA", Type: "PLA", TotalWeightInGrams: 1000, RemainingWeightInGrams: 1000},
+	} {
+		body, _ := json.Marshal(v)
+		if err := leader.Store.Set(key, string(body)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	do := func(role, method, url, body string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, url, strings.NewReader(body))
+		r = r.WithContext(context.WithValue(r.Context(), principalKey{}, Principal{Name: role + "-user", Role: role}))
+		rec := httptest.NewRecorder()
+		if method == http.MethodPost && url == "/api/v1/print_jobs" {
+			s.handlePostPrintJob(rec, r)
+		} else {
+			s.handlePrintJobs(rec, r)
+		}
+		return rec
+	}
+	steps := func(rec *httptest.ResponseRecorder) map[string]JobStep {
+		var job PrintJob
+		if err := json.NewDecoder(rec.Body).Decode(&job); err != nil {
+			t.Fatal(err)
+		}
+		byName := make(map[string]JobStep)
+		for _, step := range job.Steps {
+			byName[step.Name] = step
+		}
+		return byName
+	}
+
+	if rec := do(RoleMember, http.MethodPost, "/api/v1/print_jobs", `{"printer_id":"p1","filament_id":"f1","filepath":"part.gcode","print_weight_in_grams":10,
+		"steps":[{"name":"print","kind":"print"},{"name":"again","kind":"print"}]}`); rec.Code != http.StatusBadRequest {
+		t.Fatalf("two print steps: %d %s", rec.Code, rec.Body)
+	}
+	rec := do(RoleMember, http.MethodPost, "/api/v1/print_jobs", `{"id":"j1","printer_id":"p1","filament_id":"f1","filepath":"part.gcode","print_weight_in_grams":10,
+		"steps":[{"name":"print","kind":"print"},{"name":"cool","kind":"cool_down","status":"Done"},{"name":"qa","kind":"inspection","requires_sign_off":true}]}`)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("submit: %d %s", rec.Code, rec.Body)
+	}
+	if step := steps(rec)["cool"]; step.Status != raft.StepPending {
+		t.Fatalf("new step = %+v", step)
+	}
+
+	// Steps run in order, and the print step follows the job
+	if rec := do(RoleOperator, http.MethodPost, "/api/v1/print_jobs/j1/steps/cool/status?status=Running", ""); rec.Code != http.StatusConflict ||
+		!strings.Contains(rec.Body.String(), CodeInvalidStepTransition) {
+		t.Fatalf("cool-down before printing: %d %s", rec.Code, rec.Body)
+	}
+	if rec := do(RoleOperator, http.MethodPost, "/api/v1/print_jobs/j1/steps/print/status?status=Running", ""); rec.Code != http.StatusConflict {
+		t.Fatalf("print step changed on its own: %d", rec.Code)
+	}
+	for _, status := range []string{"Running", "Done"} {
+		if rec := do(RoleOperator, http.MethodPost, "/api/v1/print_jobs/j1/status?status="+status, ""); rec.Code != http.StatusOK {
+			t.Fatalf("job %s: %d %s", status, rec.Code, rec.Body)
+		}
+	}
+	if job, err := s.getPrintJob("j1"); err != nil || job.Steps[0].Status != raft.StepDone || job.Steps[0].StartedAt == nil {
+		t.Fatalf("print step after the job is Done: %+v %v", job.Steps, err)
+	}
+
+	for _, status := range []string{"Running", "Done"} {
+		if rec := do(RoleOperator, http.MethodPost, "/api/v1/print_jobs/j1/steps/cool/status?status="+status, ""); rec.Code != http.StatusOK {
+			t.Fatalf("cool-down %s: %d %s", status, rec.Code, rec.Body)
+		}
+	}
+	do(RoleOperator, http.MethodPost, "/api/v1/print_jobs/j1/steps/qa/status?status=Running", "")
+	rec = do(RoleOperator, http.MethodPost, "/api/v1/print_jobs/j1/steps/qa/status?status=Done", "")
+	if step := steps(rec)["qa"]; step.Status != raft.StepAwaitingSignOff {
+		t.Fatalf("inspection done without sign-off: %+v", step)
+	}
+
+	if rec := do(RoleMember, http.MethodPost, "/api/v1/print_jobs/j1/steps/qa/approve", ""); rec.Code != http.StatusForbidden {
+		t.Fatalf("member signed off: %d", rec.Code)
+	}
+	rec = do(RoleOperator, http.MethodPost, "/api/v1/print_jobs/j1/steps/qa/approve", `{"notes":"dimensions within 0.1 mm"}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("sign off: %d %s", rec.Code, rec.Body)
+	}
+	if step := steps(rec)["qa"]; step.Status != raft.StepDone || step.SignedOffBy != "operator-user" || step.Notes != "dimensions within 0.1 mm" {
+		t.Fatalf("signed off step = %+v", step)
+	}
+	if rec := do(RoleOperator, http.MethodPost, "/api/v1/print_jobs/j1/steps/qa/reject", ""); rec.Code != http.StatusConflict {
+		t.Fatalf("rejected after approval: %d", rec.Code)
+	}
+}
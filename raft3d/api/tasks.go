@@ -0,0 +1,368 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"raft3d/raft"
+)
+
+const (
+	// taskInterval is how often the leader looks for tasks to run
+	taskInterval = 2 * time.Second
+
+	// taskMaxAttempts is how often a task interrupted by a leader change is
+	// started before it fails
+	taskMaxAttempts = 3
+)
+
+// taskStatuses are the states a task moves through
+var taskStatuses = map[string]bool{"Pending": true, "Running": true, "Succeeded": true, "Failed": true}
+
+// taskRunner runs one kind of task on the leader and returns its result.
+// A runner may be run again after a leader change, so it must be safe to
+// repeat.
+type taskRunner func(ctx context.Context, task Task) (interface{}, error)
+
+// taskRunners returns the runner of every kind of task
+func (s *Server) taskRunners() map[string]taskRunner {
+	return map[string]taskRunner{
+		"slice":   s.runSliceTask,
+		"backup":  s.runBackupTask,
+		"archive": s.runArchiveTask,
+		"import":  s.runImportTask,
+	}
+}
+
+// handleTasks handles GET and POST /api/v1/tasks and GET /api/v1/tasks/{id}.
+// Slices are created with POST /api/v1/slices, which takes the model.
+func (s *Server) handleTasks(w http.ResponseWriter, r *http.Request) {
+	id := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/v1/tasks"), "/")
+
+	switch {
+	case id == "" && r.Method == http.MethodGet:
+		s.handleGetTasks(w, r)
+	case id == "" && r.Method == http.MethodPost:
+		s.handlePostTask(w, r)
+	case id != "" && r.Method == http.MethodGet:
+		task, err := s.getTask(id)
+		if err != nil {
+			s.writeStoreError(w, r, err, "Task not found")
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(task)
+	default:
+		methodNotAllowed(w, r)
+	}
+}
+
+// handleGetTasks lists tasks, oldest first, optionally filtered by ?kind=
+// and ?status=
+func (s *Server) handleGetTasks(w http.ResponseWriter, r *http.Request) {
+	kind, status := r.URL.Query().Get("kind"), r.URL.Query().Get("status")
+	if status != "" && !taskStatuses[status] {
+		writeValidationProblem(w, r, []FieldError{{Name: "status", Reason: "must be one of Pending, Running, Succeeded or Failed"}})
+		return
+	}
+	tasks, err := s.listTasks()
+	if err != nil {
+		s.writeStoreError(w, r, err, "Failed to retrieve tasks")
+		return
+	}
+
+	matching := []Task{}
+	for _, task := range tasks {
+		if (kind == "" || task.Kind == kind) && (status == "" || task.Status == status) {
+			matching = append(matching, task)
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(matching)
+}
+
+// handlePostTask queues a backup, archive or import task. Backups and
+// archive runs are admin operations.
+func (s *Server) handlePostTask(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		Kind  string          `json:"kind" validate:"required,oneof=backup archive import"`
+		Input json.RawMessage `json:"input,omitempty"`
+	}
+	if !decodeJSON(w, r, &request) {
+		return
+	}
+	switch request.Kind {
+	case "backup", "archive":
+		if !s.requireRole(w, r, RoleAdmin) {
+			return
+		}
+	case "import":
+		var input ImportInput
+		if err := json.Unmarshal(request.Input, &input); err != nil || len(input.Filaments) == 0 {
+			writeValidationProblem(w, r, []FieldError{{Name: "input.filaments", Reason: "must list at least one filament"}})
+			return
+		}
+		for i, filament := range input.Filaments {
+			if filament.ID == "" {
+				writeValidationProblem(w, r, []FieldError{{Name: fmt.Sprintf("input.filaments[%d].id", i), Reason: "is required"}})
+				return
+			}
+		}
+	}
+
+	task, err := s.queueTask(r, request.Kind, request.Input)
+	if err != nil {
+		s.writeStoreError(w, r, err, "Failed to queue task")
+		return
+	}
+	writeQueuedTask(w, task)
+}
+
+// queueTask stores a new Pending task for the leader to run
+func (s *Server) queueTask(r *http.Request, kind string, input interface{}, conditions ...raft.Condition) (Task, error) {
+	task := Task{Kind: kind, Status: "Pending", CreatedAt: time.Now().UTC()}
+	if principal, ok := principalFrom(r); ok {
+		task.SubmittedBy = principal.Name
+	}
+	if input != nil {
+		raw, err := json.Marshal(input)
+		if err != nil {
+			return task, err
+		}
+		if string(raw) != "null" {
+			task.Input = raw
+		}
+	}
+
+	body, err := json.Marshal(task)
+	if err != nil {
+		return task, err
+	}
+	value, err := s.storeFor(r).CreateAndSet("task_", "", string(body), nil, conditions...)
+	if err != nil {
+		return task, err
+	}
+	err = json.Unmarshal([]byte(value), &task)
+	return task, err
+}
+
+// writeQueuedTask answers 202 Accepted with a task and where to poll it
+func writeQueuedTask(w http.ResponseWriter, task Task) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Location", "/api/v1/tasks/"+task.ID)
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(task)
+}
+
+// getTask loads a task
+func (s *Server) getTask(id string) (Task, error) {
+	var task Task
+	value, err := s.store.Get("task_" + id)
+	if err != nil {
+		return task, err
+	}
+	err = json.Unmarshal([]byte(value), &task)
+	return task, err
+}
+
+// listTasks returns every task, oldest first
+func (s *Server) listTasks() ([]Task, error) {
+	keys, err := s.store.List("task_")
+	if err != nil {
+		return nil, err
+	}
+	tasks := []Task{}
+	for _, key := range keys {
+		value, err := s.store.Get(key)
+		if err != nil {
+			continue
+		}
+		var task Task
+		if err := json.Unmarshal([]byte(value), &task); err != nil {
+			continue
+		}
+		tasks = append(tasks, task)
+	}
+	sort.Slice(tasks, func(i, j int) bool {
+		if !tasks[i].CreatedAt.Equal(tasks[j].CreatedAt) {
+			return tasks[i].CreatedAt.Before(tasks[j].CreatedAt)
+		}
+		return tasks[i].ID < tasks[j].ID
+	})
+	return tasks, nil
+}
+
+// runTasks runs queued tasks one at a time on the leader until the server
+// stops
+func (s *Server) runTasks() {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		<-s.stopCh
+		cancel()
+	}()
+
+	ticker := time.NewTicker(taskInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			for s.store.IsLeader() && s.runNextTask(ctx) {
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// runNextTask claims the oldest runnable task and runs it, reporting whether
+// there was one. A task found Running was started by an earlier leader,
+// since this one runs a single task at a time, and is started again.
+func (s *Server) runNextTask(ctx context.Context) bool {
+	tasks, err := s.listTasks()
+	if err != nil {
+		log.Printf("Tasks: failed to list tasks: %s", err)
+		return false
+	}
+	runners := s.taskRunners()
+	for _, task := range tasks {
+		if task.Status != "Pending" && task.Status != "Running" {
+			continue
+		}
+		runner, ok := runners[task.Kind]
+		switch {
+		case !ok:
+			s.finishTask(task, nil, fmt.Errorf("unknown task kind %q", task.Kind))
+			continue
+		case task.Attempt >= taskMaxAttempts:
+			s.finishTask(task, nil, errors.New("interrupted too many times"))
+			continue
+		}
+
+		claimed := task
+		now := time.Now().UTC()
+		claimed.Status = "Running"
+		claimed.Attempt++
+		claimed.StartedAt = &now
+		if !s.updateTask(task, claimed) {
+			continue
+		}
+		result, err := runner(ctx, claimed)
+		if ctx.Err() != nil {
+			return false
+		}
+		s.finishTask(claimed, result, err)
+		return true
+	}
+	return false
+}
+
+// finishTask records the outcome of a task: its result, or the error it
+// failed with
+func (s *Server) finishTask(task Task, result interface{}, err error) {
+	done := task
+	now := time.Now().UTC()
+	done.CompletedAt = &now
+	done.Status = "Succeeded"
+	if err == nil && result != nil {
+		done.Result, err = json.Marshal(result)
+	}
+	if err != nil {
+		done.Status, done.Error = "Failed", err.Error()
+	}
+	if s.updateTask(task, done) && err != nil {
+		log.Printf("Tasks: %s task %s failed: %s", done.Kind, done.ID, err)
+	}
+}
+
+// updateTask replaces a task, only if it is still in the state it was read
+// in. A leader that lost its claim to a newer one can't overwrite it.
+func (s *Server) updateTask(current, updated Task) bool {
+	body, err := json.Marshal(updated)
+	if err != nil {
+		return false
+	}
+	key := "task_" + current.ID
+	err = s.store.SetMany(map[string]string{key: string(body)},
+		raft.Condition{Key: key, Field: "status", Equals: current.Status},
+		raft.Condition{Key: key, Field: "attempt", Equals: strconv.Itoa(current.Attempt)})
+	if err != nil && !errors.Is(err, raft.ErrConflict) {
+		log.Printf("Tasks: failed to update task %s: %s", current.ID, err)
+	}
+	return err == nil
+}
+
+// runBackupTask writes a backup
+func (s *Server) runBackupTask(ctx context.Context, task Task) (interface{}, error) {
+	return s.store.WriteBackup()
+}
+
+// runArchiveTask applies the retention policy now
+func (s *Server) runArchiveTask(ctx context.Context, task Task) (interface{}, error) {
+	if s.jobArchive == nil {
+		return nil, errors.New("no job archive is configured on the leader")
+	}
+	policy, err := s.getRetentionPolicy()
+	if err != nil {
+		return nil, fmt.Errorf("no retention policy is set: %w", err)
+	}
+	if policy.ArchiveAfterDays == 0 {
+		return nil, errors.New("the retention policy is disabled")
+	}
+	return s.archiveJobs(policy, time.Now().UTC())
+}
+
+// runImportTask creates the filaments of an import. Filaments that already
+// exist are skipped, so a repeated run creates nothing twice; invalid ones
+// are reported and the rest are still created.
+func (s *Server) runImportTask(ctx context.Context, task Task) (interface{}, error) {
+	var input ImportInput
+	if err := json.Unmarshal(task.Input, &input); err != nil {
+		return nil, fmt.Errorf("invalid import: %w", err)
+	}
+
+	result := ImportResult{Failed: []ImportFailure{}}
+	for i, filament := range input.Filaments {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		fail := func(reason string) {
+			result.Failed = append(result.Failed, ImportFailure{Index: i, ID: filament.ID, Error: reason})
+		}
+		if errs := Validate(filament); len(errs) > 0 {
+			fail(errs[0].Name + " " + errs[0].Reason)
+			continue
+		}
+		errs, err := s.prepareFilament(&filament)
+		if err != nil {
+			return nil, err
+		}
+		if len(errs) > 0 {
+			fail(errs[0].Name + " " + errs[0].Reason)
+			continue
+		}
+
+		body, err := json.Marshal(filament)
+		if err != nil {
+			return nil, err
+		}
+		_, err = s.store.CreateAndSet("filament_", filament.ID, string(body), nil)
+		switch {
+		case errors.Is(err, raft.ErrAlreadyExists):
+			result.Skipped++
+		case err != nil:
+			return nil, err
+		default:
+			result.Created++
+		}
+	}
+	return result, nil
+}
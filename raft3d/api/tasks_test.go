@@ -0,0 +1,114 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"raft3d/raft"
+	"raft3d/testsupport"
+)
+
+func TestTasksRestartInterruptedRuns(t *testing.T) {
+	c := testsupport.NewCluster(t, 1)
+	leader := c.WaitForLeader(10 * time.Second)
+	s := NewServer("", leader.Store)
+	store, err := raft.NewDirBackupTarget(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.EnableArtifacts(store)
+	slicer := &fakeSlicer{err: errors.New("profile not found")}
+	s.EnableSlicing(slicer)
+
+	sum, err := s.storeObject("model-", strings.NewReader("solid"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	input, _ := json.Marshal(SliceInput{ModelName: "part.stl", ModelSHA256: sum})
+	// Left Running by a leader that lost leadership, one that already used
+	// up its attempts, and one of a kind this node doesn't know
+	for id, task := range map[string]Task{
+		"t1": {ID: "t1", Kind: "slice", Input: input, Status: "Running", Attempt: 1},
+		"t2": {ID: "t2", Kind: "slice", Input: input, Status: "Running", Attempt: taskMaxAttempts},
+		"t3": {ID: "t3", Kind: "reindex", Status: "Pending"},
+	} {
+		body, _ := json.Marshal(task)
+		leader.Store.Set("task_"+id, string(body))
+	}
+
+	for s.runNextTask(context.Background()) {
+	}
+	first, _ := s.getTask("t1")
+	if first.Status != "Failed" || first.Attempt != 2 || first.Error != "profile not found" {
+		t.Fatalf("interrupted task: %+v", first)
+	}
+	second, _ := s.getTask("t2")
+	if second.Status != "Failed" || second.Attempt != taskMaxAttempts || len(slicer.models) != 1 {
+		t.Fatalf("exhausted task: %+v, slicer called %d times", second, len(slicer.models))
+	}
+	third, _ := s.getTask("t3")
+	if third.Status != "Failed" || third.Attempt != 0 {
+		t.Fatalf("unknown task: %+v", third)
+	}
+}
+
+func TestImportTask(t *testing.T) {
+	c := testsupport.NewCluster(t, 1)
+	leader := c.WaitForLeader(10 * time.Second)
+	s := NewServer("", leader.Store)
+	existing, _ := json.Marshal(Filament{ID: "f1", Name: "PLA", Type: "PLA", TotalWeightInGrams: 1000})
+	leader.Store.Set("filament_f1", string(existing))
+
+	post := func(body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		s.handleTasks(rec, httptest.NewRequest(http.MethodPost, "/api/v1/tasks", strings.NewReader(body)))
+		return rec
+	}
+	if rec := post(`{"kind":"reindex"}`); rec.Code != http.StatusBadRequest {
+		t.Fatalf("unknown kind: %d %s", rec.Code, rec.Body)
+	}
+	if rec := post(`{"kind":"import","input":{"filaments":[{"name":"PETG","type":"PETG"}]}}`); rec.Code != http.StatusBadRequest {
+		t.Fatalf("import without IDs: %d %s", rec.Code, rec.Body)
+	}
+	rec := post(`{"kind":"import","input":{"filaments":[
+		{"id":"f1","name":"PLA","type":"PLA","total_weight_in_grams":1000},
+		{"id":"f2","name":"PETG","type":"PETG","total_weight_in_grams":750},
+		{"id":"f3","name":"Bad","type":"PLA","total_weight_in_grams":100,"remaining_weight_in_grams":200}]}}`)
+	if rec.Code != http.StatusAccepted {
+		t.Fatalf("import: %d %s", rec.Code, rec.Body)
+	}
+	var queued Task
+	json.NewDecoder(rec.Body).Decode(&queued)
+
+	if !s.runNextTask(context.Background()) {
+		t.Fatal("no task was run")
+	}
+	rec = httptest.NewRecorder()
+	s.handleTasks(rec, httptest.NewRequest(http.MethodGet, "/api/v1/tasks/"+queued.ID, nil))
+	var done Task
+	var result ImportResult
+	json.NewDecoder(rec.Body).Decode(&done)
+	if done.Status != "Succeeded" || json.Unmarshal(done.Result, &result) != nil {
+		t.Fatalf("import not recorded: %+v", done)
+	}
+	if result.Created != 1 || result.Skipped != 1 || len(result.Failed) != 1 || result.Failed[0].ID != "f3" {
+		t.Fatalf("unexpected result: %+v", result)
+	}
+	if filament, err := s.getFilament("f2"); err != nil || filament.RemainingWeightInGrams != 750 {
+		t.Fatalf("imported filament: %+v, %v", filament, err)
+	}
+
+	rec = httptest.NewRecorder()
+	s.handleTasks(rec, httptest.NewRequest(http.MethodGet, "/api/v1/tasks?kind=import&status=Succeeded", nil))
+	var listed []Task
+	json.NewDecoder(rec.Body).Decode(&listed)
+	if len(listed) != 1 || listed[0].ID != queued.ID {
+		t.Fatalf("filtered list: %+v", listed)
+	}
+}
@@ -0,0 +1,190 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Telemetry is kept in memory on the leader, not in Raft: it is high volume,
+// only recent samples matter and losing it on failover is acceptable
+const (
+	telemetryBufferSize    = 1024 // samples kept per printer
+	defaultTelemetryWindow = time.Hour
+)
+
+// TelemetrySample is one reading reported by a printer. Every value is
+// optional.
+type TelemetrySample struct {
+	NozzleTempC *float64  `json:"nozzle_temp_c,omitempty"`
+	BedTempC    *float64  `json:"bed_temp_c,omitempty"`
+	FanSpeedPct *float64  `json:"fan_speed_pct,omitempty"`
+	ProgressPct *float64  `json:"progress_pct,omitempty"`
+	RecordedAt  time.Time `json:"recorded_at"`
+}
+
+// validate checks the sample's values
+func (t TelemetrySample) validate() []FieldError {
+	if t.NozzleTempC == nil && t.BedTempC == nil && t.FanSpeedPct == nil && t.ProgressPct == nil {
+		return []FieldError{{Name: "telemetry", Reason: "at least one value is required"}}
+	}
+	var errs []FieldError
+	for name, pct := range map[string]*float64{"fan_speed_pct": t.FanSpeedPct, "progress_pct": t.ProgressPct} {
+		if pct != nil && (*pct < 0 || *pct > 100) {
+			errs = append(errs, FieldError{Name: name, Reason: "must be between 0 and 100"})
+		}
+	}
+	return errs
+}
+
+// telemetryRing holds the most recent samples of one printer
+type telemetryRing struct {
+	samples []TelemetrySample
+	next    int
+}
+
+// add appends a sample, overwriting the oldest once the ring is full
+func (r *telemetryRing) add(sample TelemetrySample) {
+	if len(r.samples) < telemetryBufferSize {
+		r.samples = append(r.samples, sample)
+		return
+	}
+	r.samples[r.next] = sample
+	r.next = (r.next + 1) % telemetryBufferSize
+}
+
+// since returns the samples recorded at or after from, oldest first
+func (r *telemetryRing) since(from time.Time) []TelemetrySample {
+	ordered := append(append([]TelemetrySample{}, r.samples[r.next:]...), r.samples[:r.next]...)
+	samples := []TelemetrySample{}
+	for _, sample := range ordered {
+		if !sample.RecordedAt.Before(from) {
+			samples = append(samples, sample)
+		}
+	}
+	return samples
+}
+
+// latest returns the newest sample, if any
+func (r *telemetryRing) latest() *TelemetrySample {
+	if len(r.samples) == 0 {
+		return nil
+	}
+	i := len(r.samples) - 1
+	if len(r.samples) == telemetryBufferSize {
+		i = (r.next + telemetryBufferSize - 1) % telemetryBufferSize
+	}
+	sample := r.samples[i]
+	return &sample
+}
+
+// telemetryStore holds the telemetry rings of every printer
+type telemetryStore struct {
+	mutex sync.RWMutex
+	rings map[string]*telemetryRing
+}
+
+func newTelemetryStore() *telemetryStore {
+	return &telemetryStore{rings: make(map[string]*telemetryRing)}
+}
+
+func (t *telemetryStore) add(printerID string, sample TelemetrySample) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	ring, ok := t.rings[printerID]
+	if !ok {
+		ring = &telemetryRing{}
+		t.rings[printerID] = ring
+	}
+	ring.add(sample)
+}
+
+func (t *telemetryStore) since(printerID string, from time.Time) []TelemetrySample {
+	t.mutex.RLock()
+	defer t.mutex.RUnlock()
+
+	if ring, ok := t.rings[printerID]; ok {
+		return ring.since(from)
+	}
+	return []TelemetrySample{}
+}
+
+func (t *telemetryStore) latest(printerID string) *TelemetrySample {
+	t.mutex.RLock()
+	defer t.mutex.RUnlock()
+
+	if ring, ok := t.rings[printerID]; ok {
+		return ring.latest()
+	}
+	return nil
+}
+
+// withTelemetry fills in the latest telemetry this node holds for a printer
+func (p Printer) withTelemetry(t *telemetryStore) Printer {
+	p.Telemetry = t.latest(p.ID)
+	return p
+}
+
+// handlePrinterTelemetry handles POST /printers/{id}/telemetry, which records
+// a sample, and GET /printers/{id}/telemetry?window=1h, which lists recent
+// ones. Both are served by the leader, which holds the samples.
+func (s *Server) handlePrinterTelemetry(w http.ResponseWriter, r *http.Request, printerID string) {
+	if !s.store.IsLeader() {
+		s.writeNotLeader(w, r)
+		return
+	}
+	printer, err := s.getPrinter(printerID)
+	if err != nil {
+		s.writeStoreError(w, r, err, "Printer not found")
+		return
+	}
+
+	switch r.Method {
+	case http.MethodPost:
+		var sample TelemetrySample
+		if !decodeJSON(w, r, &sample) {
+			return
+		}
+		if errs := sample.validate(); len(errs) > 0 {
+			writeValidationProblem(w, r, errs)
+			return
+		}
+		now := time.Now().UTC()
+		if sample.RecordedAt.IsZero() || sample.RecordedAt.After(now) {
+			sample.RecordedAt = now
+		}
+		s.telemetry.add(printerID, sample)
+
+		// Telemetry also shows the printer is alive
+		if _, err := s.printerSeen(printer, now, nil); err != nil {
+			s.writeStoreError(w, r, err, "Failed to mark printer online")
+			return
+		}
+		s.evaluateAlerts(printerID, now)
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(w).Encode(sample)
+
+	case http.MethodGet:
+		window := defaultTelemetryWindow
+		if raw := r.URL.Query().Get("window"); raw != "" {
+			if window, err = time.ParseDuration(raw); err != nil || window <= 0 {
+				writeValidationProblem(w, r, []FieldError{{Name: "window", Reason: fmt.Sprintf("must be a positive duration such as %s", defaultTelemetryWindow)}})
+				return
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"printer_id": printerID,
+			"window":     window.String(),
+			"samples":    s.telemetry.since(printerID, time.Now().UTC().Add(-window)),
+		})
+
+	default:
+		methodNotAllowed(w, r)
+	}
+}
@@ -0,0 +1,100 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"raft3d/testsupport"
+)
+
+func TestTelemetryRingKeepsTheNewestSamples(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	var ring telemetryRing
+	if ring.latest() != nil {
+		t.Fatal("empty ring has a latest sample")
+	}
+	for i := 0; i < telemetryBufferSize+10; i++ {
+		progress := float64(i)
+		ring.add(TelemetrySample{ProgressPct: &progress, RecordedAt: start.Add(time.Duration(i) * time.Second)})
+	}
+
+	if latest := ring.latest(); latest == nil || *latest.ProgressPct != telemetryBufferSize+9 {
+		t.Fatalf("latest after wrapping: %+v", latest)
+	}
+	all := ring.since(time.Time{})
+	if len(all) != telemetryBufferSize || *all[0].ProgressPct != 10 || *all[len(all)-1].ProgressPct != telemetryBufferSize+9 {
+		t.Fatalf("kept %d samples from %g to %g", len(all), *all[0].ProgressPct, *all[len(all)-1].ProgressPct)
+	}
+	recent := ring.since(start.Add(telemetryBufferSize * time.Second))
+	if len(recent) != 10 || *recent[0].ProgressPct != telemetryBufferSize {
+		t.Fatalf("recent samples: %d", len(recent))
+	}
+}
+
+func TestPrinterTelemetry(t *testing.T) {
+	c := testsupport.NewCluster(t, 1)
+	leader := c.WaitForLeader(10 * time.Second)
+	s := NewServer("", leader.Store)
+	body, _ := json.Marshal(Printer{ID: "p1", Name: "Prusa", Status: PrinterStatusOffline, StatusBeforeOffline: "Idle"})
+	if err := leader.Store.Set("printer_p1", string(body)); err != nil {
+		t.Fatal(err)
+	}
+
+	telemetry := func(method, printerID, query, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(method, "/api/v1/printers/"+printerID+"/telemetry"+query, strings.NewReader(body))
+		s.handlePrinterTelemetry(w, r, printerID)
+		return w
+	}
+
+	tests := []struct {
+		name      string
+		printerID string
+		body      string
+		want      int
+	}{
+		{"sample", "p1", `{"nozzle_temp_c":210,"bed_temp_c":60}`, http.StatusAccepted},
+		{"old sample", "p1", `{"progress_pct":5,"recorded_at":"` + time.Now().Add(-2*time.Hour).UTC().Format(time.RFC3339) + `"}`, http.StatusAccepted},
+		{"no values", "p1", `{}`, http.StatusBadRequest},
+		{"percentage out of range", "p1", `{"fan_speed_pct":120}`, http.StatusBadRequest},
+		{"unknown printer", "p2", `{"nozzle_temp_c":210}`, http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if w := telemetry(http.MethodPost, tt.printerID, "", tt.body); w.Code != tt.want {
+				t.Fatalf("got %d, want %d: %s", w.Code, tt.want, w.Body)
+			}
+		})
+	}
+
+	// The default window leaves out the two hour old sample
+	var got struct {
+		Window  string            `json:"window"`
+		Samples []TelemetrySample `json:"samples"`
+	}
+	w := telemetry(http.MethodGet, "p1", "", "")
+	if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil || got.Window != "1h0m0s" || len(got.Samples) != 1 || *got.Samples[0].NozzleTempC != 210 {
+		t.Fatalf("default window: %d %s", w.Code, w.Body)
+	}
+	w = telemetry(http.MethodGet, "p1", "?window=3h", "")
+	if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil || len(got.Samples) != 2 {
+		t.Fatalf("3h window: %d %s", w.Code, w.Body)
+	}
+	if w := telemetry(http.MethodGet, "p1", "?window=-1h", ""); w.Code != http.StatusBadRequest {
+		t.Fatalf("negative window: %d %s", w.Code, w.Body)
+	}
+
+	// Telemetry brings the printer back online, which shows the sample
+	// reported last
+	printer, err := s.getPrinter("p1")
+	if err != nil || printer.Status != "Idle" {
+		t.Fatalf("printer after telemetry: %+v %v", printer, err)
+	}
+	if latest := printer.withTelemetry(s.telemetry).Telemetry; latest == nil || latest.ProgressPct == nil || *latest.ProgressPct != 5 {
+		t.Fatalf("latest telemetry: %+v", latest)
+	}
+}
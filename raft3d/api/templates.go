@@ -0,0 +1,259 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"raft3d/raft"
+)
+
+// Event types published for job templates
+const (
+	EventTemplateInstantiated = "job_template.instantiated"
+	EventTemplateSkipped      = "job_template.skipped"
+)
+
+// templateKeyPrefix prefixes job template records
+const templateKeyPrefix = "jobtemplate_"
+
+// handleJobTemplates handles /job_templates, /job_templates/{id} and
+// POST /job_templates/{id}/instantiate
+func (s *Server) handleJobTemplates(w http.ResponseWriter, r *http.Request) {
+	path := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/v1/job_templates"), "/")
+	id, action, _ := strings.Cut(path, "/")
+
+	switch {
+	case id == "" && r.Method == http.MethodGet:
+		s.handleListJobTemplates(w, r)
+	case id == "" && r.Method == http.MethodPost:
+		s.handlePostJobTemplate(w, r)
+	case action == "" && r.Method == http.MethodGet:
+		template, err := s.getJobTemplate(id)
+		if err != nil {
+			s.writeStoreError(w, r, err, "Job template not found")
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(template)
+	case action == "" && r.Method == http.MethodDelete:
+		if _, err := s.getJobTemplate(id); err != nil {
+			s.writeStoreError(w, r, err, "Job template not found")
+			return
+		}
+		if err := s.storeFor(r).Delete(templateKeyPrefix + id); err != nil {
+			s.writeStoreError(w, r, err, "Failed to delete job template")
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	case action == "instantiate" && r.Method == http.MethodPost:
+		s.handleInstantiateJobTemplate(w, r, id)
+	case action != "" && action != "instantiate":
+		writeError(w, r, http.StatusNotFound, CodeNotFound, "Unknown job template action")
+	default:
+		methodNotAllowed(w, r)
+	}
+}
+
+// handleListJobTemplates returns every job template
+func (s *Server) handleListJobTemplates(w http.ResponseWriter, r *http.Request) {
+	templates, err := s.listJobTemplates()
+	if err != nil {
+		s.writeStoreError(w, r, err, "Failed to retrieve job templates")
+		return
+	}
+
+	byID := make(map[string]JobTemplate)
+	for _, template := range templates {
+		byID[template.ID] = template
+	}
+	writeMap(w, r, byID)
+}
+
+// handlePostJobTemplate stores a new job template
+func (s *Server) handlePostJobTemplate(w http.ResponseWriter, r *http.Request) {
+	var template JobTemplate
+	if !decodeJSON(w, r, &template) {
+		return
+	}
+
+	var errs []FieldError
+	if template.Schedule != "" {
+		if _, err := parseCron(template.Schedule); err != nil {
+			errs = append(errs, FieldError{Name: "schedule", Reason: err.Error()})
+		}
+	}
+	if _, err := time.LoadLocation(template.Timezone); err != nil {
+		errs = append(errs, FieldError{Name: "timezone", Reason: "unknown time zone"})
+	}
+	if len(errs) > 0 {
+		writeValidationProblem(w, r, errs)
+		return
+	}
+
+	template.SubmittedBy = ""
+	if principal, ok := principalFrom(r); ok {
+		template.SubmittedBy = principal.Name
+	}
+	template.CreatedAt = time.Now().UTC()
+	template.LastScheduledAt = nil
+
+	body, err := json.Marshal(template)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, CodeInternal, "Failed to process job template data")
+		return
+	}
+	if err := s.storeFor(r).Set(templateKeyPrefix+template.ID, string(body)); err != nil {
+		s.writeStoreError(w, r, err, "Failed to store job template")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	w.Write(body)
+}
+
+// handleInstantiateJobTemplate enqueues a job from a template right away
+func (s *Server) handleInstantiateJobTemplate(w http.ResponseWriter, r *http.Request, id string) {
+	template, err := s.getJobTemplate(id)
+	if err != nil {
+		s.writeStoreError(w, r, err, "Job template not found")
+		return
+	}
+
+	job, problem, err := s.instantiateTemplate(r.Context(), template, time.Now().UTC())
+	if err != nil {
+		s.writeStoreError(w, r, err, "Failed to create print job")
+		return
+	}
+	if problem != nil {
+		writeProblem(w, r, *problem)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(job)
+}
+
+// getJobTemplate loads a job template
+func (s *Server) getJobTemplate(id string) (JobTemplate, error) {
+	var template JobTemplate
+	value, err := s.store.Get(templateKeyPrefix + id)
+	if err != nil {
+		return template, err
+	}
+	err = json.Unmarshal([]byte(value), &template)
+	return template, err
+}
+
+// listJobTemplates returns every stored job template
+func (s *Server) listJobTemplates() ([]JobTemplate, error) {
+	keys, err := s.store.List(templateKeyPrefix)
+	if err != nil {
+		return nil, err
+	}
+
+	var templates []JobTemplate
+	for _, key := range keys {
+		template, err := s.getJobTemplate(strings.TrimPrefix(key, templateKeyPrefix))
+		if err != nil {
+			continue
+		}
+		templates = append(templates, template)
+	}
+	return templates, nil
+}
+
+// instantiateTemplate creates the print job for one occurrence of a template.
+// The job ID is derived from the occurrence and written with a create-only
+// command, so a second attempt (for example by a new leader) fails with
+// raft.ErrConflict instead of enqueueing the part twice.
+func (s *Server) instantiateTemplate(ctx context.Context, template JobTemplate, occurrence time.Time) (PrintJob, *Problem, error) {
+	job := PrintJob{
+		ID:                 fmt.Sprintf("%s-%s", template.ID, occurrence.UTC().Format("20060102T1504Z")),
+		PrinterID:          template.PrinterID,
+		PrinterGroupID:     template.PrinterGroupID,
+		FilamentID:         template.FilamentID,
+		FilePath:           template.FilePath,
+		PrintWeightInGrams: template.PrintWeightInGrams,
+		TemplateID:         template.ID,
+	}
+	job, _, problem, err := s.createPrintJob(job, template.SubmittedBy, func(job PrintJob, body string, reservation quotaReservation) (string, error) {
+		return s.store.WithContext(ctx).CreateAndSet("printjob_", job.ID, body, reservation.values, reservation.conditions...)
+	})
+	return job, problem, err
+}
+
+// instantiateDueTemplates enqueues the latest missed occurrence of every
+// scheduled template. Older missed occurrences are skipped rather than
+// flooding the queue after downtime.
+func (s *Server) instantiateDueTemplates(now time.Time) {
+	templates, err := s.listJobTemplates()
+	if err != nil {
+		log.Printf("Scheduler: failed to list job templates: %s", err)
+		return
+	}
+
+	for _, template := range templates {
+		if template.Schedule == "" {
+			continue
+		}
+		schedule, err := parseCron(template.Schedule)
+		if err != nil {
+			continue
+		}
+		loc, err := time.LoadLocation(template.Timezone)
+		if err != nil {
+			continue
+		}
+
+		from := template.CreatedAt
+		if template.LastScheduledAt != nil {
+			from = *template.LastScheduledAt
+		}
+		var due time.Time
+		for next := schedule.next(from.In(loc)); !next.IsZero() && !next.After(now); next = schedule.next(next) {
+			due = next
+		}
+		if due.IsZero() {
+			continue
+		}
+
+		job, problem, err := s.instantiateTemplate(context.Background(), template, due)
+		switch {
+		case errors.Is(err, raft.ErrConflict):
+			// Already created for this occurrence
+		case err != nil:
+			log.Printf("Scheduler: failed to instantiate template %s: %s", template.ID, err)
+			continue
+		case problem != nil:
+			s.publish(EventTemplateSkipped, map[string]interface{}{
+				"template_id": template.ID,
+				"occurrence":  due.UTC(),
+				"reason":      problem.Detail,
+			})
+		default:
+			s.publish(EventTemplateInstantiated, map[string]interface{}{
+				"template_id":  template.ID,
+				"occurrence":   due.UTC(),
+				"print_job_id": job.ID,
+			})
+		}
+
+		scheduledAt := due.UTC()
+		template.LastScheduledAt = &scheduledAt
+		body, err := json.Marshal(template)
+		if err != nil {
+			continue
+		}
+		if err := s.store.Set(templateKeyPrefix+template.ID, string(body)); err != nil {
+			log.Printf("Scheduler: failed to update template %s: %s", template.ID, err)
+		}
+	}
+}
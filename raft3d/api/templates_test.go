@@ -0,0 +1,95 @@
+package api
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"raft3d/testsupport"
+)
+
+func TestCronNext(t *testing.T) {
+	from := time.Date(2026, 3, 14, 9, 30, 45, 0, time.UTC) // a Saturday
+	tests := []struct {
+		expr string
+		want time.Time
+	}{
+		{"* * * * *", time.Date(2026, 3, 14, 9, 31, 0, 0, time.UTC)},
+		{"@hourly", time.Date(2026, 3, 14, 10, 0, 0, 0, time.UTC)},
+		{"*/20 8-17 * * *", time.Date(2026, 3, 14, 9, 40, 0, 0, time.UTC)},
+		{"0 6 * * 1-5", time.Date(2026, 3, 16, 6, 0, 0, 0, time.UTC)},
+		{"0 0 * * 7", time.Date(2026, 3, 15, 0, 0, 0, 0, time.UTC)},
+		{"0 0 1,15 * *", time.Date(2026, 3, 15, 0, 0, 0, 0, time.UTC)},
+		// Restricting both day fields matches either
+		{"0 12 1 * 1", time.Date(2026, 3, 16, 12, 0, 0, 0, time.UTC)},
+		{"0 0 29 2 *", time.Date(2028, 2, 29, 0, 0, 0, 0, time.UTC)},
+		{"0 0 31 2 *", time.Time{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			schedule, err := parseCron(tt.expr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := schedule.next(from); !got.Equal(tt.want) {
+				t.Errorf("next = %s, want %s", got, tt.want)
+			}
+		})
+	}
+
+	for _, expr := range []string{"* * * *", "60 * * * *", "* * 0 * *", "*/0 * * * *", "5-1 * * * *", "a * * * *"} {
+		if _, err := parseCron(expr); err == nil {
+			t.Errorf("parsed invalid expression %q", expr)
+		}
+	}
+}
+
+func TestScheduledTemplateEnqueuesLatestOccurrenceOnce(t *testing.T) {
+	c := testsupport.NewCluster(t, 1)
+	leader := c.WaitForLeader(10 * time.Second)
+	s := NewServer("", leader.Store)
+
+	put := func(key string, v interface{}) {
+		body, _ := json.Marshal(v)
+		if err := leader.Store.Set(key, string(body)); err != nil {
+			t.Fatalf("set %s: %s", key, err)
+		}
+	}
+	put("printer_p1", Printer{ID: "p1", Name: "Prusa", Status: "Idle"})
+	put("filament_f1", Filament{ID: "f1", Name: "PLA", Type: "PLA", TotalWeightInGrams: 1000, RemainingWeightInGrams: 1000})
+	created := time.Date(2026, 3, 14, 0, 0, 0, 0, time.UTC)
+	put(templateKeyPrefix+"nightly", JobTemplate{ID: "nightly", PrinterID: "p1", FilamentID: "f1", FilePath: "clip.gcode",
+		PrintWeightInGrams: 10, Schedule: "0 2 * * *", CreatedAt: created})
+
+	// Three nights were missed; only the latest is enqueued
+	now := time.Date(2026, 3, 16, 12, 0, 0, 0, time.UTC)
+	s.instantiateDueTemplates(now)
+	s.instantiateDueTemplates(now)
+	jobs, err := s.store.List("printjob_")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(jobs) != 1 || jobs[0] != "printjob_nightly-20260316T0200Z" {
+		t.Fatalf("jobs after catching up: %v", jobs)
+	}
+	job, err := s.getPrintJob("nightly-20260316T0200Z")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if job.TemplateID != "nightly" || job.FilePath != "clip.gcode" || job.Status != "Queued" {
+		t.Fatalf("job: %+v", job)
+	}
+	template, err := s.getJobTemplate("nightly")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if template.LastScheduledAt == nil || !template.LastScheduledAt.Equal(time.Date(2026, 3, 16, 2, 0, 0, 0, time.UTC)) {
+		t.Fatalf("template: %+v", template)
+	}
+
+	// The next night's occurrence is a new job
+	s.instantiateDueTemplates(now.Add(24 * time.Hour))
+	if jobs, _ := s.store.List("printjob_"); len(jobs) != 2 {
+		t.Fatalf("jobs the next night: %v", jobs)
+	}
+}
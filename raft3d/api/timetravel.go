@@ -0,0 +1,59 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"regexp"
+	"strconv"
+
+	"raft3d/raft"
+)
+
+// atIndexHeader reports the index a read with ?at_index= was answered at
+const atIndexHeader = "X-Raft-At-Index"
+
+// atIndexPaths matches the reads ?at_index= is supported on: printers,
+// filaments and print jobs, one or all of them, and printer queues
+var atIndexPaths = regexp.MustCompile(`^/api/v1/(printers(/[^/]+(/queue)?)?|filaments(/[^/]+)?|print_jobs(/[^/]+)?)/?$`)
+
+// atIndexKey is the context key of the store view a request reads from
+type atIndexKey struct{}
+
+// atIndex makes GET requests with ?at_index=N read printers, filaments and
+// print jobs as they were after log entry N was applied, for post-mortems.
+// Only recent history is kept; older indexes get 410 Gone.
+func (s *Server) atIndex(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		raw := r.URL.Query().Get("at_index")
+		if raw == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if (r.Method != http.MethodGet && r.Method != http.MethodHead) || !atIndexPaths.MatchString(r.URL.Path) {
+			writeValidationProblem(w, r, []FieldError{{Name: "at_index", Reason: "is only supported when reading printers, printer queues, filaments and print jobs"}})
+			return
+		}
+		index, err := strconv.ParseUint(raw, 10, 64)
+		if err != nil {
+			writeValidationProblem(w, r, []FieldError{{Name: "at_index", Reason: "must be a non-negative integer"}})
+			return
+		}
+
+		view, err := s.store.AtIndex(index)
+		if err != nil {
+			s.writeStoreError(w, r, err, "Failed to read at index "+raw)
+			return
+		}
+		w.Header().Set(atIndexHeader, raw)
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), atIndexKey{}, view)))
+	})
+}
+
+// readStore returns the store a request reads from: a view of the past when
+// it asked for ?at_index=, reported as true, or the current state
+func (s *Server) readStore(r *http.Request) (raft.Store, bool) {
+	if view, ok := r.Context().Value(atIndexKey{}).(raft.Store); ok {
+		return view, true
+	}
+	return s.store, false
+}
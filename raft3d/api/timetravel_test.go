@@ -0,0 +1,67 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"raft3d/testsupport"
+)
+
+func TestReadsAtIndex(t *testing.T) {
+	c := testsupport.NewCluster(t, 1)
+	leader := c.WaitForLeader(10 * time.Second)
+	s := NewServer("", leader.Store)
+	handler := s.atIndex(http.HandlerFunc(s.handlePrintJobs))
+
+	for _, status := range []string{"Queued", "Running"} {
+		body, _ := json.Marshal(PrintJob{ID: "j1", PrinterID: "p1", FilamentID: "f1", FilePath: "part.gcode", Status: status})
+		if err := leader.Store.Set("printjob_j1", string(body)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// The node registering itself may be applied in between, so the first
+	// write's index comes from the log
+	page, err := leader.Store.ReadLog(0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var queued uint64
+	for _, entry := range page.Entries {
+		if len(entry.Keys) > 0 && entry.Keys[0] == "printjob_j1" && queued == 0 {
+			queued = entry.Index
+		}
+	}
+
+	get := func(url string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, url, nil))
+		return rec
+	}
+	rec := get(fmt.Sprintf("/api/v1/print_jobs/j1?at_index=%d", queued))
+	var job PrintJob
+	if rec.Code != http.StatusOK || json.NewDecoder(rec.Body).Decode(&job) != nil || job.Status != "Queued" || rec.Header().Get(atIndexHeader) != fmt.Sprint(queued) {
+		t.Fatalf("job at %d: %d %+v", queued, rec.Code, job)
+	}
+	rec = get(fmt.Sprintf("/api/v1/print_jobs?at_index=%d", queued-1))
+	var jobs map[string]PrintJob
+	if rec.Code != http.StatusOK || json.NewDecoder(rec.Body).Decode(&jobs) != nil || len(jobs) != 0 {
+		t.Fatalf("jobs before the first write: %d %v", rec.Code, jobs)
+	}
+	if rec := get("/api/v1/print_jobs/j1"); rec.Code != http.StatusOK || rec.Header().Get(atIndexHeader) != "" {
+		t.Fatalf("current read: %d", rec.Code)
+	}
+
+	for url, want := range map[string]int{
+		fmt.Sprintf("/api/v1/print_jobs/j1?at_index=%d", queued+1000): http.StatusBadRequest,
+		"/api/v1/print_jobs/j1?at_index=latest":                       http.StatusBadRequest,
+		"/api/v1/print_jobs/j1/artifacts?at_index=1":                  http.StatusBadRequest,
+	} {
+		if rec := get(url); rec.Code != want {
+			t.Errorf("%s: %d %s, want %d", url, rec.Code, rec.Body, want)
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package api
+
+import "math"
+
+// withFilamentDefaults fills in the density and diameter of a filament that
+// was posted without them, taking the density from the material catalog
+func (s *Server) withFilamentDefaults(filament Filament) Filament {
+	if filament.DiameterMM == 0 {
+		filament.DiameterMM = defaultFilamentDiameterMM
+	}
+	if filament.DensityGPerCm3 == 0 {
+		if material, err := s.getMaterial(filament.Type); err == nil {
+			filament.DensityGPerCm3 = material.DensityGPerCm3
+		}
+	}
+	return filament
+}
+
+// resolvePrintQuantity converts the amount of filament a job asks for to
+// grams and fills in the other units. A job gives exactly one of a weight, a
+// volume or a length.
+//
+// The conversion is done here, before the job is proposed, rather than by the
+// FSM: the FSM only stores and checks values, and knows nothing of filaments
+// or materials. Every job reaches the log already in grams, so availability
+// is only ever computed from print_weight_in_grams.
+func resolvePrintQuantity(job *PrintJob, filament Filament) []FieldError {
+	given := 0
+	for _, quantity := range []float64{job.PrintWeightInGrams, job.PrintVolumeInCm3, job.PrintLengthInMeters} {
+		if quantity > 0 {
+			given++
+		}
+	}
+	if given > 1 {
+		return []FieldError{{Name: "print_weight_in_grams", Reason: "give only one of print_weight_in_grams, print_volume_in_cm3 or print_length_in_meters"}}
+	}
+
+	density := filament.DensityGPerCm3
+	noDensity := []FieldError{{Name: "filament_id", Reason: "filament has no density to convert from; set density_g_per_cm3"}}
+
+	var volume float64
+	switch {
+	case job.PrintWeightInGrams > 0:
+		if density > 0 {
+			volume = job.PrintWeightInGrams / density
+		}
+	case job.PrintVolumeInCm3 > 0:
+		if density == 0 {
+			return noDensity
+		}
+		volume = job.PrintVolumeInCm3
+		job.PrintWeightInGrams = volume * density
+	case job.PrintLengthInMeters > 0:
+		if density == 0 {
+			return noDensity
+		}
+		if filament.DiameterMM == 0 {
+			return []FieldError{{Name: "filament_id", Reason: "filament has no diameter to convert from; set diameter_mm"}}
+		}
+		volume = job.PrintLengthInMeters * filament.cm3PerMeter()
+		job.PrintWeightInGrams = volume * density
+	default:
+		return []FieldError{{Name: "print_weight_in_grams", Reason: "one of print_weight_in_grams, print_volume_in_cm3 or print_length_in_meters is required"}}
+	}
+
+	// Weights are kept to the milligram
+	job.PrintWeightInGrams = roundGrams(job.PrintWeightInGrams)
+	if job.PrintWeightInGrams <= 0 {
+		return []FieldError{{Name: "print_weight_in_grams", Reason: "must be at least 0.001"}}
+	}
+	job.PrintVolumeInCm3, job.PrintLengthInMeters = 0, 0
+	if volume > 0 {
+		job.PrintVolumeInCm3 = roundThousandths(volume)
+		if filament.DiameterMM > 0 {
+			job.PrintLengthInMeters = roundThousandths(volume / filament.cm3PerMeter())
+		}
+	}
+	return nil
+}
+
+// roundThousandths rounds a length or volume to three decimals
+func roundThousandths(v float64) float64 {
+	return math.Round(v*1000) / 1000
+}
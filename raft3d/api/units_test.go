@@ -0,0 +1,55 @@
+package api
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestResolvePrintQuantity(t *testing.T) {
+	pla := Filament{ID: "f1", Type: "PLA", DensityGPerCm3: 1.24, DiameterMM: 1.75}
+	noDensity := Filament{ID: "f1", Type: "Exotic", DiameterMM: 1.75}
+	noDiameter := Filament{ID: "f1", Type: "PLA", DensityGPerCm3: 1.24}
+
+	tests := []struct {
+		name     string
+		job      PrintJob
+		filament Filament
+		want     PrintJob
+		err      string
+	}{
+		{"meters to grams", PrintJob{PrintLengthInMeters: 4.2}, pla,
+			PrintJob{PrintWeightInGrams: 12.527, PrintVolumeInCm3: 10.102, PrintLengthInMeters: 4.2}, ""},
+		{"cm3 to grams", PrintJob{PrintVolumeInCm3: 10}, pla,
+			PrintJob{PrintWeightInGrams: 12.4, PrintVolumeInCm3: 10, PrintLengthInMeters: 4.158}, ""},
+		{"grams to the other units", PrintJob{PrintWeightInGrams: 25}, pla,
+			PrintJob{PrintWeightInGrams: 25, PrintVolumeInCm3: 20.161, PrintLengthInMeters: 8.382}, ""},
+		{"grams without a density", PrintJob{PrintWeightInGrams: 25}, noDensity,
+			PrintJob{PrintWeightInGrams: 25}, ""},
+		{"meters without a density", PrintJob{PrintLengthInMeters: 4.2}, noDensity, PrintJob{}, "no density"},
+		{"cm3 without a density", PrintJob{PrintVolumeInCm3: 10}, noDensity, PrintJob{}, "no density"},
+		{"meters without a diameter", PrintJob{PrintLengthInMeters: 4.2}, noDiameter, PrintJob{}, "no diameter"},
+		{"grams and meters", PrintJob{PrintWeightInGrams: 25, PrintLengthInMeters: 4.2}, pla, PrintJob{}, "only one of"},
+		{"cm3 and meters", PrintJob{PrintVolumeInCm3: 10, PrintLengthInMeters: 4.2}, pla, PrintJob{}, "only one of"},
+		{"nothing", PrintJob{}, pla, PrintJob{}, "is required"},
+		{"less than a milligram", PrintJob{PrintVolumeInCm3: 0.0001}, pla, PrintJob{}, "at least 0.001"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			job := tt.job
+			errs := resolvePrintQuantity(&job, tt.filament)
+			if tt.err != "" {
+				if len(errs) != 1 || !strings.Contains(errs[0].Reason, tt.err) {
+					t.Fatalf("got errors %+v, want %q", errs, tt.err)
+				}
+				return
+			}
+			if len(errs) > 0 {
+				t.Fatalf("errors %+v", errs)
+			}
+			if job.PrintWeightInGrams != tt.want.PrintWeightInGrams || job.PrintVolumeInCm3 != tt.want.PrintVolumeInCm3 || job.PrintLengthInMeters != tt.want.PrintLengthInMeters {
+				t.Fatalf("resolved to %gg, %gcm3, %gm, want %gg, %gcm3, %gm", job.PrintWeightInGrams, job.PrintVolumeInCm3, job.PrintLengthInMeters,
+					tt.want.PrintWeightInGrams, tt.want.PrintVolumeInCm3, tt.want.PrintLengthInMeters)
+			}
+		})
+	}
+}
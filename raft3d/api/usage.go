@@ -0,0 +1,154 @@
+package api
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// usageKeyPrefix prefixes filament usage records, one per completed job
+const usageKeyPrefix = "usage_"
+
+// Forecast defaults, overridable with ?window_days= and ?lead_time_days=
+const (
+	defaultForecastWindowDays = 30
+	defaultReorderLeadDays    = 7
+)
+
+// usageRecord encodes the filament consumed by a job that just completed
+func usageRecord(job PrintJob, filament Filament) (string, error) {
+	recordedAt := time.Now().UTC()
+	if job.CompletedAt != nil {
+		recordedAt = *job.CompletedAt
+	}
+	usage := FilamentUsage{
+		PrintJobID:    job.ID,
+		FilamentID:    job.FilamentID,
+		PrinterID:     job.PrinterID,
+		SubmittedBy:   job.SubmittedBy,
+		FilamentType:  filament.Type,
+		WeightInGrams: job.PrintWeightInGrams,
+		Cost:          job.MaterialCost,
+		RecordedAt:    recordedAt,
+	}
+	body, err := json.Marshal(usage)
+	return string(body), err
+}
+
+// listUsage returns every recorded usage, optionally for a single filament
+func (s *Server) listUsage(filamentID string) ([]FilamentUsage, error) {
+	keys, err := s.store.List(usageKeyPrefix)
+	if err != nil {
+		return nil, err
+	}
+
+	var usages []FilamentUsage
+	for _, key := range keys {
+		value, err := s.store.Get(key)
+		if err != nil {
+			continue
+		}
+
+		var usage FilamentUsage
+		if err := json.Unmarshal([]byte(value), &usage); err != nil {
+			continue
+		}
+		if filamentID != "" && usage.FilamentID != filamentID {
+			continue
+		}
+		usages = append(usages, usage)
+	}
+	return usages, nil
+}
+
+// handleFilamentForecast handles GET /filaments/{id}/forecast, estimating when
+// the roll runs out from its consumption over the last window_days
+func (s *Server) handleFilamentForecast(w http.ResponseWriter, r *http.Request, id string) {
+	windowDays, ok := positiveIntParam(w, r, "window_days", defaultForecastWindowDays)
+	if !ok {
+		return
+	}
+	leadDays, ok := positiveIntParam(w, r, "lead_time_days", defaultReorderLeadDays)
+	if !ok {
+		return
+	}
+
+	value, err := s.store.Get("filament_" + id)
+	if err != nil {
+		s.writeStoreError(w, r, err, "Filament not found")
+		return
+	}
+	var filament Filament
+	if err := json.Unmarshal([]byte(value), &filament); err != nil {
+		writeError(w, r, http.StatusInternalServerError, CodeInternal, "Failed to parse filament data")
+		return
+	}
+
+	usages, err := s.listUsage(id)
+	if err != nil {
+		s.writeStoreError(w, r, err, "Failed to retrieve usage history")
+		return
+	}
+
+	forecast := forecastDepletion(filament, usages, windowDays, leadDays, time.Now().UTC())
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(forecast)
+}
+
+// forecastDepletion projects the current usage rate forward. The rate is
+// averaged over the window, or over the history itself when it is shorter,
+// so a roll that started being used yesterday isn't diluted by empty days.
+func forecastDepletion(filament Filament, usages []FilamentUsage, windowDays, leadDays int, now time.Time) FilamentForecast {
+	forecast := FilamentForecast{
+		FilamentID:             filament.ID,
+		RemainingWeightInGrams: filament.RemainingWeightInGrams,
+		WindowDays:             windowDays,
+	}
+
+	windowStart := now.AddDate(0, 0, -windowDays)
+	first := now
+	for _, usage := range usages {
+		if usage.RecordedAt.Before(windowStart) {
+			continue
+		}
+		forecast.UsedInWindowGrams = roundGrams(forecast.UsedInWindowGrams + usage.WeightInGrams)
+		if usage.RecordedAt.Before(first) {
+			first = usage.RecordedAt
+		}
+	}
+	if forecast.UsedInWindowGrams == 0 {
+		return forecast
+	}
+
+	days := math.Max(now.Sub(first).Hours()/24, 1)
+	forecast.GramsPerDay = forecast.UsedInWindowGrams / days
+
+	remaining := filament.RemainingWeightInGrams / forecast.GramsPerDay
+	depletion := now.Add(time.Duration(remaining * float64(24*time.Hour)))
+	reorder := depletion.AddDate(0, 0, -leadDays)
+	if reorder.Before(now) {
+		reorder = now
+	}
+	forecast.DaysUntilDepletion = &remaining
+	forecast.DepletionDate = &depletion
+	forecast.ReorderDate = &reorder
+	return forecast
+}
+
+// positiveIntParam reads an optional positive integer query parameter,
+// writing a validation problem if it is malformed
+func positiveIntParam(w http.ResponseWriter, r *http.Request, name string, def int) (int, bool) {
+	raw := r.URL.Query().Get(name)
+	if raw == "" {
+		return def, true
+	}
+	n, err := strconv.Atoi(raw)
+	if err != nil || n <= 0 {
+		writeValidationProblem(w, r, []FieldError{{Name: name, Reason: "must be a positive integer"}})
+		return 0, false
+	}
+	return n, true
+}
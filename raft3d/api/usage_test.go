@@ -0,0 +1,109 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"raft3d/testsupport"
+)
+
+func TestForecastDepletion(t *testing.T) {
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	daysAgo := func(days float64) time.Time { return now.Add(-time.Duration(days * float64(24*time.Hour))) }
+	used := func(grams, days float64) FilamentUsage {
+		return FilamentUsage{FilamentID: "f1", WeightInGrams: grams, RecordedAt: daysAgo(days)}
+	}
+
+	tests := []struct {
+		name        string
+		remaining   float64
+		usages      []FilamentUsage
+		gramsPerDay float64
+		daysLeft    float64 // 0 when no depletion is forecast
+		reorderDays float64 // from now
+	}{
+		{"no history", 500, nil, 0, 0, 0},
+		{"only before the window", 500, []FilamentUsage{used(100, 45)}, 0, 0, 0},
+		{"nothing used", 500, []FilamentUsage{used(0, 3)}, 0, 0, 0},
+		{"steady use", 500, []FilamentUsage{used(60, 10), used(40, 5), used(100, 40)}, 10, 50, 43},
+		{"shorter history than a day", 90, []FilamentUsage{used(30, 0.1)}, 30, 3, 0},
+		{"reorder overdue", 20, []FilamentUsage{used(100, 10)}, 10, 2, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			forecast := forecastDepletion(Filament{ID: "f1", RemainingWeightInGrams: tt.remaining}, tt.usages, 30, 7, now)
+			if forecast.GramsPerDay != tt.gramsPerDay {
+				t.Fatalf("%g grams per day, want %g", forecast.GramsPerDay, tt.gramsPerDay)
+			}
+			if tt.daysLeft == 0 {
+				if forecast.DaysUntilDepletion != nil || forecast.DepletionDate != nil || forecast.ReorderDate != nil {
+					t.Fatalf("forecast a depletion: %+v", forecast)
+				}
+				return
+			}
+			if forecast.DaysUntilDepletion == nil || *forecast.DaysUntilDepletion != tt.daysLeft {
+				t.Fatalf("days until depletion %v, want %g", forecast.DaysUntilDepletion, tt.daysLeft)
+			}
+			if want := now.Add(time.Duration(tt.daysLeft * float64(24*time.Hour))); !forecast.DepletionDate.Equal(want) {
+				t.Fatalf("depletion %s, want %s", forecast.DepletionDate, want)
+			}
+			if want := now.AddDate(0, 0, int(tt.reorderDays)); !forecast.ReorderDate.Equal(want) {
+				t.Fatalf("reorder %s, want %s", forecast.ReorderDate, want)
+			}
+		})
+	}
+}
+
+func TestFilamentForecastEndpoint(t *testing.T) {
+	c := testsupport.NewCluster(t, 1)
+	leader := c.WaitForLeader(10 * time.Second)
+	s := NewServer("", leader.Store)
+
+	put := func(key string, v interface{}) {
+		body, _ := json.Marshal(v)
+		if err := leader.Store.Set(key, string(body)); err != nil {
+			t.Fatalf("set %s: %s", key, err)
+		}
+	}
+	now := time.Now().UTC()
+	put("filament_f1", Filament{ID: "f1", Name: "PLA", Type: "PLA", TotalWeightInGrams: 1000, RemainingWeightInGrams: 400})
+	put("filament_f2", Filament{ID: "f2", Name: "PETG", Type: "PETG", TotalWeightInGrams: 1000, RemainingWeightInGrams: 1000})
+	put(usageKeyPrefix+"j1", FilamentUsage{PrintJobID: "j1", FilamentID: "f1", WeightInGrams: 100, RecordedAt: now.AddDate(0, 0, -10)})
+	put(usageKeyPrefix+"j2", FilamentUsage{PrintJobID: "j2", FilamentID: "f1", WeightInGrams: 100, RecordedAt: now.AddDate(0, 0, -2)})
+	put(usageKeyPrefix+"j3", FilamentUsage{PrintJobID: "j3", FilamentID: "f2", WeightInGrams: 300, RecordedAt: now.AddDate(0, 0, -60)})
+
+	forecast := func(id, query string) (*httptest.ResponseRecorder, FilamentForecast) {
+		w := httptest.NewRecorder()
+		s.handleFilamentForecast(w, httptest.NewRequest(http.MethodGet, "/api/v1/filaments/"+id+"/forecast"+query, nil), id)
+		var forecast FilamentForecast
+		json.Unmarshal(w.Body.Bytes(), &forecast)
+		return w, forecast
+	}
+
+	// Only f1's own usage counts: 200g over 10 days
+	w, got := forecast("f1", "")
+	if w.Code != http.StatusOK || got.WindowDays != 30 || got.UsedInWindowGrams != 200 || got.DaysUntilDepletion == nil ||
+		got.GramsPerDay < 19.99 || got.GramsPerDay > 20.01 || *got.DaysUntilDepletion < 19.9 || *got.DaysUntilDepletion > 20.1 {
+		t.Fatalf("f1: %d %s", w.Code, w.Body)
+	}
+
+	// A narrower window sees only the recent job
+	if w, got := forecast("f1", "?window_days=5"); w.Code != http.StatusOK || got.UsedInWindowGrams != 100 || got.GramsPerDay < 49.9 || got.GramsPerDay > 50.1 {
+		t.Fatalf("f1 over 5 days: %d %s", w.Code, w.Body)
+	}
+
+	// Usage older than the window forecasts nothing
+	if w, got := forecast("f2", ""); w.Code != http.StatusOK || got.GramsPerDay != 0 || got.DaysUntilDepletion != nil || got.RemainingWeightInGrams != 1000 {
+		t.Fatalf("f2: %d %s", w.Code, w.Body)
+	}
+
+	if w, _ := forecast("f1", "?window_days=0"); w.Code != http.StatusBadRequest {
+		t.Fatalf("window_days=0: %d %s", w.Code, w.Body)
+	}
+	if w, _ := forecast("f9", ""); w.Code != http.StatusNotFound {
+		t.Fatalf("unknown filament: %d %s", w.Code, w.Body)
+	}
+}
@@ -0,0 +1,202 @@
+package api
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"sort"
+	"time"
+)
+
+// defaultUtilizationRange is reported when ?from= isn't given
+const defaultUtilizationRange = 30 * 24 * time.Hour
+
+// interval is a span of time
+type interval struct {
+	start, end time.Time
+}
+
+// hours returns the total length of intervals, which must not overlap
+func hours(intervals []interval) float64 {
+	var total time.Duration
+	for _, iv := range intervals {
+		total += iv.end.Sub(iv.start)
+	}
+	return total.Hours()
+}
+
+// clipAndMerge limits intervals to [from, to) and merges overlapping ones
+func clipAndMerge(intervals []interval, from, to time.Time) []interval {
+	var clipped []interval
+	for _, iv := range intervals {
+		if iv.start.Before(from) {
+			iv.start = from
+		}
+		if iv.end.After(to) {
+			iv.end = to
+		}
+		if iv.end.After(iv.start) {
+			clipped = append(clipped, iv)
+		}
+	}
+	sort.Slice(clipped, func(i, j int) bool { return clipped[i].start.Before(clipped[j].start) })
+
+	var merged []interval
+	for _, iv := range clipped {
+		if n := len(merged); n > 0 && !iv.start.After(merged[n-1].end) {
+			if iv.end.After(merged[n-1].end) {
+				merged[n-1].end = iv.end
+			}
+			continue
+		}
+		merged = append(merged, iv)
+	}
+	return merged
+}
+
+// subtract removes the time covered by minus from intervals. Both must be
+// sorted and merged, as clipAndMerge returns them.
+func subtract(intervals, minus []interval) []interval {
+	var left []interval
+	for _, iv := range intervals {
+		for _, m := range minus {
+			if !m.end.After(iv.start) {
+				continue
+			}
+			if !m.start.Before(iv.end) {
+				break
+			}
+			if m.start.After(iv.start) {
+				left = append(left, interval{iv.start, m.start})
+			}
+			iv.start = m.end
+			if !iv.end.After(iv.start) {
+				break
+			}
+		}
+		if iv.end.After(iv.start) {
+			left = append(left, iv)
+		}
+	}
+	return left
+}
+
+// handleUtilizationReport handles GET /api/v1/reports/utilization,
+// comparing each printer's busy hours with the hours it was available within
+// ?from= and ?to= (default the last 30 days). Busy time runs from a job's
+// start to its completion, or to now while it is Running; canceled jobs have
+// no recorded end and aren't counted. Maintenance windows are not available
+// time, so a job printing through one is only busy outside it.
+func (s *Server) handleUtilizationReport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, r)
+		return
+	}
+
+	csvOut, ok := wantsCSV(w, r)
+	if !ok {
+		return
+	}
+
+	now := time.Now().UTC()
+	var errs []FieldError
+	from, err := parseReportTime(r.URL.Query().Get("from"))
+	if err != nil {
+		errs = append(errs, FieldError{Name: "from", Reason: err.Error()})
+	}
+	to, err := parseReportTime(r.URL.Query().Get("to"))
+	if err != nil {
+		errs = append(errs, FieldError{Name: "to", Reason: err.Error()})
+	}
+	if len(errs) > 0 {
+		writeValidationProblem(w, r, errs)
+		return
+	}
+	report := UtilizationReport{To: now, ByPrinter: make(map[string]PrinterUtilization)}
+	if to != nil {
+		report.To = *to
+	}
+	report.From = report.To.Add(-defaultUtilizationRange)
+	if from != nil {
+		report.From = *from
+	}
+	if !report.To.After(report.From) {
+		writeValidationProblem(w, r, []FieldError{{Name: "to", Reason: "must be after from"}})
+		return
+	}
+
+	printers, err := s.listPrinters()
+	if err != nil {
+		s.writeStoreError(w, r, err, "Failed to retrieve printers")
+		return
+	}
+	jobs, err := s.listPrintJobs()
+	if err != nil {
+		s.writeStoreError(w, r, err, "Failed to retrieve print jobs")
+		return
+	}
+	windows, err := s.listMaintenanceWindows("")
+	if err != nil {
+		s.writeStoreError(w, r, err, "Failed to retrieve maintenance windows")
+		return
+	}
+
+	busy := make(map[string][]interval)
+	jobCount := make(map[string]int)
+	for _, job := range jobs {
+		if job.StartedAt == nil {
+			continue
+		}
+		end := now
+		switch {
+		case job.Status == "Done" && job.CompletedAt != nil:
+			end = *job.CompletedAt
+		case job.Status != "Running":
+			continue
+		}
+		if job.StartedAt.Before(report.To) && end.After(report.From) {
+			jobCount[job.PrinterID]++
+		}
+		busy[job.PrinterID] = append(busy[job.PrinterID], interval{*job.StartedAt, end})
+	}
+	maintenance := make(map[string][]interval)
+	for _, window := range windows {
+		maintenance[window.PrinterID] = append(maintenance[window.PrinterID], interval{window.Start, window.End})
+	}
+
+	period := report.To.Sub(report.From).Hours()
+	for _, printer := range printers {
+		down := clipAndMerge(maintenance[printer.ID], report.From, report.To)
+		u := PrinterUtilization{
+			Jobs:             jobCount[printer.ID],
+			BusyHours:        hours(subtract(clipAndMerge(busy[printer.ID], report.From, report.To), down)),
+			MaintenanceHours: hours(down),
+		}
+		u.AvailableHours = period - u.MaintenanceHours
+		report.ByPrinter[printer.ID] = u.rounded()
+
+		report.Total.Jobs += u.Jobs
+		report.Total.BusyHours += u.BusyHours
+		report.Total.MaintenanceHours += u.MaintenanceHours
+		report.Total.AvailableHours += u.AvailableHours
+	}
+	report.Total = report.Total.rounded()
+
+	if csvOut {
+		writeUtilizationReportCSV(w, report)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
+
+// rounded computes the utilization and rounds the hours for the response
+func (u PrinterUtilization) rounded() PrinterUtilization {
+	if u.AvailableHours > 0 {
+		u.UtilizationPct = math.Min(100, math.Round(u.BusyHours/u.AvailableHours*1000)/10)
+	}
+	u.BusyHours = roundThousandths(u.BusyHours)
+	u.MaintenanceHours = roundThousandths(u.MaintenanceHours)
+	u.AvailableHours = roundThousandths(u.AvailableHours)
+	return u
+}
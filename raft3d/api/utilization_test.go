@@ -0,0 +1,155 @@
+package api
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"raft3d/testsupport"
+)
+
+func TestClipAndMerge(t *testing.T) {
+	base := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+	at := func(hours float64) time.Time { return base.Add(time.Duration(hours * float64(time.Hour))) }
+	span := func(from, to float64) interval { return interval{at(from), at(to)} }
+
+	tests := []struct {
+		name      string
+		intervals []interval
+		want      []interval
+	}{
+		{"overlapping", []interval{span(1, 3), span(2, 4)}, []interval{span(1, 4)}},
+		{"contained", []interval{span(1, 5), span(2, 3)}, []interval{span(1, 5)}},
+		{"touching", []interval{span(1, 2), span(2, 3)}, []interval{span(1, 3)}},
+		{"apart and unsorted", []interval{span(5, 6), span(1, 2)}, []interval{span(1, 2), span(5, 6)}},
+		{"clipped at from", []interval{span(-2, 1)}, []interval{span(0, 1)}},
+		{"clipped at to", []interval{span(9, 12)}, []interval{span(9, 10)}},
+		{"outside", []interval{span(-3, -1), span(10, 11), span(11, 12)}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := clipAndMerge(tt.intervals, at(0), at(10))
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if !got[i].start.Equal(tt.want[i].start) || !got[i].end.Equal(tt.want[i].end) {
+					t.Fatalf("got %v, want %v", got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestSubtract(t *testing.T) {
+	base := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+	at := func(hours float64) time.Time { return base.Add(time.Duration(hours * float64(time.Hour))) }
+	span := func(from, to float64) interval { return interval{at(from), at(to)} }
+
+	tests := []struct {
+		name  string
+		busy  []interval
+		minus []interval
+		want  float64
+	}{
+		{"no maintenance", []interval{span(0, 4)}, nil, 4},
+		{"maintenance inside a job", []interval{span(0, 4)}, []interval{span(1, 2)}, 3},
+		{"maintenance over the start", []interval{span(1, 4)}, []interval{span(0, 2)}, 2},
+		{"maintenance over the end", []interval{span(0, 4)}, []interval{span(3, 5)}, 3},
+		{"maintenance over the whole job", []interval{span(1, 2)}, []interval{span(0, 3)}, 0},
+		{"two windows in a job", []interval{span(0, 10)}, []interval{span(1, 2), span(5, 8)}, 6},
+		{"a window over two jobs", []interval{span(0, 2), span(3, 5)}, []interval{span(1, 4)}, 2},
+		{"maintenance between jobs", []interval{span(0, 1), span(3, 4)}, []interval{span(1, 3)}, 2},
+	}
+	for _, tt := range tests {
+		if got := hours(subtract(tt.busy, tt.minus)); got != tt.want {
+			t.Errorf("%s: %g busy hours, want %g", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestUtilizationReport(t *testing.T) {
+	c := testsupport.NewCluster(t, 1)
+	leader := c.WaitForLeader(10 * time.Second)
+	s := NewServer("", leader.Store)
+
+	put := func(key string, v interface{}) {
+		body, _ := json.Marshal(v)
+		if err := leader.Store.Set(key, string(body)); err != nil {
+			t.Fatalf("set %s: %s", key, err)
+		}
+	}
+	now := time.Now().UTC().Truncate(time.Second)
+	ago := func(hours float64) *time.Time {
+		at := now.Add(-time.Duration(hours * float64(time.Hour)))
+		return &at
+	}
+	put("printer_p1", Printer{ID: "p1", Name: "Prusa", Status: "Printing"})
+	put("printer_p2", Printer{ID: "p2", Name: "Ender", Status: "Idle"})
+	job := func(id, status string, started, completed *time.Time) {
+		put("printjob_"+id, PrintJob{ID: id, PrinterID: "p1", FilamentID: "f1", FilePath: "a.gcode", PrintWeightInGrams: 10,
+			Status: status, StartedAt: started, CompletedAt: completed})
+	}
+	job("done", "Done", ago(5), ago(3))
+	job("running", "Running", ago(0.5), nil)
+	job("canceled", "Canceled", ago(10), nil)
+	job("old", "Done", ago(40*24), ago(40*24-1))
+	put(maintenanceKeyPrefix+"m1", MaintenanceWindow{ID: "m1", PrinterID: "p1", Start: *ago(4), End: *ago(3.5)})
+
+	report := func(query string) UtilizationReport {
+		t.Helper()
+		w := httptest.NewRecorder()
+		s.handleUtilizationReport(w, httptest.NewRequest(http.MethodGet, "/api/v1/reports/utilization"+query, nil))
+		var report UtilizationReport
+		if w.Code != http.StatusOK || json.Unmarshal(w.Body.Bytes(), &report) != nil {
+			t.Fatalf("report%s: %d %s", query, w.Code, w.Body)
+		}
+		return report
+	}
+
+	// By default the last 30 days up to now, with the running job busy
+	// until now and the maintenance window taken out of the finished one
+	got := report("")
+	if got.To.Before(now) || got.To.Sub(now) > time.Minute || !got.From.Equal(got.To.Add(-defaultUtilizationRange)) {
+		t.Fatalf("default period %s to %s", got.From, got.To)
+	}
+	p1 := got.ByPrinter["p1"]
+	if p1.Jobs != 2 || math.Abs(p1.BusyHours-2) > 0.02 || p1.MaintenanceHours != 0.5 || p1.AvailableHours != 719.5 {
+		t.Fatalf("p1: %+v", p1)
+	}
+	if p2 := got.ByPrinter["p2"]; p2.Jobs != 0 || p2.BusyHours != 0 || p2.AvailableHours != 720 || p2.UtilizationPct != 0 {
+		t.Fatalf("p2: %+v", p2)
+	}
+	if got.Total.Jobs != 2 || got.Total.AvailableHours != 1439.5 {
+		t.Fatalf("total: %+v", got.Total)
+	}
+
+	// A period ending before the running job started clips the finished one
+	period := "?from=" + url.QueryEscape(ago(4.5).Format(time.RFC3339)) + "&to=" + url.QueryEscape(ago(3).Format(time.RFC3339))
+	if p1 := report(period).ByPrinter["p1"]; p1.Jobs != 1 || p1.BusyHours != 1 || p1.AvailableHours != 1 || p1.UtilizationPct != 100 {
+		t.Fatalf("p1 over 1.5 hours: %+v", p1)
+	}
+
+	w := httptest.NewRecorder()
+	s.handleUtilizationReport(w, httptest.NewRequest(http.MethodGet, "/api/v1/reports/utilization"+period+"&format=csv", nil))
+	rows, err := csv.NewReader(w.Body).ReadAll()
+	if err != nil || !strings.HasPrefix(w.Header().Get("Content-Type"), "text/csv") {
+		t.Fatalf("csv: %v %s", err, w.Header().Get("Content-Type"))
+	}
+	if len(rows) != 4 || strings.Join(rows[0], ",") != "printer_id,jobs,busy_hours,maintenance_hours,available_hours,utilization_pct" ||
+		strings.Join(rows[1], ",") != ",1,1,0.5,2.5,40" || strings.Join(rows[2], ",") != "p1,1,1,0.5,1,100" || strings.Join(rows[3], ",") != "p2,0,0,0,1.5,0" {
+		t.Fatalf("csv rows %q", rows)
+	}
+
+	w = httptest.NewRecorder()
+	s.handleUtilizationReport(w, httptest.NewRequest(http.MethodGet, "/api/v1/reports/utilization?from=2024-06-01&to=2024-05-01", nil))
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("to before from: %d %s", w.Code, w.Body)
+	}
+}
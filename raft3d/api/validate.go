@@ -0,0 +1,194 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// FieldError describes a single invalid field in a request
+type FieldError struct {
+	Name   string `json:"name"`
+	Reason string `json:"reason"`
+}
+
+// Validate checks v against its `validate` struct tags and returns every violation.
+//
+// Supported rules are:
+//   - required:   the field must not be the zero value
+//   - omitempty:  skip the remaining rules when the field is the zero value
+//   - gt=N, gte=N: numeric lower bounds
+//   - oneof=A B C: the value must be one of the space separated options
+//   - labels:     a map of label keys to values within the label limits
+//   - annotations: a map of strings within the annotation size limit
+//
+// Any other rule fails validation, so a typo in a tag can't go unnoticed.
+//
+// Nested structs, and pointers to them when set, are validated too, with
+// their fields named parent.child; embedded structs keep their fields' names.
+// A `validate:"-"` tag skips a field entirely.
+func Validate(v interface{}) []FieldError {
+	return validateStruct(reflect.ValueOf(v), "")
+}
+
+// validateStruct validates the fields of a struct, prefixing their names
+func validateStruct(val reflect.Value, prefix string) []FieldError {
+	for val.Kind() == reflect.Ptr {
+		if val.IsNil() {
+			return nil
+		}
+		val = val.Elem()
+	}
+	if val.Kind() != reflect.Struct {
+		return nil
+	}
+
+	var errs []FieldError
+	typ := val.Type()
+	for i := 0; i < typ.NumField(); i++ {
+		field := typ.Field(i)
+		tag := field.Tag.Get("validate")
+		if tag == "-" || !field.IsExported() {
+			continue
+		}
+
+		name := prefix + jsonFieldName(field)
+		fv := val.Field(i)
+		valid := true
+		for _, rule := range strings.Split(tag, ",") {
+			if rule == "" {
+				continue
+			}
+			if rule == "omitempty" {
+				if fv.IsZero() {
+					break
+				}
+				continue
+			}
+			if reason := checkRule(fv, rule); reason != "" {
+				errs = append(errs, FieldError{Name: name, Reason: reason})
+				valid = false
+				break
+			}
+		}
+
+		if valid {
+			nested := name + "."
+			if field.Anonymous {
+				nested = prefix
+			}
+			errs = append(errs, validateStruct(fv, nested)...)
+		}
+	}
+	return errs
+}
+
+// checkRule evaluates a single validation rule, returning a reason when it fails
+func checkRule(fv reflect.Value, rule string) string {
+	name, arg, _ := strings.Cut(rule, "=")
+	switch name {
+	case "required":
+		if fv.IsZero() {
+			return "is required"
+		}
+	case "gt", "gte":
+		limit, err := strconv.ParseFloat(arg, 64)
+		if err != nil {
+			return fmt.Sprintf("has invalid rule %q", rule)
+		}
+		n, ok := numericValue(fv)
+		if !ok {
+			return "must be a number"
+		}
+		if name == "gt" && n <= limit {
+			return fmt.Sprintf("must be greater than %s", arg)
+		}
+		if name == "gte" && n < limit {
+			return fmt.Sprintf("must be greater than or equal to %s", arg)
+		}
+	case "oneof":
+		options := strings.Fields(arg)
+		s := fmt.Sprint(fv.Interface())
+		for _, o := range options {
+			if o == s {
+				return ""
+			}
+		}
+		return fmt.Sprintf("must be one of: %s", strings.Join(options, ", "))
+	case "labels":
+		return checkLabels(fv)
+	case "annotations":
+		return checkAnnotations(fv)
+	default:
+		return fmt.Sprintf("has invalid rule %q", rule)
+	}
+	return ""
+}
+
+// numericValue converts integer and float fields to float64
+func numericValue(fv reflect.Value) (float64, bool) {
+	switch fv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(fv.Int()), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(fv.Uint()), true
+	case reflect.Float32, reflect.Float64:
+		return fv.Float(), true
+	}
+	return 0, false
+}
+
+// jsonFieldName returns the name a struct field is serialized under
+func jsonFieldName(field reflect.StructField) string {
+	name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+	if name == "" || name == "-" {
+		return field.Name
+	}
+	return name
+}
+
+// decodeJSON strictly decodes the request body into v and validates it.
+// On failure it writes a problem response and returns false.
+func decodeJSON(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	defer r.Body.Close()
+
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		writeProblem(w, r, Problem{
+			Title:  "Malformed request body",
+			Status: http.StatusBadRequest,
+			Code:   CodeMalformedRequest,
+			Detail: describeDecodeError(err),
+		})
+		return false
+	}
+
+	if errs := Validate(v); len(errs) > 0 {
+		writeValidationProblem(w, r, errs)
+		return false
+	}
+	return true
+}
+
+// describeDecodeError turns a JSON decoding error into a client-friendly message
+func describeDecodeError(err error) string {
+	var typeErr *json.UnmarshalTypeError
+	var syntaxErr *json.SyntaxError
+	switch {
+	case errors.Is(err, io.EOF):
+		return "request body must not be empty"
+	case errors.As(err, &typeErr):
+		return fmt.Sprintf("field %q must be of type %s", typeErr.Field, typeErr.Type)
+	case errors.As(err, &syntaxErr):
+		return fmt.Sprintf("invalid JSON at offset %d", syntaxErr.Offset)
+	case strings.HasPrefix(err.Error(), "json: unknown field"):
+		return strings.TrimPrefix(err.Error(), "json: ")
+	}
+	return "invalid JSON"
+}
@@ -0,0 +1,72 @@
+package api
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	type bounds struct {
+		Count int     `json:"count" validate:"gt=0"`
+		Ratio float64 `json:"ratio" validate:"gte=0.5"`
+	}
+	type optional struct {
+		Notes string `json:"notes" validate:"omitempty,oneof=a b"`
+		Limit int    `json:"limit,omitempty" validate:"omitempty,gte=10"`
+	}
+	type choice struct {
+		Mode string `json:"mode" validate:"required,oneof=fast slow"`
+	}
+	type typo struct {
+		Name string `json:"name" validate:"requird"`
+	}
+	type badLimit struct {
+		Count int `json:"count" validate:"gt=ten"`
+	}
+	type notNumber struct {
+		Name string `json:"name" validate:"gt=0"`
+	}
+	type nested struct {
+		Principal
+		Choice  choice    `json:"choice"`
+		Bounds  *bounds   `json:"bounds"`
+		Skipped choice    `json:"skipped" validate:"-"`
+		Missing *optional `json:"missing"`
+	}
+
+	tests := []struct {
+		name  string
+		value interface{}
+		want  []FieldError
+	}{
+		{"gt and gte pass", bounds{Count: 1, Ratio: 0.5}, nil},
+		{"gt is exclusive", bounds{Count: 0, Ratio: 1}, []FieldError{{"count", "must be greater than 0"}}},
+		{"gte is inclusive", bounds{Count: 1, Ratio: 0.4}, []FieldError{{"ratio", "must be greater than or equal to 0.5"}}},
+		{"omitempty skips zero values", optional{}, nil},
+		{"omitempty checks set values", optional{Notes: "c", Limit: 5}, []FieldError{
+			{"notes", "must be one of: a, b"},
+			{"limit", "must be greater than or equal to 10"},
+		}},
+		{"oneof matches", choice{Mode: "slow"}, nil},
+		{"oneof rejects others", choice{Mode: "medium"}, []FieldError{{"mode", "must be one of: fast, slow"}}},
+		{"required stops at the first failure", choice{}, []FieldError{{"mode", "is required"}}},
+		{"pointers are followed", &choice{Mode: "fast"}, nil},
+		{"unknown rules fail", typo{Name: "x"}, []FieldError{{"name", `has invalid rule "requird"`}}},
+		{"malformed limits fail", badLimit{Count: 1}, []FieldError{{"count", `has invalid rule "gt=ten"`}}},
+		{"bounds need numbers", notNumber{Name: "x"}, []FieldError{{"name", "must be a number"}}},
+		{"nested structs are validated", nested{Bounds: &bounds{Count: 1}}, []FieldError{
+			{"name", "is required"},
+			{"role", "is required"},
+			{"choice.mode", "is required"},
+			{"bounds.ratio", "must be greater than or equal to 0.5"},
+		}},
+		{"not a struct", "text", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Validate(tt.value); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Validate() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,173 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sort"
+	"strings"
+
+	"raft3d/raft"
+)
+
+// vendorKeyPrefix prefixes vendors in the store
+const vendorKeyPrefix = "vendor_"
+
+// handleVendors handles GET/POST /vendors, GET/PUT/DELETE /vendors/{id} and
+// GET /vendors/{id}/filaments. Changing vendors requires the admin or
+// operator role, and a vendor can only be deleted once no filament names it.
+func (s *Server) handleVendors(w http.ResponseWriter, r *http.Request) {
+	path := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/v1/vendors"), "/")
+	id, action, _ := strings.Cut(path, "/")
+
+	switch {
+	case path == "" && r.Method == http.MethodGet:
+		all, err := loadAll[Vendor](s, vendorKeyPrefix)
+		if err != nil {
+			s.writeStoreError(w, r, err, "Failed to retrieve vendors")
+			return
+		}
+		vendors := []Vendor{}
+		for _, vendor := range all {
+			vendors = append(vendors, vendor)
+		}
+		sort.Slice(vendors, func(i, j int) bool { return vendors[i].ID < vendors[j].ID })
+		writeList(w, r, vendors)
+	case path == "" && r.Method == http.MethodPost:
+		if s.requireRole(w, r, RoleAdmin, RoleOperator) {
+			s.handlePostVendor(w, r)
+		}
+	case action == "" && r.Method == http.MethodGet:
+		value, err := s.store.Get(vendorKeyPrefix + id)
+		if err != nil {
+			s.writeStoreError(w, r, err, "Vendor not found")
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(value))
+	case action == "" && r.Method == http.MethodPut:
+		if s.requireRole(w, r, RoleAdmin, RoleOperator) {
+			s.handlePutVendor(w, r, id)
+		}
+	case action == "" && r.Method == http.MethodDelete:
+		if s.requireRole(w, r, RoleAdmin, RoleOperator) {
+			s.handleDeleteVendor(w, r, id)
+		}
+	case action == "filaments" && r.Method == http.MethodGet:
+		if _, err := s.store.Get(vendorKeyPrefix + id); err != nil {
+			s.writeStoreError(w, r, err, "Vendor not found")
+			return
+		}
+		filaments, err := s.vendorFilaments(id)
+		if err != nil {
+			s.writeStoreError(w, r, err, "Failed to retrieve filaments")
+			return
+		}
+		writeList(w, r, filaments)
+	default:
+		methodNotAllowed(w, r)
+	}
+}
+
+// handlePostVendor adds a vendor
+func (s *Server) handlePostVendor(w http.ResponseWriter, r *http.Request) {
+	var vendor Vendor
+	if !decodeJSON(w, r, &vendor) {
+		return
+	}
+	body, err := json.Marshal(vendor)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, CodeInternal, "Failed to process vendor data")
+		return
+	}
+	stored, err := s.storeNew(r, vendorKeyPrefix, vendor.ID, string(body))
+	if err != nil {
+		s.writeStoreError(w, r, err, "Failed to store vendor")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	w.Write([]byte(stored))
+}
+
+// handlePutVendor replaces an existing vendor's details
+func (s *Server) handlePutVendor(w http.ResponseWriter, r *http.Request, id string) {
+	var vendor Vendor
+	if !decodeJSON(w, r, &vendor) {
+		return
+	}
+	if _, err := s.store.Get(vendorKeyPrefix + id); err != nil {
+		s.writeStoreError(w, r, err, "Vendor not found")
+		return
+	}
+	vendor.ID = id
+	body, err := json.Marshal(vendor)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, CodeInternal, "Failed to process vendor data")
+		return
+	}
+	if err := s.storeFor(r).Set(vendorKeyPrefix+id, string(body)); err != nil {
+		s.writeStoreError(w, r, err, "Failed to store vendor")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(body)
+}
+
+// handleDeleteVendor removes a vendor no filament names
+func (s *Server) handleDeleteVendor(w http.ResponseWriter, r *http.Request, id string) {
+	if _, err := s.store.Get(vendorKeyPrefix + id); err != nil {
+		s.writeStoreError(w, r, err, "Vendor not found")
+		return
+	}
+	filaments, err := s.vendorFilaments(id)
+	if err != nil {
+		s.writeStoreError(w, r, err, "Failed to retrieve filaments")
+		return
+	}
+	if len(filaments) > 0 {
+		ids := make([]string, len(filaments))
+		for i, filament := range filaments {
+			ids[i] = filament.ID
+		}
+		writeError(w, r, http.StatusConflict, CodeConflict, "Vendor still supplies filaments: "+strings.Join(ids, ", "))
+		return
+	}
+
+	if err := s.storeFor(r).Delete(vendorKeyPrefix + id); err != nil {
+		s.writeStoreError(w, r, err, "Failed to delete vendor")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// vendorFilaments returns the filaments bought from a vendor, by ID
+func (s *Server) vendorFilaments(vendorID string) ([]Filament, error) {
+	all, err := loadAll[Filament](s, "filament_")
+	if err != nil {
+		return nil, err
+	}
+	filaments := []Filament{}
+	for _, filament := range all {
+		if filament.VendorID == vendorID {
+			filaments = append(filaments, filament)
+		}
+	}
+	sort.Slice(filaments, func(i, j int) bool { return filaments[i].ID < filaments[j].ID })
+	return filaments, nil
+}
+
+// checkVendor reports a field error when a filament names a vendor that
+// doesn't exist
+func (s *Server) checkVendor(vendorID string) ([]FieldError, error) {
+	if vendorID == "" {
+		return nil, nil
+	}
+	_, err := s.store.Get(vendorKeyPrefix + vendorID)
+	if errors.Is(err, raft.ErrNotFound) {
+		return []FieldError{{Name: "vendor_id", Reason: "vendor does not exist"}}, nil
+	}
+	return nil, err
+}
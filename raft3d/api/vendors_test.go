@@ -0,0 +1,69 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"raft3d/testsupport"
+)
+
+func TestVendors(t *testing.T) {
+	c := testsupport.NewCluster(t, 1)
+	leader := c.WaitForLeader(10 * time.Second)
+	s := NewServer("", leader.Store)
+	s.EnableAuth([]APIKey{{Key: "x", Principal: Principal{Name: "root", Role: RoleAdmin}}})
+
+	do := func(role, method, url, body string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, url, strings.NewReader(body))
+		r = r.WithContext(context.WithValue(r.Context(), principalKey{}, Principal{Name: role + "-user", Role: role}))
+		rec := httptest.NewRecorder()
+		if strings.HasPrefix(url, "/api/v1/vendors") {
+			s.handleVendors(rec, r)
+		} else {
+			s.handleFilaments(rec, r)
+		}
+		return rec
+	}
+
+	if rec := do(RoleMember, http.MethodPost, "/api/v1/vendors", `{"id":"acme","name":"Acme"}`); rec.Code != http.StatusForbidden {
+		t.Fatalf("member added a vendor: %d", rec.Code)
+	}
+	if rec := do(RoleOperator, http.MethodPost, "/api/v1/vendors", `{"id":"acme","name":"Acme Filament","lead_time_days":5}`); rec.Code != http.StatusCreated {
+		t.Fatalf("add vendor: %d %s", rec.Code, rec.Body)
+	}
+	if rec := do(RoleOperator, http.MethodPut, "/api/v1/vendors/acme", `{"name":"Acme Filament Co","lead_time_days":7}`); rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"id":"acme"`) {
+		t.Fatalf("update vendor: %d %s", rec.Code, rec.Body)
+	}
+
+	rec := do(RoleMember, http.MethodPost, "/api/v1/filaments", `{"id":"f1","name":"PLA","type":"PLA","total_weight_in_grams":1000,"vendor_id":"acme","sku":"PLA-BLK-1KG","lot_number":"L42","purchase_date":"2026-09-01T00:00:00Z","purchase_price":24.5}`)
+	var filament Filament
+	if rec.Code != http.StatusCreated || json.NewDecoder(rec.Body).Decode(&filament) != nil || filament.CostPerKg != 24.5 || filament.LotNumber != "L42" {
+		t.Fatalf("add filament: %d %+v", rec.Code, filament)
+	}
+	if rec := do(RoleMember, http.MethodPost, "/api/v1/filaments", `{"id":"f2","name":"PLA","type":"PLA","total_weight_in_grams":1000,"vendor_id":"nobody"}`); rec.Code != http.StatusBadRequest {
+		t.Fatalf("unknown vendor: %d %s", rec.Code, rec.Body)
+	}
+
+	rec = do(RoleMember, http.MethodGet, "/api/v1/vendors/acme/filaments", "")
+	var supplied []Filament
+	if rec.Code != http.StatusOK || json.NewDecoder(rec.Body).Decode(&supplied) != nil || len(supplied) != 1 || supplied[0].ID != "f1" {
+		t.Fatalf("vendor filaments: %d %+v", rec.Code, supplied)
+	}
+	if rec := do(RoleAdmin, http.MethodDelete, "/api/v1/vendors/acme", ""); rec.Code != http.StatusConflict {
+		t.Fatalf("deleted a vendor in use: %d", rec.Code)
+	}
+	if err := leader.Store.Delete("filament_f1"); err != nil {
+		t.Fatal(err)
+	}
+	if rec := do(RoleAdmin, http.MethodDelete, "/api/v1/vendors/acme", ""); rec.Code != http.StatusNoContent {
+		t.Fatalf("delete vendor: %d %s", rec.Code, rec.Body)
+	}
+	if rec := do(RoleMember, http.MethodGet, "/api/v1/vendors/acme", ""); rec.Code != http.StatusNotFound {
+		t.Fatalf("deleted vendor: %d", rec.Code)
+	}
+}
@@ -0,0 +1,210 @@
+package api
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// API path prefixes. v2 is served by the v1 handlers; only the shape of
+// list responses differs.
+const (
+	apiV1Prefix = "/api/v1/"
+	apiV2Prefix = "/api/v2/"
+)
+
+// v1 is deprecated as of the release that introduced v2, a fixed date. It is
+// removed at defaultV1Sunset unless -v1-sunset announces another date.
+var (
+	v1DeprecatedAt  = time.Date(2026, time.October, 16, 0, 0, 0, 0, time.UTC)
+	defaultV1Sunset = time.Date(2027, time.October, 16, 0, 0, 0, 0, time.UTC)
+)
+
+// SetV1Sunset sets the date /api/v1/ responses announce v1 will be removed
+func (s *Server) SetV1Sunset(sunset time.Time) {
+	s.v1Sunset = sunset.UTC()
+}
+
+// Pagination limits for v2 lists
+const (
+	defaultPageLimit = 100
+	maxPageLimit     = 1000
+)
+
+// Page is a v2 list response. NextCursor is passed back as ?cursor= to read
+// the following page and is empty on the last one.
+type Page[T any] struct {
+	Items      []T    `json:"items"`
+	Total      int    `json:"total"`
+	NextCursor string `json:"next_cursor,omitempty"`
+}
+
+// apiVersionKey is the context key holding the request's apiVersion
+type apiVersionKey struct{}
+
+// apiVersion records the API version a request was made against and the
+// path it used before being routed to the v1 handlers
+type apiVersion struct {
+	version int
+	path    string
+}
+
+// versioned routes /api/v2/ requests to the v1 handlers and marks /api/v1/
+// responses as deprecated
+func (s *Server) versioned(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case strings.HasPrefix(r.URL.Path, apiV2Prefix):
+			v := apiVersion{version: 2, path: r.URL.Path}
+			r = r.WithContext(context.WithValue(r.Context(), apiVersionKey{}, v))
+			r.URL.Path = apiV1Prefix + strings.TrimPrefix(r.URL.Path, apiV2Prefix)
+			r.URL.RawPath = ""
+		case strings.HasPrefix(r.URL.Path, apiV1Prefix):
+			w.Header().Set("Deprecation", "@"+strconv.FormatInt(v1DeprecatedAt.Unix(), 10))
+			sunset := s.v1Sunset
+			if sunset.IsZero() {
+				sunset = defaultV1Sunset
+			}
+			w.Header().Set("Sunset", sunset.Format(http.TimeFormat))
+			w.Header().Set("Link", fmt.Sprintf(`<%s%s>; rel="successor-version"`, apiV2Prefix, strings.TrimPrefix(r.URL.Path, apiV1Prefix)))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// isV2 reports whether a request was made against /api/v2/
+func isV2(r *http.Request) bool {
+	v, ok := r.Context().Value(apiVersionKey{}).(apiVersion)
+	return ok && v.version == 2
+}
+
+// requestPath returns the path the client requested, before versioned
+// rewrote it
+func requestPath(r *http.Request) string {
+	if v, ok := r.Context().Value(apiVersionKey{}).(apiVersion); ok {
+		return v.path
+	}
+	return r.URL.Path
+}
+
+// writeList writes a list response: the slice itself on v1, or a page of it
+// on v2. ?fields= trims the items on either version.
+func writeList[T any](w http.ResponseWriter, r *http.Request, items []T) {
+	fields, errs := parseFields[T](r)
+	if len(errs) > 0 {
+		writeValidationProblem(w, r, errs)
+		return
+	}
+	if !isV2(r) {
+		w.Header().Set("Content-Type", "application/json")
+		if fields != nil {
+			json.NewEncoder(w).Encode(sparseList(items, fields))
+			return
+		}
+		json.NewEncoder(w).Encode(items)
+		return
+	}
+
+	limit, offset, errs := parsePage(r)
+	if len(errs) > 0 {
+		writeValidationProblem(w, r, errs)
+		return
+	}
+	page := Page[T]{Items: []T{}, Total: len(items)}
+	if offset < len(items) {
+		end := min(offset+limit, len(items))
+		page.Items = items[offset:end]
+		if end < len(items) {
+			page.NextCursor = encodeCursor(end)
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if fields != nil {
+		json.NewEncoder(w).Encode(Page[map[string]json.RawMessage]{
+			Items:      sparseList(page.Items, fields),
+			Total:      page.Total,
+			NextCursor: page.NextCursor,
+		})
+		return
+	}
+	json.NewEncoder(w).Encode(page)
+}
+
+// writeMap writes a list keyed by ID: the map itself on v1, or a page of
+// its values ordered by ID on v2
+func writeMap[T any](w http.ResponseWriter, r *http.Request, byID map[string]T) {
+	if isV2(r) {
+		ids := make([]string, 0, len(byID))
+		for id := range byID {
+			ids = append(ids, id)
+		}
+		sort.Strings(ids)
+		items := make([]T, len(ids))
+		for i, id := range ids {
+			items[i] = byID[id]
+		}
+		writeList(w, r, items)
+		return
+	}
+
+	fields, errs := parseFields[T](r)
+	if len(errs) > 0 {
+		writeValidationProblem(w, r, errs)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if fields != nil {
+		kept := make(map[string]map[string]json.RawMessage, len(byID))
+		for id, item := range byID {
+			kept[id] = sparse(item, fields)
+		}
+		json.NewEncoder(w).Encode(kept)
+		return
+	}
+	json.NewEncoder(w).Encode(byID)
+}
+
+// parsePage reads ?limit= and ?cursor=
+func parsePage(r *http.Request) (limit, offset int, errs []FieldError) {
+	limit = defaultPageLimit
+	if value := r.URL.Query().Get("limit"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 || n > maxPageLimit {
+			errs = append(errs, FieldError{Name: "limit", Reason: fmt.Sprintf("must be between 1 and %d", maxPageLimit)})
+		}
+		limit = n
+	}
+	if value := r.URL.Query().Get("cursor"); value != "" {
+		n, ok := decodeCursor(value)
+		if !ok {
+			errs = append(errs, FieldError{Name: "cursor", Reason: "is not a cursor returned by this list"})
+		}
+		offset = n
+	}
+	return limit, offset, errs
+}
+
+// encodeCursor makes an opaque cursor for the item at offset
+func encodeCursor(offset int) string {
+	return base64.RawURLEncoding.EncodeToString([]byte("o:" + strconv.Itoa(offset)))
+}
+
+// decodeCursor returns the offset an encodeCursor cursor points at
+func decodeCursor(cursor string) (int, bool) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return 0, false
+	}
+	value, ok := strings.CutPrefix(string(raw), "o:")
+	if !ok {
+		return 0, false
+	}
+	offset, err := strconv.Atoi(value)
+	return offset, err == nil && offset >= 0
+}
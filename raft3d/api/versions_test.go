@@ -0,0 +1,83 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"raft3d/testsupport"
+)
+
+func TestV2PagesListsAndV1IsDeprecated(t *testing.T) {
+	c := testsupport.NewCluster(t, 1)
+	leader := c.WaitForLeader(10 * time.Second)
+	s := NewServer("", leader.Store)
+	for _, id := range []string{"p1", "p2", "p3"} {
+		body, _ := json.Marshal(Printer{ID: id, Name: "Prusa", Status: "Idle"})
+		if err := leader.Store.Set("printer_"+id, string(body)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	handler := s.versioned(http.HandlerFunc(s.handlePrinters))
+	get := func(url string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, url, nil))
+		return w
+	}
+
+	// v1 keeps its shape and points at its successor
+	w := get("/api/v1/printers")
+	var byID map[string]Printer
+	if err := json.Unmarshal(w.Body.Bytes(), &byID); err != nil || len(byID) != 3 {
+		t.Fatalf("v1 list: %d %s", w.Code, w.Body)
+	}
+	if w.Header().Get("Deprecation") == "" || w.Header().Get("Sunset") == "" || w.Header().Get("Link") != `</api/v2/printers>; rel="successor-version"` {
+		t.Fatalf("v1 headers: %v", w.Header())
+	}
+	if got := w.Header().Get("Sunset"); got != "Sat, 16 Oct 2027 00:00:00 GMT" {
+		t.Fatalf("default Sunset %q", got)
+	}
+	s.SetV1Sunset(time.Date(2028, time.January, 31, 0, 0, 0, 0, time.UTC))
+	if got := get("/api/v1/printers").Header().Get("Sunset"); got != "Mon, 31 Jan 2028 00:00:00 GMT" {
+		t.Fatalf("configured Sunset %q", got)
+	}
+
+	// v2 pages through the printers in ID order
+	var ids []string
+	url := "/api/v2/printers?limit=2"
+	for pages := 0; url != ""; pages++ {
+		if pages == 3 {
+			t.Fatal("pagination did not end")
+		}
+		w := get(url)
+		if w.Header().Get("Deprecation") != "" {
+			t.Fatalf("v2 response is deprecated: %v", w.Header())
+		}
+		var page Page[Printer]
+		if err := json.Unmarshal(w.Body.Bytes(), &page); err != nil || page.Total != 3 {
+			t.Fatalf("v2 page: %d %s", w.Code, w.Body)
+		}
+		for _, printer := range page.Items {
+			ids = append(ids, printer.ID)
+		}
+		url = ""
+		if page.NextCursor != "" {
+			url = "/api/v2/printers?limit=2&cursor=" + page.NextCursor
+		}
+	}
+	if strings.Join(ids, ",") != "p1,p2,p3" {
+		t.Fatalf("v2 pages held %v", ids)
+	}
+	if w := get("/api/v2/printers/p2"); w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"p2"`) {
+		t.Fatalf("v2 printer: %d %s", w.Code, w.Body)
+	}
+
+	for _, url := range []string{"/api/v2/printers?limit=0", "/api/v2/printers?limit=1001", "/api/v2/printers?cursor=bogus"} {
+		if w := get(url); w.Code != http.StatusBadRequest {
+			t.Fatalf("%s: %d %s", url, w.Code, w.Body)
+		}
+	}
+}
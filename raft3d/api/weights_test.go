@@ -0,0 +1,66 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"raft3d/testsupport"
+)
+
+func TestFractionalWeightsDoNotDrift(t *testing.T) {
+	c := testsupport.NewCluster(t, 1)
+	leader := c.WaitForLeader(10 * time.Second)
+	s := NewServer("", leader.Store)
+
+	// Weights stored before they were fractional still decode
+	if err := leader.Store.Set("printer_p1", `{"id":"p1","name":"Prusa","status":"Idle"}`); err != nil {
+		t.Fatal(err)
+	}
+	if err := leader.Store.Set("filament_f1", `{"id":"f1","name":"PLA","type":"PLA","total_weight_in_grams":1,"remaining_weight_in_grams":1}`); err != nil {
+		t.Fatal(err)
+	}
+
+	submit := func(id string, grams float64) *httptest.ResponseRecorder {
+		body := fmt.Sprintf(`{"id":%q,"printer_id":"p1","filament_id":"f1","filepath":"part.gcode","print_weight_in_grams":%g}`, id, grams)
+		w := httptest.NewRecorder()
+		s.handlePostPrintJob(w, httptest.NewRequest(http.MethodPost, "/api/v1/print_jobs", strings.NewReader(body)))
+		return w
+	}
+
+	// 0.1 + 0.1 + 0.1 + 0.7 is slightly over 1 in floating point; kept to
+	// the milligram it uses the spool exactly
+	for i, grams := range []float64{0.1, 0.1, 0.1, 0.7} {
+		if w := submit(fmt.Sprintf("j%d", i), grams); w.Code != http.StatusCreated {
+			t.Fatalf("job of %gg: %d %s", grams, w.Code, w.Body)
+		}
+	}
+	if w := submit("over", 0.001); w.Code != http.StatusConflict || !strings.Contains(w.Body.String(), CodeInsufficientFilament) {
+		t.Fatalf("job beyond the spool: %d %s", w.Code, w.Body)
+	}
+	if w := submit("tiny", 0.0004); w.Code != http.StatusBadRequest {
+		t.Fatalf("job under a milligram: %d %s", w.Code, w.Body)
+	}
+
+	// Completing every job empties the spool to exactly zero
+	for i := 0; i < 4; i++ {
+		id := fmt.Sprintf("j%d", i)
+		for _, to := range []string{"Running", "Done"} {
+			w := httptest.NewRecorder()
+			s.handleUpdatePrintJobStatus(w, httptest.NewRequest(http.MethodPost, "/api/v1/print_jobs/"+id+"/status?status="+to, nil), id)
+			if w.Code != http.StatusOK {
+				t.Fatalf("%s to %s: %d %s", id, to, w.Code, w.Body)
+			}
+		}
+	}
+	filament, err := s.getFilament("f1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if filament.RemainingWeightInGrams != 0 {
+		t.Fatalf("remaining after using the spool: %v", filament.RemainingWeightInGrams)
+	}
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+
+	"raft3d/raft"
+)
+
+// runBackup implements the "backup" subcommand. Its only action, verify,
+// checks a backup archive against its manifest before it is relied on for a
+// restore. It returns the process exit code.
+func runBackup(args []string) int {
+	if len(args) == 0 || args[0] != "verify" {
+		fmt.Fprintln(os.Stderr, "usage: raft3d backup verify --backup <archive> [--manifest <file>] [--json]")
+		return 2
+	}
+
+	fs := flag.NewFlagSet("backup verify", flag.ExitOnError)
+	backupPath := fs.String("backup", "", "Path or s3://, gs:// URL of a backup archive (.json.gz)")
+	manifestPath := fs.String("manifest", "", "Path to the backup's manifest (default: the archive path plus .manifest.json)")
+	asJSON := fs.Bool("json", false, "Print the result as JSON")
+	fs.Parse(args[1:])
+
+	if *backupPath == "" {
+		fmt.Fprintln(os.Stderr, "backup verify: --backup is required")
+		return 2
+	}
+	if *manifestPath == "" {
+		*manifestPath = raft.ManifestName(*backupPath)
+	}
+
+	result, err := raft.VerifyBackup(*backupPath, *manifestPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "backup verify: %s\n", err)
+		return 2
+	}
+
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(result)
+	} else {
+		printBackupVerification(result)
+	}
+
+	if !result.OK() {
+		return 1
+	}
+	return 0
+}
+
+// printBackupVerification writes a human-readable verification result to stdout
+func printBackupVerification(result *raft.BackupVerification) {
+	fmt.Printf("Backup:   %s\n", result.Backup)
+	fmt.Printf("Size:     %d bytes\n", result.Size)
+	fmt.Printf("SHA-256:  %s\n", result.SHA256)
+	fmt.Printf("Index:    %d\n", result.Index)
+	fmt.Printf("Keys:     %d\n", result.Keys)
+
+	prefixes := make([]string, 0, len(result.Entities))
+	for prefix := range result.Entities {
+		prefixes = append(prefixes, prefix)
+	}
+	sort.Strings(prefixes)
+	for _, prefix := range prefixes {
+		fmt.Printf("  %-20s %d\n", prefix, result.Entities[prefix])
+	}
+
+	if result.OK() {
+		fmt.Println("\nBackup matches its manifest")
+		return
+	}
+	fmt.Printf("\n%d problem(s) found:\n", len(result.Problems))
+	for _, problem := range result.Problems {
+		fmt.Printf("  %s\n", problem)
+	}
+}
@@ -0,0 +1,337 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"raft3d/api"
+)
+
+// Operations the benchmark performs
+const (
+	benchCreatePrinter  = "create_printer"
+	benchCreateFilament = "create_filament"
+	benchCreateJob      = "create_job"
+	benchUpdateStatus   = "update_status"
+)
+
+// LatencySummary describes the latencies of one kind of request
+type LatencySummary struct {
+	Count int     `json:"count"`
+	P50Ms float64 `json:"p50_ms"`
+	P95Ms float64 `json:"p95_ms"`
+	P99Ms float64 `json:"p99_ms"`
+	MaxMs float64 `json:"max_ms"`
+}
+
+// BenchReport is the outcome of a benchmark run
+type BenchReport struct {
+	Target          string                    `json:"target"`
+	RunID           string                    `json:"run_id"`
+	Concurrency     int                       `json:"concurrency"`
+	Writes          int                       `json:"writes"`
+	Succeeded       int                       `json:"succeeded"`
+	Failed          int                       `json:"failed"`
+	DurationSeconds float64                   `json:"duration_seconds"`
+	WritesPerSecond float64                   `json:"writes_per_second"` // successful ones
+	Latency         LatencySummary            `json:"latency"`
+	Operations      map[string]LatencySummary `json:"operations"`
+	Errors          map[string]int            `json:"errors,omitempty"` // by status and error code
+}
+
+// runBench implements the "bench" subcommand, which drives a realistic mix
+// of writes at the cluster's leader and reports throughput, latency and
+// errors. It returns 0 when every write succeeded, 1 when some failed and 2
+// when the benchmark couldn't run.
+func runBench(args []string) int {
+	fs := flag.NewFlagSet("bench", flag.ExitOnError)
+	target := fs.String("target", "127.0.0.1:8000", "HTTP address of any node; writes go to the leader it reports")
+	writes := fs.Int("writes", 1000, "Total writes to perform")
+	concurrency := fs.Int("concurrency", 16, "Writes in flight at once, each worker with a printer and filament of its own")
+	apiKey := fs.String("api-key", "", "Operator or admin API key, when the cluster requires authentication")
+	useTLS := fs.Bool("https", false, "Reach the nodes over HTTPS")
+	asJSON := fs.Bool("json", false, "Print the report as JSON")
+	fs.Parse(args)
+
+	if *writes <= 0 || *concurrency <= 0 {
+		fmt.Fprintln(os.Stderr, "bench: --writes and --concurrency must be positive")
+		return 2
+	}
+	if *concurrency > *writes {
+		*concurrency = *writes
+	}
+
+	b := &bench{
+		client: &http.Client{
+			Timeout:   30 * time.Second,
+			Transport: &http.Transport{MaxIdleConnsPerHost: *concurrency},
+		},
+		scheme:    "http",
+		apiKey:    *apiKey,
+		runID:     "bench-" + strconv.FormatInt(time.Now().Unix(), 36),
+		remaining: int64(*writes),
+		latencies: make(map[string][]time.Duration),
+		errors:    make(map[string]int),
+	}
+	if *useTLS {
+		b.scheme = "https"
+	}
+	leader, err := b.leader(*target)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "bench: %s\n", err)
+		return 2
+	}
+	b.addr = leader
+
+	start := time.Now()
+	var wg sync.WaitGroup
+	for i := 0; i < *concurrency; i++ {
+		wg.Add(1)
+		go func(worker int) {
+			defer wg.Done()
+			b.work(worker)
+		}(i)
+	}
+	wg.Wait()
+
+	report := b.report(time.Since(start), *concurrency)
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(report)
+	} else {
+		printBenchReport(report)
+	}
+	if report.Failed > 0 {
+		return 1
+	}
+	return 0
+}
+
+// bench holds the state shared by a run's workers
+type bench struct {
+	client *http.Client
+	scheme string
+	addr   string
+	apiKey string
+	runID  string
+
+	remaining int64 // writes not yet started
+
+	mu        sync.Mutex
+	latencies map[string][]time.Duration // of successful writes, by operation
+	errors    map[string]int
+}
+
+// leader asks a node where the leader is, falling back to the node itself
+func (b *bench) leader(addr string) (string, error) {
+	req, err := http.NewRequest(http.MethodGet, fmt.Sprintf("%s://%s/cluster", b.scheme, addr), nil)
+	if err != nil {
+		return "", err
+	}
+	resp, err := b.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	var status api.ClusterStatus
+	if resp.StatusCode != http.StatusOK || json.NewDecoder(resp.Body).Decode(&status) != nil {
+		return addr, nil
+	}
+	if status.IsLeader || status.Leader.HTTPAddr == "" {
+		return addr, nil
+	}
+	return status.Leader.HTTPAddr, nil
+}
+
+// take claims the next write, reporting false once all have been claimed
+func (b *bench) take() bool {
+	return atomic.AddInt64(&b.remaining, -1) >= 0
+}
+
+// work runs one worker: it creates a printer and a filament, then queues
+// jobs on them and moves them through Running to Done
+func (b *bench) work(worker int) {
+	prefix := fmt.Sprintf("%s-w%d", b.runID, worker)
+	printerID, filamentID := prefix+"-printer", prefix+"-filament"
+	var queued []string
+	running := ""
+
+	for n := 0; b.take(); n++ {
+		switch {
+		case n == 0:
+			b.post(benchCreatePrinter, "/api/v1/printers", api.Printer{
+				ID: printerID, Name: "Bench " + prefix, Model: "bench", Status: "Idle",
+				Labels: map[string]string{"bench": b.runID},
+			})
+		case n == 1:
+			b.post(benchCreateFilament, "/api/v1/filaments", api.Filament{
+				ID: filamentID, Name: "Bench " + prefix, Type: "PLA",
+				TotalWeightInGrams: 1e9, RemainingWeightInGrams: 1e9,
+				Labels: map[string]string{"bench": b.runID},
+			})
+		case running != "":
+			// Finish the running job before starting another on the printer
+			b.post(benchUpdateStatus, "/api/v1/print_jobs/"+running+"/status?status=Done", nil)
+			running = ""
+		case len(queued) > 0 && n%3 == 0:
+			job := queued[0]
+			queued = queued[1:]
+			if b.post(benchUpdateStatus, "/api/v1/print_jobs/"+job+"/status?status=Running", nil) {
+				running = job
+			}
+		default:
+			job := fmt.Sprintf("%s-job%d", prefix, n)
+			if b.post(benchCreateJob, "/api/v1/print_jobs", api.PrintJob{
+				ID: job, PrinterID: printerID, FilamentID: filamentID,
+				FilePath: job + ".gcode", PrintWeightInGrams: 5,
+			}) {
+				queued = append(queued, job)
+			}
+		}
+	}
+}
+
+// post performs one write and records its latency or error. It reports
+// whether the write succeeded.
+func (b *bench) post(op, path string, body interface{}) bool {
+	var payload []byte
+	if body != nil {
+		payload, _ = json.Marshal(body)
+	}
+	req, err := http.NewRequest(http.MethodPost, fmt.Sprintf("%s://%s%s", b.scheme, b.addr, path), bytes.NewReader(payload))
+	if err != nil {
+		b.failed("request: " + err.Error())
+		return false
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if b.apiKey != "" {
+		req.Header.Set("X-API-Key", b.apiKey)
+	}
+
+	start := time.Now()
+	resp, err := b.client.Do(req)
+	elapsed := time.Since(start)
+	if err != nil {
+		b.failed("network error")
+		return false
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		var problem api.Problem
+		json.NewDecoder(resp.Body).Decode(&problem)
+		kind := strconv.Itoa(resp.StatusCode)
+		if problem.Code != "" {
+			kind += " " + problem.Code
+		}
+		b.failed(kind)
+		return false
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.latencies[op] = append(b.latencies[op], elapsed)
+	return true
+}
+
+// failed counts an error of a kind
+func (b *bench) failed(kind string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.errors[kind]++
+}
+
+// report summarizes the run
+func (b *bench) report(elapsed time.Duration, concurrency int) BenchReport {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	report := BenchReport{
+		Target:          b.addr,
+		RunID:           b.runID,
+		Concurrency:     concurrency,
+		DurationSeconds: elapsed.Seconds(),
+		Operations:      make(map[string]LatencySummary),
+		Errors:          b.errors,
+	}
+	var all []time.Duration
+	for op, latencies := range b.latencies {
+		report.Operations[op] = summarizeLatencies(latencies)
+		all = append(all, latencies...)
+	}
+	report.Latency = summarizeLatencies(all)
+	report.Succeeded = len(all)
+	for _, count := range b.errors {
+		report.Failed += count
+	}
+	report.Writes = report.Succeeded + report.Failed
+	if elapsed > 0 {
+		report.WritesPerSecond = float64(report.Succeeded) / elapsed.Seconds()
+	}
+	return report
+}
+
+// summarizeLatencies returns the count, percentiles and maximum of
+// latencies, using the nearest-rank method
+func summarizeLatencies(latencies []time.Duration) LatencySummary {
+	summary := LatencySummary{Count: len(latencies)}
+	if len(latencies) == 0 {
+		return summary
+	}
+	sorted := append([]time.Duration(nil), latencies...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	percentile := func(p int) float64 {
+		rank := (p*len(sorted) + 99) / 100
+		if rank < 1 {
+			rank = 1
+		}
+		return float64(sorted[rank-1].Microseconds()) / 1000
+	}
+	summary.P50Ms, summary.P95Ms, summary.P99Ms = percentile(50), percentile(95), percentile(99)
+	summary.MaxMs = float64(sorted[len(sorted)-1].Microseconds()) / 1000
+	return summary
+}
+
+// printBenchReport writes a human-readable report to stdout
+func printBenchReport(report BenchReport) {
+	fmt.Printf("Target:      %s (run %s)\n", report.Target, report.RunID)
+	fmt.Printf("Writes:      %d with concurrency %d in %.2fs\n", report.Writes, report.Concurrency, report.DurationSeconds)
+	fmt.Printf("Succeeded:   %d (%.1f writes/s)\n", report.Succeeded, report.WritesPerSecond)
+	fmt.Printf("Failed:      %d\n", report.Failed)
+
+	fmt.Printf("\n%-16s %8s %10s %10s %10s %10s\n", "operation", "count", "p50 ms", "p95 ms", "p99 ms", "max ms")
+	ops := make([]string, 0, len(report.Operations))
+	for op := range report.Operations {
+		ops = append(ops, op)
+	}
+	sort.Strings(ops)
+	row := func(name string, s LatencySummary) {
+		fmt.Printf("%-16s %8d %10.1f %10.1f %10.1f %10.1f\n", name, s.Count, s.P50Ms, s.P95Ms, s.P99Ms, s.MaxMs)
+	}
+	for _, op := range ops {
+		row(op, report.Operations[op])
+	}
+	row("all", report.Latency)
+
+	if len(report.Errors) > 0 {
+		fmt.Println("\nErrors:")
+		kinds := make([]string, 0, len(report.Errors))
+		for kind := range report.Errors {
+			kinds = append(kinds, kind)
+		}
+		sort.Strings(kinds)
+		for _, kind := range kinds {
+			fmt.Printf("  %-36s %d\n", strings.TrimSpace(kind), report.Errors[kind])
+		}
+	}
+}
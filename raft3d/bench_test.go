@@ -0,0 +1,27 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSummarizeLatencies(t *testing.T) {
+	var latencies []time.Duration
+	for i := 100; i >= 1; i-- {
+		latencies = append(latencies, time.Duration(i)*time.Millisecond)
+	}
+	summary := summarizeLatencies(latencies)
+	if summary.Count != 100 || summary.P50Ms != 50 || summary.P95Ms != 95 || summary.P99Ms != 99 || summary.MaxMs != 100 {
+		t.Fatalf("summary = %+v", summary)
+	}
+	if latencies[0] != 100*time.Millisecond {
+		t.Fatal("latencies were sorted in place")
+	}
+
+	if summary := summarizeLatencies([]time.Duration{7 * time.Millisecond}); summary.P50Ms != 7 || summary.P99Ms != 7 {
+		t.Fatalf("single latency: %+v", summary)
+	}
+	if summary := summarizeLatencies(nil); summary.Count != 0 {
+		t.Fatalf("no latencies: %+v", summary)
+	}
+}
@@ -0,0 +1,264 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"raft3d/api"
+	"raft3d/events"
+	"raft3d/raft"
+)
+
+// reloadableFlags are the settings a running node picks up on SIGHUP or
+// POST /api/v1/admin/reload. Every other flag needs a restart.
+var reloadableFlags = map[string]bool{
+	"log-level":            true,
+	"webhooks":             true,
+	"api-keys":             true,
+	"quorum-loss-timeout":  true,
+	"management-allowlist": true,
+}
+
+// readConfigFile reads a JSON object of flag names to values. Lists, such as
+// webhooks, may be given as arrays.
+func readConfigFile(path string) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var raw map[string]interface{}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+
+	values := make(map[string]string, len(raw))
+	for name, value := range raw {
+		if flag.Lookup(name) == nil {
+			return nil, fmt.Errorf("%s: unknown setting %q", path, name)
+		}
+		switch v := value.(type) {
+		case []interface{}:
+			var items []string
+			for _, item := range v {
+				items = append(items, fmt.Sprint(item))
+			}
+			values[name] = strings.Join(items, ",")
+		case float64:
+			values[name] = strconv.FormatFloat(v, 'f', -1, 64)
+		default:
+			values[name] = fmt.Sprint(v)
+		}
+	}
+	return values, nil
+}
+
+// splitList splits a comma-separated flag value, dropping empty items
+func splitList(list string) []string {
+	var items []string
+	for _, item := range strings.Split(list, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// applyConfigFile sets every flag from the config file that wasn't given on
+// the command line, which always takes precedence
+func applyConfigFile(path string) error {
+	values, err := readConfigFile(path)
+	if err != nil {
+		return err
+	}
+	explicit := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) { explicit[f.Name] = true })
+	for name, value := range values {
+		if explicit[name] {
+			continue
+		}
+		if err := flag.Set(name, value); err != nil {
+			return fmt.Errorf("%s: %s: %w", path, name, err)
+		}
+	}
+	return nil
+}
+
+// reloader re-applies runtime settings to a running node
+type reloader struct {
+	configPath string
+	cmdline    map[string]bool // flags given on the command line
+
+	store  *raft.RaftStore
+	server *api.Server
+
+	mutex    sync.Mutex
+	current  map[string]string
+	webhooks map[string]*events.Webhook
+}
+
+// newReloader captures the node's starting configuration. It must be called
+// after flags, the config file and the profile have been applied; store and
+// server are set once they exist.
+func newReloader(configPath string, cmdline map[string]bool) *reloader {
+	r := &reloader{
+		configPath: configPath,
+		cmdline:    cmdline,
+		current:    make(map[string]string),
+		webhooks:   make(map[string]*events.Webhook),
+	}
+	flag.VisitAll(func(f *flag.Flag) { r.current[f.Name] = f.Value.String() })
+	return r
+}
+
+// setWebhooks starts delivery to new URLs and stops it for removed ones. The
+// caller must hold the mutex unless the node is still starting.
+func (r *reloader) setWebhooks(list string) {
+	wanted := make(map[string]bool)
+	for _, url := range strings.Split(list, ",") {
+		if url = strings.TrimSpace(url); url != "" {
+			wanted[url] = true
+		}
+	}
+	for url, webhook := range r.webhooks {
+		if !wanted[url] {
+			webhook.Stop()
+			delete(r.webhooks, url)
+		}
+	}
+	for url := range wanted {
+		if _, ok := r.webhooks[url]; !ok {
+			r.webhooks[url] = events.StartWebhook(r.store, url)
+		}
+	}
+}
+
+// apiKeysRefs returns the API keys reference currently in effect
+func (r *reloader) apiKeysRefs() []string {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	return []string{r.current["api-keys"]}
+}
+
+// rotateAPIKeys re-reads the current API keys after their content changed.
+// Invalid keys are logged and the previous ones stay in effect.
+func (r *reloader) rotateAPIKeys() {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	path := r.current["api-keys"]
+	if path == "" {
+		return
+	}
+	keys, err := api.LoadAPIKeys(path)
+	if err != nil {
+		log.Printf("Failed to reload API keys, keeping the current ones: %s", err)
+		return
+	}
+	if err := r.server.EnableAuth(keys); err != nil {
+		log.Printf("Failed to reload API keys, keeping the current ones: %s", err)
+		return
+	}
+	log.Printf("Reloaded API keys: %d keys", len(keys))
+}
+
+// reload re-reads the config file and the API keys file. Every new value is
+// validated before anything is applied, so a bad file changes nothing.
+func (r *reloader) reload() (api.ReloadResult, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	result := api.ReloadResult{
+		Applied:         make(map[string]string),
+		RestartRequired: []string{},
+		ReloadedAt:      time.Now().UTC(),
+	}
+
+	desired := make(map[string]string, len(r.current))
+	for name, value := range r.current {
+		desired[name] = value
+	}
+	if r.configPath != "" {
+		values, err := readConfigFile(r.configPath)
+		if err != nil {
+			return result, err
+		}
+		for name, value := range values {
+			if !r.cmdline[name] {
+				desired[name] = value
+			}
+		}
+	}
+
+	// Validate
+	var keys []api.APIKey
+	if path := desired["api-keys"]; path != "" {
+		var err error
+		if keys, err = api.LoadAPIKeys(path); err != nil {
+			return result, err
+		}
+	} else if r.current["api-keys"] != "" {
+		// Dropping authentication is never done by a reload: an emptied
+		// setting is far more often a mistake than an intent
+		return result, fmt.Errorf("api-keys: a reload can't turn authentication off, restart the node without -api-keys to do so")
+	}
+	allowlist, err := api.ParseAllowlist(desired["management-allowlist"])
+	if err != nil {
+		return result, fmt.Errorf("management-allowlist: %w", err)
+	}
+	threshold, err := time.ParseDuration(desired["quorum-loss-timeout"])
+	if err != nil {
+		return result, fmt.Errorf("quorum-loss-timeout: %w", err)
+	}
+	if threshold <= 0 {
+		return result, fmt.Errorf("quorum-loss-timeout must be positive")
+	}
+
+	// Apply
+	if desired["log-level"] != r.current["log-level"] {
+		if err := r.store.SetLogLevel(desired["log-level"]); err != nil {
+			return result, err
+		}
+		result.Applied["log-level"] = desired["log-level"]
+	}
+	if desired["quorum-loss-timeout"] != r.current["quorum-loss-timeout"] {
+		if err := r.store.SetQuorumLossThreshold(threshold); err != nil {
+			return result, err
+		}
+		result.Applied["quorum-loss-timeout"] = threshold.String()
+	}
+	if desired["webhooks"] != r.current["webhooks"] {
+		r.setWebhooks(desired["webhooks"])
+		result.Applied["webhooks"] = desired["webhooks"]
+	}
+	if desired["management-allowlist"] != r.current["management-allowlist"] {
+		r.server.EnableManagementAllowlist(allowlist)
+		result.Applied["management-allowlist"] = desired["management-allowlist"]
+	}
+	if keys != nil {
+		if err := r.server.EnableAuth(keys); err != nil {
+			return result, fmt.Errorf("api-keys: %w", err)
+		}
+		result.Applied["api-keys"] = fmt.Sprintf("%d keys", len(keys))
+	}
+
+	for name, value := range desired {
+		if !reloadableFlags[name] && value != r.current[name] {
+			result.RestartRequired = append(result.RestartRequired, name)
+			continue
+		}
+		r.current[name] = value
+	}
+	sort.Strings(result.RestartRequired)
+
+	log.Printf("Reloaded configuration: applied %v, restart required for %v", result.Applied, result.RestartRequired)
+	return result, nil
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"raft3d/api"
+)
+
+func init() {
+	for _, name := range []string{"log-level", "webhooks", "api-keys", "quorum-loss-timeout", "management-allowlist"} {
+		flag.String(name, "", "")
+	}
+}
+
+func TestReloadRefusesToDisableAuth(t *testing.T) {
+	dir := t.TempDir()
+	keysPath := filepath.Join(dir, "keys.json")
+	os.WriteFile(keysPath, []byte(`[{"key":"k1","name":"ops","role":"admin"}]`), 0o600)
+	configPath := filepath.Join(dir, "config.json")
+
+	server := api.NewServer("", nil)
+	keys, err := api.LoadAPIKeys(keysPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := server.EnableAuth(keys); err != nil {
+		t.Fatal(err)
+	}
+	r := &reloader{
+		configPath: configPath,
+		cmdline:    map[string]bool{},
+		server:     server,
+		current: map[string]string{
+			"log-level":           "info",
+			"api-keys":            keysPath,
+			"quorum-loss-timeout": "30s",
+		},
+	}
+
+	tests := []struct {
+		name   string
+		config string
+		keys   string
+	}{
+		{"emptied setting", `{"api-keys": ""}`, `[{"key":"k1","name":"ops","role":"admin"}]`},
+		{"keys file holding null", `{}`, `null`},
+		{"keys file holding no keys", `{}`, `[]`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			os.WriteFile(configPath, []byte(tt.config), 0o600)
+			os.WriteFile(keysPath, []byte(tt.keys), 0o600)
+			if _, err := r.reload(); err == nil {
+				t.Fatal("reload succeeded")
+			}
+			if r.current["api-keys"] != keysPath {
+				t.Fatalf("api-keys is now %q", r.current["api-keys"])
+			}
+		})
+	}
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"raft3d/api"
+	"raft3d/events"
+	"raft3d/lifecycle"
+	"raft3d/raft"
+)
+
+// devNode is one member of a dev cluster
+type devNode struct {
+	info   raft.NodeInfo
+	store  *raft.RaftStore
+	server *api.Server
+}
+
+// runDev implements the "dev" subcommand, which runs a whole cluster inside
+// one process for demos and local testing. It returns the process exit code.
+func runDev(args []string) int {
+	fs := flag.NewFlagSet("dev", flag.ExitOnError)
+	nodes := fs.Int("nodes", 3, "Number of nodes to run")
+	host := fs.String("host", "127.0.0.1", "Host the nodes listen on")
+	httpPort := fs.Int("http-port", 8001, "HTTP port of the first node; later nodes count up from it")
+	raftPort := fs.Int("raft-port", 9001, "Raft port of the first node; later nodes count up from it")
+	dataDir := fs.String("data", "", "Directory for node data (default: a temporary directory removed on exit)")
+	fs.Parse(args)
+
+	if *nodes < 1 {
+		fmt.Fprintln(os.Stderr, "dev: --nodes must be at least 1")
+		return 2
+	}
+
+	dir := *dataDir
+	if dir == "" {
+		tmp, err := os.MkdirTemp("", "raft3d-dev-")
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "dev: %s\n", err)
+			return 1
+		}
+		defer os.RemoveAll(tmp)
+		dir = tmp
+	}
+
+	var voters []raft.NodeInfo
+	for i := 0; i < *nodes; i++ {
+		voters = append(voters, raft.NodeInfo{
+			ID:       fmt.Sprintf("node%d", i+1),
+			RaftAddr: net.JoinHostPort(*host, strconv.Itoa(*raftPort+i)),
+			HTTPAddr: net.JoinHostPort(*host, strconv.Itoa(*httpPort+i)),
+		})
+	}
+
+	// Each node's HTTP server stops before its store; every component
+	// registered stops when the command returns
+	var cluster []*devNode
+	m := lifecycle.New()
+	defer func() {
+		if err := m.Stop(context.Background()); err != nil {
+			log.Printf("Error shutting down the dev cluster: %s", err)
+		}
+	}()
+
+	// Every node bootstraps with the same voter set, so they form one
+	// cluster without a join step
+	for _, info := range voters {
+		nodeDataDir := filepath.Join(dir, info.ID)
+		if err := os.MkdirAll(nodeDataDir, 0755); err != nil {
+			fmt.Fprintf(os.Stderr, "dev: %s\n", err)
+			return 1
+		}
+		bus := events.NewBus(1000)
+		store, err := raft.NewRaftStore(raft.StoreConfig{
+			NodeID:    info.ID,
+			RaftAddr:  info.RaftAddr,
+			HTTPAddr:  info.HTTPAddr,
+			DataDir:   nodeDataDir,
+			Bootstrap: true,
+			Voters:    voters,
+			Events:    bus,
+		})
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "dev: failed to start %s: %s\n", info.ID, err)
+			for _, node := range cluster {
+				node.store.Close()
+			}
+			return 1
+		}
+		server := api.NewServer(info.HTTPAddr, store)
+		server.EnableEvents(bus)
+		m.Add(lifecycle.Component{
+			Name:  info.ID + " raft store",
+			Ready: store.Ready,
+			Stop:  func(context.Context) error { return store.Close() },
+		})
+		m.Add(lifecycle.Component{
+			Name:      info.ID + " http server",
+			DependsOn: []string{info.ID + " raft store"},
+			Start:     func(context.Context) error { return server.Start() },
+			Ready:     server.Ready,
+			Stop:      server.Shutdown,
+		})
+		cluster = append(cluster, &devNode{info: info, store: store, server: server})
+	}
+	if err := m.Start(context.Background()); err != nil {
+		fmt.Fprintf(os.Stderr, "dev: %s\n", err)
+		return 1
+	}
+
+	leader := waitForDevLeader(cluster, 10*time.Second)
+	if leader == nil {
+		fmt.Fprintln(os.Stderr, "dev: no leader elected")
+		return 1
+	}
+
+	// Register every node's HTTP address so followers can point clients at
+	// the leader and /cluster lists the whole cluster
+	for _, node := range cluster {
+		if err := leader.store.Join(node.info.ID, node.info.RaftAddr, node.info.HTTPAddr, true); err != nil {
+			log.Printf("Failed to register %s: %s", node.info.ID, err)
+		}
+	}
+
+	fmt.Printf("Dev cluster of %d nodes running, data in %s\n", len(cluster), dir)
+	for _, node := range cluster {
+		role := "follower"
+		if node == leader {
+			role = "leader"
+		}
+		fmt.Printf("  %-8s http://%s  raft %s  (%s)\n", node.info.ID, node.info.HTTPAddr, node.info.RaftAddr, role)
+	}
+	fmt.Println("Press Ctrl+C to stop")
+
+	terminate := make(chan os.Signal, 1)
+	signal.Notify(terminate, os.Interrupt)
+	<-terminate
+	fmt.Println("Dev cluster shutting down")
+	return 0
+}
+
+// waitForDevLeader waits until one of the nodes is leader
+func waitForDevLeader(cluster []*devNode, timeout time.Duration) *devNode {
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		for _, node := range cluster {
+			if node.store.IsLeader() {
+				return node
+			}
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	return nil
+}
@@ -0,0 +1,316 @@
+package drivers
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+func init() {
+	Register("bambu", openBambu)
+}
+
+const (
+	// bambuPort is the MQTT port of a printer in LAN mode
+	bambuPort = "8883"
+
+	// bambuKeepAlive is how often the connection is pinged
+	bambuKeepAlive = 30 * time.Second
+
+	// bambuStaleAfter is how long without a report before the printer is
+	// considered offline
+	bambuStaleAfter = 2 * bambuKeepAlive
+
+	// bambuRetryDelay is how long to wait before reconnecting
+	bambuRetryDelay = 5 * time.Second
+)
+
+// bambu drives a Bambu Lab printer in LAN mode through the MQTT broker it
+// runs. Job files are paths on its SD card; .3mf projects print their first
+// plate. The printer pushes partial reports, which are merged into the last
+// known state.
+type bambu struct {
+	addr       string
+	serial     string
+	accessCode string
+	tlsConfig  *tls.Config
+
+	mutex    sync.Mutex
+	conn     *mqttConn
+	report   map[string]json.RawMessage // merged "print" reports
+	seen     time.Time                  // when the last report arrived
+	sequence int
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+func openBambu(config Config) (PrinterDriver, error) {
+	if config.Serial == "" {
+		return nil, errors.New("bambu printers need their serial number")
+	}
+	accessCode, err := config.apiKey()
+	if err != nil {
+		return nil, err
+	}
+	if accessCode == "" {
+		return nil, errors.New("bambu printers need their LAN access code as api_key_ref")
+	}
+	host, port, err := net.SplitHostPort(config.Address)
+	if err != nil {
+		host, port = config.Address, bambuPort
+	}
+
+	// Printers present a certificate signed by Bambu Lab's own CA, which
+	// is only checked when it is configured
+	tlsConfig := &tls.Config{ServerName: config.Serial, InsecureSkipVerify: true}
+	if config.CAFile != "" {
+		pem, err := os.ReadFile(config.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("%s holds no PEM certificates", config.CAFile)
+		}
+		tlsConfig = &tls.Config{ServerName: config.Serial, RootCAs: pool}
+	}
+
+	b := &bambu{
+		addr:       net.JoinHostPort(host, port),
+		serial:     config.Serial,
+		accessCode: accessCode,
+		tlsConfig:  tlsConfig,
+		report:     make(map[string]json.RawMessage),
+		stop:       make(chan struct{}),
+		done:       make(chan struct{}),
+	}
+	go b.run()
+	return b, nil
+}
+
+// run keeps a connection to the printer and merges its reports until Close
+func (b *bambu) run() {
+	defer close(b.done)
+	for {
+		err := b.session()
+		select {
+		case <-b.stop:
+			return
+		default:
+		}
+		log.Printf("Bambu printer %s: %s; reconnecting in %s", b.serial, err, bambuRetryDelay)
+		select {
+		case <-time.After(bambuRetryDelay):
+		case <-b.stop:
+			return
+		}
+	}
+}
+
+// session connects, asks for the full state and reads reports until the
+// connection fails
+func (b *bambu) session() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	conn, err := dialMQTT(ctx, b.addr, b.tlsConfig, "raft3d-"+b.serial, "bblp", b.accessCode, bambuKeepAlive)
+	cancel()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if err := conn.Subscribe("device/" + b.serial + "/report"); err != nil {
+		return err
+	}
+
+	b.mutex.Lock()
+	b.conn = conn
+	b.mutex.Unlock()
+	defer func() {
+		b.mutex.Lock()
+		b.conn = nil
+		b.mutex.Unlock()
+	}()
+	if err := b.request("pushing", map[string]interface{}{"command": "pushall"}); err != nil {
+		return err
+	}
+
+	closed := make(chan struct{})
+	defer close(closed)
+	go func() {
+		ticker := time.NewTicker(bambuKeepAlive / 2)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				conn.Ping()
+			case <-b.stop:
+				conn.Close()
+				return
+			case <-closed:
+				return
+			}
+		}
+	}()
+
+	for {
+		_, payload, err := conn.ReadMessage()
+		if err != nil {
+			return err
+		}
+		var message struct {
+			Print map[string]json.RawMessage `json:"print"`
+		}
+		if json.Unmarshal(payload, &message) != nil || message.Print == nil {
+			continue
+		}
+		b.mutex.Lock()
+		for key, value := range message.Print {
+			b.report[key] = value
+		}
+		b.seen = time.Now()
+		b.mutex.Unlock()
+	}
+}
+
+// request publishes a numbered command of a group, such as "print", to the
+// printer
+func (b *bambu) request(group string, command map[string]interface{}) error {
+	b.mutex.Lock()
+	conn := b.conn
+	b.sequence++
+	command["sequence_id"] = strconv.Itoa(b.sequence)
+	b.mutex.Unlock()
+
+	if conn == nil {
+		return errors.New("not connected to the printer")
+	}
+	payload, err := json.Marshal(map[string]interface{}{group: command})
+	if err != nil {
+		return err
+	}
+	return conn.Publish("device/"+b.serial+"/request", payload)
+}
+
+func (b *bambu) StartJob(ctx context.Context, job Job) error {
+	status, err := b.Status(ctx)
+	if err != nil {
+		return err
+	}
+	if status.State == StatePrinting || status.State == StatePaused {
+		return ErrBusy
+	}
+	file := strings.TrimPrefix(job.FilePath, "/")
+	print := map[string]interface{}{"command": "gcode_file", "param": "/sdcard/" + file}
+	if strings.EqualFold(path.Ext(file), ".3mf") {
+		print = map[string]interface{}{
+			"command":      "project_file",
+			"param":        "Metadata/plate_1.gcode",
+			"url":          "file:///sdcard/" + file,
+			"subtask_name": strings.TrimSuffix(path.Base(file), path.Ext(file)),
+			"bed_leveling": true,
+			"use_ams":      false,
+		}
+	}
+	return b.request("print", print)
+}
+
+func (b *bambu) Cancel(ctx context.Context) error {
+	return b.request("print", map[string]interface{}{"command": "stop"})
+}
+
+// field decodes a field of the merged report into out, reporting whether it
+// was present. The caller must hold the mutex.
+func (b *bambu) field(name string, out interface{}) bool {
+	raw, ok := b.report[name]
+	return ok && json.Unmarshal(raw, out) == nil
+}
+
+func (b *bambu) Status(ctx context.Context) (Status, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if b.conn == nil || time.Since(b.seen) > bambuStaleAfter {
+		return Status{State: StateOffline}, nil
+	}
+	var state, file string
+	var printError int
+	var progress float64
+	b.field("gcode_state", &state)
+	b.field("gcode_file", &file)
+	b.field("print_error", &printError)
+	status := Status{File: file}
+	if b.field("mc_percent", &progress) {
+		status.ProgressPct = &progress
+	}
+
+	switch state {
+	case "RUNNING", "PREPARE", "SLICING":
+		status.State = StatePrinting
+	case "PAUSE":
+		status.State = StatePaused
+	case "FINISH":
+		status.State = StateDone
+	case "FAILED":
+		// A print stopped on the printer also ends as FAILED, without an
+		// error code
+		status.State = StateIdle
+		status.Message = "print was stopped on the printer"
+		if printError != 0 {
+			status.State = StateError
+			status.Message = fmt.Sprintf("print failed with error %08X", printError)
+		}
+	case "IDLE":
+		status.State = StateIdle
+	default:
+		status.State = StateOffline
+	}
+	return status, nil
+}
+
+func (b *bambu) Telemetry(ctx context.Context) (Telemetry, error) {
+	status, _ := b.Status(ctx)
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	var telemetry Telemetry
+	if status.State == StateOffline {
+		return telemetry, nil
+	}
+	var nozzle, bed float64
+	if b.field("nozzle_temper", &nozzle) {
+		telemetry.NozzleTempC = &nozzle
+	}
+	if b.field("bed_temper", &bed) {
+		telemetry.BedTempC = &bed
+	}
+	// The part cooling fan reports a level from 0 to 15 as a string
+	var fan string
+	if b.field("cooling_fan_speed", &fan) {
+		if level, err := strconv.Atoi(fan); err == nil {
+			pct := float64(level) * 100 / 15
+			telemetry.FanSpeedPct = &pct
+		}
+	}
+	if status.State == StatePrinting {
+		telemetry.ProgressPct = status.ProgressPct
+	}
+	return telemetry, nil
+}
+
+func (b *bambu) Close() error {
+	close(b.stop)
+	<-b.done
+	return nil
+}
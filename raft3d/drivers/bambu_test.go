@@ -0,0 +1,150 @@
+package drivers
+
+import (
+	"bufio"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// selfSigned returns a certificate like the one a printer presents
+func selfSigned(t *testing.T) tls.Certificate {
+	t.Helper()
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	template := &x509.Certificate{SerialNumber: big.NewInt(1), NotAfter: time.Now().Add(time.Hour)}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+// fakeBambu is a printer's MQTT broker: it accepts one client, answers
+// pushall with a report split in two, and passes on every request
+func fakeBambu(t *testing.T, serial, accessCode string) (string, <-chan map[string]interface{}) {
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: []tls.Certificate{selfSigned(t)}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	requests := make(chan map[string]interface{}, 10)
+
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		m := &mqttConn{conn: conn, reader: bufio.NewReader(conn)}
+
+		kind, body, err := m.readPacket()
+		if err != nil || kind>>4 != mqttConnect || !strings.Contains(string(body), "bblp") || !strings.HasSuffix(string(body), accessCode) {
+			m.write(mqttConnack<<4, []byte{0, 5})
+			return
+		}
+		m.write(mqttConnack<<4, []byte{0, 0})
+		report := func(fields string) {
+			m.Publish("device/"+serial+"/report", []byte(`{"print":{`+fields+`}}`))
+		}
+		for {
+			kind, body, err := m.readPacket()
+			if err != nil {
+				return
+			}
+			switch kind >> 4 {
+			case mqttSubscribe:
+				m.write(mqttSuback<<4, append(body[:2], 0))
+			case mqttPublish:
+				var request map[string]map[string]interface{}
+				json.Unmarshal(body[2+len("device/"+serial+"/request"):], &request)
+				if request["pushing"]["command"] == "pushall" {
+					report(`"gcode_state":"RUNNING","mc_percent":40,"nozzle_temper":220.0,"bed_temper":65.0`)
+					report(`"cooling_fan_speed":"15","gcode_file":"part.gcode"`)
+					continue
+				}
+				requests <- request["print"]
+			}
+		}
+	}()
+	return listener.Addr().String(), requests
+}
+
+func TestBambu(t *testing.T) {
+	codeFile := filepath.Join(t.TempDir(), "access-code")
+	os.WriteFile(codeFile, []byte("12345678\n"), 0600)
+	addr, requests := fakeBambu(t, "01S00A000000001", "12345678")
+
+	driver, err := Open(Config{Driver: "bambu", Address: addr, Serial: "01S00A000000001", APIKeyRef: codeFile})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer driver.Close()
+	ctx := context.Background()
+
+	// Both partial reports are merged
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		telemetry, _ := driver.Telemetry(ctx)
+		if telemetry.FanSpeedPct != nil {
+			if *telemetry.FanSpeedPct != 100 || *telemetry.ProgressPct != 40 || *telemetry.NozzleTempC != 220 {
+				t.Fatalf("telemetry %+v", telemetry)
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("no reports from the printer")
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	if status, _ := driver.Status(ctx); status.State != StatePrinting || status.File != "part.gcode" {
+		t.Fatalf("status %+v", status)
+	}
+	if err := driver.StartJob(ctx, Job{ID: "j1", FilePath: "part.3mf"}); err != ErrBusy {
+		t.Fatalf("starting on a busy printer = %v, want ErrBusy", err)
+	}
+
+	if err := driver.Cancel(ctx); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case request := <-requests:
+		if request["command"] != "stop" || request["sequence_id"] == "" {
+			t.Fatalf("cancel sent %v", request)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the printer got no stop command")
+	}
+}
+
+func TestBambuStates(t *testing.T) {
+	tests := map[string]string{
+		`"gcode_state":"IDLE"`:                     StateIdle,
+		`"gcode_state":"PREPARE"`:                  StatePrinting,
+		`"gcode_state":"PAUSE"`:                    StatePaused,
+		`"gcode_state":"FINISH"`:                   StateDone,
+		`"gcode_state":"FAILED","print_error":0`:   StateIdle,
+		`"gcode_state":"FAILED","print_error":123`: StateError,
+	}
+	for report, want := range tests {
+		b := &bambu{conn: &mqttConn{}, seen: time.Now(), report: make(map[string]json.RawMessage)}
+		json.Unmarshal([]byte("{"+report+"}"), &b.report)
+		if status, _ := b.Status(context.Background()); status.State != want {
+			t.Errorf("%s: state %q, want %q", report, status.State, want)
+		}
+	}
+
+	stale := &bambu{conn: &mqttConn{}, seen: time.Now().Add(-bambuStaleAfter - time.Second), report: map[string]json.RawMessage{"gcode_state": json.RawMessage(`"IDLE"`)}}
+	if status, _ := stale.Status(context.Background()); status.State != StateOffline {
+		t.Errorf("a printer without recent reports is %q, want offline", status.State)
+	}
+}
@@ -0,0 +1,193 @@
+// Package drivers controls physical printers through their firmware or host
+// software, so a node next to its printers can run the jobs assigned to them.
+package drivers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"raft3d/secrets"
+)
+
+// Printer states reported by drivers
+const (
+	StateIdle     = "idle"
+	StatePrinting = "printing"
+	StatePaused   = "paused"
+	StateDone     = "done" // finished the last job and not started another
+	StateError    = "error"
+	StateOffline  = "offline"
+)
+
+// ErrBusy is returned when a job is started on a printer that is printing
+var ErrBusy = errors.New("printer is busy")
+
+// Job is a print job handed to a driver
+type Job struct {
+	ID string
+
+	// FilePath is the job's G-code: a local file for drivers that stream it
+	// or a path in the host software's storage for those that don't
+	FilePath string
+}
+
+// Status is a printer's state as the driver sees it
+type Status struct {
+	State       string   `json:"state"`
+	File        string   `json:"file,omitempty"` // file being printed, if known
+	ProgressPct *float64 `json:"progress_pct,omitempty"`
+	Message     string   `json:"message,omitempty"`
+}
+
+// Telemetry is a reading from the printer. Every value is optional.
+type Telemetry struct {
+	NozzleTempC *float64 `json:"nozzle_temp_c,omitempty"`
+	BedTempC    *float64 `json:"bed_temp_c,omitempty"`
+	FanSpeedPct *float64 `json:"fan_speed_pct,omitempty"`
+	ProgressPct *float64 `json:"progress_pct,omitempty"`
+}
+
+// PrinterDriver controls one printer
+type PrinterDriver interface {
+	// StartJob starts printing a job, failing with ErrBusy if the printer
+	// is already printing
+	StartJob(ctx context.Context, job Job) error
+
+	// Cancel stops the current job, if any
+	Cancel(ctx context.Context) error
+
+	// Status reports what the printer is doing
+	Status(ctx context.Context) (Status, error)
+
+	// Telemetry reads the printer's temperatures and progress
+	Telemetry(ctx context.Context) (Telemetry, error)
+
+	// Close releases the connection to the printer
+	Close() error
+}
+
+// Config says how to reach a printer
+type Config struct {
+	Driver string `json:"driver"`
+
+	// Address is a serial device for Marlin, a base URL for drivers
+	// talking to host software and a host for Bambu Lab printers
+	Address string `json:"address"`
+
+	// APIKeyRef is the host software's API key, or a Bambu Lab printer's
+	// LAN access code, read with secrets.Read. Moonraker only needs one
+	// when it doesn't trust the node's address.
+	APIKeyRef string `json:"api_key_ref,omitempty"`
+
+	// Serial is the serial number of a Bambu Lab printer
+	Serial string `json:"serial,omitempty"`
+
+	// CAFile is a PEM file of the CA that signs a Bambu Lab printer's
+	// certificate. Without it the certificate isn't verified.
+	CAFile string `json:"ca_file,omitempty"`
+
+	// Baud is the serial speed (default 115200)
+	Baud int `json:"baud,omitempty"`
+
+	// FileRoot is the directory drivers that stream local files print
+	// from. Job file paths are resolved under it and may not leave it.
+	FileRoot string `json:"file_root,omitempty"`
+}
+
+// apiKey reads the configured API key, if any
+func (c Config) apiKey() (string, error) {
+	if c.APIKeyRef == "" {
+		return "", nil
+	}
+	key, err := secrets.Read(c.APIKeyRef)
+	if err != nil {
+		return "", fmt.Errorf("reading API key: %w", err)
+	}
+	return strings.TrimSpace(string(key)), nil
+}
+
+// Factory connects to a printer
+type Factory func(config Config) (PrinterDriver, error)
+
+var (
+	registryMutex sync.RWMutex
+	registry      = make(map[string]Factory)
+)
+
+// Register makes a driver available by name. It panics if the name is taken.
+func Register(name string, factory Factory) {
+	registryMutex.Lock()
+	defer registryMutex.Unlock()
+
+	if _, ok := registry[name]; ok {
+		panic("drivers: driver registered twice: " + name)
+	}
+	registry[name] = factory
+}
+
+// Names returns the registered drivers, sorted
+func Names() []string {
+	registryMutex.RLock()
+	defer registryMutex.RUnlock()
+
+	names := make([]string, 0, len(registry))
+	for name := range registry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Open connects to a printer with the driver its config names
+func Open(config Config) (PrinterDriver, error) {
+	registryMutex.RLock()
+	factory, ok := registry[config.Driver]
+	registryMutex.RUnlock()
+
+	if !ok {
+		return nil, fmt.Errorf("unknown driver %q (want one of %v)", config.Driver, Names())
+	}
+	return factory(config)
+}
+
+// LoadConfig reads a JSON object mapping printer IDs to their driver config
+// from a file or Vault secret reference, as understood by secrets.Read
+func LoadConfig(path string) (map[string]Config, error) {
+	data, err := secrets.Read(path)
+	if err != nil {
+		return nil, err
+	}
+	var configs map[string]Config
+	if err := json.Unmarshal(data, &configs); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	for printerID, config := range configs {
+		if err := config.Validate(); err != nil {
+			return nil, fmt.Errorf("%s: printer %s: %w", path, printerID, err)
+		}
+	}
+	return configs, nil
+}
+
+// Validate checks that the config names a registered driver and says how to
+// reach the printer
+func (c Config) Validate() error {
+	registryMutex.RLock()
+	_, ok := registry[c.Driver]
+	registryMutex.RUnlock()
+
+	switch {
+	case c.Driver == "" || c.Address == "":
+		return errors.New("driver and address are required")
+	case !ok:
+		return fmt.Errorf("unknown driver %q (want one of %v)", c.Driver, Names())
+	case c.Driver == "bambu" && c.Serial == "":
+		return errors.New("bambu printers need their serial number")
+	}
+	return nil
+}
@@ -0,0 +1,333 @@
+package drivers
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+func init() {
+	Register("marlin", openMarlin)
+}
+
+const (
+	// marlinDefaultBaud is the serial speed most Marlin boards ship with
+	marlinDefaultBaud = 115200
+
+	// marlinBootTime is how long a board takes to restart after the port
+	// is opened, which resets most of them
+	marlinBootTime = 2 * time.Second
+
+	// marlinCommandTimeout is how long the firmware may stay silent before
+	// a command is given up on. Long moves and heating keep the host
+	// informed with busy and temperature reports.
+	marlinCommandTimeout = 2 * time.Minute
+)
+
+// marlinTemps picks the current temperatures out of an M105 report such as
+// "ok T:210.1 /210.0 B:59.8 /60.0 @:64 B@:0"
+var (
+	marlinNozzleTemp = regexp.MustCompile(`\bT0?:\s*(-?[\d.]+)`)
+	marlinBedTemp    = regexp.MustCompile(`\bB:\s*(-?[\d.]+)`)
+)
+
+// marlin drives a printer running Marlin firmware over a serial port,
+// streaming G-code files from the node line by line. Streaming stops if the
+// node does, so jobs only survive as long as the process driving them.
+type marlin struct {
+	port  io.ReadWriteCloser
+	root  string
+	lines chan string // lines received from the firmware
+
+	commandMutex sync.Mutex // one command in flight at a time
+
+	mutex  sync.Mutex
+	status Status
+	cancel chan struct{} // closed to stop the file being streamed
+	done   chan struct{} // closed once streaming has stopped
+}
+
+func openMarlin(config Config) (PrinterDriver, error) {
+	baud := config.Baud
+	if baud == 0 {
+		baud = marlinDefaultBaud
+	}
+	port, err := openSerial(config.Address, baud)
+	if err != nil {
+		return nil, err
+	}
+	return newMarlin(port, config.FileRoot)
+}
+
+// newMarlin talks to Marlin over an open port and checks it answers
+func newMarlin(port io.ReadWriteCloser, root string) (*marlin, error) {
+	m := &marlin{
+		port:   port,
+		root:   root,
+		lines:  make(chan string, 64),
+		status: Status{State: StateIdle},
+	}
+	go m.read()
+
+	time.Sleep(marlinBootTime)
+	if _, err := m.command("M115"); err != nil {
+		port.Close()
+		return nil, fmt.Errorf("firmware did not answer: %w", err)
+	}
+	return m, nil
+}
+
+// read forwards lines from the port until it is closed
+func (m *marlin) read() {
+	scanner := bufio.NewScanner(m.port)
+	for scanner.Scan() {
+		m.lines <- strings.TrimSpace(scanner.Text())
+	}
+	close(m.lines)
+}
+
+// command sends one line of G-code and waits for the firmware's "ok",
+// returning the lines it printed in between. Lines received before the
+// command was sent are discarded.
+func (m *marlin) command(gcode string) ([]string, error) {
+	m.commandMutex.Lock()
+	defer m.commandMutex.Unlock()
+
+	for drained := false; !drained; {
+		select {
+		case <-m.lines:
+		default:
+			drained = true
+		}
+	}
+	if _, err := io.WriteString(m.port, gcode+"\n"); err != nil {
+		return nil, err
+	}
+
+	timer := time.NewTimer(marlinCommandTimeout)
+	defer timer.Stop()
+	var reply []string
+	var failure string
+	for {
+		select {
+		case line, ok := <-m.lines:
+			if !ok {
+				return reply, errors.New("serial port closed")
+			}
+			timer.Reset(marlinCommandTimeout)
+			switch {
+			case strings.HasPrefix(line, "ok"):
+				reply = append(reply, line)
+				if failure != "" {
+					return reply, fmt.Errorf("%s: %s", gcode, failure)
+				}
+				return reply, nil
+			case strings.HasPrefix(line, "Error:"):
+				failure = strings.TrimPrefix(line, "Error:")
+				if strings.Contains(line, "halted") || strings.Contains(line, "kill()") {
+					return reply, fmt.Errorf("%s: %s", gcode, failure)
+				}
+			case strings.HasPrefix(line, "echo:busy"), strings.HasPrefix(line, "busy:"):
+			default:
+				reply = append(reply, line)
+			}
+		case <-timer.C:
+			return reply, fmt.Errorf("%s: no answer within %s", gcode, marlinCommandTimeout)
+		}
+	}
+}
+
+func (m *marlin) StartJob(ctx context.Context, job Job) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if m.status.State == StatePrinting {
+		return ErrBusy
+	}
+	path, err := m.resolve(job.FilePath)
+	if err != nil {
+		return err
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+
+	zero := 0.0
+	m.status = Status{State: StatePrinting, File: job.FilePath, ProgressPct: &zero}
+	m.cancel = make(chan struct{})
+	m.done = make(chan struct{})
+	go m.stream(file, info.Size(), m.cancel, m.done)
+	return nil
+}
+
+// resolve turns a job's file path into a file under the root directory.
+// Paths come from whoever created the job, so absolute paths and paths
+// climbing out of the root are refused.
+func (m *marlin) resolve(path string) (string, error) {
+	if m.root == "" {
+		return "", errors.New("no file_root is configured to print from")
+	}
+	if path == "" || filepath.IsAbs(path) || strings.HasPrefix(path, "/") {
+		return "", fmt.Errorf("file path %q must be relative to the file root", path)
+	}
+	for _, part := range strings.FieldsFunc(path, func(r rune) bool { return r == '/' || r == filepath.Separator }) {
+		if part == ".." {
+			return "", fmt.Errorf("file path %q must not contain ..", path)
+		}
+	}
+	root, err := filepath.Abs(m.root)
+	if err == nil {
+		root, err = filepath.EvalSymlinks(root)
+	}
+	if err != nil {
+		return "", err
+	}
+	// Links inside the root must not lead out of it either
+	resolved, err := filepath.EvalSymlinks(filepath.Join(root, path))
+	if err != nil {
+		return "", err
+	}
+	if !strings.HasPrefix(resolved, root+string(filepath.Separator)) {
+		return "", fmt.Errorf("file path %q is outside the file root", path)
+	}
+	return resolved, nil
+}
+
+// stream sends a G-code file to the firmware, stripping comments, until it
+// ends, fails or is canceled
+func (m *marlin) stream(file *os.File, size int64, cancel, done chan struct{}) {
+	defer close(done)
+	defer file.Close()
+
+	reader := bufio.NewReader(file)
+	var sent int64
+	for {
+		line, readErr := reader.ReadString('\n')
+		sent += int64(len(line))
+		if i := strings.IndexByte(line, ';'); i >= 0 {
+			line = line[:i]
+		}
+		if gcode := strings.TrimSpace(line); gcode != "" {
+			select {
+			case <-cancel:
+				m.coolDown()
+				m.finish(StateIdle, "canceled")
+				return
+			default:
+			}
+			if _, err := m.command(gcode); err != nil {
+				m.finish(StateError, err.Error())
+				return
+			}
+		}
+
+		switch {
+		case readErr == io.EOF:
+			m.finish(StateDone, "")
+			return
+		case readErr != nil:
+			m.finish(StateError, readErr.Error())
+			return
+		}
+		if size > 0 {
+			progress := float64(sent) * 100 / float64(size)
+			m.mutex.Lock()
+			m.status.ProgressPct = &progress
+			m.mutex.Unlock()
+		}
+	}
+}
+
+// coolDown turns off the heaters, fan and motors after a canceled job
+func (m *marlin) coolDown() {
+	for _, gcode := range []string{"M104 S0", "M140 S0", "M107", "M84"} {
+		if _, err := m.command(gcode); err != nil {
+			return
+		}
+	}
+}
+
+// finish records how streaming ended
+func (m *marlin) finish(state, message string) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.status.State = state
+	m.status.Message = message
+	if state == StateDone {
+		complete := 100.0
+		m.status.ProgressPct = &complete
+	}
+}
+
+func (m *marlin) Cancel(ctx context.Context) error {
+	m.mutex.Lock()
+	if m.status.State != StatePrinting {
+		m.mutex.Unlock()
+		return nil
+	}
+	cancel, done := m.cancel, m.done
+	m.mutex.Unlock()
+
+	select {
+	case <-cancel:
+	default:
+		close(cancel)
+	}
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (m *marlin) Status(ctx context.Context) (Status, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	return m.status, nil
+}
+
+func (m *marlin) Telemetry(ctx context.Context) (Telemetry, error) {
+	reply, err := m.command("M105")
+	if err != nil {
+		return Telemetry{}, err
+	}
+	report := strings.Join(reply, " ")
+
+	var telemetry Telemetry
+	if match := marlinNozzleTemp.FindStringSubmatch(report); match != nil {
+		if temp, err := strconv.ParseFloat(match[1], 64); err == nil {
+			telemetry.NozzleTempC = &temp
+		}
+	}
+	if match := marlinBedTemp.FindStringSubmatch(report); match != nil {
+		if temp, err := strconv.ParseFloat(match[1], 64); err == nil {
+			telemetry.BedTempC = &temp
+		}
+	}
+	if status, _ := m.Status(ctx); status.State == StatePrinting {
+		telemetry.ProgressPct = status.ProgressPct
+	}
+	return telemetry, nil
+}
+
+func (m *marlin) Close() error {
+	return m.port.Close()
+}
@@ -0,0 +1,165 @@
+package drivers
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeMarlin answers G-code like Marlin firmware on the other end of a
+// pipe, holding each G28 until it is released
+type fakeMarlin struct {
+	hold chan struct{}
+
+	mutex    sync.Mutex
+	received []string
+}
+
+func (f *fakeMarlin) serve(conn net.Conn) {
+	scanner := bufio.NewScanner(conn)
+	for scanner.Scan() {
+		line := scanner.Text()
+		f.mutex.Lock()
+		f.received = append(f.received, line)
+		f.mutex.Unlock()
+
+		switch line {
+		case "G28":
+			fmt.Fprint(conn, "echo:busy: processing\n")
+			<-f.hold
+			fmt.Fprint(conn, "ok\n")
+		case "M105":
+			fmt.Fprint(conn, "ok T:210.1 /210.0 B:59.8 /60.0 @:64 B@:0\n")
+		case "M112":
+			fmt.Fprint(conn, "Error:Printer halted. kill() called!\n")
+		default:
+			fmt.Fprint(conn, "ok\n")
+		}
+	}
+}
+
+// since returns the commands received after the first n
+func (f *fakeMarlin) since(n int) []string {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return append([]string{}, f.received[n:]...)
+}
+
+func (f *fakeMarlin) count() int {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return len(f.received)
+}
+
+// waitForState polls the driver until it reports state
+func waitForState(t *testing.T, driver PrinterDriver, state string) Status {
+	t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		status, _ := driver.Status(context.Background())
+		if status.State == state {
+			return status
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("state %q, want %q", status.State, state)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestMarlin(t *testing.T) {
+	root := t.TempDir()
+	os.WriteFile(filepath.Join(root, "part.gcode"), []byte("; sliced\nG28 ; home\nG1 X10 Y10\n\nM104 S200\n"), 0600)
+	os.WriteFile(filepath.Join(root, "halt.gcode"), []byte("G1 X1\nM112\nG1 X2\n"), 0600)
+	outside := filepath.Join(t.TempDir(), "outside.gcode")
+	os.WriteFile(outside, []byte("G1 X1\n"), 0600)
+	os.Symlink(outside, filepath.Join(root, "link.gcode"))
+
+	host, firmware := net.Pipe()
+	fake := &fakeMarlin{hold: make(chan struct{})}
+	go fake.serve(firmware)
+	driver, err := newMarlin(host, root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer driver.Close()
+	ctx := context.Background()
+	if got := fake.since(0); len(got) != 1 || got[0] != "M115" {
+		t.Fatalf("handshake sent %v", got)
+	}
+
+	for _, path := range []string{"", "/etc/passwd", "../outside.gcode", "parts/../../outside.gcode", "link.gcode", "missing.gcode"} {
+		if err := driver.StartJob(ctx, Job{ID: "j0", FilePath: path}); err == nil {
+			t.Fatalf("started %q", path)
+		}
+	}
+
+	// The file is streamed without comments or blank lines
+	sent := fake.count()
+	if err := driver.StartJob(ctx, Job{ID: "j1", FilePath: "part.gcode"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := driver.StartJob(ctx, Job{ID: "j2", FilePath: "part.gcode"}); !errors.Is(err, ErrBusy) {
+		t.Fatalf("StartJob while printing = %v", err)
+	}
+	fake.hold <- struct{}{}
+	if status := waitForState(t, driver, StateDone); *status.ProgressPct != 100 || status.File != "part.gcode" {
+		t.Fatalf("status after printing: %+v", status)
+	}
+	if got := strings.Join(fake.since(sent), ","); got != "G28,G1 X10 Y10,M104 S200" {
+		t.Fatalf("streamed %s", got)
+	}
+
+	telemetry, err := driver.Telemetry(ctx)
+	if err != nil || *telemetry.NozzleTempC != 210.1 || *telemetry.BedTempC != 59.8 || telemetry.ProgressPct != nil {
+		t.Fatalf("telemetry %+v %v", telemetry, err)
+	}
+
+	// Canceling stops streaming and cools the printer down
+	sent = fake.count()
+	if err := driver.StartJob(ctx, Job{ID: "j3", FilePath: "part.gcode"}); err != nil {
+		t.Fatal(err)
+	}
+	for fake.count() == sent {
+		time.Sleep(10 * time.Millisecond)
+	}
+	canceled := make(chan error, 1)
+	go func() { canceled <- driver.Cancel(ctx) }()
+	// Only release G28 once the cancel is requested
+	for stopped := false; !stopped; {
+		driver.mutex.Lock()
+		stop := driver.cancel
+		driver.mutex.Unlock()
+		select {
+		case <-stop:
+			stopped = true
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+	fake.hold <- struct{}{}
+	if err := <-canceled; err != nil {
+		t.Fatal(err)
+	}
+	if status := waitForState(t, driver, StateIdle); status.Message != "canceled" {
+		t.Fatalf("status after canceling: %+v", status)
+	}
+	if got := strings.Join(fake.since(sent), ","); got != "G28,M104 S0,M140 S0,M107,M84" {
+		t.Fatalf("sent %s while canceling", got)
+	}
+
+	// A firmware error fails the job
+	if err := driver.StartJob(ctx, Job{ID: "j4", FilePath: "halt.gcode"}); err != nil {
+		t.Fatal(err)
+	}
+	if status := waitForState(t, driver, StateError); !strings.Contains(status.Message, "halted") {
+		t.Fatalf("status after a halt: %+v", status)
+	}
+}
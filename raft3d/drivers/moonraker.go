@@ -0,0 +1,214 @@
+package drivers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+func init() {
+	Register("moonraker", openMoonraker)
+}
+
+// moonrakerObjects are the Klipper objects a status query reads
+const moonrakerObjects = "webhooks&print_stats&virtual_sdcard&extruder&heater_bed&fan"
+
+// moonraker drives a Klipper printer through Moonraker's HTTP API. Job files
+// are paths in Moonraker's gcodes directory.
+type moonraker struct {
+	base   string
+	apiKey string
+	client *http.Client
+}
+
+func openMoonraker(config Config) (PrinterDriver, error) {
+	base, err := url.Parse(config.Address)
+	if err != nil || (base.Scheme != "http" && base.Scheme != "https") || base.Host == "" {
+		return nil, fmt.Errorf("moonraker address %q must be an http or https URL", config.Address)
+	}
+	apiKey, err := config.apiKey()
+	if err != nil {
+		return nil, err
+	}
+	return &moonraker{
+		base:   strings.TrimSuffix(config.Address, "/"),
+		apiKey: apiKey,
+		client: &http.Client{Timeout: 10 * time.Second},
+	}, nil
+}
+
+// call sends a request to the API and decodes the "result" member of the
+// response into out, unless it is nil
+func (m *moonraker) call(ctx context.Context, method, path string, out interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, method, m.base+path, nil)
+	if err != nil {
+		return err
+	}
+	if m.apiKey != "" {
+		req.Header.Set("X-Api-Key", m.apiKey)
+	}
+
+	resp, err := m.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		var failure struct {
+			Error struct {
+				Message string `json:"message"`
+			} `json:"error"`
+		}
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		if json.Unmarshal(msg, &failure) == nil && failure.Error.Message != "" {
+			msg = []byte(failure.Error.Message)
+		}
+		return fmt.Errorf("%s %s: %s: %s", method, path, resp.Status, strings.TrimSpace(string(msg)))
+	}
+	if out == nil {
+		return nil
+	}
+	var envelope struct {
+		Result json.RawMessage `json:"result"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&envelope); err != nil {
+		return err
+	}
+	return json.Unmarshal(envelope.Result, out)
+}
+
+// moonrakerStatus is the part of a printer objects query the driver reads
+type moonrakerStatus struct {
+	Webhooks struct {
+		State        string `json:"state"`
+		StateMessage string `json:"state_message"`
+	} `json:"webhooks"`
+	PrintStats struct {
+		State    string `json:"state"`
+		Filename string `json:"filename"`
+		Message  string `json:"message"`
+	} `json:"print_stats"`
+	VirtualSDCard struct {
+		Progress *float64 `json:"progress"`
+	} `json:"virtual_sdcard"`
+	Extruder struct {
+		Temperature *float64 `json:"temperature"`
+	} `json:"extruder"`
+	HeaterBed struct {
+		Temperature *float64 `json:"temperature"`
+	} `json:"heater_bed"`
+	Fan struct {
+		Speed *float64 `json:"speed"`
+	} `json:"fan"`
+}
+
+func (m *moonraker) query(ctx context.Context) (moonrakerStatus, error) {
+	var result struct {
+		Status moonrakerStatus `json:"status"`
+	}
+	err := m.call(ctx, http.MethodGet, "/printer/objects/query?"+moonrakerObjects, &result)
+	return result.Status, err
+}
+
+func (m *moonraker) StartJob(ctx context.Context, job Job) error {
+	status, err := m.Status(ctx)
+	if err != nil {
+		return err
+	}
+	if status.State == StatePrinting || status.State == StatePaused {
+		return ErrBusy
+	}
+	path := "/printer/print/start?filename=" + url.QueryEscape(strings.TrimPrefix(job.FilePath, "/"))
+	return m.call(ctx, http.MethodPost, path, nil)
+}
+
+func (m *moonraker) Cancel(ctx context.Context) error {
+	return m.call(ctx, http.MethodPost, "/printer/print/cancel", nil)
+}
+
+func (m *moonraker) Status(ctx context.Context) (Status, error) {
+	objects, err := m.query(ctx)
+	if err != nil {
+		return Status{}, err
+	}
+	return moonrakerState(objects), nil
+}
+
+// moonrakerState maps Klipper's host and print_stats states to a driver
+// state. A print canceled on the printer reads as idle, which fails the job.
+func moonrakerState(objects moonrakerStatus) Status {
+	stats := objects.PrintStats
+	status := Status{File: stats.Filename, ProgressPct: percent(objects.VirtualSDCard.Progress), Message: stats.Message}
+
+	switch objects.Webhooks.State {
+	case "ready":
+	case "shutdown", "error":
+		status.State = StateError
+		status.Message = objects.Webhooks.StateMessage
+		return status
+	default:
+		// Klipper is starting up or Moonraker lost its connection to it
+		status.State = StateOffline
+		return status
+	}
+
+	switch stats.State {
+	case "printing":
+		status.State = StatePrinting
+	case "paused":
+		status.State = StatePaused
+	case "complete":
+		status.State = StateDone
+	case "error":
+		status.State = StateError
+		if status.Message == "" {
+			status.Message = "print failed"
+		}
+	case "cancelled":
+		status.State = StateIdle
+		if status.Message == "" {
+			status.Message = "print was canceled on the printer"
+		}
+	case "standby":
+		status.State = StateIdle
+	default:
+		status.State = StateOffline
+	}
+	return status
+}
+
+func (m *moonraker) Telemetry(ctx context.Context) (Telemetry, error) {
+	objects, err := m.query(ctx)
+	if err != nil {
+		return Telemetry{}, err
+	}
+	telemetry := Telemetry{
+		NozzleTempC: objects.Extruder.Temperature,
+		BedTempC:    objects.HeaterBed.Temperature,
+		FanSpeedPct: percent(objects.Fan.Speed),
+	}
+	if moonrakerState(objects).State == StatePrinting {
+		telemetry.ProgressPct = percent(objects.VirtualSDCard.Progress)
+	}
+	return telemetry, nil
+}
+
+func (m *moonraker) Close() error {
+	m.client.CloseIdleConnections()
+	return nil
+}
+
+// percent scales a 0-1 fraction to a percentage
+func percent(fraction *float64) *float64 {
+	if fraction == nil {
+		return nil
+	}
+	pct := *fraction * 100
+	return &pct
+}
@@ -0,0 +1,73 @@
+package drivers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestMoonrakerStates(t *testing.T) {
+	tests := []struct {
+		host, print string
+		want        string
+	}{
+		{"ready", "standby", StateIdle},
+		{"ready", "printing", StatePrinting},
+		{"ready", "paused", StatePaused},
+		{"ready", "complete", StateDone},
+		{"ready", "cancelled", StateIdle},
+		{"ready", "error", StateError},
+		{"shutdown", "printing", StateError},
+		{"startup", "standby", StateOffline},
+	}
+	keyFile := filepath.Join(t.TempDir(), "moonraker.key")
+	os.WriteFile(keyFile, []byte("k\n"), 0600)
+
+	for _, tt := range tests {
+		var started string
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get("X-Api-Key") != "k" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			switch r.URL.Path {
+			case "/printer/objects/query":
+				fmt.Fprintf(w, `{"result":{"status":{"webhooks":{"state":%q},"print_stats":{"state":%q,"filename":"part.gcode"},
+					"virtual_sdcard":{"progress":0.25},"extruder":{"temperature":210.5},"fan":{"speed":0.5}}}}`, tt.host, tt.print)
+			case "/printer/print/start":
+				started = r.URL.Query().Get("filename")
+				fmt.Fprint(w, `{"result":"ok"}`)
+			}
+		}))
+		driver, err := Open(Config{Driver: "moonraker", Address: srv.URL, APIKeyRef: keyFile})
+		if err != nil {
+			t.Fatal(err)
+		}
+		ctx := context.Background()
+
+		status, err := driver.Status(ctx)
+		if err != nil || status.State != tt.want {
+			t.Errorf("%s/%s: state %q (%v), want %q", tt.host, tt.print, status.State, err, tt.want)
+		}
+		if tt.want == StatePrinting {
+			telemetry, _ := driver.Telemetry(ctx)
+			if *telemetry.ProgressPct != 25 || *telemetry.FanSpeedPct != 50 || *telemetry.NozzleTempC != 210.5 {
+				t.Errorf("telemetry %+v", telemetry)
+			}
+			if err := driver.StartJob(ctx, Job{ID: "j1", FilePath: "/benchy v2.gcode"}); err != ErrBusy {
+				t.Errorf("starting on a busy printer = %v, want ErrBusy", err)
+			}
+		}
+		if tt.want == StateIdle {
+			if err := driver.StartJob(ctx, Job{ID: "j1", FilePath: "/benchy v2.gcode"}); err != nil || started != "benchy v2.gcode" {
+				t.Errorf("StartJob = %v, started %q", err, started)
+			}
+		}
+		driver.Close()
+		srv.Close()
+	}
+}
@@ -0,0 +1,212 @@
+package drivers
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// MQTT 3.1.1 packet types, in the high nibble of the fixed header
+const (
+	mqttConnect    = 1
+	mqttConnack    = 2
+	mqttPublish    = 3
+	mqttSubscribe  = 8
+	mqttSuback     = 9
+	mqttPingreq    = 12
+	mqttDisconnect = 14
+)
+
+// mqttMaxPacket bounds the packets read from a broker
+const mqttMaxPacket = 4 << 20
+
+// mqttConn is a minimal MQTT 3.1.1 client: enough to subscribe and publish
+// at QoS 0, which is all printers speaking MQTT need
+type mqttConn struct {
+	conn   net.Conn
+	reader *bufio.Reader
+
+	writeMutex sync.Mutex
+	packetID   uint16
+}
+
+// dialMQTT connects and logs in to a broker over TLS
+func dialMQTT(ctx context.Context, addr string, config *tls.Config, clientID, username, password string, keepAlive time.Duration) (*mqttConn, error) {
+	dialer := &tls.Dialer{Config: config}
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	m := &mqttConn{conn: conn, reader: bufio.NewReader(conn)}
+
+	var body []byte
+	body = appendMQTTString(body, "MQTT")
+	flags := byte(0x02) // clean session
+	if username != "" {
+		flags |= 0x80
+	}
+	if password != "" {
+		flags |= 0x40
+	}
+	body = append(body, 4, flags)
+	body = binary.BigEndian.AppendUint16(body, uint16(keepAlive/time.Second))
+	body = appendMQTTString(body, clientID)
+	if username != "" {
+		body = appendMQTTString(body, username)
+	}
+	if password != "" {
+		body = appendMQTTString(body, password)
+	}
+
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	if err := m.write(mqttConnect<<4, body); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	kind, reply, err := m.readPacket()
+	if err == nil && (kind>>4 != mqttConnack || len(reply) != 2) {
+		err = errors.New("mqtt: broker did not acknowledge the connection")
+	}
+	if err == nil && reply[1] != 0 {
+		err = fmt.Errorf("mqtt: connection refused with code %d", reply[1])
+	}
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	conn.SetDeadline(time.Time{})
+	return m, nil
+}
+
+// Subscribe asks for the messages published to topic at QoS 0. The broker's
+// acknowledgement arrives through ReadMessage, which skips it.
+func (m *mqttConn) Subscribe(topic string) error {
+	body := binary.BigEndian.AppendUint16(nil, m.nextPacketID())
+	body = appendMQTTString(body, topic)
+	body = append(body, 0)
+	return m.write(mqttSubscribe<<4|0x02, body)
+}
+
+// Publish sends payload to topic at QoS 0
+func (m *mqttConn) Publish(topic string, payload []byte) error {
+	body := appendMQTTString(nil, topic)
+	return m.write(mqttPublish<<4, append(body, payload...))
+}
+
+// Ping keeps the connection alive
+func (m *mqttConn) Ping() error {
+	return m.write(mqttPingreq<<4, nil)
+}
+
+// ReadMessage returns the next message published to a subscribed topic
+func (m *mqttConn) ReadMessage() (string, []byte, error) {
+	for {
+		kind, body, err := m.readPacket()
+		if err != nil {
+			return "", nil, err
+		}
+		if kind>>4 != mqttPublish {
+			continue
+		}
+		if len(body) < 2 {
+			return "", nil, errors.New("mqtt: malformed publish")
+		}
+		n := int(binary.BigEndian.Uint16(body))
+		if len(body) < 2+n {
+			return "", nil, errors.New("mqtt: malformed publish")
+		}
+		topic, payload := string(body[2:2+n]), body[2+n:]
+		// Messages above QoS 0 carry a packet ID
+		if (kind>>1)&0x03 > 0 {
+			if len(payload) < 2 {
+				return "", nil, errors.New("mqtt: malformed publish")
+			}
+			payload = payload[2:]
+		}
+		return topic, payload, nil
+	}
+}
+
+// Close disconnects from the broker
+func (m *mqttConn) Close() error {
+	m.write(mqttDisconnect<<4, nil)
+	return m.conn.Close()
+}
+
+func (m *mqttConn) nextPacketID() uint16 {
+	m.writeMutex.Lock()
+	defer m.writeMutex.Unlock()
+	m.packetID++
+	if m.packetID == 0 {
+		m.packetID = 1
+	}
+	return m.packetID
+}
+
+// write sends a packet with the given first header byte
+func (m *mqttConn) write(header byte, body []byte) error {
+	packet := []byte{header}
+	for n := len(body); ; {
+		b := byte(n % 128)
+		n /= 128
+		if n > 0 {
+			b |= 0x80
+		}
+		packet = append(packet, b)
+		if n == 0 {
+			break
+		}
+	}
+	packet = append(packet, body...)
+
+	m.writeMutex.Lock()
+	defer m.writeMutex.Unlock()
+	_, err := m.conn.Write(packet)
+	return err
+}
+
+// readPacket reads a packet, returning its first header byte and its body
+func (m *mqttConn) readPacket() (byte, []byte, error) {
+	header, err := m.reader.ReadByte()
+	if err != nil {
+		return 0, nil, err
+	}
+	length, multiplier := 0, 1
+	for i := 0; ; i++ {
+		b, err := m.reader.ReadByte()
+		if err != nil {
+			return 0, nil, err
+		}
+		length += int(b&0x7f) * multiplier
+		if b&0x80 == 0 {
+			break
+		}
+		if i == 3 {
+			return 0, nil, errors.New("mqtt: malformed packet length")
+		}
+		multiplier *= 128
+	}
+	if length > mqttMaxPacket {
+		return 0, nil, fmt.Errorf("mqtt: %d byte packet is too large", length)
+	}
+	body := make([]byte, length)
+	if _, err := io.ReadFull(m.reader, body); err != nil {
+		return 0, nil, err
+	}
+	return header, body, nil
+}
+
+// appendMQTTString appends a length-prefixed UTF-8 string
+func appendMQTTString(b []byte, s string) []byte {
+	b = binary.BigEndian.AppendUint16(b, uint16(len(s)))
+	return append(b, s...)
+}
@@ -0,0 +1,158 @@
+package drivers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+func init() {
+	Register("octoprint", openOctoPrint)
+}
+
+// octoPrint drives a printer through OctoPrint's REST API. Job files are
+// paths in OctoPrint's local storage.
+type octoPrint struct {
+	base   string
+	apiKey string
+	client *http.Client
+}
+
+func openOctoPrint(config Config) (PrinterDriver, error) {
+	base, err := url.Parse(config.Address)
+	if err != nil || (base.Scheme != "http" && base.Scheme != "https") || base.Host == "" {
+		return nil, fmt.Errorf("octoprint address %q must be an http or https URL", config.Address)
+	}
+	apiKey, err := config.apiKey()
+	if err != nil {
+		return nil, err
+	}
+	return &octoPrint{
+		base:   strings.TrimSuffix(config.Address, "/"),
+		apiKey: apiKey,
+		client: &http.Client{Timeout: 10 * time.Second},
+	}, nil
+}
+
+// call sends a request to the API and decodes a JSON response into out,
+// unless it is nil
+func (o *octoPrint) call(ctx context.Context, method, path string, body, out interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, o.base+path, reader)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if o.apiKey != "" {
+		req.Header.Set("X-Api-Key", o.apiKey)
+	}
+
+	resp, err := o.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("%s %s: %s: %s", method, path, resp.Status, strings.TrimSpace(string(msg)))
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+func (o *octoPrint) StartJob(ctx context.Context, job Job) error {
+	status, err := o.Status(ctx)
+	if err != nil {
+		return err
+	}
+	if status.State == StatePrinting || status.State == StatePaused {
+		return ErrBusy
+	}
+	path := "/api/files/local/" + strings.TrimPrefix(job.FilePath, "/")
+	return o.call(ctx, http.MethodPost, path, map[string]interface{}{"command": "select", "print": true}, nil)
+}
+
+func (o *octoPrint) Cancel(ctx context.Context) error {
+	return o.call(ctx, http.MethodPost, "/api/job", map[string]string{"command": "cancel"}, nil)
+}
+
+func (o *octoPrint) Status(ctx context.Context) (Status, error) {
+	var job struct {
+		State string `json:"state"`
+		Error string `json:"error"`
+		Job   struct {
+			File struct {
+				Path string `json:"path"`
+			} `json:"file"`
+		} `json:"job"`
+		Progress struct {
+			Completion *float64 `json:"completion"`
+		} `json:"progress"`
+	}
+	if err := o.call(ctx, http.MethodGet, "/api/job", nil, &job); err != nil {
+		return Status{}, err
+	}
+
+	status := Status{File: job.Job.File.Path, ProgressPct: job.Progress.Completion, Message: job.Error}
+	switch state := job.State; {
+	case strings.HasPrefix(state, "Printing"), state == "Starting", state == "Cancelling", state == "Finishing":
+		status.State = StatePrinting
+	case strings.HasPrefix(state, "Paus"), state == "Resuming":
+		status.State = StatePaused
+	case strings.Contains(state, "rror"):
+		status.State = StateError
+		if status.Message == "" {
+			status.Message = state
+		}
+	case state == "Operational":
+		status.State = StateIdle
+		if job.Job.File.Path != "" && job.Progress.Completion != nil && *job.Progress.Completion >= 100 {
+			status.State = StateDone
+		}
+	default:
+		status.State = StateOffline
+	}
+	return status, nil
+}
+
+func (o *octoPrint) Telemetry(ctx context.Context) (Telemetry, error) {
+	var printer struct {
+		Temperature map[string]struct {
+			Actual *float64 `json:"actual"`
+		} `json:"temperature"`
+	}
+	if err := o.call(ctx, http.MethodGet, "/api/printer?exclude=sd,state", nil, &printer); err != nil {
+		return Telemetry{}, err
+	}
+	telemetry := Telemetry{
+		NozzleTempC: printer.Temperature["tool0"].Actual,
+		BedTempC:    printer.Temperature["bed"].Actual,
+	}
+	if status, err := o.Status(ctx); err == nil && status.State == StatePrinting {
+		telemetry.ProgressPct = status.ProgressPct
+	}
+	return telemetry, nil
+}
+
+func (o *octoPrint) Close() error {
+	o.client.CloseIdleConnections()
+	return nil
+}
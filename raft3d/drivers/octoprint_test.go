@@ -0,0 +1,86 @@
+package drivers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestOctoPrint(t *testing.T) {
+	var state, file string
+	var completion float64
+	var started, command string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Api-Key") != "k" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		switch {
+		case r.Method == http.MethodGet && r.URL.Path == "/api/job":
+			fmt.Fprintf(w, `{"state":%q,"job":{"file":{"path":%q}},"progress":{"completion":%g}}`, state, file, completion)
+		case r.Method == http.MethodGet && r.URL.Path == "/api/printer":
+			fmt.Fprint(w, `{"temperature":{"tool0":{"actual":214.8},"bed":{"actual":60.1}}}`)
+		case r.Method == http.MethodPost:
+			var body map[string]interface{}
+			json.NewDecoder(r.Body).Decode(&body)
+			started, command = r.URL.Path, fmt.Sprint(body["command"])
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+	keyFile := filepath.Join(t.TempDir(), "octoprint.key")
+	os.WriteFile(keyFile, []byte("k\n"), 0600)
+
+	if _, err := Open(Config{Driver: "octoprint", Address: "octopi.local"}); err == nil {
+		t.Fatal("opened an address without a scheme")
+	}
+	driver, err := Open(Config{Driver: "octoprint", Address: srv.URL + "/", APIKeyRef: keyFile})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer driver.Close()
+	ctx := context.Background()
+
+	tests := []struct {
+		state, file string
+		completion  float64
+		want        string
+	}{
+		{"Operational", "", 0, StateIdle},
+		{"Operational", "part.gcode", 100, StateDone},
+		{"Printing from SD", "part.gcode", 40, StatePrinting},
+		{"Pausing", "part.gcode", 40, StatePaused},
+		{"Offline after error", "", 0, StateError},
+		{"Offline", "", 0, StateOffline},
+	}
+	for _, tt := range tests {
+		state, file, completion = tt.state, tt.file, tt.completion
+		if status, err := driver.Status(ctx); err != nil || status.State != tt.want {
+			t.Errorf("%s: state %q (%v), want %q", tt.state, status.State, err, tt.want)
+		}
+	}
+
+	state, file, completion = "Printing", "part.gcode", 40
+	if err := driver.StartJob(ctx, Job{ID: "j1", FilePath: "other.gcode"}); !errors.Is(err, ErrBusy) {
+		t.Fatalf("StartJob while printing = %v", err)
+	}
+	if telemetry, err := driver.Telemetry(ctx); err != nil || *telemetry.NozzleTempC != 214.8 || *telemetry.BedTempC != 60.1 || *telemetry.ProgressPct != 40 {
+		t.Fatalf("telemetry %+v %v", telemetry, err)
+	}
+	if err := driver.Cancel(ctx); err != nil || started != "/api/job" || command != "cancel" {
+		t.Fatalf("Cancel = %v, posted %s %s", err, command, started)
+	}
+
+	state = "Operational"
+	if err := driver.StartJob(ctx, Job{ID: "j2", FilePath: "/parts/bracket.gcode"}); err != nil || started != "/api/files/local/parts/bracket.gcode" || command != "select" {
+		t.Fatalf("StartJob = %v, posted %s %s", err, command, started)
+	}
+}
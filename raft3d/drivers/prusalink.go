@@ -0,0 +1,164 @@
+package drivers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+func init() {
+	Register("prusalink", openPrusaLink)
+}
+
+// prusaLink drives a Prusa printer through the PrusaLink API it serves on the
+// local network, which is also what Prusa Connect talks to. Job files are
+// paths that start with the storage they are on, such as usb/part.bgcode.
+type prusaLink struct {
+	base   string
+	apiKey string
+	client *http.Client
+}
+
+func openPrusaLink(config Config) (PrinterDriver, error) {
+	base, err := url.Parse(config.Address)
+	if err != nil || (base.Scheme != "http" && base.Scheme != "https") || base.Host == "" {
+		return nil, fmt.Errorf("prusalink address %q must be an http or https URL", config.Address)
+	}
+	apiKey, err := config.apiKey()
+	if err != nil {
+		return nil, err
+	}
+	return &prusaLink{
+		base:   strings.TrimSuffix(config.Address, "/"),
+		apiKey: apiKey,
+		client: &http.Client{Timeout: 10 * time.Second},
+	}, nil
+}
+
+// call sends a request to the API and decodes a JSON response into out,
+// unless it is nil
+func (p *prusaLink) call(ctx context.Context, method, path string, out interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, method, p.base+path, nil)
+	if err != nil {
+		return err
+	}
+	if p.apiKey != "" {
+		req.Header.Set("X-Api-Key", p.apiKey)
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("%s %s: %s: %s", method, path, resp.Status, strings.TrimSpace(string(msg)))
+	}
+	if out == nil || resp.StatusCode == http.StatusNoContent {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// prusaLinkStatus is the response of GET /api/v1/status
+type prusaLinkStatus struct {
+	Printer struct {
+		State      string   `json:"state"`
+		TempNozzle *float64 `json:"temp_nozzle"`
+		TempBed    *float64 `json:"temp_bed"`
+	} `json:"printer"`
+	Job *struct {
+		ID       int      `json:"id"`
+		Progress *float64 `json:"progress"`
+	} `json:"job"`
+}
+
+func (p *prusaLink) status(ctx context.Context) (prusaLinkStatus, error) {
+	var status prusaLinkStatus
+	err := p.call(ctx, http.MethodGet, "/api/v1/status", &status)
+	return status, err
+}
+
+func (p *prusaLink) StartJob(ctx context.Context, job Job) error {
+	status, err := p.Status(ctx)
+	if err != nil {
+		return err
+	}
+	if status.State == StatePrinting || status.State == StatePaused {
+		return ErrBusy
+	}
+	var segments []string
+	for _, segment := range strings.Split(strings.TrimPrefix(job.FilePath, "/"), "/") {
+		segments = append(segments, url.PathEscape(segment))
+	}
+	return p.call(ctx, http.MethodPost, "/api/v1/files/"+strings.Join(segments, "/"), nil)
+}
+
+func (p *prusaLink) Cancel(ctx context.Context) error {
+	status, err := p.status(ctx)
+	if err != nil {
+		return err
+	}
+	if status.Job == nil {
+		return nil
+	}
+	return p.call(ctx, http.MethodDelete, fmt.Sprintf("/api/v1/job/%d", status.Job.ID), nil)
+}
+
+func (p *prusaLink) Status(ctx context.Context) (Status, error) {
+	raw, err := p.status(ctx)
+	if err != nil {
+		return Status{}, err
+	}
+
+	var status Status
+	if raw.Job != nil {
+		status.ProgressPct = raw.Job.Progress
+	}
+	switch raw.Printer.State {
+	case "PRINTING", "BUSY":
+		status.State = StatePrinting
+	case "PAUSED":
+		status.State = StatePaused
+	case "ATTENTION":
+		status.State = StatePaused
+		status.Message = "printer needs attention"
+	case "FINISHED":
+		status.State = StateDone
+	case "STOPPED":
+		status.State = StateIdle
+		status.Message = "print was stopped on the printer"
+	case "ERROR":
+		status.State = StateError
+		status.Message = "printer reported an error"
+	case "IDLE", "READY":
+		status.State = StateIdle
+	default:
+		status.State = StateOffline
+	}
+	return status, nil
+}
+
+func (p *prusaLink) Telemetry(ctx context.Context) (Telemetry, error) {
+	raw, err := p.status(ctx)
+	if err != nil {
+		return Telemetry{}, err
+	}
+	telemetry := Telemetry{NozzleTempC: raw.Printer.TempNozzle, BedTempC: raw.Printer.TempBed}
+	if raw.Printer.State == "PRINTING" && raw.Job != nil {
+		telemetry.ProgressPct = raw.Job.Progress
+	}
+	return telemetry, nil
+}
+
+func (p *prusaLink) Close() error {
+	p.client.CloseIdleConnections()
+	return nil
+}
@@ -0,0 +1,54 @@
+package drivers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestPrusaLink(t *testing.T) {
+	var state string
+	var started, deleted string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodGet && r.URL.Path == "/api/v1/status":
+			fmt.Fprintf(w, `{"printer":{"state":%q,"temp_nozzle":215,"temp_bed":60},"job":{"id":7,"progress":30}}`, state)
+		case r.Method == http.MethodPost:
+			started = r.URL.Path
+			w.WriteHeader(http.StatusNoContent)
+		case r.Method == http.MethodDelete:
+			deleted = r.URL.Path
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+	driver, err := Open(Config{Driver: "prusalink", Address: srv.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	for prusa, want := range map[string]string{"IDLE": StateIdle, "PRINTING": StatePrinting, "ATTENTION": StatePaused,
+		"FINISHED": StateDone, "STOPPED": StateIdle, "ERROR": StateError} {
+		state = prusa
+		if status, err := driver.Status(ctx); err != nil || status.State != want {
+			t.Errorf("%s: state %q (%v), want %q", prusa, status.State, err, want)
+		}
+	}
+
+	state = "IDLE"
+	if err := driver.StartJob(ctx, Job{ID: "j1", FilePath: "/usb/my part.bgcode"}); err != nil || started != "/api/v1/files/usb/my part.bgcode" {
+		t.Fatalf("StartJob = %v, posted %q", err, started)
+	}
+	state = "PRINTING"
+	if telemetry, _ := driver.Telemetry(ctx); *telemetry.ProgressPct != 30 || *telemetry.NozzleTempC != 215 {
+		t.Fatalf("telemetry %+v", telemetry)
+	}
+	if err := driver.Cancel(ctx); err != nil || deleted != "/api/v1/job/7" {
+		t.Fatalf("Cancel = %v, deleted %q", err, deleted)
+	}
+}
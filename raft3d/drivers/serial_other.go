@@ -0,0 +1,14 @@
+//go:build !linux || ppc || ppc64 || ppc64le
+
+package drivers
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+)
+
+// openSerial is only implemented on Linux
+func openSerial(path string, baud int) (*os.File, error) {
+	return nil, fmt.Errorf("serial printers are not supported on %s/%s", runtime.GOOS, runtime.GOARCH)
+}
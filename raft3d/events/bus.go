@@ -0,0 +1,104 @@
+// Package events provides an in-process event bus with a bounded history of
+// recent events, used to push cluster and inventory changes to clients.
+package events
+
+import (
+	"strings"
+	"sync"
+	"time"
+)
+
+// Event is a single notification published on the bus
+type Event struct {
+	Seq  uint64      `json:"seq"`
+	Type string      `json:"type"`
+	Time time.Time   `json:"time"`
+	Data interface{} `json:"data,omitempty"`
+}
+
+// Bus fans published events out to subscribers and keeps the most recent
+// events in a ring buffer so late clients can catch up
+type Bus struct {
+	mutex sync.RWMutex
+	seq   uint64
+	ring  []Event
+	next  int
+	full  bool
+	subs  map[chan Event]struct{}
+}
+
+// NewBus creates a bus that remembers the last capacity events
+func NewBus(capacity int) *Bus {
+	if capacity <= 0 {
+		capacity = 1
+	}
+	return &Bus{
+		ring: make([]Event, capacity),
+		subs: make(map[chan Event]struct{}),
+	}
+}
+
+// Publish records an event and delivers it to every subscriber. Slow
+// subscribers miss events rather than blocking the publisher.
+func (b *Bus) Publish(typ string, data interface{}) Event {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	b.seq++
+	event := Event{Seq: b.seq, Type: typ, Time: time.Now().UTC(), Data: data}
+
+	b.ring[b.next] = event
+	b.next = (b.next + 1) % len(b.ring)
+	if b.next == 0 {
+		b.full = true
+	}
+
+	for ch := range b.subs {
+		select {
+		case ch <- event:
+		default:
+		}
+	}
+	return event
+}
+
+// Recent returns remembered events newer than since whose type starts with
+// prefix, oldest first
+func (b *Bus) Recent(prefix string, since uint64) []Event {
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
+
+	var ordered []Event
+	if b.full {
+		ordered = append(ordered, b.ring[b.next:]...)
+	}
+	ordered = append(ordered, b.ring[:b.next]...)
+
+	result := []Event{}
+	for _, event := range ordered {
+		if event.Seq > since && strings.HasPrefix(event.Type, prefix) {
+			result = append(result, event)
+		}
+	}
+	return result
+}
+
+// Subscribe returns a channel receiving every future event and a function
+// that ends the subscription
+func (b *Bus) Subscribe(buffer int) (<-chan Event, func()) {
+	ch := make(chan Event, buffer)
+
+	b.mutex.Lock()
+	b.subs[ch] = struct{}{}
+	b.mutex.Unlock()
+
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			b.mutex.Lock()
+			delete(b.subs, ch)
+			b.mutex.Unlock()
+			close(ch)
+		})
+	}
+}
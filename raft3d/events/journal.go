@@ -0,0 +1,52 @@
+package events
+
+import (
+	"context"
+	"log"
+)
+
+// Journal is replicated storage for events and per-subscriber delivery
+// cursors. Events recorded in it keep their sequence numbers across restarts
+// and leader changes, so delivery can resume where the previous leader
+// stopped.
+type Journal interface {
+	// IsLeader reports whether this node appends events and delivers them
+	IsLeader() bool
+
+	// AppendEvent records an event and returns the sequence number it was
+	// given, which is greater than that of every event recorded before it
+	AppendEvent(event Event) (uint64, error)
+
+	// EventsAfter returns up to limit recorded events numbered above seq,
+	// oldest first
+	EventsAfter(seq uint64, limit int) ([]Event, error)
+
+	// WaitForEvents blocks until an event numbered above seq may have been
+	// recorded, or ctx ends
+	WaitForEvents(ctx context.Context, seq uint64) error
+
+	// DeliveryCursor returns the last event delivered to subscriber. For a
+	// subscriber without a cursor it returns the newest recorded event and
+	// false, so a new subscriber doesn't receive the backlog.
+	DeliveryCursor(subscriber string) (uint64, bool, error)
+
+	// SetDeliveryCursor records that subscriber has received every event up
+	// to seq
+	SetDeliveryCursor(subscriber string, seq uint64) error
+}
+
+// Record appends every event published on bus while this node leads to
+// journal. Events published on followers are only delivered locally.
+func Record(bus *Bus, journal Journal) {
+	ch, _ := bus.Subscribe(256)
+	go func() {
+		for event := range ch {
+			if !journal.IsLeader() {
+				continue
+			}
+			if _, err := journal.AppendEvent(event); err != nil {
+				log.Printf("Failed to record event %d (%s): %s", event.Seq, event.Type, err)
+			}
+		}
+	}()
+}
@@ -0,0 +1,161 @@
+package events
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+)
+
+// webhookAttempts is how many times delivery of a single event is tried
+// before the webhook backs off
+const webhookAttempts = 3
+
+// webhookRetryDelay is how long a webhook waits after an event couldn't be
+// delivered before trying it again
+const webhookRetryDelay = 10 * time.Second
+
+// webhookBatch is how many recorded events are read at a time
+const webhookBatch = 100
+
+// Webhook posts every event recorded in a journal to a URL as JSON. Only the
+// leader delivers, and the cursor it advances after each delivery is
+// replicated, so a new leader resumes without replaying or skipping events.
+// An event can still be posted twice if the leader fails between posting it
+// and recording the cursor; receivers can use its seq to ignore repeats.
+type Webhook struct {
+	URL     string
+	journal Journal
+	client  *http.Client
+	ctx     context.Context
+	stop    context.CancelFunc
+}
+
+// StartWebhook delivers events recorded in journal to url until Stop is called
+func StartWebhook(journal Journal, url string) *Webhook {
+	ctx, stop := context.WithCancel(context.Background())
+	w := &Webhook{
+		URL:     url,
+		journal: journal,
+		client:  &http.Client{Timeout: 5 * time.Second},
+		ctx:     ctx,
+		stop:    stop,
+	}
+	go w.run()
+	return w
+}
+
+// Stop ends delivery
+func (w *Webhook) Stop() {
+	w.stop()
+}
+
+func (w *Webhook) run() {
+	for w.ctx.Err() == nil {
+		if !w.journal.IsLeader() {
+			w.sleep(time.Second)
+			continue
+		}
+		cursor, err := w.deliverPending()
+		if w.ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			log.Printf("Webhook %s: %s; retrying in %s", w.URL, err, webhookRetryDelay)
+			w.sleep(webhookRetryDelay)
+			continue
+		}
+
+		ctx, cancel := context.WithTimeout(w.ctx, 30*time.Second)
+		w.journal.WaitForEvents(ctx, cursor)
+		cancel()
+	}
+}
+
+// deliverPending posts the events recorded after the webhook's cursor,
+// advancing the cursor after each, and returns the last event delivered
+func (w *Webhook) deliverPending() (uint64, error) {
+	cursor, ok, err := w.journal.DeliveryCursor(w.URL)
+	if err != nil {
+		return 0, fmt.Errorf("reading delivery cursor: %w", err)
+	}
+	if !ok {
+		if err := w.journal.SetDeliveryCursor(w.URL, cursor); err != nil {
+			return 0, fmt.Errorf("recording delivery cursor: %w", err)
+		}
+	}
+
+	for w.ctx.Err() == nil && w.journal.IsLeader() {
+		pending, err := w.journal.EventsAfter(cursor, webhookBatch)
+		if err != nil {
+			return cursor, fmt.Errorf("reading events: %w", err)
+		}
+		if len(pending) == 0 {
+			break
+		}
+		for _, event := range pending {
+			if err := w.deliver(event); err != nil {
+				return cursor, fmt.Errorf("delivering event %d (%s): %w", event.Seq, event.Type, err)
+			}
+			if err := w.journal.SetDeliveryCursor(w.URL, event.Seq); err != nil {
+				return cursor, fmt.Errorf("recording delivery of event %d: %w", event.Seq, err)
+			}
+			cursor = event.Seq
+		}
+	}
+	return cursor, nil
+}
+
+// sleep waits for d, or until the webhook is stopped, in which case it
+// returns false
+func (w *Webhook) sleep(d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-w.ctx.Done():
+		return false
+	}
+}
+
+// deliver posts an event, retrying with backoff on failure
+func (w *Webhook) deliver(event Event) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	backoff := 500 * time.Millisecond
+	for attempt := 1; ; attempt++ {
+		err = w.post(body)
+		if err == nil || attempt == webhookAttempts {
+			return err
+		}
+		if !w.sleep(backoff) {
+			return w.ctx.Err()
+		}
+		backoff *= 2
+	}
+}
+
+func (w *Webhook) post(body []byte) error {
+	req, err := http.NewRequestWithContext(w.ctx, http.MethodPost, w.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return nil
+}
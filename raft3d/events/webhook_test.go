@@ -0,0 +1,37 @@
+package events
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestStopInterruptsDeliveryBackoff(t *testing.T) {
+	failed := make(chan struct{}, webhookAttempts)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		failed <- struct{}{}
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+
+	ctx, stop := context.WithCancel(context.Background())
+	w := &Webhook{URL: srv.URL, client: srv.Client(), ctx: ctx, stop: stop}
+
+	done := make(chan error, 1)
+	go func() { done <- w.deliver(Event{Seq: 1, Type: "test"}) }()
+
+	// Stop while the first retry is backing off
+	<-failed
+	w.Stop()
+	select {
+	case err := <-done:
+		if !errors.Is(err, context.Canceled) {
+			t.Fatalf("deliver = %v, want context.Canceled", err)
+		}
+	case <-time.After(250 * time.Millisecond):
+		t.Fatal("deliver kept backing off after Stop")
+	}
+}
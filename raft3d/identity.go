@@ -0,0 +1,45 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"raft3d/api"
+)
+
+// enabledFeatures returns the names of the features that are on, sorted
+func enabledFeatures(features map[string]bool) []string {
+	enabled := []string{}
+	for name, on := range features {
+		if on {
+			enabled = append(enabled, name)
+		}
+	}
+	sort.Strings(enabled)
+	return enabled
+}
+
+// printBanner writes what the node runs to stdout at startup, one field a
+// line, as GET /api/v1/node reports it
+func printBanner(identity api.NodeIdentity) {
+	features := strings.Join(identity.Features, ", ")
+	if features == "" {
+		features = "none"
+	}
+	for _, field := range [][2]string{
+		{"node", identity.NodeID},
+		{"version", identity.Build.String()},
+		{"profile", identity.Profile},
+		{"raft", identity.RaftAddr},
+		{"http", identity.HTTPAddr},
+		{"data", identity.Storage.DataDir},
+		{"log archive", identity.Storage.LogArchive},
+		{"sql projection", identity.Storage.SQLProjection},
+		{"features", features},
+	} {
+		if field[1] != "" {
+			fmt.Printf("  %-15s %s\n", field[0]+":", field[1])
+		}
+	}
+}
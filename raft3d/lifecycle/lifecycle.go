@@ -0,0 +1,249 @@
+// Package lifecycle starts the components of a node in dependency order and
+// stops them in reverse, each within a timeout, so nothing is torn down
+// while something that uses it is still running
+package lifecycle
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// Default time each component gets to start or stop
+const (
+	DefaultStartTimeout = 30 * time.Second
+	DefaultStopTimeout  = 10 * time.Second
+)
+
+// readyPollInterval is how often a started component's readiness is checked
+const readyPollInterval = 50 * time.Millisecond
+
+// Component is one part of the node, such as the Raft store or the HTTP
+// server. Start and Stop are both optional.
+type Component struct {
+	Name string
+
+	// DependsOn names components that must be started before this one and
+	// stopped after it
+	DependsOn []string
+
+	Start func(ctx context.Context) error
+	Stop  func(ctx context.Context) error
+
+	// Ready, when set, is polled after Start until it returns nil, and the
+	// components depending on this one start only then. Its error says what
+	// the component is still waiting for.
+	Ready func() error
+
+	// StartTimeout and StopTimeout override the manager's for this component
+	StartTimeout time.Duration
+	StopTimeout  time.Duration
+}
+
+// Worker returns a component that runs fn on a goroutine of its own until
+// it is stopped. fn must return once stop is closed.
+func Worker(name string, dependsOn []string, fn func(stop <-chan struct{})) Component {
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	return Component{
+		Name:      name,
+		DependsOn: dependsOn,
+		Start: func(context.Context) error {
+			go func() {
+				defer close(done)
+				fn(stop)
+			}()
+			return nil
+		},
+		Stop: func(ctx context.Context) error {
+			close(stop)
+			select {
+			case <-done:
+				return nil
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		},
+	}
+}
+
+// Manager starts and stops a set of components
+type Manager struct {
+	StartTimeout time.Duration // per component, DefaultStartTimeout when zero
+	StopTimeout  time.Duration // per component, DefaultStopTimeout when zero
+
+	mu         sync.Mutex
+	components []Component
+	started    []Component // in the order they were started
+}
+
+// New returns a manager with no components
+func New() *Manager {
+	return &Manager{}
+}
+
+// Add registers a component. Names must be unique.
+func (m *Manager) Add(c Component) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, existing := range m.components {
+		if existing.Name == c.Name {
+			return fmt.Errorf("component %s added twice", c.Name)
+		}
+	}
+	m.components = append(m.components, c)
+	return nil
+}
+
+// order sorts the components so each comes after its dependencies, keeping
+// the order they were added in otherwise. The caller must hold the mutex.
+func (m *Manager) order() ([]Component, error) {
+	byName := make(map[string]Component, len(m.components))
+	for _, c := range m.components {
+		byName[c.Name] = c
+	}
+	for _, c := range m.components {
+		for _, dep := range c.DependsOn {
+			if _, ok := byName[dep]; !ok {
+				return nil, fmt.Errorf("component %s depends on unknown component %s", c.Name, dep)
+			}
+		}
+	}
+
+	var ordered []Component
+	placed := make(map[string]bool, len(m.components))
+	for len(ordered) < len(m.components) {
+		progress := false
+		for _, c := range m.components {
+			if placed[c.Name] {
+				continue
+			}
+			ready := true
+			for _, dep := range c.DependsOn {
+				ready = ready && placed[dep]
+			}
+			if ready {
+				ordered = append(ordered, c)
+				placed[c.Name] = true
+				progress = true
+			}
+		}
+		if !progress {
+			return nil, errors.New("components depend on each other in a cycle")
+		}
+	}
+	return ordered, nil
+}
+
+// Start starts every component once its dependencies are ready. If one
+// fails, or isn't ready within its start timeout, those already started are
+// stopped again and the error is returned.
+func (m *Manager) Start(ctx context.Context) error {
+	m.mu.Lock()
+	ordered, err := m.order()
+	m.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	for _, c := range ordered {
+		timeout := c.StartTimeout
+		if timeout <= 0 {
+			timeout = m.StartTimeout
+		}
+		if timeout <= 0 {
+			timeout = DefaultStartTimeout
+		}
+		startCtx, cancel := context.WithTimeout(ctx, timeout)
+		err := m.startAndWait(startCtx, c)
+		cancel()
+		if err != nil {
+			if stopErr := m.Stop(context.Background()); stopErr != nil {
+				log.Printf("Failed to stop after %s did not start: %s", c.Name, stopErr)
+			}
+			return fmt.Errorf("start %s: %w", c.Name, err)
+		}
+	}
+	return nil
+}
+
+// startAndWait starts a component, records it as started so a later failure
+// stops it, and waits until it is ready
+func (m *Manager) startAndWait(ctx context.Context, c Component) error {
+	if c.Start != nil {
+		if err := c.Start(ctx); err != nil {
+			return err
+		}
+	}
+	m.mu.Lock()
+	m.started = append(m.started, c)
+	m.mu.Unlock()
+	if c.Ready == nil {
+		return nil
+	}
+
+	ticker := time.NewTicker(readyPollInterval)
+	defer ticker.Stop()
+	for {
+		err := c.Ready()
+		if err == nil {
+			return nil
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return fmt.Errorf("not ready: %w", err)
+		}
+	}
+}
+
+// Stop stops the started components in reverse order, each within its stop
+// timeout or until ctx ends, and returns every error. A component that
+// doesn't stop in time is left behind and the rest are stopped anyway.
+func (m *Manager) Stop(ctx context.Context) error {
+	m.mu.Lock()
+	started := m.started
+	m.started = nil
+	m.mu.Unlock()
+
+	var errs []error
+	for i := len(started) - 1; i >= 0; i-- {
+		c := started[i]
+		if c.Stop == nil {
+			continue
+		}
+		timeout := c.StopTimeout
+		if timeout <= 0 {
+			timeout = m.StopTimeout
+		}
+		if timeout <= 0 {
+			timeout = DefaultStopTimeout
+		}
+		if err := stopWithin(ctx, c, timeout); err != nil {
+			errs = append(errs, fmt.Errorf("stop %s: %w", c.Name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// stopWithin calls a component's Stop, giving up on it once the timeout
+// passes even if Stop ignores its context
+func stopWithin(ctx context.Context, c Component, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	done := make(chan error, 1)
+	go func() {
+		done <- c.Stop(ctx)
+	}()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return fmt.Errorf("did not stop within %s: %w", timeout, ctx.Err())
+	}
+}
@@ -0,0 +1,134 @@
+package lifecycle
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestStartsInDependencyOrderAndStopsInReverse(t *testing.T) {
+	var mu sync.Mutex
+	var calls []string
+	record := func(call string) func(context.Context) error {
+		return func(context.Context) error {
+			mu.Lock()
+			defer mu.Unlock()
+			calls = append(calls, call)
+			return nil
+		}
+	}
+
+	m := New()
+	m.Add(Component{Name: "http", DependsOn: []string{"store"}, Start: record("start http"), Stop: record("stop http")})
+	m.Add(Component{Name: "watcher", Start: record("start watcher"), Stop: record("stop watcher")})
+	m.Add(Component{Name: "store", Stop: record("stop store")})
+	if err := m.Add(Component{Name: "store"}); err == nil {
+		t.Fatal("duplicate name accepted")
+	}
+
+	if err := m.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Stop(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"start watcher", "start http", "stop http", "stop store", "stop watcher"}
+	if !reflect.DeepEqual(calls, want) {
+		t.Fatalf("calls = %v, want %v", calls, want)
+	}
+
+	// Stopping twice does nothing
+	if err := m.Stop(context.Background()); err != nil || len(calls) != len(want) {
+		t.Fatalf("second stop: %v %v", err, calls)
+	}
+}
+
+func TestFailedStartStopsWhatStarted(t *testing.T) {
+	stopped := false
+	m := New()
+	m.Add(Component{Name: "store", Stop: func(context.Context) error { stopped = true; return nil }})
+	m.Add(Component{Name: "http", DependsOn: []string{"store"}, Start: func(context.Context) error { return errors.New("port in use") }})
+	if err := m.Start(context.Background()); err == nil || !strings.Contains(err.Error(), "start http: port in use") {
+		t.Fatalf("err = %v", err)
+	}
+	if !stopped {
+		t.Fatal("store left running")
+	}
+
+	cyclic := New()
+	cyclic.Add(Component{Name: "a", DependsOn: []string{"b"}})
+	cyclic.Add(Component{Name: "b", DependsOn: []string{"a"}})
+	if err := cyclic.Start(context.Background()); err == nil {
+		t.Fatal("cycle accepted")
+	}
+}
+
+func TestStopTimeout(t *testing.T) {
+	m := &Manager{StopTimeout: 20 * time.Millisecond}
+	m.Add(Component{Name: "stuck", Stop: func(context.Context) error { select {} }})
+	m.Add(Component{Name: "worker", DependsOn: []string{"stuck"}})
+	stopped := make(chan struct{})
+	m.Add(Worker("loop", nil, func(stop <-chan struct{}) {
+		<-stop
+		close(stopped)
+	}))
+	if err := m.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	err := m.Stop(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "stop stuck: did not stop within") {
+		t.Fatalf("err = %v", err)
+	}
+	select {
+	case <-stopped:
+	default:
+		t.Fatal("worker not stopped")
+	}
+}
+
+func TestDependentsWaitUntilReady(t *testing.T) {
+	var mu sync.Mutex
+	checks := 0
+	joined := false
+	m := New()
+	m.Add(Component{Name: "http", Ready: func() error {
+		mu.Lock()
+		defer mu.Unlock()
+		if checks++; checks < 3 {
+			return errors.New("not serving yet")
+		}
+		return nil
+	}})
+	m.Add(Component{Name: "join", DependsOn: []string{"http"}, Start: func(context.Context) error {
+		mu.Lock()
+		defer mu.Unlock()
+		if checks < 3 {
+			t.Error("joined before http was ready")
+		}
+		joined = true
+		return nil
+	}})
+	if err := m.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if !joined {
+		t.Fatal("join never started")
+	}
+
+	stopped := false
+	never := &Manager{StartTimeout: 100 * time.Millisecond}
+	never.Add(Component{Name: "store", Stop: func(context.Context) error { stopped = true; return nil },
+		Ready: func() error { return errors.New("transport not listening") }})
+	err := never.Start(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "start store: not ready: transport not listening") {
+		t.Fatalf("err = %v", err)
+	}
+	if !stopped {
+		t.Fatal("store that never became ready left running")
+	}
+}
@@ -1,32 +1,147 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
+	"io/fs"
 	"log"
 	"os"
 	"os/signal"
 	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
 	"time"
 
 	"raft3d/api"
+	"raft3d/drivers"
+	"raft3d/events"
+	"raft3d/lifecycle"
 	"raft3d/raft"
+	"raft3d/secrets"
+	"raft3d/service"
+	"raft3d/version"
 )
 
 func main() {
+	// Offline maintenance subcommands, the proxy and dev mode
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "verify":
+			os.Exit(runVerify(os.Args[2:]))
+		case "restore":
+			os.Exit(runRestore(os.Args[2:]))
+		case "backup":
+			os.Exit(runBackup(os.Args[2:]))
+		case "replay":
+			os.Exit(runReplay(os.Args[2:]))
+		case "recover":
+			os.Exit(runRecover(os.Args[2:]))
+		case "proxy":
+			os.Exit(runProxy(os.Args[2:]))
+		case "state-hash":
+			os.Exit(runStateHash(os.Args[2:]))
+		case "bench":
+			os.Exit(runBench(os.Args[2:]))
+		case "dev":
+			os.Exit(runDev(os.Args[2:]))
+		}
+	}
+	started := time.Now()
+
 	var (
 		nodeID    = flag.String("id", "", "Node ID")
-		httpAddr  = flag.String("http", "127.0.0.1:8000", "HTTP server address")
+		httpAddr  = flag.String("http", "127.0.0.1:8000", "HTTP server address, or unix:///path for a Unix domain socket")
 		raftAddr  = flag.String("raft", "127.0.0.1:9000", "Raft server address")
 		joinAddr  = flag.String("join", "", "Address of node to join")
 		dataDir   = flag.String("data", "data", "Directory for data storage")
 		bootstrap = flag.Bool("bootstrap", false, "Bootstrap the cluster")
+
+		raftAdvertise  = flag.String("raft-advertise-addr", "", "Raft address peers reach this node at, when -raft binds e.g. 0.0.0.0 (default -raft)")
+		socketMode     = flag.String("http-socket-mode", "0660", "Permissions of the socket file when -http is unix:///path")
+		socketGroup    = flag.String("http-socket-group", "", "Group name or ID owning the socket file when -http is unix:///path")
+		httpAdvertise  = flag.String("http-advertise-addr", "", "HTTP address other nodes and clients reach this node at (default -http)")
+		backupTarget   = flag.String("backup-target", "", "Directory, file:// URL, or s3:// or gs:// bucket and prefix for periodic backups")
+		backupInterval = flag.Duration("backup-interval", time.Hour, "Interval between backups taken by the leader (0 disables)")
+		backupRetain   = flag.Int("backup-retain", 24, "Number of backups to keep (0 keeps all)")
+		webhooks       = flag.String("webhooks", "", "Comma-separated URLs that receive every event as a JSON POST")
+		notifyHooks    = flag.String("notify-webhooks", "", "Comma-separated URLs the outbox delivers every notification to")
+		notifyConfig   = flag.String("notifications", "", "JSON file or vault:<path>#<field> of notification channels (webhook, slack, smtp) and the types each receives")
+		printerDrivers = flag.String("printer-drivers", "", "JSON file or vault:<path>#<field> mapping IDs of printers attached to this node to their driver (marlin, octoprint, moonraker, prusalink, bambu); their jobs are run here")
+		driverAPIKey   = flag.String("driver-api-key", "", "File or vault:<path>#<field> holding the API key the printer drivers report to the leader with")
+		joinAPIKey     = flag.String("join-api-key", "", "File or vault:<path>#<field> holding the admin API key sent with -join when the cluster requires authentication and this node has no client certificate")
+		enableChaos    = flag.Bool("enable-chaos", false, "Enable fault injection endpoints under /api/v1/chaos (never in production)")
+		logArchive     = flag.String("log-archive", "", "Directory to ship every committed command to for point-in-time recovery")
+		logShipTarget  = flag.String("log-archive-target", "", "s3:// or gs:// bucket and prefix, or directory, the -log-archive is copied to off-site; each node ships under <target>/<id>")
+		logShipEvery   = flag.Duration("log-ship-interval", 10*time.Second, "Interval between copies of the log archive to -log-archive-target")
+		historyEntries = flag.Int("history-entries", 10000, "Log entries back that ?at_index= reads of printers, filaments and jobs can go; versions are kept in memory (negative disables)")
+		sqlProjection  = flag.String("sql-projection", "", "SQLite database file the state is mirrored into for POST /api/v1/query (needs a build with -tags sqlite); rebuilt on every start")
+		plugins        = flag.String("plugins", "", "Comma-separated Go plugin files (-buildmode=plugin) whose extensions validate commands or run after they are applied")
+		cdcExport      = flag.String("cdc-export", "", "nats://host:port/subject JetStream subject or kafka://host:port/topic Kafka topic the leader publishes every applied command to")
+		snapshotRetain = flag.Int("snapshot-retain", 3, "Number of Raft snapshots to keep on disk")
+		trailingLogs   = flag.Uint64("trailing-logs", 10240, "Log entries kept behind each snapshot for slow followers")
+		snapThreshold  = flag.Uint64("snapshot-threshold", 8192, "New log entries that trigger an automatic snapshot")
+		applyTimeout   = flag.Duration("apply-timeout", 10*time.Second, "How long a write waits to be replicated before failing with 504 replication_timeout")
+		maxFSMPending  = flag.Int("max-fsm-pending", 64, "Committed entries waiting for the state machine beyond which writes get 429 (negative disables)")
+		determinism    = flag.Duration("determinism-audit", 0, "Debug mode: digest the state after every applied entry and compare digests with the other nodes this often, logging divergence (0 disables)")
+		debugAddr      = flag.String("debug-addr", "", "Loopback address, such as 127.0.0.1:6060, serving /debug/pprof and /debug/vars without authentication")
+		fenceMembers   = flag.Bool("fence-membership-changes", false, "Hold writes back while the leader adds or removes a server, until the new configuration is committed")
+		quorumTimeout  = flag.Duration("quorum-loss-timeout", 5*time.Second, "Time without leader contact before the node turns read-only")
+		apiKeysFile    = flag.String("api-keys", "", "JSON file or vault:<path>#<field> of API keys; when set every /api/ request must authenticate")
+		artifactStore  = flag.String("artifact-store", "", "Directory, file:// URL, or s3:// or gs:// bucket and prefix that print job artifacts are stored in; share it between nodes")
+		slicerURL      = flag.String("slicer-url", "", "URL of a slicing service that turns uploaded models into G-code; needs -artifact-store")
+		jobArchive     = flag.String("job-archive", "", "Directory, file:// URL, or s3:// or gs:// bucket and prefix that print jobs are archived to before the retention policy removes them")
+		configFile     = flag.String("config", "", "JSON file of flag settings; reloadable ones are re-read on SIGHUP or POST /api/v1/admin/reload")
+		logLevel       = flag.String("log-level", "info", "Raft log level: trace, debug, info, warn or error")
+		heartbeatTTL   = flag.Duration("printer-heartbeat-timeout", 0, "Mark printers Offline when no heartbeat arrives within this time (0 disables)")
+		encryptionKey  = flag.String("encryption-key-file", "", "File holding the base64 AES key that encrypts snapshots, the log, backups and archives at rest (default $"+raft.EncryptionKeyEnv+")")
+		dryMaxAge      = flag.Duration("filament-dry-max-age", 0, "Reject jobs on hygroscopic filament last dried longer ago than this (0 disables)")
+		v1Sunset       = flag.String("v1-sunset", "", "Date (YYYY-MM-DD) /api/v1/ responses announce in their Sunset header (default a year after v2 was released)")
+		idFormat       = flag.String("id-format", raft.IDFormatUUID, "IDs generated for printers, filaments and jobs posted without one: uuid or sequential")
+		profileName    = flag.String("profile", "default", "Resource profile: default, or embedded for Raspberry Pi class boards")
+		tlsCert        = flag.String("tls-cert", "", "PEM certificate file or vault:<path>#<field>; enables TLS for HTTP and Raft")
+		tlsKey         = flag.String("tls-key", "", "PEM private key file or vault:<path>#<field> for -tls-cert")
+		tlsCA          = flag.String("tls-ca", "", "PEM CA bundle file or vault:<path>#<field> that peer certificates must chain to")
+		mgmtAllowlist  = flag.String("management-allowlist", "", "Comma-separated CIDRs allowed to call /join, /leave, /cluster/* and the chaos endpoints (default any)")
+		cameraNets     = flag.String("camera-networks", api.DefaultCameraNetworks, "Comma-separated CIDRs printer camera URLs may reach; empty disables cameras")
+		corsOrigins    = flag.String("cors-origins", "", "Comma-separated origins browsers may call the API from, * for any, or https://*.example.com for subdomains")
+		corsMethods    = flag.String("cors-methods", "GET,POST,PUT,PATCH,DELETE", "Comma-separated methods allowed in cross-origin requests")
+		corsHeaders    = flag.String("cors-headers", "Authorization,Content-Type,X-API-Key", "Comma-separated request headers allowed in cross-origin requests")
+		corsCreds      = flag.Bool("cors-credentials", false, "Allow cross-origin requests to carry cookies and Authorization headers")
+		corsMaxAge     = flag.Duration("cors-max-age", 10*time.Minute, "How long browsers may cache a CORS preflight response")
+		secretsRefresh = flag.Duration("secrets-refresh", time.Minute, "How often TLS material and API keys are re-read for rotation (0 disables)")
+		allowSkew      = flag.Bool("allow-version-skew", false, "Admit joining nodes more than one protocol version away, with a warning, instead of refusing them")
+		joinTimeout    = flag.Duration("join-timeout", time.Minute, "How long to keep retrying -join while the target can't be reached or has no leader")
+		stopTimeout    = flag.Duration("shutdown-timeout", lifecycle.DefaultStopTimeout, "How long each component, such as the HTTP server with its requests in flight, gets to stop on shutdown")
 	)
 	flag.Parse()
 
+	cmdline := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) { cmdline[f.Name] = true })
+	if *configFile != "" {
+		if err := applyConfigFile(*configFile); err != nil {
+			log.Fatalf("Failed to load config: %s", err)
+		}
+	}
+
+	prof, err := applyProfile(*profileName)
+	if err != nil {
+		log.Fatal(err)
+	}
+
 	if *nodeID == "" {
 		log.Fatal("Node ID is required")
 	}
+	for name, addr := range map[string]string{"http": *httpAddr, "raft": *raftAddr, "join": *joinAddr,
+		"raft-advertise-addr": *raftAdvertise, "http-advertise-addr": *httpAdvertise} {
+		if _, unix := api.SocketPath(addr); addr == "" || (unix && name == "http") {
+			continue
+		}
+		if err := raft.ValidateAddr(addr); err != nil {
+			log.Fatalf("Invalid -%s: %s", name, err)
+		}
+	}
 
 	// Ensure data directory exists
 	nodeDataDir := filepath.Join(*dataDir, *nodeID)
@@ -34,40 +149,421 @@ func main() {
 		log.Fatalf("Failed to create data directory: %s", err)
 	}
 
+	// Create the event bus shared by the store and the API
+	bus := events.NewBus(prof.eventHistory)
+	reload := newReloader(*configFile, cmdline)
+
+	var chaos *raft.Chaos
+	if *enableChaos && !prof.allowChaos {
+		log.Fatalf("Chaos mode is not available with the %s profile", *profileName)
+	}
+	if *enableChaos {
+		log.Println("WARNING: chaos mode enabled, fault injection endpoints are exposed")
+		chaos = raft.NewChaos()
+	}
+
+	key, err := raft.LoadEncryptionKey(*encryptionKey)
+	if err != nil {
+		log.Fatalf("Failed to load encryption key: %s", err)
+	}
+	if key != nil {
+		log.Println("Encrypting snapshots and the Raft log at rest")
+	}
+
+	var (
+		certs       *secrets.Certificates
+		tlsProvider raft.TLSProvider
+	)
+	if *tlsCert != "" || *tlsKey != "" {
+		if certs, err = secrets.LoadCertificates(*tlsCert, *tlsKey, *tlsCA); err != nil {
+			log.Fatalf("Failed to load TLS certificates: %s", err)
+		}
+		tlsProvider = certs
+	}
+
+	if *plugins != "" {
+		for _, path := range strings.Split(*plugins, ",") {
+			if err := raft.LoadPlugin(strings.TrimSpace(path)); err != nil {
+				log.Fatalf("Failed to load plugin: %s", err)
+			}
+			log.Printf("Loaded plugin %s", path)
+		}
+	}
+
+	advertisedRaft, advertisedHTTP := *raftAddr, *httpAddr
+	if *raftAdvertise != "" {
+		advertisedRaft = *raftAdvertise
+	}
+	if *httpAdvertise != "" {
+		advertisedHTTP = *httpAdvertise
+	}
+
+	// Report readiness and take stop requests from systemd or the Windows
+	// service manager when one runs the node
+	host, err := service.Detect("raft3d")
+	if err != nil {
+		log.Fatalf("Failed to connect to the service manager: %s", err)
+	}
+
 	// Initialize the Raft store
-	raftStore, err := raft.NewRaftStore(*nodeID, *raftAddr, nodeDataDir, *bootstrap)
+	raftStore, err := raft.NewRaftStore(raft.StoreConfig{
+		NodeID:        *nodeID,
+		RaftAddr:      *raftAddr,
+		HTTPAddr:      advertisedHTTP,
+		DataDir:       nodeDataDir,
+		Bootstrap:     *bootstrap,
+		LogArchiveDir: *logArchive,
+		SQLProjection: *sqlProjection,
+		Chaos:         chaos,
+		Events:        bus,
+
+		QuorumLossThreshold:    *quorumTimeout,
+		ApplyTimeout:           *applyTimeout,
+		MaxFSMPending:          *maxFSMPending,
+		FenceMembershipChanges: *fenceMembers,
+		DeterminismAudit:       *determinism > 0,
+		HistoryEntries:         *historyEntries,
+		SnapshotRetain:         *snapshotRetain,
+		TrailingLogs:           *trailingLogs,
+		SnapshotThreshold:      *snapThreshold,
+		Tuning:                 prof.tuning,
+		LogLevel:               *logLevel,
+		IDFormat:               *idFormat,
+		EncryptionKey:          key,
+		TLS:                    tlsProvider,
+		RaftAdvertiseAddr:      *raftAdvertise,
+	})
 	if err != nil {
 		log.Fatalf("Failed to create Raft store: %s", err)
 	}
 
+	// Configure periodic backups
+	if *backupTarget != "" {
+		target, err := raft.NewBackupTarget(*backupTarget)
+		if err != nil {
+			log.Fatalf("Failed to open backup target: %s", err)
+		}
+		raftStore.EnableBackups(raft.BackupConfig{
+			Target:   target,
+			Interval: *backupInterval,
+			Retain:   *backupRetain,
+		})
+	}
+
+	// Ship the log archive off-site
+	if *logShipTarget != "" {
+		target, err := raft.NewBackupTarget(strings.TrimSuffix(*logShipTarget, "/") + "/" + *nodeID)
+		if err != nil {
+			log.Fatalf("Failed to open log archive target: %s", err)
+		}
+		if err := raftStore.EnableLogShipping(raft.LogShippingConfig{Target: target, Interval: *logShipEvery}); err != nil {
+			log.Fatalf("Failed to enable log shipping: %s", err)
+		}
+	}
+
+	// Publish applied commands to Kafka or NATS
+	if *cdcExport != "" {
+		publisher, err := raft.NewChangePublisher(*cdcExport)
+		if err != nil {
+			log.Fatalf("Invalid -cdc-export: %s", err)
+		}
+		if err := raftStore.EnableCDC(raft.CDCConfig{Publisher: publisher}); err != nil {
+			log.Fatalf("Failed to enable change data capture: %s", err)
+		}
+	}
+
 	// Start the HTTP server
 	httpServer := api.NewServer(*httpAddr, raftStore)
-	if err := httpServer.Start(); err != nil {
-		log.Fatalf("Failed to start HTTP server: %s", err)
+	httpServer.AdvertiseAddr = *httpAdvertise
+	if _, unix := api.SocketPath(*httpAddr); unix {
+		mode, err := strconv.ParseUint(*socketMode, 8, 32)
+		if err != nil || mode > 0777 {
+			log.Fatalf("Invalid -http-socket-mode %q: want octal permissions such as 0660", *socketMode)
+		}
+		httpServer.SetSocketPermissions(fs.FileMode(mode), *socketGroup)
+	}
+	httpServer.EnableEvents(bus)
+	reload.store, reload.server = raftStore, httpServer
+	events.Record(bus, raftStore)
+	reload.setWebhooks(*webhooks)
+	httpServer.EnableReload(reload.reload)
+	if *heartbeatTTL > 0 {
+		httpServer.EnableHeartbeatMonitor(*heartbeatTTL)
+	}
+	if *dryMaxAge > 0 {
+		httpServer.EnableDryingCheck(*dryMaxAge)
 	}
+	if *v1Sunset != "" {
+		sunset, err := time.Parse("2006-01-02", *v1Sunset)
+		if err != nil {
+			log.Fatalf("Invalid -v1-sunset %q: want a date such as 2027-10-16", *v1Sunset)
+		}
+		httpServer.SetV1Sunset(sunset)
+	}
+	if *determinism > 0 {
+		httpServer.EnableDeterminismAudit(*determinism)
+	}
+	if *debugAddr != "" {
+		httpServer.EnableDebugListener(*debugAddr)
+	}
+	if *corsOrigins != "" {
+		httpServer.EnableCORS(api.CORSConfig{
+			AllowedOrigins:   splitList(*corsOrigins),
+			AllowedMethods:   splitList(*corsMethods),
+			AllowedHeaders:   splitList(*corsHeaders),
+			AllowCredentials: *corsCreds,
+			MaxAge:           *corsMaxAge,
+		})
+	}
+	if *mgmtAllowlist != "" {
+		prefixes, err := api.ParseAllowlist(*mgmtAllowlist)
+		if err != nil {
+			log.Fatalf("Invalid management allowlist: %s", err)
+		}
+		httpServer.EnableManagementAllowlist(prefixes)
+	}
+	if *cameraNets != "" {
+		prefixes, err := api.ParseAllowlist(*cameraNets)
+		if err != nil {
+			log.Fatalf("Invalid camera networks: %s", err)
+		}
+		httpServer.EnableCameraNetworks(prefixes)
+	}
+	if *apiKeysFile != "" {
+		keys, err := api.LoadAPIKeys(*apiKeysFile)
+		if err != nil {
+			log.Fatalf("Failed to load API keys: %s", err)
+		}
+		if err := httpServer.EnableAuth(keys); err != nil {
+			log.Fatalf("Failed to enable authentication: %s", err)
+		}
+	}
+	if *jobArchive != "" {
+		target, err := raft.NewBackupTarget(*jobArchive)
+		if err == nil {
+			target, err = raft.EncryptTarget(target, key)
+		}
+		if err != nil {
+			log.Fatalf("Failed to open job archive: %s", err)
+		}
+		httpServer.EnableJobArchive(target)
+	}
+	if *artifactStore != "" {
+		target, err := raft.NewBackupTarget(*artifactStore)
+		if err == nil {
+			target, err = raft.EncryptTarget(target, key)
+		}
+		if err != nil {
+			log.Fatalf("Failed to open artifact store: %s", err)
+		}
+		httpServer.EnableArtifacts(target)
+	}
+	if *slicerURL != "" {
+		if *artifactStore == "" {
+			log.Fatalf("-slicer-url needs -artifact-store to keep models and G-code in")
+		}
+		slicer, err := api.NewHTTPSlicer(*slicerURL)
+		if err != nil {
+			log.Fatalf("Failed to configure slicing: %s", err)
+		}
+		httpServer.EnableSlicing(slicer)
+	}
+	if chaos != nil {
+		httpServer.EnableChaos(chaos)
+	}
+	var notifications api.NotificationConfig
+	if *notifyConfig != "" {
+		if notifications, err = api.LoadNotificationConfig(*notifyConfig); err != nil {
+			log.Fatalf("Failed to load notification channels: %s", err)
+		}
+	}
+	notifiers, err := notifications.Notifiers()
+	if err != nil {
+		log.Fatalf("Failed to set up notification channels: %s", err)
+	}
+	for _, url := range splitList(*notifyHooks) {
+		notifiers = append(notifiers, api.NewWebhookNotifier(url))
+	}
+	if len(notifiers) > 0 {
+		httpServer.EnableOutbox(notifications.FilamentLowGrams, notifiers...)
+	}
+	printers := make(map[string]drivers.PrinterDriver)
+	if *printerDrivers != "" {
+		configs, err := drivers.LoadConfig(*printerDrivers)
+		if err != nil {
+			log.Fatalf("Failed to load printer drivers: %s", err)
+		}
+		for printerID, config := range configs {
+			if printers[printerID], err = drivers.Open(config); err != nil {
+				log.Fatalf("Failed to connect to printer %s: %s", printerID, err)
+			}
+			log.Printf("Driving printer %s with %s at %s", printerID, config.Driver, config.Address)
+		}
+	}
+	// Printers whose stored driver config names this node are driven too
+	var driverKey string
+	if *driverAPIKey != "" {
+		data, err := secrets.Read(*driverAPIKey)
+		if err != nil {
+			log.Fatalf("Failed to read driver API key: %s", err)
+		}
+		driverKey = strings.TrimSpace(string(data))
+	}
+	httpServer.NodeID = *nodeID
+	httpServer.AllowVersionSkew(*allowSkew)
+	httpServer.EnableDrivers(printers, driverKey)
+	if certs != nil {
+		httpServer.EnableTLS(certs)
+	}
+	identity := api.NodeIdentity{
+		NodeID:    *nodeID,
+		Build:     version.Get(),
+		RaftAddr:  advertisedRaft,
+		HTTPAddr:  advertisedHTTP,
+		Profile:   *profileName,
+		StartedAt: started.UTC(),
+		Storage: api.StoragePaths{
+			DataDir:       nodeDataDir,
+			RaftLog:       filepath.Join(nodeDataDir, "raft.db"),
+			Snapshots:     filepath.Join(nodeDataDir, "snapshots"),
+			LogArchive:    *logArchive,
+			SQLProjection: *sqlProjection,
+		},
+		Features: enabledFeatures(map[string]bool{
+			"api_keys":                 *apiKeysFile != "",
+			"tls":                      certs != nil,
+			"encryption_at_rest":       key != nil,
+			"backups":                  *backupTarget != "",
+			"log_archive":              *logArchive != "",
+			"log_shipping":             *logShipTarget != "",
+			"cdc":                      *cdcExport != "",
+			"sql_projection":           *sqlProjection != "",
+			"history":                  *historyEntries >= 0,
+			"webhooks":                 *webhooks != "",
+			"notifications":            len(notifiers) > 0,
+			"printer_drivers":          len(printers) > 0 || driverKey != "",
+			"heartbeat_monitor":        *heartbeatTTL > 0,
+			"drying_check":             *dryMaxAge > 0,
+			"artifacts":                *artifactStore != "",
+			"slicing":                  *slicerURL != "",
+			"job_archive":              *jobArchive != "",
+			"plugins":                  *plugins != "",
+			"cors":                     *corsOrigins != "",
+			"management_allowlist":     *mgmtAllowlist != "",
+			"printer_cameras":          *cameraNets != "",
+			"fence_membership_changes": *fenceMembers,
+			"determinism_audit":        *determinism > 0,
+			"debug_listener":           *debugAddr != "",
+			"chaos":                    *enableChaos,
+		}),
+	}
+	httpServer.SetIdentity(identity)
+
+	// The components of the node start in dependency order and stop in
+	// reverse, so the store outlives every request and worker using it
+	node := lifecycle.New()
+	node.StopTimeout = *stopTimeout
+	node.Add(lifecycle.Component{
+		Name:  "raft store",
+		Ready: raftStore.Ready,
+		Stop:  func(context.Context) error { return raftStore.Close() },
+	})
+	node.Add(lifecycle.Component{
+		Name:      "http server",
+		DependsOn: []string{"raft store"},
+		Start:     func(context.Context) error { return httpServer.Start() },
+		Ready:     httpServer.Ready,
+		Stop:      httpServer.Shutdown,
+	})
 
 	// If join address is specified, join the cluster
 	if *joinAddr != "" {
-		// Wait a bit for the server to initialize
-		time.Sleep(1 * time.Second)
-		if err := httpServer.JoinCluster(*joinAddr, *nodeID, *raftAddr); err != nil {
-			log.Fatalf("Failed to join cluster: %s", err)
+		if *joinAPIKey != "" {
+			data, err := secrets.Read(*joinAPIKey)
+			if err != nil {
+				log.Fatalf("Failed to read join API key: %s", err)
+			}
+			httpServer.EnableJoinKey(strings.TrimSpace(string(data)))
+		}
+		// Joining waits until the transport and HTTP server are up, since
+		// the leader contacts both as soon as it adds this node
+		node.Add(lifecycle.Component{
+			Name:      "cluster join",
+			DependsOn: []string{"http server"},
+			Start: func(ctx context.Context) error {
+				return httpServer.JoinCluster(ctx, *joinAddr, *nodeID, advertisedRaft)
+			},
+			StartTimeout: *joinTimeout,
+		})
+	}
+
+	// The service manager hears the node is ready once it has joined and
+	// knows the leader, not merely once it has started
+	readyAfter := "http server"
+	if *joinAddr != "" {
+		readyAfter = "cluster join"
+	}
+	node.Add(lifecycle.Worker("service readiness", []string{readyAfter}, func(stop <-chan struct{}) {
+		ticker := time.NewTicker(100 * time.Millisecond)
+		defer ticker.Stop()
+		for raftStore.Leader() == "" {
+			select {
+			case <-ticker.C:
+			case <-stop:
+				return
+			}
+		}
+		if err := host.Ready(fmt.Sprintf("Serving HTTP at %s, leader at %s", advertisedHTTP, raftStore.Leader())); err != nil {
+			log.Printf("Failed to notify the service manager: %s", err)
+		}
+	}))
+
+	// Pick up rotated certificates and API keys
+	if *secretsRefresh > 0 {
+		if certs != nil {
+			node.Add(lifecycle.Worker("certificate rotation", nil, func(stop <-chan struct{}) {
+				secrets.Watch(*secretsRefresh, stop, certs.Refs, func() {
+					if err := certs.Reload(); err != nil {
+						log.Printf("Failed to reload TLS certificates, keeping the current ones: %s", err)
+						return
+					}
+					log.Println("Reloaded TLS certificates")
+				})
+			}))
 		}
+		node.Add(lifecycle.Worker("api key rotation", nil, func(stop <-chan struct{}) {
+			secrets.Watch(*secretsRefresh, stop, reload.apiKeysRefs, reload.rotateAPIKeys)
+		}))
 	}
 
+	if err := node.Start(context.Background()); err != nil {
+		log.Fatalf("Failed to start: %s", err)
+	}
 	fmt.Printf("KV store started, HTTP: %s, Raft: %s\n", *httpAddr, *raftAddr)
+	printBanner(identity)
 
-	// Wait for signal to exit
+	// Reload on SIGHUP, exit on interrupt, SIGTERM or a stop request from
+	// the service manager
+	hangup := make(chan os.Signal, 1)
+	signal.Notify(hangup, syscall.SIGHUP)
 	terminate := make(chan os.Signal, 1)
-	signal.Notify(terminate, os.Interrupt)
-	<-terminate
-	fmt.Println("KV store shutting down")
-
-	// Shutdown procedures
-	if err := httpServer.Stop(); err != nil {
-		log.Printf("Error stopping HTTP server: %s", err)
+	signal.Notify(terminate, os.Interrupt, syscall.SIGTERM)
+	for waiting := true; waiting; {
+		select {
+		case <-hangup:
+			if _, err := reload.reload(); err != nil {
+				log.Printf("Reload failed, nothing was changed: %s", err)
+			}
+		case <-terminate:
+			waiting = false
+		case <-host.Stop():
+			waiting = false
+		}
 	}
-	if err := raftStore.Close(); err != nil {
-		log.Printf("Error closing Raft store: %s", err)
+	fmt.Println("KV store shutting down")
+	host.Stopping()
+	if err := node.Stop(context.Background()); err != nil {
+		log.Printf("Error shutting down: %s", err)
 	}
-}
\ No newline at end of file
+	host.Stopped()
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"runtime"
+	"runtime/debug"
+
+	"raft3d/raft"
+)
+
+// profile bundles the settings a deployment profile changes
+type profile struct {
+	// tuning overrides the Raft timeouts, nil keeps the defaults
+	tuning *raft.Tuning
+
+	// flagDefaults replace flag defaults the operator didn't set explicitly
+	flagDefaults map[string]string
+
+	// eventHistory is the number of events kept for replay
+	eventHistory int
+
+	// maxProcs caps GOMAXPROCS unless set in the environment, 0 leaves it
+	maxProcs int
+
+	// gcPercent is passed to debug.SetGCPercent, 0 leaves the default
+	gcPercent int
+
+	// allowChaos permits --enable-chaos
+	allowChaos bool
+}
+
+// profiles maps --profile values to their settings
+var profiles = map[string]func() profile{
+	"default": func() profile {
+		return profile{eventHistory: 1000, allowChaos: true}
+	},
+	// embedded suits a Raspberry Pi or similar board next to the printers:
+	// slower elections, small on-disk footprint, fewer threads and less heap
+	"embedded": func() profile {
+		tuning := raft.EmbeddedTuning()
+		return profile{
+			tuning: &tuning,
+			flagDefaults: map[string]string{
+				"snapshot-retain":     "1",
+				"trailing-logs":       "1024",
+				"snapshot-threshold":  "1024",
+				"backup-retain":       "6",
+				"quorum-loss-timeout": "10s",
+				"history-entries":     "1024",
+			},
+			eventHistory: 100,
+			maxProcs:     2,
+			gcPercent:    50,
+		}
+	},
+}
+
+// applyProfile looks up a profile and applies its flag defaults and runtime
+// limits. It must be called after flag.Parse.
+func applyProfile(name string) (profile, error) {
+	newProfile, ok := profiles[name]
+	if !ok {
+		return profile{}, fmt.Errorf("unknown profile %q (use default or embedded)", name)
+	}
+	p := newProfile()
+
+	explicit := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) { explicit[f.Name] = true })
+	for name, value := range p.flagDefaults {
+		if !explicit[name] {
+			if err := flag.Set(name, value); err != nil {
+				return profile{}, err
+			}
+		}
+	}
+
+	if p.maxProcs > 0 && os.Getenv("GOMAXPROCS") == "" && runtime.GOMAXPROCS(0) > p.maxProcs {
+		runtime.GOMAXPROCS(p.maxProcs)
+	}
+	if p.gcPercent > 0 {
+		debug.SetGCPercent(p.gcPercent)
+	}
+
+	log.Printf("Using %s profile", name)
+	return p, nil
+}
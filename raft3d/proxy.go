@@ -0,0 +1,57 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"time"
+
+	"raft3d/proxy"
+)
+
+// runProxy implements the "proxy" subcommand, which serves a single stable
+// endpoint in front of the cluster. It returns the process exit code.
+func runProxy(args []string) int {
+	fs := flag.NewFlagSet("proxy", flag.ExitOnError)
+	listen := fs.String("listen", "127.0.0.1:8080", "Address the proxy listens on")
+	backends := fs.String("backends", "", "Comma-separated HTTP addresses of cluster nodes; other members are discovered")
+	interval := fs.Duration("poll-interval", time.Second, "How often to poll the nodes for the current leader")
+	fs.Parse(args)
+
+	var seeds []string
+	for _, addr := range strings.Split(*backends, ",") {
+		if addr = strings.TrimSpace(addr); addr != "" {
+			seeds = append(seeds, addr)
+		}
+	}
+	if len(seeds) == 0 {
+		fmt.Fprintln(os.Stderr, "proxy: --backends is required")
+		return 2
+	}
+
+	p := proxy.New(seeds, *interval)
+	p.Start()
+	defer p.Stop()
+
+	srv := &http.Server{Addr: *listen, Handler: p}
+	go func() {
+		if err := srv.ListenAndServe(); err != http.ErrServerClosed {
+			log.Fatalf("Proxy server error: %s", err)
+		}
+	}()
+	fmt.Printf("Proxy listening on %s for %s\n", *listen, strings.Join(seeds, ", "))
+
+	terminate := make(chan os.Signal, 1)
+	signal.Notify(terminate, os.Interrupt)
+	<-terminate
+	fmt.Println("Proxy shutting down")
+	if err := srv.Close(); err != nil {
+		log.Printf("Error stopping proxy: %s", err)
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,339 @@
+// Package proxy implements a Raft-aware reverse proxy that gives clients a
+// single stable endpoint in front of a raft3d cluster. Writes are routed to
+// the leader and reads are spread across healthy followers.
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/http/httputil"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// backendHeader names the node that served a proxied response
+const backendHeader = "X-Raft3d-Backend"
+
+// pollTimeout bounds each /cluster request made while polling
+const pollTimeout = 2 * time.Second
+
+// nodeInfo mirrors raft.NodeInfo as reported by /cluster
+type nodeInfo struct {
+	ID       string `json:"id"`
+	HTTPAddr string `json:"http_addr"`
+}
+
+// clusterStatus is the subset of a node's /cluster response the proxy uses
+type clusterStatus struct {
+	IsLeader bool       `json:"is_leader"`
+	Leader   nodeInfo   `json:"leader"`
+	Nodes    []nodeInfo `json:"nodes"`
+	Quorum   struct {
+		Lost bool `json:"quorum_lost"`
+	} `json:"quorum"`
+}
+
+// Backend is the proxy's view of one node
+type Backend struct {
+	Addr      string    `json:"addr"`
+	Healthy   bool      `json:"healthy"`
+	Leader    bool      `json:"leader"`
+	Error     string    `json:"error,omitempty"`
+	CheckedAt time.Time `json:"checked_at"`
+}
+
+// Proxy routes requests to the nodes of a cluster
+type Proxy struct {
+	interval time.Duration
+	client   *http.Client
+	reverse  *httputil.ReverseProxy
+
+	mutex    sync.RWMutex
+	backends map[string]*Backend
+	leader   string
+	next     uint64 // round-robin counter for reads
+
+	refreshCh chan struct{}
+	stopCh    chan struct{}
+}
+
+// New creates a proxy for the cluster reachable through the seed HTTP
+// addresses. Other members are discovered from the seeds' /cluster responses.
+func New(seeds []string, interval time.Duration) *Proxy {
+	p := &Proxy{
+		interval:  interval,
+		client:    &http.Client{Timeout: pollTimeout},
+		backends:  make(map[string]*Backend),
+		refreshCh: make(chan struct{}, 1),
+		stopCh:    make(chan struct{}),
+	}
+	for _, addr := range seeds {
+		p.backends[addr] = &Backend{Addr: addr}
+	}
+	p.reverse = &httputil.ReverseProxy{
+		Director:       p.direct,
+		ModifyResponse: p.inspect,
+		ErrorHandler:   p.backendError,
+		FlushInterval:  -1, // stream server-sent events as they arrive
+	}
+	return p
+}
+
+// Start polls the cluster until Stop is called
+func (p *Proxy) Start() {
+	p.poll()
+	go func() {
+		ticker := time.NewTicker(p.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+			case <-p.refreshCh:
+			case <-p.stopCh:
+				return
+			}
+			p.poll()
+		}
+	}()
+}
+
+// Stop ends polling
+func (p *Proxy) Stop() {
+	close(p.stopCh)
+}
+
+// refresh asks for an immediate poll, e.g. after the leader moved
+func (p *Proxy) refresh() {
+	select {
+	case p.refreshCh <- struct{}{}:
+	default:
+	}
+}
+
+// poll checks every known node and updates the leader and healthy set
+func (p *Proxy) poll() {
+	p.mutex.RLock()
+	addrs := make([]string, 0, len(p.backends))
+	for addr := range p.backends {
+		addrs = append(addrs, addr)
+	}
+	p.mutex.RUnlock()
+
+	type result struct {
+		addr   string
+		status clusterStatus
+		err    error
+	}
+	results := make(chan result, len(addrs))
+	for _, addr := range addrs {
+		go func(addr string) {
+			status, err := p.fetchStatus(addr)
+			results <- result{addr, status, err}
+		}(addr)
+	}
+
+	now := time.Now().UTC()
+	leader := ""
+	discovered := make(map[string]bool)
+	updates := make(map[string]*Backend)
+	for range addrs {
+		res := <-results
+		backend := &Backend{Addr: res.addr, CheckedAt: now}
+		if res.err != nil {
+			backend.Error = res.err.Error()
+		} else {
+			backend.Healthy = !res.status.Quorum.Lost
+			backend.Leader = res.status.IsLeader
+			if backend.Leader {
+				leader = res.addr
+			}
+			for _, node := range res.status.Nodes {
+				if node.HTTPAddr != "" {
+					discovered[node.HTTPAddr] = true
+				}
+			}
+		}
+		updates[res.addr] = backend
+	}
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	for addr, backend := range updates {
+		p.backends[addr] = backend
+	}
+	for addr := range discovered {
+		if _, ok := p.backends[addr]; !ok {
+			log.Printf("Proxy: discovered node %s", addr)
+			p.backends[addr] = &Backend{Addr: addr}
+		}
+	}
+	if leader != p.leader {
+		log.Printf("Proxy: leader is now %q", leader)
+		p.leader = leader
+	}
+}
+
+// fetchStatus reads a node's /cluster endpoint
+func (p *Proxy) fetchStatus(addr string) (clusterStatus, error) {
+	var status clusterStatus
+	resp, err := p.client.Get(fmt.Sprintf("http://%s/cluster", addr))
+	if err != nil {
+		return status, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return status, fmt.Errorf("/cluster returned %s", resp.Status)
+	}
+	err = json.NewDecoder(resp.Body).Decode(&status)
+	return status, err
+}
+
+// Backends returns the current view of every node, sorted by address
+func (p *Proxy) Backends() []Backend {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+
+	backends := make([]Backend, 0, len(p.backends))
+	for _, backend := range p.backends {
+		backends = append(backends, *backend)
+	}
+	sort.Slice(backends, func(i, j int) bool { return backends[i].Addr < backends[j].Addr })
+	return backends
+}
+
+// isWrite reports whether a request changes state and must go to the leader
+func isWrite(r *http.Request) bool {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return false
+	}
+	return true
+}
+
+// pick chooses the backend for a request: the leader for writes, and a
+// healthy follower in turn for reads, falling back to the leader
+func (p *Proxy) pick(r *http.Request) string {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+
+	if isWrite(r) {
+		return p.leader
+	}
+
+	var followers []string
+	for addr, backend := range p.backends {
+		if backend.Healthy && !backend.Leader {
+			followers = append(followers, addr)
+		}
+	}
+	if len(followers) == 0 {
+		return p.leader
+	}
+	sort.Strings(followers)
+	n := atomic.AddUint64(&p.next, 1)
+	return followers[n%uint64(len(followers))]
+}
+
+// ServeHTTP proxies a request, or serves the proxy's own status page
+func (p *Proxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == "/proxy/status" {
+		p.mutex.RLock()
+		leader := p.leader
+		p.mutex.RUnlock()
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"leader":   leader,
+			"backends": p.Backends(),
+		})
+		return
+	}
+
+	addr := p.pick(r)
+	if addr == "" {
+		p.refresh()
+		writeUnavailable(w, r, "not_leader", "The proxy does not currently know a leader; retry shortly")
+		return
+	}
+	r = r.WithContext(withBackend(r.Context(), addr))
+	p.reverse.ServeHTTP(w, r)
+}
+
+// direct rewrites the outgoing request to the chosen backend
+func (p *Proxy) direct(r *http.Request) {
+	addr := backendFrom(r.Context())
+	r.URL.Scheme = "http"
+	r.URL.Host = addr
+}
+
+// backendKey carries the chosen backend through the request context
+type backendKey struct{}
+
+// withBackend records the chosen backend in a context
+func withBackend(ctx context.Context, addr string) context.Context {
+	return context.WithValue(ctx, backendKey{}, addr)
+}
+
+// backendFrom returns the backend chosen for a request
+func backendFrom(ctx context.Context) string {
+	addr, _ := ctx.Value(backendKey{}).(string)
+	return addr
+}
+
+// inspect tags responses with the backend that served them and re-polls
+// when a node reports it is no longer the leader
+func (p *Proxy) inspect(resp *http.Response) error {
+	addr := backendFrom(resp.Request.Context())
+	resp.Header.Set(backendHeader, addr)
+	if isWrite(resp.Request) && resp.StatusCode == http.StatusServiceUnavailable {
+		p.refresh()
+	}
+	return nil
+}
+
+// backendError marks an unreachable backend unhealthy and answers 502
+func (p *Proxy) backendError(w http.ResponseWriter, r *http.Request, err error) {
+	addr := backendFrom(r.Context())
+	log.Printf("Proxy: %s %s via %s failed: %s", r.Method, r.URL.Path, addr, err)
+
+	p.mutex.Lock()
+	if backend, ok := p.backends[addr]; ok {
+		backend.Healthy = false
+		backend.Error = err.Error()
+		if p.leader == addr {
+			p.leader = ""
+		}
+	}
+	p.mutex.Unlock()
+	p.refresh()
+
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.WriteHeader(http.StatusBadGateway)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"type":     "about:blank",
+		"title":    http.StatusText(http.StatusBadGateway),
+		"status":   http.StatusBadGateway,
+		"code":     "backend_unavailable",
+		"detail":   fmt.Sprintf("Node %s could not be reached", addr),
+		"instance": r.URL.Path,
+	})
+}
+
+// writeUnavailable writes a 503 problem in the same shape as the nodes' own
+func writeUnavailable(w http.ResponseWriter, r *http.Request, code, detail string) {
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.Header().Set("Retry-After", "1")
+	w.WriteHeader(http.StatusServiceUnavailable)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"type":     "about:blank",
+		"title":    http.StatusText(http.StatusServiceUnavailable),
+		"status":   http.StatusServiceUnavailable,
+		"code":     code,
+		"detail":   detail,
+		"instance": r.URL.Path,
+	})
+}
@@ -0,0 +1,119 @@
+package proxy
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeNode serves a node's /cluster status and answers every other request
+// with its own address
+type fakeNode struct {
+	server *httptest.Server
+
+	mutex  sync.Mutex
+	status clusterStatus
+}
+
+func newFakeNode(t *testing.T) *fakeNode {
+	n := &fakeNode{}
+	n.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/cluster" {
+			n.mutex.Lock()
+			defer n.mutex.Unlock()
+			json.NewEncoder(w).Encode(n.status)
+			return
+		}
+		w.Write([]byte(n.addr()))
+	}))
+	t.Cleanup(n.server.Close)
+	return n
+}
+
+func (n *fakeNode) addr() string {
+	return strings.TrimPrefix(n.server.URL, "http://")
+}
+
+func (n *fakeNode) set(update func(status *clusterStatus)) {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	update(&n.status)
+}
+
+func TestProxyRoutesWritesToLeaderAndReadsToFollowers(t *testing.T) {
+	leader, a, b := newFakeNode(t), newFakeNode(t), newFakeNode(t)
+	var members []nodeInfo
+	for _, n := range []*fakeNode{leader, a, b} {
+		members = append(members, nodeInfo{HTTPAddr: n.addr()})
+	}
+	for _, n := range []*fakeNode{leader, a, b} {
+		n.set(func(status *clusterStatus) { status.Nodes = members })
+	}
+	leader.set(func(status *clusterStatus) { status.IsLeader = true })
+
+	// Only the leader is a seed; the followers are discovered through it
+	p := New([]string{leader.addr()}, time.Hour)
+	p.poll()
+	p.poll()
+	if backends := p.Backends(); len(backends) != 3 {
+		t.Fatalf("backends after discovery: %+v", backends)
+	}
+
+	do := func(method string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		p.ServeHTTP(w, httptest.NewRequest(method, "/api/v1/printers", nil))
+		return w
+	}
+	for _, method := range []string{http.MethodPost, http.MethodPut, http.MethodDelete} {
+		if w := do(method); w.Body.String() != leader.addr() || w.Header().Get(backendHeader) != leader.addr() {
+			t.Fatalf("%s went to %s, want the leader %s", method, w.Body, leader.addr())
+		}
+	}
+	served := make(map[string]int)
+	for i := 0; i < 4; i++ {
+		served[do(http.MethodGet).Body.String()]++
+	}
+	if served[a.addr()] != 2 || served[b.addr()] != 2 {
+		t.Fatalf("reads were served by %v, want both followers in turn", served)
+	}
+
+	// A follower that lost quorum gets no reads
+	a.set(func(status *clusterStatus) { status.Quorum.Lost = true })
+	p.poll()
+	for i := 0; i < 2; i++ {
+		if got := do(http.MethodGet).Body.String(); got != b.addr() {
+			t.Fatalf("read went to %s with %s out of quorum", got, a.addr())
+		}
+	}
+
+	// An unreachable leader fails the write and is forgotten until a node
+	// reports being leader again
+	leader.server.Close()
+	w := do(http.MethodPost)
+	if w.Code != http.StatusBadGateway || !strings.Contains(w.Body.String(), "backend_unavailable") {
+		t.Fatalf("write to a dead leader: %d %s", w.Code, w.Body)
+	}
+	w = do(http.MethodPost)
+	if w.Code != http.StatusServiceUnavailable || w.Header().Get("Retry-After") != "1" || !strings.Contains(w.Body.String(), "not_leader") {
+		t.Fatalf("write without a leader: %d %s", w.Code, w.Body)
+	}
+
+	b.set(func(status *clusterStatus) { status.IsLeader = true })
+	p.poll()
+	if w := do(http.MethodPost); w.Body.String() != b.addr() {
+		t.Fatalf("write after failover went to %s, want %s", w.Body, b.addr())
+	}
+	w = httptest.NewRecorder()
+	p.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/proxy/status", nil))
+	var status struct {
+		Leader   string    `json:"leader"`
+		Backends []Backend `json:"backends"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &status); err != nil || status.Leader != b.addr() || len(status.Backends) != 3 {
+		t.Fatalf("status: %s", w.Body)
+	}
+}
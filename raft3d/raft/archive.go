@@ -0,0 +1,409 @@
+package raft
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/raft"
+)
+
+// defaultSegmentEntries is how many entries are written per archive segment
+const defaultSegmentEntries = 10000
+
+// defaultLogShipInterval is how often the log archive is shipped off-site
+const defaultLogShipInterval = 10 * time.Second
+
+// errStopReplay ends a replay once the requested index is reached
+var errStopReplay = errors.New("replay target reached")
+
+// ArchivedEntry is a committed command as stored in the log archive
+type ArchivedEntry struct {
+	Index   uint64          `json:"index"`
+	Term    uint64          `json:"term"`
+	Command json.RawMessage `json:"command"`
+}
+
+// LogArchiver ships every applied command to append-only segment files, so
+// state can be rebuilt to any index between snapshots. Segments are named
+// after their first index and hold one JSON entry per line, each sealed
+// with the at-rest key when encryption is on. Segments can be shipped to a
+// BackupTarget, such as an S3 or GCS bucket, as they grow.
+type LogArchiver struct {
+	mutex       sync.Mutex
+	dir         string
+	cipher      *atRestCipher
+	segmentSize int
+	file        *os.File
+	writer      *bufio.Writer
+	count       int
+	lastIndex   uint64
+
+	shipMutex sync.Mutex
+	target    BackupTarget     // off-site copy of the segments, if any
+	shipped   map[string]int64 // size of each segment when last shipped
+}
+
+// NewLogArchiver opens an archive directory and resumes after its last
+// entry. Entries are encrypted with key unless it is nil.
+func NewLogArchiver(dir string, key []byte) (*LogArchiver, error) {
+	atRest, err := newAtRestCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	a := &LogArchiver{dir: dir, cipher: atRest, segmentSize: defaultSegmentEntries, shipped: make(map[string]int64)}
+
+	segments, err := archiveSegments(dir)
+	if err != nil {
+		return nil, err
+	}
+	// Resume after the last archived entry. New entries always go to a fresh
+	// segment so a torn write at the end of the previous one stays isolated.
+	if len(segments) > 0 {
+		last := segments[len(segments)-1]
+		err := readSegment(last, atRest, func(e ArchivedEntry) error {
+			a.lastIndex = e.Index
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to read archive segment %s: %w", last, err)
+		}
+	}
+	return a, nil
+}
+
+// Append archives a log entry. Entries at or below the last archived index,
+// which are seen again when the FSM replays its log on startup, are skipped.
+func (a *LogArchiver) Append(l *raft.Log) error {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	if l.Index <= a.lastIndex || !json.Valid(l.Data) {
+		return nil
+	}
+
+	if a.file == nil || a.count >= a.segmentSize {
+		if err := a.rotate(l.Index); err != nil {
+			return err
+		}
+	}
+
+	line, err := json.Marshal(ArchivedEntry{Index: l.Index, Term: l.Term, Command: l.Data})
+	if err == nil {
+		line, err = a.cipher.sealLine(line)
+	}
+	if err != nil {
+		return err
+	}
+	if _, err := a.writer.Write(append(line, '\n')); err != nil {
+		return err
+	}
+	if err := a.writer.Flush(); err != nil {
+		return err
+	}
+
+	a.count++
+	a.lastIndex = l.Index
+	return nil
+}
+
+// Close flushes and closes the current segment, then ships what is left
+func (a *LogArchiver) Close() error {
+	a.mutex.Lock()
+	err := a.closeSegment()
+	a.mutex.Unlock()
+	if err != nil {
+		return err
+	}
+	return a.Ship()
+}
+
+// ShipTo sets the target that Ship copies segments to
+func (a *LogArchiver) ShipTo(target BackupTarget) {
+	a.shipMutex.Lock()
+	defer a.shipMutex.Unlock()
+	a.target = target
+}
+
+// Ship uploads every segment that grew since it was last shipped. The open
+// segment is uploaded whole each time, replacing the previous copy, so the
+// off-site archive trails the local one by at most one shipping interval.
+// After a restart every segment is shipped once more.
+func (a *LogArchiver) Ship() error {
+	a.shipMutex.Lock()
+	defer a.shipMutex.Unlock()
+	if a.target == nil {
+		return nil
+	}
+
+	// Sizes are taken between appends so no upload ends inside an entry
+	a.mutex.Lock()
+	segments, err := archiveSegments(a.dir)
+	sizes := make(map[string]int64, len(segments))
+	for _, segment := range segments {
+		if info, statErr := os.Stat(segment); statErr == nil {
+			sizes[segment] = info.Size()
+		}
+	}
+	a.mutex.Unlock()
+	if err != nil {
+		return err
+	}
+
+	for _, segment := range segments {
+		name := filepath.Base(segment)
+		size, ok := sizes[segment]
+		if !ok || a.shipped[name] == size {
+			continue
+		}
+		f, err := os.Open(segment)
+		if err != nil {
+			return err
+		}
+		err = a.target.Write(name, io.LimitReader(f, size))
+		f.Close()
+		if err != nil {
+			return fmt.Errorf("failed to ship %s: %w", name, err)
+		}
+		a.shipped[name] = size
+	}
+	return nil
+}
+
+// rotate closes the current segment and starts a new one at firstIndex
+func (a *LogArchiver) rotate(firstIndex uint64) error {
+	if err := a.closeSegment(); err != nil {
+		return err
+	}
+	a.count = 0
+	return a.openSegment(filepath.Join(a.dir, fmt.Sprintf("wal-%020d.jsonl", firstIndex)))
+}
+
+func (a *LogArchiver) openSegment(path string) error {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	a.file = f
+	a.writer = bufio.NewWriter(f)
+	return nil
+}
+
+func (a *LogArchiver) closeSegment() error {
+	if a.file == nil {
+		return nil
+	}
+	if err := a.writer.Flush(); err != nil {
+		return err
+	}
+	if err := a.file.Sync(); err != nil {
+		return err
+	}
+	err := a.file.Close()
+	a.file, a.writer = nil, nil
+	return err
+}
+
+// archiveSegments returns the segment files in dir in index order
+func archiveSegments(dir string) ([]string, error) {
+	matches, err := filepath.Glob(filepath.Join(dir, "wal-*.jsonl"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(matches)
+	return matches, nil
+}
+
+// readSegment calls fn for every entry in a segment file, decrypting
+// entries with atRest
+func readSegment(path string, atRest *atRestCipher, fn func(ArchivedEntry) error) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		plain, err := atRest.openLine([]byte(line))
+		if errors.Is(err, errTornLine) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		var entry ArchivedEntry
+		if err := json.Unmarshal(plain, &entry); err != nil {
+			// A torn final write is expected after a crash
+			return nil
+		}
+		if err := fn(entry); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+// LogShippingConfig controls shipping of the log archive
+type LogShippingConfig struct {
+	Target   BackupTarget
+	Interval time.Duration // default 10s
+}
+
+// EnableLogShipping starts copying the log archive to a target, so commands
+// committed since the last backup survive the loss of the node
+func (s *RaftStore) EnableLogShipping(cfg LogShippingConfig) error {
+	archiver := s.fsm.archiver
+	if archiver == nil {
+		return fmt.Errorf("%w: log shipping needs a log archive", ErrValidation)
+	}
+	interval := cfg.Interval
+	if interval <= 0 {
+		interval = defaultLogShipInterval
+	}
+	archiver.ShipTo(cfg.Target)
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if err := archiver.Ship(); err != nil {
+					log.Printf("Log shipping failed: %s", err)
+				}
+			case <-s.shutdownCh:
+				return
+			}
+		}
+	}()
+	return nil
+}
+
+// fetchArchive downloads the segments of a shipped log archive at an s3://
+// or gs:// location into a temporary directory, which cleanup removes
+func fetchArchive(location string) (dir string, cleanup func(), err error) {
+	target, err := NewObjectBackupTarget(location)
+	if err != nil {
+		return "", nil, err
+	}
+	objects, err := target.list()
+	if err != nil {
+		return "", nil, err
+	}
+	dir, err = os.MkdirTemp("", "raft3d-replay-")
+	if err != nil {
+		return "", nil, err
+	}
+	cleanup = func() { os.RemoveAll(dir) }
+
+	for _, object := range objects {
+		name := strings.TrimPrefix(object.Key, target.prefix)
+		if matched, _ := filepath.Match("wal-*.jsonl", name); !matched {
+			continue
+		}
+		if err := download(target, name, filepath.Join(dir, name)); err != nil {
+			cleanup()
+			return "", nil, err
+		}
+	}
+	return dir, cleanup, nil
+}
+
+// download copies a named object of target to path
+func download(target BackupTarget, name, path string) error {
+	r, err := target.Open(name)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// ReplayArchive rebuilds FSM state by applying archived entries on top of an
+// optional base backup, stopping at toIndex (0 replays everything). The
+// archive is a directory or an s3:// or gs:// location it was shipped to.
+// Encrypted entries are read with $RAFT3D_ENCRYPTION_KEY.
+func ReplayArchive(location string, base *Backup, toIndex uint64) (*Backup, error) {
+	atRest, err := cipherFromEnv()
+	if err != nil {
+		return nil, err
+	}
+	dir := strings.TrimPrefix(location, "file://")
+	if strings.HasPrefix(location, "s3://") || strings.HasPrefix(location, "gs://") {
+		fetched, cleanup, err := fetchArchive(location)
+		if err != nil {
+			return nil, err
+		}
+		defer cleanup()
+		dir = fetched
+	}
+	fsm := NewFSM()
+	var fromIndex uint64
+	if base != nil {
+		data, err := json.Marshal(base.Data)
+		if err != nil {
+			return nil, err
+		}
+		if err := fsm.Restore(io.NopCloser(bytes.NewReader(data))); err != nil {
+			return nil, err
+		}
+		fromIndex = base.Index
+		fsm.index = base.Index
+	}
+
+	segments, err := archiveSegments(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	// Indexes are not contiguous: configuration changes and no-op entries
+	// never reach the FSM and so are never archived
+	for _, segment := range segments {
+		err := readSegment(segment, atRest, func(e ArchivedEntry) error {
+			if e.Index <= fromIndex {
+				return nil
+			}
+			if toIndex > 0 && e.Index > toIndex {
+				return errStopReplay
+			}
+			fsm.Apply(&raft.Log{Index: e.Index, Term: e.Term, Type: raft.LogCommand, Data: e.Command})
+			return nil
+		})
+		if err == errStopReplay {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	data, index := fsm.State()
+	return &Backup{Index: index, CreatedAt: time.Now().UTC(), Data: data}, nil
+}
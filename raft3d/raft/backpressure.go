@@ -0,0 +1,45 @@
+package raft
+
+import (
+	"fmt"
+	"strconv"
+	"sync/atomic"
+)
+
+// defaultMaxFSMPending is the FSM queue depth beyond which writes are shed
+// when StoreConfig.MaxFSMPending is unset. Raft buffers at most 128 committed
+// entries for the FSM, so the threshold must stay below that to ever apply.
+const defaultMaxFSMPending = 64
+
+// backpressure sheds writes while the FSM is behind on applying committed
+// entries, so queued applies can't pile up in memory
+type backpressure struct {
+	threshold int    // 0 disables shedding
+	rejected  uint64 // accessed atomically
+}
+
+// fsmPending returns the number of committed entries waiting for the FSM
+func (s *RaftStore) fsmPending() int {
+	pending, _ := strconv.Atoi(s.raft.Stats()["fsm_pending"])
+	return pending
+}
+
+// admit refuses a write with ErrOverloaded while the FSM queue is deeper
+// than the threshold, or the PostApply hooks are more than hookBacklog
+// entries behind
+func (s *RaftStore) admit() error {
+	if s.fsm.hooks != nil {
+		if backlog := s.fsm.hooks.backlog(); backlog > hookBacklog {
+			atomic.AddUint64(&s.backpressure.rejected, 1)
+			return fmt.Errorf("%w: apply hooks are %d entries behind, limit %d", ErrOverloaded, backlog, hookBacklog)
+		}
+	}
+	if s.backpressure.threshold <= 0 {
+		return nil
+	}
+	if pending := s.fsmPending(); pending > s.backpressure.threshold {
+		atomic.AddUint64(&s.backpressure.rejected, 1)
+		return fmt.Errorf("%w: %d entries waiting to be applied, limit %d", ErrOverloaded, pending, s.backpressure.threshold)
+	}
+	return nil
+}
@@ -0,0 +1,60 @@
+package raft
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/raft"
+)
+
+func TestWritesAreShedWhileTheFSMIsBehind(t *testing.T) {
+	addr, transport := raft.NewInmemTransport("")
+	store, err := NewRaftStore(StoreConfig{NodeID: "n1", RaftAddr: string(addr), Bootstrap: true, Transport: transport, InMemory: true,
+		DataDir: t.TempDir(), MaxFSMPending: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { store.Close() })
+	waitFor(t, 10*time.Second, func() bool {
+		_, err := store.Get(nodeKeyPrefix + "n1")
+		return err == nil
+	}, "leader did not register itself")
+
+	// Stall the FSM and commit entries behind it, one batch at a time
+	store.fsm.mutex.Lock()
+	var futures []raft.ApplyFuture
+	for i := 0; i < 6; i++ {
+		data, _ := json.Marshal(&Command{Op: "set", Key: fmt.Sprintf("printer_%d", i), Value: "{}", Version: ProtocolVersion})
+		futures = append(futures, store.raft.Apply(data, 0))
+		time.Sleep(20 * time.Millisecond)
+	}
+	waitFor(t, 10*time.Second, func() bool { return store.fsmPending() > 2 }, "committed entries did not queue for the FSM")
+
+	err = store.Set("printer_shed", "{}")
+	store.fsm.mutex.Unlock()
+	if !errors.Is(err, ErrOverloaded) {
+		t.Fatalf("write while the FSM is behind: %v", err)
+	}
+	if shed := store.Metrics()["writes_shed_overloaded"]; shed != uint64(1) {
+		t.Fatalf("writes_shed_overloaded = %v", shed)
+	}
+
+	// Once it catches up, writes are admitted again and nothing queued was
+	// lost
+	for _, future := range futures {
+		if err := future.Error(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := store.Set("printer_shed", "{}"); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 6; i++ {
+		if _, err := store.Get(fmt.Sprintf("printer_%d", i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
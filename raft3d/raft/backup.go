@@ -0,0 +1,402 @@
+package raft
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/raft"
+	raftboltdb "github.com/hashicorp/raft-boltdb/v2"
+)
+
+// backupSuffix is the file extension of backup archives
+const backupSuffix = ".json.gz"
+
+// BackupInfo describes a stored backup
+type BackupInfo struct {
+	Name      string    `json:"name"`
+	Size      int64     `json:"size"`
+	CreatedAt time.Time `json:"created_at"`
+	SHA256    string    `json:"sha256,omitempty"` // set when the backup is written
+}
+
+// Backup is the content of a backup archive
+type Backup struct {
+	NodeID    string            `json:"node_id"`
+	Index     uint64            `json:"index"`
+	CreatedAt time.Time         `json:"created_at"`
+	Data      map[string]string `json:"data"`
+}
+
+// BackupTarget is a place backups are written to
+type BackupTarget interface {
+	// Write stores a backup under name
+	Write(name string, r io.Reader) error
+
+	// Open returns a reader for the named backup
+	Open(name string) (io.ReadCloser, error)
+
+	// List returns all stored backups, newest first
+	List() ([]BackupInfo, error)
+
+	// Remove deletes the named backup
+	Remove(name string) error
+}
+
+// NewBackupTarget creates a target from a location: a plain path, a file://
+// URL, or an s3:// or gs:// bucket with an optional key prefix
+func NewBackupTarget(location string) (BackupTarget, error) {
+	switch {
+	case strings.HasPrefix(location, "file://"):
+		return NewDirBackupTarget(strings.TrimPrefix(location, "file://"))
+	case strings.HasPrefix(location, "s3://"), strings.HasPrefix(location, "gs://"):
+		return NewObjectBackupTarget(location)
+	case strings.Contains(location, "://"):
+		scheme, _, _ := strings.Cut(location, "://")
+		return nil, fmt.Errorf("unsupported backup target scheme %q", scheme)
+	}
+	return NewDirBackupTarget(location)
+}
+
+// OpenBackup opens a backup archive or manifest by path or by its URL in a
+// backup target, such as s3://bucket/prefix/raft3d-...json.gz
+func OpenBackup(location string) (io.ReadCloser, error) {
+	if !strings.Contains(location, "://") || strings.HasPrefix(location, "file://") {
+		return os.Open(strings.TrimPrefix(location, "file://"))
+	}
+	dir, name := location, ""
+	if i := strings.LastIndex(location, "/"); i > strings.Index(location, "://")+2 {
+		dir, name = location[:i], location[i+1:]
+	}
+	if name == "" {
+		return nil, fmt.Errorf("%s does not name a backup", location)
+	}
+	target, err := NewBackupTarget(dir)
+	if err != nil {
+		return nil, err
+	}
+	return target.Open(name)
+}
+
+// DirBackupTarget stores backups as files in a local directory, which may be
+// a mounted network or object storage filesystem
+type DirBackupTarget struct {
+	dir string
+}
+
+// NewDirBackupTarget creates a backup target rooted at dir
+func NewDirBackupTarget(dir string) (*DirBackupTarget, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &DirBackupTarget{dir: dir}, nil
+}
+
+// Write stores a backup atomically by writing to a temp file and renaming it
+func (t *DirBackupTarget) Write(name string, r io.Reader) error {
+	tmp, err := os.CreateTemp(t.dir, ".backup-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := io.Copy(tmp, r); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), filepath.Join(t.dir, name))
+}
+
+// Open returns a reader for the named backup
+func (t *DirBackupTarget) Open(name string) (io.ReadCloser, error) {
+	return os.Open(filepath.Join(t.dir, filepath.Base(name)))
+}
+
+// List returns all stored backups, newest first
+func (t *DirBackupTarget) List() ([]BackupInfo, error) {
+	entries, err := os.ReadDir(t.dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var backups []BackupInfo
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), backupSuffix) {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		backups = append(backups, BackupInfo{
+			Name:      entry.Name(),
+			Size:      info.Size(),
+			CreatedAt: info.ModTime().UTC(),
+		})
+	}
+
+	// Names embed a sortable timestamp
+	sort.Slice(backups, func(i, j int) bool { return backups[i].Name > backups[j].Name })
+	return backups, nil
+}
+
+// Remove deletes the named backup
+func (t *DirBackupTarget) Remove(name string) error {
+	return os.Remove(filepath.Join(t.dir, filepath.Base(name)))
+}
+
+// BackupConfig controls periodic backups
+type BackupConfig struct {
+	Target   BackupTarget
+	Interval time.Duration
+	Retain   int
+}
+
+// runBackups periodically writes a backup while this node is leader
+func (s *RaftStore) runBackups(cfg BackupConfig) {
+	ticker := time.NewTicker(cfg.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if s.raft.State() != raft.Leader {
+				continue
+			}
+			info, err := s.WriteBackup()
+			if err != nil {
+				log.Printf("Backup failed: %s", err)
+				continue
+			}
+			log.Printf("Wrote backup %s (%d bytes)", info.Name, info.Size)
+			if err := s.pruneBackups(cfg.Retain); err != nil {
+				log.Printf("Failed to prune backups: %s", err)
+			}
+		case <-s.shutdownCh:
+			return
+		}
+	}
+}
+
+// EnableBackups starts writing periodic backups to the configured target
+func (s *RaftStore) EnableBackups(cfg BackupConfig) {
+	s.backupTarget = cfg.Target
+	if cfg.Interval > 0 {
+		go s.runBackups(cfg)
+	}
+}
+
+// encodeBackup compresses a backup archive and seals it with atRest
+func encodeBackup(backup *Backup, atRest *atRestCipher) ([]byte, error) {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	if err := json.NewEncoder(gz).Encode(backup); err != nil {
+		return nil, err
+	}
+	if err := gz.Close(); err != nil {
+		return nil, err
+	}
+	return atRest.seal(buf.Bytes())
+}
+
+// EncodeBackup encodes a backup archive for the offline subcommands,
+// encrypted with $RAFT3D_ENCRYPTION_KEY when it is set
+func EncodeBackup(backup *Backup) ([]byte, error) {
+	atRest, err := cipherFromEnv()
+	if err != nil {
+		return nil, err
+	}
+	return encodeBackup(backup, atRest)
+}
+
+// WriteBackup writes a compressed copy of the FSM state to the backup target,
+// followed by its manifest. The archive is encrypted like snapshots are; the
+// manifest only holds counts and the archive's checksum.
+func (s *RaftStore) WriteBackup() (BackupInfo, error) {
+	if s.backupTarget == nil {
+		return BackupInfo{}, fmt.Errorf("%w: backups are not configured", ErrValidation)
+	}
+
+	data, index := s.fsm.State()
+	backup := Backup{
+		NodeID:    string(s.raftConfig.LocalID),
+		Index:     index,
+		CreatedAt: time.Now().UTC(),
+		Data:      data,
+	}
+	name := fmt.Sprintf("raft3d-%s-%d%s", backup.CreatedAt.Format("20060102T150405Z"), index, backupSuffix)
+
+	archive, err := encodeBackup(&backup, s.fsm.cipher)
+	if err != nil {
+		return BackupInfo{}, err
+	}
+	checksum := newChecksumWriter()
+	checksum.Write(archive)
+	if err := s.backupTarget.Write(name, bytes.NewReader(archive)); err != nil {
+		return BackupInfo{}, err
+	}
+
+	manifest, err := json.MarshalIndent(BackupManifest{
+		Backup:    name,
+		NodeID:    backup.NodeID,
+		Index:     index,
+		CreatedAt: backup.CreatedAt.Format(time.RFC3339),
+		Size:      checksum.size,
+		SHA256:    checksum.sum(),
+		Keys:      len(data),
+		Entities:  entityCounts(data),
+	}, "", "  ")
+	if err != nil {
+		return BackupInfo{}, err
+	}
+	if err := s.backupTarget.Write(ManifestName(name), bytes.NewReader(manifest)); err != nil {
+		return BackupInfo{}, fmt.Errorf("failed to write manifest: %w", err)
+	}
+
+	backups, err := s.backupTarget.List()
+	if err != nil {
+		return BackupInfo{}, err
+	}
+	for _, b := range backups {
+		if b.Name == name {
+			b.SHA256 = checksum.sum()
+			return b, nil
+		}
+	}
+	return BackupInfo{Name: name, Size: checksum.size, CreatedAt: backup.CreatedAt, SHA256: checksum.sum()}, nil
+}
+
+// pruneBackups removes all but the newest retain backups
+func (s *RaftStore) pruneBackups(retain int) error {
+	if retain <= 0 {
+		return nil
+	}
+	backups, err := s.backupTarget.List()
+	if err != nil {
+		return err
+	}
+	for i := retain; i < len(backups); i++ {
+		if err := s.backupTarget.Remove(backups[i].Name); err != nil {
+			return err
+		}
+		// Backups written before manifests existed have none
+		if err := s.backupTarget.Remove(ManifestName(backups[i].Name)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+	}
+	return nil
+}
+
+// Backups lists the backups stored in the backup target
+func (s *RaftStore) Backups() ([]BackupInfo, error) {
+	if s.backupTarget == nil {
+		return nil, fmt.Errorf("%w: backups are not configured", ErrValidation)
+	}
+	return s.backupTarget.List()
+}
+
+// ReadBackup decodes a backup archive. Encrypted archives are opened with
+// $RAFT3D_ENCRYPTION_KEY.
+func ReadBackup(r io.Reader) (*Backup, error) {
+	buffered := bufio.NewReader(r)
+	r = buffered
+	if head, _ := buffered.Peek(len(encryptedMagic)); bytes.Equal(head, encryptedMagic) {
+		atRest, err := cipherFromEnv()
+		if err != nil {
+			return nil, err
+		}
+		data, err := io.ReadAll(buffered)
+		if err != nil {
+			return nil, err
+		}
+		plain, err := atRest.open(data)
+		if errors.Is(err, ErrEncrypted) {
+			return nil, fmt.Errorf("%w; set $%s", err, EncryptionKeyEnv)
+		}
+		if err != nil {
+			return nil, err
+		}
+		r = bytes.NewReader(plain)
+	}
+
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	defer gz.Close()
+
+	var backup Backup
+	if err := json.NewDecoder(gz).Decode(&backup); err != nil {
+		return nil, err
+	}
+	return &backup, nil
+}
+
+// RestoreBackup seeds an empty data directory with a snapshot holding the
+// backup's state and a single-voter configuration for this node. Starting the
+// node afterwards elects it leader of a new cluster that other nodes can join.
+// The snapshot is encrypted with $RAFT3D_ENCRYPTION_KEY when it is set.
+func RestoreBackup(backup *Backup, nodeID, raftAddr, dataDir string) error {
+	atRest, err := cipherFromEnv()
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(filepath.Join(dataDir, "raft.db")); err == nil {
+		return fmt.Errorf("%s already contains Raft state; restore into an empty directory", dataDir)
+	}
+	if err := os.MkdirAll(dataDir, 0755); err != nil {
+		return err
+	}
+
+	// Persist a starting term so the first election moves past the snapshot's term
+	boltDB, err := raftboltdb.NewBoltStore(filepath.Join(dataDir, "raft.db"))
+	if err != nil {
+		return err
+	}
+	err = boltDB.SetUint64([]byte("CurrentTerm"), 1)
+	if closeErr := boltDB.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	snapshots, err := raft.NewFileSnapshotStore(dataDir, 1, os.Stderr)
+	if err != nil {
+		return err
+	}
+
+	// The snapshot stands in for index 1; the restored log begins after it
+	configuration := raft.Configuration{
+		Servers: []raft.Server{{
+			Suffrage: raft.Voter,
+			ID:       raft.ServerID(nodeID),
+			Address:  raft.ServerAddress(raftAddr),
+		}},
+	}
+	// The transport is only used to encode peer addresses into the snapshot
+	_, transport := raft.NewInmemTransport(raft.ServerAddress(raftAddr))
+	sink, err := snapshots.Create(raft.SnapshotVersionMax, 1, 1, configuration, 1, transport)
+	if err != nil {
+		return err
+	}
+	return (&FSMSnapshot{data: backup.Data, index: 1, cipher: atRest}).Persist(sink)
+}
@@ -0,0 +1,285 @@
+package raft
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/raft"
+)
+
+// cdcCursorKey holds the index of the last log entry exported. Writes to it
+// are not exported themselves.
+const cdcCursorKey = "cdc_cursor"
+
+const (
+	// defaultCDCInterval is how often the leader looks for new entries
+	defaultCDCInterval = time.Second
+
+	// cdcCursorEvery is how many exported entries are published between
+	// cursor writes; a restart republishes at most this many
+	cdcCursorEvery = 100
+
+	// cdcRetryDelay is the longest wait between attempts to publish
+	cdcRetryDelay = 30 * time.Second
+)
+
+// ChangeRecord is an applied command as change data capture publishes it
+type ChangeRecord struct {
+	Index      uint64          `json:"index"`
+	Term       uint64          `json:"term"`
+	AppendedAt time.Time       `json:"appended_at,omitempty"`
+	Command    json.RawMessage `json:"command"`
+}
+
+// ChangePublisher delivers change records downstream. Publish returns once
+// the broker has acknowledged the record; records may be delivered more
+// than once, and IDs let consumers drop the duplicates.
+type ChangePublisher interface {
+	Publish(ctx context.Context, id string, payload []byte) error
+	Close() error
+}
+
+// NewChangePublisher returns a publisher for a nats://host:port/subject
+// JetStream subject or a kafka://host:port/topic Kafka topic
+func NewChangePublisher(location string) (ChangePublisher, error) {
+	u, err := url.Parse(location)
+	if err != nil {
+		return nil, fmt.Errorf("%w: change export %q: %s", ErrValidation, location, err)
+	}
+	name := strings.Trim(u.Path, "/")
+	if u.Host == "" || name == "" {
+		return nil, fmt.Errorf("%w: change export %q needs a host and a subject or topic", ErrValidation, location)
+	}
+	switch u.Scheme {
+	case "nats":
+		return newNATSPublisher(u.Host, name, u.User), nil
+	case "kafka":
+		return newKafkaPublisher(u.Host, name), nil
+	}
+	return nil, fmt.Errorf("%w: change export %q must be a nats:// or kafka:// URL", ErrValidation, location)
+}
+
+// CDCConfig configures change data capture
+type CDCConfig struct {
+	Publisher ChangePublisher
+	Interval  time.Duration // how often to look for new entries (default 1s)
+}
+
+// CDCStatus reports how far change data capture has got
+type CDCStatus struct {
+	Cursor    uint64 // last entry exported
+	Published uint64 // records published since the node started
+	Skipped   uint64 // entries lost to log compaction before export
+	LastError string
+}
+
+// cdcExporter publishes committed log entries in order from the leader
+type cdcExporter struct {
+	store     *RaftStore
+	publisher ChangePublisher
+
+	mutex  sync.Mutex
+	status CDCStatus
+}
+
+// EnableCDC starts publishing every applied command to cfg.Publisher. Only
+// the leader publishes; the cursor is replicated, so a new leader carries on
+// where the old one stopped.
+func (s *RaftStore) EnableCDC(cfg CDCConfig) error {
+	if cfg.Publisher == nil {
+		return fmt.Errorf("%w: change data capture needs a publisher", ErrValidation)
+	}
+	interval := cfg.Interval
+	if interval <= 0 {
+		interval = defaultCDCInterval
+	}
+	s.cdc = &cdcExporter{store: s, publisher: cfg.Publisher}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-s.shutdownCh
+		cancel()
+	}()
+	go func() {
+		defer cfg.Publisher.Close()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if s.IsLeader() {
+					s.cdc.export(ctx)
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return nil
+}
+
+// CDCStatus reports on change data capture, if it is enabled
+func (s *RaftStore) CDCStatus() (CDCStatus, bool) {
+	if s.cdc == nil {
+		return CDCStatus{}, false
+	}
+	s.cdc.mutex.Lock()
+	defer s.cdc.mutex.Unlock()
+	return s.cdc.status, true
+}
+
+// cursor returns the last exported index. Without a cursor, export starts
+// at the oldest entry still in the log.
+func (c *cdcExporter) cursor() (uint64, error) {
+	value, err := c.store.fsm.Get(cdcCursorKey)
+	if errors.Is(err, ErrNotFound) {
+		first, err := c.store.logs.FirstIndex()
+		if first > 0 {
+			first--
+		}
+		return first, err
+	}
+	if err != nil {
+		return 0, err
+	}
+	var cursor struct {
+		Index uint64 `json:"index"`
+	}
+	if err := json.Unmarshal([]byte(value), &cursor); err != nil {
+		return 0, fmt.Errorf("change export cursor: %w", err)
+	}
+	return cursor.Index, nil
+}
+
+// saveCursor replicates the index of the last exported entry
+func (c *cdcExporter) saveCursor(index uint64) error {
+	body, err := json.Marshal(map[string]interface{}{"index": index, "updated_at": time.Now().UTC()})
+	if err != nil {
+		return err
+	}
+	return c.store.Set(cdcCursorKey, string(body))
+}
+
+// export publishes every applied entry after the cursor, retrying each
+// until it is acknowledged or leadership is lost
+func (c *cdcExporter) export(ctx context.Context) {
+	cursor, err := c.cursor()
+	if err != nil {
+		c.fail(err)
+		return
+	}
+	first, err := c.store.logs.FirstIndex()
+	if err != nil {
+		c.fail(err)
+		return
+	}
+	if first > cursor+1 {
+		log.Printf("Change export: entries %d to %d were compacted away before they were exported", cursor+1, first-1)
+		c.mutex.Lock()
+		c.status.Skipped += first - cursor - 1
+		c.mutex.Unlock()
+		cursor = first - 1
+	}
+
+	applied := c.store.AppliedIndex()
+	unsaved := 0
+	for index := cursor + 1; index <= applied; index++ {
+		var entry raft.Log
+		if err := c.store.logs.GetLog(index, &entry); err != nil {
+			c.fail(fmt.Errorf("reading log entry %d: %w", index, err))
+			break
+		}
+		id, payload, ok := changeRecord(&entry)
+		if ok && !c.publish(ctx, id, payload) {
+			break
+		}
+		cursor = index
+		if ok {
+			unsaved++
+		}
+		if unsaved >= cdcCursorEvery {
+			if c.saveCursor(cursor) == nil {
+				unsaved = 0
+			}
+		}
+	}
+
+	// A cursor that only moved over entries that aren't exported isn't
+	// worth a write, which would itself be such an entry
+	if unsaved > 0 {
+		if err := c.saveCursor(cursor); err != nil {
+			c.fail(fmt.Errorf("saving cursor: %w", err))
+		}
+	}
+	c.mutex.Lock()
+	c.status.Cursor = cursor
+	c.mutex.Unlock()
+}
+
+// publish publishes a record, backing off while the broker fails. It gives
+// up, reporting false, when the node stops or stops leading.
+func (c *cdcExporter) publish(ctx context.Context, id string, payload []byte) bool {
+	delay := time.Second
+	for {
+		err := c.publisher.Publish(ctx, id, payload)
+		if err == nil {
+			c.mutex.Lock()
+			c.status.Published++
+			c.status.LastError = ""
+			c.mutex.Unlock()
+			return true
+		}
+		c.fail(err)
+
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return false
+		}
+		if !c.store.IsLeader() {
+			return false
+		}
+		if delay *= 2; delay > cdcRetryDelay {
+			delay = cdcRetryDelay
+		}
+	}
+}
+
+func (c *cdcExporter) fail(err error) {
+	log.Printf("Change export: %s", err)
+	c.mutex.Lock()
+	c.status.LastError = err.Error()
+	c.mutex.Unlock()
+}
+
+// changeRecord encodes a log entry for export, reporting false for entries
+// that aren't exported: configuration changes, no-ops and cursor writes
+func changeRecord(entry *raft.Log) (string, []byte, bool) {
+	if entry.Type != raft.LogCommand {
+		return "", nil, false
+	}
+	var cmd Command
+	if err := json.Unmarshal(entry.Data, &cmd); err != nil {
+		return "", nil, false
+	}
+	if cmd.Op == "set" && cmd.Key == cdcCursorKey {
+		return "", nil, false
+	}
+	payload, err := json.Marshal(ChangeRecord{
+		Index:      entry.Index,
+		Term:       entry.Term,
+		AppendedAt: entry.AppendedAt.UTC(),
+		Command:    entry.Data,
+	})
+	if err != nil {
+		return "", nil, false
+	}
+	return fmt.Sprintf("%d-%d", entry.Term, entry.Index), payload, true
+}
@@ -0,0 +1,305 @@
+package raft
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/raft"
+)
+
+// recordingPublisher keeps what it is sent, failing the first fail calls
+type recordingPublisher struct {
+	mutex   sync.Mutex
+	fail    int
+	ids     []string
+	records []ChangeRecord
+}
+
+func (p *recordingPublisher) Publish(ctx context.Context, id string, payload []byte) error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if p.fail > 0 {
+		p.fail--
+		return errors.New("broker unavailable")
+	}
+	var record ChangeRecord
+	if err := json.Unmarshal(payload, &record); err != nil {
+		return err
+	}
+	p.ids = append(p.ids, id)
+	p.records = append(p.records, record)
+	return nil
+}
+
+func (p *recordingPublisher) Close() error { return nil }
+
+func (p *recordingPublisher) keys() []string {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	var keys []string
+	for _, record := range p.records {
+		var cmd Command
+		json.Unmarshal(record.Command, &cmd)
+		// The node registers itself when it starts leading
+		if !strings.HasPrefix(cmd.Key, "node_") {
+			keys = append(keys, cmd.Key)
+		}
+	}
+	return keys
+}
+
+// startSingleNode starts a one-node in-memory cluster and waits for it to
+// lead
+func startSingleNode(t *testing.T) *RaftStore {
+	t.Helper()
+	addr, transport := raft.NewInmemTransport("")
+	store, err := NewRaftStore(StoreConfig{NodeID: "n1", RaftAddr: string(addr), Bootstrap: true, Transport: transport, InMemory: true, DataDir: t.TempDir()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { store.Close() })
+	waitFor(t, 10*time.Second, store.IsLeader, "node did not become leader")
+	return store
+}
+
+func TestCDCExportsCommittedEntries(t *testing.T) {
+	store := startSingleNode(t)
+	for _, key := range []string{"printer_p1", "printer_p2", "filament_f1"} {
+		if err := store.Set(key, `{}`); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	publisher := &recordingPublisher{fail: 1}
+	store.cdc = &cdcExporter{store: store, publisher: publisher}
+	store.cdc.export(context.Background())
+
+	if got := strings.Join(publisher.keys(), ","); got != "printer_p1,printer_p2,filament_f1" {
+		t.Fatalf("exported %s", got)
+	}
+	for i, record := range publisher.records {
+		if record.Term == 0 || record.Index == 0 || publisher.ids[i] != fmt.Sprintf("%d-%d", record.Term, record.Index) {
+			t.Fatalf("record %d: %+v, id %s", i, record, publisher.ids[i])
+		}
+		if i > 0 && record.Index <= publisher.records[i-1].Index {
+			t.Fatalf("records out of order: %+v", publisher.records)
+		}
+	}
+	status, _ := store.CDCStatus()
+	cursor, err := store.cdc.cursor()
+	last := publisher.records[len(publisher.records)-1]
+	if err != nil || cursor != last.Index || status.Published != uint64(len(publisher.records)) || status.LastError != "" {
+		t.Fatalf("cursor %d, %v, status %+v", cursor, err, status)
+	}
+
+	// A new leader's exporter carries on from the replicated cursor, and
+	// the cursor write itself is not exported
+	store.Set("printer_p3", `{}`)
+	next := &recordingPublisher{}
+	store.cdc = &cdcExporter{store: store, publisher: next}
+	store.cdc.export(context.Background())
+	if got := strings.Join(next.keys(), ","); got != "printer_p3" {
+		t.Fatalf("second run exported %s", got)
+	}
+	exported := len(next.records)
+	store.cdc.export(context.Background())
+	if len(next.records) != exported {
+		t.Fatalf("cursor writes were exported: %s", next.keys())
+	}
+}
+
+func TestNATSPublisher(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	received := make(chan string, 4)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		reader := bufio.NewReader(conn)
+		io.WriteString(conn, "INFO {\"headers\":true,\"max_payload\":1048576}\r\n")
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				return
+			}
+			fields := strings.Fields(line)
+			switch fields[0] {
+			case "PING":
+				io.WriteString(conn, "PONG\r\n")
+			case "HPUB":
+				// HPUB <subject> <reply> <header size> <total size>
+				total, _ := strconv.Atoi(fields[4])
+				headerSize, _ := strconv.Atoi(fields[3])
+				body := make([]byte, total+2)
+				io.ReadFull(reader, body)
+				received <- fields[1] + "|" + string(body[:headerSize]) + "|" + string(body[headerSize:total])
+				ack := `{"stream":"CDC","seq":1}`
+				if fields[1] == "nostream" {
+					fmt.Fprintf(conn, "HMSG %s 1 16 16\r\nNATS/1.0 503\r\n\r\n\r\n", fields[2])
+					continue
+				}
+				// The late answer to an earlier request comes first
+				fmt.Fprintf(conn, "MSG %s.999 1 %d\r\n%s\r\nPING\r\n", strings.TrimSuffix(fields[2], ".1"), len(ack), ack)
+				fmt.Fprintf(conn, "MSG %s 1 %d\r\n%s\r\n", fields[2], len(ack), ack)
+			}
+		}
+	}()
+
+	publisher, err := NewChangePublisher("nats://" + listener.Addr().String() + "/raft3d.changes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer publisher.Close()
+	if err := publisher.Publish(context.Background(), "2-7", []byte(`{"index":7}`)); err != nil {
+		t.Fatal(err)
+	}
+	if got := <-received; got != "raft3d.changes|NATS/1.0\r\nNats-Msg-Id: 2-7\r\n\r\n|{\"index\":7}" {
+		t.Fatalf("server got %q", got)
+	}
+
+	publisher.(*natsPublisher).subject = "nostream"
+	if err := publisher.Publish(context.Background(), "2-8", []byte(`{}`)); !errors.Is(err, errStreamRejected) {
+		t.Fatalf("publish without a stream: %v", err)
+	}
+}
+
+func TestKafkaPublisher(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	host, portText, _ := net.SplitHostPort(listener.Addr().String())
+	port, _ := strconv.Atoi(portText)
+
+	produced := make(chan [2]string, 1)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go serveFakeKafka(t, conn, host, int32(port), produced)
+		}
+	}()
+
+	publisher, err := NewChangePublisher("kafka://" + listener.Addr().String() + "/changes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer publisher.Close()
+	if err := publisher.Publish(context.Background(), "3-9", []byte(`{"index":9}`)); err != nil {
+		t.Fatal(err)
+	}
+	if got := <-produced; got != [2]string{"3-9", `{"index":9}`} {
+		t.Fatalf("broker got %q", got)
+	}
+}
+
+// serveFakeKafka answers metadata requests with itself as the only broker
+// and decodes produced record batches, checking their CRC
+func serveFakeKafka(t *testing.T, conn net.Conn, host string, port int32, produced chan<- [2]string) {
+	defer conn.Close()
+	for {
+		var size [4]byte
+		if _, err := io.ReadFull(conn, size[:]); err != nil {
+			return
+		}
+		req := make([]byte, binary.BigEndian.Uint32(size[:]))
+		io.ReadFull(conn, req)
+		r := kafkaReader{data: req}
+		apiKey, _, correlation := r.int16(), r.int16(), r.int32()
+		r.string() // client ID
+
+		var resp kafkaBuffer
+		resp.int32(correlation)
+		switch apiKey {
+		case kafkaMetadata:
+			resp.int32(0) // throttle
+			resp.int32(1)
+			resp.int32(1)
+			resp.string(host)
+			resp.int32(port)
+			resp.int16(-1) // rack
+			resp.int16(-1) // cluster ID
+			resp.int32(1)  // controller
+			resp.int32(1)
+			resp.int16(0)
+			resp.string("changes")
+			resp.int8(0)
+			resp.int32(1)
+			resp.int16(0)
+			resp.int32(0) // partition
+			resp.int32(1) // leader
+			resp.int32(1)
+			resp.int32(1) // replicas
+			resp.int32(1)
+			resp.int32(1) // in-sync replicas
+		case kafkaProduce:
+			r.int16() // transactional ID
+			if acks := r.int16(); acks != -1 {
+				t.Errorf("acks = %d", acks)
+			}
+			r.int32()
+			r.int32()
+			topic := r.string()
+			r.int32()
+			partition := r.int32()
+			batch := r.next(int(r.int32()))
+			b := kafkaReader{data: batch}
+			b.int64()
+			length := b.int32()
+			b.int32()
+			magic := b.int8()
+			crc := uint32(b.int32())
+			if r.err != nil || b.err != nil || topic != "changes" || partition != 0 || magic != 2 || int(length) != len(batch)-12 ||
+				crc != crc32.Checksum(batch[21:], crc32.MakeTable(crc32.Castagnoli)) {
+				t.Errorf("malformed produce request to %s/%d: magic %d, length %d of %d", topic, partition, magic, length, len(batch))
+				return
+			}
+			b.next(2 + 4 + 8 + 8 + 8 + 2 + 4 + 4)
+			rest := b.data
+			_, n := binary.Varint(rest) // record length
+			rest = rest[n+1:]           // attributes
+			_, n = binary.Varint(rest)  // timestamp delta
+			rest = rest[n:]
+			_, n = binary.Varint(rest) // offset delta
+			rest = rest[n:]
+			keyLength, n := binary.Varint(rest)
+			key := string(rest[n : n+int(keyLength)])
+			rest = rest[n+int(keyLength):]
+			valueLength, n := binary.Varint(rest)
+			produced <- [2]string{key, string(rest[n : n+int(valueLength)])}
+
+			resp.int32(1)
+			resp.string(topic)
+			resp.int32(1)
+			resp.int32(0)
+			resp.int16(0)
+			resp.int64(0)
+			resp.int64(-1)
+			resp.int32(0) // throttle
+		}
+		frame := binary.BigEndian.AppendUint32(nil, uint32(len(resp.bytes())))
+		conn.Write(append(frame, resp.bytes()...))
+	}
+}
@@ -0,0 +1,136 @@
+package raft
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+const (
+	// changelogPrefix precedes the entity key a changelog belongs to, as in
+	// changelog_printjob_<id>
+	changelogPrefix = "changelog_"
+
+	// ChangelogRetentionKey holds the replicated ChangelogRetention. It is
+	// read while applying entries, so every node prunes the same records.
+	ChangelogRetentionKey = "retention_changelog"
+
+	// defaultChangelogEntries is how many records an entity keeps when no
+	// retention is set
+	defaultChangelogEntries = 100
+)
+
+// changelogFields maps the key prefixes that keep a changelog to the JSON
+// field whose transitions are recorded
+var changelogFields = map[string]string{
+	"printjob_": "status",
+}
+
+// ChangelogEntry records one transition of an entity's tracked field
+type ChangelogEntry struct {
+	Index uint64     `json:"raft_index"`
+	At    *time.Time `json:"at,omitempty"` // when the leader appended the entry
+	Actor string     `json:"actor,omitempty"`
+	Field string     `json:"field"`
+	From  string     `json:"from"` // empty when the entity was created
+	To    string     `json:"to"`
+}
+
+// ChangelogRetention bounds the records kept per entity. Zero MaxEntries
+// means the default of 100; zero MaxAgeDays keeps records regardless of age.
+type ChangelogRetention struct {
+	MaxEntries int `json:"max_entries" validate:"gte=0"`
+	MaxAgeDays int `json:"max_age_days" validate:"gte=0"`
+}
+
+// ChangelogKey returns the key the changelog of an entity key is stored under
+func ChangelogKey(key string) string {
+	return changelogPrefix + key
+}
+
+// changelogField returns the field whose transitions are recorded for key,
+// if any
+func changelogField(key string) (string, bool) {
+	field, ok := changelogFields[keyPrefix(key)]
+	return field, ok
+}
+
+// fieldValue returns a field of a JSON object as text, or "" when the value
+// is missing or not an object
+func fieldValue(value string, exists bool, field string) string {
+	if !exists {
+		return ""
+	}
+	var fields map[string]interface{}
+	if err := json.Unmarshal([]byte(value), &fields); err != nil || fields[field] == nil {
+		return ""
+	}
+	return fmt.Sprint(fields[field])
+}
+
+// changelogRetention returns the replicated retention. The caller must hold
+// the mutex.
+func (f *FSM) changelogRetention() ChangelogRetention {
+	var retention ChangelogRetention
+	if value, ok := f.data[ChangelogRetentionKey]; ok {
+		json.Unmarshal([]byte(value), &retention)
+	}
+	if retention.MaxEntries <= 0 {
+		retention.MaxEntries = defaultChangelogEntries
+	}
+	return retention
+}
+
+// recordTransition appends to key's changelog when a write changes its
+// tracked field. Pruning is by the entry's append time, not the clock, so
+// every node keeps the same records. The caller must hold the mutex.
+func (f *FSM) recordTransition(key, field, from, to string) {
+	var entries []ChangelogEntry
+	if value, ok := f.data[ChangelogKey(key)]; ok {
+		json.Unmarshal([]byte(value), &entries)
+	}
+	entry := ChangelogEntry{Index: f.index, Actor: f.actor, Field: field, From: from, To: to}
+	if !f.appendedAt.IsZero() {
+		at := f.appendedAt.UTC()
+		entry.At = &at
+	}
+	entries = append(entries, entry)
+
+	retention := f.changelogRetention()
+	if retention.MaxAgeDays > 0 && entry.At != nil {
+		cutoff := entry.At.AddDate(0, 0, -retention.MaxAgeDays)
+		first := 0
+		for first < len(entries)-1 && entries[first].At != nil && entries[first].At.Before(cutoff) {
+			first++
+		}
+		entries = entries[first:]
+	}
+	if len(entries) > retention.MaxEntries {
+		entries = entries[len(entries)-retention.MaxEntries:]
+	}
+
+	body, _ := json.Marshal(entries)
+	f.put(ChangelogKey(key), string(body))
+}
+
+// Changelog returns the recorded transitions of an entity key, oldest first
+func (f *FSM) Changelog(key string) ([]ChangelogEntry, error) {
+	f.mutex.RLock()
+	defer f.mutex.RUnlock()
+
+	if _, ok := changelogField(key); !ok {
+		return nil, fmt.Errorf("%w: %s keeps no changelog", ErrValidation, key)
+	}
+	entries := []ChangelogEntry{}
+	if value, ok := f.data[ChangelogKey(key)]; ok {
+		if err := json.Unmarshal([]byte(value), &entries); err != nil {
+			return nil, fmt.Errorf("changelog of %s: %w", key, err)
+		}
+	}
+	return entries, nil
+}
+
+// Changelog returns the recorded transitions of an entity key, oldest first
+func (s *RaftStore) Changelog(key string) ([]ChangelogEntry, error) {
+	return s.fsm.Changelog(key)
+}
@@ -0,0 +1,65 @@
+package raft
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/raft"
+)
+
+func TestChangelogRecordsTransitions(t *testing.T) {
+	fsm := NewFSM()
+	start := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	apply := func(index uint64, days int, cmd string) {
+		fsm.Apply(&raft.Log{Index: index, Type: raft.LogCommand, AppendedAt: start.AddDate(0, 0, days), Data: []byte(cmd)})
+	}
+	apply(1, 0, `{"op":"create","key":"printjob_j1","value":"{\"status\":\"Queued\"}","actor":"alice"}`)
+	apply(2, 0, `{"op":"set","key":"printjob_j1","value":"{\"status\":\"Queued\",\"priority\":3}"}`)
+	apply(3, 1, `{"op":"set_many","values":{"printjob_j1":"{\"status\":\"Running\"}"},"actor":"bob"}`)
+	apply(4, 1, `{"op":"set","key":"printjob_j1","value":"{\"status\":\"Done\"}","conditions":[{"key":"printjob_j1","field":"status","equals":"Queued"}]}`)
+
+	entries, err := fsm.Changelog("printjob_j1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, entry := range entries {
+		got = append(got, fmt.Sprintf("%d %s %s %s:%s>%s", entry.Index, entry.At.Format("01-02"), entry.Actor, entry.Field, entry.From, entry.To))
+	}
+	if fmt.Sprint(got) != "[1 03-01 alice status:>Queued 3 03-02 bob status:Queued>Running]" {
+		t.Fatalf("changelog: %q", got)
+	}
+	if _, err := fsm.Changelog("printer_p1"); err == nil {
+		t.Fatal("printers have no changelog")
+	}
+
+	// Retention is replicated, and applied as records are appended
+	apply(5, 2, `{"op":"set","key":"retention_changelog","value":"{\"max_entries\":2,\"max_age_days\":5}"}`)
+	apply(6, 3, `{"op":"set","key":"printjob_j1","value":"{\"status\":\"Failed\"}"}`)
+	if entries, _ := fsm.Changelog("printjob_j1"); len(entries) != 2 || entries[0].Index != 3 || entries[1].Index != 6 {
+		t.Fatalf("after max_entries: %+v", entries)
+	}
+	apply(7, 10, `{"op":"set","key":"printjob_j1","value":"{\"status\":\"Queued\"}"}`)
+	if entries, _ := fsm.Changelog("printjob_j1"); len(entries) != 1 || entries[0].Index != 7 {
+		t.Fatalf("after max_age_days: %+v", entries)
+	}
+
+	apply(8, 10, `{"op":"delete","key":"printjob_j1"}`)
+	if _, err := fsm.Get(ChangelogKey("printjob_j1")); err == nil {
+		t.Fatal("changelog outlived its job")
+	}
+}
+
+func TestChangelogActorFromContext(t *testing.T) {
+	store := startSingleNode(t)
+	ctx := WithActor(context.Background(), "carol")
+	if err := store.WithContext(ctx).Set("printjob_j1", `{"status":"Queued"}`); err != nil {
+		t.Fatal(err)
+	}
+	entries, err := store.Changelog("printjob_j1")
+	if err != nil || len(entries) != 1 || entries[0].Actor != "carol" || entries[0].At == nil {
+		t.Fatalf("changelog: %+v, %v", entries, err)
+	}
+}
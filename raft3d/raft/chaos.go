@@ -0,0 +1,165 @@
+package raft
+
+import (
+	"errors"
+	"io"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/raft"
+)
+
+// errDroppedRPC is returned for RPCs discarded by fault injection
+var errDroppedRPC = errors.New("rpc dropped by chaos injection")
+
+// Chaos holds fault injection settings used to demonstrate failover behavior.
+// It is only wired into a store when chaos mode is enabled.
+type Chaos struct {
+	mutex        sync.Mutex
+	resumed      *sync.Cond
+	applyPaused  bool
+	applyLatency time.Duration
+	dropPercent  int
+}
+
+// ChaosState is a point-in-time view of the fault injection settings
+type ChaosState struct {
+	ApplyPaused    bool  `json:"apply_paused"`
+	ApplyLatencyMs int64 `json:"apply_latency_ms"`
+	DropPercent    int   `json:"drop_rpc_percent"`
+}
+
+// NewChaos creates fault injection settings with everything disabled
+func NewChaos() *Chaos {
+	c := &Chaos{}
+	c.resumed = sync.NewCond(&c.mutex)
+	return c
+}
+
+// State returns the current fault injection settings
+func (c *Chaos) State() ChaosState {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	return ChaosState{
+		ApplyPaused:    c.applyPaused,
+		ApplyLatencyMs: c.applyLatency.Milliseconds(),
+		DropPercent:    c.dropPercent,
+	}
+}
+
+// PauseApply blocks the FSM apply loop until ResumeApply is called
+func (c *Chaos) PauseApply() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.applyPaused = true
+}
+
+// ResumeApply releases a paused FSM apply loop
+func (c *Chaos) ResumeApply() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.applyPaused = false
+	c.resumed.Broadcast()
+}
+
+// SetApplyLatency delays every FSM apply by d
+func (c *Chaos) SetApplyLatency(d time.Duration) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.applyLatency = d
+}
+
+// SetDropPercent drops the given percentage of outgoing Raft RPCs
+func (c *Chaos) SetDropPercent(percent int) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.dropPercent = percent
+}
+
+// Reset disables all fault injection
+func (c *Chaos) Reset() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.applyLatency = 0
+	c.dropPercent = 0
+	c.applyPaused = false
+	c.resumed.Broadcast()
+}
+
+// beforeApply is called by the FSM before applying each entry
+func (c *Chaos) beforeApply() {
+	c.mutex.Lock()
+	for c.applyPaused {
+		c.resumed.Wait()
+	}
+	latency := c.applyLatency
+	c.mutex.Unlock()
+
+	if latency > 0 {
+		time.Sleep(latency)
+	}
+}
+
+// shouldDrop decides whether to drop an outgoing RPC
+func (c *Chaos) shouldDrop() bool {
+	c.mutex.Lock()
+	percent := c.dropPercent
+	c.mutex.Unlock()
+
+	return percent > 0 && rand.Intn(100) < percent
+}
+
+// chaosTransport wraps a transport and drops outgoing RPCs on request
+type chaosTransport struct {
+	raft.Transport
+	chaos *Chaos
+}
+
+// AppendEntriesPipeline is disabled so every AppendEntries passes through the
+// drop check
+func (t *chaosTransport) AppendEntriesPipeline(id raft.ServerID, target raft.ServerAddress) (raft.AppendPipeline, error) {
+	return nil, raft.ErrPipelineReplicationNotSupported
+}
+
+func (t *chaosTransport) AppendEntries(id raft.ServerID, target raft.ServerAddress, args *raft.AppendEntriesRequest, resp *raft.AppendEntriesResponse) error {
+	if t.chaos.shouldDrop() {
+		return errDroppedRPC
+	}
+	return t.Transport.AppendEntries(id, target, args, resp)
+}
+
+func (t *chaosTransport) RequestVote(id raft.ServerID, target raft.ServerAddress, args *raft.RequestVoteRequest, resp *raft.RequestVoteResponse) error {
+	if t.chaos.shouldDrop() {
+		return errDroppedRPC
+	}
+	return t.Transport.RequestVote(id, target, args, resp)
+}
+
+func (t *chaosTransport) InstallSnapshot(id raft.ServerID, target raft.ServerAddress, args *raft.InstallSnapshotRequest, resp *raft.InstallSnapshotResponse, data io.Reader) error {
+	if t.chaos.shouldDrop() {
+		return errDroppedRPC
+	}
+	return t.Transport.InstallSnapshot(id, target, args, resp, data)
+}
+
+func (t *chaosTransport) TimeoutNow(id raft.ServerID, target raft.ServerAddress, args *raft.TimeoutNowRequest, resp *raft.TimeoutNowResponse) error {
+	if t.chaos.shouldDrop() {
+		return errDroppedRPC
+	}
+	return t.Transport.TimeoutNow(id, target, args, resp)
+}
+
+// Close closes the wrapped transport so Raft shutdown still releases it
+func (t *chaosTransport) Close() error {
+	if closer, ok := t.Transport.(raft.WithClose); ok {
+		return closer.Close()
+	}
+	return nil
+}
@@ -0,0 +1,87 @@
+package raft
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"github.com/hashicorp/raft"
+)
+
+// defaultSnapshotRetain is how many snapshots are kept on disk by default
+const defaultSnapshotRetain = 3
+
+// CompactionResult describes the snapshot taken by a compaction and the log
+// range left behind it
+type CompactionResult struct {
+	SnapshotID    string `json:"snapshot_id,omitempty"`
+	SnapshotIndex uint64 `json:"snapshot_index"`
+	FirstLogIndex uint64 `json:"first_log_index"`
+	LastLogIndex  uint64 `json:"last_log_index"`
+	Skipped       bool   `json:"skipped,omitempty"`
+}
+
+// StorageStats reports how much disk the node's Raft state uses
+type StorageStats struct {
+	LogSizeBytes      int64 `json:"log_size_bytes"`
+	SnapshotSizeBytes int64 `json:"snapshot_size_bytes"`
+	SnapshotCount     int   `json:"snapshot_count"`
+}
+
+// Compact takes a snapshot now, which truncates the log down to the
+// configured number of trailing entries. The bolt file keeps its size but
+// reuses the freed pages for new entries.
+func (s *RaftStore) Compact() (CompactionResult, error) {
+	var result CompactionResult
+
+	future := s.raft.Snapshot()
+	switch err := future.Error(); {
+	case errors.Is(err, raft.ErrNothingNewToSnapshot):
+		result.Skipped = true
+	case err != nil:
+		return result, err
+	default:
+		meta, _, err := future.Open()
+		if err != nil {
+			return result, err
+		}
+		result.SnapshotID, result.SnapshotIndex = meta.ID, meta.Index
+	}
+
+	if s.raftBoltStore != nil {
+		result.FirstLogIndex, _ = s.raftBoltStore.FirstIndex()
+		result.LastLogIndex, _ = s.raftBoltStore.LastIndex()
+	}
+	return result, nil
+}
+
+// StorageStats returns the on-disk size of the log and snapshots
+func (s *RaftStore) StorageStats() StorageStats {
+	var stats StorageStats
+	if s.dataDir == "" || s.raftBoltStore == nil {
+		return stats
+	}
+
+	if info, err := os.Stat(filepath.Join(s.dataDir, "raft.db")); err == nil {
+		stats.LogSizeBytes = info.Size()
+	}
+
+	snapshotDir := filepath.Join(s.dataDir, "snapshots")
+	filepath.WalkDir(snapshotDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if d.IsDir() {
+			if filepath.Dir(path) == snapshotDir {
+				stats.SnapshotCount++
+			}
+			return nil
+		}
+		if info, err := d.Info(); err == nil {
+			stats.SnapshotSizeBytes += info.Size()
+		}
+		return nil
+	})
+	return stats
+}
@@ -0,0 +1,53 @@
+package raft
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/raft"
+)
+
+func TestCompactRetainsSnapshotsAndTrailingLogs(t *testing.T) {
+	addr, transport := raft.NewInmemTransport("")
+	store, err := NewRaftStore(StoreConfig{
+		NodeID: "n1", RaftAddr: string(addr), Bootstrap: true, Transport: transport, DataDir: t.TempDir(),
+		SnapshotRetain: 2, TrailingLogs: 2,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { store.Close() })
+	waitFor(t, 10*time.Second, store.IsLeader, "node did not become leader")
+
+	write := func(round int) {
+		t.Helper()
+		for i := 0; i < 5; i++ {
+			if err := store.Set(fmt.Sprintf("printer_p%d_%d", round, i), "{}"); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	// The log keeps TrailingLogs entries up to the snapshot
+	write(1)
+	result, err := store.Compact()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Skipped || result.SnapshotIndex == 0 || result.FirstLogIndex != result.SnapshotIndex-1 || result.LastLogIndex < result.SnapshotIndex {
+		t.Fatalf("compaction kept the wrong log range: %+v", result)
+	}
+
+	// Only the newest SnapshotRetain snapshots stay on disk
+	for round := 2; round <= 3; round++ {
+		write(round)
+		if _, err := store.Compact(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	stats := store.StorageStats()
+	if stats.SnapshotCount != 2 || stats.SnapshotSizeBytes == 0 || stats.LogSizeBytes == 0 {
+		t.Fatalf("storage: %+v", stats)
+	}
+}
@@ -0,0 +1,84 @@
+package raft
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// defaultApplyTimeout is how long a write waits to be committed when
+// StoreConfig.ApplyTimeout is unset
+const defaultApplyTimeout = 10 * time.Second
+
+// actorKey is the context key of the name writes are attributed to
+type actorKey struct{}
+
+// WithActor returns a context whose writes, through WithContext, are
+// attributed to actor in entity changelogs
+func WithActor(ctx context.Context, actor string) context.Context {
+	return context.WithValue(ctx, actorKey{}, actor)
+}
+
+// actorFrom returns the actor ctx attributes writes to, if any
+func actorFrom(ctx context.Context) string {
+	actor, _ := ctx.Value(actorKey{}).(string)
+	return actor
+}
+
+// boundStore is a RaftStore whose writes give up when ctx ends
+type boundStore struct {
+	*RaftStore
+	ctx context.Context
+}
+
+// WithContext returns a view of the store whose writes stop waiting for
+// replication when ctx is canceled or its deadline passes
+func (s *RaftStore) WithContext(ctx context.Context) Store {
+	return &boundStore{RaftStore: s, ctx: ctx}
+}
+
+func (b *boundStore) WithContext(ctx context.Context) Store {
+	return b.RaftStore.WithContext(ctx)
+}
+
+func (b *boundStore) Set(key string, value string) error {
+	return b.set(b.ctx, key, value)
+}
+
+func (b *boundStore) SetIf(key string, value string, conditions ...Condition) error {
+	return b.setIf(b.ctx, key, value, conditions...)
+}
+
+func (b *boundStore) SetMany(values map[string]string, conditions ...Condition) error {
+	return b.setMany(b.ctx, values, conditions...)
+}
+
+func (b *boundStore) Create(key string, value string) error {
+	return b.create(b.ctx, key, value, nil)
+}
+
+func (b *boundStore) CreateWithID(prefix string, value string) (string, error) {
+	return b.createWithID(b.ctx, prefix, value, nil)
+}
+
+func (b *boundStore) CreateAndSet(prefix, id, value string, values map[string]string, conditions ...Condition) (string, error) {
+	return b.createAndSet(b.ctx, prefix, id, value, values, conditions...)
+}
+
+func (b *boundStore) Delete(key string) error {
+	return b.delete(b.ctx, key)
+}
+
+func (b *boundStore) DeleteMany(keys []string, conditions ...Condition) error {
+	return b.deleteMany(b.ctx, keys, conditions...)
+}
+
+// contextError explains why ctx ended. A passed deadline is a replication
+// timeout; a cancellation means the caller went away.
+func contextError(ctx context.Context) error {
+	if errors.Is(ctx.Err(), context.Canceled) {
+		return fmt.Errorf("write abandoned: %w", ctx.Err())
+	}
+	return fmt.Errorf("%w: the caller's deadline passed", ErrReplicationTimeout)
+}
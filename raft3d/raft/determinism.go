@@ -0,0 +1,103 @@
+package raft
+
+import (
+	"fmt"
+	"hash/fnv"
+	"time"
+)
+
+// auditDigestsKept is how many applied entries back a determinism audit can
+// compare digests
+const auditDigestsKept = 4096
+
+// StateDigest is a digest of the whole FSM state right after the entry at
+// Index was applied. Replicas that applied the same log agree on it.
+type StateDigest struct {
+	Index  uint64 `json:"index"`
+	Digest string `json:"digest"`
+}
+
+// stateAudit keeps a running digest of the state, updated with every write,
+// and the digests of recent entries. The FSM's mutex guards it.
+type stateAudit struct {
+	digest uint64
+	recent [auditDigestsKept]struct {
+		index  uint64
+		digest uint64
+	}
+}
+
+// entryDigest hashes one key and its value. The state's digest XORs these,
+// so it doesn't depend on the order keys were written in.
+func entryDigest(key, value string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	h.Write([]byte{0})
+	h.Write([]byte(value))
+	return h.Sum64()
+}
+
+// write updates the digest for a key going from old to value, where either
+// may be absent
+func (a *stateAudit) write(key, old string, existed bool, value string, exists bool) {
+	if existed {
+		a.digest ^= entryDigest(key, old)
+	}
+	if exists {
+		a.digest ^= entryDigest(key, value)
+	}
+}
+
+// reset recomputes the digest from scratch, e.g. after a snapshot restore
+func (a *stateAudit) reset(data map[string]string) {
+	a.digest = 0
+	for key, value := range data {
+		a.digest ^= entryDigest(key, value)
+	}
+}
+
+// record remembers the current digest as the one after index
+func (a *stateAudit) record(index uint64) {
+	slot := &a.recent[index%auditDigestsKept]
+	slot.index, slot.digest = index, a.digest
+}
+
+// at returns the digest recorded after index, if it is still kept
+func (a *stateAudit) at(index uint64) (StateDigest, bool) {
+	slot := a.recent[index%auditDigestsKept]
+	if index == 0 || slot.index != index {
+		return StateDigest{}, false
+	}
+	return StateDigest{Index: index, Digest: fmt.Sprintf("%016x", slot.digest)}, true
+}
+
+// StateDigest returns the digest of the state after the entry at index, or
+// after the last applied entry when index is 0. It reports false when the
+// determinism audit is off or the index isn't among the recent ones kept.
+func (f *FSM) StateDigest(index uint64) (StateDigest, bool) {
+	f.mutex.RLock()
+	defer f.mutex.RUnlock()
+
+	if f.audit == nil {
+		return StateDigest{}, false
+	}
+	if index == 0 {
+		index = f.index
+	}
+	return f.audit.at(index)
+}
+
+// StateDigest returns the digest of this node's state after the entry at
+// index, or its latest when index is 0. It reports false unless the store
+// was opened with DeterminismAudit and the index is recent.
+func (s *RaftStore) StateDigest(index uint64) (StateDigest, bool) {
+	return s.fsm.StateDigest(index)
+}
+
+// commandTime is the time the leader stamps on a command it builds. Applying
+// the command reads this rather than a clock, so every replica stores the
+// same timestamps.
+func commandTime() *time.Time {
+	now := time.Now().UTC()
+	return &now
+}
@@ -0,0 +1,60 @@
+package raft
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/raft"
+)
+
+func TestReplicasAgreeOnStateDigests(t *testing.T) {
+	leaderTime := `"at":"2026-10-20T09:00:00Z"`
+	entries := []string{
+		`{"op":"set","key":"printer_p1","value":"{\"status\":\"Idle\"}",` + leaderTime + `}`,
+		`{"op":"set","key":"printer_p1","value":"{\"status\":\"Printing\"}",` + leaderTime + `}`,
+		`{"op":"create","key":"printer_p1","value":"{}"}`, // rejected
+		`{"op":"delete","key":"printer_p1"}`,
+		`{"op":"set","key":"filament_f1","value":"{}"}`,
+	}
+
+	replicas := []*FSM{NewFSM(), NewFSM()}
+	for _, fsm := range replicas {
+		fsm.audit = &stateAudit{}
+	}
+	for i, data := range entries {
+		for n, fsm := range replicas {
+			// Each replica applies the entry at a different time
+			fsm.Apply(&raft.Log{Index: uint64(i + 1), Type: raft.LogCommand, Data: []byte(data), AppendedAt: time.Now().Add(time.Duration(n) * time.Hour)})
+		}
+		a, okA := replicas[0].StateDigest(uint64(i + 1))
+		b, okB := replicas[1].StateDigest(uint64(i + 1))
+		if !okA || !okB || a != b {
+			t.Fatalf("entry %d: digests %+v and %+v", i+1, a, b)
+		}
+	}
+
+	// Timestamps come from the command, not the replica's clock
+	replicas[0].Apply(&raft.Log{Index: 6, Type: raft.LogCommand, Data: []byte(`{"op":"set","key":"printjob_j2","value":"{\"status\":\"Idle\"}",` + leaderTime + `}`)})
+	if log := replicas[0].data[ChangelogKey("printjob_j2")]; !strings.Contains(log, "2026-10-20T09:00:00Z") {
+		t.Fatalf("changelog = %s", log)
+	}
+
+	// A replica whose state differs has a different digest
+	replicas[1].Apply(&raft.Log{Index: 6, Type: raft.LogCommand, Data: []byte(`{"op":"set","key":"printjob_j2","value":"{}"}`)})
+	a, _ := replicas[0].StateDigest(0)
+	b, _ := replicas[1].StateDigest(0)
+	if a.Index != 6 || a == b {
+		t.Fatalf("diverged replicas agree: %+v and %+v", a, b)
+	}
+
+	// The running digest matches one computed from scratch
+	var fresh stateAudit
+	fresh.reset(replicas[0].data)
+	if fresh.digest != replicas[0].audit.digest {
+		t.Fatal("running digest drifted")
+	}
+	if _, ok := NewFSM().StateDigest(0); ok {
+		t.Fatal("digest without the audit")
+	}
+}
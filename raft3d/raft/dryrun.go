@@ -0,0 +1,64 @@
+package raft
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/hashicorp/raft"
+)
+
+// dryRunKey is the context key marking writes as dry runs
+type dryRunKey struct{}
+
+// WithDryRun returns a context whose writes, through WithContext, are
+// checked against the current state as the FSM would apply them but never
+// committed
+func WithDryRun(ctx context.Context) context.Context {
+	return context.WithValue(ctx, dryRunKey{}, true)
+}
+
+// IsDryRun reports whether writes through ctx are dry runs
+func IsDryRun(ctx context.Context) bool {
+	dryRun, _ := ctx.Value(dryRunKey{}).(bool)
+	return dryRun
+}
+
+// dryRun applies an encoded command to a scratch copy of the state, with the
+// same conditions, policies and checks as Apply, and returns what it would
+// have stored. Nothing is written to the log or the FSM.
+func (f *FSM) dryRun(data []byte) (string, error) {
+	var cmd Command
+	if err := json.Unmarshal(data, &cmd); err != nil {
+		return "", fmt.Errorf("%w: failed to unmarshal command: %s", ErrValidation, err)
+	}
+
+	// Timestamps come from the command, as they would when it is applied;
+	// the local clock stands in only for commands without one
+	appendedAt := time.Now()
+	if cmd.At != nil {
+		appendedAt = *cmd.At
+	}
+
+	f.mutex.RLock()
+	scratch := &FSM{
+		data:           f.copyData(),
+		changes:        make(map[string]uint64),
+		index:          f.index + 1,
+		policies:       f.policies,
+		policiesLoaded: f.policiesLoaded,
+		actor:          cmd.Actor,
+		appendedAt:     appendedAt,
+	}
+	f.mutex.RUnlock()
+
+	// A command that panics is rejected as it would be when applied, not
+	// allowed to take the node down
+	entity, err := scratch.applyIsolated(&raft.Log{Index: scratch.index, Data: data, AppendedAt: appendedAt}, cmd)
+	if err != nil {
+		result := applyResult(scratch.index, "", err)
+		return "", &ApplyError{Code: result.Code, Index: result.Index, Err: result.Err}
+	}
+	return entity, nil
+}
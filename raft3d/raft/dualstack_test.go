@@ -0,0 +1,119 @@
+package raft
+
+import (
+	"fmt"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestValidateAddr(t *testing.T) {
+	tests := []struct {
+		addr  string
+		valid bool
+	}{
+		{"127.0.0.1:9000", true},
+		{"localhost:9000", true},
+		{"[::1]:9000", true},
+		{"[2001:db8::1]:9000", true},
+		{"[fe80::1%eth0]:9000", true},
+		{"::1:9000", false},
+		{"2001:db8::1:9000", false},
+		{"[::1]", false},
+		{"[::1]:", false},
+		{"[zz::1]:9000", false},
+		{"127.0.0.1", false},
+	}
+	for _, tt := range tests {
+		if err := ValidateAddr(tt.addr); (err == nil) != tt.valid {
+			t.Errorf("ValidateAddr(%q) = %v, want valid %v", tt.addr, err, tt.valid)
+		}
+	}
+}
+
+// freePort returns a TCP port that is free on host
+func freePort(t *testing.T, host string) int {
+	t.Helper()
+	l, err := net.Listen("tcp", net.JoinHostPort(host, "0"))
+	if err != nil {
+		t.Skipf("can't listen on %s: %s", host, err)
+	}
+	defer l.Close()
+	return l.Addr().(*net.TCPAddr).Port
+}
+
+func TestMixedIPv4AndIPv6Cluster(t *testing.T) {
+	v4 := fmt.Sprintf("127.0.0.1:%d", freePort(t, "127.0.0.1"))
+	v6 := fmt.Sprintf("[::1]:%d", freePort(t, "::1"))
+	anyPort := freePort(t, "::1")
+
+	start := func(cfg StoreConfig) *RaftStore {
+		t.Helper()
+		cfg.DataDir = t.TempDir()
+		cfg.InMemory = true
+		store, err := NewRaftStore(cfg)
+		if err != nil {
+			t.Fatalf("starting %s on %s: %s", cfg.NodeID, cfg.RaftAddr, err)
+		}
+		t.Cleanup(func() { store.Close() })
+		return store
+	}
+	leader := start(StoreConfig{NodeID: "v4", RaftAddr: v4, HTTPAddr: "127.0.0.1:8001", Bootstrap: true})
+	v6Node := start(StoreConfig{NodeID: "v6", RaftAddr: v6, HTTPAddr: "[::1]:8002"})
+	// Bound to every address and reached over IPv6
+	anyNode := start(StoreConfig{
+		NodeID:            "any",
+		RaftAddr:          fmt.Sprintf("[::]:%d", anyPort),
+		RaftAdvertiseAddr: fmt.Sprintf("[::1]:%d", anyPort),
+		HTTPAddr:          "[::1]:8003",
+	})
+
+	waitFor(t, 10*time.Second, leader.IsLeader, "v4 node did not become leader")
+	if err := leader.Join("v6", v6, "[::1]:8002", true); err != nil {
+		t.Fatalf("joining v6: %s", err)
+	}
+	if err := leader.Join("any", fmt.Sprintf("[::1]:%d", anyPort), "[::1]:8003", true); err != nil {
+		t.Fatalf("joining any: %s", err)
+	}
+
+	if err := leader.Set("printer_p1", `{"id":"p1"}`); err != nil {
+		t.Fatalf("write: %s", err)
+	}
+	for _, follower := range []*RaftStore{v6Node, anyNode} {
+		follower := follower
+		waitFor(t, 10*time.Second, func() bool {
+			value, err := follower.Get("printer_p1")
+			return err == nil && value == `{"id":"p1"}`
+		}, "write did not replicate to an IPv6 member")
+	}
+
+	// The IPv6 members can take over from the IPv4 one
+	if err := leader.TransferLeadership(); err != nil {
+		t.Fatalf("transferring leadership: %s", err)
+	}
+	waitFor(t, 10*time.Second, func() bool { return v6Node.IsLeader() || anyNode.IsLeader() }, "no IPv6 member became leader")
+	newLeader := v6Node
+	if anyNode.IsLeader() {
+		newLeader = anyNode
+	}
+	if info := newLeader.LeaderInfo(); ValidateAddr(info.RaftAddr) != nil || ValidateAddr(info.HTTPAddr) != nil {
+		t.Errorf("leader info has unusable addresses: %+v", info)
+	}
+	if err := newLeader.Set("printer_p2", `{"id":"p2"}`); err != nil {
+		t.Fatalf("write on IPv6 leader: %s", err)
+	}
+	waitFor(t, 10*time.Second, func() bool {
+		value, err := leader.Get("printer_p2")
+		return err == nil && value == `{"id":"p2"}`
+	}, "write on IPv6 leader did not replicate to the IPv4 member")
+}
+
+// waitFor polls cond until it holds, failing the test after timeout
+func waitFor(t *testing.T, timeout time.Duration, cond func() bool, msg string) {
+	t.Helper()
+	for deadline := time.Now().Add(timeout); !cond(); time.Sleep(50 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("%s within %s", msg, timeout)
+		}
+	}
+}
@@ -0,0 +1,175 @@
+package raft
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// jobPrefix precedes the ID of each stored print job
+	jobPrefix = "printjob_"
+
+	// DuplicateJobPolicyKey holds the replicated DuplicateJobPolicy. It is
+	// read while applying entries, so every node makes the same decision.
+	DuplicateJobPolicyKey = "duplicate_jobs"
+
+	// defaultDuplicateJobWindow is how far apart two submissions of the
+	// same job can be to count as duplicates when no window is set
+	defaultDuplicateJobWindow = 5 * time.Minute
+)
+
+// Duplicate job modes
+const (
+	DuplicateJobsWarn   = "warn"   // accept the job, marking it with duplicate_of
+	DuplicateJobsReject = "reject" // refuse the job with ErrDuplicateJob
+	DuplicateJobsOff    = "off"
+)
+
+// DuplicateJobPolicy decides what happens to a new print job with the same
+// printer, file and weight as a Queued one created shortly before it. Empty
+// Mode means warn and zero WindowSeconds five minutes.
+type DuplicateJobPolicy struct {
+	Mode          string `json:"mode" validate:"omitempty,oneof=warn reject off"`
+	WindowSeconds int    `json:"window_seconds" validate:"gte=0"`
+}
+
+// window returns the policy's window, or the default
+func (p DuplicateJobPolicy) window() time.Duration {
+	if p.WindowSeconds <= 0 {
+		return defaultDuplicateJobWindow
+	}
+	return time.Duration(p.WindowSeconds) * time.Second
+}
+
+// jobFingerprint is the part of a stored print job duplicates are found by
+type jobFingerprint struct {
+	PrinterID string    `json:"printer_id"`
+	FilePath  string    `json:"filepath"`
+	Weight    float64   `json:"print_weight_in_grams"`
+	Status    string    `json:"status"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// duplicateJobPolicy returns the replicated policy. The caller must hold the
+// mutex.
+func (f *FSM) duplicateJobPolicy() DuplicateJobPolicy {
+	var policy DuplicateJobPolicy
+	if value, ok := f.data[DuplicateJobPolicyKey]; ok {
+		json.Unmarshal([]byte(value), &policy)
+	}
+	if policy.Mode == "" {
+		policy.Mode = DuplicateJobsWarn
+	}
+	return policy
+}
+
+// checkDuplicateJobs looks for print jobs a command creates that repeat a
+// Queued job. Deciding while applying means a double submission that lands
+// on two nodes at once is still caught. It returns the command to apply,
+// with duplicates marked in warn mode. The caller must hold the mutex.
+func (f *FSM) checkDuplicateJobs(cmd Command) (Command, error) {
+	policy := f.duplicateJobPolicy()
+	if policy.Mode == DuplicateJobsOff {
+		return cmd, nil
+	}
+
+	isNew := func(key string) bool {
+		_, exists := f.data[key]
+		return strings.HasPrefix(key, jobPrefix) && !exists
+	}
+	mark := func(value string) (string, error) {
+		original := f.duplicateOf(value, policy.window())
+		if original == "" {
+			return value, nil
+		}
+		if policy.Mode == DuplicateJobsReject {
+			return "", fmt.Errorf("%w: same printer, file and weight as queued job %s", ErrDuplicateJob, original)
+		}
+		var fields map[string]json.RawMessage
+		if err := json.Unmarshal([]byte(value), &fields); err != nil || fields == nil {
+			return value, nil
+		}
+		fields["duplicate_of"], _ = json.Marshal(original)
+		body, err := json.Marshal(fields)
+		return string(body), err
+	}
+
+	var err error
+	switch cmd.Op {
+	case "create", "set":
+		if isNew(cmd.Key) {
+			if cmd.Value, err = mark(cmd.Value); err != nil {
+				return cmd, err
+			}
+		}
+	case "create_with_id":
+		if cmd.Key == jobPrefix {
+			if cmd.Value, err = mark(cmd.Value); err != nil {
+				return cmd, err
+			}
+		}
+	}
+
+	if len(cmd.Values) > 0 {
+		keys := make([]string, 0, len(cmd.Values))
+		for key := range cmd.Values {
+			if isNew(key) {
+				keys = append(keys, key)
+			}
+		}
+		sort.Strings(keys)
+		if len(keys) > 0 {
+			values := make(map[string]string, len(cmd.Values))
+			for key, value := range cmd.Values {
+				values[key] = value
+			}
+			for _, key := range keys {
+				if values[key], err = mark(values[key]); err != nil {
+					return cmd, err
+				}
+			}
+			cmd.Values = values
+		}
+	}
+	return cmd, nil
+}
+
+// duplicateOf returns the ID of the Queued job, lowest first, that a new
+// job's value repeats, created no more than window before it, or "". The
+// caller must hold the mutex.
+func (f *FSM) duplicateOf(value string, window time.Duration) string {
+	var job jobFingerprint
+	if json.Unmarshal([]byte(value), &job) != nil {
+		return ""
+	}
+	createdAt := job.CreatedAt
+	if createdAt.IsZero() {
+		createdAt = f.appendedAt
+	}
+
+	var matches []string
+	for key, stored := range f.data {
+		if !strings.HasPrefix(key, jobPrefix) {
+			continue
+		}
+		var other jobFingerprint
+		if json.Unmarshal([]byte(stored), &other) != nil || other.Status != "Queued" {
+			continue
+		}
+		if other.PrinterID != job.PrinterID || other.FilePath != job.FilePath || other.Weight != job.Weight {
+			continue
+		}
+		if age := createdAt.Sub(other.CreatedAt); age < 0 || age > window {
+			continue
+		}
+		matches = append(matches, strings.TrimPrefix(key, jobPrefix))
+	}
+	if len(matches) == 0 {
+		return ""
+	}
+	sort.Strings(matches)
+	return matches[0]
+}
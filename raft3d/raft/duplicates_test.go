@@ -0,0 +1,59 @@
+package raft
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/raft"
+)
+
+func TestDuplicateJobsDecidedByFSM(t *testing.T) {
+	fsm := NewFSM()
+	index := uint64(0)
+	apply := func(cmd string) ApplyResult {
+		index++
+		return fsm.Apply(&raft.Log{Index: index, Type: raft.LogCommand, Data: []byte(cmd)}).(ApplyResult)
+	}
+	job := func(status, createdAt string) string {
+		return `{\"printer_id\":\"p1\",\"filepath\":\"clip.gcode\",\"print_weight_in_grams\":12.5,\"status\":\"` + status +
+			`\",\"created_at\":\"2026-10-20T` + createdAt + `:00Z\"}`
+	}
+
+	if result := apply(`{"op":"create","key":"printjob_a","value":"` + job("Queued", "09:00") + `"}`); result.Err != nil {
+		t.Fatal(result.Err)
+	}
+
+	// By default a repeat is accepted and marked
+	result := apply(`{"op":"create_with_id","key":"printjob_","id":"b","value":"` + job("Queued", "09:02") + `"}`)
+	var stored struct {
+		DuplicateOf string `json:"duplicate_of"`
+	}
+	if result.Err != nil || json.Unmarshal([]byte(result.Entity), &stored) != nil || stored.DuplicateOf != "a" {
+		t.Fatalf("repeat not marked: %+v", result)
+	}
+
+	// Outside the window, or repeating a job no longer queued, is no duplicate
+	if result := apply(`{"op":"create","key":"printjob_c","value":"` + job("Queued", "09:30") + `"}`); result.Err != nil || strings.Contains(result.Entity, "duplicate_of") {
+		t.Fatalf("job outside the window: %+v", result)
+	}
+
+	apply(`{"op":"set","key":"` + DuplicateJobPolicyKey + `","value":"{\"mode\":\"reject\",\"window_seconds\":60}"}`)
+	result = apply(`{"op":"set_many","values":{"printjob_d":"` + job("Queued", "09:30") + `"}}`)
+	if !errors.Is(result.Err, ErrDuplicateJob) || !errors.Is(result.Err, ErrConflict) || result.Code != ApplyCodeDuplicateJob {
+		t.Fatalf("duplicate accepted: %+v", result)
+	}
+	if _, err := fsm.Get("printjob_d"); err == nil {
+		t.Fatal("rejected command wrote a job")
+	}
+	apply(`{"op":"set","key":"printjob_c","value":"` + job("Running", "09:30") + `"}`)
+	if result := apply(`{"op":"create","key":"printjob_e","value":"` + job("Queued", "09:30") + `"}`); result.Err != nil {
+		t.Fatalf("repeat of a running job: %+v", result)
+	}
+
+	apply(`{"op":"set","key":"` + DuplicateJobPolicyKey + `","value":"{\"mode\":\"off\"}"}`)
+	if result := apply(`{"op":"create","key":"printjob_f","value":"` + job("Queued", "09:30") + `"}`); result.Err != nil {
+		t.Fatalf("duplicates off: %+v", result)
+	}
+}
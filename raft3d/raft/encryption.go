@@ -0,0 +1,253 @@
+package raft
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/hashicorp/raft"
+)
+
+// EncryptionKeyEnv names the environment variable holding the base64
+// encoded at-rest encryption key when no key file is given. The offline
+// subcommands read it too.
+const EncryptionKeyEnv = "RAFT3D_ENCRYPTION_KEY"
+
+// encryptedMagic starts every encrypted payload. Plain snapshots and
+// commands are JSON and start with '{', so data written before encryption
+// was enabled is still read as is.
+var encryptedMagic = []byte("R3DENC1:")
+
+// ErrEncrypted is returned when encrypted data is read without a key
+var ErrEncrypted = errors.New("data is encrypted and no encryption key is configured")
+
+// LoadEncryptionKey reads the base64 encoded AES key from path or, when path
+// is empty, from $RAFT3D_ENCRYPTION_KEY. It returns nil if neither is set.
+// A KMS or secrets agent can supply the key by writing the file.
+func LoadEncryptionKey(path string) ([]byte, error) {
+	encoded := os.Getenv(EncryptionKeyEnv)
+	source := "$" + EncryptionKeyEnv
+	if path != "" {
+		raw, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		encoded, source = string(raw), path
+	}
+	encoded = strings.TrimSpace(encoded)
+	if encoded == "" {
+		return nil, nil
+	}
+
+	key, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("encryption key in %s is not valid base64: %w", source, err)
+	}
+	if len(key) != 16 && len(key) != 24 && len(key) != 32 {
+		return nil, fmt.Errorf("encryption key in %s is %d bytes; it must be 16, 24 or 32", source, len(key))
+	}
+	return key, nil
+}
+
+// atRestCipher encrypts snapshots, log entries, backups and archives with
+// AES-GCM. A nil
+// cipher leaves data in the clear.
+type atRestCipher struct {
+	aead cipher.AEAD
+}
+
+// newAtRestCipher creates a cipher for key, or returns nil for no key
+func newAtRestCipher(key []byte) (*atRestCipher, error) {
+	if key == nil {
+		return nil, nil
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &atRestCipher{aead: aead}, nil
+}
+
+// cipherFromEnv creates a cipher from $RAFT3D_ENCRYPTION_KEY for the
+// offline subcommands
+func cipherFromEnv() (*atRestCipher, error) {
+	key, err := LoadEncryptionKey("")
+	if err != nil {
+		return nil, err
+	}
+	return newAtRestCipher(key)
+}
+
+// seal encrypts plain as magic, nonce, ciphertext
+func (c *atRestCipher) seal(plain []byte) ([]byte, error) {
+	if c == nil {
+		return plain, nil
+	}
+	nonce := make([]byte, c.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	out := make([]byte, 0, len(encryptedMagic)+len(nonce)+len(plain)+c.aead.Overhead())
+	out = append(out, encryptedMagic...)
+	out = append(out, nonce...)
+	return c.aead.Seal(out, nonce, plain, nil), nil
+}
+
+// open decrypts data written by seal and passes anything else through
+func (c *atRestCipher) open(data []byte) ([]byte, error) {
+	if !bytes.HasPrefix(data, encryptedMagic) {
+		return data, nil
+	}
+	if c == nil {
+		return nil, ErrEncrypted
+	}
+	data = data[len(encryptedMagic):]
+	if len(data) < c.aead.NonceSize() {
+		return nil, errors.New("encrypted data is truncated")
+	}
+	nonce, sealed := data[:c.aead.NonceSize()], data[c.aead.NonceSize():]
+	plain, err := c.aead.Open(nil, nonce, sealed, nil)
+	if err != nil {
+		return nil, fmt.Errorf("cannot decrypt data, wrong key or corrupt: %w", err)
+	}
+	return plain, nil
+}
+
+// encryptedLogStore encrypts the commands in a log store. Configuration
+// and other internal entries stay in the clear so membership tools can
+// read them without the key.
+type encryptedLogStore struct {
+	raft.LogStore
+	cipher *atRestCipher
+}
+
+// GetLog reads and decrypts a log entry
+func (s *encryptedLogStore) GetLog(index uint64, log *raft.Log) error {
+	if err := s.LogStore.GetLog(index, log); err != nil {
+		return err
+	}
+	if log.Type != raft.LogCommand {
+		return nil
+	}
+	data, err := s.cipher.open(log.Data)
+	if err != nil {
+		return fmt.Errorf("log entry %d: %w", index, err)
+	}
+	log.Data = data
+	return nil
+}
+
+// StoreLog encrypts and stores a log entry
+func (s *encryptedLogStore) StoreLog(log *raft.Log) error {
+	return s.StoreLogs([]*raft.Log{log})
+}
+
+// StoreLogs encrypts and stores log entries. Raft keeps using the entries
+// it passes in, so they are copied rather than modified.
+func (s *encryptedLogStore) StoreLogs(logs []*raft.Log) error {
+	sealed := make([]*raft.Log, len(logs))
+	for i, log := range logs {
+		if log.Type != raft.LogCommand {
+			sealed[i] = log
+			continue
+		}
+		data, err := s.cipher.seal(log.Data)
+		if err != nil {
+			return err
+		}
+		entry := *log
+		entry.Data = data
+		sealed[i] = &entry
+	}
+	return s.LogStore.StoreLogs(sealed)
+}
+
+// sealLine encrypts plain for line-oriented files: the magic followed by the
+// base64 encoded nonce and ciphertext, so it contains no newlines
+func (c *atRestCipher) sealLine(plain []byte) ([]byte, error) {
+	if c == nil {
+		return plain, nil
+	}
+	sealed, err := c.seal(plain)
+	if err != nil {
+		return nil, err
+	}
+	body := sealed[len(encryptedMagic):]
+	line := make([]byte, len(encryptedMagic)+base64.StdEncoding.EncodedLen(len(body)))
+	copy(line, encryptedMagic)
+	base64.StdEncoding.Encode(line[len(encryptedMagic):], body)
+	return line, nil
+}
+
+// openLine decrypts a line written by sealLine and passes anything else
+// through
+func (c *atRestCipher) openLine(line []byte) ([]byte, error) {
+	if !bytes.HasPrefix(line, encryptedMagic) {
+		return line, nil
+	}
+	body, err := base64.StdEncoding.DecodeString(string(line[len(encryptedMagic):]))
+	if err != nil {
+		return nil, errTornLine
+	}
+	return c.open(append(append([]byte{}, encryptedMagic...), body...))
+}
+
+// errTornLine reports an encrypted line cut short, e.g. by a crash
+var errTornLine = errors.New("encrypted line is truncated")
+
+// EncryptTarget seals everything written to target with the at-rest key and
+// opens it again when read back. Without a key, target is returned as is.
+func EncryptTarget(target BackupTarget, key []byte) (BackupTarget, error) {
+	atRest, err := newAtRestCipher(key)
+	if err != nil || atRest == nil {
+		return target, err
+	}
+	return &encryptedTarget{BackupTarget: target, cipher: atRest}, nil
+}
+
+// encryptedTarget encrypts the files of a backup target
+type encryptedTarget struct {
+	BackupTarget
+	cipher *atRestCipher
+}
+
+func (t *encryptedTarget) Write(name string, r io.Reader) error {
+	plain, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	sealed, err := t.cipher.seal(plain)
+	if err != nil {
+		return err
+	}
+	return t.BackupTarget.Write(name, bytes.NewReader(sealed))
+}
+
+func (t *encryptedTarget) Open(name string) (io.ReadCloser, error) {
+	f, err := t.BackupTarget.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	data, err := io.ReadAll(f)
+	if err != nil {
+		return nil, err
+	}
+	plain, err := t.cipher.open(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	return io.NopCloser(bytes.NewReader(plain)), nil
+}
@@ -0,0 +1,148 @@
+package raft
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/raft"
+)
+
+// secret is a value that must never appear on disk in the clear
+const secret = "sekrit-spool-name"
+
+func testKey(t *testing.T) []byte {
+	t.Helper()
+	key := bytes.Repeat([]byte{7}, 32)
+	t.Setenv(EncryptionKeyEnv, base64.StdEncoding.EncodeToString(key))
+	return key
+}
+
+// assertNoPlaintext fails if any file under dir contains secret
+func assertNoPlaintext(t *testing.T, dir string) {
+	t.Helper()
+	filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		data, _ := os.ReadFile(path)
+		if bytes.Contains(data, []byte(secret)) {
+			t.Errorf("%s holds data in the clear", path)
+		}
+		return nil
+	})
+}
+
+func TestBackupsAreEncrypted(t *testing.T) {
+	key := testKey(t)
+	atRest, err := newAtRestCipher(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	backup := &Backup{NodeID: "node1", Index: 42, Data: map[string]string{"filament_f1": `{"name":"` + secret + `"}`}}
+
+	archive, err := encodeBackup(backup, atRest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(archive, encryptedMagic) {
+		t.Fatalf("backup is not framed with %s", encryptedMagic)
+	}
+	read, err := ReadBackup(bytes.NewReader(archive))
+	if err != nil {
+		t.Fatalf("ReadBackup: %s", err)
+	}
+	if read.Index != 42 || read.Data["filament_f1"] != backup.Data["filament_f1"] {
+		t.Fatalf("read back %+v", read)
+	}
+
+	t.Setenv(EncryptionKeyEnv, "")
+	if _, err := ReadBackup(bytes.NewReader(archive)); !errors.Is(err, ErrEncrypted) {
+		t.Fatalf("ReadBackup without a key = %v, want ErrEncrypted", err)
+	}
+}
+
+func TestRestoreBackupWritesEncryptedSnapshot(t *testing.T) {
+	testKey(t)
+	dir := t.TempDir()
+	backup := &Backup{Index: 7, Data: map[string]string{"filament_f1": `{"name":"` + secret + `"}`}}
+	if err := RestoreBackup(backup, "node1", "127.0.0.1:9001", dir); err != nil {
+		t.Fatal(err)
+	}
+	assertNoPlaintext(t, dir)
+}
+
+func TestLogArchiveIsEncrypted(t *testing.T) {
+	key := testKey(t)
+	dir := t.TempDir()
+
+	archiver, err := NewLogArchiver(dir, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, cmd := range []string{
+		`{"op":"set","key":"filament_f1","value":"{\"name\":\"` + secret + `\"}"}`,
+		`{"op":"set","key":"printer_p1","value":"{}"}`,
+	} {
+		if err := archiver.Append(&raft.Log{Index: uint64(i + 1), Term: 1, Type: raft.LogCommand, Data: []byte(cmd)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := archiver.Close(); err != nil {
+		t.Fatal(err)
+	}
+	assertNoPlaintext(t, dir)
+
+	reopened, err := NewLogArchiver(dir, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reopened.lastIndex != 2 {
+		t.Fatalf("reopened archive resumes after %d, want 2", reopened.lastIndex)
+	}
+
+	replayed, err := ReplayArchive(dir, nil, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(replayed.Data["filament_f1"], secret) {
+		t.Fatalf("replayed state %v", replayed.Data)
+	}
+
+	if _, err := NewLogArchiver(dir, nil); !errors.Is(err, ErrEncrypted) {
+		t.Fatalf("opening the archive without a key = %v, want ErrEncrypted", err)
+	}
+}
+
+func TestEncryptTarget(t *testing.T) {
+	key := testKey(t)
+	dir := t.TempDir()
+	plain, err := NewDirBackupTarget(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	target, err := EncryptTarget(plain, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := target.Write("printjobs.jsonl.gz", strings.NewReader(secret)); err != nil {
+		t.Fatal(err)
+	}
+	assertNoPlaintext(t, dir)
+
+	f, err := target.Open("printjobs.jsonl.gz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	data, _ := io.ReadAll(f)
+	if string(data) != secret {
+		t.Fatalf("read back %q", data)
+	}
+}
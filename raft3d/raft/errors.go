@@ -0,0 +1,108 @@
+package raft
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/hashicorp/raft"
+)
+
+// Errors returned by the store. Callers should compare with errors.Is since
+// most are wrapped with additional context.
+var (
+	// ErrNotLeader is returned when a write is attempted on a follower
+	ErrNotLeader = errors.New("not leader")
+
+	// ErrNotFound is returned when a key does not exist
+	ErrNotFound = errors.New("not found")
+
+	// ErrConflict is returned when a write conflicts with the current state
+	ErrConflict = errors.New("conflict")
+
+	// ErrAlreadyExists is returned when a create targets a key that is
+	// already stored. It is a kind of ErrConflict.
+	ErrAlreadyExists = fmt.Errorf("%w: already exists", ErrConflict)
+
+	// ErrValidation is returned when a command is malformed
+	ErrValidation = errors.New("validation failed")
+
+	// ErrQuorumLost is returned for writes while the node has had no leader
+	// contact for longer than the quorum loss threshold
+	ErrQuorumLost = errors.New("quorum lost")
+
+	// ErrTimeout is returned when an operation gives up waiting, e.g. for
+	// the node to catch up to a log index
+	ErrTimeout = errors.New("timed out")
+
+	// ErrReplicationTimeout is returned when a write wasn't committed within
+	// the apply timeout or the caller's deadline. The write may still be
+	// committed later. It is a kind of ErrTimeout.
+	ErrReplicationTimeout = fmt.Errorf("%w: replication", ErrTimeout)
+
+	// ErrOverloaded is returned for writes shed while the FSM is too far
+	// behind on applying committed entries. Retrying later may succeed.
+	ErrOverloaded = errors.New("overloaded")
+
+	// ErrNotCaughtUp is returned when promoting a non-voter that is still
+	// too far behind the leader's log. It is a kind of ErrConflict.
+	ErrNotCaughtUp = fmt.Errorf("%w: not caught up", ErrConflict)
+
+	// ErrHistoryUnavailable is returned for reads at an index older than
+	// the history the node keeps
+	ErrHistoryUnavailable = errors.New("history not retained")
+
+	// ErrPolicyViolation is returned when a write breaks a stored policy.
+	// It is a kind of ErrValidation.
+	ErrPolicyViolation = fmt.Errorf("%w: policy violation", ErrValidation)
+
+	// ErrReservationConflict is returned when a reservation overlaps another
+	// of the same printer. It is a kind of ErrConflict.
+	ErrReservationConflict = fmt.Errorf("%w: reservation overlaps", ErrConflict)
+
+	// ErrDuplicateJob is returned when a new print job repeats a queued one
+	// and duplicates are rejected. It is a kind of ErrConflict.
+	ErrDuplicateJob = fmt.Errorf("%w: duplicate job", ErrConflict)
+
+	// ErrInvalidStepTransition is returned when a write moves a print job's
+	// step to a status it can't reach from its current one, or out of
+	// order. It is a kind of ErrConflict.
+	ErrInvalidStepTransition = fmt.Errorf("%w: invalid step transition", ErrConflict)
+
+	// ErrQuarantined is returned for a command that panicked while being
+	// applied. Nothing it wrote was kept and the entry is recorded under
+	// QuarantinePrefix.
+	ErrQuarantined = errors.New("quarantined")
+
+	// ErrIncompatibleVersion is returned for a command or node whose
+	// protocol version is too far from this node's. It is a kind of
+	// ErrValidation.
+	ErrIncompatibleVersion = fmt.Errorf("%w: incompatible protocol version", ErrValidation)
+)
+
+// ApplyError is a command the FSM rejected. It wraps one of the errors above,
+// so errors.Is keeps working, and carries the FSM's failure code.
+type ApplyError struct {
+	Code  string
+	Index uint64
+	Err   error
+}
+
+func (e *ApplyError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *ApplyError) Unwrap() error {
+	return e.Err
+}
+
+// translateApplyError maps errors from raft.Apply onto the store errors
+func translateApplyError(err error) error {
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, raft.ErrNotLeader), errors.Is(err, raft.ErrLeadershipLost),
+		errors.Is(err, raft.ErrLeadershipTransferInProgress):
+		return ErrNotLeader
+	}
+	return err
+}
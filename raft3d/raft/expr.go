@@ -0,0 +1,584 @@
+package raft
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// exprMaxDepth bounds how deeply expressions nest. The parser recurses per
+// level, so this also bounds its stack.
+const exprMaxDepth = 64
+
+// expr is a compiled policy expression, a small subset of CEL: literals
+// (numbers, 'strings' or "strings", true, false, null and [lists]), names
+// with .field and ["field"] access, the operators ! - * / + - < <= > >=
+// == != in && || and ( ), and the functions has(path), size(value) and
+// lower(string). A missing field is null. Every operator is evaluated the
+// same way on every platform, so the FSM can rely on the result.
+type expr interface {
+	eval(vars map[string]interface{}) (interface{}, error)
+}
+
+// compileExpr parses an expression, reporting syntax errors with the column
+// they were found at
+func compileExpr(src string) (expr, error) {
+	p := &exprParser{src: src}
+	e, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	p.skipSpace()
+	if p.pos < len(p.src) {
+		return nil, p.errorf("unexpected %q", p.src[p.pos])
+	}
+	return e, nil
+}
+
+type exprParser struct {
+	src   string
+	pos   int
+	depth int
+}
+
+func (p *exprParser) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("column %d: %s", p.pos+1, fmt.Sprintf(format, args...))
+}
+
+func (p *exprParser) skipSpace() {
+	for p.pos < len(p.src) && strings.IndexByte(" \t\r\n", p.src[p.pos]) >= 0 {
+		p.pos++
+	}
+}
+
+// accept consumes op if it comes next. Word operators must not run into a
+// longer name, and "<" must not be the start of "<=".
+func (p *exprParser) accept(op string) bool {
+	p.skipSpace()
+	if !strings.HasPrefix(p.src[p.pos:], op) {
+		return false
+	}
+	next := p.pos + len(op)
+	if next < len(p.src) {
+		c := p.src[next]
+		if isExprNameChar(op[len(op)-1]) && isExprNameChar(c) {
+			return false
+		}
+		if (op == "<" || op == ">" || op == "!") && c == '=' {
+			return false
+		}
+		if (op == "&" || op == "|") && c == op[0] {
+			return false
+		}
+	}
+	p.pos = next
+	return true
+}
+
+func isExprNameChar(c byte) bool {
+	return c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9'
+}
+
+func (p *exprParser) enter() error {
+	if p.depth++; p.depth > exprMaxDepth {
+		return p.errorf("expression nests more than %d levels", exprMaxDepth)
+	}
+	return nil
+}
+
+func (p *exprParser) parseOr() (expr, error) {
+	if err := p.enter(); err != nil {
+		return nil, err
+	}
+	defer func() { p.depth-- }()
+	left, err := p.parseAnd()
+	for err == nil && p.accept("||") {
+		var right expr
+		if right, err = p.parseAnd(); err == nil {
+			left = &logicalExpr{op: "||", left: left, right: right}
+		}
+	}
+	return left, err
+}
+
+func (p *exprParser) parseAnd() (expr, error) {
+	left, err := p.parseCompare()
+	for err == nil && p.accept("&&") {
+		var right expr
+		if right, err = p.parseCompare(); err == nil {
+			left = &logicalExpr{op: "&&", left: left, right: right}
+		}
+	}
+	return left, err
+}
+
+func (p *exprParser) parseCompare() (expr, error) {
+	left, err := p.parseAdd()
+	if err != nil {
+		return nil, err
+	}
+	for _, op := range []string{"==", "!=", "<=", ">=", "<", ">", "in"} {
+		if p.accept(op) {
+			right, err := p.parseAdd()
+			if err != nil {
+				return nil, err
+			}
+			return &binaryExpr{op: op, left: left, right: right}, nil
+		}
+	}
+	return left, nil
+}
+
+func (p *exprParser) parseAdd() (expr, error) {
+	left, err := p.parseMul()
+	for err == nil {
+		op := ""
+		switch {
+		case p.accept("+"):
+			op = "+"
+		case p.accept("-"):
+			op = "-"
+		default:
+			return left, nil
+		}
+		var right expr
+		if right, err = p.parseMul(); err == nil {
+			left = &binaryExpr{op: op, left: left, right: right}
+		}
+	}
+	return nil, err
+}
+
+func (p *exprParser) parseMul() (expr, error) {
+	left, err := p.parseUnary()
+	for err == nil {
+		op := ""
+		switch {
+		case p.accept("*"):
+			op = "*"
+		case p.accept("/"):
+			op = "/"
+		default:
+			return left, nil
+		}
+		var right expr
+		if right, err = p.parseUnary(); err == nil {
+			left = &binaryExpr{op: op, left: left, right: right}
+		}
+	}
+	return nil, err
+}
+
+func (p *exprParser) parseUnary() (expr, error) {
+	if err := p.enter(); err != nil {
+		return nil, err
+	}
+	defer func() { p.depth-- }()
+	switch {
+	case p.accept("!"):
+		operand, err := p.parseUnary()
+		return &unaryExpr{op: "!", operand: operand}, err
+	case p.accept("-"):
+		operand, err := p.parseUnary()
+		return &unaryExpr{op: "-", operand: operand}, err
+	}
+	return p.parsePostfix()
+}
+
+func (p *exprParser) parsePostfix() (expr, error) {
+	e, err := p.parsePrimary()
+	for err == nil {
+		switch {
+		case p.accept("."):
+			p.skipSpace()
+			name := p.readName()
+			if name == "" {
+				return nil, p.errorf("expected a field name after '.'")
+			}
+			e = &fieldExpr{object: e, field: &literalExpr{value: name}}
+		case p.accept("["):
+			var field expr
+			if field, err = p.parseOr(); err == nil && !p.accept("]") {
+				err = p.errorf("expected ']'")
+			}
+			e = &fieldExpr{object: e, field: field}
+		default:
+			return e, nil
+		}
+	}
+	return nil, err
+}
+
+func (p *exprParser) readName() string {
+	start := p.pos
+	for p.pos < len(p.src) && isExprNameChar(p.src[p.pos]) {
+		p.pos++
+	}
+	return p.src[start:p.pos]
+}
+
+func (p *exprParser) parsePrimary() (expr, error) {
+	p.skipSpace()
+	if p.pos >= len(p.src) {
+		return nil, p.errorf("unexpected end of expression")
+	}
+	switch c := p.src[p.pos]; {
+	case c == '(':
+		p.pos++
+		e, err := p.parseOr()
+		if err == nil && !p.accept(")") {
+			err = p.errorf("expected ')'")
+		}
+		return e, err
+	case c == '[':
+		p.pos++
+		list := &listExpr{}
+		if p.accept("]") {
+			return list, nil
+		}
+		for {
+			item, err := p.parseOr()
+			if err != nil {
+				return nil, err
+			}
+			list.items = append(list.items, item)
+			if p.accept("]") {
+				return list, nil
+			}
+			if !p.accept(",") {
+				return nil, p.errorf("expected ',' or ']'")
+			}
+		}
+	case c == '"' || c == '\'':
+		end := strings.IndexByte(p.src[p.pos+1:], c)
+		if end < 0 {
+			return nil, p.errorf("unterminated string")
+		}
+		value := p.src[p.pos+1 : p.pos+1+end]
+		p.pos += end + 2
+		return &literalExpr{value: value}, nil
+	case c >= '0' && c <= '9':
+		start := p.pos
+		for p.pos < len(p.src) && (p.src[p.pos] >= '0' && p.src[p.pos] <= '9' || p.src[p.pos] == '.') {
+			p.pos++
+		}
+		value, err := strconv.ParseFloat(p.src[start:p.pos], 64)
+		if err != nil {
+			return nil, p.errorf("bad number %q", p.src[start:p.pos])
+		}
+		return &literalExpr{value: value}, nil
+	case isExprNameChar(c):
+		name := p.readName()
+		switch name {
+		case "true", "false":
+			return &literalExpr{value: name == "true"}, nil
+		case "null":
+			return &literalExpr{}, nil
+		case "has", "size", "lower":
+			if !p.accept("(") {
+				return nil, p.errorf("expected '(' after %s", name)
+			}
+			arg, err := p.parseOr()
+			if err != nil {
+				return nil, err
+			}
+			if !p.accept(")") {
+				return nil, p.errorf("expected ')'")
+			}
+			if _, ok := arg.(*fieldExpr); name == "has" && !ok {
+				return nil, p.errorf("has() takes a field, such as has(entity.labels.approved)")
+			}
+			return &callExpr{name: name, arg: arg}, nil
+		}
+		return &nameExpr{name: name}, nil
+	default:
+		return nil, p.errorf("unexpected %q", c)
+	}
+}
+
+type literalExpr struct{ value interface{} }
+
+func (e *literalExpr) eval(map[string]interface{}) (interface{}, error) { return e.value, nil }
+
+type nameExpr struct{ name string }
+
+func (e *nameExpr) eval(vars map[string]interface{}) (interface{}, error) {
+	value, ok := vars[e.name]
+	if !ok {
+		return nil, fmt.Errorf("unknown name %q", e.name)
+	}
+	return value, nil
+}
+
+type listExpr struct{ items []expr }
+
+func (e *listExpr) eval(vars map[string]interface{}) (interface{}, error) {
+	list := make([]interface{}, len(e.items))
+	for i, item := range e.items {
+		value, err := item.eval(vars)
+		if err != nil {
+			return nil, err
+		}
+		list[i] = value
+	}
+	return list, nil
+}
+
+// fieldExpr reads a field of an object; fields of null or of missing
+// objects are null, so optional nested fields need no has()
+type fieldExpr struct {
+	object expr
+	field  expr
+}
+
+func (e *fieldExpr) eval(vars map[string]interface{}) (interface{}, error) {
+	object, err := e.object.eval(vars)
+	if err != nil {
+		return nil, err
+	}
+	field, err := e.field.eval(vars)
+	if err != nil {
+		return nil, err
+	}
+	switch object := object.(type) {
+	case nil:
+		return nil, nil
+	case map[string]interface{}:
+		name, ok := field.(string)
+		if !ok {
+			return nil, fmt.Errorf("fields are named by strings, not %s", typeName(field))
+		}
+		return object[name], nil
+	case []interface{}:
+		index, ok := field.(float64)
+		if !ok || index != float64(int(index)) {
+			return nil, fmt.Errorf("lists are indexed by whole numbers, not %s", typeName(field))
+		}
+		if index < 0 || int(index) >= len(object) {
+			return nil, nil
+		}
+		return object[int(index)], nil
+	default:
+		return nil, fmt.Errorf("%s has no fields", typeName(object))
+	}
+}
+
+type callExpr struct {
+	name string
+	arg  expr
+}
+
+func (e *callExpr) eval(vars map[string]interface{}) (interface{}, error) {
+	value, err := e.arg.eval(vars)
+	if err != nil {
+		return nil, err
+	}
+	switch e.name {
+	case "has":
+		return value != nil, nil
+	case "size":
+		switch value := value.(type) {
+		case string:
+			return float64(len([]rune(value))), nil
+		case []interface{}:
+			return float64(len(value)), nil
+		case map[string]interface{}:
+			return float64(len(value)), nil
+		case nil:
+			return float64(0), nil
+		}
+	case "lower":
+		if s, ok := value.(string); ok {
+			return strings.ToLower(s), nil
+		}
+	}
+	return nil, fmt.Errorf("%s() does not take %s", e.name, typeName(value))
+}
+
+type unaryExpr struct {
+	op      string
+	operand expr
+}
+
+func (e *unaryExpr) eval(vars map[string]interface{}) (interface{}, error) {
+	value, err := e.operand.eval(vars)
+	if err != nil {
+		return nil, err
+	}
+	if e.op == "!" {
+		b, ok := value.(bool)
+		if !ok {
+			return nil, fmt.Errorf("! needs a bool, not %s", typeName(value))
+		}
+		return !b, nil
+	}
+	n, ok := value.(float64)
+	if !ok {
+		return nil, fmt.Errorf("- needs a number, not %s", typeName(value))
+	}
+	return -n, nil
+}
+
+// logicalExpr short-circuits, so "has(x) && x > 1" never compares null
+type logicalExpr struct {
+	op          string
+	left, right expr
+}
+
+func (e *logicalExpr) eval(vars map[string]interface{}) (interface{}, error) {
+	left, err := evalBool(e.op, e.left, vars)
+	if err != nil || left == (e.op == "||") {
+		return left, err
+	}
+	return evalBool(e.op, e.right, vars)
+}
+
+func evalBool(op string, e expr, vars map[string]interface{}) (bool, error) {
+	value, err := e.eval(vars)
+	if err != nil {
+		return false, err
+	}
+	b, ok := value.(bool)
+	if !ok {
+		return false, fmt.Errorf("%s needs bools, not %s", op, typeName(value))
+	}
+	return b, nil
+}
+
+type binaryExpr struct {
+	op          string
+	left, right expr
+}
+
+func (e *binaryExpr) eval(vars map[string]interface{}) (interface{}, error) {
+	left, err := e.left.eval(vars)
+	if err != nil {
+		return nil, err
+	}
+	right, err := e.right.eval(vars)
+	if err != nil {
+		return nil, err
+	}
+	switch e.op {
+	case "==":
+		return exprEqual(left, right), nil
+	case "!=":
+		return !exprEqual(left, right), nil
+	case "in":
+		switch right := right.(type) {
+		case []interface{}:
+			for _, item := range right {
+				if exprEqual(left, item) {
+					return true, nil
+				}
+			}
+			return false, nil
+		case map[string]interface{}:
+			key, ok := left.(string)
+			_, found := right[key]
+			return ok && found, nil
+		case nil:
+			return false, nil
+		}
+		return nil, fmt.Errorf("in needs a list or object, not %s", typeName(right))
+	case "+":
+		if l, ok := left.(string); ok {
+			if r, ok := right.(string); ok {
+				return l + r, nil
+			}
+		}
+	}
+
+	l, lok := left.(float64)
+	r, rok := right.(float64)
+	if !lok || !rok {
+		// Strings order lexically; anything else only compares with ==
+		ls, lok := left.(string)
+		rs, rok := right.(string)
+		if lok && rok {
+			switch e.op {
+			case "<":
+				return ls < rs, nil
+			case "<=":
+				return ls <= rs, nil
+			case ">":
+				return ls > rs, nil
+			case ">=":
+				return ls >= rs, nil
+			}
+		}
+		return nil, fmt.Errorf("%s needs numbers, not %s and %s", e.op, typeName(left), typeName(right))
+	}
+	switch e.op {
+	case "<":
+		return l < r, nil
+	case "<=":
+		return l <= r, nil
+	case ">":
+		return l > r, nil
+	case ">=":
+		return l >= r, nil
+	case "+":
+		return l + r, nil
+	case "-":
+		return l - r, nil
+	case "*":
+		return l * r, nil
+	default:
+		if r == 0 {
+			return nil, fmt.Errorf("division by zero")
+		}
+		return l / r, nil
+	}
+}
+
+// exprEqual compares values of the same type; values of different types
+// are never equal
+func exprEqual(left, right interface{}) bool {
+	switch l := left.(type) {
+	case []interface{}:
+		r, ok := right.([]interface{})
+		if !ok || len(l) != len(r) {
+			return false
+		}
+		for i := range l {
+			if !exprEqual(l[i], r[i]) {
+				return false
+			}
+		}
+		return true
+	case map[string]interface{}:
+		r, ok := right.(map[string]interface{})
+		if !ok || len(l) != len(r) {
+			return false
+		}
+		for key, value := range l {
+			if other, found := r[key]; !found || !exprEqual(value, other) {
+				return false
+			}
+		}
+		return true
+	}
+	switch right.(type) {
+	case []interface{}, map[string]interface{}:
+		return false
+	}
+	return left == right
+}
+
+func typeName(value interface{}) string {
+	switch value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "a bool"
+	case float64:
+		return "a number"
+	case string:
+		return "a string"
+	case []interface{}:
+		return "a list"
+	default:
+		return "an object"
+	}
+}
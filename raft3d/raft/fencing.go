@@ -0,0 +1,96 @@
+package raft
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/raft"
+)
+
+// writeFence holds writes back while a membership change is in flight, so
+// they aren't caught up in a configuration that is still being committed
+type writeFence struct {
+	enabled bool
+
+	mu       sync.Mutex
+	active   int           // changes in flight
+	released chan struct{} // closed when the last change in flight completes
+	since    time.Time     // when the current window opened
+	windows  uint64        // windows opened so far
+	fenced   time.Duration // time spent in closed windows
+	waited   uint64        // writes that were held back
+}
+
+// raise opens a window, or joins the open one, until the returned function
+// is called. It does nothing unless fencing is enabled.
+func (f *writeFence) raise() func() {
+	if !f.enabled {
+		return func() {}
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.active == 0 {
+		f.released = make(chan struct{})
+		f.since = time.Now()
+		f.windows++
+	}
+	f.active++
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			f.mu.Lock()
+			defer f.mu.Unlock()
+			if f.active--; f.active == 0 {
+				f.fenced += time.Since(f.since)
+				close(f.released)
+			}
+		})
+	}
+}
+
+// wait blocks a write while a window is open, for at most timeout or until
+// ctx ends
+func (f *writeFence) wait(ctx context.Context, timeout time.Duration) error {
+	f.mu.Lock()
+	if f.active == 0 {
+		f.mu.Unlock()
+		return nil
+	}
+	released := f.released
+	f.waited++
+	f.mu.Unlock()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case <-released:
+		return nil
+	case <-timer.C:
+		return fmt.Errorf("%w: a membership change was still in flight after %s", ErrReplicationTimeout, timeout)
+	case <-ctx.Done():
+		return contextError(ctx)
+	}
+}
+
+// stats returns whether a window is open, how many have opened, the time
+// spent fenced including the open window, and how many writes waited
+func (f *writeFence) stats() (active bool, windows uint64, fenced time.Duration, waited uint64) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	fenced = f.fenced
+	if f.active > 0 {
+		fenced += time.Since(f.since)
+	}
+	return f.active > 0, f.windows, fenced, f.waited
+}
+
+// changeMembership runs a configuration change, such as AddVoter, with
+// writes fenced until it is committed
+func (s *RaftStore) changeMembership(change func() raft.IndexFuture) error {
+	release := s.fence.raise()
+	defer release()
+	return translateApplyError(change().Error())
+}
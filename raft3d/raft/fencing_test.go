@@ -0,0 +1,41 @@
+package raft
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestWriteFence(t *testing.T) {
+	var off writeFence
+	off.raise()
+	if err := off.wait(context.Background(), time.Millisecond); err != nil {
+		t.Fatalf("disabled fence held a write: %v", err)
+	}
+
+	fence := writeFence{enabled: true}
+	first, second := fence.raise(), fence.raise()
+	if err := fence.wait(context.Background(), 10*time.Millisecond); !errors.Is(err, ErrReplicationTimeout) {
+		t.Fatalf("write got past an open fence: %v", err)
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- fence.wait(context.Background(), 5*time.Second) }()
+	first()
+	first() // releasing twice counts once
+	select {
+	case err := <-done:
+		t.Fatalf("fence lifted with a change still in flight: %v", err)
+	case <-time.After(20 * time.Millisecond):
+	}
+	second()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+
+	active, windows, fenced, waited := fence.stats()
+	if active || windows != 1 || fenced <= 0 || waited != 2 {
+		t.Fatalf("stats = %v %d %s %d", active, windows, fenced, waited)
+	}
+}
@@ -2,54 +2,363 @@ package raft
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	stdlog "log"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/hashicorp/raft"
 )
 
 // Command represents an action to be performed on the key-value store
 type Command struct {
-	Op    string `json:"op"`    // Operation: "set" or "delete"
+	Op    string `json:"op"`    // Operation: "set", "set_many", "create", "create_with_id", "delete" or "delete_many"
 	Key   string `json:"key"`   // Key
 	Value string `json:"value"` // Value (used for "set" operations)
+
+	// Keys are removed together by "delete_many"
+	Keys []string `json:"keys,omitempty"`
+
+	// ID is the leader-generated ID for "create_with_id", whose Key is a
+	// prefix. When empty the FSM uses the log index.
+	ID string `json:"id,omitempty"`
+
+	// Values are written together by "set_many", and alongside the new
+	// entity by "create" and "create_with_id"
+	Values map[string]string `json:"values,omitempty"`
+
+	// Conditions must all hold when the command is applied, otherwise it
+	// fails with ErrConflict and nothing is written
+	Conditions []Condition `json:"conditions,omitempty"`
+
+	// Actor names who asked for the change, for entity changelogs
+	Actor string `json:"actor,omitempty"`
+
+	// At is when the leader built the command. Timestamps the FSM records
+	// come from it, or the entry's AppendedAt for older commands, never
+	// from the applying node's clock.
+	At *time.Time `json:"at,omitempty"`
+
+	// Version is the protocol version of the leader that logged the
+	// command; see CommandVersion
+	Version int `json:"v,omitempty"`
+}
+
+// Condition is a precondition on the stored state. The key must exist and,
+// if Field is set, its JSON value must have that top-level field equal to
+// Equals.
+type Condition struct {
+	Key    string `json:"key"`
+	Field  string `json:"field,omitempty"`
+	Equals string `json:"equals,omitempty"`
+}
+
+// check evaluates the condition. The caller must hold the mutex.
+func (c Condition) check(data map[string]string) error {
+	value, ok := data[c.Key]
+	if !ok {
+		return fmt.Errorf("%w: condition failed: %s does not exist", ErrConflict, c.Key)
+	}
+	if c.Field == "" {
+		return nil
+	}
+
+	var fields map[string]interface{}
+	if err := json.Unmarshal([]byte(value), &fields); err != nil {
+		return fmt.Errorf("%w: condition failed: %s is not a JSON object", ErrConflict, c.Key)
+	}
+	if got := fmt.Sprint(fields[c.Field]); got != c.Equals {
+		return fmt.Errorf("%w: condition failed: %s.%s is %q, want %q", ErrConflict, c.Key, c.Field, got, c.Equals)
+	}
+	return nil
 }
 
 // FSM implements the raft.FSM interface for a key-value store
 type FSM struct {
 	mutex sync.RWMutex
 	data  map[string]string
+	index uint64 // index of the last applied log entry
+
+	// changes holds the index of the last write under each key prefix,
+	// such as "printer_", for long-polling readers. restores counts
+	// snapshot restores, which may change anything.
+	changes  map[string]uint64
+	restores uint64
+
+	archiver *LogArchiver    // optional destination for applied commands
+	chaos    *Chaos          // optional fault injection, nil unless chaos mode is on
+	latency  *latencyMetrics // optional apply latency collection
+	cipher   *atRestCipher   // optional snapshot encryption
+
+	projection *sqlProjection   // optional SQL copy of data for reporting
+	touched    []string         // keys written by the entry being applied
+	history    *entityHistory   // optional recent versions, for reads at an index
+	hooks      *hookRunner      // optional extensions' PostApply hooks
+	recovery   *recoveryTracker // optional report of the replay at startup
+	undo       []undoEntry      // values the entry being applied overwrote
+	audit      *stateAudit      // optional running state digest, to compare replicas
+
+	policies       []compiledPolicy // stored policies, compiled
+	policiesLoaded bool             // policies reflects the stored ones
+
+	actor      string    // who asked for the entry being applied
+	appendedAt time.Time // when the leader appended the entry being applied
 }
 
 // NewFSM creates a new FSM instance
 func NewFSM() *FSM {
 	return &FSM{
-		data: make(map[string]string),
+		data:    make(map[string]string),
+		changes: make(map[string]uint64),
+	}
+}
+
+// Codes reported in ApplyResult.Code
+const (
+	ApplyCodeConflict      = "conflict"
+	ApplyCodeAlreadyExists = "already_exists"
+	ApplyCodeValidation    = "validation_failed"
+	ApplyCodePolicy        = "policy_violation"
+	ApplyCodeReservation   = "reservation_conflict"
+	ApplyCodeDuplicateJob  = "duplicate_job"
+	ApplyCodeStep          = "invalid_step_transition"
+	ApplyCodeQuarantined   = "quarantined"
+	ApplyCodeVersion       = "incompatible_version"
+	ApplyCodeInternal      = "internal_error"
+)
+
+// ApplyResult is the response of FSM.Apply for every command
+type ApplyResult struct {
+	Index  uint64 // log index of the command
+	Entity string // value stored by a set or create, empty otherwise
+	Code   string // machine-readable failure code, empty on success
+	Err    error
+}
+
+// applyResult builds the result of a command, deriving the code from the
+// error
+func applyResult(index uint64, entity string, err error) ApplyResult {
+	result := ApplyResult{Index: index, Entity: entity, Err: err}
+	switch {
+	case err == nil:
+	case errors.Is(err, ErrQuarantined):
+		result.Code, result.Entity = ApplyCodeQuarantined, ""
+	case errors.Is(err, ErrAlreadyExists):
+		result.Code, result.Entity = ApplyCodeAlreadyExists, ""
+	case errors.Is(err, ErrReservationConflict):
+		result.Code, result.Entity = ApplyCodeReservation, ""
+	case errors.Is(err, ErrDuplicateJob):
+		result.Code, result.Entity = ApplyCodeDuplicateJob, ""
+	case errors.Is(err, ErrInvalidStepTransition):
+		result.Code, result.Entity = ApplyCodeStep, ""
+	case errors.Is(err, ErrConflict):
+		result.Code, result.Entity = ApplyCodeConflict, ""
+	case errors.Is(err, ErrPolicyViolation):
+		result.Code, result.Entity = ApplyCodePolicy, ""
+	case errors.Is(err, ErrIncompatibleVersion):
+		result.Code, result.Entity = ApplyCodeVersion, ""
+	case errors.Is(err, ErrValidation):
+		result.Code, result.Entity = ApplyCodeValidation, ""
+	default:
+		result.Code, result.Entity = ApplyCodeInternal, ""
 	}
+	return result
 }
 
-// Apply applies a Raft log entry to the FSM
+// Apply applies a Raft log entry to the FSM and returns an ApplyResult
 func (f *FSM) Apply(log *raft.Log) interface{} {
+	if f.chaos != nil {
+		f.chaos.beforeApply()
+	}
+
 	var cmd Command
 	if err := json.Unmarshal(log.Data, &cmd); err != nil {
-		return fmt.Errorf("failed to unmarshal command: %s", err)
+		err = fmt.Errorf("%w: failed to unmarshal command: %s", ErrValidation, err)
+		if f.recovery != nil {
+			f.recovery.applied(log.Index, err, true)
+		}
+		return applyResult(log.Index, "", err)
+	}
+
+	if f.latency != nil {
+		if !log.AppendedAt.IsZero() {
+			f.latency.observe(f.latency.commit, cmd.Op, time.Since(log.AppendedAt))
+		}
+		defer func(start time.Time) {
+			f.latency.observe(f.latency.fsm, cmd.Op, time.Since(start))
+		}(time.Now())
 	}
 
 	f.mutex.Lock()
 	defer f.mutex.Unlock()
 
+	f.index = log.Index
+
+	if f.archiver != nil {
+		if err := f.archiver.Append(log); err != nil {
+			stdlog.Printf("Failed to archive log entry %d: %s", log.Index, err)
+		}
+	}
+
+	f.touched = f.touched[:0]
+	f.actor, f.appendedAt = cmd.Actor, log.AppendedAt
+	if cmd.At != nil {
+		f.appendedAt = *cmd.At
+	}
+	entity, err := f.applyIsolated(log, cmd)
+	if f.audit != nil {
+		f.audit.record(f.index)
+	}
+	if f.projection != nil {
+		f.projection.changed(f.index, f.touched, f.data)
+	}
+	if f.history != nil {
+		f.history.appliedEntry(f.index)
+	}
+	if f.recovery != nil {
+		f.recovery.applied(log.Index, err, false)
+	}
+	if f.hooks != nil {
+		f.hooks.enqueue(AppliedCommand{Index: log.Index, Term: log.Term, AppendedAt: log.AppendedAt, Command: cmd, Err: err})
+	}
+	return applyResult(log.Index, entity, err)
+}
+
+// applyCommand performs a decoded command and returns the stored value, if
+// any. The caller must hold the mutex.
+func (f *FSM) applyCommand(cmd Command) (string, error) {
+	if err := checkCommandVersion(cmd); err != nil {
+		return "", err
+	}
+	for _, cond := range cmd.Conditions {
+		if err := cond.check(f.data); err != nil {
+			return "", err
+		}
+	}
+	if err := f.checkPolicies(cmd); err != nil {
+		return "", err
+	}
+	if err := f.checkReservations(cmd); err != nil {
+		return "", err
+	}
+	if err := f.checkJobSteps(cmd); err != nil {
+		return "", err
+	}
+	cmd, err := f.checkDuplicateJobs(cmd)
+	if err != nil {
+		return "", err
+	}
+
 	switch cmd.Op {
 	case "set":
-		f.data[cmd.Key] = cmd.Value
-		return nil
+		f.put(cmd.Key, cmd.Value)
+		return cmd.Value, nil
+	case "set_many":
+		f.setValues(cmd.Values)
+		return "", nil
+	case "create":
+		if _, exists := f.data[cmd.Key]; exists {
+			return "", fmt.Errorf("%w: key %s", ErrAlreadyExists, cmd.Key)
+		}
+		f.put(cmd.Key, cmd.Value)
+		f.setValues(cmd.Values)
+		return cmd.Value, nil
+	case "create_with_id":
+		return f.createWithID(cmd)
 	case "delete":
-		delete(f.data, cmd.Key)
-		return nil
+		f.remove(cmd.Key)
+		return "", nil
+	case "delete_many":
+		for _, key := range cmd.Keys {
+			f.remove(key)
+		}
+		return "", nil
 	default:
-		return fmt.Errorf("unknown command operation: %s", cmd.Op)
+		return "", fmt.Errorf("%w: unknown command operation: %s", ErrValidation, cmd.Op)
+	}
+}
+
+// setValues writes every value of a command. The caller must hold the mutex.
+func (f *FSM) setValues(values map[string]string) {
+	for key, value := range values {
+		f.put(key, value)
+	}
+}
+
+// put stores a value, recording a transition of the key's tracked field,
+// if it has one. The caller must hold the mutex.
+func (f *FSM) put(key, value string) {
+	if f.history != nil {
+		f.history.before(key, f.data)
+	}
+	old, existed := f.data[key]
+	f.undo = append(f.undo, undoEntry{key: key, value: old, existed: existed})
+	f.data[key] = value
+	if f.audit != nil {
+		f.audit.write(key, old, existed, value, true)
+	}
+	f.touch(key)
+	if field, ok := changelogField(key); ok {
+		if from, to := fieldValue(old, existed, field), fieldValue(value, true, field); from != to {
+			f.recordTransition(key, field, from, to)
+		}
+	}
+}
+
+// remove deletes a key, and its changelog with it. The caller must hold the
+// mutex.
+func (f *FSM) remove(key string) {
+	if f.history != nil {
+		f.history.before(key, f.data)
+	}
+	old, existed := f.data[key]
+	f.undo = append(f.undo, undoEntry{key: key, value: old, existed: existed})
+	delete(f.data, key)
+	if f.audit != nil {
+		f.audit.write(key, old, existed, "", false)
+	}
+	f.touch(key)
+	if _, ok := changelogField(key); ok {
+		if _, logged := f.data[ChangelogKey(key)]; logged {
+			f.remove(ChangelogKey(key))
+		}
+	}
+}
+
+// keyPrefix returns the prefix a key's changes are tracked under: everything
+// up to and including the first underscore
+func keyPrefix(key string) string {
+	if i := strings.IndexByte(key, '_'); i >= 0 {
+		return key[:i+1]
 	}
+	return key
+}
+
+// touch records a write to key at the current index. The caller must hold
+// the mutex.
+func (f *FSM) touch(key string) {
+	f.changes[keyPrefix(key)] = f.index
+	if strings.HasPrefix(key, PolicyPrefix) {
+		f.policiesLoaded = false
+	}
+	if f.projection != nil {
+		f.touched = append(f.touched, key)
+	}
+	if f.history != nil {
+		f.history.after(f.index, key, f.data)
+	}
+}
+
+// LastChange returns the index of the last write under a key prefix as
+// tracked by keyPrefix, and the number of snapshot restores so far
+func (f *FSM) LastChange(prefix string) (index uint64, restores uint64) {
+	f.mutex.RLock()
+	defer f.mutex.RUnlock()
+
+	return f.changes[prefix], f.restores
 }
 
 // Snapshot returns a snapshot of the FSM
@@ -57,21 +366,47 @@ func (f *FSM) Snapshot() (raft.FSMSnapshot, error) {
 	f.mutex.RLock()
 	defer f.mutex.RUnlock()
 
-	// Copy the data map to make the snapshot
-	data := make(map[string]string)
+	return &FSMSnapshot{data: f.copyData(), index: f.index, cipher: f.cipher}, nil
+}
+
+// copyData returns a copy of the data map. The caller must hold the mutex.
+func (f *FSM) copyData() map[string]string {
+	data := make(map[string]string, len(f.data))
 	for k, v := range f.data {
 		data[k] = v
 	}
+	return data
+}
+
+// State returns a copy of the data map and the index it reflects
+func (f *FSM) State() (map[string]string, uint64) {
+	f.mutex.RLock()
+	defer f.mutex.RUnlock()
+
+	return f.copyData(), f.index
+}
+
+// AppliedIndex returns the index of the last log entry applied to the FSM
+func (f *FSM) AppliedIndex() uint64 {
+	f.mutex.RLock()
+	defer f.mutex.RUnlock()
 
-	return &FSMSnapshot{data: data}, nil
+	return f.index
 }
 
 // Restore restores the FSM to a previous state
 func (f *FSM) Restore(closer io.ReadCloser) error {
 	defer closer.Close()
 
-	data := make(map[string]string)
-	if err := json.NewDecoder(closer).Decode(&data); err != nil {
+	raw, err := io.ReadAll(closer)
+	if err != nil {
+		return err
+	}
+	if raw, err = f.cipher.open(raw); err != nil {
+		return err
+	}
+	data, index, err := decodeSnapshot(raw)
+	if err != nil {
 		return err
 	}
 
@@ -79,6 +414,27 @@ func (f *FSM) Restore(closer io.ReadCloser) error {
 	defer f.mutex.Unlock()
 
 	f.data = data
+	// Snapshots from before the index was recorded leave it to the next
+	// applied entry
+	if index > 0 {
+		f.index = index
+	}
+	f.changes = make(map[string]uint64)
+	f.restores++
+	if f.audit != nil {
+		f.audit.reset(data)
+		f.audit.record(f.index)
+	}
+	if f.recovery != nil {
+		f.recovery.restored(f.index)
+	}
+	f.policiesLoaded = false
+	if f.projection != nil {
+		f.projection.restored(f.index, data)
+	}
+	if f.history != nil {
+		f.history.reset(f.index)
+	}
 	return nil
 }
 
@@ -89,7 +445,7 @@ func (f *FSM) Get(key string) (string, error) {
 
 	value, exists := f.data[key]
 	if !exists {
-		return "", fmt.Errorf("key not found: %s", key)
+		return "", fmt.Errorf("%w: key %s", ErrNotFound, key)
 	}
 	return value, nil
 }
@@ -110,14 +466,54 @@ func (f *FSM) List(prefix string) ([]string, error) {
 
 // FSMSnapshot is a snapshot of the FSM state
 type FSMSnapshot struct {
-	data map[string]string
+	data   map[string]string
+	index  uint64        // last index applied to data
+	cipher *atRestCipher // encrypts the snapshot when set
+}
+
+// snapshotFormat is the version of snapshotEnvelope written by Persist
+const snapshotFormat = 1
+
+// snapshotEnvelope is the content of a snapshot. Older snapshots hold the
+// bare data map.
+type snapshotEnvelope struct {
+	Format int               `json:"raft3d_snapshot"`
+	Index  uint64            `json:"index"`
+	Data   map[string]string `json:"data"`
+}
+
+// decodeSnapshot returns the data of a decrypted snapshot and the index it
+// reflects, which is 0 for snapshots that predate snapshotEnvelope
+func decodeSnapshot(raw []byte) (map[string]string, uint64, error) {
+	var envelope snapshotEnvelope
+	if err := json.Unmarshal(raw, &envelope); err == nil && envelope.Format > 0 {
+		if envelope.Format > snapshotFormat {
+			return nil, 0, fmt.Errorf("snapshot format %d is newer than this node supports", envelope.Format)
+		}
+		if envelope.Data == nil {
+			envelope.Data = make(map[string]string)
+		}
+		return envelope.Data, envelope.Index, nil
+	}
+	data := make(map[string]string)
+	if err := json.Unmarshal(raw, &data); err != nil {
+		return nil, 0, err
+	}
+	return data, 0, nil
 }
 
 // Persist writes the snapshot to the given sink
 func (s *FSMSnapshot) Persist(sink raft.SnapshotSink) error {
 	err := func() error {
-		// Encode data
-		if err := json.NewEncoder(sink).Encode(s.data); err != nil {
+		// Encode data, encrypting it if configured
+		raw, err := json.Marshal(snapshotEnvelope{Format: snapshotFormat, Index: s.index, Data: s.data})
+		if err != nil {
+			return err
+		}
+		if raw, err = s.cipher.seal(raw); err != nil {
+			return err
+		}
+		if _, err := sink.Write(raw); err != nil {
 			return err
 		}
 		return sink.Close()
@@ -131,4 +527,4 @@ func (s *FSMSnapshot) Persist(sink raft.SnapshotSink) error {
 }
 
 // Release is a no-op
-func (s *FSMSnapshot) Release() {}
\ No newline at end of file
+func (s *FSMSnapshot) Release() {}
@@ -0,0 +1,53 @@
+package raft
+
+import (
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/raft"
+)
+
+func TestRestoreRecordsSnapshotIndex(t *testing.T) {
+	fsm := NewFSM()
+	for i, key := range []string{"printer_p1", "printer_p2", "printer_p3"} {
+		cmd := `{"op":"set","key":"` + key + `","value":"{}"}`
+		fsm.Apply(&raft.Log{Index: uint64(10 + i), Term: 1, Type: raft.LogCommand, Data: []byte(cmd)})
+	}
+	snapshot, err := fsm.Snapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	store := raft.NewInmemSnapshotStore()
+	_, transport := raft.NewInmemTransport("")
+	sink, err := store.Create(raft.SnapshotVersionMax, 12, 1, raft.Configuration{}, 1, transport)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := snapshot.Persist(sink); err != nil {
+		t.Fatal(err)
+	}
+	_, rc, err := store.Open(sink.ID())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	restored := NewFSM()
+	if err := restored.Restore(rc); err != nil {
+		t.Fatal(err)
+	}
+	if data, index := restored.State(); index != 12 || len(data) != 3 {
+		t.Fatalf("restored %d keys at index %d, want 3 at 12", len(data), index)
+	}
+}
+
+func TestRestoreReadsSnapshotsWithoutIndex(t *testing.T) {
+	fsm := NewFSM()
+	if err := fsm.Restore(io.NopCloser(strings.NewReader(`{"printer_p1":"{}"}`))); err != nil {
+		t.Fatal(err)
+	}
+	if data, index := fsm.State(); index != 0 || data["printer_p1"] != "{}" {
+		t.Fatalf("restored %v at index %d", data, index)
+	}
+}
@@ -0,0 +1,236 @@
+package raft
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+const (
+	// defaultHistoryEntries is how many log entries back reads at an index
+	// can go when StoreConfig.HistoryEntries is unset
+	defaultHistoryEntries = 10000
+
+	// historyPruneEvery is how many applied entries pass between prunes of
+	// versions that fell out of the window
+	historyPruneEvery = 1024
+)
+
+// keyVersion is the value a key held from index on
+type keyVersion struct {
+	index   uint64
+	value   string
+	deleted bool
+}
+
+// entityHistory keeps the versions keys had over the last window log
+// entries, so reads can be answered as of an earlier index. It lives only in
+// memory: after a restart or a snapshot restore, history starts again at
+// the restored index. The FSM's mutex guards it.
+type entityHistory struct {
+	window   uint64
+	start    uint64 // oldest index reads can be answered at
+	versions map[string][]keyVersion
+	applied  int // entries since the last prune
+}
+
+func newEntityHistory(window uint64) *entityHistory {
+	return &entityHistory{window: window, versions: make(map[string][]keyVersion)}
+}
+
+// before records the value a key had before its first write in the window,
+// from the start of the window on
+func (h *entityHistory) before(key string, data map[string]string) {
+	if _, ok := h.versions[key]; ok {
+		return
+	}
+	value, exists := data[key]
+	h.versions[key] = []keyVersion{{index: h.start, value: value, deleted: !exists}}
+}
+
+// after records the value a key was given by the entry at index
+func (h *entityHistory) after(index uint64, key string, data map[string]string) {
+	value, exists := data[key]
+	version := keyVersion{index: index, value: value, deleted: !exists}
+	versions := h.versions[key]
+	if last := len(versions) - 1; last >= 0 && versions[last].index == index {
+		versions[last] = version
+		return
+	}
+	h.versions[key] = append(versions, version)
+}
+
+// appliedEntry notes that the entry at index was applied, pruning now and then
+func (h *entityHistory) appliedEntry(index uint64) {
+	if h.applied++; h.applied >= historyPruneEvery {
+		h.applied = 0
+		h.prune(index)
+	}
+}
+
+// prune drops versions superseded before the window, and keys whose only
+// version left is the current value
+func (h *entityHistory) prune(index uint64) {
+	if index <= h.window || index-h.window <= h.start {
+		return
+	}
+	h.start = index - h.window
+	for key, versions := range h.versions {
+		first := 0
+		for i, version := range versions {
+			if version.index <= h.start {
+				first = i
+			}
+		}
+		if versions = versions[first:]; len(versions) == 1 && versions[0].index <= h.start {
+			delete(h.versions, key)
+			continue
+		}
+		h.versions[key] = versions
+	}
+}
+
+// reset forgets every version, as after a snapshot restore at index
+func (h *entityHistory) reset(index uint64) {
+	h.start = index
+	h.versions = make(map[string][]keyVersion)
+	h.applied = 0
+}
+
+// get returns the value key had at index, given the current data
+func (h *entityHistory) get(key string, index uint64, data map[string]string) (string, bool) {
+	versions, ok := h.versions[key]
+	if !ok {
+		value, exists := data[key]
+		return value, exists
+	}
+	i := sort.Search(len(versions), func(i int) bool { return versions[i].index > index }) - 1
+	if i < 0 {
+		return "", false
+	}
+	return versions[i].value, !versions[i].deleted
+}
+
+// checkHistoryIndex reports whether reads at index can be answered. The
+// caller must hold the mutex.
+func (f *FSM) checkHistoryIndex(index uint64) error {
+	switch {
+	case f.history == nil:
+		return fmt.Errorf("%w: this node keeps no history", ErrHistoryUnavailable)
+	case index > f.index:
+		return fmt.Errorf("%w: index %d has not been applied yet; this node is at %d", ErrValidation, index, f.index)
+	case index < f.history.start:
+		return fmt.Errorf("%w: the oldest index this node can read at is %d", ErrHistoryUnavailable, f.history.start)
+	}
+	return nil
+}
+
+// GetAt returns the value of key as of the log entry at index
+func (f *FSM) GetAt(key string, index uint64) (string, error) {
+	f.mutex.RLock()
+	defer f.mutex.RUnlock()
+
+	if err := f.checkHistoryIndex(index); err != nil {
+		return "", err
+	}
+	value, ok := f.history.get(key, index, f.data)
+	if !ok {
+		return "", fmt.Errorf("%w: key %s at index %d", ErrNotFound, key, index)
+	}
+	return value, nil
+}
+
+// ListAt returns the keys with a given prefix as of the log entry at index
+func (f *FSM) ListAt(prefix string, index uint64) ([]string, error) {
+	f.mutex.RLock()
+	defer f.mutex.RUnlock()
+
+	if err := f.checkHistoryIndex(index); err != nil {
+		return nil, err
+	}
+	var keys []string
+	for key := range f.data {
+		if _, changed := f.history.versions[key]; !changed && strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	for key := range f.history.versions {
+		if _, ok := f.history.get(key, index, f.data); ok && strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	return keys, nil
+}
+
+// HistoryStart returns the oldest index reads can be answered at, or false
+// when the node keeps no history
+func (f *FSM) HistoryStart() (uint64, bool) {
+	f.mutex.RLock()
+	defer f.mutex.RUnlock()
+
+	if f.history == nil {
+		return 0, false
+	}
+	return f.history.start, true
+}
+
+// historicalStore is a read-only view of the store as of a past index
+type historicalStore struct {
+	*RaftStore
+	index uint64
+}
+
+// AtIndex returns a read-only view of the store as of the log entry at
+// index. Only the last StoreConfig.HistoryEntries entries can be read at;
+// older indexes fail with ErrHistoryUnavailable.
+func (s *RaftStore) AtIndex(index uint64) (Store, error) {
+	s.fsm.mutex.RLock()
+	err := s.fsm.checkHistoryIndex(index)
+	s.fsm.mutex.RUnlock()
+	if err != nil {
+		return nil, err
+	}
+	return &historicalStore{RaftStore: s, index: index}, nil
+}
+
+// errHistoricalWrite rejects writes through a view of the past
+var errHistoricalWrite = fmt.Errorf("%w: a view at an earlier index is read-only", ErrValidation)
+
+func (h *historicalStore) Get(key string) (string, error) {
+	return h.fsm.GetAt(key, h.index)
+}
+
+func (h *historicalStore) List(prefix string) ([]string, error) {
+	return h.fsm.ListAt(prefix, h.index)
+}
+
+func (h *historicalStore) Set(key string, value string) error { return errHistoricalWrite }
+
+func (h *historicalStore) SetIf(key string, value string, conditions ...Condition) error {
+	return errHistoricalWrite
+}
+
+func (h *historicalStore) SetMany(values map[string]string, conditions ...Condition) error {
+	return errHistoricalWrite
+}
+
+func (h *historicalStore) Create(key string, value string) error { return errHistoricalWrite }
+
+func (h *historicalStore) CreateWithID(prefix string, value string) (string, error) {
+	return "", errHistoricalWrite
+}
+
+func (h *historicalStore) CreateAndSet(prefix, id, value string, values map[string]string, conditions ...Condition) (string, error) {
+	return "", errHistoricalWrite
+}
+
+func (h *historicalStore) Delete(key string) error { return errHistoricalWrite }
+
+func (h *historicalStore) DeleteMany(keys []string, conditions ...Condition) error {
+	return errHistoricalWrite
+}
+
+func (h *historicalStore) WithContext(ctx context.Context) Store { return h }
+
+func (h *historicalStore) AppliedIndex() uint64 { return h.index }
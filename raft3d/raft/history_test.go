@@ -0,0 +1,89 @@
+package raft
+
+import (
+	"errors"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/raft"
+)
+
+func TestReadsAtIndex(t *testing.T) {
+	fsm := NewFSM()
+	fsm.history = newEntityHistory(3)
+	for i, cmd := range []string{
+		`{"op":"set","key":"printjob_a","value":"Queued"}`,
+		`{"op":"set","key":"printjob_b","value":"Queued"}`,
+		`{"op":"set","key":"printjob_a","value":"Running"}`,
+		`{"op":"delete","key":"printjob_b"}`,
+		`{"op":"set_many","values":{"printjob_a":"Done","printjob_c":"Queued"}}`,
+	} {
+		fsm.Apply(&raft.Log{Index: uint64(i + 1), Type: raft.LogCommand, Data: []byte(cmd)})
+	}
+
+	list := func(index uint64) string {
+		keys, err := fsm.ListAt("printjob_", index)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sort.Strings(keys)
+		var values []string
+		for _, key := range keys {
+			value, err := fsm.GetAt(key, index)
+			if err != nil {
+				t.Fatal(err)
+			}
+			values = append(values, strings.TrimPrefix(key, "printjob_")+"="+value)
+		}
+		return strings.Join(values, ",")
+	}
+	for index, want := range map[uint64]string{
+		0: "",
+		1: "a=Queued",
+		2: "a=Queued,b=Queued",
+		3: "a=Running,b=Queued",
+		4: "a=Running",
+		5: "a=Done,c=Queued",
+	} {
+		if got := list(index); got != want {
+			t.Errorf("at %d: %s, want %s", index, got, want)
+		}
+	}
+	if _, err := fsm.GetAt("printjob_b", 4); !errors.Is(err, ErrNotFound) {
+		t.Errorf("deleted job at 4: %v", err)
+	}
+	if _, err := fsm.GetAt("printjob_a", 6); !errors.Is(err, ErrValidation) {
+		t.Errorf("read ahead of the log: %v", err)
+	}
+
+	// Pruning keeps the window and drops what only the past needs
+	fsm.history.prune(5)
+	if _, err := fsm.GetAt("printjob_a", 1); !errors.Is(err, ErrHistoryUnavailable) {
+		t.Errorf("read before the window: %v", err)
+	}
+	if got := list(2); got != "a=Queued,b=Queued" {
+		t.Errorf("at 2 after pruning: %s", got)
+	}
+	fsm.history.prune(8)
+	if len(fsm.history.versions) != 0 {
+		t.Errorf("keys unchanged within the window are still versioned: %v", fsm.history.versions)
+	}
+	if got := list(5); got != "a=Done,c=Queued" {
+		t.Errorf("at 5 after pruning: %s", got)
+	}
+
+	if err := fsm.Restore(nopCloser{strings.NewReader(`{"raft3d_snapshot":1,"index":9,"data":{"printjob_z":"Queued"}}`)}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fsm.GetAt("printjob_z", 8); !errors.Is(err, ErrHistoryUnavailable) {
+		t.Errorf("read before a restore: %v", err)
+	}
+	if got := list(9); got != "z=Queued" {
+		t.Errorf("at 9 after restore: %s", got)
+	}
+}
+
+type nopCloser struct{ *strings.Reader }
+
+func (nopCloser) Close() error { return nil }
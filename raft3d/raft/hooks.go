@@ -0,0 +1,260 @@
+package raft
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"maps"
+	"plugin"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+)
+
+// hookBacklog is how many applied commands may wait for PostApply hooks
+// before writes through this node are shed with ErrOverloaded. Applied
+// commands are queued however far behind the hooks are, so none is skipped.
+const hookBacklog = 4096
+
+// PluginSymbol is the variable a Go plugin exports for LoadPlugin: an
+// Extension, or a []Extension for several
+const PluginSymbol = "Raft3DExtension"
+
+// AppliedCommand is a command the FSM applied, as handed to PostApply hooks
+type AppliedCommand struct {
+	Index      uint64
+	Term       uint64
+	AppendedAt time.Time
+	Command    Command
+	Err        error // why the command changed nothing, e.g. a failed condition
+	Leader     bool  // whether this node led the cluster when the hook ran
+}
+
+// Extension adds behaviour to the store for some command operations, such
+// as business rules or syncing changes to an ERP. Extensions are compiled
+// in and registered from an init function, or loaded as Go plugins.
+type Extension struct {
+	Name  string
+	Ops   []string // operations handled, such as "set" or "delete_many"; empty for all
+	Order int      // lower runs first; ties run in registration order
+
+	// Validate, when set, runs on the node proposing a command, before it
+	// is logged; an error rejects the write with ErrValidation. It sees
+	// the command only, so rules about current state belong in Conditions.
+	Validate func(cmd Command) error
+
+	// PostApply, when set, is called with every applied command in log
+	// order, after the FSM has let go of its state, so it can't change
+	// it. Every node calls it, again for entries replayed at startup, so
+	// a sync to another system should act only while Leader and be
+	// idempotent. A panic is recovered and counted against the extension.
+	PostApply func(cmd AppliedCommand)
+}
+
+func (e Extension) handles(op string) bool {
+	return len(e.Ops) == 0 || slices.Contains(e.Ops, op)
+}
+
+// registry holds the extensions stores created from now on will run
+var registry struct {
+	mutex      sync.Mutex
+	extensions []Extension
+}
+
+// RegisterExtension adds an extension to every store created afterwards
+func RegisterExtension(ext Extension) error {
+	if ext.Name == "" {
+		return fmt.Errorf("%w: an extension needs a name", ErrValidation)
+	}
+	if ext.Validate == nil && ext.PostApply == nil {
+		return fmt.Errorf("%w: extension %s has neither Validate nor PostApply", ErrValidation, ext.Name)
+	}
+	registry.mutex.Lock()
+	defer registry.mutex.Unlock()
+	for _, registered := range registry.extensions {
+		if registered.Name == ext.Name {
+			return fmt.Errorf("%w: extension %s is already registered", ErrConflict, ext.Name)
+		}
+	}
+	registry.extensions = append(registry.extensions, ext)
+	return nil
+}
+
+// LoadPlugin opens a Go plugin built with -buildmode=plugin against the
+// same raft3d source and registers the extensions it exports as
+// PluginSymbol
+func LoadPlugin(path string) error {
+	p, err := plugin.Open(path)
+	if err != nil {
+		return fmt.Errorf("plugin %s: %w", path, err)
+	}
+	symbol, err := p.Lookup(PluginSymbol)
+	if err != nil {
+		return fmt.Errorf("plugin %s: %w", path, err)
+	}
+	var extensions []Extension
+	switch exported := symbol.(type) {
+	case *Extension:
+		extensions = []Extension{*exported}
+	case *[]Extension:
+		extensions = *exported
+	default:
+		return fmt.Errorf("%w: plugin %s exports %s as %T, not an Extension or []Extension", ErrValidation, path, PluginSymbol, symbol)
+	}
+	for _, ext := range extensions {
+		if err := RegisterExtension(ext); err != nil {
+			return fmt.Errorf("plugin %s: %w", path, err)
+		}
+	}
+	return nil
+}
+
+// registeredExtensions returns the registered extensions in running order
+func registeredExtensions() []Extension {
+	registry.mutex.Lock()
+	extensions := slices.Clone(registry.extensions)
+	registry.mutex.Unlock()
+	sort.SliceStable(extensions, func(i, j int) bool { return extensions[i].Order < extensions[j].Order })
+	return extensions
+}
+
+// hookRunner calls the extensions of a store. PostApply hooks run on a
+// goroutine of their own, one command at a time in log order, so a slow or
+// failing hook never holds up or breaks the FSM.
+type hookRunner struct {
+	extensions []Extension
+	leading    func() bool
+	done       chan struct{}
+
+	mutex    sync.Mutex
+	ready    *sync.Cond       // signalled when a command is queued or the runner stops
+	queue    []AppliedCommand // applied commands the hooks haven't run for yet
+	stopped  bool
+	failures map[string]uint64 // panics per extension
+}
+
+// newHookRunner returns nil when no extension is registered
+func newHookRunner(extensions []Extension) *hookRunner {
+	if len(extensions) == 0 {
+		return nil
+	}
+	h := &hookRunner{
+		extensions: extensions,
+		done:       make(chan struct{}),
+		failures:   make(map[string]uint64),
+	}
+	h.ready = sync.NewCond(&h.mutex)
+	return h
+}
+
+// validate runs the Validate hooks for a command about to be proposed
+func (h *hookRunner) validate(cmd Command) error {
+	for _, ext := range h.extensions {
+		if ext.Validate == nil || !ext.handles(cmd.Op) {
+			continue
+		}
+		if err := h.call(ext, func() error { return ext.Validate(cloneCommand(cmd)) }); err != nil {
+			return fmt.Errorf("%w: %s: %s", ErrValidation, ext.Name, err)
+		}
+	}
+	return nil
+}
+
+// enqueue hands an applied command to the hooks without blocking. The FSM
+// calls it with its mutex held.
+func (h *hookRunner) enqueue(cmd AppliedCommand) {
+	h.mutex.Lock()
+	h.queue = append(h.queue, cmd)
+	if len(h.queue) == hookBacklog+1 {
+		log.Printf("Apply hooks are %d entries behind at entry %d; shedding writes until they catch up", hookBacklog, cmd.Index)
+	}
+	h.mutex.Unlock()
+	h.ready.Signal()
+}
+
+// next waits for the oldest queued command. It reports false once the
+// runner is stopped and everything queued has run.
+func (h *hookRunner) next() (AppliedCommand, bool) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	for len(h.queue) == 0 && !h.stopped {
+		h.ready.Wait()
+	}
+	if len(h.queue) == 0 {
+		return AppliedCommand{}, false
+	}
+	cmd := h.queue[0]
+	h.queue[0] = AppliedCommand{}
+	h.queue = h.queue[1:]
+	return cmd, true
+}
+
+// backlog returns how many applied commands wait for the hooks
+func (h *hookRunner) backlog() int {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	return len(h.queue)
+}
+
+// start runs the PostApply hooks until stop
+func (h *hookRunner) start(leading func() bool) {
+	h.leading = leading
+	go func() {
+		defer close(h.done)
+		for {
+			cmd, ok := h.next()
+			if !ok {
+				return
+			}
+			cmd.Leader = h.leading()
+			for _, ext := range h.extensions {
+				if ext.PostApply == nil || !ext.handles(cmd.Command.Op) {
+					continue
+				}
+				applied := cmd
+				applied.Command = cloneCommand(cmd.Command)
+				h.call(ext, func() error { ext.PostApply(applied); return nil })
+			}
+		}
+	}()
+}
+
+// call runs a hook, turning a panic into an error and counting it
+func (h *hookRunner) call(ext Extension, hook func() error) (err error) {
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			log.Printf("Extension %s panicked: %v", ext.Name, recovered)
+			h.mutex.Lock()
+			h.failures[ext.Name]++
+			h.mutex.Unlock()
+			err = errors.New("extension failed")
+		}
+	}()
+	return hook()
+}
+
+// stop lets the hooks finish what is queued
+func (h *hookRunner) stop() {
+	h.mutex.Lock()
+	h.stopped = true
+	h.mutex.Unlock()
+	h.ready.Broadcast()
+	<-h.done
+}
+
+// status returns the commands waiting for the hooks and the panics per
+// extension
+func (h *hookRunner) status() (int, map[string]uint64) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	return len(h.queue), maps.Clone(h.failures)
+}
+
+// cloneCommand copies a command so one hook can't change what the next sees
+func cloneCommand(cmd Command) Command {
+	cmd.Values = maps.Clone(cmd.Values)
+	cmd.Keys = slices.Clone(cmd.Keys)
+	cmd.Conditions = slices.Clone(cmd.Conditions)
+	return cmd
+}
@@ -0,0 +1,146 @@
+package raft
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// withExtensions registers extensions for the stores a test creates
+func withExtensions(t *testing.T, extensions ...Extension) {
+	t.Helper()
+	registry.mutex.Lock()
+	saved := registry.extensions
+	registry.extensions = nil
+	registry.mutex.Unlock()
+	t.Cleanup(func() {
+		registry.mutex.Lock()
+		registry.extensions = saved
+		registry.mutex.Unlock()
+	})
+	for _, ext := range extensions {
+		if err := RegisterExtension(ext); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestApplyHooks(t *testing.T) {
+	var (
+		mutex sync.Mutex
+		calls []string
+	)
+	record := func(call string) {
+		mutex.Lock()
+		calls = append(calls, call)
+		mutex.Unlock()
+	}
+	withExtensions(t,
+		Extension{
+			Name: "erp",
+			Ops:  []string{"set", "delete"},
+			Validate: func(cmd Command) error {
+				if cmd.Op == "set" && strings.HasPrefix(cmd.Key, "printer_") && cmd.Value == "" {
+					return errors.New("printers need a value")
+				}
+				return nil
+			},
+			PostApply: func(cmd AppliedCommand) {
+				if !strings.HasPrefix(cmd.Command.Key, "node_") {
+					cmd.Command.Key = "changed"
+					record("erp " + cmd.Command.Op + " " + cmd.Command.Key)
+				}
+			},
+		},
+		Extension{
+			Name:  "audit",
+			Order: -1,
+			PostApply: func(cmd AppliedCommand) {
+				if cmd.Command.Key == "printer_boom" {
+					panic("boom")
+				}
+				if !strings.HasPrefix(cmd.Command.Key, "node_") {
+					record("audit " + cmd.Command.Op + " " + cmd.Command.Key)
+				}
+			},
+		},
+	)
+	if err := RegisterExtension(Extension{Name: "erp", PostApply: func(AppliedCommand) {}}); !errors.Is(err, ErrConflict) {
+		t.Fatalf("duplicate name: %v", err)
+	}
+
+	store := startSingleNode(t)
+	if err := store.Set("printer_p1", ""); !errors.Is(err, ErrValidation) || !strings.Contains(err.Error(), "erp: printers need a value") {
+		t.Fatalf("validation: %v", err)
+	}
+	store.Set("printer_p1", "{}")
+	store.Set("printer_boom", "{}")
+	store.SetMany(map[string]string{"filament_f1": "{}"})
+	if err := store.Delete("printer_p1"); err != nil {
+		t.Fatal(err)
+	}
+
+	want := "audit set printer_p1,erp set changed,erp set changed,audit set_many ,audit delete printer_p1,erp delete changed"
+	waitFor(t, 5*time.Second, func() bool {
+		mutex.Lock()
+		defer mutex.Unlock()
+		return strings.Join(calls, ",") == want
+	}, "hooks were not called in order")
+
+	// The panic was contained, and the FSM kept the write
+	if _, err := store.Get("printer_boom"); err != nil {
+		t.Fatal(err)
+	}
+	if failures := store.Metrics()["apply_hook_failures"].(map[string]uint64); failures["audit"] != 1 {
+		t.Fatalf("failures: %v", failures)
+	}
+}
+
+func TestApplyHooksShedWritesInsteadOfDropping(t *testing.T) {
+	var (
+		mutex sync.Mutex
+		seen  []uint64
+	)
+	release := make(chan struct{})
+	withExtensions(t, Extension{
+		Name: "slow",
+		Ops:  []string{"noop"},
+		PostApply: func(cmd AppliedCommand) {
+			<-release
+			mutex.Lock()
+			seen = append(seen, cmd.Index)
+			mutex.Unlock()
+		},
+	})
+	store := startSingleNode(t)
+
+	// Hooks more than hookBacklog entries behind shed writes, but every
+	// queued command still reaches them, in order. The first one queued may
+	// already be running and no longer count as behind.
+	const queued = hookBacklog + 2
+	for i := 1; i <= queued; i++ {
+		store.fsm.hooks.enqueue(AppliedCommand{Index: uint64(i), Command: Command{Op: "noop"}})
+	}
+	if err := store.Set("printer_p1", "{}"); !errors.Is(err, ErrOverloaded) {
+		close(release)
+		t.Fatalf("write while hooks are behind: %v", err)
+	}
+	close(release)
+	waitFor(t, 10*time.Second, func() bool { return store.fsm.hooks.backlog() == 0 }, "hooks did not catch up")
+	if err := store.Set("printer_p1", "{}"); err != nil {
+		t.Fatal(err)
+	}
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	if len(seen) != queued {
+		t.Fatalf("hooks ran for %d of %d commands", len(seen), queued)
+	}
+	for i, index := range seen {
+		if index != uint64(i+1) {
+			t.Fatalf("command %d ran as %d", index, i+1)
+		}
+	}
+}
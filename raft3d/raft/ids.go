@@ -0,0 +1,113 @@
+package raft
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Formats of the IDs generated for entities created without one
+const (
+	// IDFormatUUID generates random version 4 UUIDs. The leader picks the
+	// UUID and embeds it in the command, so every replica stores the same.
+	IDFormatUUID = "uuid"
+
+	// IDFormatSequential uses the log index of the create command, so IDs
+	// increase with every write but have gaps
+	IDFormatSequential = "sequential"
+)
+
+// generatedIDAttempts bounds how often a create is retried when the
+// generated ID is already taken, e.g. by an entity posted with that ID
+const generatedIDAttempts = 3
+
+// validIDFormat checks a configured ID format, treating "" as the default
+func validIDFormat(format string) error {
+	switch format {
+	case "", IDFormatUUID, IDFormatSequential:
+		return nil
+	}
+	return fmt.Errorf("unknown ID format %q (want %s or %s)", format, IDFormatUUID, IDFormatSequential)
+}
+
+// newUUID returns a random version 4 UUID
+func newUUID() (string, error) {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "", err
+	}
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:]), nil
+}
+
+// createWithID stores cmd.Value under cmd.Key, a prefix, followed by cmd.ID
+// or, if that is empty, the log index. The ID is also written to the value's
+// "id" field. The caller must hold the mutex.
+func (f *FSM) createWithID(cmd Command) (string, error) {
+	id := cmd.ID
+	if id == "" {
+		id = strconv.FormatUint(f.index, 10)
+	}
+	key := cmd.Key + id
+	if _, exists := f.data[key]; exists {
+		return "", fmt.Errorf("%w: key %s", ErrAlreadyExists, key)
+	}
+
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(cmd.Value), &fields); err != nil || fields == nil {
+		return "", fmt.Errorf("%w: value for %s is not a JSON object", ErrValidation, key)
+	}
+	fields["id"], _ = json.Marshal(id)
+	value, err := json.Marshal(fields)
+	if err != nil {
+		return "", fmt.Errorf("%w: %s", ErrValidation, err)
+	}
+
+	f.put(key, string(value))
+	f.setValues(cmd.Values)
+	return string(value), nil
+}
+
+// CreateWithID stores a new JSON object under prefix plus a generated ID and
+// returns the stored value, which carries the ID in its "id" field
+func (s *RaftStore) CreateWithID(prefix string, value string) (string, error) {
+	return s.createWithID(context.Background(), prefix, value, nil)
+}
+
+// createWithID is CreateWithID bound to ctx, also setting values and checking
+// conditions
+func (s *RaftStore) createWithID(ctx context.Context, prefix string, value string, values map[string]string, conditions ...Condition) (string, error) {
+	for k := range values {
+		if k == "" || strings.HasPrefix(k, prefix) {
+			return "", fmt.Errorf("%w: values must have non-empty keys outside %s", ErrValidation, prefix)
+		}
+	}
+	if err := s.writable(); err != nil {
+		return "", err
+	}
+
+	for attempt := 1; ; attempt++ {
+		cmd := Command{Op: "create_with_id", Key: prefix, Value: value, Values: values, Conditions: conditions, Actor: actorFrom(ctx), At: commandTime(), Version: ProtocolVersion}
+		if s.idFormat != IDFormatSequential {
+			id, err := newUUID()
+			if err != nil {
+				return "", err
+			}
+			cmd.ID = id
+		}
+
+		data, err := json.Marshal(&cmd)
+		if err != nil {
+			return "", err
+		}
+		entity, err := s.applyEntity(ctx, cmd.Op, data)
+		if err == nil || !errors.Is(err, ErrAlreadyExists) || attempt == generatedIDAttempts {
+			return entity, err
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package raft
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/raft"
+)
+
+var uuidV4 = regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`)
+
+// storedID returns the "id" field of a stored value
+func storedID(t *testing.T, value string) string {
+	t.Helper()
+	var fields struct {
+		ID string `json:"id"`
+	}
+	if err := json.Unmarshal([]byte(value), &fields); err != nil {
+		t.Fatal(err)
+	}
+	return fields.ID
+}
+
+func TestCreateWithIDGeneratesUUIDs(t *testing.T) {
+	store := startSingleNode(t)
+
+	seen := make(map[string]bool)
+	for i := 0; i < 20; i++ {
+		value, err := store.CreateWithID("printer_", `{"name":"Prusa"}`)
+		if err != nil {
+			t.Fatal(err)
+		}
+		id := storedID(t, value)
+		if !uuidV4.MatchString(id) || seen[id] {
+			t.Fatalf("generated ID %q (seen before: %v)", id, seen[id])
+		}
+		seen[id] = true
+		if stored, err := store.Get("printer_" + id); err != nil || stored != value {
+			t.Fatalf("stored %s %v, want %s", stored, err, value)
+		}
+	}
+
+	if _, err := store.CreateWithID("printer_", `["not", "an", "object"]`); err == nil {
+		t.Fatal("created an entity from a JSON array")
+	}
+}
+
+func TestSequentialIDsSkipTakenKeys(t *testing.T) {
+	addr, transport := raft.NewInmemTransport("")
+	store, err := NewRaftStore(StoreConfig{NodeID: "n1", RaftAddr: string(addr), Bootstrap: true, Transport: transport, InMemory: true,
+		DataDir: t.TempDir(), IDFormat: IDFormatSequential})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { store.Close() })
+	waitFor(t, 10*time.Second, func() bool {
+		_, err := store.Get(nodeKeyPrefix + "n1")
+		return err == nil
+	}, "leader did not register itself")
+
+	// Take the ID the next create would get
+	next := store.AppliedIndex() + 2
+	if err := store.Set(fmt.Sprintf("printer_%d", next), `{"name":"posted with this ID"}`); err != nil {
+		t.Fatal(err)
+	}
+	value, err := store.CreateWithID("printer_", `{"name":"Prusa"}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, err := strconv.ParseUint(storedID(t, value), 10, 64)
+	if err != nil || id <= next {
+		t.Fatalf("generated ID %d %v, want one after the taken %d", id, err, next)
+	}
+	if taken, _ := store.Get(fmt.Sprintf("printer_%d", next)); taken != `{"name":"posted with this ID"}` {
+		t.Fatalf("taken key overwritten: %s", taken)
+	}
+
+	if _, err := NewRaftStore(StoreConfig{NodeID: "n2", InMemory: true, IDFormat: "ulid"}); err == nil {
+		t.Fatal("started with an unknown ID format")
+	}
+}
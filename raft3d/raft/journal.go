@@ -0,0 +1,205 @@
+package raft
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"raft3d/events"
+)
+
+// Key prefixes of the event journal
+const (
+	eventKeyPrefix          = "event_"
+	deliveryCursorKeyPrefix = "deliverycursor_"
+)
+
+// eventJournalRetain is how many recorded events are kept. Subscribers more
+// than this far behind skip the events trimmed in between.
+const eventJournalRetain = 10000
+
+// eventJournalTrimEvery is how many events the leader records between trims
+const eventJournalTrimEvery = 100
+
+// journalEntry is a recorded event. Its ID is the log index of the command
+// that recorded it, which is the event's sequence number.
+type journalEntry struct {
+	ID   string          `json:"id"`
+	Type string          `json:"type"`
+	Time time.Time       `json:"time"`
+	Data json.RawMessage `json:"data,omitempty"`
+}
+
+// deliveryCursor is the last event delivered to a subscriber
+type deliveryCursor struct {
+	Subscriber string    `json:"subscriber"`
+	Seq        uint64    `json:"seq"`
+	UpdatedAt  time.Time `json:"updated_at"`
+}
+
+// AppendEvent records an event in the replicated journal. Its sequence
+// number is the log index of the write, so numbers only grow across leaders
+// but have gaps.
+func (s *RaftStore) AppendEvent(event events.Event) (uint64, error) {
+	if err := s.writable(); err != nil {
+		return 0, err
+	}
+
+	entry := journalEntry{Type: event.Type, Time: event.Time}
+	if event.Data != nil {
+		data, err := json.Marshal(event.Data)
+		if err != nil {
+			return 0, fmt.Errorf("%w: event data: %s", ErrValidation, err)
+		}
+		entry.Data = data
+	}
+	body, err := json.Marshal(entry)
+	if err != nil {
+		return 0, err
+	}
+	cmd, err := json.Marshal(&Command{Op: "create_with_id", Key: eventKeyPrefix, Value: string(body), At: commandTime(), Version: ProtocolVersion})
+	if err != nil {
+		return 0, err
+	}
+	stored, err := s.applyEntity(context.Background(), "create_with_id", cmd)
+	if err != nil {
+		return 0, err
+	}
+
+	if err := json.Unmarshal([]byte(stored), &entry); err != nil {
+		return 0, err
+	}
+	seq, err := strconv.ParseUint(entry.ID, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("recorded event has ID %q: %w", entry.ID, err)
+	}
+
+	if atomic.AddUint64(&s.journalAppends, 1)%eventJournalTrimEvery == 0 {
+		if err := s.trimEvents(); err != nil {
+			log.Printf("Failed to trim the event journal: %s", err)
+		}
+	}
+	return seq, nil
+}
+
+// recordedEvents returns the sequence numbers of the recorded events in order
+func (s *RaftStore) recordedEvents() ([]uint64, error) {
+	keys, err := s.fsm.List(eventKeyPrefix)
+	if err != nil {
+		return nil, err
+	}
+	seqs := make([]uint64, 0, len(keys))
+	for _, key := range keys {
+		seq, err := strconv.ParseUint(strings.TrimPrefix(key, eventKeyPrefix), 10, 64)
+		if err != nil {
+			continue
+		}
+		seqs = append(seqs, seq)
+	}
+	sort.Slice(seqs, func(i, j int) bool { return seqs[i] < seqs[j] })
+	return seqs, nil
+}
+
+// trimEvents removes the oldest events beyond the retention limit
+func (s *RaftStore) trimEvents() error {
+	seqs, err := s.recordedEvents()
+	if err != nil || len(seqs) <= eventJournalRetain {
+		return err
+	}
+	stale := seqs[:len(seqs)-eventJournalRetain]
+	keys := make([]string, len(stale))
+	for i, seq := range stale {
+		keys[i] = eventKeyPrefix + strconv.FormatUint(seq, 10)
+	}
+	return s.DeleteMany(keys)
+}
+
+// EventsAfter returns up to limit recorded events numbered above seq, oldest
+// first
+func (s *RaftStore) EventsAfter(seq uint64, limit int) ([]events.Event, error) {
+	seqs, err := s.recordedEvents()
+	if err != nil {
+		return nil, err
+	}
+	start := sort.Search(len(seqs), func(i int) bool { return seqs[i] > seq })
+
+	result := []events.Event{}
+	for _, next := range seqs[start:] {
+		if len(result) == limit {
+			break
+		}
+		value, err := s.fsm.Get(eventKeyPrefix + strconv.FormatUint(next, 10))
+		if errors.Is(err, ErrNotFound) {
+			continue // trimmed since listing
+		}
+		if err != nil {
+			return nil, err
+		}
+		var entry journalEntry
+		if err := json.Unmarshal([]byte(value), &entry); err != nil {
+			return nil, fmt.Errorf("recorded event %d: %w", next, err)
+		}
+		event := events.Event{Seq: next, Type: entry.Type, Time: entry.Time}
+		if len(entry.Data) > 0 {
+			event.Data = entry.Data
+		}
+		result = append(result, event)
+	}
+	return result, nil
+}
+
+// WaitForEvents blocks until an event numbered above seq is recorded,
+// returning ErrTimeout if ctx ends first
+func (s *RaftStore) WaitForEvents(ctx context.Context, seq uint64) error {
+	since := seq
+	for {
+		if err := s.WaitForChange(ctx, eventKeyPrefix, since); err != nil {
+			return err
+		}
+		pending, err := s.EventsAfter(seq, 1)
+		if err != nil || len(pending) > 0 {
+			return err
+		}
+		// Only trimmed; wait for the next change
+		since, _ = s.fsm.LastChange(eventKeyPrefix)
+	}
+}
+
+// DeliveryCursor returns the last event delivered to subscriber, or the
+// newest recorded event and false if the subscriber has no cursor yet
+func (s *RaftStore) DeliveryCursor(subscriber string) (uint64, bool, error) {
+	value, err := s.fsm.Get(deliveryCursorKeyPrefix + subscriber)
+	if errors.Is(err, ErrNotFound) {
+		seqs, err := s.recordedEvents()
+		if err != nil || len(seqs) == 0 {
+			return 0, false, err
+		}
+		return seqs[len(seqs)-1], false, nil
+	}
+	if err != nil {
+		return 0, false, err
+	}
+
+	var cursor deliveryCursor
+	if err := json.Unmarshal([]byte(value), &cursor); err != nil {
+		return 0, false, fmt.Errorf("delivery cursor for %s: %w", subscriber, err)
+	}
+	return cursor.Seq, true, nil
+}
+
+// SetDeliveryCursor records that subscriber has received every event up to
+// seq
+func (s *RaftStore) SetDeliveryCursor(subscriber string, seq uint64) error {
+	body, err := json.Marshal(deliveryCursor{Subscriber: subscriber, Seq: seq, UpdatedAt: time.Now().UTC()})
+	if err != nil {
+		return err
+	}
+	return s.Set(deliveryCursorKeyPrefix+subscriber, string(body))
+}
@@ -0,0 +1,346 @@
+package raft
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Kafka API keys and the versions used, which every broker since 1.0
+// supports
+const (
+	kafkaProduce         = 0
+	kafkaProduceVersion  = 3
+	kafkaMetadata        = 3
+	kafkaMetadataVersion = 4
+)
+
+// kafkaTimeout bounds connecting and waiting for a response when the context
+// has no deadline
+const kafkaTimeout = 10 * time.Second
+
+// kafkaCastagnoli is the CRC-32C table record batches are checksummed with
+var kafkaCastagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// kafkaPublisher produces to partition 0 of a Kafka topic, so records keep
+// the log's order, with acks from every in-sync replica. The record key is
+// the change's ID, for consumers to drop the duplicates a retry produces.
+type kafkaPublisher struct {
+	bootstrap string
+	topic     string
+
+	mutex       sync.Mutex
+	conn        net.Conn
+	reader      *bufio.Reader
+	correlation int32
+}
+
+func newKafkaPublisher(bootstrap, topic string) *kafkaPublisher {
+	if _, _, err := net.SplitHostPort(bootstrap); err != nil {
+		bootstrap = net.JoinHostPort(bootstrap, "9092")
+	}
+	return &kafkaPublisher{bootstrap: bootstrap, topic: topic}
+}
+
+func (k *kafkaPublisher) Publish(ctx context.Context, id string, payload []byte) error {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(kafkaTimeout)
+	}
+	if k.conn == nil {
+		if err := k.connect(ctx, deadline); err != nil {
+			return fmt.Errorf("kafka: %w", err)
+		}
+	}
+	if err := k.produce(deadline, id, payload); err != nil {
+		// The partition may have moved, so the leader is looked up again
+		k.closeConn()
+		return fmt.Errorf("kafka: %w", err)
+	}
+	return nil
+}
+
+// connect finds the leader of the topic's partition 0 through the bootstrap
+// broker and connects to it
+func (k *kafkaPublisher) connect(ctx context.Context, deadline time.Time) error {
+	if err := k.dial(ctx, k.bootstrap, deadline); err != nil {
+		return err
+	}
+	leader, err := k.partitionLeader(deadline)
+	if err != nil {
+		k.closeConn()
+		return err
+	}
+	if leader == k.bootstrap {
+		return nil
+	}
+	k.closeConn()
+	return k.dial(ctx, leader, deadline)
+}
+
+func (k *kafkaPublisher) dial(ctx context.Context, addr string, deadline time.Time) error {
+	dialer := net.Dialer{Deadline: deadline}
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return err
+	}
+	k.conn, k.reader = conn, bufio.NewReader(conn)
+	return nil
+}
+
+// partitionLeader asks for the topic's metadata and returns the address of
+// the broker leading partition 0
+func (k *kafkaPublisher) partitionLeader(deadline time.Time) (string, error) {
+	var body kafkaBuffer
+	body.int32(1)
+	body.string(k.topic)
+	body.int8(0) // don't create the topic
+	resp, err := k.roundTrip(deadline, kafkaMetadata, kafkaMetadataVersion, body.bytes())
+	if err != nil {
+		return "", err
+	}
+
+	r := kafkaReader{data: resp}
+	r.int32() // throttle time
+	brokers := make(map[int32]string)
+	for i := r.int32(); i > 0 && r.err == nil; i-- {
+		node, host, port := r.int32(), r.string(), r.int32()
+		r.nullableString() // rack
+		brokers[node] = net.JoinHostPort(host, strconv.Itoa(int(port)))
+	}
+	r.nullableString() // cluster ID
+	r.int32()          // controller ID
+	for i := r.int32(); i > 0 && r.err == nil; i-- {
+		code, name := r.int16(), r.string()
+		r.int8() // internal
+		partitions := r.int32()
+		for ; partitions > 0 && r.err == nil; partitions-- {
+			r.int16() // partition error
+			partition, leader := r.int32(), r.int32()
+			r.int32s() // replicas
+			r.int32s() // in-sync replicas
+			if name == k.topic && partition == 0 && code == 0 {
+				if addr, ok := brokers[leader]; ok {
+					return addr, r.err
+				}
+			}
+		}
+		if name == k.topic && code != 0 {
+			return "", fmt.Errorf("topic %s: error code %d", k.topic, code)
+		}
+	}
+	if r.err != nil {
+		return "", r.err
+	}
+	return "", fmt.Errorf("topic %s has no leader for partition 0", k.topic)
+}
+
+// produce writes one record to partition 0 and waits for it to be committed
+func (k *kafkaPublisher) produce(deadline time.Time, key string, value []byte) error {
+	batch := kafkaRecordBatch([]byte(key), value, time.Now())
+
+	var body kafkaBuffer
+	body.int16(-1) // no transaction
+	body.int16(-1) // acks from all in-sync replicas
+	body.int32(int32(time.Until(deadline) / time.Millisecond))
+	body.int32(1)
+	body.string(k.topic)
+	body.int32(1)
+	body.int32(0) // partition
+	body.int32(int32(len(batch)))
+	body.raw(batch)
+	resp, err := k.roundTrip(deadline, kafkaProduce, kafkaProduceVersion, body.bytes())
+	if err != nil {
+		return err
+	}
+
+	r := kafkaReader{data: resp}
+	for i := r.int32(); i > 0 && r.err == nil; i-- {
+		r.string()
+		for j := r.int32(); j > 0 && r.err == nil; j-- {
+			r.int32() // partition
+			code := r.int16()
+			r.int64() // base offset
+			r.int64() // log append time
+			if code != 0 && r.err == nil {
+				return fmt.Errorf("produce to %s failed with error code %d", k.topic, code)
+			}
+		}
+	}
+	return r.err
+}
+
+// roundTrip sends a request and returns the body of its response
+func (k *kafkaPublisher) roundTrip(deadline time.Time, apiKey, version int16, body []byte) ([]byte, error) {
+	k.conn.SetDeadline(deadline)
+	k.correlation++
+
+	var req kafkaBuffer
+	req.int16(apiKey)
+	req.int16(version)
+	req.int32(k.correlation)
+	req.string("raft3d")
+	req.raw(body)
+	frame := binary.BigEndian.AppendUint32(nil, uint32(len(req.bytes())))
+	if _, err := k.conn.Write(append(frame, req.bytes()...)); err != nil {
+		return nil, err
+	}
+
+	var header [8]byte
+	if _, err := io.ReadFull(k.reader, header[:]); err != nil {
+		return nil, err
+	}
+	size := binary.BigEndian.Uint32(header[:4])
+	if size < 4 || size > 16<<20 {
+		return nil, fmt.Errorf("response of %d bytes is malformed", size)
+	}
+	if correlation := int32(binary.BigEndian.Uint32(header[4:])); correlation != k.correlation {
+		return nil, fmt.Errorf("response %d does not match request %d", correlation, k.correlation)
+	}
+	resp := make([]byte, size-4)
+	_, err := io.ReadFull(k.reader, resp)
+	return resp, err
+}
+
+func (k *kafkaPublisher) closeConn() {
+	if k.conn != nil {
+		k.conn.Close()
+		k.conn, k.reader = nil, nil
+	}
+}
+
+func (k *kafkaPublisher) Close() error {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+	k.closeConn()
+	return nil
+}
+
+// kafkaRecordBatch encodes a record batch (magic 2) holding one record
+func kafkaRecordBatch(key, value []byte, now time.Time) []byte {
+	var record kafkaBuffer
+	record.int8(0)   // attributes
+	record.varint(0) // timestamp delta
+	record.varint(0) // offset delta
+	record.varint(int64(len(key)))
+	record.raw(key)
+	record.varint(int64(len(value)))
+	record.raw(value)
+	record.varint(0) // headers
+
+	// Everything from the attributes on is covered by the CRC
+	var tail kafkaBuffer
+	tail.int16(0) // attributes: no compression
+	tail.int32(0) // last offset delta
+	tail.int64(now.UnixMilli())
+	tail.int64(now.UnixMilli())
+	tail.int64(-1) // producer ID
+	tail.int16(-1) // producer epoch
+	tail.int32(-1) // base sequence
+	tail.int32(1)  // records
+	tail.varint(int64(len(record.bytes())))
+	tail.raw(record.bytes())
+
+	var batch kafkaBuffer
+	batch.int64(0) // base offset
+	batch.int32(int32(4 + 1 + 4 + len(tail.bytes())))
+	batch.int32(-1) // partition leader epoch
+	batch.int8(2)   // magic
+	batch.int32(int32(crc32.Checksum(tail.bytes(), kafkaCastagnoli)))
+	batch.raw(tail.bytes())
+	return batch.bytes()
+}
+
+// kafkaBuffer encodes the primitive types of the Kafka protocol
+type kafkaBuffer struct {
+	data []byte
+}
+
+func (b *kafkaBuffer) bytes() []byte  { return b.data }
+func (b *kafkaBuffer) raw(p []byte)   { b.data = append(b.data, p...) }
+func (b *kafkaBuffer) int8(v int8)    { b.data = append(b.data, byte(v)) }
+func (b *kafkaBuffer) int16(v int16)  { b.data = binary.BigEndian.AppendUint16(b.data, uint16(v)) }
+func (b *kafkaBuffer) int32(v int32)  { b.data = binary.BigEndian.AppendUint32(b.data, uint32(v)) }
+func (b *kafkaBuffer) int64(v int64)  { b.data = binary.BigEndian.AppendUint64(b.data, uint64(v)) }
+func (b *kafkaBuffer) varint(v int64) { b.data = binary.AppendVarint(b.data, v) }
+
+func (b *kafkaBuffer) string(s string) {
+	b.int16(int16(len(s)))
+	b.data = append(b.data, s...)
+}
+
+// kafkaReader decodes a response, remembering the first error
+type kafkaReader struct {
+	data []byte
+	err  error
+}
+
+func (r *kafkaReader) next(n int) []byte {
+	if r.err != nil {
+		return nil
+	}
+	if n < 0 || len(r.data) < n {
+		r.err = errors.New("truncated response")
+		return nil
+	}
+	p := r.data[:n]
+	r.data = r.data[n:]
+	return p
+}
+
+func (r *kafkaReader) int8() int8 {
+	if p := r.next(1); p != nil {
+		return int8(p[0])
+	}
+	return 0
+}
+
+func (r *kafkaReader) int16() int16 {
+	if p := r.next(2); p != nil {
+		return int16(binary.BigEndian.Uint16(p))
+	}
+	return 0
+}
+
+func (r *kafkaReader) int32() int32 {
+	if p := r.next(4); p != nil {
+		return int32(binary.BigEndian.Uint32(p))
+	}
+	return 0
+}
+
+func (r *kafkaReader) int64() int64 {
+	if p := r.next(8); p != nil {
+		return int64(binary.BigEndian.Uint64(p))
+	}
+	return 0
+}
+
+func (r *kafkaReader) string() string {
+	return string(r.next(int(r.int16())))
+}
+
+func (r *kafkaReader) nullableString() string {
+	n := r.int16()
+	if n < 0 {
+		return ""
+	}
+	return string(r.next(int(n)))
+}
+
+func (r *kafkaReader) int32s() {
+	for i := r.int32(); i > 0 && r.err == nil; i-- {
+		r.int32()
+	}
+}
@@ -0,0 +1,126 @@
+package raft
+
+import (
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// latencyBuckets are the upper bounds, in seconds, of the apply latency
+// histograms
+var latencyBuckets = []float64{0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// Histogram is a snapshot of a latency distribution. Counts are cumulative
+// per bucket, as in the Prometheus exposition format.
+type Histogram struct {
+	Buckets []float64 `json:"buckets"`
+	Counts  []uint64  `json:"counts"`
+	Count   uint64    `json:"count"`
+	Sum     float64   `json:"sum"`
+}
+
+// histogram accumulates observations into latencyBuckets
+type histogram struct {
+	counts []uint64 // per bucket, not cumulative
+	count  uint64
+	sum    float64
+}
+
+func (h *histogram) observe(seconds float64) {
+	if h.counts == nil {
+		h.counts = make([]uint64, len(latencyBuckets))
+	}
+	if i := sort.SearchFloat64s(latencyBuckets, seconds); i < len(latencyBuckets) {
+		h.counts[i]++
+	}
+	h.count++
+	h.sum += seconds
+}
+
+func (h *histogram) snapshot() Histogram {
+	snap := Histogram{Buckets: latencyBuckets, Counts: make([]uint64, len(latencyBuckets)), Count: h.count, Sum: h.sum}
+	var cumulative uint64
+	for i := range latencyBuckets {
+		if h.counts != nil {
+			cumulative += h.counts[i]
+		}
+		snap.Counts[i] = cumulative
+	}
+	return snap
+}
+
+// ApplyLatency breaks write latency down by command type, so a stall can be
+// attributed to replication or to the state machine
+type ApplyLatency struct {
+	// Pending is the number of writes submitted by this node and not yet
+	// applied
+	Pending int64 `json:"pending"`
+
+	// Commit is the time from the leader appending a command to this node's
+	// FSM starting to apply it, which covers replication and commit
+	Commit map[string]Histogram `json:"commit"`
+
+	// FSM is the time the state machine spends applying a command
+	FSM map[string]Histogram `json:"fsm"`
+
+	// Total is the time from submission to response for writes made through
+	// this node while it is leader
+	Total map[string]Histogram `json:"total"`
+}
+
+// latencyMetrics collects apply latencies per command type
+type latencyMetrics struct {
+	pending int64 // accessed atomically
+
+	mutex  sync.Mutex
+	commit map[string]*histogram
+	fsm    map[string]*histogram
+	total  map[string]*histogram
+}
+
+func newLatencyMetrics() *latencyMetrics {
+	return &latencyMetrics{
+		commit: make(map[string]*histogram),
+		fsm:    make(map[string]*histogram),
+		total:  make(map[string]*histogram),
+	}
+}
+
+// observe records a duration for a command type in one of the histograms
+func (m *latencyMetrics) observe(set map[string]*histogram, op string, d time.Duration) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	h, ok := set[op]
+	if !ok {
+		h = &histogram{}
+		set[op] = h
+	}
+	h.observe(d.Seconds())
+}
+
+func (m *latencyMetrics) snapshot() ApplyLatency {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	copySet := func(set map[string]*histogram) map[string]Histogram {
+		out := make(map[string]Histogram, len(set))
+		for op, h := range set {
+			out[op] = h.snapshot()
+		}
+		return out
+	}
+	return ApplyLatency{
+		Pending: atomic.LoadInt64(&m.pending),
+		Commit:  copySet(m.commit),
+		FSM:     copySet(m.fsm),
+		Total:   copySet(m.total),
+	}
+}
+
+// ApplyLatency returns the apply latency histograms and the number of
+// pending writes
+func (s *RaftStore) ApplyLatency() ApplyLatency {
+	return s.latency.snapshot()
+}
@@ -0,0 +1,35 @@
+package raft
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/hashicorp/go-hclog"
+)
+
+// parseLogLevel converts a level name to an hclog level, defaulting to info
+func parseLogLevel(name string) (hclog.Level, error) {
+	if name == "" {
+		return hclog.Info, nil
+	}
+	level := hclog.LevelFromString(name)
+	if level == hclog.NoLevel {
+		return level, fmt.Errorf("%w: unknown log level %q (use trace, debug, info, warn or error)", ErrValidation, name)
+	}
+	return level, nil
+}
+
+// SetLogLevel changes the Raft library's log level at runtime
+func (s *RaftStore) SetLogLevel(name string) error {
+	level, err := parseLogLevel(name)
+	if err != nil {
+		return err
+	}
+	s.raftConfig.Logger.SetLevel(level)
+	return nil
+}
+
+// LogLevel returns the Raft library's current log level
+func (s *RaftStore) LogLevel() string {
+	return strings.ToLower(s.raftConfig.Logger.GetLevel().String())
+}
@@ -0,0 +1,110 @@
+package raft
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/hashicorp/raft"
+)
+
+// maxLogRead caps the entries one ReadLog call returns
+const maxLogRead = 1000
+
+// LogEntry describes a Raft log entry for inspection. Values are left out,
+// so reading the log never shows more than key names and sizes.
+type LogEntry struct {
+	Index      uint64     `json:"index"`
+	Term       uint64     `json:"term"`
+	Type       string     `json:"type"`
+	AppendedAt *time.Time `json:"appended_at,omitempty"`
+	Size       int        `json:"size"`
+	Op         string     `json:"op,omitempty"`
+	Keys       []string   `json:"keys,omitempty"`
+	Conditions int        `json:"conditions,omitempty"`
+	Actor      string     `json:"actor,omitempty"`
+	Summary    string     `json:"summary,omitempty"` // for entries that aren't decoded commands
+}
+
+// LogPage is a range of the log, along with the range the node still holds
+type LogPage struct {
+	FirstIndex uint64     `json:"first_index"`
+	LastIndex  uint64     `json:"last_index"`
+	Entries    []LogEntry `json:"entries"`
+	Truncated  bool       `json:"truncated"` // the range held more than maxLogRead entries
+}
+
+// ReadLog describes the entries from index from to index to, inclusive,
+// that this node still holds. Zero to reads up to the last entry.
+func (s *RaftStore) ReadLog(from, to uint64) (LogPage, error) {
+	first, err := s.logs.FirstIndex()
+	if err != nil {
+		return LogPage{}, err
+	}
+	last, err := s.logs.LastIndex()
+	if err != nil {
+		return LogPage{}, err
+	}
+	page := LogPage{FirstIndex: first, LastIndex: last, Entries: []LogEntry{}}
+	if to == 0 || to > last {
+		to = last
+	}
+	if from < first {
+		from = first
+	}
+	if from > to {
+		return page, nil
+	}
+	if to-from >= maxLogRead {
+		to, page.Truncated = from+maxLogRead-1, true
+	}
+
+	for index := from; index <= to; index++ {
+		var entry raft.Log
+		if err := s.logs.GetLog(index, &entry); err != nil {
+			if errors.Is(err, raft.ErrLogNotFound) {
+				// Compacted away while we were reading
+				continue
+			}
+			return page, fmt.Errorf("reading log entry %d: %w", index, err)
+		}
+		page.Entries = append(page.Entries, describeLogEntry(&entry))
+	}
+	return page, nil
+}
+
+// describeLogEntry decodes a command's operation and keys, and summarizes
+// anything else by its size
+func describeLogEntry(entry *raft.Log) LogEntry {
+	described := LogEntry{Index: entry.Index, Term: entry.Term, Type: entry.Type.String(), Size: len(entry.Data)}
+	if !entry.AppendedAt.IsZero() {
+		at := entry.AppendedAt.UTC()
+		described.AppendedAt = &at
+	}
+	if entry.Type != raft.LogCommand {
+		if len(entry.Data) > 0 {
+			described.Summary = fmt.Sprintf("%d bytes of %s data", len(entry.Data), described.Type)
+		}
+		return described
+	}
+
+	var cmd Command
+	if err := json.Unmarshal(entry.Data, &cmd); err != nil || cmd.Op == "" {
+		described.Summary = fmt.Sprintf("%d bytes that are not a command", len(entry.Data))
+		return described
+	}
+	described.Op, described.Actor, described.Conditions = cmd.Op, cmd.Actor, len(cmd.Conditions)
+	if cmd.Key != "" {
+		described.Keys = append(described.Keys, cmd.Key)
+	}
+	var keys []string
+	for key := range cmd.Values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	described.Keys = append(described.Keys, keys...)
+	described.Keys = append(described.Keys, cmd.Keys...)
+	return described
+}
@@ -0,0 +1,56 @@
+package raft
+
+import (
+	"context"
+	"testing"
+
+	"github.com/hashicorp/raft"
+)
+
+func TestReadLog(t *testing.T) {
+	store := startSingleNode(t)
+	store.WithContext(WithActor(context.Background(), "alice")).Set("printer_p1", `{"secret":"hunter2"}`)
+	store.SetMany(map[string]string{"filament_b": "{}", "filament_a": "{}"}, Condition{Key: "printer_p1", Field: "secret", Equals: "hunter2"})
+	store.DeleteMany([]string{"filament_a"})
+
+	page, err := store.ReadLog(0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if page.FirstIndex != 1 || page.LastIndex != store.AppliedIndex() || page.Truncated {
+		t.Fatalf("page: %+v", page)
+	}
+	var commands []LogEntry
+	for _, entry := range page.Entries {
+		if entry.Op != "" && entry.Keys[0] != "node_n1" {
+			commands = append(commands, entry)
+		}
+	}
+	if len(commands) != 3 {
+		t.Fatalf("commands: %+v", commands)
+	}
+	if set := commands[0]; set.Op != "set" || set.Actor != "alice" || set.Size == 0 || set.Term == 0 || set.AppendedAt == nil {
+		t.Fatalf("set: %+v", set)
+	}
+	if many := commands[1]; many.Op != "set_many" || len(many.Keys) != 2 || many.Keys[0] != "filament_a" || many.Conditions != 1 {
+		t.Fatalf("set_many: %+v", many)
+	}
+	if page.Entries[0].Type != raft.LogConfiguration.String() || page.Entries[0].Summary == "" {
+		t.Fatalf("first entry: %+v", page.Entries[0])
+	}
+
+	last := commands[2].Index
+	if page, _ := store.ReadLog(last, last); len(page.Entries) != 1 || page.Entries[0].Op != "delete_many" {
+		t.Fatalf("single entry: %+v", page.Entries)
+	}
+	if page, _ := store.ReadLog(last+100, 0); len(page.Entries) != 0 {
+		t.Fatalf("beyond the log: %+v", page.Entries)
+	}
+}
+
+func TestDescribeBinaryEntry(t *testing.T) {
+	entry := describeLogEntry(&raft.Log{Index: 4, Type: raft.LogCommand, Data: []byte{0x00, 0xff, 0x10}})
+	if entry.Op != "" || entry.Keys != nil || entry.Summary != "3 bytes that are not a command" {
+		t.Fatalf("binary entry: %+v", entry)
+	}
+}
@@ -0,0 +1,181 @@
+package raft
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"io/fs"
+	"sort"
+)
+
+// manifestSuffix is appended to a backup's name to name its manifest
+const manifestSuffix = ".manifest.json"
+
+// BackupManifest is written next to every backup so the archive can be
+// checked before it is relied on for a restore
+type BackupManifest struct {
+	Backup    string         `json:"backup"`
+	NodeID    string         `json:"node_id"`
+	Index     uint64         `json:"index"`
+	CreatedAt string         `json:"created_at"`
+	Size      int64          `json:"size"`
+	SHA256    string         `json:"sha256"`
+	Keys      int            `json:"keys"`
+	Entities  map[string]int `json:"entities"` // keys per prefix, e.g. "printer_"
+}
+
+// ManifestName returns the name of a backup's manifest
+func ManifestName(backupName string) string {
+	return backupName + manifestSuffix
+}
+
+// entityCounts counts keys by prefix
+func entityCounts(data map[string]string) map[string]int {
+	counts := make(map[string]int)
+	for key := range data {
+		counts[keyPrefix(key)]++
+	}
+	return counts
+}
+
+// checksumWriter hashes and counts the bytes written through it
+type checksumWriter struct {
+	hash hash.Hash
+	size int64
+}
+
+func newChecksumWriter() *checksumWriter {
+	return &checksumWriter{hash: sha256.New()}
+}
+
+func (c *checksumWriter) Write(p []byte) (int, error) {
+	c.size += int64(len(p))
+	return c.hash.Write(p)
+}
+
+// sum returns the hex encoded SHA-256 of everything written
+func (c *checksumWriter) sum() string {
+	return hex.EncodeToString(c.hash.Sum(nil))
+}
+
+// BackupVerification is the result of checking a backup archive against
+// its manifest
+type BackupVerification struct {
+	Backup   string          `json:"backup"`
+	Manifest *BackupManifest `json:"manifest,omitempty"`
+	Size     int64           `json:"size"`
+	SHA256   string          `json:"sha256"`
+	Index    uint64          `json:"index"`
+	Keys     int             `json:"keys"`
+	Entities map[string]int  `json:"entities"`
+	Problems []string        `json:"problems"`
+}
+
+// OK reports whether the backup can be relied on for a restore
+func (v *BackupVerification) OK() bool {
+	return len(v.Problems) == 0
+}
+
+func (v *BackupVerification) problem(format string, args ...interface{}) {
+	v.Problems = append(v.Problems, fmt.Sprintf(format, args...))
+}
+
+// readBackupFile reads a whole file by path or backup target URL
+func readBackupFile(location string) ([]byte, error) {
+	f, err := OpenBackup(location)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return io.ReadAll(f)
+}
+
+// VerifyBackup decodes a backup archive and checks it against its manifest:
+// the archive's size and SHA-256, its index and the number of keys of each
+// entity type. Every value must also be valid JSON. A missing manifest is
+// reported as a problem, since the archive can't be checked without it.
+func VerifyBackup(archivePath, manifestPath string) (*BackupVerification, error) {
+	f, err := OpenBackup(archivePath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	v := &BackupVerification{Backup: archivePath, Entities: map[string]int{}, Problems: []string{}}
+	checksum := newChecksumWriter()
+	archive := io.TeeReader(f, checksum)
+	backup, err := ReadBackup(archive)
+	if err != nil {
+		v.problem("archive can't be decoded: %s", err)
+	}
+	if _, err := io.Copy(io.Discard, archive); err != nil {
+		return nil, err
+	}
+	v.Size, v.SHA256 = checksum.size, checksum.sum()
+
+	if backup != nil {
+		v.Index, v.Keys, v.Entities = backup.Index, len(backup.Data), entityCounts(backup.Data)
+		invalid := 0
+		for _, value := range backup.Data {
+			if !json.Valid([]byte(value)) {
+				invalid++
+			}
+		}
+		if invalid > 0 {
+			v.problem("%d value(s) are not valid JSON", invalid)
+		}
+	}
+
+	body, err := readBackupFile(manifestPath)
+	if errors.Is(err, fs.ErrNotExist) {
+		v.problem("no manifest at %s", manifestPath)
+		return v, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var manifest BackupManifest
+	if err := json.Unmarshal(body, &manifest); err != nil {
+		v.problem("manifest can't be decoded: %s", err)
+		return v, nil
+	}
+	v.Manifest = &manifest
+
+	if manifest.Size != v.Size {
+		v.problem("size is %d bytes, manifest says %d", v.Size, manifest.Size)
+	}
+	if manifest.SHA256 != v.SHA256 {
+		v.problem("SHA-256 is %s, manifest says %s", v.SHA256, manifest.SHA256)
+	}
+	if backup == nil {
+		return v, nil
+	}
+	if manifest.Index != v.Index {
+		v.problem("index is %d, manifest says %d", v.Index, manifest.Index)
+	}
+	if manifest.Keys != v.Keys {
+		v.problem("archive holds %d keys, manifest says %d", v.Keys, manifest.Keys)
+	}
+	prefixes := make(map[string]bool)
+	for prefix := range manifest.Entities {
+		prefixes[prefix] = true
+	}
+	for prefix := range v.Entities {
+		prefixes[prefix] = true
+	}
+	sorted := make([]string, 0, len(prefixes))
+	for prefix := range prefixes {
+		sorted = append(sorted, prefix)
+	}
+	sort.Strings(sorted)
+	for _, prefix := range sorted {
+		if got, want := v.Entities[prefix], manifest.Entities[prefix]; got != want {
+			v.problem("archive holds %d %s keys, manifest says %d", got, prefix, want)
+		}
+	}
+	return v, nil
+}
@@ -0,0 +1,256 @@
+package raft
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// natsTimeout bounds connecting and waiting for an acknowledgement when the
+// context has no deadline
+const natsTimeout = 10 * time.Second
+
+// natsPublisher publishes to a NATS JetStream subject over the NATS client
+// protocol. Each message is sent with a reply subject and waits for the
+// stream's acknowledgement; its Nats-Msg-Id header lets JetStream drop the
+// duplicates a retry sends within the stream's duplicate window.
+type natsPublisher struct {
+	addr     string
+	subject  string
+	user     *url.Userinfo
+	mutex    sync.Mutex
+	conn     net.Conn
+	reader   *bufio.Reader
+	inbox    string
+	requests int
+}
+
+func newNATSPublisher(addr, subject string, user *url.Userinfo) *natsPublisher {
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		addr = net.JoinHostPort(addr, "4222")
+	}
+	return &natsPublisher{addr: addr, subject: subject, user: user}
+}
+
+func (n *natsPublisher) Publish(ctx context.Context, id string, payload []byte) error {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(natsTimeout)
+	}
+	if n.conn == nil {
+		if err := n.connect(ctx, deadline); err != nil {
+			return fmt.Errorf("nats: %w", err)
+		}
+	}
+	err := n.publish(deadline, id, payload)
+	if err != nil && !errors.Is(err, errStreamRejected) {
+		n.closeConn()
+	}
+	return err
+}
+
+// errStreamRejected marks a message JetStream answered with an error, which
+// leaves the connection usable
+var errStreamRejected = errors.New("nats: stream rejected the message")
+
+func (n *natsPublisher) publish(deadline time.Time, id string, payload []byte) error {
+	n.conn.SetDeadline(deadline)
+	n.requests++
+	reply := n.inbox + "." + strconv.Itoa(n.requests)
+	headers := "NATS/1.0\r\nNats-Msg-Id: " + id + "\r\n\r\n"
+	frame := fmt.Sprintf("HPUB %s %s %d %d\r\n%s%s\r\n", n.subject, reply, len(headers), len(headers)+len(payload), headers, payload)
+	if _, err := n.conn.Write([]byte(frame)); err != nil {
+		return fmt.Errorf("nats: %w", err)
+	}
+
+	for {
+		subject, header, body, err := n.readMessage()
+		if err != nil {
+			return fmt.Errorf("nats: %w", err)
+		}
+		if subject != reply {
+			continue // the late answer to an earlier message
+		}
+		// A status header without a body, such as 503, means no stream
+		// listens on the subject
+		if strings.HasPrefix(header, "NATS/1.0 ") && len(body) == 0 {
+			return fmt.Errorf("%w: no stream acknowledged %s (%s)", errStreamRejected, n.subject, strings.TrimSpace(strings.SplitN(header, "\r\n", 2)[0]))
+		}
+		var ack struct {
+			Stream string `json:"stream"`
+			Error  *struct {
+				Code        int    `json:"code"`
+				Description string `json:"description"`
+			} `json:"error"`
+		}
+		if err := json.Unmarshal(body, &ack); err != nil {
+			return fmt.Errorf("%w: unreadable acknowledgement: %s", errStreamRejected, err)
+		}
+		if ack.Error != nil {
+			return fmt.Errorf("%w: %s (%d)", errStreamRejected, ack.Error.Description, ack.Error.Code)
+		}
+		if ack.Stream == "" {
+			return fmt.Errorf("%w: %s is not a JetStream subject", errStreamRejected, n.subject)
+		}
+		return nil
+	}
+}
+
+// connect dials the server, logs in and subscribes to an inbox for
+// acknowledgements
+func (n *natsPublisher) connect(ctx context.Context, deadline time.Time) error {
+	dialer := net.Dialer{Deadline: deadline}
+	conn, err := dialer.DialContext(ctx, "tcp", n.addr)
+	if err != nil {
+		return err
+	}
+	conn.SetDeadline(deadline)
+	n.conn, n.reader = conn, bufio.NewReader(conn)
+
+	line, err := n.readLine()
+	if err != nil {
+		n.closeConn()
+		return err
+	}
+	var info struct {
+		Headers     bool `json:"headers"`
+		TLSRequired bool `json:"tls_required"`
+	}
+	if !strings.HasPrefix(line, "INFO ") || json.Unmarshal([]byte(line[5:]), &info) != nil {
+		n.closeConn()
+		return fmt.Errorf("unexpected greeting %q", line)
+	}
+	if info.TLSRequired || !info.Headers {
+		n.closeConn()
+		return errors.New("the server needs TLS or doesn't support headers")
+	}
+
+	options := map[string]interface{}{
+		"verbose": false, "pedantic": false, "lang": "go", "version": "raft3d",
+		"protocol": 1, "headers": true, "no_responders": true, "name": "raft3d-cdc",
+	}
+	if n.user != nil {
+		if password, ok := n.user.Password(); ok {
+			options["user"], options["pass"] = n.user.Username(), password
+		} else {
+			options["auth_token"] = n.user.Username()
+		}
+	}
+	connect, _ := json.Marshal(options)
+	token := make([]byte, 8)
+	rand.Read(token)
+	n.inbox = "_INBOX.raft3d." + hex.EncodeToString(token)
+	frame := fmt.Sprintf("CONNECT %s\r\nSUB %s.* 1\r\nPING\r\n", connect, n.inbox)
+	if _, err := conn.Write([]byte(frame)); err != nil {
+		n.closeConn()
+		return err
+	}
+	for {
+		line, err := n.readLine()
+		if err != nil {
+			n.closeConn()
+			return err
+		}
+		switch {
+		case line == "PONG":
+			return nil
+		case strings.HasPrefix(line, "-ERR"):
+			n.closeConn()
+			return errors.New(strings.TrimSpace(strings.TrimPrefix(line, "-ERR")))
+		}
+	}
+}
+
+// readMessage returns the next message delivered to the inbox, answering
+// pings and skipping other protocol lines
+func (n *natsPublisher) readMessage() (subject, header string, body []byte, err error) {
+	for {
+		line, err := n.readLine()
+		if err != nil {
+			return "", "", nil, err
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		switch fields[0] {
+		case "PING":
+			if _, err := n.conn.Write([]byte("PONG\r\n")); err != nil {
+				return "", "", nil, err
+			}
+		case "-ERR":
+			return "", "", nil, errors.New(strings.TrimSpace(strings.TrimPrefix(line, "-ERR")))
+		case "MSG":
+			// MSG <subject> <sid> [reply] <size>
+			if len(fields) < 4 {
+				return "", "", nil, fmt.Errorf("malformed %q", line)
+			}
+			size, err := strconv.Atoi(fields[len(fields)-1])
+			if err != nil {
+				return "", "", nil, fmt.Errorf("malformed %q", line)
+			}
+			payload, err := n.readPayload(size)
+			return fields[1], "", payload, err
+		case "HMSG":
+			// HMSG <subject> <sid> [reply] <header size> <total size>
+			if len(fields) < 5 {
+				return "", "", nil, fmt.Errorf("malformed %q", line)
+			}
+			headerSize, err1 := strconv.Atoi(fields[len(fields)-2])
+			total, err2 := strconv.Atoi(fields[len(fields)-1])
+			if err1 != nil || err2 != nil || headerSize > total {
+				return "", "", nil, fmt.Errorf("malformed %q", line)
+			}
+			payload, err := n.readPayload(total)
+			if err != nil {
+				return "", "", nil, err
+			}
+			return fields[1], string(payload[:headerSize]), payload[headerSize:], nil
+		}
+	}
+}
+
+// readPayload reads a message body and the CRLF after it
+func (n *natsPublisher) readPayload(size int) ([]byte, error) {
+	if size < 0 || size > 8<<20 {
+		return nil, fmt.Errorf("message of %d bytes is too large", size)
+	}
+	payload := make([]byte, size+2)
+	if _, err := io.ReadFull(n.reader, payload); err != nil {
+		return nil, err
+	}
+	return payload[:size], nil
+}
+
+func (n *natsPublisher) readLine() (string, error) {
+	line, err := n.reader.ReadString('\n')
+	return strings.TrimRight(line, "\r\n"), err
+}
+
+func (n *natsPublisher) closeConn() {
+	if n.conn != nil {
+		n.conn.Close()
+		n.conn, n.reader = nil, nil
+	}
+}
+
+func (n *natsPublisher) Close() error {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	n.closeConn()
+	return nil
+}
@@ -0,0 +1,106 @@
+package raft
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/netip"
+	"strings"
+)
+
+// nodeKeyPrefix is the key prefix under which node records are stored
+const nodeKeyPrefix = "node_"
+
+// Suffrage of a cluster member as recorded in its node record
+const (
+	SuffrageVoter    = "voter"
+	SuffrageNonvoter = "nonvoter"
+)
+
+// NodeInfo describes how to reach a member of the cluster
+type NodeInfo struct {
+	ID       string `json:"id"`
+	RaftAddr string `json:"raft_addr"`
+	HTTPAddr string `json:"http_addr"`
+	Suffrage string `json:"suffrage,omitempty"`
+}
+
+// ValidateAddr checks that addr is a host and port, with IPv6 literals in
+// brackets as in [2001:db8::1]:9000
+func ValidateAddr(addr string) error {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return fmt.Errorf("%q is not host:port; write IPv6 addresses as [::1]:9000", addr)
+	}
+	if port == "" {
+		return fmt.Errorf("%q has no port", addr)
+	}
+	// netip accepts zones, as in [fe80::1%eth0]:9000
+	if _, err := netip.ParseAddr(host); strings.Contains(host, ":") && err != nil {
+		return fmt.Errorf("%q has an invalid IPv6 address", addr)
+	}
+	return nil
+}
+
+// LeaderInfo returns what is known about the current leader. The HTTP address
+// is only populated once the leader has registered its node record.
+func (s *RaftStore) LeaderInfo() NodeInfo {
+	addr, id := s.raft.LeaderWithID()
+	info := NodeInfo{ID: string(id), RaftAddr: string(addr)}
+	if id == "" {
+		return info
+	}
+
+	if node, err := s.node(string(id)); err == nil {
+		info.HTTPAddr = node.HTTPAddr
+	}
+	return info
+}
+
+// node looks up a node record in the FSM
+func (s *RaftStore) node(id string) (NodeInfo, error) {
+	var info NodeInfo
+	value, err := s.fsm.Get(nodeKeyPrefix + id)
+	if err != nil {
+		return info, err
+	}
+	err = json.Unmarshal([]byte(value), &info)
+	return info, err
+}
+
+// setNode writes a node record through the Raft log
+func (s *RaftStore) setNode(info NodeInfo) error {
+	data, err := json.Marshal(info)
+	if err != nil {
+		return err
+	}
+	return s.Set(nodeKeyPrefix+info.ID, string(data))
+}
+
+// monitorLeadership registers this node's record whenever it becomes leader,
+// so followers can point clients at the leader's HTTP address
+func (s *RaftStore) monitorLeadership() {
+	for {
+		select {
+		case isLeader := <-s.raft.LeaderCh():
+			if !isLeader {
+				continue
+			}
+			self := NodeInfo{
+				ID:       string(s.raftConfig.LocalID),
+				RaftAddr: string(s.raftTransport.LocalAddr()),
+				HTTPAddr: s.httpAddr,
+				Suffrage: SuffrageVoter,
+			}
+			if existing, err := s.node(self.ID); err == nil && existing == self {
+				continue
+			}
+			if err := s.setNode(self); err != nil {
+				log.Printf("Failed to register node record: %s", err)
+			}
+		case <-s.shutdownCh:
+			return
+		}
+	}
+}
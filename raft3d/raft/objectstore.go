@@ -0,0 +1,327 @@
+package raft
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+// objectRequestTimeout bounds every request to the object store
+const objectRequestTimeout = 5 * time.Minute
+
+// emptySHA256 is the payload hash of requests without a body
+const emptySHA256 = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+// ObjectBackupTarget stores backups in an S3 bucket, or a GCS bucket through
+// its S3-compatible XML API, signing requests with AWS Signature Version 4
+type ObjectBackupTarget struct {
+	client   *http.Client
+	endpoint *url.URL
+	bucket   string
+	prefix   string // key prefix, empty or ending in "/"
+	vhost    bool   // address the bucket as a subdomain of the endpoint
+	region   string
+	keyID    string
+	secret   string
+	token    string // session token of temporary AWS credentials
+	now      func() time.Time
+}
+
+// NewObjectBackupTarget creates a target for s3://bucket/prefix or
+// gs://bucket/prefix. S3 credentials come from $AWS_ACCESS_KEY_ID,
+// $AWS_SECRET_ACCESS_KEY and optionally $AWS_SESSION_TOKEN, in $AWS_REGION;
+// $AWS_ENDPOINT_URL points at an S3-compatible store such as MinIO. GCS uses
+// the HMAC key in $GCS_HMAC_ACCESS_ID and $GCS_HMAC_SECRET.
+func NewObjectBackupTarget(location string) (*ObjectBackupTarget, error) {
+	u, err := url.Parse(location)
+	if err != nil {
+		return nil, fmt.Errorf("invalid backup target %q: %w", location, err)
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("backup target %q has no bucket", location)
+	}
+	t := &ObjectBackupTarget{
+		client: &http.Client{Timeout: objectRequestTimeout},
+		bucket: u.Host,
+		now:    time.Now,
+	}
+	if prefix := strings.Trim(u.Path, "/"); prefix != "" {
+		t.prefix = prefix + "/"
+	}
+
+	endpoint := ""
+	switch u.Scheme {
+	case "s3":
+		t.keyID, t.secret, t.token = os.Getenv("AWS_ACCESS_KEY_ID"), os.Getenv("AWS_SECRET_ACCESS_KEY"), os.Getenv("AWS_SESSION_TOKEN")
+		t.region = firstNonEmpty(os.Getenv("AWS_REGION"), os.Getenv("AWS_DEFAULT_REGION"), "us-east-1")
+		endpoint = firstNonEmpty(os.Getenv("AWS_ENDPOINT_URL_S3"), os.Getenv("AWS_ENDPOINT_URL"))
+		if endpoint == "" {
+			endpoint = fmt.Sprintf("https://s3.%s.amazonaws.com", t.region)
+			// Bucket names with dots don't match the wildcard certificate
+			t.vhost = !strings.Contains(t.bucket, ".")
+		}
+	case "gs":
+		t.keyID, t.secret = os.Getenv("GCS_HMAC_ACCESS_ID"), os.Getenv("GCS_HMAC_SECRET")
+		t.region = "auto"
+		endpoint = "https://storage.googleapis.com"
+	default:
+		return nil, fmt.Errorf("unsupported backup target scheme %q", u.Scheme)
+	}
+	if t.keyID == "" || t.secret == "" {
+		return nil, fmt.Errorf("no credentials for %s://%s in the environment", u.Scheme, t.bucket)
+	}
+	if t.endpoint, err = url.Parse(endpoint); err != nil || t.endpoint.Host == "" {
+		return nil, fmt.Errorf("invalid object store endpoint %q", endpoint)
+	}
+	return t, nil
+}
+
+// Write uploads a backup. Uploads of a single object are atomic.
+func (t *ObjectBackupTarget) Write(name string, r io.Reader) error {
+	body, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	resp, err := t.do(http.MethodPut, t.prefix+path.Base(name), nil, body)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// Open downloads the named backup
+func (t *ObjectBackupTarget) Open(name string) (io.ReadCloser, error) {
+	resp, err := t.do(http.MethodGet, t.prefix+path.Base(name), nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+// List returns all stored backups, newest first
+func (t *ObjectBackupTarget) List() ([]BackupInfo, error) {
+	objects, err := t.list()
+	if err != nil {
+		return nil, err
+	}
+	var backups []BackupInfo
+	for _, object := range objects {
+		name := strings.TrimPrefix(object.Key, t.prefix)
+		if strings.Contains(name, "/") || !strings.HasSuffix(name, backupSuffix) {
+			continue
+		}
+		backups = append(backups, BackupInfo{Name: name, Size: object.Size, CreatedAt: object.LastModified.UTC()})
+	}
+
+	// Names embed a sortable timestamp
+	sort.Slice(backups, func(i, j int) bool { return backups[i].Name > backups[j].Name })
+	return backups, nil
+}
+
+// Remove deletes the named backup
+func (t *ObjectBackupTarget) Remove(name string) error {
+	resp, err := t.do(http.MethodDelete, t.prefix+path.Base(name), nil, nil)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// listedObject is an entry of a bucket listing
+type listedObject struct {
+	Key          string    `xml:"Key"`
+	Size         int64     `xml:"Size"`
+	LastModified time.Time `xml:"LastModified"`
+}
+
+// list returns every object under the prefix, following pagination
+func (t *ObjectBackupTarget) list() ([]listedObject, error) {
+	var objects []listedObject
+	marker := ""
+	for {
+		query := url.Values{"prefix": {t.prefix}}
+		if marker != "" {
+			query.Set("marker", marker)
+		}
+		resp, err := t.do(http.MethodGet, "", query, nil)
+		if err != nil {
+			return nil, err
+		}
+		var page struct {
+			IsTruncated bool           `xml:"IsTruncated"`
+			NextMarker  string         `xml:"NextMarker"`
+			Contents    []listedObject `xml:"Contents"`
+		}
+		err = xml.NewDecoder(resp.Body).Decode(&page)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse listing of %s: %w", t.bucket, err)
+		}
+		objects = append(objects, page.Contents...)
+		if !page.IsTruncated || len(page.Contents) == 0 {
+			return objects, nil
+		}
+		marker = page.NextMarker
+		if marker == "" {
+			marker = page.Contents[len(page.Contents)-1].Key
+		}
+	}
+}
+
+// do sends a signed request for key, or for the bucket when key is empty, and
+// turns error responses into errors. A missing object is fs.ErrNotExist.
+func (t *ObjectBackupTarget) do(method, key string, query url.Values, body []byte) (*http.Response, error) {
+	u := *t.endpoint
+	if t.vhost {
+		u.Host = t.bucket + "." + u.Host
+		u.Path = "/" + key
+	} else {
+		u.Path = "/" + t.bucket + "/" + key
+	}
+	u.RawQuery = ""
+	req, err := http.NewRequest(method, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.URL.RawQuery = canonicalQuery(query)
+
+	payloadHash := emptySHA256
+	if len(body) > 0 {
+		sum := sha256.Sum256(body)
+		payloadHash = hex.EncodeToString(sum[:])
+	}
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	if t.token != "" {
+		req.Header.Set("X-Amz-Security-Token", t.token)
+	}
+	signV4(req, payloadHash, t.keyID, t.secret, t.region, "s3", t.now())
+
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode/100 == 2 {
+		return resp, nil
+	}
+	defer resp.Body.Close()
+
+	var objectErr struct {
+		Code    string `xml:"Code"`
+		Message string `xml:"Message"`
+	}
+	xml.NewDecoder(io.LimitReader(resp.Body, 64<<10)).Decode(&objectErr)
+	target := fmt.Sprintf("%s/%s", t.bucket, key)
+	if resp.StatusCode == http.StatusNotFound && (objectErr.Code == "" || objectErr.Code == "NoSuchKey") {
+		return nil, fmt.Errorf("%s: %w", target, fs.ErrNotExist)
+	}
+	if objectErr.Code == "" {
+		objectErr.Code = resp.Status
+	}
+	return nil, fmt.Errorf("%s %s: %s %s", method, target, objectErr.Code, objectErr.Message)
+}
+
+// signV4 adds an AWS Signature Version 4 Authorization header to req. The
+// host, Content-Type and every X-Amz- header are signed.
+func signV4(req *http.Request, payloadHash, keyID, secret, region, service string, now time.Time) {
+	amzDate := now.UTC().Format("20060102T150405Z")
+	date := amzDate[:8]
+	req.Header.Set("X-Amz-Date", amzDate)
+
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		lower := strings.ToLower(name)
+		if lower == "content-type" || strings.HasPrefix(lower, "x-amz-") {
+			headers[lower] = strings.TrimSpace(strings.Join(values, ","))
+		}
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalURI := uriEncode(req.URL.Path, false)
+	if canonicalURI == "" {
+		canonicalURI = "/"
+	}
+	req.URL.RawPath = canonicalURI
+	canonicalRequest := strings.Join([]string{
+		req.Method, canonicalURI, req.URL.RawQuery, canonicalHeaders.String(), signedHeaders, payloadHash,
+	}, "\n")
+
+	scope := strings.Join([]string{date, region, service, "aws4_request"}, "/")
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := strings.Join([]string{"AWS4-HMAC-SHA256", amzDate, scope, hex.EncodeToString(requestHash[:])}, "\n")
+
+	key := []byte("AWS4" + secret)
+	for _, part := range []string{date, region, service, "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		keyID, scope, signedHeaders, signature))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// canonicalQuery encodes query parameters sorted by name, as SigV4 signs them
+func canonicalQuery(query url.Values) string {
+	var params []string
+	for name, values := range query {
+		for _, value := range values {
+			params = append(params, uriEncode(name, true)+"="+uriEncode(value, true))
+		}
+	}
+	sort.Strings(params)
+	return strings.Join(params, "&")
+}
+
+// uriEncode percent-encodes everything but unreserved characters and,
+// unless encodeSlash is set, slashes
+func uriEncode(s string, encodeSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9',
+			c == '-', c == '_', c == '.', c == '~', c == '/' && !encodeSlash:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+// firstNonEmpty returns the first of values that isn't empty
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
@@ -0,0 +1,215 @@
+package raft
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/raft"
+)
+
+// The example request from the AWS Signature Version 4 documentation
+func TestSignV4(t *testing.T) {
+	req, _ := http.NewRequest(http.MethodGet, "https://iam.amazonaws.com/?Action=ListUsers&Version=2010-05-08", nil)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded; charset=utf-8")
+	now, _ := time.Parse("20060102T150405Z", "20150830T123600Z")
+
+	signV4(req, emptySHA256, "AKIDEXAMPLE", "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY", "us-east-1", "iam", now)
+
+	want := "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/iam/aws4_request, " +
+		"SignedHeaders=content-type;host;x-amz-date, " +
+		"Signature=5d672d79c15b13162d9279b0855cfba6789a8edb4c82c400e06b5924a6f2b5d7"
+	if got := req.Header.Get("Authorization"); got != want {
+		t.Fatalf("Authorization =\n%s\nwant\n%s", got, want)
+	}
+}
+
+// fakeBucket is a minimal S3 bucket serving path-style requests
+type fakeBucket struct {
+	t       *testing.T
+	mutex   sync.Mutex
+	objects map[string][]byte
+	puts    int
+}
+
+func (b *fakeBucket) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=AKID/") {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	key := strings.TrimPrefix(r.URL.Path, "/bucket/")
+	switch {
+	case r.Method == http.MethodPut:
+		body, _ := io.ReadAll(r.Body)
+		sum := sha256.Sum256(body)
+		if r.Header.Get("X-Amz-Content-Sha256") != hex.EncodeToString(sum[:]) {
+			b.t.Errorf("PUT %s: payload hash does not match the body", key)
+		}
+		b.objects[key] = body
+		b.puts++
+	case r.Method == http.MethodDelete:
+		delete(b.objects, key)
+		w.WriteHeader(http.StatusNoContent)
+	case r.Method == http.MethodGet && key == "":
+		// Two keys per page, so listings must follow the marker
+		var keys []string
+		for k := range b.objects {
+			if strings.HasPrefix(k, r.URL.Query().Get("prefix")) && k > r.URL.Query().Get("marker") {
+				keys = append(keys, k)
+			}
+		}
+		sort.Strings(keys)
+		type object struct {
+			Key          string
+			Size         int
+			LastModified string
+		}
+		page := struct {
+			XMLName     xml.Name `xml:"ListBucketResult"`
+			IsTruncated bool
+			Contents    []object
+		}{IsTruncated: len(keys) > 2}
+		for i := 0; i < len(keys) && i < 2; i++ {
+			page.Contents = append(page.Contents, object{keys[i], len(b.objects[keys[i]]), "2026-01-02T03:04:05.000Z"})
+		}
+		xml.NewEncoder(w).Encode(page)
+	case r.Method == http.MethodGet:
+		body, ok := b.objects[key]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			io.WriteString(w, "<Error><Code>NoSuchKey</Code><Message>The specified key does not exist.</Message></Error>")
+			return
+		}
+		w.Write(body)
+	}
+}
+
+func TestObjectBackupTarget(t *testing.T) {
+	bucket := &fakeBucket{t: t, objects: map[string][]byte{"other/raft3d-x.json.gz": []byte("elsewhere")}}
+	srv := httptest.NewServer(bucket)
+	defer srv.Close()
+	t.Setenv("AWS_ENDPOINT_URL", srv.URL)
+	t.Setenv("AWS_ACCESS_KEY_ID", "AKID")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+
+	target, err := NewBackupTarget("s3://bucket/backups/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := []string{"raft3d-20260101T000000Z-1.json.gz", "raft3d-20260102T000000Z-2.json.gz", "raft3d-20260103T000000Z-3.json.gz"}
+	for _, name := range names {
+		if err := target.Write(name, strings.NewReader("backup "+name)); err != nil {
+			t.Fatalf("Write %s: %s", name, err)
+		}
+		if err := target.Write(ManifestName(name), strings.NewReader("{}")); err != nil {
+			t.Fatalf("Write manifest of %s: %s", name, err)
+		}
+	}
+
+	backups, err := target.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(backups) != 3 || backups[0].Name != names[2] || backups[2].Name != names[0] {
+		t.Fatalf("List = %+v, want the three backups newest first", backups)
+	}
+
+	f, err := OpenBackup("s3://bucket/backups/" + names[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(f)
+	f.Close()
+	if string(body) != "backup "+names[1] {
+		t.Fatalf("read back %q", body)
+	}
+
+	if err := target.Remove(names[0]); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := target.Open(names[0]); err == nil || !strings.Contains(err.Error(), "not exist") {
+		t.Fatalf("opening a removed backup = %v, want a not-exist error", err)
+	}
+	if _, ok := bucket.objects["other/raft3d-x.json.gz"]; !ok {
+		t.Fatal("an object outside the prefix was touched")
+	}
+}
+
+func TestObjectBackupTargetNeedsCredentials(t *testing.T) {
+	t.Setenv("AWS_ACCESS_KEY_ID", "")
+	t.Setenv("GCS_HMAC_ACCESS_ID", "")
+	for _, location := range []string{"s3://bucket", "gs://bucket/prefix"} {
+		if _, err := NewBackupTarget(location); err == nil {
+			t.Errorf("NewBackupTarget(%s) succeeded without credentials", location)
+		}
+	}
+}
+
+func TestLogArchiveShipsToObjectStore(t *testing.T) {
+	bucket := &fakeBucket{t: t, objects: map[string][]byte{}}
+	srv := httptest.NewServer(bucket)
+	defer srv.Close()
+	t.Setenv("AWS_ENDPOINT_URL", srv.URL)
+	t.Setenv("AWS_ACCESS_KEY_ID", "AKID")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+	t.Setenv(EncryptionKeyEnv, "")
+
+	target, err := NewBackupTarget("s3://bucket/wal/node1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	archiver, err := NewLogArchiver(dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	archiver.segmentSize = 2
+	archiver.ShipTo(target)
+
+	apply := func(index uint64, key string) {
+		cmd := `{"op":"set","key":"` + key + `","value":"{}"}`
+		if err := archiver.Append(&raft.Log{Index: index, Term: 1, Type: raft.LogCommand, Data: []byte(cmd)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	apply(1, "printer_p1")
+	apply(2, "printer_p2")
+	apply(3, "printer_p3")
+	if err := archiver.Ship(); err != nil {
+		t.Fatal(err)
+	}
+	if bucket.puts != 2 {
+		t.Fatalf("first shipment uploaded %d segments, want 2", bucket.puts)
+	}
+
+	// Only the segment that grew is shipped again
+	apply(4, "printer_p4")
+	if err := archiver.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if bucket.puts != 3 {
+		t.Fatalf("%d uploads after the segment grew, want 3", bucket.puts)
+	}
+
+	// The shipped archive alone rebuilds the state
+	os.RemoveAll(dir)
+	replayed, err := ReplayArchive("s3://bucket/wal/node1", nil, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if replayed.Index != 4 || len(replayed.Data) != 4 {
+		t.Fatalf("replayed %d keys at index %d, want 4 at 4", len(replayed.Data), replayed.Index)
+	}
+}
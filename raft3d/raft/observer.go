@@ -0,0 +1,78 @@
+package raft
+
+import (
+	"time"
+
+	"github.com/hashicorp/raft"
+
+	"raft3d/events"
+)
+
+// Cluster event types published on the event bus
+const (
+	EventLeaderChanged    = "cluster.leader_changed"
+	EventPeerAdded        = "cluster.peer_added"
+	EventPeerRemoved      = "cluster.peer_removed"
+	EventHeartbeatFailed  = "cluster.heartbeat_failed"
+	EventHeartbeatResumed = "cluster.heartbeat_resumed"
+	EventStateChanged     = "cluster.state_changed"
+)
+
+// observeCluster forwards Raft observations to the event bus until shutdown
+func (s *RaftStore) observeCluster(bus *events.Bus) {
+	ch := make(chan raft.Observation, 64)
+	observer := raft.NewObserver(ch, false, func(o *raft.Observation) bool {
+		switch o.Data.(type) {
+		case raft.LeaderObservation, raft.PeerObservation, raft.RaftState,
+			raft.FailedHeartbeatObservation, raft.ResumedHeartbeatObservation:
+			return true
+		}
+		return false
+	})
+	s.raft.RegisterObserver(observer)
+	defer s.raft.DeregisterObserver(observer)
+
+	node := string(s.raftConfig.LocalID)
+	for {
+		select {
+		case o := <-ch:
+			switch data := o.Data.(type) {
+			case raft.LeaderObservation:
+				bus.Publish(EventLeaderChanged, map[string]string{
+					"node_id":     node,
+					"leader_id":   string(data.LeaderID),
+					"leader_addr": string(data.LeaderAddr),
+				})
+			case raft.PeerObservation:
+				typ := EventPeerAdded
+				if data.Removed {
+					typ = EventPeerRemoved
+				}
+				bus.Publish(typ, map[string]string{
+					"node_id":   node,
+					"peer_id":   string(data.Peer.ID),
+					"peer_addr": string(data.Peer.Address),
+					"suffrage":  data.Peer.Suffrage.String(),
+				})
+			case raft.FailedHeartbeatObservation:
+				bus.Publish(EventHeartbeatFailed, map[string]string{
+					"node_id":      node,
+					"peer_id":      string(data.PeerID),
+					"last_contact": data.LastContact.UTC().Format(time.RFC3339),
+				})
+			case raft.ResumedHeartbeatObservation:
+				bus.Publish(EventHeartbeatResumed, map[string]string{
+					"node_id": node,
+					"peer_id": string(data.PeerID),
+				})
+			case raft.RaftState:
+				bus.Publish(EventStateChanged, map[string]string{
+					"node_id": node,
+					"state":   data.String(),
+				})
+			}
+		case <-s.shutdownCh:
+			return
+		}
+	}
+}
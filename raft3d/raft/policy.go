@@ -0,0 +1,187 @@
+package raft
+
+import (
+	"encoding/json"
+	"fmt"
+	stdlog "log"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+)
+
+// PolicyPrefix precedes the name of each stored policy
+const PolicyPrefix = "policy_"
+
+// policyRevisions is how many earlier versions a policy keeps
+const policyRevisions = 10
+
+// policyName matches valid policy names
+var policyName = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,63}$`)
+
+// Policy is a rule every create and update of some kinds of entity must
+// satisfy. It is stored in the FSM and checked while applying commands, so
+// every node enforces the same version of it at the same log index.
+type Policy struct {
+	Name       string   `json:"name"`
+	Kinds      []string `json:"kinds" validate:"required"` // key prefixes without the underscore, such as printjob
+	Expression string   `json:"expression" validate:"required"`
+	Message    string   `json:"message,omitempty"` // returned when a write breaks the rule
+	Disabled   bool     `json:"disabled,omitempty"`
+
+	Version   int              `json:"version"`
+	UpdatedAt *time.Time       `json:"updated_at,omitempty"`
+	UpdatedBy string           `json:"updated_by,omitempty"`
+	Previous  []PolicyRevision `json:"previous,omitempty"` // earlier versions, newest first
+}
+
+// PolicyRevision is an earlier version of a policy
+type PolicyRevision struct {
+	Version    int        `json:"version"`
+	Kinds      []string   `json:"kinds"`
+	Expression string     `json:"expression"`
+	Message    string     `json:"message,omitempty"`
+	Disabled   bool       `json:"disabled,omitempty"`
+	UpdatedAt  *time.Time `json:"updated_at,omitempty"`
+	UpdatedBy  string     `json:"updated_by,omitempty"`
+}
+
+// Check reports whether a policy has a valid name and kinds, and an
+// expression that compiles
+func (p Policy) Check() error {
+	if !policyName.MatchString(p.Name) {
+		return fmt.Errorf("%w: policy names are up to 64 lowercase letters, digits, - and _", ErrValidation)
+	}
+	if len(p.Kinds) == 0 {
+		return fmt.Errorf("%w: policies need at least one kind", ErrValidation)
+	}
+	for _, kind := range p.Kinds {
+		if kind == "" || strings.Contains(kind, "_") || kind+"_" == PolicyPrefix {
+			return fmt.Errorf("%w: kind %q is not one policies can apply to", ErrValidation, kind)
+		}
+	}
+	if _, err := compileExpr(p.Expression); err != nil {
+		return fmt.Errorf("%w: expression: %s", ErrValidation, err)
+	}
+	return nil
+}
+
+// Revise returns the policy with next's rule, a version higher, keeping
+// the current version among the earlier ones
+func (p Policy) Revise(next Policy, at time.Time, by string) Policy {
+	next.Name = p.Name
+	next.Version = p.Version + 1
+	next.UpdatedAt, next.UpdatedBy = &at, by
+	next.Previous = append([]PolicyRevision{{
+		Version:    p.Version,
+		Kinds:      p.Kinds,
+		Expression: p.Expression,
+		Message:    p.Message,
+		Disabled:   p.Disabled,
+		UpdatedAt:  p.UpdatedAt,
+		UpdatedBy:  p.UpdatedBy,
+	}}, p.Previous...)
+	if len(next.Previous) > policyRevisions {
+		next.Previous = next.Previous[:policyRevisions]
+	}
+	return next
+}
+
+// compiledPolicy is a stored policy ready to evaluate
+type compiledPolicy struct {
+	Policy
+	rule expr
+}
+
+// loadPolicies compiles the stored policies, in name order, if they changed
+// since they were last compiled. The caller must hold the mutex.
+func (f *FSM) loadPolicies() []compiledPolicy {
+	if f.policiesLoaded {
+		return f.policies
+	}
+	f.policies, f.policiesLoaded = nil, true
+	var keys []string
+	for key := range f.data {
+		if strings.HasPrefix(key, PolicyPrefix) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		var policy Policy
+		if err := json.Unmarshal([]byte(f.data[key]), &policy); err != nil || policy.Disabled {
+			continue
+		}
+		rule, err := compileExpr(policy.Expression)
+		if err != nil {
+			// The API checks expressions before storing them, so this is
+			// a policy written some other way; ignoring it is the same
+			// on every node
+			stdlog.Printf("Ignoring policy %s: %s", policy.Name, err)
+			continue
+		}
+		f.policies = append(f.policies, compiledPolicy{Policy: policy, rule: rule})
+	}
+	return f.policies
+}
+
+// checkPolicies evaluates the policies for every entity a command creates
+// or updates, before anything is written. The caller must hold the mutex.
+func (f *FSM) checkPolicies(cmd Command) error {
+	policies := f.loadPolicies()
+	if len(policies) == 0 {
+		return nil
+	}
+
+	writes := make(map[string]string, len(cmd.Values)+1)
+	switch cmd.Op {
+	case "set", "create", "create_with_id":
+		writes[cmd.Key] = cmd.Value
+	}
+	for key, value := range cmd.Values {
+		writes[key] = value
+	}
+	keys := make([]string, 0, len(writes))
+	for key := range writes {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		kind := strings.TrimSuffix(keyPrefix(key), "_")
+		vars := map[string]interface{}{"kind": kind, "op": "create", "old": nil, "actor": cmd.Actor}
+		vars["entity"] = policyValue(writes[key])
+		// A create_with_id key is only the prefix, so there is nothing old
+		if old, exists := f.data[key]; exists && cmd.Op != "create_with_id" {
+			vars["op"], vars["old"] = "update", policyValue(old)
+		}
+		for _, policy := range policies {
+			if !slices.Contains(policy.Kinds, kind) {
+				continue
+			}
+			result, err := policy.rule.eval(vars)
+			if err != nil {
+				return fmt.Errorf("%w: policy %s could not be evaluated for %s: %s", ErrPolicyViolation, policy.Name, key, err)
+			}
+			if result != true {
+				message := policy.Message
+				if message == "" {
+					message = "requires " + policy.Expression
+				}
+				return fmt.Errorf("%w: policy %s (version %d) rejects %s: %s", ErrPolicyViolation, policy.Name, policy.Version, key, message)
+			}
+		}
+	}
+	return nil
+}
+
+// policyValue decodes a stored value for an expression; values that aren't
+// JSON are strings
+func policyValue(value string) interface{} {
+	var decoded interface{}
+	if err := json.Unmarshal([]byte(value), &decoded); err != nil {
+		return value
+	}
+	return decoded
+}
@@ -0,0 +1,90 @@
+package raft
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/raft"
+)
+
+func TestExpressions(t *testing.T) {
+	vars := map[string]interface{}{
+		"entity": policyValue(`{"weight":600,"status":"Queued","labels":{"approved":"true"},"tags":["a","b"]}`),
+		"old":    nil,
+	}
+	for src, want := range map[string]interface{}{
+		`entity.weight <= 500 || entity.labels.approved == "true"`:     true,
+		`entity.weight > 500 && !has(entity.labels.rush)`:              true,
+		`entity["status"] in ['Queued', 'Running']`:                    true,
+		`"approved" in entity.labels && size(entity.tags) == 2`:        true,
+		`entity.weight * 2 - 200 == 1000 && entity.weight / 3 > 199.9`: true,
+		`lower(entity.status) + "!" == "queued!"`:                      true,
+		`old == null && old.status == null`:                            true,
+		`entity.tags[1] == "b" && entity.tags[5] == null`:              true,
+		`-entity.weight < 0 && (1 + 2) * 3 == 9`:                       true,
+		`entity.status < "R" && 1 != "1"`:                              true,
+	} {
+		rule, err := compileExpr(src)
+		if err != nil {
+			t.Errorf("%s: %v", src, err)
+			continue
+		}
+		if got, err := rule.eval(vars); err != nil || got != want {
+			t.Errorf("%s = %v, %v", src, got, err)
+		}
+	}
+
+	for _, src := range []string{`entity.weight >`, `(1`, `has(1)`, `entity.`, `'open`, `1 2`, strings.Repeat("(", 100) + "1" + strings.Repeat(")", 100)} {
+		if _, err := compileExpr(src); err == nil {
+			t.Errorf("%s compiled", src)
+		}
+	}
+	for _, src := range []string{`entity.missing > 1`, `entity.weight || true`, `unknown == 1`, `1 / 0 == 1`, `entity.weight.x == 1`} {
+		rule, _ := compileExpr(src)
+		if _, err := rule.eval(vars); err == nil {
+			t.Errorf("%s evaluated", src)
+		}
+	}
+}
+
+func TestPoliciesEnforcedByFSM(t *testing.T) {
+	fsm := NewFSM()
+	index := uint64(0)
+	apply := func(cmd string) error {
+		index++
+		return fsm.Apply(&raft.Log{Index: index, Type: raft.LogCommand, Data: []byte(cmd)}).(ApplyResult).Err
+	}
+	if err := apply(`{"op":"set","key":"policy_big-jobs","value":"{\"name\":\"big-jobs\",\"kinds\":[\"printjob\"],\"expression\":\"entity.print_weight_in_grams <= 500 || entity.labels.approved == 'true'\",\"message\":\"jobs over 500g need label approved=true\",\"version\":2}"}`); err != nil {
+		t.Fatal(err)
+	}
+
+	err := apply(`{"op":"set_many","values":{"printjob_a":"{\"print_weight_in_grams\":600}","printer_p1":"{}"}}`)
+	if !errors.Is(err, ErrPolicyViolation) || !strings.Contains(err.Error(), "big-jobs (version 2) rejects printjob_a: jobs over 500g need label approved=true") {
+		t.Fatalf("violation: %v", err)
+	}
+	if _, err := fsm.Get("printer_p1"); !errors.Is(err, ErrNotFound) {
+		t.Fatal("a rejected command wrote part of its values")
+	}
+	if result := fsm.Apply(&raft.Log{Index: 99, Type: raft.LogCommand, Data: []byte(`{"op":"create_with_id","key":"printjob_","value":"{\"print_weight_in_grams\":900}"}`)}).(ApplyResult); result.Code != ApplyCodePolicy {
+		t.Fatalf("create_with_id: %+v", result)
+	}
+	for _, cmd := range []string{
+		`{"op":"set","key":"printjob_a","value":"{\"print_weight_in_grams\":600,\"labels\":{\"approved\":\"true\"}}"}`,
+		`{"op":"set","key":"printjob_b","value":"{\"print_weight_in_grams\":100}"}`,
+		`{"op":"set","key":"filament_f1","value":"{\"print_weight_in_grams\":900}"}`,
+		`{"op":"delete","key":"printjob_a"}`,
+	} {
+		if err := apply(cmd); err != nil {
+			t.Fatalf("%s: %v", cmd, err)
+		}
+	}
+
+	// Disabling the policy takes effect at the entry that disables it
+	if err := apply(`{"op":"set","key":"policy_big-jobs","value":"{\"name\":\"big-jobs\",\"kinds\":[\"printjob\"],\"expression\":\"false\",\"disabled\":true}"}`); err != nil {
+		t.Fatal(err)
+	}
+	if err := apply(`{"op":"set","key":"printjob_c","value":"{\"print_weight_in_grams\":900}"}`); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,142 @@
+package raft
+
+import (
+	"io"
+	"sync"
+
+	"github.com/hashicorp/raft"
+)
+
+// replicationProgress records, on the leader, the last log index each peer
+// has acknowledged. hashicorp/raft keeps its match indexes private, so they
+// are taken from the responses to the leader's own replication RPCs.
+type replicationProgress struct {
+	mutex sync.Mutex
+	match map[raft.ServerID]uint64
+}
+
+func newReplicationProgress() *replicationProgress {
+	return &replicationProgress{match: make(map[raft.ServerID]uint64)}
+}
+
+// appended records a successful AppendEntries. Heartbeats carry no log
+// position and are ignored.
+func (p *replicationProgress) appended(id raft.ServerID, args *raft.AppendEntriesRequest, resp *raft.AppendEntriesResponse) {
+	if resp == nil || !resp.Success {
+		return
+	}
+	match := args.PrevLogEntry
+	if n := len(args.Entries); n > 0 {
+		match = args.Entries[n-1].Index
+	}
+	if match == 0 {
+		return
+	}
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.match[id] = match
+}
+
+// installed records a snapshot the peer accepted
+func (p *replicationProgress) installed(id raft.ServerID, args *raft.InstallSnapshotRequest, resp *raft.InstallSnapshotResponse) {
+	if resp == nil || !resp.Success {
+		return
+	}
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.match[id] = args.LastLogIndex
+}
+
+// matchIndex returns the last index the peer acknowledged to this node, and
+// whether it acknowledged any
+func (p *replicationProgress) matchIndex(id raft.ServerID) (uint64, bool) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	match, ok := p.match[id]
+	return match, ok
+}
+
+// progressTransport wraps a transport and records what peers acknowledge
+type progressTransport struct {
+	raft.Transport
+	progress *replicationProgress
+}
+
+func (t *progressTransport) AppendEntries(id raft.ServerID, target raft.ServerAddress, args *raft.AppendEntriesRequest, resp *raft.AppendEntriesResponse) error {
+	if err := t.Transport.AppendEntries(id, target, args, resp); err != nil {
+		return err
+	}
+	t.progress.appended(id, args, resp)
+	return nil
+}
+
+func (t *progressTransport) AppendEntriesPipeline(id raft.ServerID, target raft.ServerAddress) (raft.AppendPipeline, error) {
+	pipeline, err := t.Transport.AppendEntriesPipeline(id, target)
+	if err != nil {
+		return nil, err
+	}
+	p := &progressPipeline{
+		AppendPipeline: pipeline,
+		id:             id,
+		progress:       t.progress,
+		consumer:       make(chan raft.AppendFuture),
+		shutdownCh:     make(chan struct{}),
+	}
+	go p.forward()
+	return p, nil
+}
+
+func (t *progressTransport) InstallSnapshot(id raft.ServerID, target raft.ServerAddress, args *raft.InstallSnapshotRequest, resp *raft.InstallSnapshotResponse, data io.Reader) error {
+	if err := t.Transport.InstallSnapshot(id, target, args, resp, data); err != nil {
+		return err
+	}
+	t.progress.installed(id, args, resp)
+	return nil
+}
+
+// Close closes the wrapped transport so Raft shutdown still releases it
+func (t *progressTransport) Close() error {
+	if closer, ok := t.Transport.(raft.WithClose); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+// progressPipeline records pipelined AppendEntries as Raft consumes them
+type progressPipeline struct {
+	raft.AppendPipeline
+	id       raft.ServerID
+	progress *replicationProgress
+
+	consumer   chan raft.AppendFuture
+	shutdownCh chan struct{}
+	closeOnce  sync.Once
+}
+
+// forward passes completed futures on to Raft in order, recording each
+func (p *progressPipeline) forward() {
+	for {
+		select {
+		case future := <-p.AppendPipeline.Consumer():
+			if future.Error() == nil {
+				p.progress.appended(p.id, future.Request(), future.Response())
+			}
+			select {
+			case p.consumer <- future:
+			case <-p.shutdownCh:
+				return
+			}
+		case <-p.shutdownCh:
+			return
+		}
+	}
+}
+
+func (p *progressPipeline) Consumer() <-chan raft.AppendFuture {
+	return p.consumer
+}
+
+func (p *progressPipeline) Close() error {
+	p.closeOnce.Do(func() { close(p.shutdownCh) })
+	return p.AppendPipeline.Close()
+}
@@ -0,0 +1,373 @@
+package raft
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// sqliteDrivers are the database/sql drivers the projection can use, in
+// order of preference: modernc.org/sqlite, which needs no cgo, and
+// github.com/mattn/go-sqlite3. One of them must be linked into the binary.
+var sqliteDrivers = []string{"sqlite", "sqlite3"}
+
+const (
+	// defaultQueryLimit and maxQueryLimit bound the rows a query returns
+	defaultQueryLimit = 1000
+	maxQueryLimit     = 10000
+
+	// projectionRetryDelay is how long the projection waits after a failed
+	// write before trying again
+	projectionRetryDelay = time.Second
+)
+
+// projectionViewName matches kinds that get a view of their own
+var projectionViewName = regexp.MustCompile(`^[a-z][a-z0-9]*$`)
+
+// projectionSchema creates the table every key is projected into. Values
+// that are JSON go in data, for json_extract; anything else goes in raw.
+const projectionSchema = `
+CREATE TABLE IF NOT EXISTS entities (
+	key  TEXT PRIMARY KEY,
+	kind TEXT NOT NULL,
+	id   TEXT NOT NULL,
+	data TEXT,
+	raw  TEXT
+);
+CREATE INDEX IF NOT EXISTS entities_kind ON entities (kind, id);
+CREATE TABLE IF NOT EXISTS projection (
+	applied_index INTEGER NOT NULL
+);
+DELETE FROM entities;
+DELETE FROM projection;
+INSERT INTO projection (applied_index) VALUES (0);
+`
+
+// QueryResult holds the rows of a query against the SQL projection
+type QueryResult struct {
+	Columns      []string        `json:"columns"`
+	Rows         [][]interface{} `json:"rows"`
+	Truncated    bool            `json:"truncated"`     // more rows matched than the limit
+	AppliedIndex uint64          `json:"applied_index"` // last log entry the projection reflects
+}
+
+// projectedValue is a key's latest value, or its deletion, waiting to be
+// written to the projection
+type projectedValue struct {
+	value   string
+	deleted bool
+}
+
+// sqlProjection mirrors the FSM into a local SQLite database for ad-hoc
+// reporting. The FSM hands it changes as they are applied; they are
+// coalesced and written in the background, so SQL never slows down Apply.
+// The projection is rebuilt from scratch whenever the node starts.
+type sqlProjection struct {
+	writer *sql.DB
+	reader *sql.DB
+
+	mutex   sync.Mutex
+	pending map[string]projectedValue
+	reset   bool   // clear the table before writing pending
+	index   uint64 // index pending brings the projection up to
+	views   map[string]bool
+	lastErr string
+
+	applied uint64 // index reflected in the database, accessed atomically
+	wake    chan struct{}
+	stop    chan struct{}
+	done    chan struct{}
+}
+
+// openSQLProjection creates or clears the projection database at path
+func openSQLProjection(path string) (*sqlProjection, error) {
+	driver := ""
+	for _, name := range sqliteDrivers {
+		for _, registered := range sql.Drivers() {
+			if name == registered && driver == "" {
+				driver = name
+			}
+		}
+	}
+	if driver == "" {
+		return nil, fmt.Errorf("%w: the SQL projection needs a SQLite driver; build with -tags sqlite", ErrValidation)
+	}
+
+	writer, err := sql.Open(driver, path)
+	if err != nil {
+		return nil, err
+	}
+	// SQLite allows one writer; readers use their own handle so a long
+	// report doesn't hold up the projection, and WAL keeps them apart
+	writer.SetMaxOpenConns(1)
+	if _, err := writer.Exec("PRAGMA journal_mode = WAL"); err != nil {
+		writer.Close()
+		return nil, fmt.Errorf("SQL projection %s: %w", path, err)
+	}
+	if _, err := writer.Exec(projectionSchema); err != nil {
+		writer.Close()
+		return nil, fmt.Errorf("SQL projection %s: %w", path, err)
+	}
+	reader, err := sql.Open(driver, path)
+	if err != nil {
+		writer.Close()
+		return nil, err
+	}
+
+	p := &sqlProjection{
+		writer:  writer,
+		reader:  reader,
+		pending: make(map[string]projectedValue),
+		views:   make(map[string]bool),
+		wake:    make(chan struct{}, 1),
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	go p.run()
+	return p, nil
+}
+
+// changed records the values of keys written by the entry at index. The
+// FSM calls it with its mutex held, passing the current data.
+func (p *sqlProjection) changed(index uint64, keys []string, data map[string]string) {
+	p.mutex.Lock()
+	for _, key := range keys {
+		value, ok := data[key]
+		p.pending[key] = projectedValue{value: value, deleted: !ok}
+	}
+	p.index = index
+	p.mutex.Unlock()
+	p.signal()
+}
+
+// restored replaces the projection with the state of a restored snapshot
+func (p *sqlProjection) restored(index uint64, data map[string]string) {
+	p.mutex.Lock()
+	p.pending = make(map[string]projectedValue, len(data))
+	for key, value := range data {
+		p.pending[key] = projectedValue{value: value}
+	}
+	p.reset = true
+	p.index = index
+	p.mutex.Unlock()
+	p.signal()
+}
+
+func (p *sqlProjection) signal() {
+	select {
+	case p.wake <- struct{}{}:
+	default:
+	}
+}
+
+// run writes pending changes until the projection is closed
+func (p *sqlProjection) run() {
+	defer close(p.done)
+	for {
+		select {
+		case <-p.wake:
+		case <-p.stop:
+			p.flush()
+			return
+		}
+		if err := p.flush(); err != nil {
+			log.Printf("Failed to update the SQL projection: %s", err)
+			select {
+			case <-time.After(projectionRetryDelay):
+				p.signal()
+			case <-p.stop:
+				return
+			}
+		}
+	}
+}
+
+// flush writes the pending changes in one transaction. On failure they are
+// put back, under anything that arrived meanwhile, to be retried.
+func (p *sqlProjection) flush() error {
+	p.mutex.Lock()
+	pending, reset, index := p.pending, p.reset, p.index
+	if len(pending) == 0 && !reset && index <= atomic.LoadUint64(&p.applied) {
+		p.mutex.Unlock()
+		return nil
+	}
+	p.pending, p.reset = make(map[string]projectedValue), false
+	p.mutex.Unlock()
+
+	err := p.write(pending, reset, index)
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if err != nil {
+		p.lastErr = err.Error()
+		if !p.reset {
+			for key, value := range p.pending {
+				pending[key] = value
+			}
+			p.pending, p.reset = pending, reset
+		}
+		return err
+	}
+	p.lastErr = ""
+	atomic.StoreUint64(&p.applied, index)
+	return nil
+}
+
+func (p *sqlProjection) write(pending map[string]projectedValue, reset bool, index uint64) error {
+	tx, err := p.writer.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if reset {
+		if _, err := tx.Exec("DELETE FROM entities"); err != nil {
+			return err
+		}
+	}
+	upsert, err := tx.Prepare("INSERT OR REPLACE INTO entities (key, kind, id, data, raw) VALUES (?, ?, ?, ?, ?)")
+	if err != nil {
+		return err
+	}
+	defer upsert.Close()
+	remove, err := tx.Prepare("DELETE FROM entities WHERE key = ?")
+	if err != nil {
+		return err
+	}
+	defer remove.Close()
+
+	var views []string
+	for key, change := range pending {
+		if change.deleted {
+			if _, err := remove.Exec(key); err != nil {
+				return err
+			}
+			continue
+		}
+		prefix := keyPrefix(key)
+		kind := strings.TrimSuffix(prefix, "_")
+		var data, raw interface{}
+		if json.Valid([]byte(change.value)) {
+			data = change.value
+		} else {
+			raw = change.value
+		}
+		if _, err := upsert.Exec(key, kind, strings.TrimPrefix(key, prefix), data, raw); err != nil {
+			return err
+		}
+		if !p.views[kind] && projectionViewName.MatchString(kind) {
+			views = append(views, kind)
+		}
+	}
+	// A view per kind, such as printer or printjob, saves filtering on kind
+	for _, kind := range views {
+		view := fmt.Sprintf(`CREATE VIEW IF NOT EXISTS "%s" AS SELECT id, data FROM entities WHERE kind = '%s'`, kind, kind)
+		if _, err := tx.Exec(view); err != nil {
+			return err
+		}
+	}
+	if _, err := tx.Exec("UPDATE projection SET applied_index = ?", int64(index)); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	for _, kind := range views {
+		p.views[kind] = true
+	}
+	return nil
+}
+
+// query runs a read-only statement, returning at most limit rows
+func (p *sqlProjection) query(ctx context.Context, statement string, args []interface{}, limit int) (QueryResult, error) {
+	if limit <= 0 {
+		limit = defaultQueryLimit
+	}
+	if limit > maxQueryLimit {
+		limit = maxQueryLimit
+	}
+	result := QueryResult{AppliedIndex: atomic.LoadUint64(&p.applied)}
+
+	conn, err := p.reader.Conn(ctx)
+	if err != nil {
+		return result, err
+	}
+	defer conn.Close()
+	// Every query sets this, since the pool may hand out a fresh connection
+	if _, err := conn.ExecContext(ctx, "PRAGMA query_only = ON"); err != nil {
+		return result, err
+	}
+	rows, err := conn.QueryContext(ctx, statement, args...)
+	if err != nil {
+		return result, queryError(ctx, err)
+	}
+	defer rows.Close()
+
+	if result.Columns, err = rows.Columns(); err != nil {
+		return result, queryError(ctx, err)
+	}
+	result.Rows = [][]interface{}{}
+	for rows.Next() {
+		if len(result.Rows) == limit {
+			result.Truncated = true
+			break
+		}
+		values := make([]interface{}, len(result.Columns))
+		pointers := make([]interface{}, len(values))
+		for i := range values {
+			pointers[i] = &values[i]
+		}
+		if err := rows.Scan(pointers...); err != nil {
+			return result, queryError(ctx, err)
+		}
+		for i, value := range values {
+			if b, ok := value.([]byte); ok {
+				values[i] = string(b)
+			}
+		}
+		result.Rows = append(result.Rows, values)
+	}
+	if err := rows.Err(); err != nil {
+		return result, queryError(ctx, err)
+	}
+	return result, nil
+}
+
+// queryError reports why a query failed: it ran out of time, or SQLite
+// rejected it, which is the caller's fault
+func queryError(ctx context.Context, err error) error {
+	if ctx.Err() != nil {
+		return fmt.Errorf("%w: query: %s", ErrTimeout, ctx.Err())
+	}
+	return fmt.Errorf("%w: %s", ErrValidation, err)
+}
+
+// status reports the index the projection has reached and its last error
+func (p *sqlProjection) status() (uint64, string) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return atomic.LoadUint64(&p.applied), p.lastErr
+}
+
+// Close writes what is pending and closes the database
+func (p *sqlProjection) Close() error {
+	close(p.stop)
+	<-p.done
+	return errors.Join(p.reader.Close(), p.writer.Close())
+}
+
+// Query runs a read-only SQL statement against the node's SQL projection of
+// the state, which may trail the FSM by a moment
+func (s *RaftStore) Query(ctx context.Context, statement string, args []interface{}, limit int) (QueryResult, error) {
+	if s.fsm.projection == nil {
+		return QueryResult{}, fmt.Errorf("%w: the SQL projection is not enabled on this node", ErrConflict)
+	}
+	return s.fsm.projection.query(ctx, statement, args, limit)
+}
@@ -0,0 +1,211 @@
+package raft
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/raft"
+)
+
+// fakeSQLite stands in for a SQLite driver. It understands the statements
+// the projection writes with; any query returns key, data and raw of every
+// entity, and "BAD" in a query is a syntax error.
+type fakeSQLite struct {
+	mutex sync.Mutex
+	dbs   map[string]*fakeDatabase
+}
+
+type fakeDatabase struct {
+	entities  map[string][3]interface{} // kind, data, raw
+	views     map[string]bool
+	applied   int64
+	queryOnly bool // set on a connection before a query was run
+}
+
+var fakeSQLiteDriver = &fakeSQLite{dbs: make(map[string]*fakeDatabase)}
+
+func init() {
+	sql.Register("sqlite", fakeSQLiteDriver)
+}
+
+func (d *fakeSQLite) Open(name string) (driver.Conn, error) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	if d.dbs[name] == nil {
+		d.dbs[name] = &fakeDatabase{entities: make(map[string][3]interface{}), views: make(map[string]bool)}
+	}
+	return &fakeConn{driver: d, db: d.dbs[name]}, nil
+}
+
+// database returns a copy of a fake database's entities and state
+func (d *fakeSQLite) database(name string) (map[string][3]interface{}, map[string]bool, int64, bool) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	db := d.dbs[name]
+	entities := make(map[string][3]interface{})
+	for k, v := range db.entities {
+		entities[k] = v
+	}
+	views := make(map[string]bool)
+	for k := range db.views {
+		views[k] = true
+	}
+	return entities, views, db.applied, db.queryOnly
+}
+
+type fakeConn struct {
+	driver    *fakeSQLite
+	db        *fakeDatabase
+	queryOnly bool
+}
+
+func (c *fakeConn) Prepare(query string) (driver.Stmt, error) {
+	return &fakeStmt{conn: c, query: query}, nil
+}
+func (c *fakeConn) Close() error              { return nil }
+func (c *fakeConn) Begin() (driver.Tx, error) { return fakeTx{}, nil }
+
+type fakeTx struct{}
+
+func (fakeTx) Commit() error   { return nil }
+func (fakeTx) Rollback() error { return nil }
+
+type fakeStmt struct {
+	conn  *fakeConn
+	query string
+}
+
+func (s *fakeStmt) Close() error  { return nil }
+func (s *fakeStmt) NumInput() int { return -1 }
+
+func (s *fakeStmt) Exec(args []driver.Value) (driver.Result, error) {
+	s.conn.driver.mutex.Lock()
+	defer s.conn.driver.mutex.Unlock()
+	db, query := s.conn.db, s.query
+	switch {
+	case strings.HasPrefix(query, "PRAGMA query_only"):
+		s.conn.queryOnly = true
+	case s.conn.queryOnly:
+		return nil, errors.New("attempt to write a readonly database")
+	case strings.HasPrefix(query, "INSERT OR REPLACE INTO entities"):
+		db.entities[args[0].(string)] = [3]interface{}{args[1], args[3], args[4]}
+	case query == "DELETE FROM entities WHERE key = ?":
+		delete(db.entities, args[0].(string))
+	case query == "DELETE FROM entities":
+		db.entities = make(map[string][3]interface{})
+	case strings.HasPrefix(query, "CREATE VIEW"):
+		db.views[strings.Split(query, `"`)[1]] = true
+	case strings.HasPrefix(query, "UPDATE projection"):
+		db.applied = args[0].(int64)
+	}
+	return driver.RowsAffected(1), nil
+}
+
+func (s *fakeStmt) Query(args []driver.Value) (driver.Rows, error) {
+	s.conn.driver.mutex.Lock()
+	defer s.conn.driver.mutex.Unlock()
+	if strings.Contains(s.query, "BAD") {
+		return nil, errors.New(`near "BAD": syntax error`)
+	}
+	s.conn.db.queryOnly = s.conn.queryOnly
+	rows := &fakeRows{}
+	for key, entity := range s.conn.db.entities {
+		rows.values = append(rows.values, []driver.Value{key, entity[1], entity[2]})
+	}
+	return rows, nil
+}
+
+type fakeRows struct {
+	values [][]driver.Value
+}
+
+func (r *fakeRows) Columns() []string { return []string{"key", "data", "raw"} }
+func (r *fakeRows) Close() error      { return nil }
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if len(r.values) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.values[0])
+	r.values = r.values[1:]
+	return nil
+}
+
+func TestSQLProjection(t *testing.T) {
+	addr, transport := raft.NewInmemTransport("")
+	store, err := NewRaftStore(StoreConfig{NodeID: "n1", RaftAddr: string(addr), Bootstrap: true, Transport: transport, InMemory: true, DataDir: t.TempDir(), SQLProjection: "projection-store"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { store.Close() })
+	waitFor(t, 10*time.Second, store.IsLeader, "node did not become leader")
+
+	store.Set("printer_p1", `{"id":"p1","status":"Idle"}`)
+	store.Set("filament_f1", `{"id":"f1"}`)
+	store.Set("note_n1", "not json")
+	store.Delete("filament_f1")
+	store.SetIf("printer_p1", `{}`, Condition{Key: "printer_p1", Field: "status", Equals: "Busy"})
+
+	applied := int64(store.AppliedIndex())
+	waitFor(t, 5*time.Second, func() bool {
+		_, _, index, _ := fakeSQLiteDriver.database("projection-store")
+		return index == applied
+	}, "projection did not catch up")
+	entities, views, _, _ := fakeSQLiteDriver.database("projection-store")
+	if got := entities["printer_p1"]; got != [3]interface{}{"printer", `{"id":"p1","status":"Idle"}`, nil} {
+		t.Fatalf("printer projected as %v", got)
+	}
+	if got := entities["note_n1"]; got != [3]interface{}{"note", nil, "not json"} {
+		t.Fatalf("non-JSON value projected as %v", got)
+	}
+	if _, ok := entities["filament_f1"]; ok {
+		t.Fatal("deleted filament is still projected")
+	}
+	if !views["printer"] || !views["note"] {
+		t.Fatalf("views: %v", views)
+	}
+
+	result, err := store.Query(context.Background(), "SELECT key, data, raw FROM entities", nil, 1)
+	if err != nil || len(result.Rows) != 1 || !result.Truncated || result.AppliedIndex != uint64(applied) || strings.Join(result.Columns, ",") != "key,data,raw" {
+		t.Fatalf("query: %+v, %v", result, err)
+	}
+	if _, _, _, queryOnly := fakeSQLiteDriver.database("projection-store"); !queryOnly {
+		t.Fatal("query ran on a writable connection")
+	}
+	if _, err := store.Query(context.Background(), "BAD", nil, 0); !errors.Is(err, ErrValidation) {
+		t.Fatalf("bad query: %v", err)
+	}
+	if store.Metrics()["sql_projection_index"] != uint64(applied) {
+		t.Fatalf("metrics: %v", store.Metrics())
+	}
+}
+
+func TestSQLProjectionRebuildsOnRestore(t *testing.T) {
+	projection, err := openSQLProjection("projection-restore")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer projection.Close()
+	fsm := NewFSM()
+	fsm.projection = projection
+
+	fsm.Apply(&raft.Log{Index: 1, Data: []byte(`{"op":"set","key":"printer_old","value":"{}"}`)})
+	if err := fsm.Restore(io.NopCloser(strings.NewReader(`{"raft3d_snapshot":1,"data":{"printer_new":"{}"},"index":7}`))); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, 5*time.Second, func() bool {
+		_, _, index, _ := fakeSQLiteDriver.database("projection-restore")
+		return index == 7
+	}, "projection did not catch up")
+	entities, _, _, _ := fakeSQLiteDriver.database("projection-restore")
+	if _, ok := entities["printer_new"]; !ok || len(entities) != 1 {
+		t.Fatalf("projection after restore: %v", entities)
+	}
+}
@@ -0,0 +1,57 @@
+package raft
+
+import (
+	"fmt"
+	"log"
+	"sync/atomic"
+)
+
+// Protocol versions. A node stamps the commands it logs and the join
+// requests it sends with ProtocolVersion and works with nodes one version
+// older or newer, so a cluster can be upgraded one node at a time.
+//
+// Version 2 commands carry the leader's time and their version; version 1 is
+// every command logged before, which carry neither.
+const (
+	ProtocolVersion    = 2
+	MinProtocolVersion = ProtocolVersion - 1
+)
+
+// CommandVersion returns the protocol version a command was logged with
+func CommandVersion(cmd Command) int {
+	if cmd.Version == 0 {
+		return 1
+	}
+	return cmd.Version
+}
+
+// CompatibleVersion reports whether a node speaking a protocol version can
+// run in a cluster with this one: at most one version apart. Zero is a node
+// too old to say, which speaks version 1.
+func CompatibleVersion(version int) bool {
+	if version == 0 {
+		version = 1
+	}
+	return version >= ProtocolVersion-1 && version <= ProtocolVersion+1
+}
+
+// newerCommandsSeen is set once this node has applied a command from a
+// newer protocol version, so it warns only once
+var newerCommandsSeen int32
+
+// checkCommandVersion rejects commands of a protocol version this node no
+// longer reads. Commands from a newer leader, during a rolling upgrade, are
+// applied as far as this node understands them; a node should not be more
+// than one version behind the leader.
+func checkCommandVersion(cmd Command) error {
+	version := CommandVersion(cmd)
+	switch {
+	case version < MinProtocolVersion:
+		return fmt.Errorf("%w: command of protocol version %d, this node reads %d to %d",
+			ErrIncompatibleVersion, version, MinProtocolVersion, ProtocolVersion)
+	case version > ProtocolVersion && atomic.CompareAndSwapInt32(&newerCommandsSeen, 0, 1):
+		log.Printf("Applying commands of protocol version %d, newer than this node's %d; upgrade it to finish the rolling upgrade",
+			version, ProtocolVersion)
+	}
+	return nil
+}
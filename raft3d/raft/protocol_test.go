@@ -0,0 +1,53 @@
+package raft
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/hashicorp/raft"
+)
+
+func TestCompatibleVersion(t *testing.T) {
+	tests := []struct {
+		version int
+		want    bool
+	}{
+		{0, ProtocolVersion <= 2}, // predates versions, so speaks 1
+		{ProtocolVersion - 1, true},
+		{ProtocolVersion, true},
+		{ProtocolVersion + 1, true},
+		{ProtocolVersion + 2, false},
+		{ProtocolVersion + 9, false},
+	}
+	for _, tt := range tests {
+		if got := CompatibleVersion(tt.version); got != tt.want {
+			t.Errorf("CompatibleVersion(%d) = %v, want %v", tt.version, got, tt.want)
+		}
+	}
+}
+
+func TestFSMAppliesCommandsOneVersionApart(t *testing.T) {
+	f := NewFSM()
+	apply := func(index uint64, cmd Command) error {
+		data, _ := json.Marshal(cmd)
+		return f.Apply(&raft.Log{Index: index, Data: data}).(ApplyResult).Err
+	}
+
+	// Commands logged before versions existed, and from a newer leader
+	if err := apply(1, Command{Op: "set", Key: "printer_old", Value: "{}"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := apply(2, Command{Op: "set", Key: "printer_new", Value: "{}", Version: ProtocolVersion + 1}); err != nil {
+		t.Fatal(err)
+	}
+	if CommandVersion(Command{}) != 1 {
+		t.Fatal("unversioned command not version 1")
+	}
+
+	// Versions this node no longer reads are rejected
+	err := checkCommandVersion(Command{Version: -1})
+	if !errors.Is(err, ErrIncompatibleVersion) || applyResult(3, "", err).Code != ApplyCodeVersion {
+		t.Fatalf("err = %v", err)
+	}
+}
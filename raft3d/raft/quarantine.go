@@ -0,0 +1,108 @@
+package raft
+
+import (
+	"encoding/json"
+	"fmt"
+	stdlog "log"
+	"runtime/debug"
+	"time"
+
+	"github.com/hashicorp/raft"
+)
+
+// QuarantinePrefix precedes the zero-padded log index of each quarantined
+// command, so they list in the order they were applied
+const QuarantinePrefix = "quarantine_"
+
+// quarantineCommandKept bounds how much of a quarantined command is kept
+const quarantineCommandKept = 4096
+
+// QuarantinedCommand is a log entry that panicked while being applied. Its
+// writes were undone and it was skipped; the record is stored in the FSM at
+// the same index on every node, so replicas stay identical.
+type QuarantinedCommand struct {
+	Index      uint64     `json:"index"`
+	Term       uint64     `json:"term"`
+	Op         string     `json:"op"`
+	Key        string     `json:"key,omitempty"`
+	Actor      string     `json:"actor,omitempty"`
+	Error      string     `json:"error"`
+	AppendedAt *time.Time `json:"appended_at,omitempty"`
+	Command    string     `json:"command"` // the encoded command, cut to 4 KiB
+	Truncated  bool       `json:"truncated,omitempty"`
+}
+
+// QuarantineKey returns the key a command quarantined at index is stored
+// under
+func QuarantineKey(index uint64) string {
+	return fmt.Sprintf("%s%020d", QuarantinePrefix, index)
+}
+
+// undoEntry is a key's value before the entry being applied wrote it
+type undoEntry struct {
+	key     string
+	value   string
+	existed bool
+}
+
+// applyIsolated performs a command, recovering from a panic by undoing what
+// the command wrote and quarantining the entry instead. A malformed command
+// then fails the same way on every node that replays it rather than
+// crashing them all. The caller must hold the mutex.
+func (f *FSM) applyIsolated(log *raft.Log, cmd Command) (entity string, err error) {
+	f.undo = f.undo[:0]
+	defer func() {
+		recovered := recover()
+		if recovered == nil {
+			return
+		}
+		stdlog.Printf("Command at index %d panicked and was quarantined: %v\n%s", log.Index, recovered, debug.Stack())
+		f.rollback()
+		entity, err = "", f.quarantine(log, cmd, recovered)
+	}()
+	return f.applyCommand(cmd)
+}
+
+// rollback restores every key written since the undo journal was reset,
+// newest first. The caller must hold the mutex.
+func (f *FSM) rollback() {
+	for i := len(f.undo) - 1; i >= 0; i-- {
+		u := f.undo[i]
+		if f.audit != nil {
+			current, exists := f.data[u.key]
+			f.audit.write(u.key, current, exists, u.value, u.existed)
+		}
+		if u.existed {
+			f.data[u.key] = u.value
+		} else {
+			delete(f.data, u.key)
+		}
+		f.touch(u.key)
+	}
+	f.undo = f.undo[:0]
+}
+
+// quarantine stores the record of a command that panicked and returns the
+// error it is rejected with. Everything recorded comes from the log entry,
+// never the local clock. The caller must hold the mutex.
+func (f *FSM) quarantine(log *raft.Log, cmd Command, recovered interface{}) error {
+	record := QuarantinedCommand{
+		Index:   log.Index,
+		Term:    log.Term,
+		Op:      cmd.Op,
+		Key:     cmd.Key,
+		Actor:   cmd.Actor,
+		Error:   fmt.Sprint(recovered),
+		Command: string(log.Data),
+	}
+	if !log.AppendedAt.IsZero() {
+		at := log.AppendedAt.UTC()
+		record.AppendedAt = &at
+	}
+	if len(record.Command) > quarantineCommandKept {
+		record.Command, record.Truncated = record.Command[:quarantineCommandKept], true
+	}
+	body, _ := json.Marshal(record)
+	f.put(QuarantineKey(log.Index), string(body))
+	return fmt.Errorf("%w: command at index %d panicked: %s", ErrQuarantined, log.Index, record.Error)
+}
@@ -0,0 +1,87 @@
+package raft
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/hashicorp/raft"
+)
+
+// panicRule is a policy rule that panics, standing in for a bug in Apply
+type panicRule struct{}
+
+func (panicRule) eval(map[string]interface{}) (interface{}, error) {
+	panic("rule exploded")
+}
+
+func TestPanickingCommandIsQuarantined(t *testing.T) {
+	apply := func(fsm *FSM) ApplyResult {
+		fsm.Apply(&raft.Log{Index: 1, Type: raft.LogCommand, Data: []byte(`{"op":"set","key":"printer_p1","value":"{}"}`)})
+		fsm.policies = []compiledPolicy{{Policy: Policy{Name: "broken", Kinds: []string{"printer"}}, rule: panicRule{}}}
+		fsm.policiesLoaded = true
+		return fsm.Apply(&raft.Log{Index: 2, Term: 3, Type: raft.LogCommand,
+			Data: []byte(`{"op":"set","key":"printer_p1","value":"{\"name\":\"bad\"}","actor":"alice"}`)}).(ApplyResult)
+	}
+
+	// Every replica skips the entry and records it the same way
+	first, second := NewFSM(), NewFSM()
+	result := apply(first)
+	apply(second)
+	if !errors.Is(result.Err, ErrQuarantined) || result.Code != ApplyCodeQuarantined || result.Index != 2 {
+		t.Fatalf("result = %+v", result)
+	}
+	if value, _ := first.Get("printer_p1"); value != "{}" {
+		t.Fatalf("quarantined command wrote %s", value)
+	}
+	a, _ := first.State()
+	b, _ := second.State()
+	if len(a) != len(b) || a[QuarantineKey(2)] != b[QuarantineKey(2)] {
+		t.Fatalf("replicas differ: %v vs %v", a, b)
+	}
+
+	var record QuarantinedCommand
+	if err := json.Unmarshal([]byte(a[QuarantineKey(2)]), &record); err != nil {
+		t.Fatal(err)
+	}
+	if record.Index != 2 || record.Term != 3 || record.Op != "set" || record.Key != "printer_p1" || record.Actor != "alice" || record.Error != "rule exploded" {
+		t.Fatalf("record = %+v", record)
+	}
+
+	// The next entry applies normally
+	first.policies, first.policiesLoaded = nil, true
+	if result := first.Apply(&raft.Log{Index: 3, Type: raft.LogCommand, Data: []byte(`{"op":"set","key":"printer_p2","value":"{}"}`)}).(ApplyResult); result.Err != nil {
+		t.Fatal(result.Err)
+	}
+}
+
+func TestRollbackUndoesWrites(t *testing.T) {
+	fsm := NewFSM()
+	fsm.data["printer_p1"] = "old"
+	fsm.undo = fsm.undo[:0]
+	fsm.put("printer_p1", "new")
+	fsm.put("printer_p2", "added")
+	fsm.remove("printer_p1")
+	fsm.rollback()
+	if len(fsm.data) != 1 || fsm.data["printer_p1"] != "old" {
+		t.Fatalf("data = %v", fsm.data)
+	}
+}
+
+func TestPanickingDryRunIsRejected(t *testing.T) {
+	fsm := NewFSM()
+	fsm.Apply(&raft.Log{Index: 1, Type: raft.LogCommand, Data: []byte(`{"op":"set","key":"printer_p1","value":"{}"}`)})
+	fsm.policies = []compiledPolicy{{Policy: Policy{Name: "broken", Kinds: []string{"printer"}}, rule: panicRule{}}}
+	fsm.policiesLoaded = true
+
+	_, err := fsm.dryRun([]byte(`{"op":"set","key":"printer_p1","value":"{\"name\":\"bad\"}"}`))
+	var applyErr *ApplyError
+	if !errors.Is(err, ErrQuarantined) || !errors.As(err, &applyErr) || applyErr.Code != ApplyCodeQuarantined || applyErr.Index != 2 {
+		t.Fatalf("dry run = %v", err)
+	}
+	// Nothing, not even the quarantine record, reaches the real state
+	state, _ := fsm.State()
+	if len(state) != 1 || state["printer_p1"] != "{}" {
+		t.Fatalf("state after the dry run: %v", state)
+	}
+}
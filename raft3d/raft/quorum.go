@@ -0,0 +1,143 @@
+package raft
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/raft"
+)
+
+// Quorum event types published on the event bus
+const (
+	EventQuorumLost     = "cluster.quorum_lost"
+	EventQuorumRestored = "cluster.quorum_restored"
+)
+
+// defaultQuorumLossThreshold is how long a follower may go without leader
+// contact before the node switches to read-only mode
+const defaultQuorumLossThreshold = 5 * time.Second
+
+// QuorumStatus reports whether the node believes the cluster has lost quorum
+type QuorumStatus struct {
+	Lost        bool       `json:"quorum_lost"`
+	Since       *time.Time `json:"quorum_lost_since,omitempty"`
+	LastContact *time.Time `json:"last_leader_contact,omitempty"`
+}
+
+// quorumMonitor tracks leader contact and flips the node into read-only mode
+// when it has been out of touch for longer than the threshold
+type quorumMonitor struct {
+	mutex     sync.RWMutex
+	threshold time.Duration
+	lostSince time.Time
+}
+
+// QuorumStatus returns the node's current view of cluster quorum
+func (s *RaftStore) QuorumStatus() QuorumStatus {
+	s.quorum.mutex.RLock()
+	defer s.quorum.mutex.RUnlock()
+
+	status := QuorumStatus{Lost: !s.quorum.lostSince.IsZero()}
+	if status.Lost {
+		since := s.quorum.lostSince
+		status.Since = &since
+	}
+	if s.raft.State() != raft.Leader {
+		if contact := s.raft.LastContact(); !contact.IsZero() {
+			status.LastContact = &contact
+		}
+	}
+	return status
+}
+
+// quorumLost reports whether writes should be refused because quorum is lost
+func (s *RaftStore) quorumLost() bool {
+	s.quorum.mutex.RLock()
+	defer s.quorum.mutex.RUnlock()
+
+	return !s.quorum.lostSince.IsZero()
+}
+
+// SetQuorumLossThreshold changes how long the node may go without leader
+// contact before it turns read-only
+func (s *RaftStore) SetQuorumLossThreshold(threshold time.Duration) error {
+	if threshold <= 0 {
+		return fmt.Errorf("%w: quorum loss threshold must be positive", ErrValidation)
+	}
+	s.quorum.mutex.Lock()
+	defer s.quorum.mutex.Unlock()
+
+	s.quorum.threshold = threshold
+	return nil
+}
+
+// quorumThreshold returns the current quorum loss threshold
+func (s *RaftStore) quorumThreshold() time.Duration {
+	s.quorum.mutex.RLock()
+	defer s.quorum.mutex.RUnlock()
+
+	return s.quorum.threshold
+}
+
+// monitorQuorum periodically checks leader contact until shutdown. The check
+// interval follows the threshold, which may change at runtime.
+func (s *RaftStore) monitorQuorum() {
+	interval := s.quorumThreshold() / 4
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	started := time.Now()
+	for {
+		select {
+		case <-ticker.C:
+			s.checkQuorum(started)
+			if next := s.quorumThreshold() / 4; next != interval {
+				interval = next
+				ticker.Reset(interval)
+			}
+		case <-s.shutdownCh:
+			return
+		}
+	}
+}
+
+// checkQuorum updates the quorum state. A leader steps down by itself once it
+// loses contact with a majority, so only followers and candidates can be
+// degraded: they are if no leader has been heard from within the threshold.
+func (s *RaftStore) checkQuorum(started time.Time) {
+	threshold := s.quorumThreshold()
+	lost := false
+	if s.raft.State() != raft.Leader {
+		lastContact := s.raft.LastContact()
+		if lastContact.IsZero() {
+			// Never heard from a leader; give a fresh node time to join
+			lastContact = started
+		}
+		lost = time.Since(lastContact) > threshold
+	}
+
+	s.quorum.mutex.Lock()
+	wasLost := !s.quorum.lostSince.IsZero()
+	switch {
+	case lost && !wasLost:
+		s.quorum.lostSince = time.Now().UTC()
+	case !lost && wasLost:
+		s.quorum.lostSince = time.Time{}
+	}
+	since := s.quorum.lostSince
+	s.quorum.mutex.Unlock()
+
+	if lost == wasLost {
+		return
+	}
+	node := string(s.raftConfig.LocalID)
+	if lost {
+		log.Printf("Quorum lost: no leader contact for %s, switching to read-only mode", threshold)
+		s.publish(EventQuorumLost, map[string]string{"node_id": node, "since": since.Format(time.RFC3339)})
+	} else {
+		log.Printf("Quorum restored, accepting writes again")
+		s.publish(EventQuorumRestored, map[string]string{"node_id": node})
+	}
+}
@@ -0,0 +1,93 @@
+package raft
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/hashicorp/raft"
+	raftboltdb "github.com/hashicorp/raft-boltdb/v2"
+	"go.etcd.io/bbolt"
+)
+
+// RecoverPeers rewrites the Raft configuration of an offline node from a
+// peers.json file, for clusters that have permanently lost quorum. Every
+// surviving node must be recovered with the same peers file before any of
+// them is started again. It refuses to run while the node is still live.
+// Encrypted nodes need the key in $RAFT3D_ENCRYPTION_KEY, since recovery
+// replays the log into a new snapshot.
+func RecoverPeers(dataDir, nodeID, peersPath string) (raft.Configuration, error) {
+	atRest, err := cipherFromEnv()
+	if err != nil {
+		return raft.Configuration{}, err
+	}
+
+	configuration, err := raft.ReadConfigJSON(peersPath)
+	if err != nil {
+		return raft.Configuration{}, fmt.Errorf("failed to read %s: %w", peersPath, err)
+	}
+
+	var self *raft.Server
+	for i, server := range configuration.Servers {
+		if server.ID == raft.ServerID(nodeID) {
+			self = &configuration.Servers[i]
+		}
+	}
+	if self == nil {
+		return raft.Configuration{}, fmt.Errorf("%s does not list node %s", peersPath, nodeID)
+	}
+	if addrInUse(string(self.Address)) {
+		return raft.Configuration{}, fmt.Errorf("raft address %s is in use; stop the node before recovering", self.Address)
+	}
+
+	dbPath := filepath.Join(dataDir, "raft.db")
+	if _, err := os.Stat(dbPath); err != nil {
+		return raft.Configuration{}, err
+	}
+	boltDB, err := raftboltdb.New(raftboltdb.Options{
+		Path:        dbPath,
+		BoltOptions: &bbolt.Options{Timeout: 2 * time.Second},
+	})
+	if err != nil {
+		if errors.Is(err, bbolt.ErrTimeout) {
+			return raft.Configuration{}, fmt.Errorf("%s is locked; stop the node before recovering", dbPath)
+		}
+		return raft.Configuration{}, err
+	}
+	defer boltDB.Close()
+
+	snapshots, err := raft.NewFileSnapshotStore(dataDir, 3, os.Stderr)
+	if err != nil {
+		return raft.Configuration{}, err
+	}
+
+	config := raft.DefaultConfig()
+	config.LocalID = raft.ServerID(nodeID)
+
+	// The transport is only used to encode peer addresses into the snapshot
+	_, transport := raft.NewInmemTransport(self.Address)
+	fsm := NewFSM()
+	fsm.cipher = atRest
+	var logs raft.LogStore = boltDB
+	if atRest != nil {
+		logs = &encryptedLogStore{LogStore: boltDB, cipher: atRest}
+	}
+	if err := raft.RecoverCluster(config, fsm, logs, boltDB, snapshots, transport, configuration); err != nil {
+		return raft.Configuration{}, err
+	}
+	return configuration, nil
+}
+
+// addrInUse reports whether something is already listening on addr
+func addrInUse(addr string) bool {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return errors.Is(err, syscall.EADDRINUSE)
+	}
+	ln.Close()
+	return false
+}
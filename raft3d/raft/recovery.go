@@ -0,0 +1,148 @@
+package raft
+
+import (
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+// recoveryCorruptKept bounds the corrupt commands a recovery report lists
+const recoveryCorruptKept = 20
+
+// RecoveryReport summarizes how the node rebuilt its state when it started:
+// the snapshot it restored and the log entries it replayed on top of it
+type RecoveryReport struct {
+	StartedAt       time.Time  `json:"started_at"`
+	CompletedAt     *time.Time `json:"completed_at,omitempty"`
+	Complete        bool       `json:"complete"`
+	DurationSeconds float64    `json:"duration_seconds,omitempty"`
+
+	SnapshotRestored bool   `json:"snapshot_restored"`
+	SnapshotIndex    uint64 `json:"snapshot_index"`
+	LastLogIndex     uint64 `json:"last_log_index"` // the last entry in the log at startup
+	AppliedIndex     uint64 `json:"applied_index"`
+
+	// EntriesReplayed counts commands applied up to LastLogIndex. Rejected
+	// ones were refused again, as they were when first applied; corrupt
+	// ones couldn't be decoded and were skipped.
+	EntriesReplayed uint64           `json:"entries_replayed"`
+	Rejected        uint64           `json:"rejected"`
+	Corrupt         uint64           `json:"corrupt"`
+	CorruptEntries  []SkippedCommand `json:"corrupt_entries,omitempty"` // the first 20
+
+	// EntityCounts counts keys by prefix, without the underscore, once
+	// recovery completes
+	EntityCounts map[string]int `json:"entity_counts,omitempty"`
+}
+
+// SkippedCommand is a log entry the FSM could not decode
+type SkippedCommand struct {
+	Index uint64 `json:"index"`
+	Error string `json:"error"`
+}
+
+// recoveryTracker builds the recovery report while the FSM replays the log
+type recoveryTracker struct {
+	mu     sync.Mutex
+	report RecoveryReport
+}
+
+func newRecoveryTracker() *recoveryTracker {
+	return &recoveryTracker{report: RecoveryReport{StartedAt: time.Now().UTC()}}
+}
+
+// restored records a snapshot restored before recovery completed
+func (t *recoveryTracker) restored(index uint64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if !t.report.Complete {
+		t.report.SnapshotRestored = true
+		t.report.SnapshotIndex = index
+	}
+}
+
+// applied records a command replayed up to the last index at startup
+func (t *recoveryTracker) applied(index uint64, err error, corrupt bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.report.Complete || index > t.report.LastLogIndex {
+		return
+	}
+	t.report.EntriesReplayed++
+	switch {
+	case corrupt:
+		t.report.Corrupt++
+		if len(t.report.CorruptEntries) < recoveryCorruptKept {
+			t.report.CorruptEntries = append(t.report.CorruptEntries, SkippedCommand{Index: index, Error: err.Error()})
+		}
+	case err != nil:
+		t.report.Rejected++
+	}
+}
+
+// complete finishes the report with the applied index and entity counts
+func (t *recoveryTracker) complete(applied uint64, data map[string]string) RecoveryReport {
+	counts := make(map[string]int)
+	for key := range data {
+		counts[strings.TrimSuffix(keyPrefix(key), "_")]++
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := time.Now().UTC()
+	t.report.Complete = true
+	t.report.CompletedAt = &now
+	t.report.DurationSeconds = now.Sub(t.report.StartedAt).Seconds()
+	t.report.AppliedIndex = applied
+	t.report.EntityCounts = counts
+	return t.snapshot()
+}
+
+// snapshot returns a copy of the report. The caller must hold the mutex.
+func (t *recoveryTracker) snapshot() RecoveryReport {
+	report := t.report
+	report.CorruptEntries = append([]SkippedCommand(nil), t.report.CorruptEntries...)
+	return report
+}
+
+// trackRecovery waits for the node to apply everything its log held at
+// startup, then completes and logs the recovery report. Entries that were
+// never committed are replaced by the next leader, so the applied index
+// passes the startup one either way.
+func (s *RaftStore) trackRecovery() {
+	ticker := time.NewTicker(50 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		s.recovery.mu.Lock()
+		target := s.recovery.report.LastLogIndex
+		if s.recovery.report.SnapshotIndex > target {
+			target = s.recovery.report.SnapshotIndex
+		}
+		s.recovery.mu.Unlock()
+		if s.raft.AppliedIndex() >= target {
+			break
+		}
+		select {
+		case <-ticker.C:
+		case <-s.shutdownCh:
+			return
+		}
+	}
+
+	data, _ := s.fsm.State()
+	report := s.recovery.complete(s.raft.AppliedIndex(), data)
+	log.Printf("Recovered state in %.1fs: snapshot at index %d, %d entries replayed to index %d (%d rejected, %d corrupt), %d keys",
+		report.DurationSeconds, report.SnapshotIndex, report.EntriesReplayed, report.AppliedIndex, report.Rejected, report.Corrupt, len(data))
+	for _, skipped := range report.CorruptEntries {
+		log.Printf("Skipped corrupt command at index %d: %s", skipped.Index, skipped.Error)
+	}
+}
+
+// Recovery returns the report of how the node rebuilt its state at startup,
+// which is incomplete until the log it held then has been applied
+func (s *RaftStore) Recovery() RecoveryReport {
+	s.recovery.mu.Lock()
+	defer s.recovery.mu.Unlock()
+	return s.recovery.snapshot()
+}
@@ -0,0 +1,40 @@
+package raft
+
+import (
+	"testing"
+
+	"github.com/hashicorp/raft"
+)
+
+func TestRecoveryReportCountsReplay(t *testing.T) {
+	fsm := NewFSM()
+	fsm.recovery = newRecoveryTracker()
+	fsm.recovery.report.LastLogIndex = 4
+
+	for i, data := range []string{
+		`{"op":"set","key":"printer_p1","value":"{}"}`,
+		`{"op":"create","key":"printer_p1","value":"{}"}`, // rejected, as it was first time round
+		`not json`,
+		`{"op":"set","key":"filament_f1","value":"{}"}`,
+		`{"op":"set","key":"filament_f2","value":"{}"}`, // after the log's end at startup
+	} {
+		fsm.Apply(&raft.Log{Index: uint64(i + 1), Type: raft.LogCommand, Data: []byte(data)})
+	}
+
+	report := fsm.recovery.complete(5, fsm.data)
+	if !report.Complete || report.EntriesReplayed != 4 || report.Rejected != 1 || report.Corrupt != 1 {
+		t.Fatalf("report = %+v", report)
+	}
+	if len(report.CorruptEntries) != 1 || report.CorruptEntries[0].Index != 3 {
+		t.Fatalf("corrupt entries = %+v", report.CorruptEntries)
+	}
+	if report.EntityCounts["printer"] != 1 || report.EntityCounts["filament"] != 2 {
+		t.Fatalf("entity counts = %v", report.EntityCounts)
+	}
+
+	// Snapshots installed later aren't part of the recovery
+	fsm.recovery.restored(100)
+	if fsm.recovery.snapshot().SnapshotRestored {
+		t.Fatal("later restore recorded")
+	}
+}
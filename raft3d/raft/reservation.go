@@ -0,0 +1,87 @@
+package raft
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ReservationPrefix precedes the ID of each stored printer reservation
+const ReservationPrefix = "reservation_"
+
+// reservationSlot is the part of a stored reservation the FSM checks for
+// overlaps
+type reservationSlot struct {
+	PrinterID string    `json:"printer_id"`
+	Start     time.Time `json:"start"`
+	End       time.Time `json:"end"`
+}
+
+func (r reservationSlot) overlaps(other reservationSlot) bool {
+	return r.PrinterID == other.PrinterID && r.Start.Before(other.End) && other.Start.Before(r.End)
+}
+
+// checkReservations rejects a command that would leave two reservations of
+// the same printer overlapping. Checking while applying, rather than before
+// proposing, means two nodes booking the same slot at once can't both win.
+// The caller must hold the mutex.
+func (f *FSM) checkReservations(cmd Command) error {
+	writes := make(map[string]string)
+	switch cmd.Op {
+	case "set", "create", "create_with_id":
+		if strings.HasPrefix(cmd.Key, ReservationPrefix) {
+			writes[cmd.Key] = cmd.Value
+		}
+	}
+	for key, value := range cmd.Values {
+		if strings.HasPrefix(key, ReservationPrefix) {
+			writes[key] = value
+		}
+	}
+	if len(writes) == 0 {
+		return nil
+	}
+
+	// The reservations as they would be after the command, in key order so
+	// every node reports the same conflict
+	slots := make(map[string]reservationSlot)
+	for key, value := range f.data {
+		if strings.HasPrefix(key, ReservationPrefix) {
+			if _, replaced := writes[key]; !replaced {
+				var slot reservationSlot
+				if json.Unmarshal([]byte(value), &slot) == nil {
+					slots[key] = slot
+				}
+			}
+		}
+	}
+	written := make([]string, 0, len(writes))
+	for key, value := range writes {
+		var slot reservationSlot
+		if err := json.Unmarshal([]byte(value), &slot); err != nil {
+			return fmt.Errorf("%w: reservation %s: %s", ErrValidation, key, err)
+		}
+		if slot.PrinterID == "" || !slot.End.After(slot.Start) {
+			return fmt.Errorf("%w: reservation %s needs a printer_id and an end after its start", ErrValidation, key)
+		}
+		slots[key] = slot
+		written = append(written, key)
+	}
+	sort.Strings(written)
+
+	others := make([]string, 0, len(slots))
+	for key := range slots {
+		others = append(others, key)
+	}
+	sort.Strings(others)
+	for _, key := range written {
+		for _, other := range others {
+			if other != key && slots[key].overlaps(slots[other]) {
+				return fmt.Errorf("%w: %s overlaps %s on printer %s", ErrReservationConflict, key, other, slots[key].PrinterID)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,57 @@
+package raft
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/hashicorp/raft"
+)
+
+func TestReservationConflictsRejectedByFSM(t *testing.T) {
+	fsm := NewFSM()
+	index := uint64(0)
+	apply := func(cmd string) ApplyResult {
+		index++
+		return fsm.Apply(&raft.Log{Index: index, Type: raft.LogCommand, Data: []byte(cmd)}).(ApplyResult)
+	}
+	slot := func(printer, start, end string) string {
+		return `{\"printer_id\":\"` + printer + `\",\"start\":\"2026-10-20T` + start + `:00Z\",\"end\":\"2026-10-20T` + end + `:00Z\"}`
+	}
+
+	if result := apply(`{"op":"create","key":"reservation_a","value":"` + slot("p1", "09:00", "11:00") + `"}`); result.Err != nil {
+		t.Fatal(result.Err)
+	}
+	result := apply(`{"op":"create_with_id","key":"reservation_","value":"` + slot("p1", "10:30", "12:00") + `"}`)
+	if !errors.Is(result.Err, ErrReservationConflict) || !errors.Is(result.Err, ErrConflict) || result.Code != ApplyCodeReservation {
+		t.Fatalf("overlap accepted: %+v", result)
+	}
+
+	// Back to back slots, other printers and moving a reservation are fine
+	for _, cmd := range []string{
+		`{"op":"set","key":"reservation_b","value":"` + slot("p1", "11:00", "12:00") + `"}`,
+		`{"op":"set","key":"reservation_c","value":"` + slot("p2", "09:00", "12:00") + `"}`,
+		`{"op":"set","key":"reservation_a","value":"` + slot("p1", "08:00", "10:00") + `"}`,
+	} {
+		if result := apply(cmd); result.Err != nil {
+			t.Fatalf("%s: %v", cmd, result.Err)
+		}
+	}
+
+	// Two reservations written together must not overlap each other
+	result = apply(`{"op":"set_many","values":{"reservation_d":"` + slot("p3", "09:00", "10:00") + `","reservation_e":"` + slot("p3", "09:30", "10:30") + `"}}`)
+	if !errors.Is(result.Err, ErrReservationConflict) {
+		t.Fatalf("overlapping pair accepted: %+v", result)
+	}
+	if _, err := fsm.Get("reservation_d"); err == nil {
+		t.Fatal("rejected command wrote a reservation")
+	}
+	if result := apply(`{"op":"set","key":"reservation_f","value":"{\"printer_id\":\"p1\"}"}`); !errors.Is(result.Err, ErrValidation) {
+		t.Fatalf("reservation without times accepted: %+v", result)
+	}
+
+	// Deleting a reservation frees its slot
+	apply(`{"op":"delete","key":"reservation_b"}`)
+	if result := apply(`{"op":"set","key":"reservation_g","value":"` + slot("p1", "11:00", "11:30") + `"}`); result.Err != nil {
+		t.Fatal(result.Err)
+	}
+}
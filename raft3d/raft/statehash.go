@@ -0,0 +1,47 @@
+package raft
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"sort"
+)
+
+// StateHash is a hash of every key and value in the FSM, with the index of
+// the last entry applied to it. Replicas at the same index must agree.
+type StateHash struct {
+	Index uint64 `json:"applied_index"`
+	Hash  string `json:"hash"` // hex SHA-256 of the keys, sorted, and their values
+	Keys  int    `json:"keys"`
+}
+
+// hashState hashes data in key order, each key and value prefixed with its
+// length so no two different states encode alike
+func hashState(data map[string]string) string {
+	keys := make([]string, 0, len(data))
+	for key := range data {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	h := sha256.New()
+	var length [binary.MaxVarintLen64]byte
+	for _, key := range keys {
+		h.Write(length[:binary.PutUvarint(length[:], uint64(len(key)))])
+		h.Write([]byte(key))
+		h.Write(length[:binary.PutUvarint(length[:], uint64(len(data[key])))])
+		h.Write([]byte(data[key]))
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// StateHash returns the hash of the state and the index it reflects
+func (f *FSM) StateHash() StateHash {
+	data, index := f.State()
+	return StateHash{Index: index, Hash: hashState(data), Keys: len(data)}
+}
+
+// StateHash returns the hash of this node's state and the index it reflects
+func (s *RaftStore) StateHash() StateHash {
+	return s.fsm.StateHash()
+}
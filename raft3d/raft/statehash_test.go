@@ -0,0 +1,20 @@
+package raft
+
+import "testing"
+
+func TestStateHashIgnoresWriteOrder(t *testing.T) {
+	a, b := NewFSM(), NewFSM()
+	a.data["printer_p1"], a.data["filament_f1"] = "{}", `{"name":"PLA"}`
+	b.data["filament_f1"], b.data["printer_p1"] = `{"name":"PLA"}`, "{}"
+	if a.StateHash() != b.StateHash() || a.StateHash().Keys != 2 {
+		t.Fatalf("hashes differ: %+v %+v", a.StateHash(), b.StateHash())
+	}
+
+	// Lengths are hashed too, so moving bytes between a key and its value
+	// changes the hash
+	c := NewFSM()
+	c.data["printer_p1{"], c.data["filament_f1"] = "}", `{"name":"PLA"}`
+	if c.StateHash().Hash == a.StateHash().Hash {
+		t.Fatal("different states hash alike")
+	}
+}
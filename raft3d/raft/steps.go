@@ -0,0 +1,126 @@
+package raft
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// Statuses of a step of a multi-stage print job
+const (
+	StepPending         = "Pending"
+	StepRunning         = "Running"
+	StepAwaitingSignOff = "AwaitingSignOff"
+	StepDone            = "Done"
+	StepFailed          = "Failed"
+)
+
+// StepKindPrint is the kind of the step that is the print itself. Its status
+// follows the job's rather than being changed on its own.
+const StepKindPrint = "print"
+
+// validStepTransitions lists the statuses each step status can move to. Done
+// is final; a failed step can be run again.
+var validStepTransitions = map[string][]string{
+	StepPending:         {StepRunning},
+	StepRunning:         {StepAwaitingSignOff, StepDone, StepFailed},
+	StepAwaitingSignOff: {StepDone, StepFailed},
+	StepFailed:          {StepRunning},
+}
+
+// ValidStepTransition reports whether a step can move from one status to
+// another
+func ValidStepTransition(from, to string) bool {
+	for _, next := range validStepTransitions[from] {
+		if next == to {
+			return true
+		}
+	}
+	return false
+}
+
+// stepState is the part of a stored job step its transitions are checked by
+type stepState struct {
+	Name            string `json:"name"`
+	Kind            string `json:"kind"`
+	Status          string `json:"status"`
+	RequiresSignOff bool   `json:"requires_sign_off"`
+	SignedOffBy     string `json:"signed_off_by"`
+}
+
+// jobSteps is the part of a stored print job its steps are checked with
+type jobSteps struct {
+	Status string      `json:"status"`
+	Steps  []stepState `json:"steps"`
+}
+
+// checkJobSteps rejects writes that move the steps of existing print jobs
+// out of order or to a status they can't reach. Checking while applying
+// means two operators changing the same job at once can't both succeed with
+// what the other no longer allows. The caller must hold the mutex.
+func (f *FSM) checkJobSteps(cmd Command) error {
+	check := func(key, value string) error {
+		old, exists := f.data[key]
+		if !strings.HasPrefix(key, jobPrefix) || !exists {
+			return nil
+		}
+		var before, after jobSteps
+		if json.Unmarshal([]byte(old), &before) != nil || json.Unmarshal([]byte(value), &after) != nil {
+			return nil
+		}
+		if err := stepTransitions(before, after); err != nil {
+			return fmt.Errorf("print job %s: %w", strings.TrimPrefix(key, jobPrefix), err)
+		}
+		return nil
+	}
+
+	switch cmd.Op {
+	case "set", "create":
+		if err := check(cmd.Key, cmd.Value); err != nil {
+			return err
+		}
+	}
+	for key, value := range cmd.Values {
+		if err := check(key, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// stepTransitions checks the changes between a job's steps before and after
+// a write. Steps can't be added, removed or redefined once the job exists;
+// each step starts only once those before it are Done, a step needing
+// sign-off is Done only once signed off, and the print step changes only
+// along with the job's status, apart from its sign-off.
+func stepTransitions(before, after jobSteps) error {
+	if len(before.Steps) != len(after.Steps) {
+		return fmt.Errorf("%w: steps can't be added or removed", ErrInvalidStepTransition)
+	}
+	for i, step := range after.Steps {
+		old := before.Steps[i]
+		if step.Name != old.Name || step.Kind != old.Kind || step.RequiresSignOff != old.RequiresSignOff {
+			return fmt.Errorf("%w: step %s can't be redefined", ErrInvalidStepTransition, old.Name)
+		}
+		if step.Status == old.Status {
+			continue
+		}
+		if !ValidStepTransition(old.Status, step.Status) {
+			return fmt.Errorf("%w: step %s can't go from %s to %s", ErrInvalidStepTransition, step.Name, old.Status, step.Status)
+		}
+		if step.Kind == StepKindPrint && before.Status == after.Status && old.Status != StepAwaitingSignOff {
+			return fmt.Errorf("%w: step %s follows the job's status", ErrInvalidStepTransition, step.Name)
+		}
+		if step.Status == StepRunning {
+			for _, earlier := range after.Steps[:i] {
+				if earlier.Status != StepDone {
+					return fmt.Errorf("%w: step %s can't start before step %s is Done", ErrInvalidStepTransition, step.Name, earlier.Name)
+				}
+			}
+		}
+		if step.Status == StepDone && step.RequiresSignOff && step.SignedOffBy == "" {
+			return fmt.Errorf("%w: step %s needs a sign-off", ErrInvalidStepTransition, step.Name)
+		}
+	}
+	return nil
+}
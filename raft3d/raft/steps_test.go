@@ -0,0 +1,69 @@
+package raft
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/hashicorp/raft"
+)
+
+func TestJobStepTransitionsCheckedByFSM(t *testing.T) {
+	fsm := NewFSM()
+	index := uint64(0)
+	apply := func(cmd string) ApplyResult {
+		index++
+		return fsm.Apply(&raft.Log{Index: index, Type: raft.LogCommand, Data: []byte(cmd)}).(ApplyResult)
+	}
+	job := func(status, print, qa, signedOffBy string) string {
+		return `{\"status\":\"` + status + `\",\"steps\":[` +
+			`{\"name\":\"print\",\"kind\":\"print\",\"status\":\"` + print + `\"},` +
+			`{\"name\":\"qa\",\"kind\":\"inspection\",\"requires_sign_off\":true,\"status\":\"` + qa + `\",\"signed_off_by\":\"` + signedOffBy + `\"}]}`
+	}
+	set := func(value string) ApplyResult {
+		return apply(`{"op":"set","key":"printjob_j1","value":"` + value + `"}`)
+	}
+
+	if result := set(job("Queued", StepPending, StepRunning, "")); result.Err != nil {
+		t.Fatalf("new jobs aren't checked: %+v", result)
+	}
+	if result := apply(`{"op":"delete","key":"printjob_j1"}`); result.Err != nil {
+		t.Fatalf("delete: %+v", result)
+	}
+	if result := set(job("Queued", StepPending, StepPending, "")); result.Err != nil {
+		t.Fatalf("new job rejected: %+v", result)
+	}
+
+	for name, tc := range map[string]string{
+		"out of order":           job("Queued", StepPending, StepRunning, ""),
+		"print step without job": job("Queued", StepRunning, StepPending, ""),
+		"skipping a status":      job("Running", StepDone, StepPending, ""),
+	} {
+		result := set(tc)
+		if !errors.Is(result.Err, ErrInvalidStepTransition) || !errors.Is(result.Err, ErrConflict) || result.Code != ApplyCodeStep {
+			t.Fatalf("%s accepted: %+v", name, result)
+		}
+	}
+
+	for _, value := range []string{
+		job("Running", StepRunning, StepPending, ""),
+		job("Done", StepDone, StepPending, ""),
+		job("Done", StepDone, StepRunning, ""),
+		job("Done", StepDone, StepAwaitingSignOff, ""),
+	} {
+		if result := set(value); result.Err != nil {
+			t.Fatalf("valid transition rejected: %+v", result)
+		}
+	}
+	if result := set(job("Done", StepDone, StepDone, "")); !errors.Is(result.Err, ErrInvalidStepTransition) {
+		t.Fatalf("done without a sign-off: %+v", result)
+	}
+	if result := set(job("Done", StepDone, StepDone, "qa-lead")); result.Err != nil {
+		t.Fatalf("sign-off rejected: %+v", result)
+	}
+	if result := set(job("Done", StepDone, StepFailed, "qa-lead")); result.Err == nil {
+		t.Fatal("finished step reopened")
+	}
+	if result := apply(`{"op":"set_many","values":{"printjob_j1":"{\"status\":\"Done\"}"}}`); !errors.Is(result.Err, ErrInvalidStepTransition) {
+		t.Fatalf("steps removed: %+v", result)
+	}
+}
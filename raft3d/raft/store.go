@@ -1,119 +1,497 @@
 package raft
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"log"
 	"net"
 	"os"
 	"path/filepath"
+	"sync/atomic"
 	"time"
 
+	"github.com/hashicorp/go-hclog"
 	"github.com/hashicorp/raft"
 	raftboltdb "github.com/hashicorp/raft-boltdb/v2"
-	
+
+	"raft3d/events"
 )
 
 // Store provides an interface for operations on the distributed store
 type Store interface {
 	// Get retrieves a value for the given key
 	Get(key string) (string, error)
-	
+
 	// Set sets a value for the given key
 	Set(key string, value string) error
-	
+
+	// SetIf sets a value only if every condition holds when the write is
+	// applied, returning ErrConflict otherwise
+	SetIf(key string, value string, conditions ...Condition) error
+
+	// SetMany sets several values in a single log entry, only if every
+	// condition holds when it is applied. Either all values are written or
+	// none are.
+	SetMany(values map[string]string, conditions ...Condition) error
+
+	// Create sets a value only if the key does not exist yet, returning
+	// ErrAlreadyExists otherwise. The check happens in the FSM, so concurrent
+	// creates of the same key can't both succeed.
+	Create(key string, value string) error
+
+	// CreateWithID stores a new JSON object under prefix plus an ID chosen
+	// when the command is applied, so retries through other nodes can't
+	// produce diverging IDs. It returns the stored value with its "id" set.
+	CreateWithID(prefix string, value string) (string, error)
+
+	// CreateAndSet creates prefix plus id like Create, or like CreateWithID
+	// when id is empty, and sets values in the same log entry. Nothing is
+	// written unless every condition holds. It returns the created value.
+	CreateAndSet(prefix, id, value string, values map[string]string, conditions ...Condition) (string, error)
+
 	// Delete removes a key
 	Delete(key string) error
-	
+
+	// DeleteMany removes several keys in a single log entry, only if every
+	// condition holds when it is applied
+	DeleteMany(keys []string, conditions ...Condition) error
+
 	// List returns all keys with a given prefix
 	List(prefix string) ([]string, error)
-	
-	// Join adds a node to the cluster
-	Join(nodeID string, addr string) error
-	
+
+	// Join adds a node to the cluster, as a voter or a non-voter. Joining
+	// again with the same ID updates the node's address.
+	Join(nodeID, raftAddr, httpAddr string, voter bool) error
+
+	// WithContext returns a view of the store whose writes stop waiting for
+	// replication when ctx ends, failing with ErrReplicationTimeout once its
+	// deadline passes
+	WithContext(ctx context.Context) Store
+
+	// Promote makes a non-voter a voter once the log it has acknowledged to
+	// the leader is close enough to the leader's, returning ErrNotCaughtUp
+	// otherwise
+	Promote(nodeID string) error
+
 	// Close closes the store
 	Close() error
-	
+
 	// Leader returns the current leader's address
 	Leader() string
-	
+
+	// IsLeader reports whether this node is the leader
+	IsLeader() bool
+
+	// QuorumStatus reports whether the node has lost contact with a quorum
+	QuorumStatus() QuorumStatus
+
+	// TransferLeadership asks the leader to step down in favor of another voter
+	TransferLeadership() error
+
+	// LeaderInfo returns the current leader's ID, Raft and HTTP addresses
+	LeaderInfo() NodeInfo
+
 	// Metrics returns metrics about the Raft cluster
 	Metrics() map[string]interface{}
+
+	// ApplyLatency returns write latency histograms per command type
+	ApplyLatency() ApplyLatency
+
+	// AppliedIndex returns the index of the last log entry applied locally
+	AppliedIndex() uint64
+
+	// WaitForIndex blocks until the node has applied at least index,
+	// returning ErrTimeout if ctx ends first
+	WaitForIndex(ctx context.Context, index uint64) error
+
+	// WaitForChange blocks until a key under prefix, which must end in an
+	// underscore, is written at an index after since, returning ErrTimeout
+	// if ctx ends first
+	WaitForChange(ctx context.Context, prefix string, since uint64) error
+
+	// Backups lists the stored backups
+	Backups() ([]BackupInfo, error)
+
+	// WriteBackup writes a backup of the current state immediately
+	WriteBackup() (BackupInfo, error)
+
+	// Compact snapshots the FSM now and truncates the log behind it
+	Compact() (CompactionResult, error)
+
+	// ReadLog describes the log entries from index from to index to that
+	// this node still holds, without their values
+	ReadLog(from, to uint64) (LogPage, error)
+
+	// Query runs a read-only SQL statement against the node's SQL
+	// projection, returning at most limit rows; ErrConflict means the
+	// projection is not enabled
+	Query(ctx context.Context, statement string, args []interface{}, limit int) (QueryResult, error)
+
+	// AtIndex returns a read-only view of the store as of an earlier log
+	// index, failing with ErrHistoryUnavailable beyond the history kept
+	AtIndex(index uint64) (Store, error)
+
+	// Changelog returns the recorded transitions of an entity key, such as
+	// a print job's status changes, oldest first
+	Changelog(key string) ([]ChangelogEntry, error)
+
+	// Recovery reports how the node rebuilt its state when it started
+	Recovery() RecoveryReport
+
+	// StateDigest returns the digest of the state after the entry at index,
+	// or the latest when index is 0, if the determinism audit is on and the
+	// index is recent
+	StateDigest(index uint64) (StateDigest, bool)
+
+	// StateHash hashes every key and value, for comparing replicas
+	StateHash() StateHash
 }
 
 // RaftStore implements the Store interface using Hashicorp's Raft
 type RaftStore struct {
-	raft          *raft.Raft
-	fsm           *FSM
-	raftConfig    *raft.Config
-	raftBoltStore *raftboltdb.BoltStore
-	raftTransport *raft.NetworkTransport
-	dataDir       string
+	raft           *raft.Raft
+	fsm            *FSM
+	raftConfig     *raft.Config
+	raftBoltStore  *raftboltdb.BoltStore
+	logs           raft.LogStore // the log store Raft uses, decrypting entries
+	raftTransport  raft.Transport
+	progress       *replicationProgress
+	dataDir        string
+	httpAddr       string
+	backupTarget   BackupTarget
+	events         *events.Bus
+	quorum         quorumMonitor
+	latency        *latencyMetrics
+	idFormat       string
+	applyTimeout   time.Duration
+	backpressure   backpressure
+	fence          writeFence
+	journalAppends uint64       // accessed atomically
+	cdc            *cdcExporter // optional change data capture
+	recovery       *recoveryTracker
+	shutdownCh     chan struct{}
+}
+
+// StoreConfig configures a RaftStore
+type StoreConfig struct {
+	NodeID    string
+	RaftAddr  string // address the Raft transport binds to
+	HTTPAddr  string // HTTP address other nodes and clients reach this node at
+	DataDir   string
+	Bootstrap bool
+
+	// LogArchiveDir, when set, receives a copy of every applied command
+	LogArchiveDir string
+
+	// HistoryEntries is how many log entries back reads at an earlier index
+	// can go (default 10000; negative disables). Versions are kept in memory
+	// and start over when the node restarts.
+	HistoryEntries int
+
+	// SQLProjection, when set, is the path of a SQLite database the state
+	// is mirrored into for Query. It is rebuilt whenever the node starts.
+	SQLProjection string
+
+	// Chaos enables fault injection when non-nil
+	Chaos *Chaos
+
+	// Transport replaces the TCP transport, e.g. with an in-memory one
+	Transport raft.Transport
+
+	// InMemory keeps the log, stable and snapshot stores in memory instead
+	// of under DataDir
+	InMemory bool
+
+	// Voters lists the initial members when bootstrapping. It defaults to
+	// this node alone.
+	Voters []NodeInfo
+
+	// Events receives cluster events such as leadership changes when set
+	Events *events.Bus
+
+	// QuorumLossThreshold is how long a node may go without leader contact
+	// before it refuses writes with ErrQuorumLost
+	QuorumLossThreshold time.Duration
+
+	// SnapshotRetain is how many snapshots are kept on disk (default 3)
+	SnapshotRetain int
+
+	// TrailingLogs is how many log entries are kept behind each snapshot so
+	// slow followers can catch up without a snapshot install (default 10240)
+	TrailingLogs uint64
+
+	// SnapshotThreshold is how many new entries trigger an automatic
+	// snapshot (default 8192)
+	SnapshotThreshold uint64
+
+	// Tuning overrides the Raft timeouts; DefaultTuning is used when unset
+	Tuning *Tuning
+
+	// LogLevel is the Raft library's log level: trace, debug, info (the
+	// default), warn or error. It can be changed later with SetLogLevel.
+	LogLevel string
+
+	// IDFormat is how IDs are generated for entities created without one:
+	// IDFormatUUID (the default) or IDFormatSequential
+	IDFormat string
+
+	// EncryptionKey, when set, encrypts snapshots and logged commands with
+	// AES-GCM. Every node of the cluster needs the same key.
+	EncryptionKey []byte
+
+	// ApplyTimeout is how long a write waits to be committed before failing
+	// with ErrReplicationTimeout (default 10s)
+	ApplyTimeout time.Duration
+
+	// MaxFSMPending is how many committed entries may wait for the FSM
+	// before new writes fail with ErrOverloaded (default 64; negative
+	// disables)
+	MaxFSMPending int
+
+	// FenceMembershipChanges holds writes back while the leader adds or
+	// removes a server, until the new configuration is committed. Held
+	// writes still fail once their apply timeout passes.
+	FenceMembershipChanges bool
+
+	// DeterminismAudit keeps a digest of the state after each of the last
+	// 4096 applied entries, so replicas can compare them. It is a debugging
+	// aid: every write also updates the digest.
+	DeterminismAudit bool
+
+	// RaftAdvertiseAddr is the address peers reach the Raft transport at,
+	// when it differs from RaftAddr, e.g. when binding 0.0.0.0 behind NAT
+	RaftAdvertiseAddr string
+
+	// TLS, when set, runs the TCP transport over TLS. Peers must present a
+	// certificate from the provider's CA when it has one.
+	TLS TLSProvider
 }
 
 // NewRaftStore creates a new Raft-backed store
-func NewRaftStore(nodeID, raftAddr, dataDir string, bootstrap bool) (*RaftStore, error) {
+func NewRaftStore(cfg StoreConfig) (*RaftStore, error) {
+	nodeID, raftAddr, dataDir := cfg.NodeID, cfg.RaftAddr, cfg.DataDir
+
+	if err := validIDFormat(cfg.IDFormat); err != nil {
+		return nil, err
+	}
+
 	// Create the FSM
 	fsm := NewFSM()
+	if cfg.LogArchiveDir != "" {
+		archiver, err := NewLogArchiver(cfg.LogArchiveDir, cfg.EncryptionKey)
+		if err != nil {
+			return nil, err
+		}
+		fsm.archiver = archiver
+	}
+	if cfg.SQLProjection != "" {
+		projection, err := openSQLProjection(cfg.SQLProjection)
+		if err != nil {
+			return nil, err
+		}
+		fsm.projection = projection
+	}
+	switch {
+	case cfg.HistoryEntries == 0:
+		fsm.history = newEntityHistory(defaultHistoryEntries)
+	case cfg.HistoryEntries > 0:
+		fsm.history = newEntityHistory(uint64(cfg.HistoryEntries))
+	}
+	fsm.chaos = cfg.Chaos
+	if cfg.DeterminismAudit {
+		fsm.audit = &stateAudit{}
+	}
+	fsm.latency = newLatencyMetrics()
+	atRest, err := newAtRestCipher(cfg.EncryptionKey)
+	if err != nil {
+		return nil, err
+	}
+	fsm.cipher = atRest
+	fsm.hooks = newHookRunner(registeredExtensions())
 
 	// Create Raft config
 	config := raft.DefaultConfig()
 	config.LocalID = raft.ServerID(nodeID)
-	
-	// Set some timeouts appropriate for a demo
-	config.HeartbeatTimeout = 500 * time.Millisecond
-	config.ElectionTimeout = 500 * time.Millisecond
-	config.LeaderLeaseTimeout = 400 * time.Millisecond
-	config.CommitTimeout = 100 * time.Millisecond
-
-	// Create Raft transport
-	addr, err := net.ResolveTCPAddr("tcp", raftAddr)
+	level, err := parseLogLevel(cfg.LogLevel)
 	if err != nil {
 		return nil, err
 	}
-	transport, err := raft.NewTCPTransport(raftAddr, addr, 3, 10*time.Second, os.Stderr)
-	if err != nil {
-		return nil, err
+	config.Logger = hclog.New(&hclog.LoggerOptions{Name: "raft", Level: level, Output: os.Stderr})
+
+	// Set timeouts, by default ones appropriate for a demo
+	tuning := DefaultTuning()
+	if cfg.Tuning != nil {
+		tuning = *cfg.Tuning
 	}
+	config.HeartbeatTimeout = tuning.HeartbeatTimeout
+	config.ElectionTimeout = tuning.ElectionTimeout
+	config.LeaderLeaseTimeout = tuning.LeaderLeaseTimeout
+	config.CommitTimeout = tuning.CommitTimeout
 
-	// Create the snapshot store
-	snapshotStore, err := raft.NewFileSnapshotStore(dataDir, 3, os.Stderr)
-	if err != nil {
-		return nil, err
+	// Snapshot and log compaction policy
+	if cfg.TrailingLogs > 0 {
+		config.TrailingLogs = cfg.TrailingLogs
+	}
+	if cfg.SnapshotThreshold > 0 {
+		config.SnapshotThreshold = cfg.SnapshotThreshold
+	}
+	snapshotRetain := cfg.SnapshotRetain
+	if snapshotRetain <= 0 {
+		snapshotRetain = defaultSnapshotRetain
 	}
 
-	// Create the log store and stable store
-	boltDB, err := raftboltdb.NewBoltStore(filepath.Join(dataDir, "raft.db"))
-	if err != nil {
-		return nil, err
+	// Create Raft transport
+	transport := cfg.Transport
+	if transport == nil {
+		advertise := raftAddr
+		if cfg.RaftAdvertiseAddr != "" {
+			advertise = cfg.RaftAdvertiseAddr
+		}
+		addr, err := net.ResolveTCPAddr("tcp", advertise)
+		if err != nil {
+			return nil, err
+		}
+		if addr.IP == nil || addr.IP.IsUnspecified() {
+			return nil, fmt.Errorf("raft address %s can't be advertised to peers; set an advertise address", advertise)
+		}
+		if cfg.TLS != nil {
+			stream, err := newTLSStreamLayer(raftAddr, addr, cfg.TLS)
+			if err != nil {
+				return nil, err
+			}
+			transport = raft.NewNetworkTransport(stream, tuning.MaxPool, 10*time.Second, os.Stderr)
+		} else {
+			transport, err = raft.NewTCPTransport(raftAddr, addr, tuning.MaxPool, 10*time.Second, os.Stderr)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	// Create the snapshot, log and stable stores
+	var (
+		snapshotStore raft.SnapshotStore
+		logStore      raft.LogStore
+		stableStore   raft.StableStore
+		boltDB        *raftboltdb.BoltStore
+	)
+	if cfg.InMemory {
+		inmem := raft.NewInmemStore()
+		snapshotStore, logStore, stableStore = raft.NewInmemSnapshotStore(), inmem, inmem
+	} else {
+		fileSnapshots, err := raft.NewFileSnapshotStore(dataDir, snapshotRetain, os.Stderr)
+		if err != nil {
+			return nil, err
+		}
+		boltDB, err = raftboltdb.NewBoltStore(filepath.Join(dataDir, "raft.db"))
+		if err != nil {
+			return nil, err
+		}
+		snapshotStore, logStore, stableStore = fileSnapshots, boltDB, boltDB
+		if fsm.cipher != nil {
+			logStore = &encryptedLogStore{LogStore: boltDB, cipher: fsm.cipher}
+		}
 	}
-	
+
 	// Create Raft instance
-	r, err := raft.NewRaft(config, fsm, boltDB, boltDB, snapshotStore, transport)
+	progress := newReplicationProgress()
+	var trans raft.Transport = &progressTransport{Transport: transport, progress: progress}
+	if cfg.Chaos != nil {
+		trans = &chaosTransport{Transport: trans, chaos: cfg.Chaos}
+	}
+	fsm.recovery = newRecoveryTracker()
+	if fsm.recovery.report.LastLogIndex, err = logStore.LastIndex(); err != nil {
+		return nil, err
+	}
+	r, err := raft.NewRaft(config, fsm, logStore, stableStore, snapshotStore, trans)
 	if err != nil {
 		return nil, err
 	}
 
 	// Bootstrap the cluster if needed
-	if bootstrap {
-		configuration := raft.Configuration{
-			Servers: []raft.Server{
-				{
-					ID:      config.LocalID,
-					Address: transport.LocalAddr(),
-				},
-			},
+	if cfg.Bootstrap {
+		voters := cfg.Voters
+		if len(voters) == 0 {
+			voters = []NodeInfo{{ID: nodeID, RaftAddr: string(transport.LocalAddr())}}
+		}
+		configuration := raft.Configuration{}
+		for _, v := range voters {
+			configuration.Servers = append(configuration.Servers, raft.Server{
+				ID:      raft.ServerID(v.ID),
+				Address: raft.ServerAddress(v.RaftAddr),
+			})
 		}
 		r.BootstrapCluster(configuration)
 	}
 
-	return &RaftStore{
+	s := &RaftStore{
 		raft:          r,
 		fsm:           fsm,
 		raftConfig:    config,
 		raftBoltStore: boltDB,
+		logs:          logStore,
 		raftTransport: transport,
+		progress:      progress,
 		dataDir:       dataDir,
-	}, nil
+		httpAddr:      cfg.HTTPAddr,
+		events:        cfg.Events,
+		latency:       fsm.latency,
+		idFormat:      cfg.IDFormat,
+		applyTimeout:  cfg.ApplyTimeout,
+		recovery:      fsm.recovery,
+		shutdownCh:    make(chan struct{}),
+	}
+	if s.applyTimeout <= 0 {
+		s.applyTimeout = defaultApplyTimeout
+	}
+	if fsm.hooks != nil {
+		fsm.hooks.start(s.IsLeader)
+	}
+	switch {
+	case cfg.MaxFSMPending == 0:
+		s.backpressure.threshold = defaultMaxFSMPending
+	case cfg.MaxFSMPending > 0:
+		s.backpressure.threshold = cfg.MaxFSMPending
+	}
+	s.fence.enabled = cfg.FenceMembershipChanges
+	s.quorum.threshold = cfg.QuorumLossThreshold
+	if s.quorum.threshold <= 0 {
+		s.quorum.threshold = defaultQuorumLossThreshold
+	}
+
+	go s.trackRecovery()
+	go s.monitorLeadership()
+	go s.monitorQuorum()
+	if cfg.Events != nil {
+		go s.observeCluster(cfg.Events)
+	}
+
+	return s, nil
+}
+
+// State returns a copy of the FSM data and the index it reflects
+func (s *RaftStore) State() (map[string]string, uint64) {
+	return s.fsm.State()
+}
+
+// publish sends an event to the event bus if one is configured
+func (s *RaftStore) publish(typ string, data interface{}) {
+	if s.events != nil {
+		s.events.Publish(typ, data)
+	}
+}
+
+// writable checks that this node can accept a write
+func (s *RaftStore) writable() error {
+	if s.quorumLost() {
+		return ErrQuorumLost
+	}
+	if s.raft.State() != raft.Leader {
+		return ErrNotLeader
+	}
+	return nil
 }
 
 // Get retrieves a value for the given key
@@ -123,43 +501,298 @@ func (s *RaftStore) Get(key string) (string, error) {
 
 // Set sets a value for the given key
 func (s *RaftStore) Set(key string, value string) error {
-	if s.raft.State() != raft.Leader {
-		return fmt.Errorf("not leader")
+	return s.set(context.Background(), key, value)
+}
+
+// set is Set bound to ctx
+func (s *RaftStore) set(ctx context.Context, key string, value string) error {
+	if key == "" {
+		return fmt.Errorf("%w: key must not be empty", ErrValidation)
+	}
+	if err := s.writable(); err != nil {
+		return err
 	}
 
 	cmd := &Command{
-		Op:    "set",
-		Key:   key,
-		Value: value,
+		Op:      "set",
+		Key:     key,
+		Value:   value,
+		Actor:   actorFrom(ctx),
+		At:      commandTime(),
+		Version: ProtocolVersion,
 	}
-	
+
 	data, err := json.Marshal(cmd)
 	if err != nil {
 		return err
 	}
 
-	future := s.raft.Apply(data, 10*time.Second)
-	return future.Error()
+	return s.apply(ctx, cmd.Op, data)
+}
+
+// SetIf sets a value only if every condition holds when the write is applied
+func (s *RaftStore) SetIf(key string, value string, conditions ...Condition) error {
+	return s.setIf(context.Background(), key, value, conditions...)
+}
+
+// setIf is SetIf bound to ctx
+func (s *RaftStore) setIf(ctx context.Context, key string, value string, conditions ...Condition) error {
+	if key == "" {
+		return fmt.Errorf("%w: key must not be empty", ErrValidation)
+	}
+	if err := s.writable(); err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(&Command{Op: "set", Key: key, Value: value, Conditions: conditions, Actor: actorFrom(ctx), At: commandTime(), Version: ProtocolVersion})
+	if err != nil {
+		return err
+	}
+
+	return s.apply(ctx, "set", data)
+}
+
+// SetMany sets several values in a single log entry
+func (s *RaftStore) SetMany(values map[string]string, conditions ...Condition) error {
+	return s.setMany(context.Background(), values, conditions...)
+}
+
+// setMany is SetMany bound to ctx
+func (s *RaftStore) setMany(ctx context.Context, values map[string]string, conditions ...Condition) error {
+	if len(values) == 0 {
+		return nil
+	}
+	for key := range values {
+		if key == "" {
+			return fmt.Errorf("%w: key must not be empty", ErrValidation)
+		}
+	}
+	if err := s.writable(); err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(&Command{Op: "set_many", Values: values, Conditions: conditions, Actor: actorFrom(ctx), At: commandTime(), Version: ProtocolVersion})
+	if err != nil {
+		return err
+	}
+
+	return s.apply(ctx, "set_many", data)
+}
+
+// Create sets a value only if the key does not exist yet
+func (s *RaftStore) Create(key string, value string) error {
+	return s.create(context.Background(), key, value, nil)
+}
+
+// create is Create bound to ctx, also setting values and checking conditions
+func (s *RaftStore) create(ctx context.Context, key string, value string, values map[string]string, conditions ...Condition) error {
+	_, err := s.createEntity(ctx, key, value, values, conditions...)
+	return err
+}
+
+// createEntity is create, also returning the value stored, which the FSM
+// may have annotated
+func (s *RaftStore) createEntity(ctx context.Context, key string, value string, values map[string]string, conditions ...Condition) (string, error) {
+	if key == "" {
+		return "", fmt.Errorf("%w: key must not be empty", ErrValidation)
+	}
+	for k := range values {
+		if k == "" || k == key {
+			return "", fmt.Errorf("%w: values must have distinct, non-empty keys", ErrValidation)
+		}
+	}
+	if err := s.writable(); err != nil {
+		return "", err
+	}
+
+	data, err := json.Marshal(&Command{Op: "create", Key: key, Value: value, Values: values, Conditions: conditions, Actor: actorFrom(ctx), At: commandTime(), Version: ProtocolVersion})
+	if err != nil {
+		return "", err
+	}
+
+	return s.applyEntity(ctx, "create", data)
+}
+
+// CreateAndSet creates an entity and sets values in one log entry
+func (s *RaftStore) CreateAndSet(prefix, id, value string, values map[string]string, conditions ...Condition) (string, error) {
+	return s.createAndSet(context.Background(), prefix, id, value, values, conditions...)
+}
+
+// createAndSet is CreateAndSet bound to ctx
+func (s *RaftStore) createAndSet(ctx context.Context, prefix, id, value string, values map[string]string, conditions ...Condition) (string, error) {
+	if id == "" {
+		return s.createWithID(ctx, prefix, value, values, conditions...)
+	}
+	return s.createEntity(ctx, prefix+id, value, values, conditions...)
 }
 
 // Delete removes a key
 func (s *RaftStore) Delete(key string) error {
-	if s.raft.State() != raft.Leader {
-		return fmt.Errorf("not leader")
+	return s.delete(context.Background(), key)
+}
+
+// delete is Delete bound to ctx
+func (s *RaftStore) delete(ctx context.Context, key string) error {
+	if err := s.writable(); err != nil {
+		return err
 	}
 
 	cmd := &Command{
-		Op:  "delete",
-		Key: key,
+		Op:      "delete",
+		Key:     key,
+		Actor:   actorFrom(ctx),
+		At:      commandTime(),
+		Version: ProtocolVersion,
 	}
-	
+
 	data, err := json.Marshal(cmd)
 	if err != nil {
 		return err
 	}
 
-	future := s.raft.Apply(data, 10*time.Second)
-	return future.Error()
+	return s.apply(ctx, cmd.Op, data)
+}
+
+// DeleteMany removes several keys in a single log entry
+func (s *RaftStore) DeleteMany(keys []string, conditions ...Condition) error {
+	return s.deleteMany(context.Background(), keys, conditions...)
+}
+
+// deleteMany is DeleteMany bound to ctx
+func (s *RaftStore) deleteMany(ctx context.Context, keys []string, conditions ...Condition) error {
+	if len(keys) == 0 {
+		return nil
+	}
+	if err := s.writable(); err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(&Command{Op: "delete_many", Keys: keys, Conditions: conditions, Actor: actorFrom(ctx), At: commandTime(), Version: ProtocolVersion})
+	if err != nil {
+		return err
+	}
+
+	return s.apply(ctx, "delete_many", data)
+}
+
+// apply submits a command to the Raft log and returns the FSM's response
+// error. op labels the command in the latency metrics.
+func (s *RaftStore) apply(ctx context.Context, op string, data []byte) error {
+	_, err := s.applyEntity(ctx, op, data)
+	return err
+}
+
+// applyEntity is apply, also returning the value the command stored. It
+// waits for the command to be committed and applied for at most the apply
+// timeout or until ctx ends, whichever comes first.
+func (s *RaftStore) applyEntity(ctx context.Context, op string, data []byte) (string, error) {
+	timeout := s.applyTimeout
+	if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < timeout {
+		timeout = time.Until(deadline)
+	}
+	if err := ctx.Err(); err != nil || timeout <= 0 {
+		return "", contextError(ctx)
+	}
+	if err := s.admit(); err != nil {
+		return "", err
+	}
+	if s.fsm.hooks != nil {
+		var cmd Command
+		if err := json.Unmarshal(data, &cmd); err == nil {
+			if err := s.fsm.hooks.validate(cmd); err != nil {
+				return "", err
+			}
+		}
+	}
+	if IsDryRun(ctx) {
+		return s.fsm.dryRun(data)
+	}
+	if s.fence.enabled {
+		start := time.Now()
+		if err := s.fence.wait(ctx, timeout); err != nil {
+			return "", err
+		}
+		if timeout -= time.Since(start); timeout <= 0 {
+			return "", fmt.Errorf("%w: a membership change held the write back for its whole apply timeout", ErrReplicationTimeout)
+		}
+	}
+
+	atomic.AddInt64(&s.latency.pending, 1)
+	start := time.Now()
+	future := s.raft.Apply(data, timeout)
+	done := make(chan error, 1)
+	go func() {
+		err := future.Error()
+		atomic.AddInt64(&s.latency.pending, -1)
+		s.latency.observe(s.latency.total, op, time.Since(start))
+		done <- err
+	}()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	var err error
+	select {
+	case err = <-done:
+	case <-timer.C:
+		return "", fmt.Errorf("%w: not committed within %s", ErrReplicationTimeout, timeout)
+	case <-ctx.Done():
+		return "", contextError(ctx)
+	}
+	if err != nil {
+		if errors.Is(err, raft.ErrEnqueueTimeout) {
+			return "", fmt.Errorf("%w: not enqueued within %s", ErrReplicationTimeout, timeout)
+		}
+		return "", translateApplyError(err)
+	}
+	result, ok := future.Response().(ApplyResult)
+	if !ok {
+		return "", fmt.Errorf("unexpected FSM response %T", future.Response())
+	}
+	if result.Err != nil {
+		return "", &ApplyError{Code: result.Code, Index: result.Index, Err: result.Err}
+	}
+	return result.Entity, nil
+}
+
+// AppliedIndex returns the index of the last log entry applied locally,
+// including entries restored from a snapshot
+func (s *RaftStore) AppliedIndex() uint64 {
+	return s.raft.AppliedIndex()
+}
+
+// WaitForIndex blocks until the node has applied at least index
+func (s *RaftStore) WaitForIndex(ctx context.Context, index uint64) error {
+	ticker := time.NewTicker(10 * time.Millisecond)
+	defer ticker.Stop()
+
+	for s.raft.AppliedIndex() < index {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return fmt.Errorf("%w: applied index %d has not reached %d", ErrTimeout, s.raft.AppliedIndex(), index)
+		}
+	}
+	return nil
+}
+
+// WaitForChange blocks until a key under prefix is written after since. A
+// snapshot restore while waiting counts as a change.
+func (s *RaftStore) WaitForChange(ctx context.Context, prefix string, since uint64) error {
+	ticker := time.NewTicker(10 * time.Millisecond)
+	defer ticker.Stop()
+
+	_, restores := s.fsm.LastChange(prefix)
+	for {
+		index, current := s.fsm.LastChange(prefix)
+		if index > since || current != restores {
+			return nil
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return fmt.Errorf("%w: no change under %s after index %d", ErrTimeout, prefix, since)
+		}
+	}
 }
 
 // List returns all keys with a given prefix
@@ -167,10 +800,10 @@ func (s *RaftStore) List(prefix string) ([]string, error) {
 	return s.fsm.List(prefix)
 }
 
-// Join adds a node to the cluster
-func (s *RaftStore) Join(nodeID string, addr string) error {
-	if s.raft.State() != raft.Leader {
-		return fmt.Errorf("not leader")
+// Join adds a node to the cluster and records its HTTP address
+func (s *RaftStore) Join(nodeID, addr, httpAddr string, voter bool) error {
+	if err := s.writable(); err != nil {
+		return err
 	}
 
 	configFuture := s.raft.GetConfiguration()
@@ -178,25 +811,106 @@ func (s *RaftStore) Join(nodeID string, addr string) error {
 		return err
 	}
 
-	// Check if the node already exists
+	id, address := raft.ServerID(nodeID), raft.ServerAddress(addr)
+	var member *raft.Server
 	for _, srv := range configFuture.Configuration().Servers {
-		if srv.ID == raft.ServerID(nodeID) || srv.Address == raft.ServerAddress(addr) {
-			// Node already exists
-			return nil
+		switch {
+		case srv.ID == id:
+			srv := srv
+			member = &srv
+		case srv.Address == address:
+			// Another node used to have this address, e.g. before a DHCP
+			// lease moved. It can't be reached there any more.
+			log.Printf("Removing %s, whose address %s now belongs to %s", srv.ID, addr, nodeID)
+			if err := s.changeMembership(func() raft.IndexFuture { return s.raft.RemoveServer(srv.ID, 0, 0) }); err != nil {
+				return err
+			}
+			if err := s.Delete(nodeKeyPrefix + string(srv.ID)); err != nil {
+				return err
+			}
 		}
 	}
 
-	// Add the node
-	future := s.raft.AddVoter(raft.ServerID(nodeID), raft.ServerAddress(addr), 0, 0)
-	if err := future.Error(); err != nil {
+	// A member keeps its suffrage when it rejoins; promotion is explicit
+	suffrage := SuffrageNonvoter
+	switch {
+	case member != nil && member.Suffrage == raft.Voter:
+		suffrage = SuffrageVoter
+	case member == nil && voter:
+		suffrage = SuffrageVoter
+	}
+
+	if member == nil || member.Address != address {
+		if member != nil {
+			log.Printf("Updating the address of %s from %s to %s", nodeID, member.Address, addr)
+		}
+		add := s.raft.AddNonvoter
+		if suffrage == SuffrageVoter {
+			add = s.raft.AddVoter
+		}
+		if err := s.changeMembership(func() raft.IndexFuture { return add(id, address, 0, 0) }); err != nil {
+			return err
+		}
+	}
+
+	return s.setNode(NodeInfo{ID: nodeID, RaftAddr: addr, HTTPAddr: httpAddr, Suffrage: suffrage})
+}
+
+// promotionMaxLag is how many log entries a non-voter may be behind the
+// leader and still be promoted
+const promotionMaxLag = 256
+
+// Promote makes a caught-up non-voter a voter. Promoting a voter is a no-op.
+// How far the node got is taken from what it acknowledged to this leader, not
+// from anything the node reports about itself.
+func (s *RaftStore) Promote(nodeID string) error {
+	if err := s.writable(); err != nil {
 		return err
 	}
 
-	return nil
+	configFuture := s.raft.GetConfiguration()
+	if err := configFuture.Error(); err != nil {
+		return err
+	}
+	var member *raft.Server
+	for _, srv := range configFuture.Configuration().Servers {
+		if srv.ID == raft.ServerID(nodeID) {
+			srv := srv
+			member = &srv
+			break
+		}
+	}
+	if member == nil {
+		return fmt.Errorf("node %s: %w", nodeID, ErrNotFound)
+	}
+	if member.Suffrage == raft.Voter {
+		return nil
+	}
+
+	match, ok := s.progress.matchIndex(member.ID)
+	if !ok {
+		return fmt.Errorf("node %s has not acknowledged any entries yet: %w", nodeID, ErrNotCaughtUp)
+	}
+	if last := s.raft.LastIndex(); last > match && last-match > promotionMaxLag {
+		return fmt.Errorf("node %s is %d entries behind: %w", nodeID, last-match, ErrNotCaughtUp)
+	}
+	if err := s.changeMembership(func() raft.IndexFuture { return s.raft.AddVoter(member.ID, member.Address, 0, 0) }); err != nil {
+		return err
+	}
+
+	info, err := s.node(nodeID)
+	if err != nil {
+		info = NodeInfo{ID: nodeID, RaftAddr: string(member.Address)}
+	}
+	info.Suffrage = SuffrageVoter
+	log.Printf("Promoted %s to voter", nodeID)
+	return s.setNode(info)
 }
 
 // Close shuts down the Raft instance and closes the BoltDB store
 func (s *RaftStore) Close() error {
+	close(s.shutdownCh)
+
 	future := s.raft.Shutdown()
 	if err := future.Error(); err != nil {
 		return err
@@ -208,6 +922,22 @@ func (s *RaftStore) Close() error {
 		}
 	}
 
+	if s.fsm.archiver != nil {
+		if err := s.fsm.archiver.Close(); err != nil {
+			return err
+		}
+	}
+
+	if s.fsm.projection != nil {
+		if err := s.fsm.projection.Close(); err != nil {
+			return err
+		}
+	}
+
+	if s.fsm.hooks != nil {
+		s.fsm.hooks.stop()
+	}
+
 	return nil
 }
 
@@ -216,30 +946,107 @@ func (s *RaftStore) Leader() string {
 	return string(s.raft.Leader())
 }
 
+// Ready reports whether the store can take part in the cluster: its Raft
+// transport is listening and Raft's main loop answers. The error says which
+// isn't yet.
+func (s *RaftStore) Ready() error {
+	if transport, ok := s.raftTransport.(interface{ IsShutdown() bool }); ok && transport.IsShutdown() {
+		return errors.New("the Raft transport is closed")
+	}
+	if s.raftTransport.LocalAddr() == "" {
+		return errors.New("the Raft transport has no address")
+	}
+	if s.raft.State() == raft.Shutdown {
+		return raft.ErrRaftShutdown
+	}
+	// Served by the main loop, so it only returns once Raft is running
+	if err := s.raft.GetConfiguration().Error(); err != nil {
+		return fmt.Errorf("Raft is not initialized: %w", err)
+	}
+	return nil
+}
+
+// IsLeader reports whether this node is the leader
+func (s *RaftStore) IsLeader() bool {
+	return s.raft.State() == raft.Leader
+}
+
+// TransferLeadership asks the leader to step down in favor of another voter
+func (s *RaftStore) TransferLeadership() error {
+	if s.raft.State() != raft.Leader {
+		return ErrNotLeader
+	}
+	return translateApplyError(s.raft.LeadershipTransfer().Error())
+}
+
 // Metrics returns metrics about the Raft cluster
 func (s *RaftStore) Metrics() map[string]interface{} {
 	leaderAddr := s.raft.Leader()
-	
+
 	isLeader := false
 	if leaderAddr == s.raftTransport.LocalAddr() {
 		isLeader = true
 	}
 
 	stats := s.raft.Stats()
-	
+
 	metrics := map[string]interface{}{
-		"node_id":      string(s.raftConfig.LocalID),
-		"state":        s.raft.State().String(),
-		"is_leader":    isLeader,
-		"leader_addr":  string(leaderAddr),
-		"last_contact": stats["last_contact"],
-		"term":         stats["term"],
+		"node_id":        string(s.raftConfig.LocalID),
+		"state":          s.raft.State().String(),
+		"is_leader":      isLeader,
+		"leader_addr":    string(leaderAddr),
+		"last_contact":   stats["last_contact"],
+		"term":           stats["term"],
 		"last_log_index": stats["last_log_index"],
 		"last_log_term":  stats["last_log_term"],
 		"commit_index":   stats["commit_index"],
 		"applied_index":  stats["applied_index"],
 		"fsm_pending":    stats["fsm_pending"],
+		"apply_pending":  atomic.LoadInt64(&s.latency.pending),
+	}
+	metrics["fsm_pending_threshold"] = s.backpressure.threshold
+	metrics["writes_shed_overloaded"] = atomic.LoadUint64(&s.backpressure.rejected)
+	if s.fence.enabled {
+		active, windows, fenced, waited := s.fence.stats()
+		metrics["membership_fence_active"] = active
+		metrics["membership_fence_windows"] = windows
+		metrics["membership_fence_seconds"] = fenced.Seconds()
+		metrics["writes_fenced"] = waited
+	}
+
+	storage := s.StorageStats()
+	metrics["log_size_bytes"] = storage.LogSizeBytes
+	metrics["snapshot_size_bytes"] = storage.SnapshotSizeBytes
+	metrics["snapshot_count"] = storage.SnapshotCount
+	metrics["trailing_logs"] = s.raftConfig.TrailingLogs
+	metrics["snapshot_threshold"] = s.raftConfig.SnapshotThreshold
+
+	if cdc, ok := s.CDCStatus(); ok {
+		metrics["cdc_cursor"] = cdc.Cursor
+		metrics["cdc_published"] = cdc.Published
+		metrics["cdc_skipped"] = cdc.Skipped
+	}
+	if start, ok := s.fsm.HistoryStart(); ok {
+		metrics["history_start_index"] = start
+	}
+	if s.fsm.projection != nil {
+		index, lastErr := s.fsm.projection.status()
+		metrics["sql_projection_index"] = index
+		if lastErr != "" {
+			metrics["sql_projection_error"] = lastErr
+		}
+	}
+	if s.fsm.hooks != nil {
+		backlog, failures := s.fsm.hooks.status()
+		metrics["apply_hooks_backlog"] = backlog
+		metrics["apply_hook_failures"] = failures
+	}
+
+	quorum := s.QuorumStatus()
+	metrics["quorum_lost"] = quorum.Lost
+	if quorum.Lost {
+		metrics["quorum_lost_since"] = *quorum.Since
 	}
 
 	return metrics
-}
\ No newline at end of file
+}
@@ -0,0 +1,55 @@
+package raft
+
+import (
+	"crypto/tls"
+	"net"
+	"time"
+
+	"github.com/hashicorp/raft"
+)
+
+// TLSProvider supplies TLS configs for the Raft transport. Configs are asked
+// for per listener and per dial, so rotated certificates apply to new
+// connections without restarting the node.
+type TLSProvider interface {
+	// ServerConfig returns the config for accepting connections
+	ServerConfig(clientAuth tls.ClientAuthType) *tls.Config
+
+	// ClientConfig returns the config for dialing serverName
+	ClientConfig(serverName string) *tls.Config
+}
+
+// tlsStreamLayer carries Raft traffic over mutually authenticated TLS
+type tlsStreamLayer struct {
+	net.Listener
+	advertise net.Addr
+	provider  TLSProvider
+}
+
+// newTLSStreamLayer listens on bindAddr, advertising advertise to peers
+func newTLSStreamLayer(bindAddr string, advertise net.Addr, provider TLSProvider) (*tlsStreamLayer, error) {
+	listener, err := net.Listen("tcp", bindAddr)
+	if err != nil {
+		return nil, err
+	}
+	return &tlsStreamLayer{
+		Listener:  tls.NewListener(listener, provider.ServerConfig(tls.RequireAndVerifyClientCert)),
+		advertise: advertise,
+		provider:  provider,
+	}, nil
+}
+
+// Dial opens a TLS connection to a peer
+func (t *tlsStreamLayer) Dial(address raft.ServerAddress, timeout time.Duration) (net.Conn, error) {
+	host, _, err := net.SplitHostPort(string(address))
+	if err != nil {
+		return nil, err
+	}
+	dialer := &net.Dialer{Timeout: timeout}
+	return tls.DialWithDialer(dialer, "tcp", string(address), t.provider.ClientConfig(host))
+}
+
+// Addr returns the address peers reach this node at
+func (t *tlsStreamLayer) Addr() net.Addr {
+	return t.advertise
+}
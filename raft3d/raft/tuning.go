@@ -0,0 +1,37 @@
+package raft
+
+import "time"
+
+// Tuning holds the Raft timing and connection settings of a node
+type Tuning struct {
+	HeartbeatTimeout   time.Duration
+	ElectionTimeout    time.Duration
+	LeaderLeaseTimeout time.Duration
+	CommitTimeout      time.Duration
+
+	// MaxPool is the number of pooled TCP connections kept per peer
+	MaxPool int
+}
+
+// DefaultTuning returns short timeouts suited to a demo cluster on a LAN
+func DefaultTuning() Tuning {
+	return Tuning{
+		HeartbeatTimeout:   500 * time.Millisecond,
+		ElectionTimeout:    500 * time.Millisecond,
+		LeaderLeaseTimeout: 400 * time.Millisecond,
+		CommitTimeout:      100 * time.Millisecond,
+		MaxPool:            3,
+	}
+}
+
+// EmbeddedTuning returns relaxed timeouts for small ARM boards, where GC
+// pauses and slow SD cards would otherwise cause spurious elections
+func EmbeddedTuning() Tuning {
+	return Tuning{
+		HeartbeatTimeout:   1500 * time.Millisecond,
+		ElectionTimeout:    1500 * time.Millisecond,
+		LeaderLeaseTimeout: 1000 * time.Millisecond,
+		CommitTimeout:      200 * time.Millisecond,
+		MaxPool:            1,
+	}
+}
@@ -0,0 +1,252 @@
+package raft
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/hashicorp/raft"
+	raftboltdb "github.com/hashicorp/raft-boltdb/v2"
+	"go.etcd.io/bbolt"
+)
+
+// Severity of a problem found while verifying a data directory
+const (
+	SeverityWarning = "warning"
+	SeverityError   = "error"
+)
+
+// VerifyIssue is a single problem found in a node's data directory
+type VerifyIssue struct {
+	Severity string `json:"severity"`
+	Index    uint64 `json:"index,omitempty"`
+	Problem  string `json:"problem"`
+	Repair   string `json:"repair"`
+}
+
+// VerifyReport summarizes the state of a node's Raft log, stable store and snapshots
+type VerifyReport struct {
+	DataDir       string        `json:"data_dir"`
+	FirstIndex    uint64        `json:"first_index"`
+	LastIndex     uint64        `json:"last_index"`
+	LastTerm      uint64        `json:"last_term"`
+	CurrentTerm   uint64        `json:"current_term"`
+	EntriesRead   uint64        `json:"entries_read"`
+	SnapshotIndex uint64        `json:"snapshot_index"`
+	SnapshotTerm  uint64        `json:"snapshot_term"`
+	Issues        []VerifyIssue `json:"issues"`
+}
+
+// Healthy reports whether verification found no errors
+func (r *VerifyReport) Healthy() bool {
+	for _, issue := range r.Issues {
+		if issue.Severity == SeverityError {
+			return false
+		}
+	}
+	return true
+}
+
+func (r *VerifyReport) addIssue(severity string, index uint64, problem, repair string) {
+	r.Issues = append(r.Issues, VerifyIssue{
+		Severity: severity,
+		Index:    index,
+		Problem:  problem,
+		Repair:   repair,
+	})
+}
+
+// Verify inspects an offline node's data directory. It opens the bolt log and
+// stable store read-only and checks for gaps, undecodable entries, term
+// regressions and snapshot/log consistency. It fails if the store is locked by
+// a running node. Encrypted data is only checked when $RAFT3D_ENCRYPTION_KEY
+// holds the key.
+func Verify(dataDir string) (*VerifyReport, error) {
+	atRest, err := cipherFromEnv()
+	if err != nil {
+		return nil, err
+	}
+
+	dbPath := filepath.Join(dataDir, "raft.db")
+	if _, err := os.Stat(dbPath); err != nil {
+		return nil, err
+	}
+
+	store, err := raftboltdb.New(raftboltdb.Options{
+		Path:        dbPath,
+		BoltOptions: &bbolt.Options{ReadOnly: true, Timeout: 2 * time.Second},
+	})
+	if err != nil {
+		if errors.Is(err, bbolt.ErrTimeout) {
+			return nil, fmt.Errorf("%s is locked; stop the node before verifying", dbPath)
+		}
+		return nil, err
+	}
+	defer store.Close()
+
+	report := &VerifyReport{DataDir: dataDir}
+	verifyLog(store, atRest, report)
+	verifyStableStore(store, report)
+	verifySnapshots(dataDir, store, atRest, report)
+
+	return report, nil
+}
+
+// verifyLog walks every entry between the first and last index
+func verifyLog(store *raftboltdb.BoltStore, atRest *atRestCipher, report *VerifyReport) {
+	first, err := store.FirstIndex()
+	if err != nil {
+		report.addIssue(SeverityError, 0, fmt.Sprintf("cannot read first index: %s", err),
+			"the log bucket is unreadable; rebuild this node by removing its data directory and rejoining")
+		return
+	}
+	last, err := store.LastIndex()
+	if err != nil {
+		report.addIssue(SeverityError, 0, fmt.Sprintf("cannot read last index: %s", err),
+			"the log bucket is unreadable; rebuild this node by removing its data directory and rejoining")
+		return
+	}
+	report.FirstIndex, report.LastIndex = first, last
+	if last == 0 {
+		return
+	}
+
+	var prevTerm, encrypted uint64
+	for idx := first; idx <= last; idx++ {
+		var entry raft.Log
+		if err := store.GetLog(idx, &entry); err != nil {
+			if errors.Is(err, raft.ErrLogNotFound) {
+				report.addIssue(SeverityError, idx, "missing log entry (gap in log)",
+					"truncate the log after the gap or rebuild the node from a healthy peer")
+			} else {
+				report.addIssue(SeverityError, idx, fmt.Sprintf("corrupt log entry: %s", err),
+					"truncate the log at this index and let the leader re-replicate it")
+			}
+			continue
+		}
+		report.EntriesRead++
+
+		if entry.Index != idx {
+			report.addIssue(SeverityError, idx, fmt.Sprintf("entry is stored under index %d but claims index %d", idx, entry.Index),
+				"truncate the log at this index and let the leader re-replicate it")
+		}
+		if entry.Term < prevTerm {
+			report.addIssue(SeverityError, idx, fmt.Sprintf("term regression: term %d follows term %d", entry.Term, prevTerm),
+				"truncate the log at this index and let the leader re-replicate it")
+		}
+		prevTerm = entry.Term
+
+		if entry.Type == raft.LogCommand {
+			data, err := atRest.open(entry.Data)
+			if errors.Is(err, ErrEncrypted) {
+				encrypted++
+				continue
+			}
+			if err != nil {
+				report.addIssue(SeverityWarning, idx, fmt.Sprintf("command payload does not decrypt: %s", err),
+					"check the encryption key; if it is right, truncate the log at this index and let the leader re-replicate it")
+				continue
+			}
+			var cmd Command
+			if err := json.Unmarshal(data, &cmd); err != nil {
+				report.addIssue(SeverityWarning, idx, fmt.Sprintf("command payload does not decode: %s", err),
+					"the FSM will reject this entry on replay; no action needed unless state is missing")
+			}
+		}
+	}
+	report.LastTerm = prevTerm
+	if encrypted > 0 {
+		report.addIssue(SeverityWarning, 0, fmt.Sprintf("%d encrypted command(s) were not checked", encrypted),
+			"set "+EncryptionKeyEnv+" to the cluster's key to check them")
+	}
+}
+
+// verifyStableStore checks the persisted term against the log
+func verifyStableStore(store *raftboltdb.BoltStore, report *VerifyReport) {
+	currentTerm, err := store.GetUint64([]byte("CurrentTerm"))
+	if err != nil {
+		if report.LastIndex > 0 {
+			report.addIssue(SeverityError, 0, fmt.Sprintf("cannot read current term: %s", err),
+				"the stable store is damaged; rebuild the node from a healthy peer")
+		}
+		return
+	}
+	report.CurrentTerm = currentTerm
+
+	if currentTerm < report.LastTerm {
+		report.addIssue(SeverityError, 0,
+			fmt.Sprintf("current term %d is behind last log term %d", currentTerm, report.LastTerm),
+			"the stable store is stale; rebuild the node from a healthy peer")
+	}
+}
+
+// verifySnapshots checks the newest snapshot decodes and lines up with the log
+func verifySnapshots(dataDir string, store *raftboltdb.BoltStore, atRest *atRestCipher, report *VerifyReport) {
+	snapshots, err := raft.NewFileSnapshotStore(dataDir, 1, io.Discard)
+	if err != nil {
+		report.addIssue(SeverityError, 0, fmt.Sprintf("cannot open snapshot store: %s", err),
+			"check permissions on the snapshots directory")
+		return
+	}
+	metas, err := snapshots.List()
+	if err != nil {
+		report.addIssue(SeverityError, 0, fmt.Sprintf("cannot list snapshots: %s", err),
+			"remove unreadable entries from the snapshots directory")
+		return
+	}
+
+	if len(metas) == 0 {
+		if report.FirstIndex > 1 {
+			report.addIssue(SeverityError, report.FirstIndex,
+				fmt.Sprintf("log starts at index %d but no snapshot covers earlier entries", report.FirstIndex),
+				"state before the first log entry is lost; rebuild the node from a healthy peer")
+		}
+		return
+	}
+
+	// List returns snapshots newest first
+	meta := metas[0]
+	report.SnapshotIndex, report.SnapshotTerm = meta.Index, meta.Term
+
+	if report.LastIndex > 0 && meta.Index+1 < report.FirstIndex {
+		report.addIssue(SeverityError, meta.Index,
+			fmt.Sprintf("snapshot ends at index %d but log starts at %d", meta.Index, report.FirstIndex),
+			"entries between the snapshot and the log are lost; rebuild the node from a healthy peer")
+	}
+
+	var entry raft.Log
+	if err := store.GetLog(meta.Index, &entry); err == nil && entry.Term != meta.Term {
+		report.addIssue(SeverityError, meta.Index,
+			fmt.Sprintf("snapshot term %d does not match log term %d at index %d", meta.Term, entry.Term, meta.Index),
+			"remove the snapshot and let the node take a new one, or rebuild from a healthy peer")
+	}
+
+	_, rc, err := snapshots.Open(meta.ID)
+	if err != nil {
+		report.addIssue(SeverityError, meta.Index, fmt.Sprintf("cannot open snapshot %s: %s", meta.ID, err),
+			"remove the snapshot directory so an older snapshot is used")
+		return
+	}
+	defer rc.Close()
+
+	raw, err := io.ReadAll(rc)
+	if err == nil {
+		raw, err = atRest.open(raw)
+	}
+	if errors.Is(err, ErrEncrypted) {
+		report.addIssue(SeverityWarning, meta.Index, fmt.Sprintf("snapshot %s is encrypted and was not checked", meta.ID),
+			"set "+EncryptionKeyEnv+" to the cluster's key to check it")
+		return
+	}
+	if err == nil {
+		_, _, err = decodeSnapshot(raw)
+	}
+	if err != nil {
+		report.addIssue(SeverityError, meta.Index, fmt.Sprintf("snapshot %s does not decode: %s", meta.ID, err),
+			"remove the snapshot directory so an older snapshot is used")
+	}
+}
@@ -0,0 +1,81 @@
+package raft
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/raft"
+	raftboltdb "github.com/hashicorp/raft-boltdb/v2"
+)
+
+// writeDataDir writes log entries and a current term into a new data directory
+func writeDataDir(t *testing.T, currentTerm uint64, entries ...*raft.Log) string {
+	t.Helper()
+	dir := t.TempDir()
+	store, err := raftboltdb.NewBoltStore(filepath.Join(dir, "raft.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	if err := store.StoreLogs(entries); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.SetUint64([]byte("CurrentTerm"), currentTerm); err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func command(index, term uint64, data string) *raft.Log {
+	return &raft.Log{Index: index, Term: term, Type: raft.LogCommand, Data: []byte(data)}
+}
+
+func TestVerify(t *testing.T) {
+	t.Setenv(EncryptionKeyEnv, "")
+	set := `{"op":"set","key":"printer_p1","value":"{}"}`
+
+	dir := writeDataDir(t, 2, command(1, 1, set), command(2, 1, set), command(3, 2, set))
+	report, err := Verify(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !report.Healthy() || len(report.Issues) != 0 || report.FirstIndex != 1 || report.LastIndex != 3 ||
+		report.EntriesRead != 3 || report.LastTerm != 2 || report.CurrentTerm != 2 {
+		t.Fatalf("healthy log: %+v", report)
+	}
+
+	// A gap, a term regression, an undecodable command and a stale stable
+	// store are each reported at the index they were found
+	dir = writeDataDir(t, 0, command(1, 1, set), command(2, 2, set), command(4, 1, "not json"))
+	report, err = Verify(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Healthy() {
+		t.Fatalf("damaged log reported healthy: %+v", report)
+	}
+	want := []struct {
+		severity string
+		index    uint64
+		problem  string
+	}{
+		{SeverityError, 3, "gap in log"},
+		{SeverityError, 4, "term regression"},
+		{SeverityWarning, 4, "does not decode"},
+		{SeverityError, 0, "current term 0 is behind last log term 1"},
+	}
+	if len(report.Issues) != len(want) {
+		t.Fatalf("issues: %+v", report.Issues)
+	}
+	for i, w := range want {
+		issue := report.Issues[i]
+		if issue.Severity != w.severity || issue.Index != w.index || !strings.Contains(issue.Problem, w.problem) || issue.Repair == "" {
+			t.Errorf("issue %d = %+v, want %s at %d: %s", i, issue, w.severity, w.index, w.problem)
+		}
+	}
+
+	if _, err := Verify(t.TempDir()); err == nil {
+		t.Fatal("verified a directory without raft.db")
+	}
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"raft3d/raft"
+)
+
+// runRecover implements the "recover" subcommand, which rewrites an offline
+// node's cluster membership from a peers.json file after quorum has been lost
+// for good. It returns the process exit code.
+func runRecover(args []string) int {
+	fs := flag.NewFlagSet("recover", flag.ExitOnError)
+	peersPath := fs.String("peers", "", "Path to a peers.json file listing the surviving voters")
+	nodeID := fs.String("id", "", "Node ID of the node being recovered")
+	dataDir := fs.String("data", "data", "Directory for data storage")
+	httpAddr := fs.String("http", "", "HTTP address of the node, checked to make sure it is stopped")
+	fs.Parse(args)
+
+	if *peersPath == "" || *nodeID == "" {
+		fmt.Fprintln(os.Stderr, "recover: --peers and --id are required")
+		return 2
+	}
+
+	if *httpAddr != "" {
+		client := &http.Client{Timeout: 2 * time.Second}
+		if resp, err := client.Get(fmt.Sprintf("http://%s/healthz", *httpAddr)); err == nil {
+			resp.Body.Close()
+			fmt.Fprintf(os.Stderr, "recover: node is still serving on %s; stop it first\n", *httpAddr)
+			return 1
+		}
+	}
+
+	nodeDataDir := filepath.Join(*dataDir, *nodeID)
+	configuration, err := raft.RecoverPeers(nodeDataDir, *nodeID, *peersPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "recover: %s\n", err)
+		return 1
+	}
+
+	fmt.Printf("Recovered %s with %d peers:\n", nodeDataDir, len(configuration.Servers))
+	for _, server := range configuration.Servers {
+		fmt.Printf("  %s %s (%s)\n", server.ID, server.Address, server.Suffrage)
+	}
+	fmt.Println("Recover every surviving node with the same peers file, then start them without -bootstrap")
+	return 0
+}
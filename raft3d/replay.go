@@ -0,0 +1,74 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"raft3d/raft"
+)
+
+// runReplay implements the "replay" subcommand. It rebuilds FSM state from a
+// log archive, optionally on top of a base backup, and writes the result as a
+// backup archive that the restore subcommand accepts.
+func runReplay(args []string) int {
+	fs := flag.NewFlagSet("replay", flag.ExitOnError)
+	archiveDir := fs.String("log-archive", "", "Log archive directory written by -log-archive, or the s3:// or gs:// <target>/<id> it was shipped to")
+	basePath := fs.String("backup", "", "Optional base backup to replay on top of")
+	toIndex := fs.Uint64("to-index", 0, "Last Raft index to apply (0 applies everything)")
+	out := fs.String("out", "", "Path of the backup archive to write")
+	fs.Parse(args)
+
+	if *archiveDir == "" || *out == "" {
+		fmt.Fprintln(os.Stderr, "replay: --log-archive and --out are required")
+		return 2
+	}
+
+	var base *raft.Backup
+	if *basePath != "" {
+		f, err := raft.OpenBackup(*basePath)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "replay: %s\n", err)
+			return 1
+		}
+		base, err = raft.ReadBackup(f)
+		f.Close()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "replay: failed to read base backup: %s\n", err)
+			return 1
+		}
+	}
+
+	result, err := raft.ReplayArchive(*archiveDir, base, *toIndex)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "replay: %s\n", err)
+		return 1
+	}
+
+	if err := writeBackupFile(*out, result); err != nil {
+		fmt.Fprintf(os.Stderr, "replay: %s\n", err)
+		return 1
+	}
+
+	fmt.Printf("Rebuilt %d keys at index %d into %s\n", len(result.Data), result.Index, *out)
+	return 0
+}
+
+// writeBackupFile writes a backup archive to path, encrypted when
+// $RAFT3D_ENCRYPTION_KEY is set
+func writeBackupFile(path string, backup *raft.Backup) error {
+	archive, err := raft.EncodeBackup(backup)
+	if err != nil {
+		return err
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if _, err := f.Write(archive); err != nil {
+		return err
+	}
+	return f.Sync()
+}
@@ -0,0 +1,131 @@
+// Package secrets reads key material from files or HashiCorp Vault and
+// notices when it is rotated
+package secrets
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// Environment variables used to reach Vault
+const (
+	VaultAddrEnv  = "VAULT_ADDR"
+	VaultTokenEnv = "VAULT_TOKEN"
+)
+
+// vaultPrefix marks a reference to a Vault secret, e.g.
+// "vault:secret/data/raft3d#api_keys"
+const vaultPrefix = "vault:"
+
+var vaultClient = &http.Client{Timeout: 10 * time.Second}
+
+// Read returns the secret a reference points to. A reference is a file path,
+// a file:// URL or "vault:<path>#<field>", which reads one field of a Vault
+// KV secret (version 1 or 2) using $VAULT_ADDR and $VAULT_TOKEN.
+func Read(ref string) ([]byte, error) {
+	if strings.HasPrefix(ref, vaultPrefix) {
+		return readVault(strings.TrimPrefix(ref, vaultPrefix))
+	}
+	return os.ReadFile(strings.TrimPrefix(ref, "file://"))
+}
+
+// readVault reads a field of the secret at path
+func readVault(ref string) ([]byte, error) {
+	path, field, ok := strings.Cut(ref, "#")
+	if !ok || path == "" || field == "" {
+		return nil, fmt.Errorf("vault reference %q must be <path>#<field>", ref)
+	}
+	addr, token := os.Getenv(VaultAddrEnv), os.Getenv(VaultTokenEnv)
+	if addr == "" || token == "" {
+		return nil, fmt.Errorf("vault reference %q needs $%s and $%s", ref, VaultAddrEnv, VaultTokenEnv)
+	}
+
+	req, err := http.NewRequest(http.MethodGet, strings.TrimSuffix(addr, "/")+"/v1/"+strings.TrimPrefix(path, "/"), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-Vault-Token", token)
+	resp, err := vaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("vault: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("vault: reading %s: %s", path, resp.Status)
+	}
+
+	var body struct {
+		Data map[string]json.RawMessage `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return nil, fmt.Errorf("vault: reading %s: %w", path, err)
+	}
+	fields := body.Data
+	// KV version 2 nests the fields one level deeper, next to the metadata
+	if nested, ok := body.Data["data"]; ok {
+		if _, hasMeta := body.Data["metadata"]; hasMeta {
+			fields = nil
+			if err := json.Unmarshal(nested, &fields); err != nil {
+				return nil, fmt.Errorf("vault: reading %s: %w", path, err)
+			}
+		}
+	}
+	raw, ok := fields[field]
+	if !ok {
+		return nil, fmt.Errorf("vault: %s has no field %q", path, field)
+	}
+
+	// String fields are returned as is, anything else as JSON
+	var s string
+	if err := json.Unmarshal(raw, &s); err == nil {
+		return []byte(s), nil
+	}
+	return raw, nil
+}
+
+// Watch polls the secrets refs returns every interval and calls onChange
+// whenever their content differs from the previous poll, until stop is
+// closed. refs is called on every poll, so the watched set may change.
+// Secrets that can't be read are logged and don't count as a change.
+func Watch(interval time.Duration, stop <-chan struct{}, refs func() []string, onChange func()) {
+	last := digest(refs())
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			current := digest(refs())
+			if current == "" || current == last {
+				continue
+			}
+			last = current
+			onChange()
+		}
+	}
+}
+
+// digest hashes the content of every ref, or returns "" if any can't be read
+func digest(refs []string) string {
+	h := sha256.New()
+	for _, ref := range refs {
+		if ref == "" {
+			continue
+		}
+		data, err := Read(ref)
+		if err != nil {
+			log.Printf("Failed to read secret %s: %s", ref, err)
+			return ""
+		}
+		fmt.Fprintf(h, "%s\x00%d\x00", ref, len(data))
+		h.Write(data)
+	}
+	return fmt.Sprintf("%x", h.Sum(nil))
+}
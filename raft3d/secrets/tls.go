@@ -0,0 +1,110 @@
+package secrets
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"sync"
+)
+
+// Certificates holds a TLS key pair and an optional CA bundle loaded from
+// secret references. Configs it hands out look the material up on every
+// handshake, so Reload takes effect without restarting listeners.
+type Certificates struct {
+	certRef, keyRef, caRef string
+
+	mutex sync.RWMutex
+	cert  *tls.Certificate
+	pool  *x509.CertPool // nil when no CA is configured
+}
+
+// LoadCertificates reads a PEM certificate and key, and a CA bundle that
+// peers' certificates must chain to when caRef is set
+func LoadCertificates(certRef, keyRef, caRef string) (*Certificates, error) {
+	c := &Certificates{certRef: certRef, keyRef: keyRef, caRef: caRef}
+	if err := c.Reload(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Refs returns the references the material is read from
+func (c *Certificates) Refs() []string {
+	return []string{c.certRef, c.keyRef, c.caRef}
+}
+
+// Reload re-reads the material. Nothing changes if any of it is invalid.
+func (c *Certificates) Reload() error {
+	certPEM, err := Read(c.certRef)
+	if err != nil {
+		return fmt.Errorf("certificate: %w", err)
+	}
+	keyPEM, err := Read(c.keyRef)
+	if err != nil {
+		return fmt.Errorf("key: %w", err)
+	}
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return err
+	}
+
+	var pool *x509.CertPool
+	if c.caRef != "" {
+		caPEM, err := Read(c.caRef)
+		if err != nil {
+			return fmt.Errorf("CA: %w", err)
+		}
+		pool = x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(caPEM) {
+			return fmt.Errorf("CA: %s holds no PEM certificates", c.caRef)
+		}
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.cert, c.pool = &cert, pool
+	return nil
+}
+
+// current returns the loaded key pair and CA pool
+func (c *Certificates) current() (*tls.Certificate, *x509.CertPool) {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	return c.cert, c.pool
+}
+
+// ServerConfig returns a config for listeners. Client certificates are
+// checked with clientAuth when a CA is configured and ignored otherwise.
+func (c *Certificates) ServerConfig(clientAuth tls.ClientAuthType) *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			cert, _ := c.current()
+			return cert, nil
+		},
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			cert, pool := c.current()
+			config := &tls.Config{
+				MinVersion:   tls.VersionTLS12,
+				Certificates: []tls.Certificate{*cert},
+			}
+			if pool != nil {
+				config.ClientCAs, config.ClientAuth = pool, clientAuth
+			}
+			return config, nil
+		},
+	}
+}
+
+// ClientConfig returns a config for dialing serverName. The server must
+// present a certificate from the CA, or from the system roots when none is
+// configured, and our own certificate is offered to it.
+func (c *Certificates) ClientConfig(serverName string) *tls.Config {
+	cert, pool := c.current()
+	return &tls.Config{
+		MinVersion:   tls.VersionTLS12,
+		ServerName:   serverName,
+		RootCAs:      pool,
+		Certificates: []tls.Certificate{*cert},
+	}
+}
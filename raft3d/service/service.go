@@ -0,0 +1,91 @@
+// Package service tells the process manager running a node how it is doing:
+// systemd through sd_notify when the unit has Type=notify, or the Windows
+// service control manager when the node runs as a Windows service. Without
+// either, reporting does nothing.
+package service
+
+import (
+	"net"
+	"os"
+	"sync"
+)
+
+// Host is the process manager running the node, if any
+type Host struct {
+	stop    chan struct{} // closed when the manager asks the node to stop
+	ready   chan struct{} // closed by Ready
+	stopped chan struct{} // closed by Stopped
+
+	// exited is closed once the Windows service handler has returned, and is
+	// nil when the node doesn't run as a Windows service
+	exited chan struct{}
+
+	stopOnce    sync.Once
+	readyOnce   sync.Once
+	stoppedOnce sync.Once
+}
+
+// Detect finds out what runs the node. On Windows it connects to the service
+// control manager when the node runs as the service called name.
+func Detect(name string) (*Host, error) {
+	h := &Host{
+		stop:    make(chan struct{}),
+		ready:   make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+	if err := runService(name, h); err != nil {
+		return nil, err
+	}
+	return h, nil
+}
+
+// Stop is closed when the process manager asks the node to stop, or once
+// Stopping is called. Signals still have to be handled separately.
+func (h *Host) Stop() <-chan struct{} {
+	return h.stop
+}
+
+// Ready reports that the node is serving, with a status line for systemctl
+// status. Only the first call tells the Windows service manager.
+func (h *Host) Ready(status string) error {
+	h.readyOnce.Do(func() { close(h.ready) })
+	return notify("READY=1\nSTATUS=" + status)
+}
+
+// Status updates the status line shown by systemctl status
+func (h *Host) Status(status string) error {
+	return notify("STATUS=" + status)
+}
+
+// Stopping reports that the node is shutting down
+func (h *Host) Stopping() error {
+	h.stopOnce.Do(func() { close(h.stop) })
+	return notify("STOPPING=1")
+}
+
+// Stopped reports that the node has shut down. As a Windows service it
+// waits until the service manager has been told, so call it last.
+func (h *Host) Stopped() {
+	h.stoppedOnce.Do(func() { close(h.stopped) })
+	if h.exited != nil {
+		<-h.exited
+	}
+}
+
+// notify sends a state to systemd over $NOTIFY_SOCKET, which systemd sets
+// for units with Type=notify. It does nothing when the variable is unset.
+func notify(state string) error {
+	socket := os.Getenv("NOTIFY_SOCKET")
+	if socket == "" {
+		return nil
+	}
+	// Names starting with @ are in the abstract namespace, which the net
+	// package handles
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	_, err = conn.Write([]byte(state))
+	return err
+}
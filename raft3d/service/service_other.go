@@ -0,0 +1,8 @@
+//go:build !windows
+
+package service
+
+// runService does nothing outside Windows
+func runService(name string, h *Host) error {
+	return nil
+}
@@ -0,0 +1,57 @@
+//go:build !windows
+
+package service
+
+import (
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestNotifiesSystemd(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "notify.sock")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	t.Setenv("NOTIFY_SOCKET", path)
+
+	h, err := Detect("raft3d")
+	if err != nil {
+		t.Fatal(err)
+	}
+	read := func() string {
+		conn.SetReadDeadline(time.Now().Add(time.Second))
+		buf := make([]byte, 256)
+		n, err := conn.Read(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(buf[:n])
+	}
+
+	if err := h.Ready("leader node1"); err != nil {
+		t.Fatal(err)
+	}
+	if got := read(); got != "READY=1\nSTATUS=leader node1" {
+		t.Fatalf("sent %q", got)
+	}
+	h.Stopping()
+	if got := read(); got != "STOPPING=1" {
+		t.Fatalf("sent %q", got)
+	}
+	select {
+	case <-h.Stop():
+	default:
+		t.Fatal("Stop not closed by Stopping")
+	}
+	h.Stopped()
+
+	// Without systemd nothing is sent
+	t.Setenv("NOTIFY_SOCKET", "")
+	if err := h.Ready("again"); err != nil {
+		t.Fatal(err)
+	}
+}
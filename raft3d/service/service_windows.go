@@ -0,0 +1,72 @@
+//go:build windows
+
+package service
+
+import (
+	"log"
+
+	"golang.org/x/sys/windows/svc"
+)
+
+// startWaitHint is how long the service manager is told starting may take
+// before the node reports ready
+const startWaitHint = 120000 // milliseconds
+
+// runService connects to the service control manager when the process runs
+// as a Windows service
+func runService(name string, h *Host) error {
+	isService, err := svc.IsWindowsService()
+	if err != nil || !isService {
+		return err
+	}
+	h.exited = make(chan struct{})
+	go func() {
+		defer close(h.exited)
+		if err := svc.Run(name, handler{h}); err != nil {
+			log.Printf("Windows service %s failed: %s", name, err)
+		}
+	}()
+	return nil
+}
+
+// handler reports the host's state to the service control manager and
+// passes on its stop and shutdown requests
+type handler struct {
+	h *Host
+}
+
+// Execute runs until the node reports it has stopped
+func (s handler) Execute(args []string, requests <-chan svc.ChangeRequest, changes chan<- svc.Status) (bool, uint32) {
+	status := svc.Status{State: svc.StartPending, WaitHint: startWaitHint}
+	changes <- status
+
+	ready, stop := s.h.ready, s.h.stop
+	for {
+		select {
+		case <-ready:
+			ready = nil
+			if status.State == svc.StopPending {
+				continue
+			}
+			status = svc.Status{State: svc.Running, Accepts: svc.AcceptStop | svc.AcceptShutdown}
+			changes <- status
+		case <-stop:
+			// The node is shutting down, at the manager's request or its own
+			stop = nil
+			if status.State != svc.StopPending {
+				status = svc.Status{State: svc.StopPending}
+				changes <- status
+			}
+		case <-s.h.stopped:
+			changes <- svc.Status{State: svc.Stopped}
+			return false, 0
+		case request := <-requests:
+			switch request.Cmd {
+			case svc.Interrogate:
+				changes <- request.CurrentStatus
+			case svc.Stop, svc.Shutdown:
+				s.h.stopOnce.Do(func() { close(s.h.stop) })
+			}
+		}
+	}
+}
@@ -0,0 +1,7 @@
+//go:build sqlite
+
+package main
+
+// Building with -tags sqlite links a SQLite driver for -sql-projection. It
+// is pure Go, so cross-compiling for ARM boards needs no C toolchain.
+import _ "modernc.org/sqlite"
@@ -0,0 +1,28 @@
+//go:build sqlite
+
+package main
+
+import (
+	"database/sql"
+	"path/filepath"
+	"testing"
+)
+
+// TestSQLiteDriver checks the linked driver registers under a name the SQL
+// projection looks for and supports the WAL mode and JSON functions it uses
+func TestSQLiteDriver(t *testing.T) {
+	db, err := sql.Open("sqlite", filepath.Join(t.TempDir(), "projection.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	var mode string
+	if err := db.QueryRow("PRAGMA journal_mode = WAL").Scan(&mode); err != nil || mode != "wal" {
+		t.Fatalf("journal mode = %q, %v", mode, err)
+	}
+	var name string
+	if err := db.QueryRow(`SELECT json_extract('{"name":"Prusa"}', '$.name')`).Scan(&name); err != nil || name != "Prusa" {
+		t.Fatalf("json_extract = %q, %v", name, err)
+	}
+}
@@ -0,0 +1,270 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"raft3d/api"
+)
+
+// NodeStateHash is what one node reported for its state hash
+type NodeStateHash struct {
+	Addr   string               `json:"addr"`
+	Report *api.StateHashReport `json:"report,omitempty"`
+	Error  string               `json:"error,omitempty"`
+}
+
+// HashGroup is the nodes at one index that reported the same hash
+type HashGroup struct {
+	Hash  string   `json:"hash"`
+	Nodes []string `json:"nodes"`
+}
+
+// StateHashDivergence is an index at which nodes reported different hashes
+type StateHashDivergence struct {
+	Index  uint64      `json:"applied_index"`
+	Groups []HashGroup `json:"groups"` // largest first
+}
+
+// StateHashComparison is the outcome of comparing the nodes' state hashes
+type StateHashComparison struct {
+	Nodes       []NodeStateHash       `json:"nodes"`
+	Agreed      []uint64              `json:"agreed_indexes,omitempty"` // indexes at which every node there agreed
+	Divergent   []StateHashDivergence `json:"divergent,omitempty"`
+	Unmatched   []string              `json:"unmatched,omitempty"` // nodes no other node shared an index with
+	Unreachable []string              `json:"unreachable,omitempty"`
+}
+
+// runStateHash implements the "state-hash" subcommand, which asks every node
+// for the hash of its state and reports nodes that disagree at the same
+// applied index. It returns 0 when they agree, 1 on divergence and 2 when
+// nothing could be compared.
+func runStateHash(args []string) int {
+	fs := flag.NewFlagSet("state-hash", flag.ExitOnError)
+	nodes := fs.String("nodes", "", "Comma-separated HTTP addresses of cluster nodes; other members are discovered")
+	apiKey := fs.String("api-key", "", "Admin API key, when the cluster requires authentication")
+	useTLS := fs.Bool("https", false, "Reach the nodes over HTTPS")
+	attempts := fs.Int("attempts", 5, "Times to ask nodes behind the others again, waiting for them to catch up")
+	asJSON := fs.Bool("json", false, "Print the comparison as JSON")
+	fs.Parse(args)
+
+	var seeds []string
+	for _, addr := range strings.Split(*nodes, ",") {
+		if addr = strings.TrimSpace(addr); addr != "" {
+			seeds = append(seeds, addr)
+		}
+	}
+	if len(seeds) == 0 {
+		fmt.Fprintln(os.Stderr, "state-hash: --nodes is required")
+		return 2
+	}
+
+	c := &stateHashClient{client: &http.Client{Timeout: 10 * time.Second}, scheme: "http", apiKey: *apiKey}
+	if *useTLS {
+		c.scheme = "https"
+	}
+	addrs := c.discover(seeds)
+
+	results := make(map[string]NodeStateHash, len(addrs))
+	for _, addr := range addrs {
+		results[addr] = c.fetch(addr, 0)
+	}
+	// Writes land between the requests, so ask nodes behind the furthest
+	// one again, waiting until they have applied as much
+	for attempt := 1; attempt < *attempts; attempt++ {
+		var target uint64
+		for _, result := range results {
+			if result.Report != nil && result.Report.Index > target {
+				target = result.Report.Index
+			}
+		}
+		behind := 0
+		for addr, result := range results {
+			if result.Report != nil && result.Report.Index < target {
+				results[addr] = c.fetch(addr, target)
+				behind++
+			}
+		}
+		if behind == 0 {
+			break
+		}
+	}
+
+	comparison := compareStateHashes(results)
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(comparison)
+	} else {
+		printStateHashComparison(comparison)
+	}
+
+	switch {
+	case len(comparison.Divergent) > 0:
+		return 1
+	case len(comparison.Agreed) == 0:
+		return 2
+	}
+	return 0
+}
+
+// stateHashClient queries nodes' admin endpoints
+type stateHashClient struct {
+	client *http.Client
+	scheme string
+	apiKey string
+}
+
+// get decodes the JSON response of a GET to a node
+func (c *stateHashClient) get(addr, path string, v interface{}) error {
+	req, err := http.NewRequest(http.MethodGet, fmt.Sprintf("%s://%s%s", c.scheme, addr, path), nil)
+	if err != nil {
+		return err
+	}
+	if c.apiKey != "" {
+		req.Header.Set("X-API-Key", c.apiKey)
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s", resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// discover returns the seeds and every node they know of, sorted
+func (c *stateHashClient) discover(seeds []string) []string {
+	found := make(map[string]bool)
+	for _, seed := range seeds {
+		found[seed] = true
+		var status api.ClusterStatus
+		if err := c.get(seed, "/cluster", &status); err != nil {
+			continue
+		}
+		for _, node := range status.Nodes {
+			if node.HTTPAddr != "" {
+				found[node.HTTPAddr] = true
+			}
+		}
+	}
+	addrs := make([]string, 0, len(found))
+	for addr := range found {
+		addrs = append(addrs, addr)
+	}
+	sort.Strings(addrs)
+	return addrs
+}
+
+// fetch asks a node for its state hash, once it has applied minIndex
+func (c *stateHashClient) fetch(addr string, minIndex uint64) NodeStateHash {
+	path := "/api/v1/admin/state-hash"
+	if minIndex > 0 {
+		path += fmt.Sprintf("?min_index=%d", minIndex)
+	}
+	var report api.StateHashReport
+	if err := c.get(addr, path, &report); err != nil {
+		return NodeStateHash{Addr: addr, Error: err.Error()}
+	}
+	return NodeStateHash{Addr: addr, Report: &report}
+}
+
+// compareStateHashes groups the nodes by applied index and, within each
+// index, by hash
+func compareStateHashes(results map[string]NodeStateHash) StateHashComparison {
+	var comparison StateHashComparison
+	byIndex := make(map[uint64]map[string][]string)
+	for _, result := range results {
+		comparison.Nodes = append(comparison.Nodes, result)
+		if result.Report == nil {
+			comparison.Unreachable = append(comparison.Unreachable, result.Addr)
+			continue
+		}
+		index := result.Report.Index
+		if byIndex[index] == nil {
+			byIndex[index] = make(map[string][]string)
+		}
+		byIndex[index][result.Report.Hash] = append(byIndex[index][result.Report.Hash], result.Addr)
+	}
+	sort.Slice(comparison.Nodes, func(i, j int) bool { return comparison.Nodes[i].Addr < comparison.Nodes[j].Addr })
+	sort.Strings(comparison.Unreachable)
+
+	indexes := make([]uint64, 0, len(byIndex))
+	for index := range byIndex {
+		indexes = append(indexes, index)
+	}
+	sort.Slice(indexes, func(i, j int) bool { return indexes[i] < indexes[j] })
+	for _, index := range indexes {
+		hashes := byIndex[index]
+		var groups []HashGroup
+		for hash, addrs := range hashes {
+			sort.Strings(addrs)
+			groups = append(groups, HashGroup{Hash: hash, Nodes: addrs})
+		}
+		switch {
+		case len(groups) > 1:
+			sort.Slice(groups, func(i, j int) bool {
+				if len(groups[i].Nodes) != len(groups[j].Nodes) {
+					return len(groups[i].Nodes) > len(groups[j].Nodes)
+				}
+				return groups[i].Hash < groups[j].Hash
+			})
+			comparison.Divergent = append(comparison.Divergent, StateHashDivergence{Index: index, Groups: groups})
+		case len(groups[0].Nodes) > 1:
+			comparison.Agreed = append(comparison.Agreed, index)
+		default:
+			comparison.Unmatched = append(comparison.Unmatched, groups[0].Nodes[0])
+		}
+	}
+	sort.Strings(comparison.Unmatched)
+	return comparison
+}
+
+// printStateHashComparison writes a human-readable comparison to stdout
+func printStateHashComparison(comparison StateHashComparison) {
+	for _, node := range comparison.Nodes {
+		if node.Report == nil {
+			fmt.Printf("%-24s unreachable: %s\n", node.Addr, node.Error)
+			continue
+		}
+		role := ""
+		if node.Report.IsLeader {
+			role = " (leader)"
+		}
+		fmt.Printf("%-24s %-12s index %-10d keys %-8d %s%s\n",
+			node.Addr, node.Report.NodeID, node.Report.Index, node.Report.Keys, shortHash(node.Report.Hash), role)
+	}
+
+	for _, divergence := range comparison.Divergent {
+		fmt.Printf("\nDIVERGED at index %d:\n", divergence.Index)
+		for _, group := range divergence.Groups {
+			fmt.Printf("  %s  %s\n", shortHash(group.Hash), strings.Join(group.Nodes, ", "))
+		}
+	}
+	if len(comparison.Unmatched) > 0 {
+		fmt.Printf("\nNot compared, no other node reached the same index: %s\n", strings.Join(comparison.Unmatched, ", "))
+	}
+	switch {
+	case len(comparison.Divergent) > 0:
+	case len(comparison.Agreed) == 0:
+		fmt.Println("\nNo two nodes could be compared")
+	default:
+		fmt.Println("\nNodes at the same index agree")
+	}
+}
+
+// shortHash abbreviates a hash for display
+func shortHash(hash string) string {
+	if len(hash) > 16 {
+		return hash[:16]
+	}
+	return hash
+}
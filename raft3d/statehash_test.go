@@ -0,0 +1,37 @@
+package main
+
+import (
+	"testing"
+
+	"raft3d/api"
+	"raft3d/raft"
+)
+
+func TestCompareStateHashes(t *testing.T) {
+	node := func(addr string, index uint64, hash string) NodeStateHash {
+		return NodeStateHash{Addr: addr, Report: &api.StateHashReport{StateHash: raft.StateHash{Index: index, Hash: hash}}}
+	}
+	results := map[string]NodeStateHash{
+		"a:8001": node("a:8001", 40, "aaaa"),
+		"b:8002": node("b:8002", 40, "aaaa"),
+		"c:8003": node("c:8003", 40, "bbbb"),
+		"d:8004": node("d:8004", 38, "cccc"),
+		"e:8005": {Addr: "e:8005", Error: "connection refused"},
+	}
+
+	comparison := compareStateHashes(results)
+	if len(comparison.Divergent) != 1 || comparison.Divergent[0].Index != 40 {
+		t.Fatalf("divergent = %+v", comparison.Divergent)
+	}
+	if groups := comparison.Divergent[0].Groups; len(groups) != 2 || groups[0].Hash != "aaaa" || len(groups[0].Nodes) != 2 || groups[1].Nodes[0] != "c:8003" {
+		t.Fatalf("groups = %+v", groups)
+	}
+	if len(comparison.Unmatched) != 1 || comparison.Unmatched[0] != "d:8004" || len(comparison.Unreachable) != 1 {
+		t.Fatalf("comparison = %+v", comparison)
+	}
+
+	delete(results, "c:8003")
+	if comparison := compareStateHashes(results); len(comparison.Divergent) != 0 || len(comparison.Agreed) != 1 {
+		t.Fatalf("agreeing nodes: %+v", comparison)
+	}
+}
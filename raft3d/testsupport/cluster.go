@@ -0,0 +1,234 @@
+// Package testsupport runs multi-node raft3d clusters inside a single process
+// so features can be exercised against real replication in tests.
+package testsupport
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	hraft "github.com/hashicorp/raft"
+
+	"raft3d/raft"
+)
+
+// Node is a single member of an in-process cluster
+type Node struct {
+	ID        string
+	Addr      hraft.ServerAddress
+	Store     *raft.RaftStore
+	Transport *hraft.InmemTransport
+	Chaos     *raft.Chaos // nil unless the cluster was started with NewChaosCluster
+}
+
+// Cluster is a set of nodes connected through in-memory transports
+type Cluster struct {
+	t     testing.TB
+	Nodes []*Node
+}
+
+// NewCluster starts an n-node cluster bootstrapped with every node as a
+// voter. The cluster is shut down when the test finishes.
+func NewCluster(t testing.TB, n int) *Cluster {
+	t.Helper()
+	return newCluster(t, n, false)
+}
+
+// NewChaosCluster is NewCluster with fault injection wired into every node,
+// all of it disabled until a test turns it on through Node.Chaos
+func NewChaosCluster(t testing.TB, n int) *Cluster {
+	t.Helper()
+	return newCluster(t, n, true)
+}
+
+func newCluster(t testing.TB, n int, chaos bool) *Cluster {
+	t.Helper()
+
+	c := &Cluster{t: t}
+	var voters []raft.NodeInfo
+	for i := 0; i < n; i++ {
+		id := fmt.Sprintf("node%d", i+1)
+		addr, transport := hraft.NewInmemTransport("")
+		node := &Node{ID: id, Addr: addr, Transport: transport}
+		if chaos {
+			node.Chaos = raft.NewChaos()
+		}
+		c.Nodes = append(c.Nodes, node)
+		voters = append(voters, raft.NodeInfo{ID: id, RaftAddr: string(addr)})
+	}
+	c.connectAll()
+
+	for _, node := range c.Nodes {
+		store, err := raft.NewRaftStore(raft.StoreConfig{
+			NodeID:    node.ID,
+			RaftAddr:  string(node.Addr),
+			Bootstrap: true,
+			Transport: node.Transport,
+			InMemory:  true,
+			Voters:    voters,
+			Chaos:     node.Chaos,
+		})
+		if err != nil {
+			c.Close()
+			t.Fatalf("failed to start %s: %s", node.ID, err)
+		}
+		node.Store = store
+	}
+
+	t.Cleanup(c.Close)
+	return c
+}
+
+// Close shuts down every node
+func (c *Cluster) Close() {
+	for _, node := range c.Nodes {
+		if node.Store != nil {
+			node.Store.Close()
+			node.Store = nil
+		}
+	}
+}
+
+// Node returns the node with the given ID
+func (c *Cluster) Node(id string) *Node {
+	for _, node := range c.Nodes {
+		if node.ID == id {
+			return node
+		}
+	}
+	c.t.Fatalf("no node %s in cluster", id)
+	return nil
+}
+
+// Leader returns the current leader, or nil if there is none
+func (c *Cluster) Leader() *Node {
+	for _, node := range c.Nodes {
+		if node.Store != nil && node.Store.IsLeader() {
+			return node
+		}
+	}
+	return nil
+}
+
+// Followers returns every node that isn't the current leader
+func (c *Cluster) Followers() []*Node {
+	leader := c.Leader()
+	var followers []*Node
+	for _, node := range c.Nodes {
+		if node != leader {
+			followers = append(followers, node)
+		}
+	}
+	return followers
+}
+
+// WaitForLeader waits until one of the given nodes (all nodes if none are
+// given) is leader and returns it
+func (c *Cluster) WaitForLeader(timeout time.Duration, among ...*Node) *Node {
+	c.t.Helper()
+
+	if len(among) == 0 {
+		among = c.Nodes
+	}
+	var leader *Node
+	c.eventually(timeout, func() bool {
+		for _, node := range among {
+			if node.Store != nil && node.Store.IsLeader() {
+				leader = node
+				return true
+			}
+		}
+		return false
+	}, "no leader elected")
+	return leader
+}
+
+// Partition cuts the given nodes off from the rest of the cluster. The
+// isolated nodes can still talk to each other.
+func (c *Cluster) Partition(isolated ...*Node) {
+	inside := make(map[*Node]bool)
+	for _, node := range isolated {
+		inside[node] = true
+	}
+	for _, a := range c.Nodes {
+		for _, b := range c.Nodes {
+			if inside[a] != inside[b] {
+				a.Transport.Disconnect(b.Addr)
+			}
+		}
+	}
+}
+
+// Heal reconnects every node to every other node
+func (c *Cluster) Heal() {
+	c.connectAll()
+}
+
+func (c *Cluster) connectAll() {
+	for _, a := range c.Nodes {
+		for _, b := range c.Nodes {
+			if a != b {
+				a.Transport.Connect(b.Addr, b.Transport)
+			}
+		}
+	}
+}
+
+// AssertConverged waits until every running node has applied the same index
+// and holds identical FSM state
+func (c *Cluster) AssertConverged(timeout time.Duration) {
+	c.t.Helper()
+
+	var diverged string
+	ok := c.poll(timeout, func() bool {
+		diverged = ""
+		var (
+			first      *Node
+			firstData  map[string]string
+			firstIndex uint64
+		)
+		for _, node := range c.Nodes {
+			if node.Store == nil {
+				continue
+			}
+			data, index := node.Store.State()
+			if first == nil {
+				first, firstData, firstIndex = node, data, index
+				continue
+			}
+			if index != firstIndex || !reflect.DeepEqual(data, firstData) {
+				diverged = fmt.Sprintf("%s (index %d, %d keys) differs from %s (index %d, %d keys)",
+					node.ID, index, len(data), first.ID, firstIndex, len(firstData))
+				return false
+			}
+		}
+		return true
+	})
+	if !ok {
+		c.t.Fatalf("FSMs did not converge within %s: %s", timeout, diverged)
+	}
+}
+
+// eventually polls cond until it holds, failing the test after timeout
+func (c *Cluster) eventually(timeout time.Duration, cond func() bool, msg string) {
+	c.t.Helper()
+
+	if !c.poll(timeout, cond) {
+		c.t.Fatalf("%s within %s", msg, timeout)
+	}
+}
+
+// poll checks cond every 50ms until it holds or timeout elapses
+func (c *Cluster) poll(timeout time.Duration, cond func() bool) bool {
+	deadline := time.Now().Add(timeout)
+	for {
+		if cond() {
+			return true
+		}
+		if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
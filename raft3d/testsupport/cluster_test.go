@@ -0,0 +1,114 @@
+package testsupport
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"raft3d/raft"
+)
+
+func TestClusterReplicatesLeaderWritesToFollowers(t *testing.T) {
+	c := NewCluster(t, 3)
+	leader := c.WaitForLeader(10 * time.Second)
+
+	if err := leader.Store.Set("printer_p1", `{"id":"p1","name":"Prusa"}`); err != nil {
+		t.Fatalf("write on leader %s: %s", leader.ID, err)
+	}
+	index := leader.Store.AppliedIndex()
+
+	followers := c.Followers()
+	if len(followers) != 2 {
+		t.Fatalf("got %d followers, want 2", len(followers))
+	}
+	for _, follower := range followers {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		err := follower.Store.WaitForIndex(ctx, index)
+		cancel()
+		if err != nil {
+			t.Fatalf("%s did not apply index %d: %s", follower.ID, index, err)
+		}
+		value, err := follower.Store.Get("printer_p1")
+		if err != nil {
+			t.Fatalf("read on follower %s: %s", follower.ID, err)
+		}
+		if value != `{"id":"p1","name":"Prusa"}` {
+			t.Fatalf("follower %s read %s", follower.ID, value)
+		}
+	}
+	c.AssertConverged(5 * time.Second)
+}
+
+func TestClusterElectsNewLeaderAfterPartition(t *testing.T) {
+	c := NewCluster(t, 3)
+	old := c.WaitForLeader(10 * time.Second)
+
+	c.Partition(old)
+	var rest []*Node
+	for _, node := range c.Nodes {
+		if node != old {
+			rest = append(rest, node)
+		}
+	}
+	leader := c.WaitForLeader(10*time.Second, rest...)
+	if err := leader.Store.Set("filament_f1", `{"id":"f1"}`); err != nil {
+		t.Fatalf("write on new leader %s: %s", leader.ID, err)
+	}
+
+	c.Heal()
+	c.AssertConverged(10 * time.Second)
+}
+
+func TestClusterFollowerCatchesUpAfterPausedApply(t *testing.T) {
+	c := NewChaosCluster(t, 3)
+	leader := c.WaitForLeader(10 * time.Second)
+	followers := c.Followers()
+	paused, other := followers[0], followers[1]
+	paused.Chaos.PauseApply()
+	t.Cleanup(paused.Chaos.Reset)
+
+	// The leader and the other follower still make a quorum
+	if err := leader.Store.Set("printer_p1", `{"id":"p1"}`); err != nil {
+		t.Fatalf("write with %s paused: %s", paused.ID, err)
+	}
+	index := leader.Store.AppliedIndex()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := other.Store.WaitForIndex(ctx, index); err != nil {
+		t.Fatalf("%s did not apply index %d: %s", other.ID, index, err)
+	}
+
+	time.Sleep(300 * time.Millisecond)
+	if _, err := paused.Store.Get("printer_p1"); err == nil {
+		t.Fatalf("%s applied a write while paused", paused.ID)
+	}
+
+	paused.Chaos.ResumeApply()
+	c.AssertConverged(5 * time.Second)
+	if _, err := paused.Store.Get("printer_p1"); err != nil {
+		t.Fatalf("%s did not catch up after resuming: %s", paused.ID, err)
+	}
+}
+
+func TestClusterIsolatedFollowerTurnsReadOnly(t *testing.T) {
+	c := NewCluster(t, 3)
+	leader := c.WaitForLeader(10 * time.Second)
+	isolated := c.Followers()[0]
+	if err := isolated.Store.SetQuorumLossThreshold(500 * time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+
+	c.Partition(isolated)
+	c.eventually(10*time.Second, func() bool { return isolated.Store.QuorumStatus().Lost }, "isolated follower did not notice quorum loss")
+	if status := leader.Store.QuorumStatus(); status.Lost {
+		t.Fatalf("leader %s lost quorum with a majority: %+v", leader.ID, status)
+	}
+	if err := isolated.Store.Set("printer_p1", `{"id":"p1"}`); !errors.Is(err, raft.ErrQuorumLost) {
+		t.Fatalf("write on %s without quorum: %v", isolated.ID, err)
+	}
+
+	c.Heal()
+	c.eventually(10*time.Second, func() bool { return !isolated.Store.QuorumStatus().Lost }, "quorum not restored after healing")
+	c.AssertConverged(10 * time.Second)
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+
+	"raft3d/raft"
+)
+
+// runVerify implements the "verify" subcommand, an offline check of a node's
+// data directory. It returns the process exit code.
+func runVerify(args []string) int {
+	fs := flag.NewFlagSet("verify", flag.ExitOnError)
+	dataDir := fs.String("data-dir", "", "Node data directory containing raft.db (e.g. data/node1)")
+	asJSON := fs.Bool("json", false, "Print the report as JSON")
+	fs.Parse(args)
+
+	if *dataDir == "" {
+		fmt.Fprintln(os.Stderr, "verify: --data-dir is required")
+		return 2
+	}
+
+	report, err := raft.Verify(*dataDir)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "verify: %s\n", err)
+		return 2
+	}
+
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(report)
+	} else {
+		printVerifyReport(report)
+	}
+
+	if !report.Healthy() {
+		return 1
+	}
+	return 0
+}
+
+// printVerifyReport writes a human-readable verification report to stdout
+func printVerifyReport(report *raft.VerifyReport) {
+	fmt.Printf("Data directory: %s\n", report.DataDir)
+	fmt.Printf("Log:            indexes %d-%d (%d entries read), last term %d\n",
+		report.FirstIndex, report.LastIndex, report.EntriesRead, report.LastTerm)
+	fmt.Printf("Stable store:   current term %d\n", report.CurrentTerm)
+	if report.SnapshotIndex > 0 {
+		fmt.Printf("Snapshot:       index %d, term %d\n", report.SnapshotIndex, report.SnapshotTerm)
+	} else {
+		fmt.Println("Snapshot:       none")
+	}
+
+	if len(report.Issues) == 0 {
+		fmt.Println("\nNo problems found")
+		return
+	}
+
+	fmt.Printf("\n%d problem(s) found:\n", len(report.Issues))
+	for _, issue := range report.Issues {
+		if issue.Index > 0 {
+			fmt.Printf("  [%s] index %d: %s\n", issue.Severity, issue.Index, issue.Problem)
+		} else {
+			fmt.Printf("  [%s] %s\n", issue.Severity, issue.Problem)
+		}
+		fmt.Printf("      repair: %s\n", issue.Repair)
+	}
+}
@@ -0,0 +1,75 @@
+// Package version identifies the build a node runs. Release builds set
+// Version and Commit with the linker:
+//
+//	go build -ldflags "-X raft3d/version.Version=1.4.0 -X raft3d/version.Commit=$(git rev-parse HEAD)"
+//
+// Other builds fall back to what the Go toolchain embedded.
+package version
+
+import (
+	"runtime"
+	"runtime/debug"
+)
+
+// Set with -ldflags -X
+var (
+	Version = ""
+	Commit  = ""
+)
+
+// Info describes a build
+type Info struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit,omitempty"`
+	Modified  bool   `json:"modified,omitempty"` // built from a tree with uncommitted changes
+	BuiltAt   string `json:"built_at,omitempty"` // time of the commit, RFC 3339
+	GoVersion string `json:"go_version"`
+	Platform  string `json:"platform"` // GOOS/GOARCH
+}
+
+// Get returns this build's version
+func Get() Info {
+	info := Info{
+		Version:   Version,
+		Commit:    Commit,
+		GoVersion: runtime.Version(),
+		Platform:  runtime.GOOS + "/" + runtime.GOARCH,
+	}
+	if build, ok := debug.ReadBuildInfo(); ok {
+		if info.Version == "" && build.Main.Version != "(devel)" {
+			info.Version = build.Main.Version
+		}
+		for _, setting := range build.Settings {
+			switch setting.Key {
+			case "vcs.revision":
+				if info.Commit == "" {
+					info.Commit = setting.Value
+				}
+			case "vcs.time":
+				info.BuiltAt = setting.Value
+			case "vcs.modified":
+				info.Modified = setting.Value == "true"
+			}
+		}
+	}
+	if info.Version == "" {
+		info.Version = "dev"
+	}
+	return info
+}
+
+// String formats the version for banners and logs, e.g. 1.4.0 (3f2a9c1d,
+// go1.21.5 linux/amd64)
+func (i Info) String() string {
+	commit := i.Commit
+	if len(commit) > 8 {
+		commit = commit[:8]
+	}
+	if i.Modified {
+		commit += "-dirty"
+	}
+	if commit == "" {
+		return i.Version + " (" + i.GoVersion + " " + i.Platform + ")"
+	}
+	return i.Version + " (" + commit + ", " + i.GoVersion + " " + i.Platform + ")"
+}